@@ -0,0 +1,190 @@
+package hwio
+
+// Analog multiplexers, such as the CD74HC4067, which connect one of 16 channels to a common pin selected by 4 GPIO
+// pins, so one analog input can read 16 sensors. NewAnalogMux adds a pin to the pin map for each channel, named
+// after the mux and the channel as printed on the chip, e.g. "mux.C3", and registers the mux as a module. AnalogRead
+// on one of these pins selects its channel, waits for the input to settle, and reads the analog input:
+//
+//	mux, e := hwio.NewAnalogMux("mux", hwio.AnalogMuxConfig{Select: hwio.PinList{s0, s1, s2, s3}, Input: ain0})
+//	...
+//	soil, e := hwio.GetPin("mux.C3")
+//	value, e := hwio.AnalogRead(soil)
+//
+// Known issues:
+// - the pins are only added to the default board's pin map, and are removed if the driver is changed
+// - the channel isn't changed while a read is in progress, so reads of a mux's channels are one at a time
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How long to wait after changing channel by default. The switch itself takes nanoseconds, but the input, with the
+// capacitance of an ADC's sample and hold, takes a few microseconds to charge through the switch's resistance.
+const analogMuxDefaultSettle = 10 * time.Microsecond
+
+type AnalogMuxConfig struct {
+	// The GPIO pins driving the select inputs, S0 first. 4 select 16 channels, as on a CD74HC4067, and 3 select 8,
+	// as on a CD4051.
+	Select PinList
+
+	// The analog input the mux's common pin (SIG on a CD74HC4067) is wired to.
+	Input Pin
+
+	// The pin driving the mux's active-low enable input (EN), if UseEnable is true. Otherwise EN should be tied low.
+	Enable    Pin
+	UseEnable bool
+
+	// How long to wait after changing channel before reading the input. Defaults to 10µs. Inputs from sources with a
+	// high impedance, e.g. over 10kΩ, need longer.
+	Settle time.Duration
+}
+
+// An analog multiplexer, which is a module reading the analog pins of its channels.
+type AnalogMux struct {
+	name   string
+	config AnalogMuxConfig
+
+	// the pins of the channels, in order, which are numbered consecutively
+	pins PinList
+
+	// guards channel, and is held while a channel is read
+	sync.Mutex
+	channel int
+}
+
+// Create an analog multiplexer, setting its select pins up as outputs, registering it as a module called name, and
+// adding a pin for each channel to the pin map.
+func NewAnalogMux(name string, config AnalogMuxConfig) (*AnalogMux, error) {
+	if len(config.Select) == 0 || len(config.Select) > 4 {
+		return nil, errors.New("an analog mux needs 1 to 4 select pins")
+	}
+	if config.Settle <= 0 {
+		config.Settle = analogMuxDefaultSettle
+	}
+	if definedPins == nil {
+		return nil, errors.New("an analog mux needs the driver to be set")
+	}
+
+	mux := &AnalogMux{name: name, config: config, channel: -1}
+	if e := mux.Enable(); e != nil {
+		return nil, e
+	}
+	if e := RegisterModule(name, mux); e != nil {
+		mux.Disable()
+		return nil, e
+	}
+
+	// the channels' pins follow the driver's
+	first := Pin(0)
+	for pin := range definedPins {
+		if pin >= first {
+			first = pin + 1
+		}
+	}
+	for channel := 0; channel < 1<<uint(len(config.Select)); channel++ {
+		pin := first + Pin(channel)
+		definedPins.Add(pin, []string{fmt.Sprintf("%s.C%d", name, channel)}, []string{name})
+		mux.pins = append(mux.pins, pin)
+	}
+	return mux, nil
+}
+
+// Return the pins of the mux's channels, in order.
+func (mux *AnalogMux) Pins() PinList {
+	return mux.pins
+}
+
+// Return the pin of a channel, from 0.
+func (mux *AnalogMux) Channel(channel int) (Pin, error) {
+	if channel < 0 || channel >= len(mux.pins) {
+		return 0, fmt.Errorf("analog mux '%s' has no channel %d", mux.name, channel)
+	}
+	return mux.pins[channel], nil
+}
+
+func (mux *AnalogMux) SetOptions(options map[string]interface{}) error {
+	return nil
+}
+
+// Set up the select pins, and the enable pin if there is one, enabling the mux.
+func (mux *AnalogMux) Enable() error {
+	mux.Lock()
+	defer mux.Unlock()
+	mux.channel = -1
+	for _, pin := range mux.config.Select {
+		if e := PinModeOutputInit(pin, Low); e != nil {
+			return e
+		}
+	}
+	if mux.config.UseEnable {
+		return PinModeOutputInit(mux.config.Enable, Low)
+	}
+	return nil
+}
+
+// Disable the mux if it has an enable pin, and close its pins.
+func (mux *AnalogMux) Disable() error {
+	mux.Lock()
+	defer mux.Unlock()
+	if mux.config.UseEnable {
+		DigitalWrite(mux.config.Enable, High)
+		ClosePin(mux.config.Enable)
+	}
+	for _, pin := range mux.config.Select {
+		ClosePin(pin)
+	}
+	return nil
+}
+
+func (mux *AnalogMux) GetName() string {
+	return mux.name
+}
+
+// Disable the mux, unregister it and remove its channels' pins from the pin map.
+func (mux *AnalogMux) Close() error {
+	mux.Disable()
+	for _, pin := range mux.pins {
+		if pd := definedPins[pin]; pd != nil && pd.modules[0] == mux.name {
+			delete(definedPins, pin)
+		}
+	}
+	return UnregisterModule(mux.name)
+}
+
+// Select the channel of a pin, waiting for the input to settle if it changed, and read the analog input.
+func (mux *AnalogMux) AnalogRead(pin Pin) (int, error) {
+	channel := int(pin) - int(mux.pins[0])
+	if channel < 0 || channel >= len(mux.pins) {
+		return 0, fmt.Errorf("pin %d is not a channel of analog mux '%s'", pin, mux.name)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if channel != mux.channel {
+		if e := mux.selectChannel(channel); e != nil {
+			return 0, e
+		}
+		spinUntil(MonotonicNow() + mux.config.Settle)
+	}
+	return AnalogRead(mux.config.Input)
+}
+
+// Set the select pins for a channel, only writing those that change. The mux must be locked.
+func (mux *AnalogMux) selectChannel(channel int) error {
+	for i, pin := range mux.config.Select {
+		bit := (channel >> uint(i)) & 1
+		if mux.channel >= 0 && (mux.channel>>uint(i))&1 == bit {
+			continue
+		}
+		if e := DigitalWrite(pin, bit); e != nil {
+			// the select pins are in an unknown state, so all are written next time
+			mux.channel = -1
+			return e
+		}
+	}
+	mux.channel = channel
+	return nil
+}
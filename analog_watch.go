@@ -0,0 +1,130 @@
+package hwio
+
+// Watching analog pins for a value crossing a threshold, for battery monitors, light sensors, tank levels and the
+// like. The pin is sampled in the background, and an event is sent each time the value crosses the threshold. A
+// hysteresis band below the threshold stops a noisy value that sits near the threshold from sending a stream of
+// events: once above the threshold, the value must fall below threshold-hysteresis to count as below it again.
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// An event from WatchAnalog: the value crossing the threshold upwards (Rising) or downwards, with the value that
+// crossed it and when it was sampled.
+type AnalogEvent struct {
+	Pin    Pin
+	Rising bool
+	Value  int
+	Time   time.Time
+}
+
+// An analog pin being watched, and the channels to stop its goroutine and wait for it.
+type analogWatch struct {
+	stop chan bool
+	done chan bool
+}
+
+var analogWatches = make(map[Pin]*analogWatch)
+
+// Tracks which side of a threshold a value is on, with hysteresis.
+type analogCrossing struct {
+	threshold  int
+	hysteresis int
+
+	above bool
+	known bool
+}
+
+// Take a new value, returning whether it crossed the threshold, and which way. The first value only sets which
+// side the value starts on; first is true for it, and rising says which side that is.
+func (c *analogCrossing) update(value int) (crossed bool, rising bool, first bool) {
+	if !c.known {
+		c.above, c.known = value > c.threshold, true
+		return false, c.above, true
+	}
+	if !c.above && value > c.threshold {
+		c.above = true
+		return true, true, false
+	}
+	if c.above && value < c.threshold-c.hysteresis {
+		c.above = false
+		return true, false, false
+	}
+	return false, c.above, false
+}
+
+// Sample an analog pin every interval, sending an event when its value goes above threshold, or below
+// threshold-hysteresis. The first event gives the side of the threshold the value starts on, from the first sample,
+// so the state is known without waiting for a crossing. Events are dropped if the channel is full. A pin can only
+// be watched once at a time; StopWatchingAnalog stops watching it and closes the channel.
+func WatchAnalog(pin Pin, threshold int, hysteresis int, interval time.Duration) (<-chan AnalogEvent, error) {
+	if interval <= 0 {
+		return nil, errors.New("WatchAnalog needs an interval to sample at")
+	}
+	if hysteresis < 0 {
+		return nil, errors.New("WatchAnalog cannot have negative hysteresis")
+	}
+	if analogWatches[pin] != nil {
+		return nil, fmt.Errorf("pin %d is already being watched", pin)
+	}
+
+	// check the pin can be read before going into the background
+	_, e := AnalogRead(pin)
+	if e != nil {
+		return nil, e
+	}
+
+	events := make(chan AnalogEvent, 16)
+	w := &analogWatch{stop: make(chan bool), done: make(chan bool)}
+	analogWatches[pin] = w
+	go w.run(pin, &analogCrossing{threshold: threshold, hysteresis: hysteresis}, interval, events)
+	return events, nil
+}
+
+// Stop watching an analog pin, closing the channel returned by WatchAnalog.
+func StopWatchingAnalog(pin Pin) error {
+	w := analogWatches[pin]
+	if w == nil {
+		return fmt.Errorf("pin %d is not being watched", pin)
+	}
+	delete(analogWatches, pin)
+	close(w.stop)
+	<-w.done
+	return nil
+}
+
+func stopAllAnalogWatches() {
+	for pin := range analogWatches {
+		StopWatchingAnalog(pin)
+	}
+}
+
+func (w *analogWatch) run(pin Pin, crossing *analogCrossing, interval time.Duration, events chan AnalogEvent) {
+	defer close(w.done)
+	defer close(events)
+
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a failed read is skipped, as a one-off error reading an ADC is not unusual
+		value, e := AnalogRead(pin)
+		if e == nil {
+			crossed, rising, first := crossing.update(value)
+			if crossed || first {
+				select {
+				case events <- AnalogEvent{Pin: pin, Rising: rising, Value: value, Time: clock.Now()}:
+				default:
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-w.stop:
+			return
+		}
+	}
+}
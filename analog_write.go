@@ -0,0 +1,261 @@
+package hwio
+
+// AnalogWrite, after the Arduino function of the same name: write a value of 0 to 255 to a pin as a PWM duty cycle.
+// Pins that a PWM module can drive use hardware PWM. Other GPIO pins use software PWM, which toggles the pin from a
+// goroutine; this is fine for dimming LEDs, but its timing jitters with system load, so it's not suitable for
+// servos or motor control.
+
+import (
+	"fmt"
+	"time"
+)
+
+// The default AnalogWrite period, 490Hz, as on most Arduino pins.
+const defaultAnalogWritePeriod = int64(time.Second) / 490
+
+// The shortest period used for software PWM. Shorter periods can't be timed with any accuracy by a goroutine.
+const minSoftPWMPeriod = int64(10 * time.Millisecond)
+
+// The PWM period used by AnalogWrite, in nanoseconds.
+var analogWritePeriod = defaultAnalogWritePeriod
+
+// Drivers whose PWM hardware needs a different mapping of AnalogWrite values to period and duty implement this
+// interface, e.g. where a pin's PWM output is inverted, or its controller only supports certain periods. Without
+// it, the period is set by SetAnalogWriteFrequency and the duty is proportional to the value.
+type AnalogWriteDriver interface {
+	// Return the period and duty, in nanoseconds, for an AnalogWrite value of 0 to 255 on a hardware PWM pin.
+	AnalogWriteDuty(pin Pin, value int) (period int64, duty int64)
+}
+
+// How a pin is being driven by AnalogWrite: by a PWM module, or by software PWM.
+type analogWritePin struct {
+	pwm  PWMModule
+	soft *softPWM
+
+	// ramps the value, if the pin has a duty slew limit
+	slew *dutySlew
+}
+
+var analogWritePins = make(map[Pin]*analogWritePin)
+
+// Set the PWM frequency AnalogWrite uses, in Hz. This applies to pins from their next AnalogWrite.
+func SetAnalogWriteFrequency(hz int) error {
+	if hz <= 0 {
+		return fmt.Errorf("AnalogWrite frequency must be positive, got %d", hz)
+	}
+	analogWritePeriod = int64(time.Second) / int64(hz)
+	return nil
+}
+
+// Write a value of 0 to 255 to a pin as a PWM duty cycle: 0 is always low, 255 always high, and 127 high half the
+// time. The first write to a pin enables its PWM output, using hardware PWM if a PWM module can drive the pin, or
+// software PWM otherwise.
+func AnalogWrite(pin Pin, value int) error {
+	if value < 0 || value > 255 {
+		return fmt.Errorf("AnalogWrite value must be 0 to 255, got %d", value)
+	}
+
+	aw := analogWritePins[pin]
+	if aw == nil {
+		var e error
+		aw, e = startAnalogWrite(pin)
+		if e != nil {
+			return e
+		}
+		analogWritePins[pin] = aw
+	}
+
+	if aw.slew != nil {
+		aw.slew.set(value)
+		return nil
+	}
+	return aw.write(pin, value)
+}
+
+// Set the PWM output of a pin to a value.
+func (aw *analogWritePin) write(pin Pin, value int) error {
+	if aw.soft != nil {
+		period := analogWritePeriod
+		if period < minSoftPWMPeriod {
+			period = minSoftPWMPeriod
+		}
+		aw.soft.set(period, period*int64(value)/255)
+		return nil
+	}
+
+	period, duty := analogWriteDuty(pin, value)
+	e := aw.pwm.SetPeriod(pin, period)
+	if e != nil {
+		return e
+	}
+	return aw.pwm.SetDuty(pin, duty)
+}
+
+// Stop AnalogWrite output on a pin. A hardware PWM pin is disabled, and a software PWM pin is left low.
+func StopAnalogWrite(pin Pin) error {
+	aw := analogWritePins[pin]
+	if aw == nil {
+		return fmt.Errorf("pin %d is not being written by AnalogWrite", pin)
+	}
+	delete(analogWritePins, pin)
+
+	if aw.slew != nil {
+		aw.slew.stop()
+	}
+	if aw.soft != nil {
+		aw.soft.stop()
+		return DigitalWrite(pin, Low)
+	}
+	return aw.pwm.EnablePin(pin, false)
+}
+
+// Stop AnalogWrite on all pins, as part of CloseAll.
+func stopAllAnalogWrites() {
+	for pin := range analogWritePins {
+		StopAnalogWrite(pin)
+	}
+}
+
+// Work out how to drive a pin, and enable it, ramping its value if it has a duty slew limit.
+func startAnalogWrite(pin Pin) (*analogWritePin, error) {
+	aw, e := enableAnalogWrite(pin)
+	if e != nil {
+		return nil, e
+	}
+	if slew := dutySlewLimit(pin); slew > 0 {
+		aw.slew = newDutySlew(slew, func(value int) {
+			aw.write(pin, value)
+		})
+	}
+	return aw, nil
+}
+
+func enableAnalogWrite(pin Pin) (*analogWritePin, error) {
+	pd := definedPins[pin]
+	if pd == nil {
+		return nil, fmt.Errorf("pin %d is not known to the driver", pin)
+	}
+
+	modules := GetModules()
+	for _, name := range pd.modules {
+		pwm, ok := modules[name].(PWMModule)
+		if !ok {
+			continue
+		}
+		e := pwm.Enable()
+		if e != nil {
+			return nil, e
+		}
+		e = pwm.EnablePin(pin, true)
+		if e != nil {
+			return nil, e
+		}
+		return &analogWritePin{pwm: pwm}, nil
+	}
+
+	if !pd.usedBy("gpio") {
+		return nil, fmt.Errorf("pin %d cannot be used for PWM or GPIO", pin)
+	}
+	e := PinModeOutputInit(pin, Low)
+	if e != nil {
+		return nil, e
+	}
+	return &analogWritePin{soft: newSoftPWM(pin)}, nil
+}
+
+// Map a value to period and duty for hardware PWM, using the driver's mapping if it has one.
+func analogWriteDuty(pin Pin, value int) (int64, int64) {
+	if m, ok := driver.(AnalogWriteDriver); ok {
+		return m.AnalogWriteDuty(pin, value)
+	}
+	return analogWritePeriod, analogWritePeriod * int64(value) / 255
+}
+
+// Software PWM on a GPIO pin, timed by a goroutine, which writes the edges as critical IO. A duty of 0 or the whole
+// period just sets the pin, and the goroutine waits for the next change.
+type softPWM struct {
+	pin     Pin
+	clock   Clock
+	changes chan [2]int64
+	done    chan bool
+}
+
+func newSoftPWM(pin Pin) *softPWM {
+	s := &softPWM{pin: pin, clock: clock, changes: make(chan [2]int64, 1), done: make(chan bool)}
+	go s.run()
+	return s
+}
+
+// Set the period and duty. A pending change that hasn't been picked up yet is replaced.
+func (s *softPWM) set(period int64, duty int64) {
+	select {
+	case <-s.changes:
+	default:
+	}
+	s.changes <- [2]int64{period, duty}
+}
+
+// Stop the goroutine, and wait for it to finish.
+func (s *softPWM) stop() {
+	close(s.changes)
+	<-s.done
+}
+
+// Write an edge on the IO thread, ahead of bulk IO.
+func (s *softPWM) write(level int) {
+	runCriticalIO(func() error {
+		return DigitalWrite(s.pin, level)
+	})
+}
+
+func (s *softPWM) run() {
+	defer close(s.done)
+
+	timer := s.clock.NewTimer(0)
+	<-timer.C()
+	period, duty := int64(0), int64(0)
+
+	// wait for d, returning false if stopped. A change takes effect from the next period.
+	wait := func(d int64) bool {
+		timer.Reset(time.Duration(d))
+		for {
+			select {
+			case <-timer.C():
+				return true
+			case c, ok := <-s.changes:
+				if !ok {
+					timer.Stop()
+					return false
+				}
+				period, duty = c[0], c[1]
+			}
+		}
+	}
+
+	for {
+		if duty <= 0 || duty >= period {
+			level := Low
+			if duty > 0 {
+				level = High
+			}
+			s.write(level)
+
+			c, ok := <-s.changes
+			if !ok {
+				return
+			}
+			period, duty = c[0], c[1]
+			continue
+		}
+
+		on, off := duty, period-duty
+		s.write(High)
+		if !wait(on) {
+			return
+		}
+		s.write(Low)
+		if !wait(off) {
+			return
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package hwio
+
+// Experimental audio output, for beeps and voice prompts on devices without a sound card. Clips are 8 bit unsigned
+// mono PCM, as LoadWAV reads from a WAV file and Tone generates, and are played through an AudioOutput:
+//
+//   - PWMAudio plays through a PWM pin, setting the duty cycle to each sample in turn, from a goroutine locked to its
+//     thread. The pin needs a low pass RC filter, e.g. 270Ω and 33nF for a corner around 18kHz, and an amplifier to
+//     drive a speaker.
+//   - ALSAAudio plays through an ALSA device using aplay, which on a Raspberry Pi without a sound card is the PWM audio
+//     of the headphone jack, or of GPIO 18 and 19 with the audremap overlay.
+//
+//	clip, e := hwio.LoadWAV(file)
+//	audio, e := hwio.NewPWMAudio(pin, 0)
+//	player, e := audio.Play(clip)
+//	...
+//	e = player.Wait()
+//
+// Known issues:
+// - PWMAudio is soft real time: each sample is a write to the PWM module, which through sysfs takes tens of
+//   microseconds, so clips are best at 8kHz to 16kHz, and a sample that is due while the goroutine is delayed is
+//   skipped, which is heard as a click
+// - the PWM carrier must be well above the sample rate so the filter removes it; hardware that can't reach the default
+//   of 62.5kHz needs a lower one passed to NewPWMAudio, and a filter with a lower corner
+// - only PCM WAV files of 8 or 16 bits are read; stereo is mixed down to mono
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// The PWM carrier frequency used by default.
+const pwmAudioDefaultCarrier = 62500
+
+// A clip of audio: 8 bit unsigned mono samples, where 128 is silence.
+type AudioClip struct {
+	SampleRate int
+	Samples    []byte
+}
+
+// Return the length of the clip.
+func (clip *AudioClip) Duration() time.Duration {
+	if clip.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(len(clip.Samples)) * time.Second / time.Duration(clip.SampleRate)
+}
+
+// Generate a sine wave clip of a frequency for a time, at a sample rate, with a volume from 0 to 1. The tone is faded
+// in and out over a millisecond, so it starts and ends without a click.
+func Tone(hz float64, duration time.Duration, sampleRate int, volume float64) (*AudioClip, error) {
+	if hz <= 0 || sampleRate <= 0 || duration < 0 {
+		return nil, errors.New("tone needs a positive frequency and sample rate")
+	}
+	if hz >= float64(sampleRate)/2 {
+		return nil, fmt.Errorf("tone of %gHz is too high for a sample rate of %d", hz, sampleRate)
+	}
+	volume = math.Max(0, math.Min(1, volume))
+	n := int(duration * time.Duration(sampleRate) / time.Second)
+	fade := sampleRate / 1000
+	clip := &AudioClip{SampleRate: sampleRate, Samples: make([]byte, n)}
+	for i := range clip.Samples {
+		level := volume
+		if edge := math.Min(float64(i), float64(n-1-i)); edge < float64(fade) {
+			level *= edge / float64(fade)
+		}
+		clip.Samples[i] = byte(128 + math.Round(127*level*math.Sin(2*math.Pi*hz*float64(i)/float64(sampleRate))))
+	}
+	return clip, nil
+}
+
+// Read a clip from a WAV file of 8 or 16 bit PCM, mixing stereo down to mono.
+func LoadWAV(r io.Reader) (*AudioClip, error) {
+	b, e := ioutil.ReadAll(r)
+	if e != nil {
+		return nil, e
+	}
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	var channels, bits int
+	clip := &AudioClip{}
+	var data []byte
+	for chunk := b[12:]; len(chunk) >= 8; {
+		id := string(chunk[0:4])
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		if size > len(chunk)-8 {
+			return nil, fmt.Errorf("WAV chunk '%s' is truncated", id)
+		}
+		body := chunk[8 : 8+size]
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("WAV format chunk is too short")
+			}
+			if format := binary.LittleEndian.Uint16(body[0:2]); format != 1 {
+				return nil, fmt.Errorf("WAV format %d is not supported, only PCM", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			clip.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			data = body
+		}
+		// chunks are padded to an even size, though the padding of the last is sometimes left out
+		next := 8 + size + size%2
+		if next > len(chunk) {
+			break
+		}
+		chunk = chunk[next:]
+	}
+	if channels < 1 || clip.SampleRate <= 0 || data == nil {
+		return nil, errors.New("WAV file has no format or data")
+	}
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("WAV files of %d bits are not supported, only 8 or 16", bits)
+	}
+
+	frame := channels * bits / 8
+	clip.Samples = make([]byte, len(data)/frame)
+	for i := range clip.Samples {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			offset := i*frame + c*bits/8
+			if bits == 8 {
+				sum += int(data[offset]) - 128
+			} else {
+				sum += int(int16(binary.LittleEndian.Uint16(data[offset:]))) >> 8
+			}
+		}
+		clip.Samples[i] = byte(sum/channels + 128)
+	}
+	return clip, nil
+}
+
+// Something that plays audio clips.
+type AudioOutput interface {
+	// Start playing a clip, returning a generator that finishes when it has played, and can be stopped.
+	Play(clip *AudioClip) (*SignalGenerator, error)
+}
+
+// Audio played through a PWM pin.
+type PWMAudio struct {
+	pin    Pin
+	pwm    PWMModule
+	period time.Duration
+}
+
+// Create an audio output on a pin that a PWM module can drive, with a carrier frequency in Hz, or 0 for the default of
+// 62.5kHz.
+func NewPWMAudio(pin Pin, carrierHz float64) (*PWMAudio, error) {
+	if carrierHz < 0 {
+		return nil, errors.New("PWM audio carrier frequency can't be negative")
+	}
+	if carrierHz == 0 {
+		carrierHz = pwmAudioDefaultCarrier
+	}
+	pwm := findPWMModule(pin)
+	if pwm == nil {
+		return nil, fmt.Errorf("pin %d is not a PWM pin", pin)
+	}
+	return &PWMAudio{pin: pin, pwm: pwm, period: time.Duration(float64(time.Second) / carrierHz)}, nil
+}
+
+// Start playing a clip. The pin is held at half duty, silence, while playing, and disabled when the clip ends or is
+// stopped.
+func (a *PWMAudio) Play(clip *AudioClip) (*SignalGenerator, error) {
+	if clip.SampleRate <= 0 {
+		return nil, errors.New("audio clip needs a sample rate")
+	}
+	if carrier := float64(time.Second) / float64(a.period); carrier < 4*float64(clip.SampleRate) {
+		return nil, fmt.Errorf("PWM carrier of %.0fHz is too low for a sample rate of %d", carrier, clip.SampleRate)
+	}
+	if e := startPWM(a.pwm, a.pin, a.period, a.period/2); e != nil {
+		return nil, e
+	}
+
+	sampleTime := time.Second / time.Duration(clip.SampleRate)
+	return startSignal(func(g *SignalGenerator) error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		start := MonotonicNow()
+		for n := 0; n < len(clip.Samples); n++ {
+			if e := a.pwm.SetDuty(a.pin, int64(a.period)*int64(clip.Samples[n])/255); e != nil {
+				a.pwm.EnablePin(a.pin, false)
+				return e
+			}
+			// skip the samples that are already due, so a delay doesn't slow the clip down
+			next := start + time.Duration(n+1)*sampleTime
+			if late := MonotonicNow() - next; late > sampleTime {
+				n += int(late / sampleTime)
+				next = start + time.Duration(n+1)*sampleTime
+			}
+			if !g.waitUntil(next) {
+				break
+			}
+		}
+		a.pwm.SetDuty(a.pin, int64(a.period/2))
+		return a.pwm.EnablePin(a.pin, false)
+	}), nil
+}
+
+// Audio played through an ALSA device with aplay.
+type ALSAAudio struct {
+	device string
+}
+
+// The command playing raw 8 bit samples from its standard input. This is a variable so tests can replace it.
+var alsaPlayCommand = func(device string, sampleRate int) *exec.Cmd {
+	return exec.Command("aplay", "-q", "-D", device, "-t", "raw", "-f", "U8", "-c", "1", "-r", strconv.Itoa(sampleRate))
+}
+
+// Create an audio output on an ALSA device, e.g. "default" or "hw:0,0". aplay, from alsa-utils, must be installed.
+func NewALSAAudio(device string) *ALSAAudio {
+	if device == "" {
+		device = "default"
+	}
+	return &ALSAAudio{device: device}
+}
+
+// Start playing a clip.
+func (a *ALSAAudio) Play(clip *AudioClip) (*SignalGenerator, error) {
+	if clip.SampleRate <= 0 {
+		return nil, errors.New("audio clip needs a sample rate")
+	}
+	cmd := alsaPlayCommand(a.device, clip.SampleRate)
+	cmd.Stdin = bytes.NewReader(clip.Samples)
+	if e := cmd.Start(); e != nil {
+		return nil, fmt.Errorf("could not start aplay: %s", e)
+	}
+
+	return startSignal(func(g *SignalGenerator) error {
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+		select {
+		case e := <-done:
+			if e != nil {
+				return fmt.Errorf("aplay failed: %s", e)
+			}
+		case <-g.stop:
+			cmd.Process.Kill()
+			<-done
+		}
+		return nil
+	}), nil
+}
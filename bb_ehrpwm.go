@@ -0,0 +1,108 @@
+// Dead band control for the AM335x enhanced high resolution PWM (EHRPWM) on BeagleBone Black. The pwm_test sysfs
+// interface only sets period, duty and polarity, so the dead band generator is set through the EHRPWM registers
+// via /dev/mem, which requires root. The PWM module must be enabled first, so the kernel has the PWM subsystem
+// clocked.
+//
+// The dead band generator takes output A as the source for both outputs: A is delayed on its rising edge, and B
+// is its inverse delayed on its falling edge, so there is a gap around each edge where both are low.
+
+package hwio
+
+// References:
+// - AM335x Technical Reference Manual, section 15.2 "Enhanced PWM (ePWM) Module".
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// offsets of the 16 bit registers within the EHRPWM block
+	ehrpwmTBCTL = 0x00
+	ehrpwmDBCTL = 0x1e
+	ehrpwmDBRED = 0x20
+	ehrpwmDBFED = 0x22
+
+	// DBCTL with both edges delayed (OUT_MODE 3), B inverted (POLSEL 2) and A as the source of both (IN_MODE 0)
+	ehrpwmDBCTLComplementary = 0x3 | 0x2<<2
+
+	// the largest dead band delay, in time base clocks
+	ehrpwmMaxDeadBand = 0x3ff
+
+	// SYSCLKOUT, from which the time base clock is divided
+	ehrpwmSysClock = 100000000
+)
+
+// The address of the EHRPWM registers of each PWM module on the BeagleBone Black.
+var ehrpwmBase = map[string]int64{
+	"pwm0": 0x48300200,
+	"pwm1": 0x48302200,
+	"pwm2": 0x48304200,
+}
+
+func ehrpwmRegister(regs []byte, offset int) *uint16 {
+	return (*uint16)(unsafe.Pointer(&regs[offset]))
+}
+
+// Return the frequency of the time base clock in Hz, from the dividers in TBCTL set by the kernel for the period.
+func ehrpwmTimeBaseClock(regs []byte) int64 {
+	tbctl := *ehrpwmRegister(regs, ehrpwmTBCTL)
+	clkdiv := int64(1) << ((tbctl >> 10) & 0x7)
+	hspclkdiv := int64(1)
+	if h := int64((tbctl >> 7) & 0x7); h > 0 {
+		hspclkdiv = h * 2
+	}
+	return ehrpwmSysClock / (clkdiv * hspclkdiv)
+}
+
+// Set the dead band generator to make complementary outputs with the given dead time. The dead time is rounded up
+// to a whole number of time base clocks, so it is never shorter than asked for.
+func ehrpwmSetDeadBand(regs []byte, deadTime int64) error {
+	if deadTime < 0 {
+		return fmt.Errorf("dead time must not be negative, got %dns", deadTime)
+	}
+
+	clock := ehrpwmTimeBaseClock(regs)
+	counts := (deadTime*clock + 999999999) / 1000000000
+	if counts > ehrpwmMaxDeadBand {
+		return fmt.Errorf("dead time %dns is too long for the PWM period, the maximum is %dns", deadTime, ehrpwmMaxDeadBand*1000000000/clock)
+	}
+
+	*ehrpwmRegister(regs, ehrpwmDBRED) = uint16(counts)
+	*ehrpwmRegister(regs, ehrpwmDBFED) = uint16(counts)
+	*ehrpwmRegister(regs, ehrpwmDBCTL) = ehrpwmDBCTLComplementary
+	return nil
+}
+
+// Bypass the dead band generator, so A and B are independent again.
+func ehrpwmClearDeadBand(regs []byte) error {
+	*ehrpwmRegister(regs, ehrpwmDBCTL) = 0
+	return nil
+}
+
+// Map the EHRPWM registers of a PWM module and call f with them.
+func ehrpwmWithRegisters(module string, f func(regs []byte) error) error {
+	base, ok := ehrpwmBase[module]
+	if !ok {
+		return fmt.Errorf("module '%s' is not an EHRPWM module", module)
+	}
+
+	file, e := os.OpenFile("/dev/mem", os.O_RDWR|os.O_SYNC, 0)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+
+	// the mapping must start on a page boundary
+	page := int64(os.Getpagesize())
+	start := base &^ (page - 1)
+	mem, e := syscall.Mmap(int(file.Fd()), start, int(page), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		return e
+	}
+	defer syscall.Munmap(mem)
+
+	return f(mem[base-start:])
+}
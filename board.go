@@ -0,0 +1,103 @@
+// Contains helpers for identifying the board we're running on. The device tree (/proc/device-tree/model and
+// compatible) is used where present, as it is reliable across 32 and 64-bit kernels. /proc/cpuinfo is used as a
+// fallback; arm64 kernels generally omit its "Hardware" field.
+
+package hwio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Location of the device tree. This is a variable so tests can point it elsewhere.
+var deviceTreePath = "/proc/device-tree"
+
+var boardInfoLoaded bool
+var boardModel string
+var boardCompatible []string
+var boardRevisionCode string
+
+// Return the board model, e.g. "Raspberry Pi 3 Model B Rev 1.2" or "Hardkernel ODROID-C2". This is the device tree
+// model if available, otherwise the "Model" or "Hardware" property from /proc/cpuinfo.
+func BoardModel() string {
+	loadBoardInfo()
+	if boardModel != "" {
+		return boardModel
+	}
+	if m := cpuInfoSystem("Model"); m != "" {
+		return m
+	}
+	return cpuInfoSystem("Hardware")
+}
+
+// Return the device tree compatible strings of the board, most specific first, e.g.
+// ["hardkernel,odroid-c2", "amlogic,meson-gxbb"]. Returns nil if there is no device tree.
+func BoardCompatible() []string {
+	loadBoardInfo()
+	return boardCompatible
+}
+
+// Returns true if any of the board's device tree compatible strings is one of names.
+func BoardIsCompatible(names ...string) bool {
+	for _, c := range BoardCompatible() {
+		for _, name := range names {
+			if c == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the board revision code as a hex string, e.g. "a02082" on a Raspberry Pi 3. This comes from the device
+// tree (/proc/device-tree/system/linux,revision) if present, otherwise the "Revision" property from /proc/cpuinfo.
+func BoardRevisionCode() string {
+	loadBoardInfo()
+	if boardRevisionCode != "" {
+		return boardRevisionCode
+	}
+	return cpuInfoSystem("Revision")
+}
+
+func loadBoardInfo() {
+	if boardInfoLoaded {
+		return
+	}
+	boardInfoLoaded = true
+
+	// device tree strings are NUL terminated, and compatible is a NUL separated list
+	if b, e := ioutil.ReadFile(deviceTreePath + "/model"); e == nil {
+		boardModel = strings.TrimRight(string(b), "\x00\n")
+	}
+
+	if b, e := ioutil.ReadFile(deviceTreePath + "/compatible"); e == nil {
+		for _, s := range strings.Split(string(b), "\x00") {
+			if s != "" {
+				boardCompatible = append(boardCompatible, s)
+			}
+		}
+	}
+
+	// the revision is a big-endian 32-bit cell
+	if b, e := ioutil.ReadFile(deviceTreePath + "/system/linux,revision"); e == nil && len(b) == 4 {
+		boardRevisionCode = fmt.Sprintf("%04x", binary.BigEndian.Uint32(b))
+	}
+}
+
+// Look up a property from the system-wide section of /proc/cpuinfo. Properties such as "Hardware", "Revision" and
+// "Model" are listed after the per-processor properties, so CpuInfo associates them with the last processor. This
+// finds them whichever processor that is.
+func cpuInfoSystem(property string) string {
+	if cpuInfo == nil {
+		loadCpuInfo()
+	}
+
+	for key, value := range cpuInfo {
+		if strings.HasSuffix(key, ":"+property) {
+			return value
+		}
+	}
+	return ""
+}
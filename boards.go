@@ -0,0 +1,528 @@
+package hwio
+
+// Multiple boards in one process. The package functions (PinMode, DigitalWrite, GetModule and so on) act on the
+// default board, the one whose driver is chosen at start up or set with SetDriver. A program that also drives other
+// hardware with a driver of its own, e.g. the local GPIO plus two USB GPIO adapters, opens each extra one with
+// NewBoard, and uses the Board's methods for it. Each board has its own pin map and modules, and pins are assigned
+// per board, so pin 0 of one board doesn't conflict with pin 0 of another.
+//
+// Pins are assigned to the board of the module assigning them. Modules learn nothing of boards; NewBoard records
+// which board the driver's modules belong to, so modules must call UnassignPinFrom, rather than UnassignPin, to
+// release pins.
+//
+// Known issues:
+// - pin locking between processes, and the features built on the package functions (rules, waveforms and so on),
+//   only cover the default board
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A board, with its driver, pin map and modules.
+type Board struct {
+	driver    HardwareDriver
+	pins      HardwarePinMap
+	assigned  map[Pin]*assignedPin
+	limits    map[Pin]*outputLimit
+	simulated map[Pin]*simulatedPin
+}
+
+// The board the package functions act on. Its driver, pin map and assigned pins are the package variables, so
+// SetDriver changes it.
+var defaultBoard = &Board{}
+
+// The board each module of a board opened with NewBoard belongs to. Modules not in this map belong to the default
+// board.
+var boardModules = make(map[Module]*Board)
+
+// The board whose driver is being initialised by NewBoard, so that modules its driver enables during Init assign
+// pins on it.
+var initialisingBoard *Board
+
+// Return the default board, which the package functions act on.
+func DefaultBoard() *Board {
+	return defaultBoard
+}
+
+// Open a board with its own driver, alongside the default board. The driver is initialised, and its pins and
+// modules are only available through the returned Board. Close the board when finished with it.
+func NewBoard(d HardwareDriver) (*Board, error) {
+	if d == nil {
+		return nil, errors.New("NewBoard needs a driver")
+	}
+	if d == driver {
+		return nil, errors.New("NewBoard: the driver is already the default board's")
+	}
+
+	b := &Board{driver: d, assigned: make(map[Pin]*assignedPin)}
+	initialisingBoard = b
+	e := d.Init()
+	initialisingBoard = nil
+	if e != nil {
+		return nil, fmt.Errorf("could not initialise driver: %s", e)
+	}
+
+	for _, m := range d.GetModules() {
+		boardModules[m] = b
+	}
+	b.pins = d.PinMap()
+	addLineNames(d, b.pins)
+	return b, nil
+}
+
+// The pin operations of a board. Code that takes an IO, rather than calling the package functions, can be given the
+// default board in the application and a board with a TestDriver in its tests:
+//
+//	io, e := hwio.New(new(hwio.TestDriver))
+type IO interface {
+	GetPin(pinName string) (Pin, error)
+	PinMode(pin Pin, mode PinIOMode, options ...PinOption) error
+	PinModeConfig(pin Pin, config PinConfig) error
+	DigitalWrite(pin Pin, value int) error
+	DigitalRead(pin Pin) (int, error)
+	AnalogRead(pin Pin) (int, error)
+	ClosePin(pin Pin) error
+	GetModule(name string) (Module, error)
+}
+
+var _ IO = (*Board)(nil)
+
+// Return a board for a driver. If the driver is nil or is the default board's driver, this is the default board;
+// otherwise the driver is opened as a new board, as NewBoard does.
+func New(d HardwareDriver) (*Board, error) {
+	if d == nil || d == driver {
+		return defaultBoard, nil
+	}
+	return NewBoard(d)
+}
+
+// Return the board a module belongs to.
+func boardOf(module Module) *Board {
+	if module != nil {
+		if b, ok := boardModules[module]; ok {
+			return b
+		}
+	}
+	if initialisingBoard != nil {
+		return initialisingBoard
+	}
+	return defaultBoard
+}
+
+// Return the board's driver.
+func (b *Board) Driver() HardwareDriver {
+	if b == defaultBoard {
+		return driver
+	}
+	return b.driver
+}
+
+// Return the board's pin map.
+func (b *Board) Pins() HardwarePinMap {
+	if b == defaultBoard {
+		return definedPins
+	}
+	return b.pins
+}
+
+// Return the pins assigned on the board.
+func (b *Board) assignments() map[Pin]*assignedPin {
+	if b == defaultBoard {
+		return assignedPins
+	}
+	return b.assigned
+}
+
+// Close the board, disabling its modules. Closing the default board is the same as CloseAll.
+func (b *Board) Close() {
+	if b == defaultBoard {
+		CloseAll()
+		return
+	}
+
+	b.driver.Close()
+	for _, m := range b.driver.GetModules() {
+		delete(boardModules, m)
+	}
+	b.assigned = make(map[Pin]*assignedPin)
+}
+
+// Return a pin of the board given its name, as GetPin does for the default board.
+func (b *Board) GetPin(pinName string) (Pin, error) {
+	return findPin(b.Pins(), pinName)
+}
+
+// Return the canonical name of a pin of the board, or "" if the board has no such pin.
+func (b *Board) PinName(pin Pin) string {
+	p := b.Pins()[pin]
+	if p == nil {
+		return ""
+	}
+	return p.names[0]
+}
+
+// Get a module of the board by name. The default board also has the modules registered with RegisterModule. If the
+// driver does not support that module, nil is returned.
+func (b *Board) GetModule(name string) (Module, error) {
+	if b == defaultBoard {
+		if m, ok := registeredModules[name]; ok {
+			return m, nil
+		}
+	}
+
+	d := b.Driver()
+	if d == nil {
+		return nil, errors.New("GetModule: Driver is not set")
+	}
+
+	modules := d.GetModules()
+	return modules[name], nil
+}
+
+// Get a module by name, returning an error if there is no module of that name. kind describes the module for the
+// error, e.g. "I2C".
+func (b *Board) getNamedModule(name string, kind string) (Module, error) {
+	m, e := b.GetModule(name)
+	if e != nil {
+		return nil, e
+	}
+	if m == nil {
+		return nil, fmt.Errorf("driver does not support %s module '%s'", kind, name)
+	}
+	return m, nil
+}
+
+// Get the board's GPIO module.
+func (b *Board) GetGPIOModule() (GPIOModule, error) {
+	m, e := b.GetModule("gpio")
+	if e != nil {
+		return nil, e
+	}
+
+	if m == nil {
+		return nil, errors.New("driver does not support GPIO")
+	}
+
+	gpio, ok := m.(GPIOModule)
+	if !ok {
+		return nil, errors.New("module 'gpio' is not a GPIO module")
+	}
+	return gpio, nil
+}
+
+// Get the board's analog module.
+func (b *Board) GetAnalogModule() (AnalogModule, error) {
+	m, e := b.GetModule("analog")
+	if e != nil {
+		return nil, e
+	}
+
+	if m == nil {
+		return nil, errors.New("driver does not support analog")
+	}
+
+	analog, ok := m.(AnalogModule)
+	if !ok {
+		return nil, errors.New("module 'analog' is not an analog module")
+	}
+	return analog, nil
+}
+
+// Get an I2C module of the board by name, e.g. "i2c".
+func (b *Board) GetI2CModule(name string) (I2CModule, error) {
+	m, e := b.getNamedModule(name, "I2C")
+	if e != nil {
+		return nil, e
+	}
+	i2c, ok := m.(I2CModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not an I2C module", name)
+	}
+	return i2c, nil
+}
+
+// Get an SPI module of the board by name, e.g. "spi0".
+func (b *Board) GetSPIModule(name string) (SPIModule, error) {
+	m, e := b.getNamedModule(name, "SPI")
+	if e != nil {
+		return nil, e
+	}
+	spi, ok := m.(SPIModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not an SPI module", name)
+	}
+	return spi, nil
+}
+
+// Get a PWM module of the board by name, e.g. "pwm" or "pwm2".
+func (b *Board) GetPWMModule(name string) (PWMModule, error) {
+	m, e := b.getNamedModule(name, "PWM")
+	if e != nil {
+		return nil, e
+	}
+	pwm, ok := m.(PWMModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not a PWM module", name)
+	}
+	return pwm, nil
+}
+
+// Get a clock module of the board by name, e.g. "clock".
+func (b *Board) GetClockModule(name string) (ClockModule, error) {
+	m, e := b.getNamedModule(name, "clock")
+	if e != nil {
+		return nil, e
+	}
+	clock, ok := m.(ClockModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not a clock module", name)
+	}
+	return clock, nil
+}
+
+// Get a serial module of the board by name, e.g. "serial".
+func (b *Board) GetSerialModule(name string) (SerialModule, error) {
+	m, e := b.getNamedModule(name, "serial")
+	if e != nil {
+		return nil, e
+	}
+	serial, ok := m.(SerialModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not a serial module", name)
+	}
+	return serial, nil
+}
+
+// Get an LED module of the board by name, e.g. "leds".
+func (b *Board) GetLEDModule(name string) (LEDModule, error) {
+	m, e := b.getNamedModule(name, "LED")
+	if e != nil {
+		return nil, e
+	}
+	leds, ok := m.(LEDModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' is not an LED module", name)
+	}
+	return leds, nil
+}
+
+// Set the mode of a pin of the board, with options as for PinMode.
+func (b *Board) PinMode(pin Pin, mode PinIOMode, options ...PinOption) (e error) {
+	if len(options) > 0 {
+		return b.PinModeConfig(pin, NewPinConfig(mode, options...))
+	}
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "PinMode", pin, mode.String(), "", e)
+		}(time.Now())
+	}
+
+	if s := b.simulated[pin]; s != nil {
+		s.setMode(mode, false)
+		return nil
+	}
+
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	e = gpio.PinMode(pin, mode)
+	if e == nil {
+		b.recordPinMode(pin, mode)
+	}
+	return e
+}
+
+// Set the mode and line attributes of a pin of the board, as PinModeConfig does for the default board.
+func (b *Board) PinModeConfig(pin Pin, config PinConfig) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "PinModeConfig", pin, fmt.Sprintf("%+v", config), "", e)
+		}(time.Now())
+	}
+
+	config = b.takeOutputLimit(pin, config)
+
+	if s := b.simulated[pin]; s != nil {
+		s.setMode(config.Mode, config.ActiveLow)
+		// an active-low output without an initial value starts deasserted, like other outputs
+		if config.Mode == Output && (config.UseInitialValue || config.ActiveLow) {
+			return s.digitalWrite(config.InitialValue)
+		}
+		return nil
+	}
+
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	if cm, ok := gpio.(GPIOConfigModule); ok {
+		e = cm.PinModeConfig(pin, config)
+	} else if config != (PinConfig{Mode: config.Mode}) {
+		return fmt.Errorf("module '%s' does not support pin configuration", gpio.GetName())
+	} else {
+		e = gpio.PinMode(pin, config.Mode)
+	}
+
+	if e == nil {
+		b.recordPinMode(pin, config.Mode)
+	}
+	return e
+}
+
+// Remember the mode a pin was set to, for reporting by the pin map. The pin must have been assigned.
+func (b *Board) recordPinMode(pin Pin, mode PinIOMode) {
+	if a := b.assignments()[pin]; a != nil {
+		a.pinIOMode = mode
+		a.modeSet = true
+	}
+}
+
+// Write a value to a digital pin of the board.
+func (b *Board) DigitalWrite(pin Pin, value int) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "DigitalWrite", pin, strconv.Itoa(value), "", e)
+		}(time.Now())
+	}
+
+	if s := b.simulated[pin]; s != nil {
+		return b.limits[pin].write(value, s.digitalWrite)
+	}
+
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	return b.limits[pin].write(value, func(v int) error {
+		return gpio.DigitalWrite(pin, v)
+	})
+}
+
+// Read a value from a digital pin of the board.
+func (b *Board) DigitalRead(pin Pin) (value int, e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "DigitalRead", pin, "", journalValue(value, e), e)
+		}(time.Now())
+	}
+
+	if s := b.simulated[pin]; s != nil {
+		return s.digitalRead(), nil
+	}
+
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return 0, e
+	}
+
+	return gpio.DigitalRead(pin)
+}
+
+// Read an analog value from a pin of the board.
+func (b *Board) AnalogRead(pin Pin) (value int, e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "AnalogRead", pin, "", journalValue(value, e), e)
+		}(time.Now())
+	}
+
+	if s := b.simulated[pin]; s != nil {
+		return s.read(), nil
+	}
+
+	analog, e := b.analogModuleFor(pin)
+	if e != nil {
+		return 0, e
+	}
+
+	return analog.AnalogRead(pin)
+}
+
+// Read several analog pins of the board as close together in time as the hardware allows. If the pins are all read
+// by one module that can sample them together, it does; otherwise they are read one after another.
+func (b *Board) AnalogReadMulti(pins ...Pin) ([]AnalogSample, error) {
+	if len(pins) == 0 {
+		return nil, errors.New("AnalogReadMulti needs pins to read")
+	}
+
+	var module AnalogModule
+	together := true
+	for _, pin := range pins {
+		m, e := b.analogModuleFor(pin)
+		if e != nil {
+			return nil, e
+		}
+		if b.simulated[pin] != nil || (module != nil && m != module) {
+			together = false
+		}
+		module = m
+	}
+	if mm, ok := module.(MultiAnalogModule); ok && together {
+		return mm.AnalogReadMulti(PinList(pins))
+	}
+
+	var samples []AnalogSample
+	e := runCriticalIO(func() (e error) {
+		samples, e = readAnalogInTurn(pins, b.AnalogRead)
+		return e
+	})
+	return samples, e
+}
+
+// Read analog pins one after another, timestamping each sample with the middle of its read.
+func readAnalogInTurn(pins PinList, read func(pin Pin) (int, error)) ([]AnalogSample, error) {
+	samples := make([]AnalogSample, len(pins))
+	for i, pin := range pins {
+		before := MonotonicNow()
+		value, e := read(pin)
+		if e != nil {
+			return nil, e
+		}
+		samples[i] = AnalogSample{Pin: pin, Value: value, Timestamp: before + (MonotonicNow()-before)/2}
+	}
+	return samples, nil
+}
+
+// Get the analog module that reads a pin. On the default board this is a registered analog module the pin map lists
+// for the pin, such as an analog mux, if there is one, and otherwise the driver's.
+func (b *Board) analogModuleFor(pin Pin) (AnalogModule, error) {
+	if b == defaultBoard {
+		if pd := definedPins[pin]; pd != nil {
+			for _, name := range pd.modules {
+				if analog, ok := registeredModules[name].(AnalogModule); ok {
+					return analog, nil
+				}
+			}
+		}
+	}
+	return b.GetAnalogModule()
+}
+
+// Close a pin of the board that has been assigned as GPIO by PinMode.
+func (b *Board) ClosePin(pin Pin) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			b.recordPinEvent(start, "ClosePin", pin, "", "", e)
+		}(time.Now())
+	}
+
+	delete(b.limits, pin)
+	if s := b.simulated[pin]; s != nil {
+		s.close()
+		return nil
+	}
+
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	return gpio.ClosePin(pin)
+}
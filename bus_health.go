@@ -0,0 +1,256 @@
+package hwio
+
+// Monitoring the I2C and SPI devices an application depends on, so it can degrade gracefully when one is unplugged,
+// loses power or locks up, and pick up again when it comes back. Each device is checked periodically, by reading an
+// identification register (WHO_AM_I) or just seeing that it acknowledges, and an event is sent when it goes missing
+// or returns:
+//
+//	monitor, e := hwio.NewBusMonitor(hwio.BusMonitorConfig{Interval: 5 * time.Second})
+//	monitor.AddDevice("imu", hwio.I2CWhoAmI(i2c.GetDevice(0x68), 0x75, 0x68))
+//	monitor.AddDevice("rtc", hwio.I2CProbe(i2c.GetDevice(0x51)))
+//	for event := range monitor.Events() {
+//		log.Printf("%s present: %v (%v)", event.Device, event.Present, event.Err)
+//	}
+//
+// Known issues:
+// - checks run one after another on the monitor's goroutine, so a device whose check blocks delays the others
+// - a check is a transfer, so it competes with the application's own transfers on the bus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check whether a device is present and working, returning nil if it is.
+type BusCheck func() error
+
+// An event from a BusMonitor: a device going missing, or being present again.
+type BusDeviceEvent struct {
+	Device  string
+	Present bool
+
+	// The error from the check that found the device missing, or nil
+	Err  error
+	Time time.Time
+}
+
+type BusMonitorConfig struct {
+	// How often to check the devices.
+	Interval time.Duration
+
+	// The number of checks in a row that must fail before a device is reported missing, so a single failed transfer
+	// isn't. Defaults to 2.
+	Misses int
+}
+
+// A monitor checking devices in the background.
+type BusMonitor struct {
+	config BusMonitorConfig
+	events chan BusDeviceEvent
+
+	// guards devices
+	sync.Mutex
+	devices map[string]*monitoredDevice
+
+	// serialises running the checks
+	checking sync.Mutex
+
+	stop chan bool
+	done chan bool
+}
+
+// A device being monitored, and what is known about it.
+type monitoredDevice struct {
+	check   BusCheck
+	known   bool
+	present bool
+	misses  int
+}
+
+// Start a monitor, checking its devices every interval. Add devices with AddDevice, and stop it with Close.
+func NewBusMonitor(config BusMonitorConfig) (*BusMonitor, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("bus monitor needs an interval to check devices at")
+	}
+	if config.Misses <= 0 {
+		config.Misses = 2
+	}
+
+	m := &BusMonitor{
+		config:  config,
+		events:  make(chan BusDeviceEvent, 16),
+		devices: make(map[string]*monitoredDevice),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+	go m.run()
+	return m, nil
+}
+
+// Add a device to check, by a name for its events. The first check of the device sends an event with whether it is
+// present, so its state is known from the start.
+func (m *BusMonitor) AddDevice(name string, check BusCheck) {
+	m.Lock()
+	defer m.Unlock()
+	m.devices[name] = &monitoredDevice{check: check}
+}
+
+// Stop checking a device.
+func (m *BusMonitor) RemoveDevice(name string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.devices, name)
+}
+
+// Return the channel events are sent on. Events are dropped if it is full. It is closed by Close.
+func (m *BusMonitor) Events() <-chan BusDeviceEvent {
+	return m.events
+}
+
+// Return whether a device was present at its last check, and false if it hasn't been checked.
+func (m *BusMonitor) Present(name string) bool {
+	m.Lock()
+	defer m.Unlock()
+	d := m.devices[name]
+	return d != nil && d.present
+}
+
+// Return the names of the devices that are missing, sorted.
+func (m *BusMonitor) Missing() []string {
+	m.Lock()
+	defer m.Unlock()
+	var names []string
+	for name, d := range m.devices {
+		if d.known && !d.present {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check all the devices now, rather than waiting for the next interval.
+func (m *BusMonitor) Check() {
+	m.checking.Lock()
+	defer m.checking.Unlock()
+
+	m.Lock()
+	names := make([]string, 0, len(m.devices))
+	checks := make([]BusCheck, 0, len(m.devices))
+	for name, d := range m.devices {
+		names = append(names, name)
+		checks = append(checks, d.check)
+	}
+	m.Unlock()
+
+	for i, name := range names {
+		e := checks[i]()
+
+		m.Lock()
+		d := m.devices[name]
+		if d != nil {
+			if event, changed := d.update(e, m.config.Misses); changed {
+				event.Device = name
+				event.Time = clock.Now()
+				select {
+				case m.events <- event:
+				default:
+				}
+			}
+		}
+		m.Unlock()
+	}
+}
+
+// Take the result of a check, returning an event if the device has gone missing or come back, or it is the first.
+func (d *monitoredDevice) update(e error, misses int) (BusDeviceEvent, bool) {
+	if e == nil {
+		d.misses = 0
+		if d.known && d.present {
+			return BusDeviceEvent{}, false
+		}
+		d.known, d.present = true, true
+		return BusDeviceEvent{Present: true}, true
+	}
+
+	d.misses++
+	if d.known && (!d.present || d.misses < misses) {
+		return BusDeviceEvent{}, false
+	}
+	d.known, d.present = true, false
+	return BusDeviceEvent{Present: false, Err: e}, true
+}
+
+// Stop checking, and close the events channel.
+func (m *BusMonitor) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
+}
+
+func (m *BusMonitor) run() {
+	defer close(m.done)
+	defer close(m.events)
+
+	ticker := clock.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			m.Check()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Check an I2C device by reading its identification register, which must hold expected.
+func I2CWhoAmI(device I2CDevice, register byte, expected byte) BusCheck {
+	return func() error {
+		id, e := device.ReadByte(register)
+		if e != nil {
+			return e
+		}
+		if id != expected {
+			return fmt.Errorf("device identifies as 0x%02x, not 0x%02x", id, expected)
+		}
+		return nil
+	}
+}
+
+// Check an I2C device acknowledges, by reading register 0. Use I2CWhoAmI for devices that have an identification
+// register, as a device that has locked up can still acknowledge.
+func I2CProbe(device I2CDevice) BusCheck {
+	return func() error {
+		_, e := device.ReadByte(0)
+		return e
+	}
+}
+
+// Check an SPI device by sending command, e.g. a register read, and comparing the bytes clocked in after it with
+// expected. A device that is missing usually reads as all zeros or all ones, so expected should be neither. The
+// module must support Transfer.
+func SPIWhoAmI(module SPIModule, slaveSelect int, command []byte, expected []byte) BusCheck {
+	return func() error {
+		tm, ok := module.(SPITransferModule)
+		if !ok {
+			return fmt.Errorf("module '%s' does not support transfers of several segments", module.GetName())
+		}
+		tx := make([]byte, len(command)+len(expected))
+		copy(tx, command)
+		rx := make([]byte, len(tx))
+		e := tm.Transfer(slaveSelect, []SPISegment{{Tx: tx, Rx: rx}})
+		if e != nil {
+			return e
+		}
+		if id := rx[len(command):]; !bytes.Equal(id, expected) {
+			return fmt.Errorf("device identifies as % x, not % x", id, expected)
+		}
+		return nil
+	}
+}
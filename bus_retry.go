@@ -0,0 +1,333 @@
+package hwio
+
+// Retrying I2C and SPI transfers that fail for reasons that usually pass: arbitration lost to another master, a
+// device that doesn't acknowledge because it is busy (e.g. an EEPROM during a write cycle), or a timeout. A device
+// is wrapped with a retry policy, and used in place of the device itself:
+//
+//	i2c, e := hwio.GetI2CModule("i2c")
+//	retrying := hwio.NewRetryI2CModule("i2c", i2c, hwio.DefaultBusRetry)
+//	retrying.SetDevicePolicy(0x50, hwio.RetryPolicy{Attempts: 10, InitialDelay: time.Millisecond})  // an EEPROM
+//	sensor := tmp102.NewTMP102(retrying)
+//
+//	spi, e := hwio.GetSPIModule("spi")
+//	s := hwio.NewRetrySPIModule("spi", spi, hwio.DefaultBusRetry)
+//
+// The wrappers count each device's transfers, errors, retries and failures, which BusRetryCounters lists by name, to
+// be logged or served to a dashboard.
+//
+// Known issues:
+// - a write that failed part way may have reached the device, so it is only safe to retry writes that are
+//   idempotent, e.g. setting a register, and not e.g. pushing to a FIFO
+// - transient errors are recognised by errno, so errors from modules that don't report the kernel's errno are
+//   never retried
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How a device's transfers are retried.
+type RetryPolicy struct {
+	// the number of times to retry a transfer after it first fails. 0 disables retrying.
+	Attempts int
+
+	// the delay before the first retry. The delay doubles after each retry, up to MaxDelay, or without limit if
+	// MaxDelay is 0.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+
+	// Return true if a transfer that failed with an error should be retried. If nil, IsTransientBusError is used.
+	Retryable func(e error) bool
+}
+
+// Retries three times over about 7ms, which covers arbitration and a busy device, but not an EEPROM write cycle.
+var DefaultBusRetry = RetryPolicy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+
+// The errno values that bus drivers report for failures that usually pass, from the kernel's i2c fault codes.
+var transientBusErrors = []syscall.Errno{
+	syscall.EAGAIN,    // arbitration lost
+	syscall.ENXIO,     // no acknowledge of the address
+	syscall.EREMOTEIO, // no acknowledge of data
+	syscall.ETIMEDOUT, // the device stretched the clock too long, or the bus was busy
+	syscall.EIO,       // a controller that doesn't say more
+}
+
+// Return true if a bus transfer error is one that usually passes, so the transfer is worth retrying. Errors are
+// recognised by errno, either as the error itself or at the end of its message, as modules report them.
+func IsTransientBusError(e error) bool {
+	if e == nil {
+		return false
+	}
+	if errno, ok := e.(syscall.Errno); ok {
+		for _, t := range transientBusErrors {
+			if errno == t {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range transientBusErrors {
+		if strings.HasSuffix(e.Error(), t.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts of a device's transfers.
+type BusRetryCounts struct {
+	// transfers attempted, not counting retries
+	Transfers uint64
+
+	// errors returned by the device, including ones that were retried
+	Errors uint64
+
+	// retries made, and transfers that failed after all retries or with an error that isn't retried
+	Retries  uint64
+	Failures uint64
+}
+
+// The counters of a wrapper's devices, by device name, registered under the wrapper's name so BusRetryCounters can
+// list them.
+type busRetryCounters struct {
+	// guards counts
+	sync.Mutex
+	counts map[string]*BusRetryCounts
+}
+
+var busRetryRegistry = struct {
+	sync.Mutex
+	counters map[string]*busRetryCounters
+}{counters: make(map[string]*busRetryCounters)}
+
+// Return the counts of each device wrapped with a retry policy, by name. The devices of an SPI module are named
+// after it, e.g. "spi.0" for chip select 0 of a module named "spi".
+func BusRetryCounters() map[string]BusRetryCounts {
+	busRetryRegistry.Lock()
+	defer busRetryRegistry.Unlock()
+	result := make(map[string]BusRetryCounts)
+	for _, c := range busRetryRegistry.counters {
+		c.Lock()
+		for name, counts := range c.counts {
+			result[name] = *counts
+		}
+		c.Unlock()
+	}
+	return result
+}
+
+// Register counters for a wrapper, replacing those of an earlier wrapper of the same name.
+func newBusRetryCounters(name string) *busRetryCounters {
+	c := &busRetryCounters{counts: make(map[string]*BusRetryCounts)}
+	busRetryRegistry.Lock()
+	busRetryRegistry.counters[name] = c
+	busRetryRegistry.Unlock()
+	return c
+}
+
+func (c *busRetryCounters) get(name string) BusRetryCounts {
+	c.Lock()
+	defer c.Unlock()
+	if counts := c.counts[name]; counts != nil {
+		return *counts
+	}
+	return BusRetryCounts{}
+}
+
+func (c *busRetryCounters) update(name string, f func(counts *BusRetryCounts)) {
+	c.Lock()
+	defer c.Unlock()
+	counts := c.counts[name]
+	if counts == nil {
+		counts = &BusRetryCounts{}
+		c.counts[name] = counts
+	}
+	f(counts)
+}
+
+// Call transfer, retrying it as the policy says, and count the result under name.
+func (c *busRetryCounters) retry(name string, policy RetryPolicy, transfer func() error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransientBusError
+	}
+
+	delay := policy.InitialDelay
+	e := transfer()
+	retries, errs := uint64(0), uint64(0)
+	for ; e != nil; e = transfer() {
+		errs++
+		if int(retries) >= policy.Attempts || !retryable(e) {
+			break
+		}
+		clock.Sleep(delay)
+		retries++
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+
+	c.update(name, func(counts *BusRetryCounts) {
+		counts.Transfers++
+		counts.Errors += errs
+		counts.Retries += retries
+		if e != nil {
+			counts.Failures++
+		}
+	})
+	return e
+}
+
+// The retry policies of a bus's devices: one for all of them, and others for particular devices.
+type busRetryPolicies struct {
+	// guards policies
+	sync.Mutex
+	policy   RetryPolicy
+	policies map[int]RetryPolicy
+}
+
+func newBusRetryPolicies(policy RetryPolicy) busRetryPolicies {
+	return busRetryPolicies{policy: policy, policies: make(map[int]RetryPolicy)}
+}
+
+// Set the retry policy of one device, by its address or chip select.
+func (p *busRetryPolicies) SetDevicePolicy(device int, policy RetryPolicy) {
+	p.Lock()
+	defer p.Unlock()
+	p.policies[device] = policy
+}
+
+func (p *busRetryPolicies) devicePolicy(device int) RetryPolicy {
+	p.Lock()
+	defer p.Unlock()
+	if policy, ok := p.policies[device]; ok {
+		return policy
+	}
+	return p.policy
+}
+
+// An I2C module whose devices' transfers are retried, with a policy for each address. It is an I2CModule, so it can
+// be given to device drivers in place of the module. Enabling and disabling it enables and disables the module.
+type RetryI2CModule struct {
+	I2CModule
+	busRetryPolicies
+	name     string
+	counters *busRetryCounters
+}
+
+// Wrap an I2C module with a retry policy for all its devices. The counts of each device are listed by
+// BusRetryCounters under name, a space and the address, e.g. "i2c 0x68".
+func NewRetryI2CModule(name string, module I2CModule, policy RetryPolicy) *RetryI2CModule {
+	return &RetryI2CModule{I2CModule: module, busRetryPolicies: newBusRetryPolicies(policy), name: name,
+		counters: newBusRetryCounters(name)}
+}
+
+// Return a device whose transfers are retried with the policy for its address.
+func (m *RetryI2CModule) GetDevice(address int) I2CDevice {
+	return &retryI2CDevice{module: m, device: m.I2CModule.GetDevice(address), address: address}
+}
+
+func (m *RetryI2CModule) deviceName(address int) string {
+	return fmt.Sprintf("%s 0x%02x", m.name, address)
+}
+
+// Return the counts of the device at an address.
+func (m *RetryI2CModule) Counts(address int) BusRetryCounts {
+	return m.counters.get(m.deviceName(address))
+}
+
+type retryI2CDevice struct {
+	module  *RetryI2CModule
+	device  I2CDevice
+	address int
+}
+
+func (d *retryI2CDevice) retry(transfer func() error) error {
+	return d.module.counters.retry(d.module.deviceName(d.address), d.module.devicePolicy(d.address), transfer)
+}
+
+func (d *retryI2CDevice) ReadByte(command byte) (value byte, e error) {
+	e = d.retry(func() (e error) {
+		value, e = d.device.ReadByte(command)
+		return e
+	})
+	return value, e
+}
+
+func (d *retryI2CDevice) WriteByte(command byte, value byte) error {
+	return d.retry(func() error {
+		return d.device.WriteByte(command, value)
+	})
+}
+
+func (d *retryI2CDevice) Read(command byte, numBytes int) (data []byte, e error) {
+	e = d.retry(func() (e error) {
+		data, e = d.device.Read(command, numBytes)
+		return e
+	})
+	return data, e
+}
+
+func (d *retryI2CDevice) Write(command byte, buffer []byte) error {
+	return d.retry(func() error {
+		return d.device.Write(command, buffer)
+	})
+}
+
+// An SPI module whose transfers are retried, with a policy for each chip select. It is an SPITransferModule, so it
+// can be given to device drivers in place of the module; Transfer returns an error if the module doesn't support
+// it. Enabling and disabling it enables and disables the module.
+type RetrySPIModule struct {
+	SPIModule
+	busRetryPolicies
+	name     string
+	counters *busRetryCounters
+}
+
+// Wrap an SPI module with a retry policy for all its devices. The counts of each chip select are listed by
+// BusRetryCounters under name, a dot and the chip select, e.g. "spi.0".
+func NewRetrySPIModule(name string, module SPIModule, policy RetryPolicy) *RetrySPIModule {
+	return &RetrySPIModule{SPIModule: module, busRetryPolicies: newBusRetryPolicies(policy), name: name,
+		counters: newBusRetryCounters(name)}
+}
+
+func (m *RetrySPIModule) deviceName(slaveSelect int) string {
+	return fmt.Sprintf("%s.%d", m.name, slaveSelect)
+}
+
+func (m *RetrySPIModule) retry(slaveSelect int, transfer func() error) error {
+	return m.counters.retry(m.deviceName(slaveSelect), m.devicePolicy(slaveSelect), transfer)
+}
+
+func (m *RetrySPIModule) Write(slaveSelect int, data []byte) error {
+	return m.retry(slaveSelect, func() error {
+		return m.SPIModule.Write(slaveSelect, data)
+	})
+}
+
+func (m *RetrySPIModule) Read(slaveSelect int, data []byte) (n int, e error) {
+	e = m.retry(slaveSelect, func() (e error) {
+		n, e = m.SPIModule.Read(slaveSelect, data)
+		return e
+	})
+	return n, e
+}
+
+func (m *RetrySPIModule) Transfer(slaveSelect int, segments []SPISegment) error {
+	tm, ok := m.SPIModule.(SPITransferModule)
+	if !ok {
+		return fmt.Errorf("module '%s' does not support transfers of several segments", m.GetName())
+	}
+	return m.retry(slaveSelect, func() error {
+		return tm.Transfer(slaveSelect, segments)
+	})
+}
+
+// Return the counts of the device on one chip select.
+func (m *RetrySPIModule) Counts(slaveSelect int) BusRetryCounts {
+	return m.counters.get(m.deviceName(slaveSelect))
+}
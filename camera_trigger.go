@@ -0,0 +1,245 @@
+// Helpers for machine vision, where cameras are triggered by a pulse on a GPIO pin and report each exposure with a
+// strobe pulse, which is often used to fire a light. CameraTrigger generates trigger pulses at precise times, and
+// matches the strobe pulses that come back to them, so a missed frame can be detected and the delay from trigger to
+// exposure measured.
+//
+// Pulses are generated by writing the GPIO pin, waiting for the last fraction of each interval by spinning rather
+// than sleeping. This gives jitter of a few microseconds on an idle system, but the scheduler can delay a pulse by
+// much more under load; running the application with a real-time priority on an isolated CPU helps. Strobe times are
+// taken by the kernel when the edge is detected, so they are accurate regardless of when they are read.
+
+package hwio
+
+import (
+	"errors"
+	"time"
+)
+
+// How long before a deadline spinUntil stops sleeping and starts spinning. This covers the wake up latency of
+// a sleep on a typical system.
+const spinThreshold = 200 * time.Microsecond
+
+type CameraTriggerConfig struct {
+	// The pin that triggers the camera, and the level that triggers it, High or Low.
+	TriggerPin    Pin
+	TriggerActive int
+
+	// How long the trigger pulse lasts. Defaults to 100µs.
+	PulseWidth time.Duration
+
+	// The pin the camera's strobe output is connected to, if UseStrobePin is true, and the edge that marks the
+	// start of an exposure. StrobeEdge defaults to EdgeRising.
+	StrobePin    Pin
+	UseStrobePin bool
+	StrobeEdge   Edge
+
+	// How long after a trigger to wait for its strobe before deciding the camera missed it. Defaults to 100ms.
+	StrobeTimeout time.Duration
+}
+
+// The result of a trigger pulse. Times are on the clock of MonotonicNow.
+type TriggerResult struct {
+	// When the trigger pin was asserted
+	Triggered time.Duration
+
+	// If a strobe was seen for this trigger, when it started, and the time between the trigger and the strobe.
+	Strobed bool
+	Strobe  time.Duration
+	Latency time.Duration
+}
+
+type CameraTrigger struct {
+	config  CameraTriggerConfig
+	strobes <-chan EdgeEvent
+
+	// strobes seen in total, including any that didn't match a trigger
+	strobeCount uint64
+}
+
+// Create a camera trigger, setting the trigger pin to an output at its inactive level and watching the strobe pin
+// for edges if one is used. The strobe pin must be on a GPIO module that supports edge detection.
+func NewCameraTrigger(config CameraTriggerConfig) (*CameraTrigger, error) {
+	if config.PulseWidth <= 0 {
+		config.PulseWidth = 100 * time.Microsecond
+	}
+	if config.StrobeEdge == EdgeNone {
+		config.StrobeEdge = EdgeRising
+	}
+	if config.StrobeEdge == EdgeBoth {
+		return nil, errors.New("a camera strobe is either a rising or a falling edge")
+	}
+	if config.StrobeTimeout <= 0 {
+		config.StrobeTimeout = 100 * time.Millisecond
+	}
+
+	e := PinModeOutputInit(config.TriggerPin, Negate(config.TriggerActive))
+	if e != nil {
+		return nil, e
+	}
+
+	t := &CameraTrigger{config: config}
+	if config.UseStrobePin {
+		e = PinMode(config.StrobePin, Input)
+		if e != nil {
+			return nil, e
+		}
+		t.strobes, e = WatchEdges(config.StrobePin, config.StrobeEdge)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return t, nil
+}
+
+// Trigger the camera once. If a strobe pin is used, this waits for the strobe, up to the strobe timeout; if none
+// arrives the result has Strobed false, which is not an error.
+func (t *CameraTrigger) Trigger() (TriggerResult, error) {
+	t.drainStrobes(nil)
+
+	result, e := t.pulse()
+	if e != nil || t.strobes == nil {
+		return result, e
+	}
+
+	timeout := time.NewTimer(t.config.StrobeTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case event, ok := <-t.strobes:
+			if !ok {
+				return result, errors.New("camera strobe pin is no longer being watched")
+			}
+			t.strobeCount++
+			if event.Timestamp >= result.Triggered {
+				result.setStrobe(event.Timestamp)
+				return result, nil
+			}
+		case <-timeout.C:
+			return result, nil
+		}
+	}
+}
+
+// Trigger the camera n times, interval apart. Each pulse is scheduled interval after the one before it was due, but
+// never less than interval after the one before it was sent, as a camera still exposing or reading out a frame ignores
+// a trigger; so a late pulse delays the ones after it rather than crowding the next. If a strobe pin is used, each
+// strobe is matched to the trigger before it, and the burst returns once the strobe timeout has passed after the last
+// trigger.
+func (t *CameraTrigger) Burst(n int, interval time.Duration) ([]TriggerResult, error) {
+	if interval <= t.config.PulseWidth {
+		return nil, errors.New("camera trigger interval must be longer than the pulse width")
+	}
+
+	var strobes []time.Duration
+	t.drainStrobes(nil)
+
+	results := make([]TriggerResult, 0, n)
+	due := MonotonicNow() + spinThreshold
+	for i := 0; i < n; i++ {
+		t.drainStrobes(&strobes)
+		spinUntil(due)
+
+		result, e := t.pulse()
+		if e != nil {
+			return results, e
+		}
+		results = append(results, result)
+		due += interval
+		if earliest := result.Triggered + interval; due < earliest {
+			due = earliest
+		}
+	}
+
+	if t.strobes != nil && n > 0 {
+		timeout := time.NewTimer(t.config.StrobeTimeout)
+		defer timeout.Stop()
+	wait:
+		for len(strobes) < n {
+			select {
+			case event, ok := <-t.strobes:
+				if !ok {
+					break wait
+				}
+				t.strobeCount++
+				strobes = append(strobes, event.Timestamp)
+			case <-timeout.C:
+				break wait
+			}
+		}
+		matchStrobes(results, strobes)
+	}
+	return results, nil
+}
+
+// The number of strobes seen since the trigger was created, including any that came when no trigger was waiting
+// for one.
+func (t *CameraTrigger) StrobeCount() uint64 {
+	return t.strobeCount
+}
+
+// Stop watching the strobe pin. The trigger pin is left at its inactive level.
+func (t *CameraTrigger) Close() error {
+	if t.strobes == nil {
+		return nil
+	}
+	t.strobes = nil
+	return StopWatchingEdges(t.config.StrobePin)
+}
+
+// Generate a trigger pulse, recording when it started.
+func (t *CameraTrigger) pulse() (TriggerResult, error) {
+	result := TriggerResult{Triggered: MonotonicNow()}
+	e := DigitalWrite(t.config.TriggerPin, t.config.TriggerActive)
+	if e != nil {
+		return result, e
+	}
+	spinUntil(result.Triggered + t.config.PulseWidth)
+	return result, DigitalWrite(t.config.TriggerPin, Negate(t.config.TriggerActive))
+}
+
+// Read the strobes that have arrived without waiting, adding their times to strobes if it's not nil.
+func (t *CameraTrigger) drainStrobes(strobes *[]time.Duration) {
+	for t.strobes != nil {
+		select {
+		case event, ok := <-t.strobes:
+			if !ok {
+				return
+			}
+			t.strobeCount++
+			if strobes != nil {
+				*strobes = append(*strobes, event.Timestamp)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (r *TriggerResult) setStrobe(strobe time.Duration) {
+	r.Strobed = true
+	r.Strobe = strobe
+	r.Latency = strobe - r.Triggered
+}
+
+// Match strobes to the triggers before them. A strobe belongs to the latest trigger that started before it; if a
+// trigger gets more than one strobe, the first is kept.
+func matchStrobes(results []TriggerResult, strobes []time.Duration) {
+	i := 0
+	for _, strobe := range strobes {
+		for i+1 < len(results) && results[i+1].Triggered <= strobe {
+			i++
+		}
+		if strobe >= results[i].Triggered && !results[i].Strobed {
+			results[i].setStrobe(strobe)
+		}
+	}
+}
+
+// Wait until a time on the clock of MonotonicNow, sleeping for most of the wait and spinning for the rest.
+func spinUntil(deadline time.Duration) {
+	if remaining := deadline - MonotonicNow(); remaining > spinThreshold {
+		time.Sleep(remaining - spinThreshold)
+	}
+	for MonotonicNow() < deadline {
+	}
+}
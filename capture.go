@@ -0,0 +1,258 @@
+package hwio
+
+// Capturing fast signals for analysis afterwards: the edges of a digital pin, or a stream of analog readings, are
+// put into a ring buffer as they arrive, to be read out in bulk later, or written to a file. The buffer is lock-free
+// for one writer and one reader, so capturing is never held up by the code reading the buffer. When the buffer is
+// full, new samples are dropped and counted, so a capture with gaps in it can be recognised.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// A captured sample: the time, on the clock of MonotonicNow, and the level (edges) or reading (analog).
+type CaptureSample struct {
+	Time  time.Duration
+	Value int
+}
+
+// A ring buffer of samples, safe for one goroutine putting samples in and one taking them out at the same time,
+// without locks.
+type CaptureBuffer struct {
+	// These are first so they are 64 bit aligned, as atomic operations on 32 bit ARM require. head is the count
+	// of samples put in, and tail of samples taken out.
+	head      uint64
+	tail      uint64
+	overflows uint64
+
+	samples []CaptureSample
+	mask    uint64
+}
+
+// Create a buffer for at least size samples. The size is rounded up to a power of two.
+func NewCaptureBuffer(size int) *CaptureBuffer {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &CaptureBuffer{samples: make([]CaptureSample, n), mask: uint64(n - 1)}
+}
+
+// Add a sample, returning false if the buffer is full, in which case the sample is dropped and counted as an
+// overflow.
+func (b *CaptureBuffer) Put(sample CaptureSample) bool {
+	head := atomic.LoadUint64(&b.head)
+	if head-atomic.LoadUint64(&b.tail) == uint64(len(b.samples)) {
+		atomic.AddUint64(&b.overflows, 1)
+		return false
+	}
+	b.samples[head&b.mask] = sample
+	atomic.StoreUint64(&b.head, head+1)
+	return true
+}
+
+// Take the oldest sample, returning false if there is none.
+func (b *CaptureBuffer) Get() (CaptureSample, bool) {
+	tail := atomic.LoadUint64(&b.tail)
+	if tail == atomic.LoadUint64(&b.head) {
+		return CaptureSample{}, false
+	}
+	sample := b.samples[tail&b.mask]
+	atomic.StoreUint64(&b.tail, tail+1)
+	return sample, true
+}
+
+// Take all the samples in the buffer, appending them to dst, which can be nil.
+func (b *CaptureBuffer) Drain(dst []CaptureSample) []CaptureSample {
+	tail := atomic.LoadUint64(&b.tail)
+	head := atomic.LoadUint64(&b.head)
+	for i := tail; i != head; i++ {
+		dst = append(dst, b.samples[i&b.mask])
+	}
+	atomic.StoreUint64(&b.tail, head)
+	return dst
+}
+
+// The number of samples in the buffer.
+func (b *CaptureBuffer) Len() int {
+	return int(atomic.LoadUint64(&b.head) - atomic.LoadUint64(&b.tail))
+}
+
+// The number of samples that have been lost, because the buffer was full or, for edges, because the kernel or
+// WatchEdges dropped them.
+func (b *CaptureBuffer) Overflows() uint64 {
+	return atomic.LoadUint64(&b.overflows)
+}
+
+// A capture running in the background, filling a CaptureBuffer.
+type Capture struct {
+	Buffer *CaptureBuffer
+
+	pin   Pin
+	edges bool
+	stop  chan bool
+	done  chan bool
+}
+
+// Capture the edges of a digital input into a buffer, with the kernel's timestamps. The pin must be an input on a
+// GPIO module that supports edge detection. Each sample is the level after the edge.
+func CaptureEdges(pin Pin, edge Edge, buffer *CaptureBuffer) (*Capture, error) {
+	events, e := WatchEdges(pin, edge)
+	if e != nil {
+		return nil, e
+	}
+
+	c := &Capture{Buffer: buffer, pin: pin, edges: true, done: make(chan bool)}
+	go func() {
+		defer close(c.done)
+
+		var lastSeq uint32
+		for event := range events {
+			if lastSeq != 0 && event.Seq != lastSeq+1 {
+				atomic.AddUint64(&buffer.overflows, uint64(event.Seq-lastSeq-1))
+			}
+			lastSeq = event.Seq
+
+			value := Low
+			if event.Rising {
+				value = High
+			}
+			buffer.Put(CaptureSample{Time: event.Timestamp, Value: value})
+		}
+	}()
+	return c, nil
+}
+
+// Capture the readings of an analog pin into a buffer, every interval. Readings that fail are skipped.
+func CaptureAnalog(pin Pin, interval time.Duration, buffer *CaptureBuffer) (*Capture, error) {
+	if interval <= 0 {
+		return nil, errors.New("CaptureAnalog needs an interval to sample at")
+	}
+	_, e := AnalogRead(pin)
+	if e != nil {
+		return nil, e
+	}
+
+	c := &Capture{Buffer: buffer, pin: pin, stop: make(chan bool), done: make(chan bool)}
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			t := MonotonicNow()
+			value, e := AnalogRead(pin)
+			if e == nil {
+				buffer.Put(CaptureSample{Time: t, Value: value})
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Stop capturing. The samples captured stay in the buffer.
+func (c *Capture) Stop() error {
+	var e error
+	if c.edges {
+		e = StopWatchingEdges(c.pin)
+	} else {
+		close(c.stop)
+	}
+	<-c.done
+	return e
+}
+
+// The start of a capture file.
+var captureFileMagic = [8]byte{'H', 'W', 'I', 'O', 'C', 'A', 'P', '1'}
+
+// Write samples to a binary file: an 8 byte header, "HWIOCAP1", the number of samples as a uint64, then for each
+// sample its time in nanoseconds as an int64 and its value as an int32, all little endian.
+func WriteCaptureFile(path string, samples []CaptureSample) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+
+	w := bufio.NewWriter(f)
+	e = writeCapture(w, samples)
+	if e == nil {
+		e = w.Flush()
+	}
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	return e
+}
+
+// Read samples written by WriteCaptureFile.
+func ReadCaptureFile(path string) ([]CaptureSample, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	samples, e := readCapture(bufio.NewReader(f))
+	if e != nil {
+		return nil, fmt.Errorf("capture file %s is not valid: %s", path, e)
+	}
+	return samples, nil
+}
+
+func writeCapture(w io.Writer, samples []CaptureSample) error {
+	e := binary.Write(w, binary.LittleEndian, captureFileMagic)
+	if e == nil {
+		e = binary.Write(w, binary.LittleEndian, uint64(len(samples)))
+	}
+	for _, s := range samples {
+		if e != nil {
+			break
+		}
+		e = binary.Write(w, binary.LittleEndian, struct {
+			Time  int64
+			Value int32
+		}{int64(s.Time), int32(s.Value)})
+	}
+	return e
+}
+
+func readCapture(r io.Reader) ([]CaptureSample, error) {
+	var magic [8]byte
+	var count uint64
+	e := binary.Read(r, binary.LittleEndian, &magic)
+	if e == nil && magic != captureFileMagic {
+		e = errors.New("it does not start with HWIOCAP1")
+	}
+	if e == nil {
+		e = binary.Read(r, binary.LittleEndian, &count)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	samples := make([]CaptureSample, 0)
+	for i := uint64(0); i < count; i++ {
+		var s struct {
+			Time  int64
+			Value int32
+		}
+		e = binary.Read(r, binary.LittleEndian, &s)
+		if e != nil {
+			return nil, e
+		}
+		samples = append(samples, CaptureSample{Time: time.Duration(s.Time), Value: int(s.Value)})
+	}
+	return samples, nil
+}
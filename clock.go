@@ -0,0 +1,252 @@
+package hwio
+
+// The clock that timed helpers run on. Soft PWM, sequences, output limits, Delay and the device drivers that wait or
+// tick get the time from the package's Clock, rather than from the time package, so that tests can replace it with a
+// FakeClock and step through time without sleeping:
+//
+//	clock := hwio.NewFakeClock(time.Now())
+//	hwio.SetClock(clock)
+//	defer hwio.SetClock(nil)
+//	... start a sequence ...
+//	clock.BlockUntil(1)  // wait for the sequence to start waiting
+//	clock.Advance(time.Second)
+//
+// Rules, analog watches, PID loops, fan controllers, the watchdog, the data logger and the RTC scheduler run on the
+// Clock too, as do the SDI-12, e-paper, TFT and LED matrix drivers.
+//
+// Known issues:
+// - MonotonicNow, and the timestamps of edges, come from the kernel's monotonic clock, not the package's Clock, so the
+//   intervals PID loops, fan controllers and the watchdog measure don't follow a FakeClock
+// - bit-level timing (soft serial, RS-485 turnaround, parallel buses, the debug probe, camera triggers and signal
+//   generators) and read deadlines set on files (serial ports, IIO buffers, SDI-12 and bootloader reads) use the
+//   system clock, as the kernel or the hardware times them
+// - the journal, the IO scheduler, snapshots, log file rotation, self tests, logic analyzer and analog captures, I2C
+//   recovery, serial frame idle timers, WaitForEdge timeouts, the timers of rules with For, and the Wiegand, PS/2
+//   and RYLR896 drivers still use the time package directly
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A source of time, with the parts of the time package that timed helpers use.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// A timer from a Clock, as time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// A ticker from a Clock, as time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+var clock Clock = systemClock{}
+
+// Set the clock the package's timed helpers use. nil sets the system clock back. Helpers that are already running
+// keep the clock they started with.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	clock = c
+}
+
+// Return the clock the package's timed helpers use, for device drivers to use too.
+func GetClock() Clock {
+	return clock
+}
+
+// The clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// A clock for tests, whose time only moves when Advance is called. Timers, tickers and sleeps fire as Advance passes
+// their times, in order.
+type FakeClock struct {
+	// guards now and waiters
+	sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// Something waiting for a FakeClock: a timer, ticker or sleep.
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// Create a fake clock, starting at a time.
+func NewFakeClock(start time.Time) *FakeClock {
+	f := &FakeClock{now: start}
+	f.changed = sync.NewCond(&f.Mutex)
+	return f
+}
+
+// Return the clock's time.
+func (f *FakeClock) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+// Block until the clock has been advanced by d.
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.NewTimer(d).C()
+}
+
+// Create a timer that fires once the clock has been advanced by d.
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1)}
+	w.Reset(d)
+	return w
+}
+
+// Create a ticker that fires each time the clock has been advanced by d.
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{clock: f, period: d, c: make(chan time.Time, 1)}
+	w.Reset(d)
+	return fakeTicker{w}
+}
+
+// Move the clock on by d, firing the timers, tickers and sleeps that fall due on the way, each at its own time.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].at.After(end) {
+		w := f.waiters[0]
+		f.now = w.at
+		w.fire()
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+			f.sortWaiters()
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = end
+	f.changed.Broadcast()
+}
+
+// Block until at least n timers, tickers or sleeps are waiting for the clock, e.g. so a test knows a goroutine has got
+// as far as waiting before it advances the clock.
+func (f *FakeClock) BlockUntil(n int) {
+	f.Lock()
+	defer f.Unlock()
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+// Sort the waiters by time. The caller must hold the lock.
+func (f *FakeClock) sortWaiters() {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].at.Before(f.waiters[j].at)
+	})
+}
+
+// Remove a waiter, returning true if it was waiting. The caller must hold the lock.
+func (f *FakeClock) remove(w *fakeWaiter) bool {
+	for i, waiter := range f.waiters {
+		if waiter == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
+
+// Send the time on the channel, dropping it if the last hasn't been received, as time.Ticker does. The caller must
+// hold the lock.
+func (w *fakeWaiter) fire() {
+	select {
+	case w.c <- w.clock.now:
+	default:
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	f := w.clock
+	f.Lock()
+	defer f.Unlock()
+	active := f.remove(w)
+	f.changed.Broadcast()
+	return active
+}
+
+// Set the waiter to fire d from now. A waiter due now or before fires straight away.
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+	f.Lock()
+	defer f.Unlock()
+	active := f.remove(w)
+	w.at = f.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		w.fire()
+	} else {
+		f.waiters = append(f.waiters, w)
+		f.sortWaiters()
+	}
+	f.changed.Broadcast()
+	return active
+}
@@ -0,0 +1,146 @@
+// hwio
+//
+// A command line tool for inspecting the hardware hwio is running on.
+//
+// Usage:
+//   hwio pins [-json] [-header name]
+//   hwio snapshot [-probe-i2c] [-o file]
+//   hwio diff [-probe-i2c] before.json [after.json]
+//
+// "pins" shows the driver's pin map. By default it prints a diagram of each header, followed by a table of all
+// pins. -json prints the pin map as JSON instead, and -header limits the output to the diagram of one header.
+//
+// "snapshot" prints the hardware state as JSON: the GPIOs exported or in use, the PWM channels and the I2C devices.
+// -o writes it to a file instead, and -probe-i2c also probes the I2C buses for devices without a driver.
+//
+// "diff" compares a saved snapshot with another, or with the current state if only one is given, and prints the
+// differences. It exits with status 1 if there are any.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cinellodev/hwio"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "pins":
+		pins(os.Args[2:])
+	case "snapshot":
+		snapshot(os.Args[2:])
+	case "diff":
+		diff(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: hwio pins [-json] [-header name]")
+	fmt.Fprintln(os.Stderr, "       hwio snapshot [-probe-i2c] [-o file]")
+	fmt.Fprintln(os.Stderr, "       hwio diff [-probe-i2c] before.json [after.json]")
+	os.Exit(2)
+}
+
+func pins(args []string) {
+	flags := flag.NewFlagSet("pins", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the pin map as JSON")
+	header := flags.String("header", "", "only show the diagram of this header")
+	flags.Parse(args)
+
+	pinMap := hwio.GetDefinedPins()
+	if pinMap == nil {
+		fmt.Fprintln(os.Stderr, "hwio: no driver for this hardware")
+		os.Exit(1)
+	}
+
+	switch {
+	case *asJSON:
+		b, e := pinMap.MarshalJSON()
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "hwio: %s\n", e)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
+	case *header != "":
+		d := pinMap.HeaderDiagram(*header)
+		if d == "" {
+			fmt.Fprintf(os.Stderr, "hwio: no header '%s'\n", *header)
+			os.Exit(1)
+		}
+		fmt.Print(d)
+	default:
+		if d := pinMap.HeaderDiagrams(); d != "" {
+			fmt.Println(d)
+		}
+		fmt.Print(pinMap.Table())
+	}
+}
+
+func snapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	probe := flags.Bool("probe-i2c", false, "probe the I2C buses for devices without a driver")
+	output := flags.String("o", "", "write the snapshot to this file")
+	flags.Parse(args)
+
+	s, e := hwio.TakeSnapshot(hwio.SnapshotOptions{ProbeI2C: *probe})
+	if e != nil {
+		fail(e)
+	}
+	if *output != "" {
+		if e = s.Save(*output); e != nil {
+			fail(e)
+		}
+		return
+	}
+	b, e := json.MarshalIndent(s, "", "  ")
+	if e != nil {
+		fail(e)
+	}
+	fmt.Println(string(b))
+}
+
+func diff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	probe := flags.Bool("probe-i2c", false, "probe the I2C buses for devices without a driver, if comparing with the current state")
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		usage()
+	}
+
+	before, e := hwio.LoadSnapshot(flags.Arg(0))
+	if e != nil {
+		fail(e)
+	}
+	var after *hwio.HardwareSnapshot
+	if flags.NArg() == 2 {
+		after, e = hwio.LoadSnapshot(flags.Arg(1))
+	} else {
+		after, e = hwio.TakeSnapshot(hwio.SnapshotOptions{ProbeI2C: *probe})
+	}
+	if e != nil {
+		fail(e)
+	}
+
+	changes := hwio.DiffSnapshots(before, after)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(e error) {
+	fmt.Fprintf(os.Stderr, "hwio: %s\n", e)
+	os.Exit(1)
+}
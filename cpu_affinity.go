@@ -0,0 +1,232 @@
+package hwio
+
+// Running realtime loops on a CPU of their own. On a multicore board, a tight control loop (e.g. 1kHz) is kept
+// clear of other work by isolating a CPU with the kernel's isolcpus parameter, so the scheduler puts nothing on it,
+// and nohz_full and rcu_nocbs, so the kernel's own timer ticks and callbacks don't interrupt it. Then the loop's
+// thread, and hwio's IO thread, are pinned to it:
+//
+//	config, e := hwio.GetRealtimeCPUConfig()
+//	for _, problem := range config.Check(3) {
+//		log.Printf("CPU 3 %s", problem)
+//	}
+//	hwio.SetIOThreadCPUs(3)
+//	go func() {
+//		hwio.LockThreadToCPUs(3)
+//		for range ticker.C {
+//			...
+//		}
+//	}()
+//
+// e.g. with "isolcpus=3 nohz_full=3 rcu_nocbs=3" added to /boot/cmdline.txt on a Raspberry Pi.
+//
+// Known issues:
+// - pinning doesn't stop interrupts being handled on the CPU; the irqaffinity parameter can move them
+// - a goroutine locked to a thread by LockThreadToCPUs keeps it until the goroutine exits, when the thread is
+//   discarded rather than going back to the runtime with its affinity
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var cpuSysfsPath = "/sys/devices/system/cpu"
+var kernelCmdlinePath = "/proc/cmdline"
+
+// The number of CPUs an affinity mask can hold, as the kernel's default CONFIG_NR_CPUS allows.
+const maxAffinityCPUs = 1024
+
+// How the CPUs of the system are set up for realtime loops.
+type RealtimeCPUConfig struct {
+	// CPUs that are online, isolated from the scheduler (isolcpus), without the scheduler's tick when they have one
+	// task (nohz_full), and without RCU callbacks (rcu_nocbs)
+	Online         []int
+	Isolated       []int
+	NoHzFull       []int
+	RCUNoCallbacks []int
+
+	// The CPUs that handle interrupts by default (irqaffinity), or nil if it isn't set, when all CPUs may
+	IRQAffinity []int
+
+	// The frequency governor of each CPU that has one, e.g. "performance"
+	Governors map[int]string
+}
+
+// Pin the IO thread, which runs time-critical IO, to some CPUs. It then only runs on those CPUs, and normally
+// should be given one that is isolated.
+func SetIOThreadCPUs(cpus ...int) error {
+	return runCriticalIO(func() error {
+		return setThreadAffinity(cpus)
+	})
+}
+
+// Lock the calling goroutine to its thread, and pin the thread to some CPUs, for a realtime loop. The goroutine
+// stays locked to the thread until it exits.
+func LockThreadToCPUs(cpus ...int) error {
+	runtime.LockOSThread()
+	return setThreadAffinity(cpus)
+}
+
+// Set the affinity of the calling thread.
+func setThreadAffinity(cpus []int) error {
+	if len(cpus) == 0 {
+		return errors.New("no CPUs to pin the thread to")
+	}
+	var mask [maxAffinityCPUs / 64]uint64
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maxAffinityCPUs {
+			return fmt.Errorf("CPU %d is out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	// pid 0 is the calling thread
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return fmt.Errorf("could not pin the thread to CPUs %s: %s", FormatCPUList(cpus), errno)
+	}
+	return nil
+}
+
+// Read how the CPUs are set up, from sysfs and the kernel command line.
+func GetRealtimeCPUConfig() (*RealtimeCPUConfig, error) {
+	config := &RealtimeCPUConfig{Governors: make(map[int]string)}
+
+	online, e := ioutil.ReadFile(filepath.Join(cpuSysfsPath, "online"))
+	if e != nil {
+		return nil, fmt.Errorf("could not read the online CPUs: %s", e)
+	}
+	if config.Online, e = ParseCPUList(string(online)); e != nil {
+		return nil, e
+	}
+
+	// isolated and nohz_full are missing on kernels built without support for them
+	for name, list := range map[string]*[]int{"isolated": &config.Isolated, "nohz_full": &config.NoHzFull} {
+		data, e := ioutil.ReadFile(filepath.Join(cpuSysfsPath, name))
+		if e != nil {
+			continue
+		}
+		if *list, e = ParseCPUList(string(data)); e != nil {
+			return nil, e
+		}
+	}
+
+	// rcu_nocbs and irqaffinity are only on the command line
+	cmdline, e := ioutil.ReadFile(kernelCmdlinePath)
+	if e != nil {
+		return nil, fmt.Errorf("could not read the kernel command line: %s", e)
+	}
+	for _, param := range strings.Fields(string(cmdline)) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "rcu_nocbs":
+			config.RCUNoCallbacks, e = ParseCPUList(kv[1])
+		case "irqaffinity":
+			config.IRQAffinity, e = ParseCPUList(kv[1])
+		}
+		if e != nil {
+			return nil, fmt.Errorf("could not parse %s on the kernel command line: %s", kv[0], e)
+		}
+	}
+
+	for _, cpu := range config.Online {
+		governor, e := ioutil.ReadFile(filepath.Join(cpuSysfsPath, fmt.Sprintf("cpu%d", cpu), "cpufreq", "scaling_governor"))
+		if e == nil {
+			config.Governors[cpu] = strings.TrimSpace(string(governor))
+		}
+	}
+	return config, nil
+}
+
+// Return what would disturb a realtime loop on a CPU, or nothing if it is set up well.
+func (config *RealtimeCPUConfig) Check(cpu int) []string {
+	var problems []string
+	if !containsCPU(config.Online, cpu) {
+		return []string{"not online"}
+	}
+	if !containsCPU(config.Isolated, cpu) {
+		problems = append(problems, "not isolated from the scheduler; add it to isolcpus")
+	}
+	if !containsCPU(config.NoHzFull, cpu) {
+		problems = append(problems, "has the scheduler tick; add it to nohz_full")
+	}
+	if !containsCPU(config.RCUNoCallbacks, cpu) {
+		problems = append(problems, "runs RCU callbacks; add it to rcu_nocbs")
+	}
+	if config.IRQAffinity == nil || containsCPU(config.IRQAffinity, cpu) {
+		problems = append(problems, "handles interrupts; set irqaffinity to the other CPUs")
+	}
+	if governor, ok := config.Governors[cpu]; ok && governor != "performance" {
+		problems = append(problems, fmt.Sprintf("frequency governor is %s, which changes its speed; use performance", governor))
+	}
+	if len(config.Online) == len(config.Isolated) {
+		problems = append(problems, "all CPUs are isolated, leaving none for the rest of the system")
+	}
+	return problems
+}
+
+func containsCPU(cpus []int, cpu int) bool {
+	for _, c := range cpus {
+		if c == cpu {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a list of CPUs in the kernel's format, e.g. "0-2,5", as used by isolcpus and sysfs. Flags before the list,
+// such as isolcpus's "domain,managed_irq,", are skipped.
+func ParseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" || (part[0] < '0' || part[0] > '9') {
+			continue
+		}
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, e := strconv.Atoi(first)
+		if e != nil {
+			return nil, fmt.Errorf("bad CPU list '%s'", list)
+		}
+		to, e := strconv.Atoi(last)
+		if e != nil || to < from {
+			return nil, fmt.Errorf("bad CPU list '%s'", list)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// Format a list of CPUs in the kernel's format, e.g. "0-2,5".
+func FormatCPUList(cpus []int) string {
+	sorted := append([]int(nil), cpus...)
+	sort.Ints(sorted)
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
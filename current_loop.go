@@ -0,0 +1,131 @@
+package hwio
+
+// Helpers for 4-20mA current loops, the usual output of industrial transmitters for pressure, level, flow and the
+// like. The loop current flows through a shunt resistor, and an analog pin reads the voltage across it; a 250 ohm
+// shunt gives 1-5V, and a 150 ohm shunt 0.6-3V. CurrentLoop converts the reading to the loop current and the
+// measurement it represents, and detects faults using the NAMUR NE 43 limits, so a broken wire, which reads as no
+// current, isn't mistaken for the bottom of the range.
+
+import (
+	"errors"
+)
+
+// Limits of the loop current in milliamps, from NAMUR NE 43. Below 3.6mA or above 21mA is a fault; between those
+// and the 3.8mA to 20.5mA measuring range the measurement is saturated but still valid.
+const (
+	loopFaultLow    = 3.6
+	loopRangeLow    = 3.8
+	loopRangeHigh   = 20.5
+	loopFaultHigh   = 21.0
+	loopCurrentZero = 4.0
+	loopCurrentSpan = 16.0
+)
+
+// The state of a current loop, from its current.
+type CurrentLoopFault int
+
+const (
+	// The current is between 3.8mA and 20.5mA.
+	LoopOK CurrentLoopFault = iota
+
+	// The current is between 3.6mA and 3.8mA, so the measurement is below the transmitter's range.
+	LoopUnderRange
+
+	// The current is between 20.5mA and 21mA, so the measurement is above the transmitter's range.
+	LoopOverRange
+
+	// The current is below 3.6mA: the loop is open, from a broken wire or a missing supply, or the transmitter
+	// is signalling a failure.
+	LoopOpen
+
+	// The current is above 21mA: the loop is shorted, or the transmitter is signalling a failure.
+	LoopShorted
+)
+
+// String representation of a current loop fault
+func (fault CurrentLoopFault) String() string {
+	switch fault {
+	case LoopOK:
+		return "OK"
+	case LoopUnderRange:
+		return "UnderRange"
+	case LoopOverRange:
+		return "OverRange"
+	case LoopOpen:
+		return "Open"
+	case LoopShorted:
+		return "Shorted"
+	}
+	return ""
+}
+
+// A 4-20mA input, read through a shunt resistor on an analog pin.
+type CurrentLoop struct {
+	// The analog pin the shunt voltage is read from
+	Pin Pin
+
+	// The resistance of the shunt, in ohms
+	ShuntOhms float64
+
+	// The voltage of one count of the analog pin, e.g. 1.8/4096 for a 12 bit ADC with a 1.8V reference.
+	VoltsPerCount float64
+
+	// The measurements the transmitter represents by 4mA and 20mA, e.g. 0 and 10 for a 0-10 bar pressure
+	// transmitter. If both are zero, Value is the fraction of the range, 0 to 1.
+	Min float64
+	Max float64
+}
+
+// A reading of a current loop.
+type CurrentLoopReading struct {
+	// The analog pin's reading, and the loop current in milliamps it represents
+	Raw       int
+	MilliAmps float64
+
+	// The measurement, scaled from 4-20mA to Min-Max. This is worked out for all readings, but it is meaningless
+	// when Valid returns false.
+	Value float64
+
+	Fault CurrentLoopFault
+}
+
+// Determine if the reading can be used, which it can unless the loop is open or shorted.
+func (r CurrentLoopReading) Valid() bool {
+	return r.Fault != LoopOpen && r.Fault != LoopShorted
+}
+
+// Read the analog pin, and convert the reading.
+func (loop CurrentLoop) Read() (CurrentLoopReading, error) {
+	if loop.ShuntOhms <= 0 || loop.VoltsPerCount <= 0 {
+		return CurrentLoopReading{}, errors.New("current loop needs the shunt resistance and volts per count")
+	}
+	raw, e := AnalogRead(loop.Pin)
+	if e != nil {
+		return CurrentLoopReading{}, e
+	}
+	return loop.Convert(raw), nil
+}
+
+// Convert an analog reading to the loop current and the measurement, and check the current for faults.
+func (loop CurrentLoop) Convert(raw int) CurrentLoopReading {
+	r := CurrentLoopReading{Raw: raw}
+	r.MilliAmps = float64(raw) * loop.VoltsPerCount / loop.ShuntOhms * 1000
+
+	min, max := loop.Min, loop.Max
+	if min == 0 && max == 0 {
+		max = 1
+	}
+	r.Value = min + (r.MilliAmps-loopCurrentZero)/loopCurrentSpan*(max-min)
+
+	switch {
+	case r.MilliAmps < loopFaultLow:
+		r.Fault = LoopOpen
+	case r.MilliAmps < loopRangeLow:
+		r.Fault = LoopUnderRange
+	case r.MilliAmps > loopFaultHigh:
+		r.Fault = LoopShorted
+	case r.MilliAmps > loopRangeHigh:
+		r.Fault = LoopOverRange
+	}
+	return r
+}
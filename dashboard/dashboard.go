@@ -0,0 +1,195 @@
+// A web dashboard for demos and commissioning: a live diagram of the board's headers showing the state of each pin,
+// buttons to toggle outputs, and a strip chart of the analog inputs. It is an http.Handler, so it can be served on
+// its own or mounted in a program's own web server:
+//
+//	http.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboard.New()))
+//	http.ListenAndServe(":8080", nil)
+//
+// The page gets the pin map from /api/pins, and the states of the pins as a stream of server-sent events from
+// /api/events, which work through proxies and need nothing beyond the standard library. Outputs are toggled by
+// POSTing to /api/write.
+//
+// Current status:
+// - only outputs set up with PinMode can be toggled; pins used by other modules are shown but not read
+// - there is no authentication, so anyone who can reach the page can change outputs
+
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+// How often the states of the pins are sent, if the dashboard isn't given an interval.
+const defaultInterval = 200 * time.Millisecond
+
+//go:embed index.html
+var static embed.FS
+
+type Dashboard struct {
+	// How often the states of the pins are sent to each page.
+	Interval time.Duration
+
+	// Whether outputs can be toggled from the page.
+	ReadOnly bool
+
+	// serialises the dashboard's use of hwio between requests
+	sync.Mutex
+
+	mux *http.ServeMux
+}
+
+// A pin, as the pin map's JSON has it.
+type pinJSON struct {
+	Pin     hwio.Pin `json:"pin"`
+	Modules []string `json:"modules"`
+	Mode    string   `json:"mode"`
+}
+
+// The states of the pins, sent as each event.
+type stateJSON struct {
+	Time    time.Time        `json:"time"`
+	Digital map[hwio.Pin]int `json:"digital"`
+	Analog  map[hwio.Pin]int `json:"analog"`
+}
+
+// Create a dashboard for the default board.
+func New() *Dashboard {
+	d := &Dashboard{Interval: defaultInterval, mux: http.NewServeMux()}
+	d.mux.Handle("/", http.FileServer(http.FS(static)))
+	d.mux.HandleFunc("/api/pins", d.servePins)
+	d.mux.HandleFunc("/api/events", d.serveEvents)
+	d.mux.HandleFunc("/api/write", d.serveWrite)
+	return d
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// Send the pin map, as HardwarePinMap's JSON, with whether outputs can be toggled.
+func (d *Dashboard) servePins(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	pins, e := hwio.GetDefinedPins().MarshalJSON()
+	d.Unlock()
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"readOnly":%t,"pins":%s}`, d.ReadOnly, pins)
+}
+
+// Send the states of the pins as server-sent events, until the page goes away.
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b, e := json.Marshal(d.state())
+		if e != nil {
+			return
+		}
+		_, e = fmt.Fprintf(w, "data: %s\n\n", b)
+		if e != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Set an output, given by the form values pin, its number, and value, 0 or 1.
+func (d *Dashboard) serveWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if d.ReadOnly {
+		http.Error(w, "the dashboard is read only", http.StatusForbidden)
+		return
+	}
+	pin, e1 := strconv.Atoi(r.FormValue("pin"))
+	value, e2 := strconv.Atoi(r.FormValue("value"))
+	if e1 != nil || e2 != nil || (value != hwio.Low && value != hwio.High) {
+		http.Error(w, "pin and value must be numbers, and value 0 or 1", http.StatusBadRequest)
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	p, ok := d.pins()[hwio.Pin(pin)]
+	if !ok || p.Mode != hwio.Output.String() {
+		http.Error(w, fmt.Sprintf("pin %d is not an output", pin), http.StatusConflict)
+		return
+	}
+	e := hwio.DigitalWrite(hwio.Pin(pin), value)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Read the pins set up with PinMode, and the analog inputs.
+func (d *Dashboard) state() *stateJSON {
+	d.Lock()
+	defer d.Unlock()
+
+	state := &stateJSON{Time: time.Now(), Digital: make(map[hwio.Pin]int), Analog: make(map[hwio.Pin]int)}
+	for pin, p := range d.pins() {
+		if p.Mode != "" {
+			if value, e := hwio.DigitalRead(pin); e == nil {
+				state.Digital[pin] = value
+			}
+			continue
+		}
+		for _, m := range p.Modules {
+			if m != "analog" {
+				continue
+			}
+			if value, e := hwio.AnalogRead(pin); e == nil {
+				state.Analog[pin] = value
+			}
+		}
+	}
+	return state
+}
+
+// Return the pins of the pin map, with the modes they were set to. The caller must hold the lock.
+func (d *Dashboard) pins() map[hwio.Pin]*pinJSON {
+	result := make(map[hwio.Pin]*pinJSON)
+	b, e := hwio.GetDefinedPins().MarshalJSON()
+	if e != nil {
+		return result
+	}
+	var pins []*pinJSON
+	json.Unmarshal(b, &pins)
+	for _, p := range pins {
+		result[p.Pin] = p
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cinellodev/hwio"
+)
+
+func TestDashboard(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	led, _ := hwio.GetPin("gpio1")
+	button, _ := hwio.GetPin("gpio2")
+
+	// the mock GPIO module doesn't assign pins itself, and the pin map only has modes for assigned pins
+	gpio, _ := hwio.GetGPIOModule()
+	hwio.AssignPins(hwio.PinList{led, button}, gpio)
+	hwio.PinMode(led, hwio.Output)
+	hwio.PinMode(button, hwio.Input)
+
+	server := httptest.NewServer(New())
+	defer server.Close()
+
+	r, e := http.Get(server.URL + "/")
+	if e != nil || r.StatusCode != http.StatusOK {
+		t.Fatalf("could not get the page: %v %v", r, e)
+	}
+	r.Body.Close()
+
+	r, e = http.Get(server.URL + "/api/pins")
+	if e != nil {
+		t.Fatalf("could not get the pins: %s", e)
+	}
+	var pins struct {
+		ReadOnly bool
+		Pins     []pinJSON
+	}
+	json.NewDecoder(r.Body).Decode(&pins)
+	r.Body.Close()
+	if pins.ReadOnly || len(pins.Pins) != len(hwio.GetDefinedPins()) {
+		t.Errorf("unexpected pins %+v", pins)
+	}
+
+	// outputs can be set, other pins can't
+	r, _ = http.PostForm(server.URL+"/api/write", url.Values{"pin": {strconv.Itoa(int(led))}, "value": {"1"}})
+	if r.StatusCode != http.StatusNoContent {
+		t.Errorf("writing the output returned %s", r.Status)
+	}
+	if v, _ := hwio.DigitalRead(led); v != hwio.High {
+		t.Errorf("led is %d, expected High", v)
+	}
+	r, _ = http.PostForm(server.URL+"/api/write", url.Values{"pin": {strconv.Itoa(int(button))}, "value": {"1"}})
+	if r.StatusCode != http.StatusConflict {
+		t.Errorf("writing an input returned %s", r.Status)
+	}
+
+	// the first event has the states of the pins set up with PinMode
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/events", nil)
+	r, e = http.DefaultClient.Do(request)
+	if e != nil {
+		t.Fatalf("could not get events: %s", e)
+	}
+	defer r.Body.Close()
+	line, _ := bufio.NewReader(r.Body).ReadString('\n')
+	var state stateJSON
+	e = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &state)
+	if e != nil {
+		t.Fatalf("could not decode event %q: %s", line, e)
+	}
+	if len(state.Digital) != 2 || state.Digital[led] != hwio.High || state.Digital[button] != hwio.Low {
+		t.Errorf("unexpected digital states %v", state.Digital)
+	}
+}
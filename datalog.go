@@ -0,0 +1,261 @@
+// A data logger, which samples pins and sensors at an interval and writes the readings to one or more sinks: CSV
+// files, InfluxDB line protocol (to a file or an InfluxDB server), or an SQL database. Samples are buffered and
+// written in batches, which keeps writes to an SD card few and large, and file sinks can rotate their files by size
+// or age.
+
+package hwio
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Something to log: a name, used as the CSV column or field name, and a function returning the current value.
+// LogDigital and LogAnalog make channels for pins; a sensor can be logged with a function that reads it, or with
+// LogTemperature etc. if it returns a measurement.
+type LogChannel struct {
+	Name string
+	Read func() (float64, error)
+
+	// The unit of the values, e.g. "°C", or "" if they have none. Sinks that support units record it.
+	Unit string
+}
+
+// A channel logging the level of a digital pin, 0 or 1.
+func LogDigital(name string, pin Pin) LogChannel {
+	return LogChannel{Name: name, Read: func() (float64, error) {
+		v, e := DigitalRead(pin)
+		return float64(v), e
+	}}
+}
+
+// A channel logging the reading of an analog pin.
+func LogAnalog(name string, pin Pin) LogChannel {
+	return LogChannel{Name: name, Read: func() (float64, error) {
+		v, e := AnalogRead(pin)
+		return float64(v), e
+	}}
+}
+
+// A channel logging a temperature, in degrees Celsius.
+func LogTemperature(name string, read func() (Temperature, error)) LogChannel {
+	return LogChannel{Name: name, Unit: Temperature(0).Unit(), Read: func() (float64, error) {
+		v, e := read()
+		return v.BaseValue(), e
+	}}
+}
+
+// A channel logging a voltage, in volts.
+func LogVoltage(name string, read func() (Voltage, error)) LogChannel {
+	return LogChannel{Name: name, Unit: Voltage(0).Unit(), Read: func() (float64, error) {
+		v, e := read()
+		return v.BaseValue(), e
+	}}
+}
+
+// A channel logging a relative humidity, in percent.
+func LogHumidity(name string, read func() (Humidity, error)) LogChannel {
+	return LogChannel{Name: name, Unit: Humidity(0).Unit(), Read: func() (float64, error) {
+		v, e := read()
+		return v.BaseValue(), e
+	}}
+}
+
+// A channel logging a pressure, in pascals.
+func LogPressure(name string, read func() (Pressure, error)) LogChannel {
+	return LogChannel{Name: name, Unit: Pressure(0).Unit(), Read: func() (float64, error) {
+		v, e := read()
+		return v.BaseValue(), e
+	}}
+}
+
+// A channel logging a distance, in metres.
+func LogDistance(name string, read func() (Distance, error)) LogChannel {
+	return LogChannel{Name: name, Unit: Distance(0).Unit(), Read: func() (float64, error) {
+		v, e := read()
+		return v.BaseValue(), e
+	}}
+}
+
+// A sample of all the channels of a logger. A channel that could not be read is NaN.
+type LogRecord struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Where a logger writes its records. names are the names of the channels, in the order of each record's values.
+// Sinks are called from one goroutine at a time, and must not keep the records after WriteRecords returns.
+type LogSink interface {
+	WriteRecords(names []string, records []LogRecord) error
+	Close() error
+}
+
+// Sinks that record the units of channels implement this interface. The logger sets the units before it writes any
+// records.
+type UnitLogSink interface {
+	LogSink
+
+	// Set the units of the channels, in the order of each record's values. A channel without a unit has "".
+	SetUnits(units []string)
+}
+
+type DataLoggerConfig struct {
+	Channels []LogChannel
+
+	// How often to sample the channels
+	Interval time.Duration
+
+	Sinks []LogSink
+
+	// Records are written to the sinks when this many have been buffered, and every FlushInterval. Default to 60
+	// records and one minute.
+	BufferSize    int
+	FlushInterval time.Duration
+}
+
+type DataLogger struct {
+	config DataLoggerConfig
+	names  []string
+
+	// guards buffer and err, and serialises writes to the sinks
+	sync.Mutex
+	buffer []LogRecord
+	err    error
+
+	stop chan bool
+	done chan bool
+}
+
+// Start logging. The first sample is taken straight away.
+func NewDataLogger(config DataLoggerConfig) (*DataLogger, error) {
+	if len(config.Channels) == 0 {
+		return nil, errors.New("data logger needs channels to log")
+	}
+	if len(config.Sinks) == 0 {
+		return nil, errors.New("data logger needs sinks to write to")
+	}
+	if config.Interval <= 0 {
+		return nil, errors.New("data logger needs an interval to sample at")
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 60
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Minute
+	}
+
+	l := &DataLogger{config: config, stop: make(chan bool), done: make(chan bool)}
+	units := make([]string, 0, len(config.Channels))
+	for _, c := range config.Channels {
+		l.names = append(l.names, c.Name)
+		units = append(units, c.Unit)
+	}
+	for _, sink := range config.Sinks {
+		if us, ok := sink.(UnitLogSink); ok {
+			us.SetUnits(units)
+		}
+	}
+	go l.run()
+	return l, nil
+}
+
+// Write the buffered records to the sinks now.
+func (l *DataLogger) Flush() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.flush()
+}
+
+// Stop logging, write the buffered records, and close the sinks.
+func (l *DataLogger) Close() error {
+	select {
+	case <-l.stop:
+		return nil
+	default:
+	}
+	close(l.stop)
+	<-l.done
+
+	l.Lock()
+	defer l.Unlock()
+	e := l.flush()
+	for _, sink := range l.config.Sinks {
+		if ce := sink.Close(); e == nil {
+			e = ce
+		}
+	}
+	return e
+}
+
+// Return the last error writing to a sink, or nil if there hasn't been one. Records a sink failed to write are
+// dropped for that sink, so the others carry on.
+func (l *DataLogger) Err() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.err
+}
+
+func (l *DataLogger) run() {
+	defer close(l.done)
+
+	ticker := clock.NewTicker(l.config.Interval)
+	defer ticker.Stop()
+	flushTimer := clock.NewTimer(l.config.FlushInterval)
+	defer flushTimer.Stop()
+
+	l.sample()
+	for {
+		select {
+		case <-ticker.C():
+			l.sample()
+		case <-flushTimer.C():
+			l.Flush()
+			flushTimer.Reset(l.config.FlushInterval)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Read the channels, as bulk IO, and write the buffer if it's full.
+func (l *DataLogger) sample() {
+	record := LogRecord{Time: clock.Now(), Values: make([]float64, len(l.config.Channels))}
+	for i, c := range l.config.Channels {
+		var v float64
+		e := runBulkIO(func() (e error) {
+			v, e = c.Read()
+			return e
+		})
+		if e != nil {
+			v = math.NaN()
+		}
+		record.Values[i] = v
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.buffer = append(l.buffer, record)
+	if len(l.buffer) >= l.config.BufferSize {
+		l.flush()
+	}
+}
+
+// Write the buffered records to each sink. The logger must be locked.
+func (l *DataLogger) flush() error {
+	if len(l.buffer) == 0 {
+		return nil
+	}
+
+	var result error
+	for _, sink := range l.config.Sinks {
+		e := sink.WriteRecords(l.names, l.buffer)
+		if e != nil {
+			l.err = e
+			result = e
+		}
+	}
+	l.buffer = l.buffer[:0]
+	return result
+}
@@ -0,0 +1,357 @@
+package hwio
+
+// Sinks for the data logger: CSV files, InfluxDB line protocol files, an InfluxDB server, and SQL databases.
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The suffix added to a log file's name when it is rotated: the time it was started.
+const logRotationSuffix = "20060102-150405.000"
+
+// When a file sink starts a new file. The current file is renamed with the time it was started added to its name,
+// e.g. "log.csv.20240131-120000.000", and a new one started.
+type LogRotation struct {
+	// Start a new file before the current one grows past this many bytes. 0 means no limit.
+	MaxSize int64
+
+	// Start a new file when the current one is this old. 0 means no limit.
+	MaxAge time.Duration
+
+	// How many rotated files to keep, deleting the oldest. 0 keeps them all.
+	MaxFiles int
+}
+
+// A file written by a sink, rotated according to a LogRotation.
+type rotatingFile struct {
+	path     string
+	rotation LogRotation
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// Append to the file, starting a new one first if it's due. If header is not nil, it is written at the start of
+// each new file.
+func (f *rotatingFile) write(b []byte, header []byte) error {
+	if f.file != nil && f.due(len(b)) {
+		e := f.rotate()
+		if e != nil {
+			return e
+		}
+	}
+
+	if f.file == nil {
+		file, e := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if e != nil {
+			return e
+		}
+		info, e := file.Stat()
+		if e != nil {
+			file.Close()
+			return e
+		}
+		f.file, f.size, f.opened = file, info.Size(), time.Now()
+
+		if f.size == 0 && header != nil {
+			b = append(header, b...)
+		}
+	}
+
+	n, e := f.file.Write(b)
+	f.size += int64(n)
+	return e
+}
+
+// Determine if the file should be rotated before writing n more bytes.
+func (f *rotatingFile) due(n int) bool {
+	if f.rotation.MaxSize > 0 && f.size > 0 && f.size+int64(n) > f.rotation.MaxSize {
+		return true
+	}
+	return f.rotation.MaxAge > 0 && time.Since(f.opened) >= f.rotation.MaxAge
+}
+
+// Rename the current file, and delete the oldest rotated files beyond the number to keep.
+func (f *rotatingFile) rotate() error {
+	e := f.close()
+	if e != nil {
+		return e
+	}
+	e = os.Rename(f.path, f.path+"."+f.opened.Format(logRotationSuffix))
+	if e != nil {
+		return e
+	}
+	if f.rotation.MaxFiles <= 0 {
+		return nil
+	}
+
+	rotated := f.rotated()
+	for len(rotated) > f.rotation.MaxFiles {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Return the rotated files, oldest first.
+func (f *rotatingFile) rotated() []string {
+	infos, _ := ioutil.ReadDir(filepath.Dir(f.path))
+	base := filepath.Base(f.path) + "."
+	pattern := regexp.MustCompile(`^\d{8}-\d{6}\.\d{3}$`)
+
+	result := make([]string, 0)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), base) && pattern.MatchString(info.Name()[len(base):]) {
+			result = append(result, filepath.Join(filepath.Dir(f.path), info.Name()))
+		}
+	}
+	// the suffix sorts by time
+	sort.Strings(result)
+	return result
+}
+
+func (f *rotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	e := f.file.Close()
+	f.file = nil
+	return e
+}
+
+// Writes records to a CSV file, one row per record, with the time first, then the channels. Each file starts with
+// a header row of the channel names, followed by their units in brackets if they have them, e.g. "supply (V)".
+// Channels that could not be read are left empty.
+type CSVSink struct {
+	file  *rotatingFile
+	units []string
+}
+
+func NewCSVSink(path string, rotation LogRotation) *CSVSink {
+	return &CSVSink{file: &rotatingFile{path: path, rotation: rotation}}
+}
+
+func (s *CSVSink) WriteRecords(names []string, records []LogRecord) error {
+	var header, rows bytes.Buffer
+	hw := csv.NewWriter(&header)
+	columns := append([]string{"time"}, names...)
+	for i, unit := range s.units {
+		if unit != "" && i < len(names) {
+			columns[i+1] += " (" + unit + ")"
+		}
+	}
+	hw.Write(columns)
+	hw.Flush()
+
+	w := csv.NewWriter(&rows)
+	row := make([]string, len(names)+1)
+	for _, r := range records {
+		row[0] = r.Time.Format(time.RFC3339Nano)
+		for i, v := range r.Values {
+			row[i+1] = formatLogValue(v)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+
+	return s.file.write(rows.Bytes(), header.Bytes())
+}
+
+func (s *CSVSink) SetUnits(units []string) {
+	s.units = units
+}
+
+func (s *CSVSink) Close() error {
+	return s.file.close()
+}
+
+// Writes records to a file in InfluxDB line protocol, one line per record, with the channels as fields. The file
+// can be loaded into InfluxDB later, or sent by Telegraf.
+type LineProtocolSink struct {
+	file        *rotatingFile
+	measurement string
+	tags        string
+}
+
+// Create a line protocol sink, writing a measurement with the given tags, which can be nil.
+func NewLineProtocolSink(path string, measurement string, tags map[string]string, rotation LogRotation) *LineProtocolSink {
+	return &LineProtocolSink{
+		file:        &rotatingFile{path: path, rotation: rotation},
+		measurement: measurement,
+		tags:        formatLineProtocolTags(tags),
+	}
+}
+
+func (s *LineProtocolSink) WriteRecords(names []string, records []LogRecord) error {
+	return s.file.write(formatLineProtocol(s.measurement, s.tags, names, records), nil)
+}
+
+func (s *LineProtocolSink) Close() error {
+	return s.file.close()
+}
+
+// Sends records to an InfluxDB server in line protocol, a batch per request.
+type InfluxDBSink struct {
+	url         string
+	token       string
+	measurement string
+	tags        string
+	client      *http.Client
+}
+
+// Create a sink sending to an InfluxDB write URL, e.g. "http://influx:8086/api/v2/write?org=home&bucket=sensors"
+// for InfluxDB 2, or "http://influx:8086/write?db=sensors" for 1.x. token is sent as the authorization token, if
+// it's not "".
+func NewInfluxDBSink(url string, token string, measurement string, tags map[string]string) *InfluxDBSink {
+	return &InfluxDBSink{
+		url:         url,
+		token:       token,
+		measurement: measurement,
+		tags:        formatLineProtocolTags(tags),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *InfluxDBSink) WriteRecords(names []string, records []LogRecord) error {
+	body := formatLineProtocol(s.measurement, s.tags, names, records)
+	if len(body) == 0 {
+		return nil
+	}
+
+	request, e := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if e != nil {
+		return e
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		request.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	response, e := s.client.Do(request)
+	if e != nil {
+		return e
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("InfluxDB write failed with %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (s *InfluxDBSink) Close() error {
+	return nil
+}
+
+// Writes records to a table of an SQL database, one row per value, with columns time, channel and value. The
+// database is opened by the application with the driver of its choice, such as SQLite; the insert uses "?"
+// placeholders, as SQLite and MySQL do.
+type SQLSink struct {
+	db     *sql.DB
+	insert string
+}
+
+// Create an SQL sink writing to a table, creating the table if it doesn't exist.
+func NewSQLSink(db *sql.DB, table string) (*SQLSink, error) {
+	if !regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`).MatchString(table) {
+		return nil, fmt.Errorf("'%s' is not a valid table name", table)
+	}
+	_, e := db.Exec("CREATE TABLE IF NOT EXISTS " + table + " (time TIMESTAMP NOT NULL, channel TEXT NOT NULL, value REAL)")
+	if e != nil {
+		return nil, e
+	}
+	return &SQLSink{db: db, insert: "INSERT INTO " + table + " (time, channel, value) VALUES (?, ?, ?)"}, nil
+}
+
+// Insert the records in one transaction. Channels that could not be read are left out.
+func (s *SQLSink) WriteRecords(names []string, records []LogRecord) error {
+	tx, e := s.db.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(s.insert)
+	if e != nil {
+		tx.Rollback()
+		return e
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		for i, v := range r.Values {
+			if math.IsNaN(v) {
+				continue
+			}
+			_, e = stmt.Exec(r.Time, names[i], v)
+			if e != nil {
+				tx.Rollback()
+				return e
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// The database is left open, as it belongs to the application.
+func (s *SQLSink) Close() error {
+	return nil
+}
+
+// Format a value for a CSV file: empty if it could not be read.
+func formatLogValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var lineProtocolEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// Format tags for line protocol, sorted by key as InfluxDB prefers, with a leading comma, or "" if there are none.
+func formatLineProtocolTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := ""
+	for _, k := range keys {
+		s += "," + lineProtocolEscaper.Replace(k) + "=" + lineProtocolEscaper.Replace(tags[k])
+	}
+	return s
+}
+
+// Format records as line protocol, with nanosecond timestamps. Channels that could not be read are left out, as
+// is a record with none that could.
+func formatLineProtocol(measurement string, tags string, names []string, records []LogRecord) []byte {
+	var b bytes.Buffer
+	measurement = strings.NewReplacer(",", `\,`, " ", `\ `).Replace(measurement)
+
+	for _, r := range records {
+		fields := make([]string, 0, len(names))
+		for i, v := range r.Values {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				fields = append(fields, lineProtocolEscaper.Replace(names[i])+"="+strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s %s %d\n", measurement, tags, strings.Join(fields, ","), r.Time.UnixNano())
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,513 @@
+package hwio
+
+// A bit-banged JTAG and SWD debug probe on GPIO pins, for programming jigs that flash or test microcontrollers from
+// an SBC. DebugProbe drives the pins directly, for simple checks such as reading a target's JTAG IDCODE or SWD
+// DPIDR, and ServeRemoteBitbang lets OpenOCD drive them over its remote_bitbang protocol, for everything else:
+//
+//	openocd -c "adapter driver remote_bitbang; remote_bitbang port 3335; transport select swd" -f target/stm32f1x.cfg
+//
+// Every bit is a few GPIO writes, so expect tens to hundreds of kHz with the cdev module; enough to flash small
+// microcontrollers in a few seconds. TCK doubles as SWCLK, and TMS as SWDIO, as on the standard debug connectors.
+// The reset lines are open drain: they are driven low to assert them, and released as inputs with a pull-up.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+type DebugProbeConfig struct {
+	// The JTAG clock and mode select, which are also the SWD clock and data.
+	TCK Pin
+	TMS Pin
+
+	// The JTAG data pins, if UseJTAG is true. Only TCK and TMS are needed for SWD.
+	TDI     Pin
+	TDO     Pin
+	UseJTAG bool
+
+	// The optional JTAG TAP reset and system reset pins, both active low.
+	TRST    Pin
+	UseTRST bool
+	SRST    Pin
+	UseSRST bool
+
+	// An optional LED, which OpenOCD turns on while it is connected.
+	LED    Pin
+	UseLED bool
+}
+
+// A JTAG and SWD probe.
+type DebugProbe struct {
+	config DebugProbeConfig
+
+	// whether SWDIO is driven by the probe; otherwise the target drives it
+	swdioOut bool
+}
+
+// SWD acknowledgements.
+const (
+	swdAckOK    = 1
+	swdAckWait  = 2
+	swdAckFault = 4
+)
+
+// Returned when an SWD transfer is acknowledged with WAIT, so the target is busy and the transfer should be tried
+// again.
+var ErrSWDWait = errors.New("SWD target replied WAIT")
+
+// Create a debug probe, setting up its pins. The reset lines are released.
+func NewDebugProbe(config DebugProbeConfig) (*DebugProbe, error) {
+	p := &DebugProbe{config: config, swdioOut: true}
+
+	e := PinModeOutputInit(config.TCK, Low)
+	if e == nil {
+		e = PinModeOutputInit(config.TMS, High)
+	}
+	if e == nil && config.UseJTAG {
+		e = PinModeOutputInit(config.TDI, Low)
+		if e == nil {
+			e = PinMode(config.TDO, Input)
+		}
+	}
+	if e == nil && config.UseLED {
+		e = PinModeOutputInit(config.LED, Low)
+	}
+	if e == nil {
+		e = p.Reset(false, false)
+	}
+	if e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+// Release the probe's pins, leaving the reset lines released.
+func (p *DebugProbe) Close() error {
+	var first error
+	for _, pin := range p.pins() {
+		if e := ClosePin(pin); e != nil && first == nil {
+			first = e
+		}
+	}
+	return first
+}
+
+// Return the pins the probe uses.
+func (p *DebugProbe) pins() PinList {
+	c := p.config
+	pins := PinList{c.TCK, c.TMS}
+	if c.UseJTAG {
+		pins = append(pins, c.TDI, c.TDO)
+	}
+	if c.UseTRST {
+		pins = append(pins, c.TRST)
+	}
+	if c.UseSRST {
+		pins = append(pins, c.SRST)
+	}
+	if c.UseLED {
+		pins = append(pins, c.LED)
+	}
+	return pins
+}
+
+// Assert or release the TAP reset and system reset lines. Lines the probe doesn't have are ignored.
+func (p *DebugProbe) Reset(trst bool, srst bool) error {
+	if p.config.UseTRST {
+		if e := setOpenDrain(p.config.TRST, trst); e != nil {
+			return e
+		}
+	}
+	if p.config.UseSRST {
+		if e := setOpenDrain(p.config.SRST, srst); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Drive an active low, open drain line low if asserted, otherwise let it be pulled up.
+func setOpenDrain(pin Pin, asserted bool) error {
+	if asserted {
+		return PinModeOutputInit(pin, Low)
+	}
+	return PinMode(pin, InputPullUp)
+}
+
+// Set TCK, TMS and TDI.
+func (p *DebugProbe) write(tck int, tms int, tdi int) error {
+	e := DigitalWrite(p.config.TCK, tck)
+	if e == nil {
+		e = DigitalWrite(p.config.TMS, tms)
+	}
+	if e == nil && p.config.UseJTAG {
+		e = DigitalWrite(p.config.TDI, tdi)
+	}
+	return e
+}
+
+// Clock one JTAG bit: set TMS and TDI with TCK low, sample TDO, and raise TCK.
+func (p *DebugProbe) Clock(tms int, tdi int) (tdo int, e error) {
+	if !p.config.UseJTAG {
+		return 0, errors.New("debug probe has no JTAG data pins")
+	}
+	e = p.write(Low, tms, tdi)
+	if e == nil {
+		tdo, e = DigitalRead(p.config.TDO)
+	}
+	if e == nil {
+		e = p.write(High, tms, tdi)
+	}
+	return tdo, e
+}
+
+// Clock a sequence of TMS values, least significant bit first, with TDI low.
+func (p *DebugProbe) clockTMS(bits uint, n int) error {
+	for i := 0; i < n; i++ {
+		if _, e := p.Clock(int(bits>>uint(i))&1, Low); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Reset the JTAG TAP with five clocks of TMS high, and move it to Run-Test/Idle. This selects each device's IDCODE
+// register, if it has one.
+func (p *DebugProbe) ResetTAP() error {
+	return p.clockTMS(0x1f, 6)
+}
+
+// Shift bits through the instruction register, from Run-Test/Idle and back, returning the bits shifted out. Bits
+// are least significant first within each byte.
+func (p *DebugProbe) ShiftIR(tdi []byte, bits int) ([]byte, error) {
+	return p.shift(0x3, 4, tdi, bits)
+}
+
+// Shift bits through the selected data register, from Run-Test/Idle and back, returning the bits shifted out.
+func (p *DebugProbe) ShiftDR(tdi []byte, bits int) ([]byte, error) {
+	return p.shift(0x1, 3, tdi, bits)
+}
+
+// Move from Run-Test/Idle to a shift state with a TMS sequence, shift the bits, and go back to Run-Test/Idle.
+func (p *DebugProbe) shift(enter uint, enterBits int, tdi []byte, bits int) ([]byte, error) {
+	if bits <= 0 || len(tdi)*8 < bits {
+		return nil, fmt.Errorf("JTAG shift of %d bits needs %d bytes of data", bits, (bits+7)/8)
+	}
+	e := p.clockTMS(enter, enterBits)
+	if e != nil {
+		return nil, e
+	}
+
+	tdo := make([]byte, (bits+7)/8)
+	for i := 0; i < bits; i++ {
+		// the last bit leaves the shift state, for Exit1
+		tms := 0
+		if i == bits-1 {
+			tms = 1
+		}
+		bit, e := p.Clock(tms, int(tdi[i/8]>>uint(i%8))&1)
+		if e != nil {
+			return nil, e
+		}
+		tdo[i/8] |= byte(bit) << uint(i%8)
+	}
+
+	// Exit1 to Update, then Run-Test/Idle
+	return tdo, p.clockTMS(0x1, 2)
+}
+
+// Reset the TAP and read the 32 bit IDCODE of the first device on the chain. A chain with nothing on it, or a
+// device without an IDCODE, returns 0 or 0xffffffff.
+func (p *DebugProbe) IDCODE() (uint32, error) {
+	e := p.ResetTAP()
+	if e != nil {
+		return 0, e
+	}
+	b, e := p.ShiftDR([]byte{0xff, 0xff, 0xff, 0xff}, 32)
+	if e != nil {
+		return 0, e
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, nil
+}
+
+// Set whether the probe drives SWDIO, which shares TMS's pin.
+func (p *DebugProbe) driveSWDIO(out bool) error {
+	if out == p.swdioOut {
+		return nil
+	}
+	var e error
+	if out {
+		e = PinModeOutputInit(p.config.TMS, High)
+	} else {
+		e = PinMode(p.config.TMS, InputPullUp)
+	}
+	if e == nil {
+		p.swdioOut = out
+	}
+	return e
+}
+
+// Write one SWD bit: the target samples it on the rising edge of SWCLK.
+func (p *DebugProbe) swdWriteBit(bit int) error {
+	e := DigitalWrite(p.config.TCK, Low)
+	if e == nil {
+		e = DigitalWrite(p.config.TMS, bit)
+	}
+	if e == nil {
+		e = DigitalWrite(p.config.TCK, High)
+	}
+	return e
+}
+
+// Read one SWD bit, which the target changes on the rising edge of SWCLK.
+func (p *DebugProbe) swdReadBit() (int, error) {
+	e := DigitalWrite(p.config.TCK, Low)
+	if e != nil {
+		return 0, e
+	}
+	bit, e := DigitalRead(p.config.TMS)
+	if e == nil {
+		e = DigitalWrite(p.config.TCK, High)
+	}
+	return bit, e
+}
+
+// Write SWD bits, least significant first.
+func (p *DebugProbe) swdWrite(bits uint64, n int) error {
+	for i := 0; i < n; i++ {
+		if e := p.swdWriteBit(int(bits>>uint(i)) & 1); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Read SWD bits, least significant first.
+func (p *DebugProbe) swdRead(n int) (uint64, error) {
+	var bits uint64
+	for i := 0; i < n; i++ {
+		bit, e := p.swdReadBit()
+		if e != nil {
+			return 0, e
+		}
+		bits |= uint64(bit) << uint(i)
+	}
+	return bits, nil
+}
+
+// Switch the target's debug port from JTAG to SWD, and reset the SWD line. The target is then read with
+// SWDTransfer, starting with a read of DPIDR (DP register 0), which SWDReset's caller must do to leave the reset
+// state; SWDDPIDR does both.
+func (p *DebugProbe) SWDReset() error {
+	e := p.driveSWDIO(true)
+	if e == nil {
+		// a line reset, the JTAG to SWD select sequence, another line reset, and idle cycles
+		e = p.swdWrite(1<<56-1, 56)
+	}
+	if e == nil {
+		e = p.swdWrite(0xe79e, 16)
+	}
+	if e == nil {
+		e = p.swdWrite(1<<56-1, 56)
+	}
+	if e == nil {
+		e = p.swdWrite(0, 8)
+	}
+	return e
+}
+
+// Reset the SWD line and read the target's debug port ID.
+func (p *DebugProbe) SWDDPIDR() (uint32, error) {
+	e := p.SWDReset()
+	if e != nil {
+		return 0, e
+	}
+	return p.SWDTransfer(false, true, 0, 0)
+}
+
+// Return the request byte of an SWD transfer to a debug port (DP) or access port (AP) register. Only bits 2 and 3
+// of addr are sent.
+func swdRequest(ap bool, read bool, addr uint8) byte {
+	request := byte(0x81) // start and park bits
+	if ap {
+		request |= 1 << 1
+	}
+	if read {
+		request |= 1 << 2
+	}
+	request |= (addr & 0xc) << 1
+	if parity32(uint32(request>>1)&0xf) == 1 {
+		request |= 1 << 5
+	}
+	return request
+}
+
+// Return the parity of a word, 1 if it has an odd number of set bits.
+func parity32(v uint32) uint32 {
+	v ^= v >> 16
+	v ^= v >> 8
+	v ^= v >> 4
+	v ^= v >> 2
+	v ^= v >> 1
+	return v & 1
+}
+
+// Read or write a debug port (DP) or access port (AP) register over SWD, returning the value read. A transfer the
+// target replies WAIT to returns ErrSWDWait, and should be tried again.
+func (p *DebugProbe) SWDTransfer(ap bool, read bool, addr uint8, data uint32) (uint32, error) {
+	e := p.driveSWDIO(true)
+	if e == nil {
+		e = p.swdWrite(uint64(swdRequest(ap, read, addr)), 8)
+	}
+
+	// turnaround, then the target's acknowledgement
+	if e == nil {
+		e = p.driveSWDIO(false)
+	}
+	if e == nil {
+		_, e = p.swdRead(1)
+	}
+	var ack uint64
+	if e == nil {
+		ack, e = p.swdRead(3)
+	}
+	if e != nil {
+		return 0, e
+	}
+	if ack != swdAckOK {
+		// turnaround back to the probe, and idle
+		p.swdRead(1)
+		p.driveSWDIO(true)
+		p.swdWrite(0, 8)
+		switch ack {
+		case swdAckWait:
+			return 0, ErrSWDWait
+		case swdAckFault:
+			return 0, errors.New("SWD target replied FAULT")
+		}
+		return 0, fmt.Errorf("SWD target did not reply, acknowledgement %03b", ack)
+	}
+
+	var value uint32
+	if read {
+		bits, e := p.swdRead(33)
+		if e != nil {
+			return 0, e
+		}
+		value = uint32(bits)
+		if uint32(bits>>32) != parity32(value) {
+			return 0, errors.New("SWD read has a parity error")
+		}
+		_, e = p.swdRead(1)
+		if e == nil {
+			e = p.driveSWDIO(true)
+		}
+		if e != nil {
+			return 0, e
+		}
+	} else {
+		_, e = p.swdRead(1)
+		if e == nil {
+			e = p.driveSWDIO(true)
+		}
+		if e == nil {
+			e = p.swdWrite(uint64(data)|uint64(parity32(data))<<32, 33)
+		}
+		if e != nil {
+			return 0, e
+		}
+		value = data
+	}
+	return value, p.swdWrite(0, 8)
+}
+
+// Serve OpenOCD's remote_bitbang protocol on a listener, one connection at a time, until the listener is closed.
+// Returns the error that closed the listener.
+func (p *DebugProbe) ServeRemoteBitbang(l net.Listener) error {
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			return e
+		}
+		p.serveRemoteBitbang(conn)
+		conn.Close()
+	}
+}
+
+// Serve one remote_bitbang connection, until OpenOCD quits or disconnects. Errors driving the pins are not reported
+// to OpenOCD, which has no way to receive them, so they end the connection.
+func (p *DebugProbe) serveRemoteBitbang(conn io.ReadWriter) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+
+	for {
+		c, e := r.ReadByte()
+		if e != nil {
+			return e
+		}
+
+		reply := -1
+		switch {
+		case c >= '0' && c <= '7':
+			bits := int(c - '0')
+			e = p.driveSWDIO(true)
+			if e == nil {
+				e = p.write(bits>>2&1, bits>>1&1, bits&1)
+			}
+		case c == 'R':
+			if !p.config.UseJTAG {
+				e = errors.New("debug probe has no JTAG data pins")
+			} else {
+				reply, e = DigitalRead(p.config.TDO)
+			}
+		case c >= 'r' && c <= 'u':
+			bits := int(c - 'r')
+			e = p.Reset(bits>>1 == 1, bits&1 == 1)
+		case c == 'B' || c == 'b':
+			if p.config.UseLED {
+				e = DigitalWrite(p.config.LED, boolToLevel(c == 'B'))
+			}
+		case c == 'O' || c == 'o':
+			e = p.driveSWDIO(c == 'O')
+		case c == 'c':
+			reply, e = DigitalRead(p.config.TMS)
+		case c >= 'd' && c <= 'g':
+			bits := int(c - 'd')
+			e = DigitalWrite(p.config.TCK, bits>>1)
+			if e == nil && p.swdioOut {
+				e = DigitalWrite(p.config.TMS, bits&1)
+			}
+		case c == 'Z':
+			time.Sleep(time.Millisecond)
+		case c == 'z':
+			time.Sleep(time.Microsecond)
+		case c == 'Q':
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+
+		if reply >= 0 {
+			w.WriteByte('0' + byte(reply))
+		}
+		// OpenOCD sends commands in batches, so only flush replies once a batch has been handled
+		if r.Buffered() == 0 {
+			if e = w.Flush(); e != nil {
+				return e
+			}
+		}
+	}
+}
+
+func boolToLevel(b bool) int {
+	if b {
+		return High
+	}
+	return Low
+}
@@ -0,0 +1,161 @@
+// Describes the current driver and its modules, so applications can adapt to the board they are running on, or
+// include the details in bug reports.
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Information about the driver in use, as returned by DescribeDriver.
+type DriverInfo struct {
+	// Type name of the driver, e.g. "RaspberryPiDTDriver"
+	Driver string
+
+	// Name and revision of the board, if the driver can determine them
+	Board    string
+	Revision string
+
+	// All modules available through GetModule, sorted by name. Aliases such as "i2c" appear as separate entries.
+	Modules []ModuleInfo
+
+	// Names of the modules that are buses (i2c, spi)
+	Buses []string
+
+	// Known limitations of the driver on this board, e.g. features that are not supported
+	Limitations []string
+}
+
+// Information about a single module.
+type ModuleInfo struct {
+	// Name the module is retrieved with
+	Name string
+
+	// Kind of module: "gpio", "pwm", "analog", "i2c", "spi", "leds", or "other"
+	Kind string
+
+	// Kernel interface used, e.g. "sysfs", "cdev", "i2c-dev" or "mmap", and the device or path. These are empty if
+	// the module does not implement KernelInterfaceModule.
+	Interface string
+	Device    string
+
+	// True if the module was added with RegisterModule rather than provided by the driver
+	Registered bool
+}
+
+// Drivers that can describe the board they are running on implement this interface. Describe should fill in
+// Board, Revision and Limitations; DescribeDriver fills in the rest.
+type DescribedDriver interface {
+	HardwareDriver
+
+	Describe() DriverInfo
+}
+
+// Return a description of the current driver and its modules. This returns an error if no driver is set.
+func DescribeDriver() (DriverInfo, error) {
+	if driver == nil {
+		return DriverInfo{}, errors.New("DescribeDriver: Driver is not set")
+	}
+
+	info := DriverInfo{}
+	if d, ok := driver.(DescribedDriver); ok {
+		info = d.Describe()
+	}
+
+	t := reflect.TypeOf(driver)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	info.Driver = t.Name()
+
+	modules := GetModules()
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	info.Modules = make([]ModuleInfo, 0, len(names))
+	info.Buses = make([]string, 0)
+	for _, name := range names {
+		m := modules[name]
+		mi := ModuleInfo{Name: name, Kind: moduleKind(m)}
+		if km, ok := m.(KernelInterfaceModule); ok {
+			mi.Interface, mi.Device = km.KernelInterface()
+		}
+		_, mi.Registered = registeredModules[name]
+
+		info.Modules = append(info.Modules, mi)
+		if mi.Kind == "i2c" || mi.Kind == "spi" {
+			info.Buses = append(info.Buses, name)
+		}
+	}
+
+	return info, nil
+}
+
+// Determine the kind of a module from the interfaces it implements.
+func moduleKind(m Module) string {
+	switch m.(type) {
+	case GPIOModule:
+		return "gpio"
+	case PWMModule:
+		return "pwm"
+	case AnalogModule:
+		return "analog"
+	case I2CModule:
+		return "i2c"
+	case SPIModule:
+		return "spi"
+	case SerialModule:
+		return "serial"
+	case LEDModule:
+		return "leds"
+	}
+	return "other"
+}
+
+// Format the driver information as text, suitable for logging or pasting into a bug report.
+func (info DriverInfo) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "driver: %s\n", info.Driver)
+	if info.Board != "" {
+		fmt.Fprintf(&b, "board: %s\n", info.Board)
+	}
+	if info.Revision != "" {
+		fmt.Fprintf(&b, "revision: %s\n", info.Revision)
+	}
+
+	b.WriteString("modules:\n")
+	for _, m := range info.Modules {
+		fmt.Fprintf(&b, "  %s: %s", m.Name, m.Kind)
+		if m.Interface != "" {
+			fmt.Fprintf(&b, " via %s", m.Interface)
+		}
+		if m.Device != "" {
+			fmt.Fprintf(&b, " (%s)", m.Device)
+		}
+		if m.Registered {
+			b.WriteString(" [registered]")
+		}
+		b.WriteString("\n")
+	}
+
+	if len(info.Buses) > 0 {
+		fmt.Fprintf(&b, "buses: %s\n", strings.Join(info.Buses, ", "))
+	}
+
+	if len(info.Limitations) > 0 {
+		b.WriteString("limitations:\n")
+		for _, l := range info.Limitations {
+			fmt.Fprintf(&b, "  - %s\n", l)
+		}
+	}
+
+	return b.String()
+}
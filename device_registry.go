@@ -0,0 +1,129 @@
+package hwio
+
+// A registry of drivers for I2C chips, so the chips on a bus can be found and their drivers created without knowing
+// in advance what is wired up. Each driver under devices registers itself when its package is imported, with the
+// addresses its chip can have and how to recognise it, usually by an ID register. ProbeI2C tries the registered
+// drivers at each of their addresses, and returns a driver for each chip it recognises:
+//
+//	import _ "github.com/cinellodev/hwio/devices/tmp102"
+//	...
+//	found, e := hwio.ProbeI2C(i2c)
+//	for _, d := range found {
+//		if t, ok := d.Device.(hwio.Thermometer); ok {
+//			...
+//		}
+//	}
+//
+// Known issues:
+// - only drivers whose packages are imported are registered
+// - chips without an ID register are recognised by registers whose bits are fixed, which another chip at the same
+//   address could happen to match; drivers are tried in order of name, and the first to recognise a chip claims its
+//   address
+// - reading a register of an unknown chip is usually harmless, but a few chips act on reads, e.g. clearing an
+//   interrupt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A driver for an I2C chip, as registered for probing.
+type DeviceDriver struct {
+	// The name of the driver, usually its package, e.g. "tmp102"
+	Name string
+
+	// The addresses the chip can be at, default first
+	Addresses []int
+
+	// Return whether the device at an address is this chip. IdentifyByRegister makes the usual check of an ID
+	// register.
+	Identify func(device I2CDevice) bool
+
+	// Create the driver for the chip at an address
+	New func(module I2CModule, address int) (interface{}, error)
+}
+
+// A chip found by ProbeI2C, and its driver.
+type ProbedDevice struct {
+	Driver  string
+	Address int
+	Device  interface{}
+}
+
+var deviceDrivers = struct {
+	// guards drivers
+	sync.Mutex
+	drivers map[string]DeviceDriver
+}{drivers: make(map[string]DeviceDriver)}
+
+// Register a driver for probing. Drivers register themselves from init, so this only needs calling for drivers outside
+// this repository.
+func RegisterDeviceDriver(driver DeviceDriver) error {
+	if driver.Name == "" || len(driver.Addresses) == 0 || driver.Identify == nil || driver.New == nil {
+		return errors.New("a device driver needs a name, addresses, and Identify and New functions")
+	}
+	for _, address := range driver.Addresses {
+		if address < 0x03 || address > 0x77 {
+			return fmt.Errorf("device driver '%s' has invalid I2C address 0x%02x", driver.Name, address)
+		}
+	}
+	deviceDrivers.Lock()
+	defer deviceDrivers.Unlock()
+	if _, ok := deviceDrivers.drivers[driver.Name]; ok {
+		return fmt.Errorf("device driver '%s' is already registered", driver.Name)
+	}
+	deviceDrivers.drivers[driver.Name] = driver
+	return nil
+}
+
+// Return the registered drivers, in order of name.
+func GetDeviceDrivers() []DeviceDriver {
+	deviceDrivers.Lock()
+	defer deviceDrivers.Unlock()
+	result := make([]DeviceDriver, 0, len(deviceDrivers.drivers))
+	for _, d := range deviceDrivers.drivers {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Return an Identify function that reads size bytes, 1 or 2, from a register, most significant first, and checks
+// that the bits in mask have the values in id.
+func IdentifyByRegister(register byte, size int, mask uint16, id uint16) func(device I2CDevice) bool {
+	return func(device I2CDevice) bool {
+		b, e := device.Read(register, size)
+		if e != nil || len(b) != size {
+			return false
+		}
+		value := uint16(b[0])
+		if size == 2 {
+			value = UInt16FromUInt8(b[0], b[1])
+		}
+		return value&mask == id&mask
+	}
+}
+
+// Find the chips on a bus that registered drivers recognise, trying each driver at each of its addresses, and create
+// their drivers. An address that doesn't answer is skipped. The chips are returned in order of address.
+func ProbeI2C(module I2CModule) ([]ProbedDevice, error) {
+	claimed := make(map[int]bool)
+	var result []ProbedDevice
+	for _, driver := range GetDeviceDrivers() {
+		for _, address := range driver.Addresses {
+			if claimed[address] || !driver.Identify(module.GetDevice(address)) {
+				continue
+			}
+			device, e := driver.New(module, address)
+			if e != nil {
+				return result, fmt.Errorf("could not create %s at 0x%02x: %s", driver.Name, address, e)
+			}
+			claimed[address] = true
+			result = append(result, ProbedDevice{Driver: driver.Name, Address: address, Device: device})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Address < result[j].Address })
+	return result, nil
+}
@@ -0,0 +1,296 @@
+// Support for push buttons on a GPIO pin, turning the pin's edges into the events applications want: pressed,
+// released, click, double click and long press. The pin is debounced in software, so any switch can be used.
+
+// Current status:
+// - a press is seen once the pin has been steady for the debounce time, and timed from its first edge.
+// - a click is only reported once the double click time has passed without a second click, so a double click
+//   isn't also two clicks. Applications that don't want double clicks can react to Released instead.
+// - a long press is reported while the button is still held, and its release is not a click.
+// - needs a GPIO module that supports edge detection.
+
+package button
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	DEFAULT_DEBOUNCE          = 20 * time.Millisecond
+	DEFAULT_DOUBLE_CLICK_TIME = 300 * time.Millisecond
+	DEFAULT_LONG_PRESS_TIME   = time.Second
+)
+
+// The kinds of event a button reports.
+type EventType int
+
+const (
+	Pressed EventType = iota
+	Released
+	Click
+	DoubleClick
+	LongPress
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Pressed:
+		return "Pressed"
+	case Released:
+		return "Released"
+	case Click:
+		return "Click"
+	case DoubleClick:
+		return "DoubleClick"
+	case LongPress:
+		return "LongPress"
+	}
+	return ""
+}
+
+// An event from a button, with when it happened on the clock of hwio.MonotonicNow.
+type Event struct {
+	Type EventType
+	Time time.Duration
+}
+
+// How a button is wired, and its timings. Zero values give the defaults.
+type Config struct {
+	// If true, the button connects the pin to the supply, and a pull-down is used. Otherwise it connects the pin to
+	// ground, and a pull-up is used, which is the usual wiring.
+	ActiveHigh bool
+
+	// If true, the pin has an external pull resistor, so the internal one isn't used.
+	NoPull bool
+
+	// How long the pin must be steady for a change to be accepted.
+	Debounce time.Duration
+
+	// The longest time from the release of one click to the press of the next for them to be a double click.
+	DoubleClickTime time.Duration
+
+	// How long the button must be held to be a long press.
+	LongPressTime time.Duration
+}
+
+type Button struct {
+	// Events from the button. This is closed when the button is closed.
+	Events <-chan Event
+
+	pin    hwio.Pin
+	config Config
+	events chan Event
+	stop   chan bool
+	done   chan bool
+
+	// guards pressed
+	sync.Mutex
+	pressed bool
+}
+
+// Create a button on a pin, and start reporting its events. The pin is set as an input, with a pull-up or
+// pull-down as the config says.
+func NewButton(pin hwio.Pin, config Config) (*Button, error) {
+	if config.Debounce <= 0 {
+		config.Debounce = DEFAULT_DEBOUNCE
+	}
+	if config.DoubleClickTime <= 0 {
+		config.DoubleClickTime = DEFAULT_DOUBLE_CLICK_TIME
+	}
+	if config.LongPressTime <= 0 {
+		config.LongPressTime = DEFAULT_LONG_PRESS_TIME
+	}
+
+	b := &Button{
+		pin:    pin,
+		config: config,
+		events: make(chan Event, 16),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+	b.Events = b.events
+
+	mode := hwio.InputPullUp
+	switch {
+	case config.NoPull:
+		mode = hwio.Input
+	case config.ActiveHigh:
+		mode = hwio.InputPullDown
+	}
+	e := hwio.PinMode(pin, mode)
+	if e != nil {
+		return nil, e
+	}
+	edges, e := hwio.WatchEdges(pin, hwio.EdgeBoth)
+	if e != nil {
+		return nil, e
+	}
+
+	b.pressed, e = b.readPressed()
+	if e != nil {
+		hwio.StopWatchingEdges(pin)
+		return nil, e
+	}
+
+	go b.run(edges)
+	return b, nil
+}
+
+// Return true if the button is pressed, as last debounced.
+func (b *Button) IsPressed() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.pressed
+}
+
+// Stop reporting events, and close the Events channel.
+func (b *Button) Close() {
+	select {
+	case <-b.stop:
+		return
+	default:
+	}
+	close(b.stop)
+	<-b.done
+	hwio.StopWatchingEdges(b.pin)
+}
+
+// Read whether the button is pressed now.
+func (b *Button) readPressed() (bool, error) {
+	v, e := hwio.DigitalRead(b.pin)
+	if e != nil {
+		return false, e
+	}
+	return (v == hwio.High) == b.config.ActiveHigh, nil
+}
+
+// Debounce the pin's edges, and turn presses and releases into events.
+func (b *Button) run(edges <-chan hwio.EdgeEvent) {
+	defer close(b.done)
+	defer close(b.events)
+
+	debounce := newStoppedTimer()
+	clickWait := newStoppedTimer()
+	longWait := newStoppedTimer()
+
+	// the time of the first edge since the pin was last steady, or 0 if it has been steady since
+	var changed time.Duration
+	// clicks not yet reported, and the time of the last one
+	clicks := 0
+	var clicked time.Duration
+	// when the current press started, and whether it has already been reported as a long press
+	var pressStart time.Duration
+	long := false
+
+	for {
+		var event Event
+		select {
+		case <-b.stop:
+			return
+
+		case e, ok := <-edges:
+			if !ok {
+				return
+			}
+			if changed == 0 {
+				changed = e.Timestamp
+			}
+			resetTimer(debounce, b.config.Debounce)
+			continue
+
+		case <-debounce.C():
+			pressed, e := b.readPressed()
+			start := changed
+			changed = 0
+			b.Lock()
+			same := e != nil || pressed == b.pressed
+			if !same {
+				b.pressed = pressed
+			}
+			b.Unlock()
+			if same {
+				continue
+			}
+
+			if pressed {
+				event = Event{Pressed, start}
+				stopTimer(clickWait)
+				resetTimer(longWait, b.config.LongPressTime-(hwio.MonotonicNow()-start))
+				pressStart = start
+				long = false
+				break
+			}
+
+			stopTimer(longWait)
+			if !b.send(Event{Released, start}) {
+				return
+			}
+			if long {
+				continue
+			}
+			clicks++
+			clicked = start
+			if clicks == 2 {
+				clicks = 0
+				event = Event{DoubleClick, start}
+				break
+			}
+			resetTimer(clickWait, b.config.DoubleClickTime)
+			continue
+
+		case <-clickWait.C():
+			clicks = 0
+			event = Event{Click, clicked}
+
+		case <-longWait.C():
+			// a click before this press is reported now, as it can't be the first of a double click
+			if clicks > 0 {
+				clicks = 0
+				if !b.send(Event{Click, clicked}) {
+					return
+				}
+			}
+			long = true
+			event = Event{LongPress, pressStart + b.config.LongPressTime}
+		}
+
+		if !b.send(event) {
+			return
+		}
+	}
+}
+
+// Send an event, returning false if the button was closed while waiting for room on the channel.
+func (b *Button) send(event Event) bool {
+	select {
+	case b.events <- event:
+		return true
+	case <-b.stop:
+		return false
+	}
+}
+
+// Return a timer that isn't running.
+func newStoppedTimer() hwio.Timer {
+	t := hwio.GetClock().NewTimer(time.Hour)
+	t.Stop()
+	return t
+}
+
+// Stop a timer, discarding an expiry that hasn't been received.
+func stopTimer(t hwio.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C():
+		default:
+		}
+	}
+}
+
+// Restart a timer, discarding an expiry that hasn't been received.
+func resetTimer(t hwio.Timer, d time.Duration) {
+	stopTimer(t)
+	t.Reset(d)
+}
@@ -0,0 +1,505 @@
+// Support for e-paper displays using the Solomon SSD1680 (e.g. Waveshare 2.13" V3 and 2.9" V2) and UltraChip IL0373
+// (e.g. Waveshare 2.13" B/C tri-colour and flexible) controllers, over SPI.
+
+// Current status:
+// - black and white, and black, white and red on tri-colour panels. The frame buffer is an image.Paletted that can
+//   be drawn on with the standard image and image/draw packages.
+// - full refresh, and partial refresh of the area that has changed. On the SSD1680 a partial refresh uses the
+//   differential waveform and doesn't flash; the IL0373 has no partial waveform in OTP, so it refreshes the window
+//   with the full waveform. Tri-colour panels only support full refresh.
+// - DrawDithered and DrawOrdered convert photos and greyscale images to the panel's colours.
+// - the busy pin is polled, as refreshes take from a fraction of a second to 15 seconds or more.
+
+package epaper
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The controllers supported
+	SSD1680 = iota
+	IL0373
+
+	// SSD1680 commands
+	SSD1680_DRIVER_OUTPUT_CONTROL = 0x01
+	SSD1680_DEEP_SLEEP            = 0x10
+	SSD1680_DATA_ENTRY_MODE       = 0x11
+	SSD1680_SW_RESET              = 0x12
+	SSD1680_TEMPERATURE_SENSOR    = 0x18
+	SSD1680_MASTER_ACTIVATION     = 0x20
+	SSD1680_UPDATE_CONTROL_1      = 0x21
+	SSD1680_UPDATE_CONTROL_2      = 0x22
+	SSD1680_WRITE_RAM_BW          = 0x24
+	SSD1680_WRITE_RAM_RED         = 0x26
+	SSD1680_BORDER_WAVEFORM       = 0x3c
+	SSD1680_RAM_X_RANGE           = 0x44
+	SSD1680_RAM_Y_RANGE           = 0x45
+	SSD1680_RAM_X_COUNTER         = 0x4e
+	SSD1680_RAM_Y_COUNTER         = 0x4f
+
+	// SSD1680 update sequences, for SSD1680_UPDATE_CONTROL_2
+	SSD1680_UPDATE_FULL    = 0xf7
+	SSD1680_UPDATE_PARTIAL = 0xfc
+
+	// IL0373 commands
+	IL0373_PANEL_SETTING      = 0x00
+	IL0373_POWER_SETTING      = 0x01
+	IL0373_POWER_OFF          = 0x02
+	IL0373_POWER_ON           = 0x04
+	IL0373_BOOSTER_SOFT_START = 0x06
+	IL0373_DEEP_SLEEP         = 0x07
+	IL0373_DATA_START_1       = 0x10
+	IL0373_DISPLAY_REFRESH    = 0x12
+	IL0373_DATA_START_2       = 0x13
+	IL0373_VCOM_DATA_INTERVAL = 0x50
+	IL0373_RESOLUTION         = 0x61
+	IL0373_PARTIAL_WINDOW     = 0x90
+	IL0373_PARTIAL_IN         = 0x91
+	IL0373_PARTIAL_OUT        = 0x92
+
+	// How long to wait for the busy pin before giving up
+	BUSY_TIMEOUT = 30 * time.Second
+)
+
+// The colours of the frame buffer's palette, by index.
+const (
+	WHITE = iota
+	BLACK
+	RED
+)
+
+// Palettes for black and white, and tri-colour panels.
+var (
+	PaletteBW  = color.Palette{color.White, color.Black}
+	PaletteBWR = color.Palette{color.White, color.Black, color.RGBA{255, 0, 0, 255}}
+)
+
+// How a display is connected.
+type Config struct {
+	// SSD1680 or IL0373
+	Controller int
+
+	// The size of the panel in pixels, with Width the short side, which the controller addresses in bytes.
+	Width  int
+	Height int
+
+	// True for black, white and red panels
+	TriColour bool
+
+	// The chip select of the display on the SPI module
+	SlaveSelect int
+
+	// The data/command, reset and busy pins
+	DCPin    hwio.Pin
+	ResetPin hwio.Pin
+	BusyPin  hwio.Pin
+}
+
+type Display struct {
+	spi    hwio.SPIModule
+	config Config
+
+	image *image.Paletted
+
+	// the part of the image that has changed since the last refresh
+	dirty image.Rectangle
+}
+
+// Create a display on an SPI module, which must be enabled. The display is not touched until Init is called.
+func NewDisplay(spi hwio.SPIModule, config Config) (*Display, error) {
+	if config.Controller != SSD1680 && config.Controller != IL0373 {
+		return nil, fmt.Errorf("unsupported e-paper controller %d", config.Controller)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("e-paper display needs its width and height")
+	}
+
+	palette := PaletteBW
+	if config.TriColour {
+		palette = PaletteBWR
+	}
+	d := &Display{spi: spi, config: config}
+	d.image = image.NewPaletted(image.Rect(0, 0, config.Width, config.Height), palette)
+	return d, nil
+}
+
+// Reset and initialise the display. The frame buffer is white, but the panel is not refreshed.
+func (d *Display) Init() error {
+	e := hwio.PinModeOutputInit(d.config.DCPin, hwio.High)
+	if e == nil {
+		e = hwio.PinModeOutputInit(d.config.ResetPin, hwio.High)
+	}
+	if e == nil {
+		e = hwio.PinMode(d.config.BusyPin, hwio.Input)
+	}
+	if e != nil {
+		return e
+	}
+
+	e = d.reset()
+	if e != nil {
+		return e
+	}
+	if d.config.Controller == SSD1680 {
+		return d.initSSD1680()
+	}
+	return d.initIL0373()
+}
+
+// Pulse the reset pin, and wait for the controller to start.
+func (d *Display) reset() error {
+	e := hwio.Pulse(d.config.ResetPin, hwio.Low, 10000)
+	if e != nil {
+		return e
+	}
+	hwio.GetClock().Sleep(10 * time.Millisecond)
+	return d.waitUntilIdle()
+}
+
+func (d *Display) initSSD1680() error {
+	// on black and white panels the red RAM holds the previous image for partial refresh, so the full refresh
+	// must ignore it
+	redRAM := byte(0x40)
+	if d.config.TriColour {
+		redRAM = 0x00
+	}
+	h := d.config.Height - 1
+	commands := [][]byte{
+		{SSD1680_DRIVER_OUTPUT_CONTROL, byte(h), byte(h >> 8), 0x00},
+		{SSD1680_DATA_ENTRY_MODE, 0x03}, // X then Y, both increasing
+		{SSD1680_BORDER_WAVEFORM, 0x05},
+		{SSD1680_UPDATE_CONTROL_1, redRAM, 0x80},
+		{SSD1680_TEMPERATURE_SENSOR, 0x80}, // internal sensor
+	}
+
+	e := d.command(SSD1680_SW_RESET)
+	if e == nil {
+		e = d.waitUntilIdle()
+	}
+	for _, c := range commands {
+		if e != nil {
+			return e
+		}
+		e = d.command(c[0], c[1:]...)
+	}
+	return e
+}
+
+func (d *Display) initIL0373() error {
+	panel := byte(0x1f) // black and white, LUT from OTP
+	if d.config.TriColour {
+		panel = 0x0f
+	}
+	commands := [][]byte{
+		{IL0373_POWER_SETTING, 0x03, 0x00, 0x2b, 0x2b, 0x03},
+		{IL0373_BOOSTER_SOFT_START, 0x17, 0x17, 0x17},
+		{IL0373_PANEL_SETTING, panel, 0x0d},
+		{IL0373_RESOLUTION, byte(d.config.Width), byte(d.config.Height >> 8), byte(d.config.Height)},
+		{IL0373_VCOM_DATA_INTERVAL, 0x97},
+	}
+	for _, c := range commands {
+		e := d.command(c[0], c[1:]...)
+		if e != nil {
+			return e
+		}
+	}
+	e := d.command(IL0373_POWER_ON)
+	if e != nil {
+		return e
+	}
+	return d.waitUntilIdle()
+}
+
+// Send a command, with its parameters.
+func (d *Display) command(cmd byte, parameters ...byte) error {
+	e := hwio.DigitalWrite(d.config.DCPin, hwio.Low)
+	if e == nil {
+		e = d.spi.Write(d.config.SlaveSelect, []byte{cmd})
+	}
+	if e == nil {
+		e = hwio.DigitalWrite(d.config.DCPin, hwio.High)
+	}
+	if e != nil || len(parameters) == 0 {
+		return e
+	}
+	return d.spi.Write(d.config.SlaveSelect, parameters)
+}
+
+// Wait for the busy pin to show the controller is idle. The SSD1680 holds it high while busy, and the IL0373 low.
+func (d *Display) waitUntilIdle() error {
+	busy := hwio.High
+	if d.config.Controller == IL0373 {
+		busy = hwio.Low
+	}
+
+	deadline := hwio.GetClock().Now().Add(BUSY_TIMEOUT)
+	for {
+		v, e := hwio.DigitalRead(d.config.BusyPin)
+		if e != nil {
+			return e
+		}
+		if v != busy {
+			return nil
+		}
+		if hwio.GetClock().Now().After(deadline) {
+			return errors.New("e-paper display stayed busy; check the busy pin and power")
+		}
+		hwio.GetClock().Sleep(10 * time.Millisecond)
+	}
+}
+
+// Return the frame buffer. Changes made to it directly are not included in a partial refresh unless the area is
+// marked with MarkDirty; a full refresh always sends the whole buffer.
+func (d *Display) Image() *image.Paletted {
+	return d.image
+}
+
+// Mark part of the frame buffer as changed, for the next partial refresh.
+func (d *Display) MarkDirty(r image.Rectangle) {
+	d.dirty = d.dirty.Union(r.Intersect(d.image.Bounds()))
+}
+
+func (d *Display) Bounds() image.Rectangle {
+	return d.image.Bounds()
+}
+
+func (d *Display) ColorModel() color.Model {
+	return d.image.Palette
+}
+
+func (d *Display) At(x, y int) color.Color {
+	return d.image.At(x, y)
+}
+
+// Set a pixel to the nearest colour the panel has, and mark it as changed. This makes the Display a draw.Image.
+func (d *Display) Set(x, y int, c color.Color) {
+	d.image.Set(x, y, c)
+	d.MarkDirty(image.Rect(x, y, x+1, y+1))
+}
+
+// Set the whole frame buffer to white.
+func (d *Display) Clear() {
+	for i := range d.image.Pix {
+		d.image.Pix[i] = WHITE
+	}
+	d.MarkDirty(d.image.Bounds())
+}
+
+// Draw an image into a rectangle of the frame buffer, converting it to the panel's colours with Floyd-Steinberg error
+// diffusion. This suits photos and gradients; sp is the point in src drawn at r.Min.
+func (d *Display) DrawDithered(r image.Rectangle, src image.Image, sp image.Point) {
+	r = r.Intersect(d.image.Bounds())
+	draw.FloydSteinberg.Draw(d.image, r, src, sp)
+	d.MarkDirty(r)
+}
+
+// 4x4 Bayer matrix, scaled to thresholds in the middle of each of 16 steps of 0-0xffff.
+var bayer4 = [4][4]uint32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Draw an image into a rectangle of the frame buffer in black and white, using ordered dithering with a 4x4 Bayer
+// matrix. Unlike error diffusion, a pixel only depends on the source pixel and its position, so redrawing part of an
+// image gives the same result, and partial refreshes don't disturb the pattern around them.
+func (d *Display) DrawOrdered(r image.Rectangle, src image.Image, sp image.Point) {
+	r = r.Intersect(d.image.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			grey := color.Gray16Model.Convert(src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)).(color.Gray16).Y
+			threshold := bayer4[y&3][x&3]*0x1000 + 0x800
+			index := uint8(WHITE)
+			if uint32(grey) < threshold {
+				index = BLACK
+			}
+			d.image.SetColorIndex(x, y, index)
+		}
+	}
+	d.MarkDirty(r)
+}
+
+// Pack a rectangle of the frame buffer into bytes of 8 pixels, most significant bit first, with a bit set where the
+// pixel is the colour given. The rectangle must start and end on a byte boundary in X.
+func (d *Display) plane(r image.Rectangle, colour uint8) []byte {
+	result := make([]byte, 0, r.Dx()/8*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x += 8 {
+			b := byte(0)
+			for i := 0; i < 8; i++ {
+				b <<= 1
+				if x+i < r.Max.X && d.image.ColorIndexAt(x+i, y) == colour {
+					b |= 1
+				}
+			}
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+func invert(data []byte) []byte {
+	for i := range data {
+		data[i] = ^data[i]
+	}
+	return data
+}
+
+// Align a rectangle to whole bytes in X, as the controllers address memory in bytes of 8 pixels.
+func (d *Display) byteAligned(r image.Rectangle) image.Rectangle {
+	r.Min.X &^= 7
+	r.Max.X = (r.Max.X + 7) &^ 7
+	return r
+}
+
+// Send the whole frame buffer to the display, and refresh it with the full waveform, which flashes the panel to
+// clear any ghosting. This returns when the refresh is complete.
+func (d *Display) Refresh() error {
+	r := d.byteAligned(d.image.Bounds())
+	var e error
+	if d.config.Controller == SSD1680 {
+		e = d.refreshSSD1680(r, SSD1680_UPDATE_FULL)
+	} else {
+		e = d.refreshIL0373(r, false)
+	}
+	if e == nil {
+		d.dirty = image.Rectangle{}
+	}
+	return e
+}
+
+// Refresh only the part of the display that has changed since the last refresh. This is quicker than Refresh, and
+// on the SSD1680 doesn't flash, but ghosting builds up over many partial refreshes, so do a full Refresh from time to
+// time.
+func (d *Display) RefreshPartial() error {
+	if d.config.TriColour {
+		return errors.New("tri-colour e-paper displays only support full refresh")
+	}
+	if d.dirty.Empty() {
+		return nil
+	}
+
+	r := d.byteAligned(d.dirty)
+	var e error
+	if d.config.Controller == SSD1680 {
+		e = d.refreshSSD1680(r, SSD1680_UPDATE_PARTIAL)
+	} else {
+		e = d.refreshIL0373(r, true)
+	}
+	if e == nil {
+		d.dirty = image.Rectangle{}
+	}
+	return e
+}
+
+// Set the SSD1680's RAM window to a rectangle, and point the counters at its start.
+func (d *Display) setWindowSSD1680(r image.Rectangle) error {
+	x0, x1 := byte(r.Min.X/8), byte(r.Max.X/8-1)
+	y0, y1 := r.Min.Y, r.Max.Y-1
+	commands := [][]byte{
+		{SSD1680_RAM_X_RANGE, x0, x1},
+		{SSD1680_RAM_Y_RANGE, byte(y0), byte(y0 >> 8), byte(y1), byte(y1 >> 8)},
+		{SSD1680_RAM_X_COUNTER, x0},
+		{SSD1680_RAM_Y_COUNTER, byte(y0), byte(y0 >> 8)},
+	}
+	for _, c := range commands {
+		e := d.command(c[0], c[1:]...)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Write a rectangle to the SSD1680's RAM, and refresh. The black and white RAM has 1 for white. For black and white
+// panels, the red RAM holds the previous image, which the partial waveform compares against, so it's written with
+// the new image after the refresh.
+func (d *Display) refreshSSD1680(r image.Rectangle, update byte) error {
+	e := d.setWindowSSD1680(r)
+	if e == nil {
+		e = d.command(SSD1680_WRITE_RAM_BW, invert(d.plane(r, BLACK))...)
+	}
+	if e == nil && d.config.TriColour {
+		e = d.setWindowSSD1680(r)
+		if e == nil {
+			e = d.command(SSD1680_WRITE_RAM_RED, d.plane(r, RED)...)
+		}
+	}
+	if e == nil {
+		e = d.command(SSD1680_UPDATE_CONTROL_2, update)
+	}
+	if e == nil {
+		e = d.command(SSD1680_MASTER_ACTIVATION)
+	}
+	if e == nil {
+		e = d.waitUntilIdle()
+	}
+	if e == nil && !d.config.TriColour {
+		e = d.setWindowSSD1680(r)
+		if e == nil {
+			e = d.command(SSD1680_WRITE_RAM_RED, invert(d.plane(r, BLACK))...)
+		}
+	}
+	return e
+}
+
+// Write a rectangle to the IL0373's RAM, and refresh, using a partial window if partial is true. Data start 1 is the
+// black plane and data start 2 the red plane on tri-colour panels; on black and white panels data start 2 is the
+// image. Both have 0 for ink.
+func (d *Display) refreshIL0373(r image.Rectangle, partial bool) error {
+	var e error
+	if partial {
+		x0, x1 := r.Min.X, r.Max.X-1
+		y0, y1 := r.Min.Y, r.Max.Y-1
+		e = d.command(IL0373_PARTIAL_IN)
+		if e == nil {
+			e = d.command(IL0373_PARTIAL_WINDOW, byte(x0), byte(x1), byte(y0>>8), byte(y0), byte(y1>>8), byte(y1), 0x01)
+		}
+	}
+
+	if e == nil && d.config.TriColour {
+		e = d.command(IL0373_DATA_START_1, invert(d.plane(r, BLACK))...)
+		if e == nil {
+			e = d.command(IL0373_DATA_START_2, invert(d.plane(r, RED))...)
+		}
+	} else if e == nil {
+		e = d.command(IL0373_DATA_START_2, invert(d.plane(r, BLACK))...)
+	}
+
+	if e == nil {
+		e = d.command(IL0373_DISPLAY_REFRESH)
+	}
+	if e == nil {
+		hwio.GetClock().Sleep(100 * time.Millisecond)
+		e = d.waitUntilIdle()
+	}
+	if e == nil && partial {
+		e = d.command(IL0373_PARTIAL_OUT)
+	}
+	return e
+}
+
+// Put the controller into deep sleep, where it draws almost no power and the image stays on the panel. Init must be
+// called to wake it.
+func (d *Display) Sleep() error {
+	if d.config.Controller == SSD1680 {
+		return d.command(SSD1680_DEEP_SLEEP, 0x01)
+	}
+	e := d.command(IL0373_POWER_OFF)
+	if e == nil {
+		e = d.waitUntilIdle()
+	}
+	if e == nil {
+		e = d.command(IL0373_DEEP_SLEEP, 0xa5)
+	}
+	return e
+}
@@ -41,6 +41,7 @@ const (
 	REG_GYRO_ZOUT_L = 0x48
 
 	REG_PWR_MGMT_1 = 0x6b
+	REG_WHO_AM_I   = 0x75
 
 	PARAM_SLEEP = 0x40
 )
@@ -49,8 +50,30 @@ type GY520 struct {
 	device hwio.I2CDevice
 }
 
+var (
+	_ hwio.Thermometer   = (*GY520)(nil)
+	_ hwio.Accelerometer = (*GY520)(nil)
+)
+
+func init() {
+	// WHO_AM_I holds the upper 6 bits of the default address, whichever address is used
+	hwio.RegisterDeviceDriver(hwio.DeviceDriver{
+		Name:      "gy520",
+		Addresses: []int{DEVICE_ADDRESS, 0x69},
+		Identify:  hwio.IdentifyByRegister(REG_WHO_AM_I, 1, 0x7e, DEVICE_ADDRESS),
+		New: func(module hwio.I2CModule, address int) (interface{}, error) {
+			return NewGY520Addr(module, address), nil
+		},
+	})
+}
+
 func NewGY520(module hwio.I2CModule) *GY520 {
-	device := module.GetDevice(DEVICE_ADDRESS)
+	return NewGY520Addr(module, DEVICE_ADDRESS)
+}
+
+// Create a GY520 at 0x69, which is its address when AD0 is high.
+func NewGY520Addr(module hwio.I2CModule, address int) *GY520 {
+	device := module.GetDevice(address)
 	result := &GY520{device: device}
 
 	return result
@@ -116,6 +139,26 @@ func (g *GY520) GetAccel() (accelX int, accelY int, accelZ int, e error) {
 	return accelX, accelY, accelZ, nil
 }
 
+// Read the acceleration, scaling the raw values of GetAccel by the full scale range set in REG_ACCEL_CONFIG, which
+// is ±2g on power on.
+func (g *GY520) ReadAcceleration() (x hwio.Acceleration, y hwio.Acceleration, z hwio.Acceleration, e error) {
+	config, e := g.device.ReadByte(REG_ACCEL_CONFIG)
+	if e != nil {
+		return 0, 0, 0, e
+	}
+	ax, ay, az, e := g.GetAccel()
+	if e != nil {
+		return 0, 0, 0, e
+	}
+
+	// AFS_SEL, bits 3 and 4, selects ±2g, 4g, 8g or 16g, which is 16384 LSB/g halved for each step
+	perG := float64(int(16384) >> ((config >> 3) & 3))
+	scale := func(raw int) hwio.Acceleration {
+		return hwio.Acceleration(float64(raw)/perG) * hwio.StandardGravity
+	}
+	return scale(ax), scale(ay), scale(az), nil
+}
+
 func (g *GY520) GetTemp() (int, error) {
 	buffer, e := g.device.Read(REG_TEMP_OUT_H, 2)
 	if e != nil {
@@ -125,6 +168,15 @@ func (g *GY520) GetTemp() (int, error) {
 	return int(int16(hwio.UInt16FromUInt8(buffer[0], buffer[1]))), nil
 }
 
+// Read the temperature of the die, which is the raw value of GetTemp scaled as the MPU-6050 datasheet gives.
+func (g *GY520) ReadTemperature() (hwio.Temperature, error) {
+	raw, e := g.GetTemp()
+	if e != nil {
+		return 0, e
+	}
+	return hwio.Celsius(float64(raw)/340 + 36.53), nil
+}
+
 func (g *GY520) SetAccelSampleRate(rate int) {
 
 }
@@ -0,0 +1,391 @@
+// Support for the Texas Instruments INA219, INA226 and INA3221 power monitors, which measure the voltage across a
+// shunt resistor and the bus voltage, over I2C. Battery powered and solar projects use them to see the current drawn
+// and the state of the battery.
+
+// Current status:
+// - INA219 and INA226 are calibrated from the shunt resistance and the largest current expected, so they report
+//   current and power directly. The INA3221 has no calibration, so its current and power are worked out from the
+//   shunt and bus voltages.
+// - undervoltage alerts: the INA226 drives its ALERT pin, and the INA3221 its PV (power valid) pin, low when the bus
+//   voltage falls below a limit. WatchAlert calls a function when that happens. The INA219 has no alert pin.
+// - conversion times and averaging are left at the chips' defaults.
+
+package ina
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The address with A0 and A1 grounded. Other wirings give 0x40-0x4f for INA219 and INA226, and 0x40-0x43 for
+	// INA3221.
+	DEFAULT_ADDRESS = 0x40
+
+	REG_CONFIG      = 0x00
+	REG_SHUNT       = 0x01
+	REG_BUS         = 0x02
+	REG_POWER       = 0x03
+	REG_CURRENT     = 0x04
+	REG_CALIBRATION = 0x05
+
+	// INA226
+	REG_MASK_ENABLE = 0x06
+	REG_ALERT_LIMIT = 0x07
+
+	// INA3221; channel n's shunt and bus voltages are at 0x01+2n and 0x02+2n.
+	REG_3221_MASK_ENABLE = 0x0f
+	REG_3221_PV_UPPER    = 0x10
+	REG_3221_PV_LOWER    = 0x11
+
+	REG_MANUFACTURER_ID = 0xfe
+	REG_DIE_ID          = 0xff
+
+	// The manufacturer ID of Texas Instruments
+	MANUFACTURER_TI = 0x5449
+
+	// INA226 mask/enable bits
+	ALERT_BUS_UNDERVOLTAGE = 1 << 12
+	ALERT_LATCH            = 1 << 0
+	ALERT_FUNCTION_FLAG    = 1 << 4
+)
+
+// A reading from a power monitor, in volts, amps and watts. BusVoltage is the voltage on the load side of the shunt,
+// relative to ground.
+type Reading struct {
+	BusVoltage   float64
+	ShuntVoltage float64
+	Current      float64
+	Power        float64
+}
+
+func (r Reading) String() string {
+	return fmt.Sprintf("%.3fV %.4fA %.3fW", r.BusVoltage, r.Current, r.Power)
+}
+
+// Read a 16 bit register.
+func readRegister(device hwio.I2CDevice, reg byte) (uint16, error) {
+	b, e := device.Read(reg, 2)
+	if e != nil {
+		return 0, e
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
+
+// Write a 16 bit register.
+func writeRegister(device hwio.I2CDevice, reg byte, value uint16) error {
+	return device.Write(reg, []byte{byte(value >> 8), byte(value)})
+}
+
+// The shunt and largest current of chips created by probing, as on the common breakout boards.
+const (
+	PROBED_SHUNT_OHMS     = 0.1
+	PROBED_INA226_CURRENT = 0.8
+)
+
+func init() {
+	// the INA219 has no ID registers, so only the INA226 and INA3221 are probed
+	addresses := make([]int, 16)
+	for i := range addresses {
+		addresses[i] = DEFAULT_ADDRESS + i
+	}
+	hwio.RegisterDeviceDriver(hwio.DeviceDriver{
+		Name:      "ina226",
+		Addresses: addresses,
+		Identify: func(device hwio.I2CDevice) bool {
+			return checkID(device, 0x2260, "INA226") == nil
+		},
+		New: func(module hwio.I2CModule, address int) (interface{}, error) {
+			return NewINA226(module, address, PROBED_SHUNT_OHMS, PROBED_INA226_CURRENT)
+		},
+	})
+	hwio.RegisterDeviceDriver(hwio.DeviceDriver{
+		Name:      "ina3221",
+		Addresses: addresses[:4],
+		Identify: func(device hwio.I2CDevice) bool {
+			return checkID(device, 0x3220, "INA3221") == nil
+		},
+		New: func(module hwio.I2CModule, address int) (interface{}, error) {
+			return NewINA3221(module, address, [3]float64{PROBED_SHUNT_OHMS, PROBED_SHUNT_OHMS, PROBED_SHUNT_OHMS})
+		},
+	})
+}
+
+// Check the device's manufacturer and die IDs.
+func checkID(device hwio.I2CDevice, die uint16, name string) error {
+	m, e := readRegister(device, REG_MANUFACTURER_ID)
+	if e != nil {
+		return e
+	}
+	d, e := readRegister(device, REG_DIE_ID)
+	if e != nil {
+		return e
+	}
+	if m != MANUFACTURER_TI || d&0xfff0 != die {
+		return fmt.Errorf("device is not an %s: manufacturer ID %04x, die ID %04x", name, m, d)
+	}
+	return nil
+}
+
+// Work out the current register's LSB and the calibration value for a shunt and a largest current. scale is the
+// constant in the chip's calibration equation.
+func calibrate(shuntOhms float64, maxCurrent float64, scale float64) (float64, uint16, error) {
+	if shuntOhms <= 0 || maxCurrent <= 0 {
+		return 0, 0, errors.New("shunt resistance and largest current must be positive")
+	}
+	lsb := maxCurrent / 32768
+	cal := math.Trunc(scale / (lsb * shuntOhms))
+	if cal > 0x7fff {
+		// a large shunt or small current can't be calibrated that finely, so use a coarser LSB
+		cal = 0x7fff
+		lsb = scale / (cal * shuntOhms)
+	}
+	return lsb, uint16(cal), nil
+}
+
+type INA219 struct {
+	device     hwio.I2CDevice
+	currentLSB float64
+}
+
+// Create an INA219 on a bus, and calibrate it for a shunt resistance and the largest current expected. The shunt
+// voltage range is set to suit, up to 320mV, and the bus range to 32V.
+func NewINA219(module hwio.I2CModule, address int, shuntOhms float64, maxCurrent float64) (*INA219, error) {
+	lsb, cal, e := calibrate(shuntOhms, maxCurrent, 0.04096)
+	if e != nil {
+		return nil, e
+	}
+
+	// the smallest shunt voltage range (PGA gain) that fits the largest current
+	shunt := maxCurrent * shuntOhms
+	gain := uint16(0)
+	for _, limit := range []float64{0.04, 0.08, 0.16, 0.32} {
+		if shunt <= limit {
+			break
+		}
+		gain++
+	}
+	if gain > 3 {
+		return nil, fmt.Errorf("INA219 shunt voltage can't exceed 320mV, %gA through %g ohms is %.0fmV", maxCurrent, shuntOhms, shunt*1000)
+	}
+
+	d := &INA219{device: module.GetDevice(address), currentLSB: lsb}
+	// 32V bus range, the gain, 12 bit conversions, and continuous shunt and bus measurement
+	e = writeRegister(d.device, REG_CONFIG, 1<<13|gain<<11|0x3<<7|0x3<<3|0x7)
+	if e != nil {
+		return nil, e
+	}
+	e = writeRegister(d.device, REG_CALIBRATION, cal)
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Read the voltages, current and power.
+func (d *INA219) Read() (Reading, error) {
+	r := Reading{}
+	shunt, e := readRegister(d.device, REG_SHUNT)
+	if e != nil {
+		return r, e
+	}
+	bus, e := readRegister(d.device, REG_BUS)
+	if e != nil {
+		return r, e
+	}
+	current, e := readRegister(d.device, REG_CURRENT)
+	if e != nil {
+		return r, e
+	}
+	power, e := readRegister(d.device, REG_POWER)
+	if e != nil {
+		return r, e
+	}
+	if bus&0x1 != 0 {
+		return r, errors.New("INA219 current or power out of range; the calibration is too small")
+	}
+
+	r.ShuntVoltage = float64(int16(shunt)) * 10e-6
+	r.BusVoltage = float64(bus>>3) * 4e-3
+	r.Current = float64(int16(current)) * d.currentLSB
+	r.Power = float64(power) * 20 * d.currentLSB
+	return r, nil
+}
+
+type INA226 struct {
+	device     hwio.I2CDevice
+	currentLSB float64
+}
+
+// Create an INA226 on a bus, and calibrate it for a shunt resistance and the largest current expected, which must
+// put no more than 81.92mV across the shunt.
+func NewINA226(module hwio.I2CModule, address int, shuntOhms float64, maxCurrent float64) (*INA226, error) {
+	if maxCurrent*shuntOhms > 0.08192 {
+		return nil, fmt.Errorf("INA226 shunt voltage can't exceed 81.92mV, %gA through %g ohms is %.0fmV", maxCurrent, shuntOhms, maxCurrent*shuntOhms*1000)
+	}
+	lsb, cal, e := calibrate(shuntOhms, maxCurrent, 0.00512)
+	if e != nil {
+		return nil, e
+	}
+
+	d := &INA226{device: module.GetDevice(address), currentLSB: lsb}
+	e = checkID(d.device, 0x2260, "INA226")
+	if e != nil {
+		return nil, e
+	}
+	e = writeRegister(d.device, REG_CALIBRATION, cal)
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Read the voltages, current and power.
+func (d *INA226) Read() (Reading, error) {
+	r := Reading{}
+	shunt, e := readRegister(d.device, REG_SHUNT)
+	if e != nil {
+		return r, e
+	}
+	bus, e := readRegister(d.device, REG_BUS)
+	if e != nil {
+		return r, e
+	}
+	current, e := readRegister(d.device, REG_CURRENT)
+	if e != nil {
+		return r, e
+	}
+	power, e := readRegister(d.device, REG_POWER)
+	if e != nil {
+		return r, e
+	}
+
+	r.ShuntVoltage = float64(int16(shunt)) * 2.5e-6
+	r.BusVoltage = float64(bus) * 1.25e-3
+	r.Current = float64(int16(current)) * d.currentLSB
+	r.Power = float64(power) * 25 * d.currentLSB
+	return r, nil
+}
+
+// Have the ALERT pin go low when the bus voltage falls below a limit, and stay low until ClearAlert is called. A
+// limit of 0 turns the alert off.
+func (d *INA226) SetUndervoltageAlert(volts float64) error {
+	if volts <= 0 {
+		return writeRegister(d.device, REG_MASK_ENABLE, 0)
+	}
+	limit := math.Round(volts / 1.25e-3)
+	if limit > 0x7fff {
+		return fmt.Errorf("INA226 bus voltage alert limit can't exceed 40.96V, got %gV", volts)
+	}
+	e := writeRegister(d.device, REG_ALERT_LIMIT, uint16(limit))
+	if e != nil {
+		return e
+	}
+	return writeRegister(d.device, REG_MASK_ENABLE, ALERT_BUS_UNDERVOLTAGE|ALERT_LATCH)
+}
+
+// Release the ALERT pin after an alert, returning true if the alert had happened.
+func (d *INA226) ClearAlert() (bool, error) {
+	// reading the mask/enable register clears the latched alert
+	v, e := readRegister(d.device, REG_MASK_ENABLE)
+	if e != nil {
+		return false, e
+	}
+	return v&ALERT_FUNCTION_FLAG != 0, nil
+}
+
+type INA3221 struct {
+	device    hwio.I2CDevice
+	shuntOhms [3]float64
+}
+
+// Create an INA3221 on a bus, with the shunt resistance of each of its three channels.
+func NewINA3221(module hwio.I2CModule, address int, shuntOhms [3]float64) (*INA3221, error) {
+	for i, r := range shuntOhms {
+		if r <= 0 {
+			return nil, fmt.Errorf("INA3221 channel %d shunt resistance must be positive", i+1)
+		}
+	}
+	d := &INA3221{device: module.GetDevice(address), shuntOhms: shuntOhms}
+	e := checkID(d.device, 0x3220, "INA3221")
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Read the voltages, current and power of a channel, 1 to 3.
+func (d *INA3221) Read(channel int) (Reading, error) {
+	r := Reading{}
+	if channel < 1 || channel > 3 {
+		return r, fmt.Errorf("INA3221 channel must be 1 to 3, got %d", channel)
+	}
+	reg := byte(REG_SHUNT + 2*(channel-1))
+	shunt, e := readRegister(d.device, reg)
+	if e != nil {
+		return r, e
+	}
+	bus, e := readRegister(d.device, reg+1)
+	if e != nil {
+		return r, e
+	}
+
+	r.ShuntVoltage = float64(int16(shunt)>>3) * 40e-6
+	r.BusVoltage = float64(int16(bus)>>3) * 8e-3
+	r.Current = r.ShuntVoltage / d.shuntOhms[channel-1]
+	r.Power = r.BusVoltage * r.Current
+	return r, nil
+}
+
+// Have the PV pin go low when the bus voltage of any channel falls below a limit, and high again once they are all
+// above it. The upper limit is set to the largest bus voltage, so only undervoltage is reported.
+func (d *INA3221) SetUndervoltageAlert(volts float64) error {
+	limit := math.Round(volts / 8e-3)
+	if limit < 0 || limit > 0xfff {
+		return fmt.Errorf("INA3221 bus voltage limit must be 0 to 26V, got %gV", volts)
+	}
+	e := writeRegister(d.device, REG_3221_PV_UPPER, 0x7ff8)
+	if e != nil {
+		return e
+	}
+	return writeRegister(d.device, REG_3221_PV_LOWER, uint16(limit)<<3)
+}
+
+// Watches an alert pin of a power monitor.
+type AlertWatcher struct {
+	pin  hwio.Pin
+	done chan bool
+}
+
+// Call handler each time a power monitor's alert pin, wired to a GPIO pin, goes low, with the time on the clock of
+// hwio.MonotonicNow. The alert pins are open drain, so the pin is set as an input with a pull-up. The handler is
+// called from a goroutine, one alert at a time. This needs a GPIO module that supports edge detection.
+func WatchAlert(pin hwio.Pin, handler func(at time.Duration)) (*AlertWatcher, error) {
+	e := hwio.PinMode(pin, hwio.InputPullUp)
+	if e != nil {
+		return nil, e
+	}
+	edges, e := hwio.WatchEdges(pin, hwio.EdgeFalling)
+	if e != nil {
+		return nil, e
+	}
+
+	w := &AlertWatcher{pin: pin, done: make(chan bool)}
+	go func() {
+		defer close(w.done)
+		for event := range edges {
+			handler(event.Timestamp)
+		}
+	}()
+	return w, nil
+}
+
+// Stop watching the alert pin.
+func (w *AlertWatcher) Close() {
+	hwio.StopWatchingEdges(w.pin)
+	<-w.done
+}
@@ -0,0 +1,327 @@
+// Support for LEDs on GPIO pins, with effects that run in the background: blinking, fading and breathing, and
+// patterns such as a heartbeat or Morse code. All LEDs' effects are stepped by one shared goroutine, so an
+// application doesn't need a goroutine and sleep loop for each.
+
+// Current status:
+// - effects are stepped every TICK, so steps shorter than that are not seen.
+// - brightness other than fully on or off uses hwio.AnalogWrite, so is hardware PWM on pins that have it, and software
+//   PWM otherwise. Once an LED has used it, the LED is always driven by AnalogWrite.
+// - AnalogWrite isn't safe to call from more than one goroutine, so applications using it on other pins while
+//   effects are running should guard it.
+
+package led
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// How often effects are stepped.
+	TICK = 10 * time.Millisecond
+
+	// Brightness of an LED that is fully on.
+	FULL = 255
+)
+
+// One step of a pattern: the LED is at Brightness (0 to FULL) for Duration. If Ramp is true, it fades to Brightness
+// over the step from the brightness before it instead.
+type Step struct {
+	Brightness int
+	Duration   time.Duration
+	Ramp       bool
+}
+
+type LED struct {
+	pin       hwio.Pin
+	activeLow bool
+
+	// the brightness last written, and whether the pin is driven by AnalogWrite
+	brightness int
+	dimmed     bool
+
+	// the running pattern, when it started, and the brightness before it
+	steps  []Step
+	repeat bool
+	start  time.Time
+	from   int
+}
+
+// The goroutine that steps effects. It is started when an effect starts, and ends when none are running. The mutex
+// guards the state of all LEDs, so an LED isn't changed by the application and the goroutine at once.
+var scheduler struct {
+	sync.Mutex
+	running map[*LED]bool
+	active  bool
+}
+
+// Create an LED on a pin, which is set as an output and turned off. If activeLow is true, the LED is lit by driving
+// the pin low.
+func NewLED(pin hwio.Pin, activeLow bool) (*LED, error) {
+	l := &LED{pin: pin, activeLow: activeLow}
+	off := hwio.Low
+	if activeLow {
+		off = hwio.High
+	}
+	e := hwio.PinModeOutputInit(pin, off)
+	if e != nil {
+		return nil, e
+	}
+	return l, nil
+}
+
+// Turn the LED fully on, stopping any effect.
+func (l *LED) On() error {
+	return l.Set(FULL)
+}
+
+// Turn the LED off, stopping any effect.
+func (l *LED) Off() error {
+	return l.Set(0)
+}
+
+// Set the brightness of the LED, from 0 to FULL, stopping any effect.
+func (l *LED) Set(brightness int) error {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	l.stopEffect()
+	return l.write(brightness)
+}
+
+// Return the brightness of the LED, from 0 to FULL.
+func (l *LED) Brightness() int {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+	return l.brightness
+}
+
+// Blink the LED, on for half of each period and off for the other half, until another effect starts or the
+// brightness is set.
+func (l *LED) Blink(period time.Duration) {
+	l.Pattern([]Step{{FULL, period / 2, false}, {0, period - period/2, false}}, true)
+}
+
+// Fade the LED from its brightness now to another over a duration, leaving it there.
+func (l *LED) Fade(brightness int, duration time.Duration) {
+	l.Pattern([]Step{{brightness, duration, true}}, false)
+}
+
+// Fade the LED up and down continuously, taking period for each cycle.
+func (l *LED) Breathe(period time.Duration) {
+	l.Pattern([]Step{{FULL, period / 2, true}, {0, period - period/2, true}}, true)
+}
+
+// Flash the LED in a heartbeat, two short pulses a second.
+func (l *LED) Heartbeat() {
+	l.Pattern(HeartbeatSteps(), true)
+}
+
+// Flash a message in Morse code, with dots of length unit, leaving the LED off at the end.
+func (l *LED) Morse(text string, unit time.Duration) {
+	l.Pattern(MorseSteps(text, unit), false)
+}
+
+// Play a pattern of steps, repeating it if repeat is true, until another effect starts or the brightness is set. A
+// pattern that doesn't repeat leaves the LED at the brightness of its last step.
+func (l *LED) Pattern(steps []Step, repeat bool) {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	if len(steps) == 0 {
+		l.stopEffect()
+		return
+	}
+	l.steps = append([]Step{}, steps...)
+	l.repeat = repeat
+	l.start = hwio.GetClock().Now()
+	l.from = l.brightness
+	l.step(hwio.GetClock().Now())
+
+	if scheduler.running == nil {
+		scheduler.running = make(map[*LED]bool)
+	}
+	scheduler.running[l] = true
+	if !scheduler.active {
+		scheduler.active = true
+		go runScheduler()
+	}
+}
+
+// Return true if an effect is running.
+func (l *LED) IsRunning() bool {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+	return scheduler.running[l]
+}
+
+// Stop any effect, leaving the LED as it is.
+func (l *LED) Stop() {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+	l.stopEffect()
+}
+
+// Stop any effect, turn the LED off and release its pin.
+func (l *LED) Close() error {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	l.stopEffect()
+	l.write(0)
+	if l.dimmed {
+		hwio.StopAnalogWrite(l.pin)
+	}
+	return hwio.ClosePin(l.pin)
+}
+
+func (l *LED) stopEffect() {
+	delete(scheduler.running, l)
+	l.steps = nil
+}
+
+// Write a brightness to the pin, if it has changed.
+func (l *LED) write(brightness int) error {
+	if brightness < 0 {
+		brightness = 0
+	} else if brightness > FULL {
+		brightness = FULL
+	}
+	if brightness == l.brightness {
+		return nil
+	}
+
+	level := brightness
+	if l.activeLow {
+		level = FULL - brightness
+	}
+
+	var e error
+	switch {
+	case l.dimmed || (brightness != 0 && brightness != FULL):
+		l.dimmed = true
+		e = hwio.AnalogWrite(l.pin, level)
+	case level == FULL:
+		e = hwio.DigitalWrite(l.pin, hwio.High)
+	default:
+		e = hwio.DigitalWrite(l.pin, hwio.Low)
+	}
+	if e == nil {
+		l.brightness = brightness
+	}
+	return e
+}
+
+// Set the LED to where its pattern is at a time, returning false if the pattern has finished.
+func (l *LED) step(now time.Time) bool {
+	total := time.Duration(0)
+	for _, s := range l.steps {
+		total += s.Duration
+	}
+
+	elapsed := now.Sub(l.start)
+	if elapsed >= total {
+		if !l.repeat || total <= 0 {
+			l.write(l.steps[len(l.steps)-1].Brightness)
+			return false
+		}
+		elapsed %= total
+	}
+
+	// the brightness before the first step is the last step's when repeating, or the LED's when the pattern started
+	prev := l.from
+	if l.repeat {
+		prev = l.steps[len(l.steps)-1].Brightness
+	}
+	for _, s := range l.steps {
+		if elapsed < s.Duration {
+			brightness := s.Brightness
+			if s.Ramp {
+				brightness = prev + int(int64(s.Brightness-prev)*int64(elapsed)/int64(s.Duration))
+			}
+			l.write(brightness)
+			return true
+		}
+		elapsed -= s.Duration
+		prev = s.Brightness
+	}
+	return true
+}
+
+// Step the running effects every tick, until none are left.
+func runScheduler() {
+	ticker := hwio.GetClock().NewTicker(TICK)
+	defer ticker.Stop()
+
+	for now := range ticker.C() {
+		scheduler.Lock()
+		for l := range scheduler.running {
+			if !l.step(now) {
+				l.stopEffect()
+			}
+		}
+		if len(scheduler.running) == 0 {
+			scheduler.active = false
+			scheduler.Unlock()
+			return
+		}
+		scheduler.Unlock()
+	}
+}
+
+// Return the steps of a heartbeat: two short pulses, then a pause, lasting a second.
+func HeartbeatSteps() []Step {
+	return []Step{
+		{FULL, 100 * time.Millisecond, false},
+		{0, 100 * time.Millisecond, false},
+		{FULL, 100 * time.Millisecond, false},
+		{0, 700 * time.Millisecond, false},
+	}
+}
+
+// Morse code for letters and digits.
+var morseCodes = map[rune]string{
+	'A': ".-", 'B': "-...", 'C': "-.-.", 'D': "-..", 'E': ".", 'F': "..-.", 'G': "--.", 'H': "....", 'I': "..",
+	'J': ".---", 'K': "-.-", 'L': ".-..", 'M': "--", 'N': "-.", 'O': "---", 'P': ".--.", 'Q': "--.-", 'R': ".-.",
+	'S': "...", 'T': "-", 'U': "..-", 'V': "...-", 'W': ".--", 'X': "-..-", 'Y': "-.--", 'Z': "--..",
+	'0': "-----", '1': ".----", '2': "..---", '3': "...--", '4': "....-", '5': ".....", '6': "-....", '7': "--...",
+	'8': "---..", '9': "----.",
+}
+
+// Return the steps of a message in Morse code, with dots of length unit. A dash is three units, with one unit
+// between the dots and dashes of a letter, three between letters and seven between words. Characters with no code
+// are skipped. The steps end with the gap after the last word, so a repeating message has a pause between
+// repeats.
+func MorseSteps(text string, unit time.Duration) []Step {
+	steps := []Step{}
+	gap := func(units int) {
+		// lengthen the gap already there, which is at least the one after the last dot or dash
+		if len(steps) > 0 {
+			if last := &steps[len(steps)-1]; last.Brightness == 0 && last.Duration < time.Duration(units)*unit {
+				last.Duration = time.Duration(units) * unit
+			}
+		}
+	}
+
+	for _, word := range strings.Fields(strings.ToUpper(text)) {
+		for _, r := range word {
+			code, ok := morseCodes[r]
+			if !ok {
+				continue
+			}
+			for _, c := range code {
+				on := unit
+				if c == '-' {
+					on = 3 * unit
+				}
+				steps = append(steps, Step{FULL, on, false}, Step{0, unit, false})
+			}
+			gap(3)
+		}
+		gap(7)
+	}
+	return steps
+}
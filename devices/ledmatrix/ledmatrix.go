@@ -0,0 +1,278 @@
+// Support for LED matrices driven directly from GPIO pins, either as rows and columns, or charlieplexed. The LEDs
+// are lit one row at a time, by a goroutine scanning the rows fast enough that they all appear lit. The application
+// sets LEDs in a frame buffer, which the goroutine shows.
+
+// Current status:
+// - each row is lit for 1/(rows * refresh rate), so LEDs are dimmer the more rows there are, and need current
+//   limiting resistors sized for their peak current. A row's pins sink or source the current of all its LEDs, which
+//   can be more than a GPIO pin can handle without transistors.
+// - the scan timing is a goroutine's, so rows jitter in brightness with system load.
+// - charlieplexed pins are switched between output and input on every row, which is slower than writing levels; on
+//   the GPIO character device each switch is a system call.
+
+package ledmatrix
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+// The default refresh rate, in Hz: how many times a second every row is lit.
+const DEFAULT_REFRESH = 100
+
+// How a row and column matrix is wired.
+type Config struct {
+	// How many times a second every row is lit. 0 is DEFAULT_REFRESH.
+	RefreshHz int
+
+	// If true, a row is selected by driving its pin low, as when rows are the LEDs' cathodes.
+	RowActiveLow bool
+
+	// If true, an LED in the selected row is lit by driving its column low, as when columns are the LEDs' cathodes.
+	ColumnActiveLow bool
+}
+
+type Matrix struct {
+	width, height int
+
+	// guards frame and err
+	sync.Mutex
+	frame []bool
+	err   error
+
+	// light a row, given the LEDs to light in it, and unlight it
+	showRow  func(row int, lit []bool) error
+	hideRow  func(row int) error
+	interval time.Duration
+
+	stop chan bool
+	done chan bool
+}
+
+// Create a matrix of LEDs on row and column pins, and start scanning it. The matrix is len(columns) wide and
+// len(rows) high, and starts with all LEDs off.
+func NewMatrix(rows []hwio.Pin, columns []hwio.Pin, config Config) (*Matrix, error) {
+	if len(rows) == 0 || len(columns) == 0 {
+		return nil, errors.New("an LED matrix needs at least one row and one column")
+	}
+
+	rowOn, rowOff := hwio.High, hwio.Low
+	if config.RowActiveLow {
+		rowOn, rowOff = rowOff, rowOn
+	}
+	colOn, colOff := hwio.High, hwio.Low
+	if config.ColumnActiveLow {
+		colOn, colOff = colOff, colOn
+	}
+
+	for _, pin := range rows {
+		if e := hwio.PinModeOutputInit(pin, rowOff); e != nil {
+			return nil, e
+		}
+	}
+	for _, pin := range columns {
+		if e := hwio.PinModeOutputInit(pin, colOff); e != nil {
+			return nil, e
+		}
+	}
+
+	m := newMatrix(len(columns), len(rows), config.RefreshHz)
+	m.showRow = func(row int, lit []bool) error {
+		for x, pin := range columns {
+			level := colOff
+			if lit[x] {
+				level = colOn
+			}
+			if e := hwio.DigitalWrite(pin, level); e != nil {
+				return e
+			}
+		}
+		return hwio.DigitalWrite(rows[row], rowOn)
+	}
+	m.hideRow = func(row int) error {
+		return hwio.DigitalWrite(rows[row], rowOff)
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Create a charlieplexed matrix of LEDs on a set of pins, and start scanning it. n pins drive n*(n-1) LEDs, one
+// between each ordered pair of pins. The matrix has a row for each pin as the LEDs' anode, and a column for each of
+// the other pins as their cathode, in order with the anode's pin left out: the LED at (x, y) has its anode on pins[y]
+// and its cathode on pins[x] if x < y, or pins[x+1] otherwise.
+func NewCharlieplex(pins []hwio.Pin, refreshHz int) (*Matrix, error) {
+	if len(pins) < 2 {
+		return nil, errors.New("charlieplexing needs at least two pins")
+	}
+
+	// all pins start as inputs, so no LED is lit
+	for _, pin := range pins {
+		if e := hwio.PinMode(pin, hwio.Input); e != nil {
+			return nil, e
+		}
+	}
+
+	m := newMatrix(len(pins)-1, len(pins), refreshHz)
+	m.showRow = func(row int, lit []bool) error {
+		anyLit := false
+		for x, on := range lit {
+			if !on {
+				continue
+			}
+			anyLit = true
+			cathode := x
+			if x >= row {
+				cathode++
+			}
+			if e := hwio.PinModeOutputInit(pins[cathode], hwio.Low); e != nil {
+				return e
+			}
+		}
+		if !anyLit {
+			return nil
+		}
+		return hwio.PinModeOutputInit(pins[row], hwio.High)
+	}
+	m.hideRow = func(row int) error {
+		for _, pin := range pins {
+			if e := hwio.PinMode(pin, hwio.Input); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	go m.run()
+	return m, nil
+}
+
+func newMatrix(width int, height int, refreshHz int) *Matrix {
+	if refreshHz <= 0 {
+		refreshHz = DEFAULT_REFRESH
+	}
+	return &Matrix{
+		width:    width,
+		height:   height,
+		frame:    make([]bool, width*height),
+		interval: time.Second / time.Duration(refreshHz*height),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+}
+
+// Return the width of the matrix, its number of columns.
+func (m *Matrix) Width() int {
+	return m.width
+}
+
+// Return the height of the matrix, its number of rows.
+func (m *Matrix) Height() int {
+	return m.height
+}
+
+// Turn an LED on or off. LEDs outside the matrix are ignored.
+func (m *Matrix) Set(x int, y int, on bool) {
+	if x < 0 || x >= m.width || y < 0 || y >= m.height {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.frame[y*m.width+x] = on
+}
+
+// Return true if an LED is on.
+func (m *Matrix) Get(x int, y int) bool {
+	if x < 0 || x >= m.width || y < 0 || y >= m.height {
+		return false
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.frame[y*m.width+x]
+}
+
+// Turn all LEDs off.
+func (m *Matrix) Clear() {
+	m.Lock()
+	defer m.Unlock()
+	for i := range m.frame {
+		m.frame[i] = false
+	}
+}
+
+// Set the whole frame at once, as a bit mask for each row with bit x for column x. This changes the display in one
+// step, so it doesn't show a partly drawn frame. Rows beyond those given are cleared.
+func (m *Matrix) SetFrame(rows []uint64) {
+	m.Lock()
+	defer m.Unlock()
+	for y := 0; y < m.height; y++ {
+		bits := uint64(0)
+		if y < len(rows) {
+			bits = rows[y]
+		}
+		for x := 0; x < m.width; x++ {
+			m.frame[y*m.width+x] = bits&(1<<uint(x)) != 0
+		}
+	}
+}
+
+// Return the error that stopped the scan, if writing to a pin failed.
+func (m *Matrix) Err() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.err
+}
+
+// Stop scanning, leaving all LEDs off.
+func (m *Matrix) Close() {
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+	close(m.stop)
+	<-m.done
+}
+
+// Light each row in turn, until stopped or a pin can't be written.
+func (m *Matrix) run() {
+	defer close(m.done)
+
+	ticker := hwio.GetClock().NewTicker(m.interval)
+	defer ticker.Stop()
+
+	lit := make([]bool, m.width)
+	row := 0
+	for {
+		m.Lock()
+		copy(lit, m.frame[row*m.width:])
+		m.Unlock()
+
+		e := m.showRow(row, lit)
+		if e == nil {
+			select {
+			case <-m.stop:
+			case <-ticker.C():
+			}
+			e = m.hideRow(row)
+		}
+		if e != nil {
+			m.Lock()
+			m.err = fmt.Errorf("LED matrix row %d: %s", row, e)
+			m.Unlock()
+			m.hideRow(row)
+			return
+		}
+
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+		row = (row + 1) % m.height
+	}
+}
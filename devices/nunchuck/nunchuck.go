@@ -39,6 +39,8 @@ type Nunchuck struct {
 	lastCPressed bool
 }
 
+var _ hwio.Accelerometer = (*Nunchuck)(nil)
+
 func NewNunchuck(module hwio.I2CModule) (*Nunchuck, error) {
 	device := module.GetDevice(DEVICE_ADDRESS)
 	n := &Nunchuck{device: device}
@@ -133,6 +135,18 @@ func (n *Nunchuck) GetAccel() (ax float32, ay float32, az float32) {
 	return n.lastAccelX, n.lastAccelY, n.lastAccelZ
 }
 
+// Read the sensors, as ReadSensors does, and return the acceleration. This takes RADIUS calibrated units as 1g, so
+// is only approximate.
+func (n *Nunchuck) ReadAcceleration() (x hwio.Acceleration, y hwio.Acceleration, z hwio.Acceleration, e error) {
+	if e = n.ReadSensors(); e != nil {
+		return 0, 0, 0, e
+	}
+	scale := func(v float32) hwio.Acceleration {
+		return hwio.Acceleration(float64(v)/RADIUS) * hwio.StandardGravity
+	}
+	return scale(n.lastAccelX), scale(n.lastAccelY), scale(n.lastAccelZ), nil
+}
+
 func (n *Nunchuck) GetZPressed() bool {
 	return n.lastZPressed
 }
@@ -0,0 +1,287 @@
+// Support for PS/2 keyboards and mice, which send bytes on a clock and data line pair. The device drives the clock,
+// and data is valid on each falling edge of the clock: a start bit (0), 8 data bits least significant first, an odd
+// parity bit and a stop bit (1).
+
+// Current status:
+// - receives bytes from the device, checking the parity of each. Sending commands to the device (e.g. to set the
+//   keyboard LEDs) is not supported, so devices stay in their default mode: scan code set 2 for keyboards, and
+//   stream mode for mice once enabled.
+// - KeyEvents turns the bytes from a keyboard into key presses and releases.
+// - needs a GPIO module that supports edge detection. Both lines are watched, and the data line's level at each
+//   clock edge is worked out from the kernel's timestamps, so the clock (10-17kHz) doesn't need to be sampled in
+//   time.
+// - PS/2 is a 5V bus; the lines need level shifting to 3.3V boards.
+
+package ps2
+
+import (
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The longest time between clock edges within a byte. Partly received bytes are dropped after this.
+	MAX_BIT_GAP = time.Millisecond
+
+	// How long to wait after the last clock edge of a byte before decoding it, for data edges still to be read.
+	SETTLE_TIME = 500 * time.Microsecond
+
+	// Prefixes in scan code set 2
+	PREFIX_EXTENDED = 0xe0
+	PREFIX_RELEASE  = 0xf0
+	PREFIX_PAUSE    = 0xe1
+
+	// The code KeyEvents reports for the Pause key, which sends its own sequence
+	KEY_PAUSE = 0xe114
+)
+
+// A byte received from the device, with the time of its first clock edge on the clock of hwio.MonotonicNow. Valid
+// is false if the parity bit was wrong.
+type Frame struct {
+	Data  byte
+	Time  time.Duration
+	Valid bool
+}
+
+type Device struct {
+	// Bytes received. This is closed when the device is closed.
+	Frames <-chan Frame
+
+	clock, data hwio.Pin
+	frames      chan Frame
+	stop        chan bool
+	done        chan bool
+
+	// falling clock edges not yet decoded, and the data edges since the last byte decoded. dataLevel is the level
+	// of the data line before the first of them.
+	clocks    []time.Duration
+	dataEdges []hwio.EdgeEvent
+	dataLevel bool
+}
+
+// Create a device on a clock and data pin, and start receiving bytes. The pins are set as inputs with pull-ups, as
+// the lines are open collector.
+func NewDevice(clock hwio.Pin, data hwio.Pin) (*Device, error) {
+	d := &Device{
+		clock:  clock,
+		data:   data,
+		frames: make(chan Frame, 16),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+	d.Frames = d.frames
+
+	for _, pin := range []hwio.Pin{clock, data} {
+		e := hwio.PinMode(pin, hwio.InputPullUp)
+		if e != nil {
+			return nil, e
+		}
+	}
+	level, e := hwio.DigitalRead(data)
+	if e != nil {
+		return nil, e
+	}
+	d.dataLevel = level == hwio.High
+
+	clocks, e := hwio.WatchEdges(clock, hwio.EdgeFalling)
+	if e != nil {
+		return nil, e
+	}
+	dataEdges, e := hwio.WatchEdges(data, hwio.EdgeBoth)
+	if e != nil {
+		hwio.StopWatchingEdges(clock)
+		return nil, e
+	}
+
+	go d.run(clocks, dataEdges)
+	return d, nil
+}
+
+// Stop receiving, and close the Frames channel.
+func (d *Device) Close() {
+	select {
+	case <-d.stop:
+		return
+	default:
+	}
+	close(d.stop)
+	<-d.done
+	hwio.StopWatchingEdges(d.clock)
+	hwio.StopWatchingEdges(d.data)
+}
+
+func (d *Device) run(clocks <-chan hwio.EdgeEvent, dataEdges <-chan hwio.EdgeEvent) {
+	defer close(d.done)
+	defer close(d.frames)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		var settled <-chan time.Time
+		if len(d.clocks) >= 11 {
+			settled = timer.C
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case event, ok := <-clocks:
+			if !ok {
+				return
+			}
+			d.addClock(event.Timestamp)
+		case event, ok := <-dataEdges:
+			if !ok {
+				return
+			}
+			d.dataEdges = append(d.dataEdges, event)
+		case <-settled:
+			// take edges that have happened but not been read yet, then decode what's complete
+		drain:
+			for {
+				select {
+				case event, ok := <-clocks:
+					if !ok {
+						return
+					}
+					d.addClock(event.Timestamp)
+				case event, ok := <-dataEdges:
+					if !ok {
+						return
+					}
+					d.dataEdges = append(d.dataEdges, event)
+				default:
+					break drain
+				}
+			}
+			if !d.decode() {
+				return
+			}
+		}
+
+		if len(d.clocks) >= 11 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(d.clocks[10] + SETTLE_TIME - hwio.MonotonicNow())
+		}
+	}
+}
+
+// Add a falling clock edge. If the clock has been idle for longer than a bit time, the edges of a partly received
+// byte before it are dropped.
+func (d *Device) addClock(t time.Duration) {
+	if n := len(d.clocks); n > 0 && t-d.clocks[n-1] > MAX_BIT_GAP {
+		d.clocks = d.clocks[:n-n%11]
+	}
+	d.clocks = append(d.clocks, t)
+}
+
+// The level of the data line at a time.
+func (d *Device) levelAt(t time.Duration) bool {
+	level := d.dataLevel
+	for _, e := range d.dataEdges {
+		if e.Timestamp > t {
+			break
+		}
+		level = e.Rising
+	}
+	return level
+}
+
+// Decode the bytes whose clock edges have all been received long enough ago, returning false if the device was
+// closed while delivering them. If a byte doesn't have a start and stop bit, its first clock edge is dropped to
+// resynchronise.
+func (d *Device) decode() bool {
+	for len(d.clocks) >= 11 && hwio.MonotonicNow() >= d.clocks[10]+SETTLE_TIME {
+		if d.levelAt(d.clocks[0]) || !d.levelAt(d.clocks[10]) {
+			d.clocks = d.clocks[1:]
+			continue
+		}
+
+		frame := Frame{Time: d.clocks[0]}
+		ones := 0
+		for i := 1; i <= 9; i++ {
+			if d.levelAt(d.clocks[i]) {
+				ones++
+				if i <= 8 {
+					frame.Data |= 1 << uint(i-1)
+				}
+			}
+		}
+		frame.Valid = ones%2 == 1
+
+		// forget the data edges before the end of this byte
+		end := d.clocks[10]
+		d.dataLevel = d.levelAt(end)
+		for len(d.dataEdges) > 0 && d.dataEdges[0].Timestamp <= end {
+			d.dataEdges = d.dataEdges[1:]
+		}
+		d.clocks = d.clocks[11:]
+
+		select {
+		case d.frames <- frame:
+		case <-d.stop:
+			return false
+		}
+	}
+	return true
+}
+
+// A key pressed or released on a keyboard. Code is the scan code in set 2, with the 0xe0 prefix of extended keys in
+// the high byte, e.g. 0x1c for A and 0xe075 for the up arrow.
+type KeyEvent struct {
+	Code     uint16
+	Released bool
+	Time     time.Duration
+}
+
+// Turn the bytes from a keyboard into key events, which are delivered on the returned channel until frames is
+// closed. Bytes with bad parity, and the keyboard's replies to commands, are skipped.
+func KeyEvents(frames <-chan Frame) <-chan KeyEvent {
+	events := make(chan KeyEvent, 16)
+	go func() {
+		defer close(events)
+
+		var event KeyEvent
+		pause := 0
+		for frame := range frames {
+			if !frame.Valid {
+				event = KeyEvent{}
+				continue
+			}
+			if event.Code == 0 && !event.Released {
+				event.Time = frame.Time
+			}
+
+			switch {
+			case pause > 0:
+				// Pause sends e1 14 77 e1 f0 14 f0 77 on press, and nothing on release
+				pause--
+				if pause == 0 {
+					events <- KeyEvent{Code: KEY_PAUSE, Time: event.Time}
+					event = KeyEvent{}
+				}
+			case frame.Data == PREFIX_PAUSE:
+				pause = 7
+			case frame.Data == PREFIX_EXTENDED:
+				event.Code = PREFIX_EXTENDED << 8
+			case frame.Data == PREFIX_RELEASE:
+				event.Released = true
+			case frame.Data == 0x00 || frame.Data >= 0xaa && event.Code == 0 && !event.Released:
+				// errors, self test results, echo, acknowledgements and resend requests
+				event = KeyEvent{}
+			default:
+				event.Code |= uint16(frame.Data)
+				events <- event
+				event = KeyEvent{}
+			}
+		}
+	}()
+	return events
+}
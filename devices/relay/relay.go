@@ -0,0 +1,216 @@
+// Support for banks of relays and contactors on GPIO pins, switched by name. Channels can be active-low, as most
+// relay boards are, and can have minimum on and off times, which protect contactors and the motors and compressors
+// they switch from being cycled too quickly. Interlocks stop channels that must never be on together, such as the
+// forward and reverse contactors of a motor, from being energised at once.
+
+// Current status:
+// - a change that an interlock or minimum time forbids returns an error rather than waiting, so the application
+//   decides what to do; the error says how long is left for a minimum time.
+// - AllOff ignores minimum on times, as it is for stopping in an emergency or on exit.
+// - interlocks are enforced by this package only; hardware interlocks are still needed where a fault would be
+//   dangerous.
+
+package relay
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+// A channel of a bank.
+type Channel struct {
+	Name string
+	Pin  hwio.Pin
+
+	// If true, the channel is energised by driving the pin low, as on most relay boards.
+	ActiveLow bool
+
+	// Once energised, the channel stays on for at least MinOn, and once off, it stays off for at least MinOff.
+	MinOn  time.Duration
+	MinOff time.Duration
+}
+
+// The error returned when a channel can't be switched yet because of its minimum on or off time.
+type DwellError struct {
+	Channel string
+	On      bool
+
+	// How long until the channel can be switched
+	Wait time.Duration
+}
+
+func (e *DwellError) Error() string {
+	state := "off"
+	if e.On {
+		state = "on"
+	}
+	return fmt.Sprintf("relay channel '%s' must stay %s for another %s", e.Channel, state, e.Wait)
+}
+
+// The error returned when a channel can't be energised because a channel it is interlocked with is on.
+type InterlockError struct {
+	Channel string
+	Other   string
+}
+
+func (e *InterlockError) Error() string {
+	return fmt.Sprintf("relay channel '%s' cannot be energised while '%s' is on", e.Channel, e.Other)
+}
+
+type channel struct {
+	Channel
+	on      bool
+	changed time.Time
+}
+
+type Bank struct {
+	// guards the channels' states
+	sync.Mutex
+
+	channels map[string]*channel
+	order    []string
+
+	// the channels each channel is interlocked with
+	interlocks map[string][]string
+}
+
+// Create a bank of channels. Each pin is set as an output, starting off, and the minimum off time starts now, so a
+// compressor isn't restarted straight after the program restarts.
+func NewBank(channels ...Channel) (*Bank, error) {
+	b := &Bank{channels: make(map[string]*channel), interlocks: make(map[string][]string)}
+	now := hwio.GetClock().Now()
+	for _, c := range channels {
+		if c.Name == "" {
+			return nil, fmt.Errorf("relay channel on pin %d has no name", c.Pin)
+		}
+		if b.channels[c.Name] != nil {
+			return nil, fmt.Errorf("there is more than one relay channel called '%s'", c.Name)
+		}
+		ch := &channel{Channel: c, changed: now}
+		e := hwio.PinModeOutputInit(c.Pin, ch.level(false))
+		if e != nil {
+			return nil, fmt.Errorf("relay channel '%s': %s", c.Name, e)
+		}
+		b.channels[c.Name] = ch
+		b.order = append(b.order, c.Name)
+	}
+	return b, nil
+}
+
+// Return the level of the pin for a state.
+func (c *channel) level(on bool) int {
+	if on != c.ActiveLow {
+		return hwio.High
+	}
+	return hwio.Low
+}
+
+// Interlock a set of channels, so that at most one of them can be on at a time.
+func (b *Bank) Interlock(names ...string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, name := range names {
+		if b.channels[name] == nil {
+			return fmt.Errorf("no relay channel called '%s'", name)
+		}
+	}
+	for _, name := range names {
+		for _, other := range names {
+			if other != name {
+				b.interlocks[name] = append(b.interlocks[name], other)
+			}
+		}
+	}
+	return nil
+}
+
+// Switch a channel on or off. Switching a channel to the state it is in does nothing. An InterlockError or
+// DwellError is returned if the change isn't allowed yet.
+func (b *Bank) Set(name string, on bool) error {
+	b.Lock()
+	defer b.Unlock()
+
+	c := b.channels[name]
+	if c == nil {
+		return fmt.Errorf("no relay channel called '%s'", name)
+	}
+	if c.on == on {
+		return nil
+	}
+
+	if on {
+		for _, other := range b.interlocks[name] {
+			if b.channels[other].on {
+				return &InterlockError{Channel: name, Other: other}
+			}
+		}
+	}
+
+	dwell := c.MinOff
+	if c.on {
+		dwell = c.MinOn
+	}
+	if wait := dwell - hwio.GetClock().Now().Sub(c.changed); wait > 0 {
+		return &DwellError{Channel: name, On: c.on, Wait: wait}
+	}
+
+	return b.change(c, on)
+}
+
+// Change a channel's state, recording when it changed.
+func (b *Bank) change(c *channel, on bool) error {
+	e := hwio.DigitalWrite(c.Pin, c.level(on))
+	if e != nil {
+		return fmt.Errorf("relay channel '%s': %s", c.Name, e)
+	}
+	c.on = on
+	c.changed = hwio.GetClock().Now()
+	return nil
+}
+
+// Switch a channel on.
+func (b *Bank) On(name string) error {
+	return b.Set(name, true)
+}
+
+// Switch a channel off.
+func (b *Bank) Off(name string) error {
+	return b.Set(name, false)
+}
+
+// Return true if a channel is on.
+func (b *Bank) IsOn(name string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	c := b.channels[name]
+	return c != nil && c.on
+}
+
+// Return the names of the channels, in the order given to NewBank.
+func (b *Bank) Channels() []string {
+	return append([]string{}, b.order...)
+}
+
+// Switch all channels off, regardless of their minimum on times. This returns the first error, after trying every
+// channel.
+func (b *Bank) AllOff() error {
+	b.Lock()
+	defer b.Unlock()
+
+	var result error
+	for _, name := range b.order {
+		c := b.channels[name]
+		if !c.on {
+			continue
+		}
+		if e := b.change(c, false); e != nil && result == nil {
+			result = e
+		}
+	}
+	return result
+}
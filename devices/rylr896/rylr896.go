@@ -0,0 +1,262 @@
+// Support for the Reyax RYLR896 (and RYLR406/RYLR890) LoRa modems, which are driven by AT commands over a serial
+// module.
+
+// Current status:
+// - supports setting the address, network ID, band, RF parameters, output power and AES password, and sending and
+//   receiving messages. The modem adds and checks the CRC of each packet itself.
+// - messages are text lines, so data must not contain "\r\n".
+
+package rylr896
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The modem's default baud rate
+	DEFAULT_BAUD = 115200
+
+	// The longest message the modem can send
+	MAX_DATA_LENGTH = 240
+
+	// The address to send to all modems on the network
+	BROADCAST_ADDRESS = 0
+)
+
+// Meanings of the modem's +ERR codes, from the AT command guide.
+var errorCodes = map[int]string{
+	1:  "no enter or \\r\\n at the end of the AT command",
+	2:  "the head of the AT command is not 'AT'",
+	4:  "unknown command",
+	5:  "the data length does not match the actual length",
+	10: "TX timeout",
+	12: "CRC error",
+	13: "TX data is more than 240 bytes",
+	15: "unknown error",
+}
+
+// A message received from another modem, with the signal strength (dBm) and signal to noise ratio it was received
+// with.
+type Message struct {
+	Address int
+	Data    []byte
+	RSSI    int
+	SNR     int
+}
+
+type RYLR896 struct {
+	// Messages received from other modems. This is closed when the modem is closed.
+	Received <-chan Message
+
+	serial    hwio.SerialModule
+	lines     *hwio.SerialFrameReader
+	received  chan Message
+	responses chan string
+
+	// serialises commands, as responses are not tagged with the command they answer
+	sync.Mutex
+	timeout time.Duration
+}
+
+// Create a modem on a serial module, which must be enabled, and start reading from it. Received messages are
+// delivered on the Received channel, and should be read promptly.
+func NewRYLR896(serial hwio.SerialModule) (*RYLR896, error) {
+	lines, e := hwio.ReadFrames(serial, hwio.SerialFraming{Delimiter: []byte("\r\n")})
+	if e != nil {
+		return nil, e
+	}
+
+	r := &RYLR896{
+		serial:    serial,
+		lines:     lines,
+		received:  make(chan Message, 16),
+		responses: make(chan string, 1),
+		timeout:   3 * time.Second,
+	}
+	r.Received = r.received
+	go r.run()
+	return r, nil
+}
+
+// Stop reading from the modem. The serial module is left enabled.
+func (r *RYLR896) Close() {
+	r.lines.Stop()
+}
+
+// Set how long to wait for a response to a command. Sending takes longer at high spreading factors. Defaults to 3
+// seconds.
+func (r *RYLR896) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// Sort lines from the modem into received messages and command responses.
+func (r *RYLR896) run() {
+	defer close(r.received)
+
+	for line := range r.lines.Frames {
+		s := string(line.Data)
+		if strings.HasPrefix(s, "+RCV=") {
+			m, e := parseReceived(s[5:])
+			if e == nil {
+				r.received <- m
+			}
+			continue
+		}
+		if s == "" || s == "+READY" {
+			continue
+		}
+
+		// replace a response nobody waited for
+		select {
+		case <-r.responses:
+		default:
+		}
+		r.responses <- s
+	}
+}
+
+// Parse the fields of +RCV=<address>,<length>,<data>,<rssi>,<snr>. The data can contain commas, so it's found by
+// its length.
+func parseReceived(s string) (Message, error) {
+	fields := strings.SplitN(s, ",", 3)
+	if len(fields) != 3 {
+		return Message{}, fmt.Errorf("RYLR896 received a malformed message '%s'", s)
+	}
+	address, e1 := strconv.Atoi(fields[0])
+	length, e2 := strconv.Atoi(fields[1])
+	if e1 != nil || e2 != nil || length < 0 || length > len(fields[2]) {
+		return Message{}, fmt.Errorf("RYLR896 received a malformed message '%s'", s)
+	}
+
+	data := fields[2][:length]
+	tail := strings.Split(strings.TrimPrefix(fields[2][length:], ","), ",")
+	if len(tail) != 2 {
+		return Message{}, fmt.Errorf("RYLR896 received a malformed message '%s'", s)
+	}
+	rssi, e1 := strconv.Atoi(tail[0])
+	snr, e2 := strconv.Atoi(tail[1])
+	if e1 != nil || e2 != nil {
+		return Message{}, fmt.Errorf("RYLR896 received a malformed message '%s'", s)
+	}
+
+	return Message{Address: address, Data: []byte(data), RSSI: rssi, SNR: snr}, nil
+}
+
+// Send an AT command, without the "AT" prefix, e.g. "+ADDRESS?", and wait for the response. For queries, the value
+// is returned, e.g. "5" for "+ADDRESS=5". A "+ERR" response is returned as an error.
+func (r *RYLR896) Command(command string) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	select {
+	case <-r.responses:
+	default:
+	}
+
+	_, e := r.serial.Write([]byte("AT" + command + "\r\n"))
+	if e != nil {
+		return "", e
+	}
+
+	select {
+	case response, ok := <-r.responses:
+		if !ok {
+			return "", errors.New("RYLR896 is closed")
+		}
+		return parseResponse(command, response)
+	case <-time.After(r.timeout):
+		return "", fmt.Errorf("RYLR896 did not respond to AT%s", command)
+	}
+}
+
+func parseResponse(command string, response string) (string, error) {
+	if response == "+OK" {
+		return "", nil
+	}
+	if strings.HasPrefix(response, "+ERR=") {
+		code, _ := strconv.Atoi(response[5:])
+		if reason, ok := errorCodes[code]; ok {
+			return "", fmt.Errorf("RYLR896 AT%s failed: %s", command, reason)
+		}
+		return "", fmt.Errorf("RYLR896 AT%s failed with error %d", command, code)
+	}
+	if i := strings.IndexByte(response, '='); i >= 0 && response[0] == '+' {
+		return response[i+1:], nil
+	}
+	return response, nil
+}
+
+// Check the modem is responding.
+func (r *RYLR896) Test() error {
+	_, e := r.Command("")
+	return e
+}
+
+// Set the modem's address, 0 to 65535.
+func (r *RYLR896) SetAddress(address int) error {
+	if address < 0 || address > 65535 {
+		return fmt.Errorf("RYLR896 address must be 0-65535, got %d", address)
+	}
+	_, e := r.Command(fmt.Sprintf("+ADDRESS=%d", address))
+	return e
+}
+
+// Set the network ID, 0 to 16. Modems only receive messages from modems with the same network ID.
+func (r *RYLR896) SetNetworkID(id int) error {
+	if id < 0 || id > 16 {
+		return fmt.Errorf("RYLR896 network ID must be 0-16, got %d", id)
+	}
+	_, e := r.Command(fmt.Sprintf("+NETWORKID=%d", id))
+	return e
+}
+
+// Set the centre frequency in Hz, e.g. 868500000 or 915000000.
+func (r *RYLR896) SetBand(hz int) error {
+	_, e := r.Command(fmt.Sprintf("+BAND=%d", hz))
+	return e
+}
+
+// Set the RF parameters: spreading factor 7-12, bandwidth 0-9 (7 is 125kHz), coding rate 1-4 and preamble length
+// 4-7. Both ends must use the same parameters.
+func (r *RYLR896) SetParameters(spreadingFactor int, bandwidth int, codingRate int, preamble int) error {
+	_, e := r.Command(fmt.Sprintf("+PARAMETER=%d,%d,%d,%d", spreadingFactor, bandwidth, codingRate, preamble))
+	return e
+}
+
+// Set the RF output power, 0 to 15 dBm.
+func (r *RYLR896) SetPower(dbm int) error {
+	if dbm < 0 || dbm > 15 {
+		return fmt.Errorf("RYLR896 output power must be 0-15 dBm, got %d", dbm)
+	}
+	_, e := r.Command(fmt.Sprintf("+CRFOP=%d", dbm))
+	return e
+}
+
+// Set the AES128 password, as 8 hex digits. Both ends must use the same password.
+func (r *RYLR896) SetPassword(password string) error {
+	if len(password) != 8 {
+		return fmt.Errorf("RYLR896 password must be 8 hex digits, got '%s'", password)
+	}
+	_, e := r.Command("+CPIN=" + password)
+	return e
+}
+
+// Send data to the modem with an address, or to all modems on the network with BROADCAST_ADDRESS. This returns
+// once the modem has accepted the data; LoRa has no acknowledgement, so it doesn't mean it was received.
+func (r *RYLR896) Send(address int, data []byte) error {
+	if len(data) > MAX_DATA_LENGTH {
+		return fmt.Errorf("RYLR896 can send at most %d bytes, got %d", MAX_DATA_LENGTH, len(data))
+	}
+	if strings.Contains(string(data), "\r\n") {
+		return errors.New("RYLR896 data cannot contain \\r\\n")
+	}
+	_, e := r.Command(fmt.Sprintf("+SEND=%d,%d,%s", address, len(data), data))
+	return e
+}
@@ -0,0 +1,385 @@
+// Support for SDI-12 sensors, the serial bus used by environmental instruments such as soil moisture probes, water
+// level loggers and weather stations. Up to 62 sensors share one data line, each with a one character address; the
+// data recorder wakes them with a break and sends commands, and the addressed sensor replies, all at 1200 baud, 7
+// data bits, even parity and inverted logic.
+
+// Current status:
+// - supports the acknowledge, address query, identification, change address, measurement (M, M1-M9) and concurrent
+//   measurement (C) commands, and reading their data. Other commands can be sent with Command.
+// - CRC variants of the measurement commands are not supported.
+// - intended for hwio.SoftSerialModule on a single pin, half duplex. Most sensors are 5V and a 3.3V GPIO pin does not
+//   drive the line to the SDI-12 levels, so a level shifter is usually needed.
+// - waits run on hwio's Clock, but read deadlines are set on the serial port, which times them on the system clock.
+
+package sdi12
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The bus speed
+	BAUD = 1200
+
+	// The break that wakes the sensors before a command, and the marking that must follow it. The standard asks
+	// for at least 12ms and 8.33ms.
+	BREAK_TIME   = 12 * time.Millisecond
+	MARKING_TIME = 9 * time.Millisecond
+
+	// How long to wait for a sensor to start responding, and between characters of its response. The standard
+	// gives a sensor 15ms to respond; the rest allows for the delay in switching the line around in software.
+	RESPONSE_TIMEOUT = 100 * time.Millisecond
+
+	// How many times a command is retried when a sensor doesn't respond. The standard requires at least 3.
+	DEFAULT_RETRIES = 3
+
+	// The longest response a sensor can send, excluding the <CR><LF>
+	MAX_RESPONSE = 81
+)
+
+// The serial module the bus is on. It must be set to 1200 baud, 7 data bits, even parity and inverted, and be able
+// to send a break and time out reads, as hwio.SoftSerialModule is.
+type Port interface {
+	hwio.SerialBreakModule
+
+	SetReadDeadline(t time.Time) error
+}
+
+// What a sensor reports for the identification command.
+type Identification struct {
+	// The version of the SDI-12 standard, e.g. "1.3"
+	Version string
+
+	// The vendor (8 characters), model (6) and model version (3), with trailing spaces removed
+	Vendor       string
+	Model        string
+	ModelVersion string
+
+	// Anything else, often the serial number
+	Other string
+}
+
+type SDI12 struct {
+	// serialises commands, as responses are not tagged with the command they answer
+	sync.Mutex
+
+	port    Port
+	retries int
+
+	// bytes received after the last response
+	pending []byte
+	buffer  []byte
+}
+
+// Create an SDI-12 data recorder on a serial module, which must be enabled.
+func NewSDI12(port Port) *SDI12 {
+	return &SDI12{port: port, retries: DEFAULT_RETRIES, buffer: make([]byte, MAX_RESPONSE+2)}
+}
+
+// Set how many times a command is retried when a sensor doesn't respond. Defaults to 3.
+func (s *SDI12) SetRetries(retries int) {
+	s.retries = retries
+}
+
+// Send a command, e.g. "0I!", and return the response without its <CR><LF>. The command is preceded by a break,
+// and retried if the sensor does not respond, or responds with another address.
+func (s *SDI12) Command(command string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.command(command)
+}
+
+// Check a sensor is present and responding.
+func (s *SDI12) Acknowledge(address byte) error {
+	s.Lock()
+	defer s.Unlock()
+	_, e := s.addressed(address, "")
+	return e
+}
+
+// Return the address of the sensor on the bus. This only works with a single sensor, as all of them respond.
+func (s *SDI12) QueryAddress() (byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	response, e := s.command("?!")
+	if e != nil {
+		return 0, e
+	}
+	if len(response) != 1 || !validAddress(response[0]) {
+		return 0, fmt.Errorf("SDI-12 sensor returned an invalid address '%s'", response)
+	}
+	return response[0], nil
+}
+
+// Change the address of a sensor.
+func (s *SDI12) ChangeAddress(from byte, to byte) error {
+	if !validAddress(from) || !validAddress(to) {
+		return fmt.Errorf("SDI-12 address change from '%c' to '%c' is not valid", from, to)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	response, e := s.command(fmt.Sprintf("%cA%c!", from, to))
+	if e == nil && response != string(to) {
+		e = fmt.Errorf("SDI-12 sensor '%c' responded to the address change with '%s'", from, response)
+	}
+	return e
+}
+
+// Return a sensor's identification.
+func (s *SDI12) Identify(address byte) (Identification, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	response, e := s.addressed(address, "I")
+	if e != nil {
+		return Identification{}, e
+	}
+	if len(response) < 19 {
+		return Identification{}, fmt.Errorf("SDI-12 sensor '%c' returned a short identification '%s'", address, response)
+	}
+	return Identification{
+		Version:      response[0:1] + "." + response[1:2],
+		Vendor:       strings.TrimRight(response[2:10], " "),
+		Model:        strings.TrimRight(response[10:16], " "),
+		ModelVersion: strings.TrimRight(response[16:19], " "),
+		Other:        response[19:],
+	}, nil
+}
+
+// Take a measurement, returning its values. This waits for the sensor to say it has finished, or for the time it
+// said the measurement would take, which can be several seconds.
+func (s *SDI12) Measure(address byte) ([]float64, error) {
+	return s.measure(address, "M")
+}
+
+// Take one of a sensor's additional measurements, numbered 1 to 9, returning its values.
+func (s *SDI12) MeasureAdditional(address byte, n int) ([]float64, error) {
+	if n < 1 || n > 9 {
+		return nil, fmt.Errorf("SDI-12 additional measurements are numbered 1 to 9, got %d", n)
+	}
+	return s.measure(address, "M"+strconv.Itoa(n))
+}
+
+// Take measurements on several sensors at once, returning the values of each by address. The sensors measure
+// together, so this takes as long as the slowest of them.
+func (s *SDI12) MeasureConcurrent(addresses []byte) (map[byte][]float64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	counts := make(map[byte]int)
+	ready := hwio.GetClock().Now()
+	for _, address := range addresses {
+		response, e := s.addressed(address, "C")
+		if e != nil {
+			return nil, e
+		}
+		wait, count, e := parseMeasurementResponse(address, response, 2)
+		if e != nil {
+			return nil, e
+		}
+		counts[address] = count
+		if t := hwio.GetClock().Now().Add(wait); t.After(ready) {
+			ready = t
+		}
+	}
+
+	hwio.GetClock().Sleep(ready.Sub(hwio.GetClock().Now()))
+
+	result := make(map[byte][]float64)
+	for _, address := range addresses {
+		values, e := s.data(address, counts[address])
+		if e != nil {
+			return result, e
+		}
+		result[address] = values
+	}
+	return result, nil
+}
+
+// Start a measurement, wait for it, and collect its values.
+func (s *SDI12) measure(address byte, command string) ([]float64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	response, e := s.addressed(address, command)
+	if e != nil {
+		return nil, e
+	}
+	wait, count, e := parseMeasurementResponse(address, response, 1)
+	if e != nil {
+		return nil, e
+	}
+	if wait > 0 {
+		s.waitForServiceRequest(address, wait)
+	}
+	return s.data(address, count)
+}
+
+// Wait for the service request a sensor sends when its measurement is done, or until the time it said it would
+// take has passed.
+func (s *SDI12) waitForServiceRequest(address byte, wait time.Duration) {
+	defer s.port.SetReadDeadline(time.Time{})
+
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		line, e := s.readLine(deadline)
+		if e != nil || line == string(address) {
+			return
+		}
+	}
+}
+
+// Send data commands, D0 to D9, until count values have been returned.
+func (s *SDI12) data(address byte, count int) ([]float64, error) {
+	values := make([]float64, 0, count)
+	for i := 0; i <= 9 && len(values) < count; i++ {
+		response, e := s.addressed(address, "D"+strconv.Itoa(i))
+		if e != nil {
+			return values, e
+		}
+		v, e := parseValues(response)
+		if e != nil {
+			return values, fmt.Errorf("SDI-12 sensor '%c' %s", address, e)
+		}
+		if len(v) == 0 {
+			break
+		}
+		values = append(values, v...)
+	}
+	if len(values) < count {
+		return values, fmt.Errorf("SDI-12 sensor '%c' returned %d values, expected %d", address, len(values), count)
+	}
+	return values, nil
+}
+
+// Send a command to an address, returning the response after the address.
+func (s *SDI12) addressed(address byte, command string) (string, error) {
+	if !validAddress(address) {
+		return "", fmt.Errorf("SDI-12 address '%c' is not valid", address)
+	}
+	response, e := s.command(string(address) + command + "!")
+	if e != nil {
+		return "", e
+	}
+	return response[1:], nil
+}
+
+// Send a command, retrying until a response from the right address is received.
+func (s *SDI12) command(command string) (string, error) {
+	defer s.port.SetReadDeadline(time.Time{})
+
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		s.discard()
+
+		e := s.port.SendBreak(BREAK_TIME)
+		if e != nil {
+			return "", e
+		}
+		hwio.GetClock().Sleep(MARKING_TIME)
+		_, e = s.port.Write([]byte(command))
+		if e != nil {
+			return "", e
+		}
+
+		response, e := s.readLine(time.Time{})
+		if errors.Is(e, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if e != nil {
+			return "", e
+		}
+		if command[0] == '?' || (len(response) > 0 && response[0] == command[0]) {
+			return response, nil
+		}
+	}
+	return "", fmt.Errorf("SDI-12 sensor did not respond to %s", command)
+}
+
+// Read a line, without its <CR><LF>. Each read waits for RESPONSE_TIMEOUT, or until the deadline if it is not zero.
+func (s *SDI12) readLine(deadline time.Time) (string, error) {
+	for {
+		if i := bytes.Index(s.pending, []byte("\r\n")); i >= 0 {
+			line := string(s.pending[:i])
+			s.pending = s.pending[i+2:]
+			return line, nil
+		}
+		if len(s.pending) > MAX_RESPONSE {
+			s.pending = nil
+			return "", errors.New("SDI-12 response is too long")
+		}
+
+		t := deadline
+		if t.IsZero() {
+			t = time.Now().Add(RESPONSE_TIMEOUT)
+		}
+		s.port.SetReadDeadline(t)
+		n, e := s.port.Read(s.buffer)
+		if e != nil {
+			return "", e
+		}
+		s.pending = append(s.pending, s.buffer[:n]...)
+	}
+}
+
+// Throw away anything received since the last response, such as a late service request.
+func (s *SDI12) discard() {
+	s.pending = s.pending[:0]
+	s.port.SetReadDeadline(time.Now())
+	for {
+		n, e := s.port.Read(s.buffer)
+		if e != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// Parse the response to a measurement command: atttn for M, or atttnn for C, where ttt is the seconds until the
+// measurement is ready and n the number of values.
+func parseMeasurementResponse(address byte, response string, countDigits int) (time.Duration, int, error) {
+	if len(response) != 3+countDigits {
+		return 0, 0, fmt.Errorf("SDI-12 sensor '%c' returned an invalid measurement response '%s'", address, response)
+	}
+	seconds, e1 := strconv.Atoi(response[:3])
+	count, e2 := strconv.Atoi(response[3:])
+	if e1 != nil || e2 != nil {
+		return 0, 0, fmt.Errorf("SDI-12 sensor '%c' returned an invalid measurement response '%s'", address, response)
+	}
+	return time.Duration(seconds) * time.Second, count, nil
+}
+
+// Parse the values of a data response, each of which starts with its sign, e.g. "+21.5-0.31+1013".
+func parseValues(response string) ([]float64, error) {
+	values := make([]float64, 0)
+	start := 0
+	for i := 1; i <= len(response); i++ {
+		if i < len(response) && response[i] != '+' && response[i] != '-' {
+			continue
+		}
+		if response[start] != '+' && response[start] != '-' {
+			return values, fmt.Errorf("returned an invalid value '%s'", response[start:i])
+		}
+		v, e := strconv.ParseFloat(response[start:i], 64)
+		if e != nil {
+			return values, fmt.Errorf("returned an invalid value '%s'", response[start:i])
+		}
+		values = append(values, v)
+		start = i
+	}
+	return values, nil
+}
+
+// Determine if a character can be a sensor address: 0-9, a-z or A-Z.
+func validAddress(address byte) bool {
+	return (address >= '0' && address <= '9') || (address >= 'a' && address <= 'z') || (address >= 'A' && address <= 'Z')
+}
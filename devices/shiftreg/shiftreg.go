@@ -0,0 +1,238 @@
+// Support for chains of shift registers, such as the 74HC595, TPIC6B595 and TLC5940, daisy-chained with the serial
+// output of each feeding the serial input of the next. Outputs are set by their index along the chain, from the first
+// output of the register nearest the host, and the whole chain is shifted out and latched by Update. Data is sent
+// over SPI if a module is given, which is much faster, and otherwise bit-banged on GPIO pins.
+
+// Current status:
+// - each output can have several bits, e.g. 12 for the greyscale of a TLC5940, but the chain must be a whole number
+//   of bytes long when SPI is used.
+// - the latch is pulsed after the data is shifted; the blank (or output enable) pin is only changed by Blank, which
+//   drivers of chips such as the TLC5940 use around the latch.
+
+package shiftreg
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cinellodev/hwio"
+)
+
+type Config struct {
+	// The SPI module and chip select to send data on. If SPI is nil, the data is bit-banged on Data and Clock, which
+	// must be GPIO pins.
+	SPI         hwio.SPIModule
+	SlaveSelect int
+	Data        hwio.Pin
+	Clock       hwio.Pin
+
+	// The pin that latches shifted data to the outputs: ST_CP (RCK) on a 74HC595 or TPIC6B595, XLAT on a TLC5940.
+	Latch hwio.Pin
+
+	// The pin that blanks the outputs, if UseBlank is true: OE on a 74HC595, G on a TPIC6B595, BLANK on a TLC5940.
+	// BlankActiveHigh is true if the outputs are blanked by driving it high, as on a TLC5940; on the 595s it is
+	// active low.
+	Blank           hwio.Pin
+	UseBlank        bool
+	BlankActiveHigh bool
+
+	// The number of registers in the chain, and the outputs of each, e.g. 8 for a 74HC595 or 16 for a TLC5940
+	Registers          int
+	OutputsPerRegister int
+
+	// The bits of each output: 1 for the 595s, 12 for the greyscale of a TLC5940. Defaults to 1.
+	BitsPerOutput int
+}
+
+type ShiftRegisterChain struct {
+	config Config
+
+	// guards values
+	sync.Mutex
+	values []uint16
+}
+
+// Create a chain, setting up its pins as outputs. The outputs start at 0, and blanked if there is a blank pin; call
+// Update to send them, and Blank(false) to show them.
+func NewShiftRegisterChain(config Config) (*ShiftRegisterChain, error) {
+	if config.Registers <= 0 || config.OutputsPerRegister <= 0 {
+		return nil, errors.New("a shift register chain needs the number of registers and their outputs")
+	}
+	if config.BitsPerOutput == 0 {
+		config.BitsPerOutput = 1
+	}
+	if config.BitsPerOutput < 0 || config.BitsPerOutput > 16 {
+		return nil, fmt.Errorf("shift register outputs can have 1 to 16 bits, not %d", config.BitsPerOutput)
+	}
+	c := &ShiftRegisterChain{config: config, values: make([]uint16, config.Registers*config.OutputsPerRegister)}
+	if config.SPI != nil && c.Bits()%8 != 0 {
+		return nil, fmt.Errorf("a shift register chain on SPI must be whole bytes, this one is %d bits", c.Bits())
+	}
+
+	pins := hwio.PinList{config.Latch}
+	if config.SPI == nil {
+		pins = append(pins, config.Data, config.Clock)
+	}
+	for _, pin := range pins {
+		if e := hwio.PinModeOutputInit(pin, hwio.Low); e != nil {
+			return nil, e
+		}
+	}
+	if config.UseBlank {
+		if e := hwio.PinModeOutputInit(config.Blank, c.blankLevel(true)); e != nil {
+			return nil, e
+		}
+	}
+	return c, nil
+}
+
+// Return the number of outputs in the chain.
+func (c *ShiftRegisterChain) Len() int {
+	return len(c.values)
+}
+
+// Return the number of bits shifted to update the chain.
+func (c *ShiftRegisterChain) Bits() int {
+	return len(c.values) * c.config.BitsPerOutput
+}
+
+// Set an output by its index along the chain, from 0 for the first output (Q0 or QA, or OUT0) of the register
+// nearest the host. A 1 bit output is on for any value but 0; others are limited to their largest value. The output
+// changes when Update is called.
+func (c *ShiftRegisterChain) Set(index int, value int) error {
+	if index < 0 || index >= len(c.values) {
+		return fmt.Errorf("shift register chain has no output %d", index)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.values[index] = c.limit(value)
+	return nil
+}
+
+// Set all the outputs to a value.
+func (c *ShiftRegisterChain) SetAll(value int) {
+	c.Lock()
+	defer c.Unlock()
+	v := c.limit(value)
+	for i := range c.values {
+		c.values[i] = v
+	}
+}
+
+// Return the value of an output, as last set.
+func (c *ShiftRegisterChain) Get(index int) int {
+	c.Lock()
+	defer c.Unlock()
+	if index < 0 || index >= len(c.values) {
+		return 0
+	}
+	return int(c.values[index])
+}
+
+// Limit a value to the bits of an output.
+func (c *ShiftRegisterChain) limit(value int) uint16 {
+	max := 1<<uint(c.config.BitsPerOutput) - 1
+	if value < 0 {
+		return 0
+	}
+	if c.config.BitsPerOutput == 1 && value != 0 || value > max {
+		return uint16(max)
+	}
+	return uint16(value)
+}
+
+// Return the data to shift: the outputs from the last to the first, each most significant bit first, so that once
+// it's shifted along the chain each value is at its output.
+func (c *ShiftRegisterChain) Bytes() []byte {
+	c.Lock()
+	defer c.Unlock()
+	return Pack(c.values, c.config.BitsPerOutput)
+}
+
+// Pack values of a number of bits each into bytes, as Bytes does: from the last value to the first, each most
+// significant bit first, padded with 0 bits at the end to a whole byte.
+func Pack(values []uint16, bits int) []byte {
+	result := make([]byte, (len(values)*bits+7)/8)
+	n := 0
+	for i := len(values) - 1; i >= 0; i-- {
+		for b := bits - 1; b >= 0; b-- {
+			if values[i]>>uint(b)&1 != 0 {
+				result[n/8] |= 0x80 >> uint(n%8)
+			}
+			n++
+		}
+	}
+	return result
+}
+
+// Shift the outputs along the chain and latch them.
+func (c *ShiftRegisterChain) Update() error {
+	if e := c.Shift(); e != nil {
+		return e
+	}
+	return c.Latch()
+}
+
+// Shift the outputs along the chain without latching them, for drivers that must do something between the two.
+func (c *ShiftRegisterChain) Shift() error {
+	return c.ShiftData(c.Bytes(), c.Bits())
+}
+
+// Shift the first bits of data along the chain's pins, most significant first, for chips that load other registers
+// through the same pins, such as the dot correction of a TLC5940. On SPI the data is sent whole.
+func (c *ShiftRegisterChain) ShiftData(data []byte, bits int) error {
+	if c.config.SPI != nil {
+		return c.config.SPI.Write(c.config.SlaveSelect, data)
+	}
+	for n := 0; n < bits; n++ {
+		if e := hwio.DigitalWrite(c.config.Data, int(data[n/8]>>uint(7-n%8)&1)); e != nil {
+			return e
+		}
+		if e := hwio.DigitalWrite(c.config.Clock, hwio.High); e != nil {
+			return e
+		}
+		if e := hwio.DigitalWrite(c.config.Clock, hwio.Low); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Pulse the latch, so the shifted data appears on the outputs.
+func (c *ShiftRegisterChain) Latch() error {
+	if e := hwio.DigitalWrite(c.config.Latch, hwio.High); e != nil {
+		return e
+	}
+	return hwio.DigitalWrite(c.config.Latch, hwio.Low)
+}
+
+// Blank the outputs, turning them all off without changing their values, or show them again.
+func (c *ShiftRegisterChain) Blank(blank bool) error {
+	if !c.config.UseBlank {
+		return errors.New("shift register chain has no blank pin")
+	}
+	return hwio.DigitalWrite(c.config.Blank, c.blankLevel(blank))
+}
+
+// Return the level of the blank pin that blanks the outputs or shows them.
+func (c *ShiftRegisterChain) blankLevel(blank bool) int {
+	if blank == c.config.BlankActiveHigh {
+		return hwio.High
+	}
+	return hwio.Low
+}
+
+// Blank the outputs if there is a blank pin, and close the chain's pins.
+func (c *ShiftRegisterChain) Close() error {
+	if c.config.UseBlank {
+		c.Blank(true)
+		hwio.ClosePin(c.config.Blank)
+	}
+	hwio.ClosePin(c.config.Latch)
+	if c.config.SPI == nil {
+		hwio.ClosePin(c.config.Data)
+		hwio.ClosePin(c.config.Clock)
+	}
+	return nil
+}
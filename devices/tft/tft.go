@@ -0,0 +1,326 @@
+// Support for ILI9341 and ST7789 TFT displays over SPI, with a frame buffer that can be drawn on with the standard
+// image and image/draw packages.
+
+// Current status:
+// - the display is drawn in 16-bit colour (RGB565). Drawing goes to an image.RGBA buffer, and the parts that have
+//   changed are sent to the display by Flush, as a single rectangle covering them all.
+// - pixel data is sent in chunks no larger than spidev's buffer (4096 bytes by default; see the bufsiz parameter of
+//   the spidev kernel module), so each chunk is one transfer the controller can DMA.
+// - the display's data/command line must be on a GPIO pin. Reading from the display is not supported.
+
+package tft
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The controllers supported
+	ILI9341 = iota
+	ST7789
+
+	// Commands common to both controllers (MIPI DCS)
+	CMD_SWRESET = 0x01
+	CMD_SLPOUT  = 0x11
+	CMD_NORON   = 0x13
+	CMD_INVOFF  = 0x20
+	CMD_INVON   = 0x21
+	CMD_DISPOFF = 0x28
+	CMD_DISPON  = 0x29
+	CMD_CASET   = 0x2a
+	CMD_RASET   = 0x2b
+	CMD_RAMWR   = 0x2c
+	CMD_MADCTL  = 0x36
+	CMD_COLMOD  = 0x3a
+
+	// MADCTL bits
+	MADCTL_MY  = 0x80
+	MADCTL_MX  = 0x40
+	MADCTL_MV  = 0x20
+	MADCTL_BGR = 0x08
+
+	// 16 bits per pixel, for COLMOD
+	COLMOD_RGB565 = 0x55
+
+	// The size of spidev's buffer, by default
+	DEFAULT_CHUNK_SIZE = 4096
+)
+
+// How a display is connected and set up.
+type Config struct {
+	// ILI9341 or ST7789
+	Controller int
+
+	// The size of the panel, in its natural (rotation 0) orientation. Defaults to 240x320.
+	Width  int
+	Height int
+
+	// Rotation of the display, as 0, 90, 180 or 270 degrees. The image is the rotated size.
+	Rotation int
+
+	// Offsets of the panel in the controller's memory, for panels smaller than the controller (e.g. 240x240
+	// ST7789 panels, which need a YOffset of 80 at rotations of 180 and 270).
+	XOffset int
+	YOffset int
+
+	// The chip select of the display on the SPI module
+	SlaveSelect int
+
+	// The data/command pin, and the reset pin if UseResetPin is true
+	DCPin       hwio.Pin
+	ResetPin    hwio.Pin
+	UseResetPin bool
+
+	// The largest SPI transfer. Defaults to DEFAULT_CHUNK_SIZE.
+	ChunkSize int
+}
+
+type Display struct {
+	spi    hwio.SPIModule
+	config Config
+
+	image *image.RGBA
+
+	// the part of the image that has changed since the last flush
+	dirty image.Rectangle
+
+	// buffer for converting the image to RGB565
+	pixels []byte
+}
+
+// Create a display on an SPI module, which must be enabled. The display is not touched until Init is called.
+func NewDisplay(spi hwio.SPIModule, config Config) (*Display, error) {
+	if config.Controller != ILI9341 && config.Controller != ST7789 {
+		return nil, fmt.Errorf("unsupported TFT controller %d", config.Controller)
+	}
+	if config.Width == 0 {
+		config.Width = 240
+	}
+	if config.Height == 0 {
+		config.Height = 320
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DEFAULT_CHUNK_SIZE
+	}
+
+	width, height := config.Width, config.Height
+	switch config.Rotation {
+	case 0, 180:
+	case 90, 270:
+		width, height = height, width
+	default:
+		return nil, fmt.Errorf("TFT rotation must be 0, 90, 180 or 270, got %d", config.Rotation)
+	}
+
+	d := &Display{spi: spi, config: config}
+	d.image = image.NewRGBA(image.Rect(0, 0, width, height))
+	d.dirty = d.image.Bounds()
+	return d, nil
+}
+
+// Reset and initialise the display, and clear it to black.
+func (d *Display) Init() error {
+	e := hwio.PinModeOutputInit(d.config.DCPin, hwio.High)
+	if e != nil {
+		return e
+	}
+	if d.config.UseResetPin {
+		e = hwio.PinModeOutputInit(d.config.ResetPin, hwio.High)
+		if e == nil {
+			e = hwio.Pulse(d.config.ResetPin, hwio.Low, 20)
+		}
+		if e != nil {
+			return e
+		}
+		hwio.GetClock().Sleep(150 * time.Millisecond)
+	}
+
+	e = d.command(CMD_SWRESET)
+	if e != nil {
+		return e
+	}
+	hwio.GetClock().Sleep(150 * time.Millisecond)
+	e = d.command(CMD_SLPOUT)
+	if e != nil {
+		return e
+	}
+	hwio.GetClock().Sleep(120 * time.Millisecond)
+
+	var init [][]byte
+	if d.config.Controller == ILI9341 {
+		init = [][]byte{
+			{0xc0, 0x23},             // power control 1
+			{0xc1, 0x10},             // power control 2
+			{0xc5, 0x3e, 0x28},       // VCOM control 1
+			{0xc7, 0x86},             // VCOM control 2
+			{0xb1, 0x00, 0x18},       // frame rate, 79Hz
+			{0xb6, 0x08, 0x82, 0x27}, // display function control
+			{0x26, 0x01},             // gamma curve 1
+			{CMD_INVOFF},
+		}
+	} else {
+		// ST7789 panels are generally wired so colours are inverted
+		init = [][]byte{{CMD_INVON}}
+	}
+	init = append(init, []byte{CMD_COLMOD, COLMOD_RGB565}, []byte{CMD_MADCTL, d.madctl()}, []byte{CMD_NORON})
+	for _, c := range init {
+		e = d.command(c[0], c[1:]...)
+		if e != nil {
+			return e
+		}
+	}
+
+	e = d.FlushAll()
+	if e != nil {
+		return e
+	}
+	return d.command(CMD_DISPON)
+}
+
+// The memory access control value for the rotation.
+func (d *Display) madctl() byte {
+	if d.config.Controller == ILI9341 {
+		switch d.config.Rotation {
+		case 90:
+			return MADCTL_MV | MADCTL_BGR
+		case 180:
+			return MADCTL_MY | MADCTL_BGR
+		case 270:
+			return MADCTL_MX | MADCTL_MY | MADCTL_MV | MADCTL_BGR
+		}
+		return MADCTL_MX | MADCTL_BGR
+	}
+
+	switch d.config.Rotation {
+	case 90:
+		return MADCTL_MX | MADCTL_MV
+	case 180:
+		return MADCTL_MX | MADCTL_MY
+	case 270:
+		return MADCTL_MY | MADCTL_MV
+	}
+	return 0
+}
+
+// Send a command, with its parameters.
+func (d *Display) command(cmd byte, parameters ...byte) error {
+	e := hwio.DigitalWrite(d.config.DCPin, hwio.Low)
+	if e == nil {
+		e = d.spi.Write(d.config.SlaveSelect, []byte{cmd})
+	}
+	if e == nil {
+		e = hwio.DigitalWrite(d.config.DCPin, hwio.High)
+	}
+	if e != nil || len(parameters) == 0 {
+		return e
+	}
+	return d.spi.Write(d.config.SlaveSelect, parameters)
+}
+
+// Turn the display on or off. The frame buffer is kept while it's off.
+func (d *Display) SetOn(on bool) error {
+	if on {
+		return d.command(CMD_DISPON)
+	}
+	return d.command(CMD_DISPOFF)
+}
+
+// Return the frame buffer. Changes made to it directly are not sent to the display until the area changed is
+// marked with MarkDirty, or FlushAll is called; drawing through the Display itself (as a draw.Image) marks the
+// pixels it sets.
+func (d *Display) Image() *image.RGBA {
+	return d.image
+}
+
+// Mark part of the frame buffer as changed, so it is sent by the next Flush.
+func (d *Display) MarkDirty(r image.Rectangle) {
+	d.dirty = d.dirty.Union(r.Intersect(d.image.Bounds()))
+}
+
+// The size of the display, as rotated.
+func (d *Display) Bounds() image.Rectangle {
+	return d.image.Bounds()
+}
+
+func (d *Display) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (d *Display) At(x, y int) color.Color {
+	return d.image.At(x, y)
+}
+
+// Set a pixel in the frame buffer, and mark it as changed. This makes the Display a draw.Image, so it can be drawn
+// on with image/draw and other libraries.
+func (d *Display) Set(x, y int, c color.Color) {
+	d.image.Set(x, y, c)
+	d.MarkDirty(image.Rect(x, y, x+1, y+1))
+}
+
+// Send the parts of the frame buffer that have changed since the last flush to the display.
+func (d *Display) Flush() error {
+	if d.dirty.Empty() {
+		return nil
+	}
+	e := d.flushRect(d.dirty)
+	if e == nil {
+		d.dirty = image.Rectangle{}
+	}
+	return e
+}
+
+// Send the whole frame buffer to the display.
+func (d *Display) FlushAll() error {
+	d.dirty = d.image.Bounds()
+	return d.Flush()
+}
+
+// Send a rectangle of the frame buffer to the display, in chunks of at most ChunkSize bytes.
+func (d *Display) flushRect(r image.Rectangle) error {
+	x0, y0 := uint16(r.Min.X+d.config.XOffset), uint16(r.Min.Y+d.config.YOffset)
+	x1, y1 := uint16(r.Max.X-1+d.config.XOffset), uint16(r.Max.Y-1+d.config.YOffset)
+
+	e := d.command(CMD_CASET, byte(x0>>8), byte(x0), byte(x1>>8), byte(x1))
+	if e == nil {
+		e = d.command(CMD_RASET, byte(y0>>8), byte(y0), byte(y1>>8), byte(y1))
+	}
+	if e == nil {
+		e = d.command(CMD_RAMWR)
+	}
+	if e != nil {
+		return e
+	}
+
+	d.pixels = d.pixels[:0]
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := d.image.Pix[d.image.PixOffset(r.Min.X, y):d.image.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			c := RGB565(row[i], row[i+1], row[i+2])
+			d.pixels = append(d.pixels, byte(c>>8), byte(c))
+		}
+	}
+
+	// chunks are a whole number of pixels, so a pixel is never split between transfers
+	chunk := d.config.ChunkSize &^ 1
+	for start := 0; start < len(d.pixels); start += chunk {
+		end := start + chunk
+		if end > len(d.pixels) {
+			end = len(d.pixels)
+		}
+		e = d.spi.Write(d.config.SlaveSelect, d.pixels[start:end])
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Convert 8-bit red, green and blue to the 16-bit RGB565 format the display uses.
+func RGB565(r, g, b uint8) uint16 {
+	return uint16(r&0xf8)<<8 | uint16(g&0xfc)<<3 | uint16(b>>3)
+}
@@ -0,0 +1,444 @@
+// Support for the Texas Instruments TLC5940 and TLC59711 constant current LED drivers, daisy-chained for any number of
+// outputs. The TLC5940 has 16 outputs of 12 bit greyscale and 6 bit dot correction, shifted in like a shift register,
+// and needs a greyscale clock (GSCLK) and a BLANK pulse every 4096 clocks to keep its outputs on; this driver
+// generates GSCLK with hardware PWM and pulses BLANK from a background refresh loop, latching new data during the
+// pulse so changes don't flicker. The TLC59711 has 12 outputs of 16 bit greyscale, as 4 RGB LEDs, and an internal
+// clock, so it only needs its data sent.
+
+// Current status:
+// - TLC5940 data is shifted over SPI if a module is given, and otherwise bit-banged on GPIO pins. GSCLK uses hardware
+//   PWM on a pin that has it; on other pins it is bit-banged, which is only fast enough for a GSCLKHz of a few kHz.
+// - the refresh loop is a hwio.PeriodicTask, so a late BLANK pulse leaves the outputs off for the rest of the cycle,
+//   seen as a brief flicker on a busy system; a real-time priority in RefreshPriority helps.
+// - dot correction needs the VPRG pin. It is kept in the chips' registers, not their EEPROM, and is lost at power off.
+// - XERR (LED open or over temperature) and the status read back on SOUT aren't read.
+// - the TLC59711 is written on SPI or bit-banged on GPIO pins. It latches its data itself once the clock has been idle
+//   for 8 times the last clock period, so a bit-banged chain that is paused mid frame can latch partial data.
+
+package tlc59xx
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+	"github.com/cinellodev/hwio/devices/shiftreg"
+)
+
+const (
+	// Outputs of each chip, and the largest greyscale and dot correction values
+	TLC5940_OUTPUTS         = 16
+	TLC5940_MAX_GREYSCALE   = 4095
+	TLC5940_MAX_DOT         = 63
+	TLC5940_DEFAULT_GSCLKHZ = 409600
+
+	TLC59711_OUTPUTS        = 12
+	TLC59711_LEDS           = 4
+	TLC59711_MAX_GREYSCALE  = 65535
+	TLC59711_MAX_BRIGHTNESS = 127
+
+	// The write command that starts each chip's 224 bits of TLC59711 data
+	TLC59711_WRITE = 0x25
+
+	// TLC59711 function control bits, which follow the write command
+	TLC59711_OUTTMG = 1 << 4 // outputs change on the rising edge of the greyscale clock
+	TLC59711_EXTGCK = 1 << 3 // use SCKI as the greyscale clock, rather than the internal oscillator
+	TLC59711_TMGRST = 1 << 2 // restart the greyscale cycle when data is latched
+	TLC59711_DSPRPT = 1 << 1 // repeat the greyscale cycle, rather than turning the outputs off after one
+	TLC59711_BLANK  = 1 << 0 // turn all outputs off
+)
+
+type TLC5940Config struct {
+	// The SPI module and chip select to send data on. If SPI is nil, the data is bit-banged on SIN and SCLK, which
+	// must be GPIO pins.
+	SPI         hwio.SPIModule
+	SlaveSelect int
+	SIN         hwio.Pin
+	SCLK        hwio.Pin
+
+	// The pins that latch data, blank the outputs, and generate the greyscale clock
+	XLAT  hwio.Pin
+	BLANK hwio.Pin
+	GSCLK hwio.Pin
+
+	// The pin that selects dot correction, if UseVPRG is true. Otherwise VPRG must be tied to ground.
+	VPRG    hwio.Pin
+	UseVPRG bool
+
+	// The number of chips in the chain. Defaults to 1.
+	Chips int
+
+	// The greyscale clock frequency. A greyscale cycle is 4096 clocks, so the default of 409.6kHz refreshes the
+	// outputs every 10ms.
+	GSCLKHz float64
+
+	// A real-time priority for the refresh loop's thread, 1 to 99, or 0 for normal
+	RefreshPriority int
+}
+
+type TLC5940 struct {
+	config  TLC5940Config
+	chain   *shiftreg.ShiftRegisterChain
+	gsclk   *hwio.SignalGenerator
+	refresh *hwio.PeriodicTask
+
+	// guards the pins while data is shifted, dot, and whether there is shifted data to latch
+	sync.Mutex
+	dot     []uint16
+	pending bool
+}
+
+// Create a chain of TLC5940s, setting up the pins, and start the greyscale clock and refresh loop. The outputs start
+// at 0, and dot correction at its largest, as it is at power on.
+func NewTLC5940(config TLC5940Config) (*TLC5940, error) {
+	if config.Chips == 0 {
+		config.Chips = 1
+	}
+	if config.Chips < 0 {
+		return nil, errors.New("TLC5940 chain can't have a negative number of chips")
+	}
+	if config.GSCLKHz == 0 {
+		config.GSCLKHz = TLC5940_DEFAULT_GSCLKHZ
+	}
+	if config.GSCLKHz < 0 {
+		return nil, errors.New("TLC5940 greyscale clock can't have a negative frequency")
+	}
+
+	chain, e := shiftreg.NewShiftRegisterChain(shiftreg.Config{
+		SPI:                config.SPI,
+		SlaveSelect:        config.SlaveSelect,
+		Data:               config.SIN,
+		Clock:              config.SCLK,
+		Latch:              config.XLAT,
+		Blank:              config.BLANK,
+		UseBlank:           true,
+		BlankActiveHigh:    true,
+		Registers:          config.Chips,
+		OutputsPerRegister: TLC5940_OUTPUTS,
+		BitsPerOutput:      12,
+	})
+	if e != nil {
+		return nil, e
+	}
+	d := &TLC5940{config: config, chain: chain, dot: make([]uint16, config.Chips*TLC5940_OUTPUTS)}
+	for i := range d.dot {
+		d.dot[i] = TLC5940_MAX_DOT
+	}
+	if config.UseVPRG {
+		if e := hwio.PinModeOutputInit(config.VPRG, hwio.Low); e != nil {
+			chain.Close()
+			return nil, e
+		}
+	}
+
+	// shift the outputs' initial 0s, so the first refresh latches them rather than whatever the chips powered up with
+	if e := d.Update(); e != nil {
+		d.Close()
+		return nil, e
+	}
+	if !hasPWM(config.GSCLK) {
+		if e := hwio.PinModeOutputInit(config.GSCLK, hwio.Low); e != nil {
+			d.Close()
+			return nil, e
+		}
+	}
+	d.gsclk, e = hwio.GenerateSquare(config.GSCLK, config.GSCLKHz, 0.5, 0)
+	if e != nil {
+		d.Close()
+		return nil, e
+	}
+	cycle := time.Duration(float64(time.Second) / config.GSCLKHz * 4096)
+	d.refresh, e = hwio.NewPeriodicTask(hwio.PeriodicTaskConfig{Period: cycle, Priority: config.RefreshPriority}, d.refreshCycle)
+	if e != nil {
+		d.Close()
+		return nil, e
+	}
+	return d, nil
+}
+
+// Return the number of outputs in the chain.
+func (d *TLC5940) Len() int {
+	return d.chain.Len()
+}
+
+// Set the greyscale of an output, 0 to 4095, by its index along the chain, from 0 for OUT0 of the chip nearest the
+// host. The output changes when Update is called.
+func (d *TLC5940) Set(index int, value int) error {
+	return d.chain.Set(index, value)
+}
+
+// Set the greyscale of all outputs.
+func (d *TLC5940) SetAll(value int) {
+	d.chain.SetAll(value)
+}
+
+// Return the greyscale of an output, as last set.
+func (d *TLC5940) Get(index int) int {
+	return d.chain.Get(index)
+}
+
+// Shift the greyscale of all outputs to the chips. They are latched at the start of the next greyscale cycle, so
+// the outputs change together without a glitch.
+func (d *TLC5940) Update() error {
+	d.Lock()
+	defer d.Unlock()
+	if e := d.chain.Shift(); e != nil {
+		return e
+	}
+	d.pending = true
+	return nil
+}
+
+// Set the dot correction of an output, 0 to 63, which scales its current from 0 to the full current set by the
+// chip's IREF resistor, to even out the brightness of LEDs. It is sent when UpdateDotCorrection is called.
+func (d *TLC5940) SetDotCorrection(index int, value int) error {
+	if index < 0 || index >= len(d.dot) {
+		return fmt.Errorf("TLC5940 chain has no output %d", index)
+	}
+	if value < 0 || value > TLC5940_MAX_DOT {
+		return fmt.Errorf("TLC5940 dot correction must be 0 to %d, got %d", TLC5940_MAX_DOT, value)
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.dot[index] = uint16(value)
+	return nil
+}
+
+// Send the dot correction of all outputs to the chips. The greyscale is shifted again afterwards, as dot correction
+// is loaded through the same shift register.
+func (d *TLC5940) UpdateDotCorrection() error {
+	if !d.config.UseVPRG {
+		return errors.New("TLC5940 needs the VPRG pin to set dot correction")
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	e := hwio.DigitalWrite(d.config.VPRG, hwio.High)
+	if e == nil {
+		e = d.chain.ShiftData(shiftreg.Pack(d.dot, 6), len(d.dot)*6)
+	}
+	if e == nil {
+		e = d.chain.Latch()
+	}
+	if e2 := hwio.DigitalWrite(d.config.VPRG, hwio.Low); e == nil {
+		e = e2
+	}
+	if e != nil {
+		return e
+	}
+
+	// the first greyscale data after dot correction needs an extra SCLK pulse after the latch. Bit-banged, that is
+	// simply a pulse; SPI can't send a single bit, so one greyscale frame is shifted and latched while the outputs are
+	// blanked, putting the chips back in step.
+	if d.config.SPI == nil {
+		if e := hwio.DigitalWrite(d.config.SCLK, hwio.High); e != nil {
+			return e
+		}
+		if e := hwio.DigitalWrite(d.config.SCLK, hwio.Low); e != nil {
+			return e
+		}
+	} else {
+		if e := d.chain.Blank(true); e != nil {
+			return e
+		}
+		e := d.chain.Shift()
+		if e == nil {
+			e = d.chain.Latch()
+		}
+		if e2 := d.chain.Blank(false); e == nil {
+			e = e2
+		}
+		if e != nil {
+			return e
+		}
+	}
+	if e := d.chain.Shift(); e != nil {
+		return e
+	}
+	d.pending = true
+	return nil
+}
+
+// Restart the greyscale cycle with a BLANK pulse, latching new data during it if there is any. If data is being
+// shifted the latch waits for the next cycle, but the pulse isn't skipped, as the outputs stay off at the end of a
+// cycle until BLANK is pulsed.
+func (d *TLC5940) refreshCycle() {
+	d.chain.Blank(true)
+	if d.TryLock() {
+		if d.pending {
+			d.chain.Latch()
+			d.pending = false
+		}
+		d.Unlock()
+	}
+	d.chain.Blank(false)
+}
+
+// Return whether a PWM module can drive a pin, so GenerateSquare uses hardware PWM for it rather than bit-banging it.
+func hasPWM(pin hwio.Pin) bool {
+	pd := hwio.GetDefinedPins().GetPin(pin)
+	if pd == nil {
+		return false
+	}
+	modules := hwio.GetModules()
+	for _, name := range pd.Modules() {
+		if _, ok := modules[name].(hwio.PWMModule); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop the refresh loop and greyscale clock, blanking the outputs, and close the pins.
+func (d *TLC5940) Close() error {
+	if d.refresh != nil {
+		d.refresh.Stop()
+	}
+	if d.gsclk != nil {
+		d.gsclk.Stop()
+		if !hasPWM(d.config.GSCLK) {
+			hwio.ClosePin(d.config.GSCLK)
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.chain.Close()
+	if d.config.UseVPRG {
+		hwio.ClosePin(d.config.VPRG)
+	}
+	return nil
+}
+
+type TLC59711Config struct {
+	// The SPI module and chip select to send data on. If SPI is nil, the data is bit-banged on SDTI and SCKI, which
+	// must be GPIO pins.
+	SPI         hwio.SPIModule
+	SlaveSelect int
+	SDTI        hwio.Pin
+	SCKI        hwio.Pin
+
+	// The number of chips in the chain. Defaults to 1.
+	Chips int
+}
+
+type TLC59711 struct {
+	config TLC59711Config
+
+	// guards values and brightness
+	sync.Mutex
+	values     []uint16
+	brightness [3]uint8
+}
+
+// Create a chain of TLC59711s. The outputs start at 0, and the global brightness at its largest.
+func NewTLC59711(config TLC59711Config) (*TLC59711, error) {
+	if config.Chips == 0 {
+		config.Chips = 1
+	}
+	if config.Chips < 0 {
+		return nil, errors.New("TLC59711 chain can't have a negative number of chips")
+	}
+	if config.SPI == nil {
+		for _, pin := range []hwio.Pin{config.SDTI, config.SCKI} {
+			if e := hwio.PinModeOutputInit(pin, hwio.Low); e != nil {
+				return nil, e
+			}
+		}
+	}
+	d := &TLC59711{config: config, values: make([]uint16, config.Chips*TLC59711_OUTPUTS)}
+	for i := range d.brightness {
+		d.brightness[i] = TLC59711_MAX_BRIGHTNESS
+	}
+	return d, nil
+}
+
+// Return the number of outputs in the chain.
+func (d *TLC59711) Len() int {
+	return len(d.values)
+}
+
+// Set the greyscale of an output, 0 to 65535, by its index along the chain, from 0 for OUTR0 of the chip nearest
+// the host. Each chip's outputs are R0, G0, B0, R1 and so on. The output changes when Update is called.
+func (d *TLC59711) Set(index int, value int) error {
+	if index < 0 || index >= len(d.values) {
+		return fmt.Errorf("TLC59711 chain has no output %d", index)
+	}
+	if value < 0 || value > TLC59711_MAX_GREYSCALE {
+		return fmt.Errorf("TLC59711 greyscale must be 0 to %d, got %d", TLC59711_MAX_GREYSCALE, value)
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.values[index] = uint16(value)
+	return nil
+}
+
+// Set the colour of an RGB LED, by its index along the chain, from 0 for LED 0 of the chip nearest the host.
+func (d *TLC59711) SetRGB(led int, r int, g int, b int) error {
+	if led < 0 || led >= len(d.values)/3 {
+		return fmt.Errorf("TLC59711 chain has no LED %d", led)
+	}
+	for i, value := range []int{r, g, b} {
+		if e := d.Set(3*led+i, value); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Set the global brightness of the red, green and blue outputs of all chips, 0 to 127, which scales their current
+// from 0 to the full current set by the chip's IREF resistor.
+func (d *TLC59711) SetBrightness(r int, g int, b int) error {
+	for _, value := range []int{r, g, b} {
+		if value < 0 || value > TLC59711_MAX_BRIGHTNESS {
+			return fmt.Errorf("TLC59711 brightness must be 0 to %d, got %d", TLC59711_MAX_BRIGHTNESS, value)
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.brightness = [3]uint8{uint8(r), uint8(g), uint8(b)}
+	return nil
+}
+
+// Return the data to send: a frame for each chip, from the last to the first, of the write command, function
+// control bits, global brightness and greyscale from OUTB3 down to OUTR0.
+func (d *TLC59711) Bytes() []byte {
+	d.Lock()
+	defer d.Unlock()
+	result := make([]byte, 0, d.config.Chips*28)
+	for chip := d.config.Chips - 1; chip >= 0; chip-- {
+		header := uint32(TLC59711_WRITE)<<26 |
+			uint32(TLC59711_OUTTMG|TLC59711_TMGRST|TLC59711_DSPRPT)<<21 |
+			uint32(d.brightness[2])<<14 | uint32(d.brightness[1])<<7 | uint32(d.brightness[0])
+		result = append(result, byte(header>>24), byte(header>>16), byte(header>>8), byte(header))
+		for i := TLC59711_OUTPUTS - 1; i >= 0; i-- {
+			v := d.values[chip*TLC59711_OUTPUTS+i]
+			result = append(result, byte(v>>8), byte(v))
+		}
+	}
+	return result
+}
+
+// Send the outputs and brightness to the chips.
+func (d *TLC59711) Update() error {
+	data := d.Bytes()
+	if d.config.SPI != nil {
+		return d.config.SPI.Write(d.config.SlaveSelect, data)
+	}
+	for _, b := range data {
+		if e := hwio.ShiftOut(d.config.SDTI, d.config.SCKI, uint(b), hwio.MSBFIRST); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Close the chain's pins.
+func (d *TLC59711) Close() error {
+	if d.config.SPI == nil {
+		hwio.ClosePin(d.config.SDTI)
+		hwio.ClosePin(d.config.SCKI)
+	}
+	return nil
+}
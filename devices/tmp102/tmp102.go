@@ -20,14 +20,41 @@ type TMP102 struct {
 	device hwio.I2CDevice
 }
 
+var _ hwio.Thermometer = (*TMP102)(nil)
+
+func init() {
+	// the TMP102 has no ID register, but the resolution bits of its configuration register always read 1, and its 4
+	// lowest bits 0
+	hwio.RegisterDeviceDriver(hwio.DeviceDriver{
+		Name:      "tmp102",
+		Addresses: []int{DEVICE_ADDRESS, 0x49, 0x4a, 0x4b},
+		Identify:  hwio.IdentifyByRegister(0x01, 2, 0x600f, 0x6000),
+		New: func(module hwio.I2CModule, address int) (interface{}, error) {
+			return NewTMP102Addr(module, address), nil
+		},
+	})
+}
+
 func NewTMP102(module hwio.I2CModule) *TMP102 {
-	device := module.GetDevice(DEVICE_ADDRESS)
+	return NewTMP102Addr(module, DEVICE_ADDRESS)
+}
+
+// Create a TMP102 at an address other than the default, 0x48-0x4b depending on how its ADD0 pin is wired.
+func NewTMP102Addr(module hwio.I2CModule, address int) *TMP102 {
+	device := module.GetDevice(address)
 	result := &TMP102{device: device}
 
 	return result
 }
 
+// Return the temperature in degrees Celsius. See ReadTemperature.
 func (t *TMP102) GetTemp() (float32, error) {
+	temp, e := t.ReadTemperature()
+	return float32(temp.Celsius()), e
+}
+
+// Read the temperature.
+func (t *TMP102) ReadTemperature() (hwio.Temperature, error) {
 	buffer, e := t.device.Read(0x00, 2)
 	if e != nil {
 		return 0, e
@@ -35,10 +62,10 @@ func (t *TMP102) GetTemp() (float32, error) {
 	MSB := buffer[0]
 	LSB := buffer[1]
 
-	/* Convert 12bit int using two's compliment */
-	/* Credit: http://bildr.org/2011/01/tmp102-arduino/ */
-	temp := ((int(MSB) << 8) | int(LSB)) >> 4
+	// the temperature is a 12 bit two's complement value in the top bits, so shifting the 16 bit value keeps its
+	// sign
+	temp := int16(uint16(MSB)<<8|uint16(LSB)) >> 4
 
 	// divide by 16, since lowest 4 bits are fractional.
-	return float32(temp) * 0.0625, nil
+	return hwio.Celsius(float64(temp) * 0.0625), nil
 }
@@ -0,0 +1,383 @@
+// Support for UPS HATs, which keep a Raspberry Pi or similar running from a battery when its supply fails. A Monitor
+// watches the battery's fuel gauge, and the HAT's power loss pin if it has one, and sends PowerEvents so that the
+// application can put its outputs into a safe state and shut down before the battery runs flat.
+//
+// Fuel gauges: the MAX17040/MAX17041 and MAX17043/MAX17048 over I2C, used by the Geekworm X7xx and similar HATs, and
+// VoltageGauge, which estimates the charge from a battery voltage read some other way, e.g. from an INA219 as on the
+// Waveshare UPS HAT.
+
+// Current status:
+// - the gauge is polled, every 10 seconds by default; the power loss pin is watched for edges, so power loss is
+//   reported straight away.
+// - the HAT's own shutdown signalling (e.g. the X728's boot and shutdown pins) is not handled; the application
+//   shuts down in whatever way it normally does.
+// - needs a GPIO module that supports edge detection to use a power loss pin.
+
+package ups
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The fixed address of the MAX1704x fuel gauges
+	MAX17040_ADDRESS = 0x36
+
+	REG_VCELL   = 0x02
+	REG_SOC     = 0x04
+	REG_MODE    = 0x06
+	REG_VERSION = 0x08
+	REG_CONFIG  = 0x0c
+	REG_COMMAND = 0xfe
+
+	DEFAULT_INTERVAL         = 10 * time.Second
+	DEFAULT_LOW_PERCENT      = 20
+	DEFAULT_CRITICAL_PERCENT = 5
+)
+
+// A battery fuel gauge.
+type Gauge interface {
+	// Return the battery voltage, and its state of charge from 0 to 100%.
+	Read() (volts float64, percent float64, e error)
+}
+
+// A MAX1704x fuel gauge.
+type MAX17040 struct {
+	device hwio.I2CDevice
+
+	// volts per count of the VCELL register
+	vcellLSB float64
+}
+
+// Create a MAX17040 or MAX17041 fuel gauge on a bus. cells is 1 for the MAX17040 and 2 for the MAX17041.
+func NewMAX17040(module hwio.I2CModule, cells int) (*MAX17040, error) {
+	if cells != 1 && cells != 2 {
+		return nil, fmt.Errorf("MAX17040 supports 1 or 2 cells, got %d", cells)
+	}
+	// VCELL is 12 bits, left justified, in steps of 1.25mV per cell
+	return &MAX17040{device: module.GetDevice(MAX17040_ADDRESS), vcellLSB: float64(cells) * 1.25e-3 / 16}, nil
+}
+
+// Create a MAX17043, MAX17044, MAX17048 or MAX17049 fuel gauge on a bus. These have a finer VCELL register than the
+// MAX17040; cells is 1 for the MAX17043 and MAX17048 and 2 for the MAX17044 and MAX17049.
+func NewMAX17048(module hwio.I2CModule, cells int) (*MAX17040, error) {
+	if cells != 1 && cells != 2 {
+		return nil, fmt.Errorf("MAX17048 supports 1 or 2 cells, got %d", cells)
+	}
+	return &MAX17040{device: module.GetDevice(MAX17040_ADDRESS), vcellLSB: float64(cells) * 78.125e-6}, nil
+}
+
+func (g *MAX17040) readRegister(reg byte) (uint16, error) {
+	b, e := g.device.Read(reg, 2)
+	if e != nil {
+		return 0, e
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
+
+// Return the battery voltage, and its state of charge.
+func (g *MAX17040) Read() (float64, float64, error) {
+	vcell, e := g.readRegister(REG_VCELL)
+	if e != nil {
+		return 0, 0, e
+	}
+	soc, e := g.readRegister(REG_SOC)
+	if e != nil {
+		return 0, 0, e
+	}
+	// the high byte of SOC is whole percent, the low byte 1/256ths
+	percent := float64(soc) / 256
+	if percent > 100 {
+		percent = 100
+	}
+	return float64(vcell) * g.vcellLSB, percent, nil
+}
+
+// Return the gauge's version.
+func (g *MAX17040) Version() (int, error) {
+	v, e := g.readRegister(REG_VERSION)
+	return int(v), e
+}
+
+// Restart the gauge's estimate of the state of charge from the battery voltage. This is for when the gauge was
+// powered up with a load or charger connected, which throws out its first estimate.
+func (g *MAX17040) QuickStart() error {
+	return g.device.Write(REG_MODE, []byte{0x40, 0x00})
+}
+
+// A gauge that estimates the state of charge from the battery voltage, linearly between Empty and Full. This is
+// rough, as a battery's voltage depends on its load, but is all that HATs without a fuel gauge can offer.
+type VoltageGauge struct {
+	// Return the battery voltage.
+	Voltage func() (float64, error)
+
+	// The voltages at 0% and 100%, e.g. 3.0 and 4.2 for one lithium cell.
+	Empty float64
+	Full  float64
+}
+
+// Return the battery voltage, and an estimate of its state of charge.
+func (g VoltageGauge) Read() (float64, float64, error) {
+	v, e := g.Voltage()
+	if e != nil {
+		return 0, 0, e
+	}
+	percent := (v - g.Empty) / (g.Full - g.Empty) * 100
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	return v, percent, nil
+}
+
+// The kinds of power event.
+type EventType int
+
+const (
+	// The external supply has failed, and the HAT is running from its battery.
+	PowerLost EventType = iota
+
+	// The external supply has come back.
+	PowerRestored
+
+	// The battery has fallen below the low level.
+	BatteryLow
+
+	// The battery has fallen below the critical level. Shut down now.
+	BatteryCritical
+)
+
+func (t EventType) String() string {
+	switch t {
+	case PowerLost:
+		return "PowerLost"
+	case PowerRestored:
+		return "PowerRestored"
+	case BatteryLow:
+		return "BatteryLow"
+	case BatteryCritical:
+		return "BatteryCritical"
+	}
+	return ""
+}
+
+// A power event, with the battery state when it happened, and when it happened on the clock of hwio.MonotonicNow.
+type PowerEvent struct {
+	Type    EventType
+	Volts   float64
+	Percent float64
+	Time    time.Duration
+}
+
+// The state of the supply and battery.
+type Status struct {
+	OnBattery bool
+	Volts     float64
+	Percent   float64
+
+	// The error from the last reading of the gauge or power loss pin, if it failed.
+	Err error
+}
+
+// What a Monitor watches. Zero values give the defaults.
+type Config struct {
+	// The battery's fuel gauge, if it has one.
+	Gauge Gauge
+
+	// The pin the HAT signals power loss on, if UsePowerPin is true. The pin is low on power loss unless
+	// PowerLostHigh is true, as it is on the Geekworm X728 (GPIO6).
+	PowerPin      hwio.Pin
+	UsePowerPin   bool
+	PowerLostHigh bool
+
+	// The state of charge, in percent, below which BatteryLow and BatteryCritical are sent. Each is sent once
+	// each time the battery falls below it, while on battery if there is a power loss pin.
+	LowPercent      float64
+	CriticalPercent float64
+
+	// How often the gauge is read.
+	Interval time.Duration
+
+	// Output pins to set, to the given values, when the battery becomes critical, before BatteryCritical is sent.
+	// This puts outputs into a safe state even if the application is too busy to react in time.
+	SafeOutputs map[hwio.Pin]int
+}
+
+// Watches a UPS's supply and battery.
+type Monitor struct {
+	// Power events. The channel is closed by Close. Events are dropped if the application doesn't read them.
+	Events <-chan PowerEvent
+
+	config Config
+	events chan PowerEvent
+	edges  <-chan hwio.EdgeEvent
+	stop   chan bool
+	done   chan bool
+
+	// guards status
+	sync.Mutex
+	status Status
+}
+
+// Start watching a UPS. If the power loss pin shows the HAT is already on battery, PowerLost is sent first. Without a
+// power loss pin, the battery levels are checked all the time.
+func NewMonitor(config Config) (*Monitor, error) {
+	if config.Gauge == nil && !config.UsePowerPin {
+		return nil, errors.New("UPS monitor needs a gauge or a power loss pin")
+	}
+	if config.LowPercent <= 0 {
+		config.LowPercent = DEFAULT_LOW_PERCENT
+	}
+	if config.CriticalPercent <= 0 {
+		config.CriticalPercent = DEFAULT_CRITICAL_PERCENT
+	}
+	if config.Interval <= 0 {
+		config.Interval = DEFAULT_INTERVAL
+	}
+
+	events := make(chan PowerEvent, 8)
+	m := &Monitor{
+		Events: events,
+		config: config,
+		events: events,
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+
+	if config.UsePowerPin {
+		e := hwio.PinMode(config.PowerPin, hwio.Input)
+		if e == nil {
+			m.edges, e = hwio.WatchEdges(config.PowerPin, hwio.EdgeBoth)
+		}
+		if e != nil {
+			return nil, e
+		}
+		onBattery, e := m.readPowerPin()
+		if e != nil {
+			hwio.StopWatchingEdges(config.PowerPin)
+			return nil, e
+		}
+		m.status.OnBattery = onBattery
+		if onBattery {
+			m.send(PowerLost, m.status)
+		}
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Return the state of the supply and battery.
+func (m *Monitor) Status() Status {
+	m.Lock()
+	defer m.Unlock()
+	return m.status
+}
+
+// Stop watching, and close the Events channel.
+func (m *Monitor) Close() {
+	select {
+	case <-m.stop:
+		return
+	default:
+		close(m.stop)
+	}
+	<-m.done
+	if m.config.UsePowerPin {
+		hwio.StopWatchingEdges(m.config.PowerPin)
+	}
+	close(m.events)
+}
+
+// Return true if the power loss pin shows the HAT is on battery.
+func (m *Monitor) readPowerPin() (bool, error) {
+	v, e := hwio.DigitalRead(m.config.PowerPin)
+	if e != nil {
+		return false, e
+	}
+	return (v == hwio.High) == m.config.PowerLostHigh, nil
+}
+
+func (m *Monitor) run() {
+	defer close(m.done)
+
+	ticker := hwio.GetClock().NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	// whether BatteryLow and BatteryCritical have been sent since the battery was last above their levels
+	low, critical := false, false
+	for {
+		m.Lock()
+		status := m.status
+		m.Unlock()
+
+		if m.config.Gauge != nil {
+			status.Volts, status.Percent, status.Err = m.config.Gauge.Read()
+			if status.Err == nil && (status.OnBattery || !m.config.UsePowerPin) {
+				if status.Percent < m.config.CriticalPercent && !critical {
+					critical = true
+					m.setSafeOutputs()
+					m.send(BatteryCritical, status)
+				}
+				if status.Percent < m.config.LowPercent && !low {
+					low = true
+					m.send(BatteryLow, status)
+				}
+			}
+			if status.Err == nil {
+				critical = critical && status.Percent < m.config.CriticalPercent
+				low = low && status.Percent < m.config.LowPercent
+			}
+		}
+		m.Lock()
+		m.status = status
+		m.Unlock()
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C():
+		case _, ok := <-m.edges:
+			if !ok {
+				m.edges = nil
+				continue
+			}
+			onBattery, e := m.readPowerPin()
+			m.Lock()
+			m.status.Err = e
+			changed := e == nil && onBattery != m.status.OnBattery
+			if changed {
+				m.status.OnBattery = onBattery
+			}
+			status = m.status
+			m.Unlock()
+			if changed && onBattery {
+				m.send(PowerLost, status)
+			} else if changed {
+				m.send(PowerRestored, status)
+				low, critical = false, false
+			}
+		}
+	}
+}
+
+// Set the safe outputs.
+func (m *Monitor) setSafeOutputs() {
+	for pin, value := range m.config.SafeOutputs {
+		hwio.DigitalWrite(pin, value)
+	}
+}
+
+// Send an event, dropping it if the channel is full.
+func (m *Monitor) send(t EventType, status Status) {
+	select {
+	case m.events <- PowerEvent{Type: t, Volts: status.Volts, Percent: status.Percent, Time: hwio.MonotonicNow()}:
+	default:
+	}
+}
@@ -0,0 +1,213 @@
+// Support for Wiegand access control readers and keypads, which send bits as low pulses on two lines: a pulse on D0
+// is a 0, and a pulse on D1 is a 1. A frame ends when the lines have been quiet for a while.
+
+// Current status:
+// - decodes 26 and 34 bit card frames into a facility code and card number, checking their parity bits.
+// - decodes keypad presses sent as 4 bit frames, or 8 bit frames with the key's complement in the top nibble.
+// - other lengths are delivered with the raw bits, for the application to decode.
+// - needs a GPIO module that supports edge detection. The pulses are timestamped by the kernel, so bits are put in
+//   order even if the two lines' events are read out of order.
+
+package wiegand
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// How long the lines must be quiet for a frame to end. Readers leave 1-2ms between bits.
+	DEFAULT_FRAME_GAP = 25 * time.Millisecond
+
+	// The longest frame the reader collects
+	MAX_BITS = 64
+
+	// Keys of a keypad, other than 0-9
+	KEY_STAR = 10
+	KEY_HASH = 11
+)
+
+// A frame received from a reader.
+type Frame struct {
+	// The bits received, first bit most significant, and how many there were.
+	Data uint64
+	Bits int
+
+	// When the first bit was received, on the clock of hwio.MonotonicNow.
+	Time time.Duration
+
+	// For 26 and 34 bit frames, the facility code and card number, and whether the parity bits were correct.
+	Facility uint32
+	Card     uint32
+	Valid    bool
+
+	// For keypad frames, the key pressed: 0-9, KEY_STAR or KEY_HASH. IsKey is true for keypad frames.
+	Key   int
+	IsKey bool
+}
+
+type Reader struct {
+	// Frames received. This is closed when the reader is closed.
+	Frames <-chan Frame
+
+	d0, d1   hwio.Pin
+	frameGap time.Duration
+	frames   chan Frame
+	stop     chan bool
+	done     chan bool
+}
+
+// Create a reader for a Wiegand device on two GPIO pins, and start receiving frames. The pins are set as inputs
+// with pull-ups, as the lines are open collector on most readers.
+func NewReader(d0 hwio.Pin, d1 hwio.Pin) (*Reader, error) {
+	r := &Reader{
+		d0:       d0,
+		d1:       d1,
+		frameGap: DEFAULT_FRAME_GAP,
+		frames:   make(chan Frame, 16),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+	r.Frames = r.frames
+
+	for _, pin := range []hwio.Pin{d0, d1} {
+		e := hwio.PinMode(pin, hwio.InputPullUp)
+		if e != nil {
+			return nil, e
+		}
+	}
+	zeros, e := hwio.WatchEdges(d0, hwio.EdgeFalling)
+	if e != nil {
+		return nil, e
+	}
+	ones, e := hwio.WatchEdges(d1, hwio.EdgeFalling)
+	if e != nil {
+		hwio.StopWatchingEdges(d0)
+		return nil, e
+	}
+
+	go r.run(zeros, ones)
+	return r, nil
+}
+
+// Set how long the lines must be quiet for a frame to end. Call this before any frames are received.
+func (r *Reader) SetFrameGap(gap time.Duration) {
+	r.frameGap = gap
+}
+
+// Stop receiving, and close the Frames channel.
+func (r *Reader) Close() {
+	select {
+	case <-r.stop:
+		return
+	default:
+	}
+	close(r.stop)
+	<-r.done
+	hwio.StopWatchingEdges(r.d0)
+	hwio.StopWatchingEdges(r.d1)
+}
+
+// One bit, with the time of its pulse.
+type bit struct {
+	time  time.Duration
+	value bool
+}
+
+// Collect pulses into frames, which end when no pulse has arrived for the frame gap.
+func (r *Reader) run(zeros <-chan hwio.EdgeEvent, ones <-chan hwio.EdgeEvent) {
+	defer close(r.done)
+	defer close(r.frames)
+
+	var bits []bit
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		var gap <-chan time.Time
+		if len(bits) > 0 {
+			gap = timer.C
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case event, ok := <-zeros:
+			if !ok {
+				return
+			}
+			bits = append(bits, bit{event.Timestamp, false})
+		case event, ok := <-ones:
+			if !ok {
+				return
+			}
+			bits = append(bits, bit{event.Timestamp, true})
+		case <-gap:
+			// the two lines are read separately, so put the bits in the order of their pulses
+			sort.Slice(bits, func(i, j int) bool { return bits[i].time < bits[j].time })
+			if len(bits) <= MAX_BITS {
+				frame := Frame{Bits: len(bits), Time: bits[0].time}
+				for _, b := range bits {
+					frame.Data <<= 1
+					if b.value {
+						frame.Data |= 1
+					}
+				}
+				select {
+				case r.frames <- Decode(frame):
+				case <-r.stop:
+					return
+				}
+			}
+			bits = nil
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.frameGap)
+	}
+}
+
+// Fill in the fields of a frame that can be worked out from its bits: the facility code and card number of 26 and
+// 34 bit frames, and the key of keypad frames. Frames of other lengths are returned as they are.
+func Decode(frame Frame) Frame {
+	switch frame.Bits {
+	case 26:
+		// even parity over the first 12 bits, facility code (8 bits), card number (16 bits), odd parity over the
+		// last 12 bits
+		frame.Facility = uint32(frame.Data>>17) & 0xff
+		frame.Card = uint32(frame.Data>>1) & 0xffff
+		frame.Valid = parity(frame.Data>>13, 13) == 0 && parity(frame.Data, 13) == 1
+	case 34:
+		// even parity over the first 17 bits, facility code (16 bits), card number (16 bits), odd parity over the
+		// last 17 bits
+		frame.Facility = uint32(frame.Data>>17) & 0xffff
+		frame.Card = uint32(frame.Data>>1) & 0xffff
+		frame.Valid = parity(frame.Data>>17, 17) == 0 && parity(frame.Data, 17) == 1
+	case 4:
+		frame.Key, frame.IsKey = int(frame.Data), frame.Data <= KEY_HASH
+		frame.Valid = frame.IsKey
+	case 8:
+		// the key in the low nibble, and its complement in the high one
+		key := frame.Data & 0xf
+		frame.Key, frame.IsKey = int(key), key <= KEY_HASH && frame.Data>>4 == ^key&0xf
+		frame.Valid = frame.IsKey
+	}
+	return frame
+}
+
+// Return the parity of the lowest n bits of a value: 1 if an odd number of them are set.
+func parity(value uint64, n uint) uint64 {
+	p := uint64(0)
+	for i := uint(0); i < n; i++ {
+		p ^= (value >> i) & 1
+	}
+	return p
+}
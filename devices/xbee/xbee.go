@@ -0,0 +1,308 @@
+// Support for Digi XBee radio modules in API mode, over a serial module.
+
+// Current status:
+// - supports API mode 1 (AP=1) and API mode 2 with escaped bytes (AP=2). Transparent mode (AP=0) needs no help
+//   beyond the serial module itself.
+// - builds and parses the frames used most: AT commands, transmit requests and their status, and received packets,
+//   as used by the 802.15.4, DigiMesh and Zigbee firmware. Other frame types can be sent and received as raw frames.
+
+package xbee
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	START_DELIMITER = 0x7e
+	ESCAPE          = 0x7d
+	XON             = 0x11
+	XOFF            = 0x13
+
+	// Frame types (API identifiers)
+	FRAME_AT_COMMAND          = 0x08
+	FRAME_TRANSMIT_REQUEST    = 0x10
+	FRAME_AT_COMMAND_RESPONSE = 0x88
+	FRAME_MODEM_STATUS        = 0x8a
+	FRAME_TRANSMIT_STATUS     = 0x8b
+	FRAME_RECEIVE_PACKET      = 0x90
+
+	// 64-bit address to send to all devices on the network
+	BROADCAST_ADDRESS = 0x000000000000ffff
+
+	// 16-bit address to use when the device's network address is not known
+	UNKNOWN_NETWORK_ADDRESS = 0xfffe
+
+	// The largest frame the modules accept
+	MAX_FRAME_LENGTH = 0xffff
+)
+
+// An API frame: the frame type, and the data that follows it. The start delimiter, length and checksum are added
+// when the frame is written, and checked when it is read.
+type Frame struct {
+	Type byte
+	Data []byte
+}
+
+// A packet received from another device, from a FRAME_RECEIVE_PACKET frame.
+type ReceivePacket struct {
+	Source64 uint64
+	Source16 uint16
+	Options  byte
+	Data     []byte
+}
+
+// The status of a transmit request, from a FRAME_TRANSMIT_STATUS frame. DeliveryStatus is 0 on success.
+type TransmitStatus struct {
+	FrameID        byte
+	Destination16  uint16
+	Retries        byte
+	DeliveryStatus byte
+	DiscoveryState byte
+}
+
+// The response to an AT command, from a FRAME_AT_COMMAND_RESPONSE frame. Status is 0 on success.
+type ATCommandResponse struct {
+	FrameID byte
+	Command string
+	Status  byte
+	Value   []byte
+}
+
+type XBee struct {
+	serial  hwio.SerialModule
+	escaped bool
+
+	// bytes read from the serial module but not yet used
+	buffer []byte
+
+	frameID byte
+}
+
+// Create an XBee on a serial module, which must be enabled. escaped should be true if the module is in API mode 2
+// (AP=2), where bytes that would be confused with framing are escaped.
+func NewXBee(serial hwio.SerialModule, escaped bool) *XBee {
+	return &XBee{serial: serial, escaped: escaped}
+}
+
+// Encode a frame with its start delimiter, length and checksum, escaping it if escaped is true.
+func EncodeFrame(frame Frame, escaped bool) ([]byte, error) {
+	length := len(frame.Data) + 1
+	if length > MAX_FRAME_LENGTH {
+		return nil, fmt.Errorf("XBee frame of %d bytes is too long", length)
+	}
+
+	body := make([]byte, 0, length+3)
+	body = append(body, byte(length>>8), byte(length), frame.Type)
+	body = append(body, frame.Data...)
+	body = append(body, checksum(body[2:]))
+
+	result := []byte{START_DELIMITER}
+	for _, b := range body {
+		if escaped && needsEscape(b) {
+			result = append(result, ESCAPE, b^0x20)
+		} else {
+			result = append(result, b)
+		}
+	}
+	return result, nil
+}
+
+// The checksum is 0xff less the low byte of the sum of the frame type and data.
+func checksum(data []byte) byte {
+	sum := byte(0)
+	for _, b := range data {
+		sum += b
+	}
+	return 0xff - sum
+}
+
+func needsEscape(b byte) bool {
+	return b == START_DELIMITER || b == ESCAPE || b == XON || b == XOFF
+}
+
+// Write a frame.
+func (x *XBee) WriteFrame(frame Frame) error {
+	b, e := EncodeFrame(frame, x.escaped)
+	if e != nil {
+		return e
+	}
+	_, e = x.serial.Write(b)
+	return e
+}
+
+// Read the next frame, waiting until one is received. Bytes before a start delimiter are skipped, and frames with
+// a bad checksum are discarded, so a read can start part way through a frame.
+func (x *XBee) ReadFrame() (Frame, error) {
+	started := false
+	for {
+		if !started {
+			_, delimiter, e := x.readByte(false)
+			if e != nil {
+				return Frame{}, e
+			}
+			if !delimiter {
+				continue
+			}
+		}
+
+		frame, ok, interrupted, e := x.readFrameBody()
+		if e != nil {
+			return Frame{}, e
+		}
+		if ok {
+			return frame, nil
+		}
+		started = interrupted
+	}
+}
+
+// Read the frame after a start delimiter, returning false if the checksum is wrong. In API mode 2, a start
+// delimiter can't appear within a frame, so one that does interrupts the frame and starts the next.
+func (x *XBee) readFrameBody() (frame Frame, ok bool, interrupted bool, e error) {
+	var b byte
+	var delimiter bool
+	header := make([]byte, 2)
+	for i := range header {
+		b, delimiter, e = x.readByte(true)
+		if e != nil {
+			return
+		}
+		if x.escaped && delimiter {
+			return frame, false, true, nil
+		}
+		header[i] = b
+	}
+
+	length := int(binary.BigEndian.Uint16(header))
+	if length == 0 {
+		return
+	}
+	data := make([]byte, length+1)
+	for i := range data {
+		b, delimiter, e = x.readByte(true)
+		if e != nil {
+			return
+		}
+		if x.escaped && delimiter {
+			return frame, false, true, nil
+		}
+		data[i] = b
+	}
+
+	if checksum(data[:length]) != data[length] {
+		return
+	}
+	return Frame{Type: data[0], Data: data[1:length]}, true, false, nil
+}
+
+// Read a byte, removing escaping if unescape is true and the module is in API mode 2. delimiter is true if the byte
+// is a start delimiter, which is never escaped.
+func (x *XBee) readByte(unescape bool) (value byte, delimiter bool, e error) {
+	b, e := x.nextByte()
+	if e != nil || b == START_DELIMITER {
+		return b, e == nil, e
+	}
+	if !unescape || !x.escaped || b != ESCAPE {
+		return b, false, nil
+	}
+	b, e = x.nextByte()
+	if e != nil || b == START_DELIMITER {
+		return b, e == nil, e
+	}
+	return b ^ 0x20, false, nil
+}
+
+func (x *XBee) nextByte() (byte, error) {
+	if len(x.buffer) == 0 {
+		buffer := make([]byte, 256)
+		n, e := x.serial.Read(buffer)
+		if e != nil {
+			return 0, e
+		}
+		x.buffer = buffer[:n]
+		if n == 0 {
+			return 0, errors.New("XBee serial module returned no data")
+		}
+	}
+	b := x.buffer[0]
+	x.buffer = x.buffer[1:]
+	return b, nil
+}
+
+// Return the next frame ID, for matching a response to its request. IDs run from 1 to 255, as 0 asks for no
+// response.
+func (x *XBee) nextFrameID() byte {
+	x.frameID++
+	if x.frameID == 0 {
+		x.frameID = 1
+	}
+	return x.frameID
+}
+
+// Send an AT command, e.g. "NI" to read the node identifier, with an optional parameter to set it. Returns the frame
+// ID, which is in the ATCommandResponse.
+func (x *XBee) ATCommand(command string, parameter []byte) (byte, error) {
+	if len(command) != 2 {
+		return 0, fmt.Errorf("XBee AT command '%s' must be two characters", command)
+	}
+	id := x.nextFrameID()
+	data := append([]byte{id, command[0], command[1]}, parameter...)
+	return id, x.WriteFrame(Frame{Type: FRAME_AT_COMMAND, Data: data})
+}
+
+// Send data to a device by its 64-bit address, or to all devices with BROADCAST_ADDRESS. Returns the frame ID,
+// which is in the TransmitStatus.
+func (x *XBee) Transmit(destination uint64, data []byte) (byte, error) {
+	id := x.nextFrameID()
+	frame := make([]byte, 13, 13+len(data))
+	frame[0] = id
+	binary.BigEndian.PutUint64(frame[1:9], destination)
+	binary.BigEndian.PutUint16(frame[9:11], UNKNOWN_NETWORK_ADDRESS)
+	// frame[11] is the broadcast radius, with 0 for the maximum; frame[12] the transmit options
+	frame = append(frame, data...)
+	return id, x.WriteFrame(Frame{Type: FRAME_TRANSMIT_REQUEST, Data: frame})
+}
+
+// Parse a FRAME_RECEIVE_PACKET frame.
+func ParseReceivePacket(frame Frame) (*ReceivePacket, error) {
+	if frame.Type != FRAME_RECEIVE_PACKET || len(frame.Data) < 11 {
+		return nil, fmt.Errorf("XBee frame type 0x%02x is not a valid receive packet", frame.Type)
+	}
+	return &ReceivePacket{
+		Source64: binary.BigEndian.Uint64(frame.Data[0:8]),
+		Source16: binary.BigEndian.Uint16(frame.Data[8:10]),
+		Options:  frame.Data[10],
+		Data:     frame.Data[11:],
+	}, nil
+}
+
+// Parse a FRAME_TRANSMIT_STATUS frame.
+func ParseTransmitStatus(frame Frame) (*TransmitStatus, error) {
+	if frame.Type != FRAME_TRANSMIT_STATUS || len(frame.Data) < 6 {
+		return nil, fmt.Errorf("XBee frame type 0x%02x is not a valid transmit status", frame.Type)
+	}
+	return &TransmitStatus{
+		FrameID:        frame.Data[0],
+		Destination16:  binary.BigEndian.Uint16(frame.Data[1:3]),
+		Retries:        frame.Data[3],
+		DeliveryStatus: frame.Data[4],
+		DiscoveryState: frame.Data[5],
+	}, nil
+}
+
+// Parse a FRAME_AT_COMMAND_RESPONSE frame.
+func ParseATCommandResponse(frame Frame) (*ATCommandResponse, error) {
+	if frame.Type != FRAME_AT_COMMAND_RESPONSE || len(frame.Data) < 4 {
+		return nil, fmt.Errorf("XBee frame type 0x%02x is not a valid AT command response", frame.Type)
+	}
+	return &ATCommandResponse{
+		FrameID: frame.Data[0],
+		Command: string(frame.Data[1:3]),
+		Status:  frame.Data[3],
+		Value:   frame.Data[4:],
+	}, nil
+}
@@ -133,12 +133,12 @@ func (d *BeagleBoneBlackDriver) createPinData() {
 		d.makePin([]string{"P9.14", "gpmc_a2", "gpio1_18"}, []string{"gpio"}, 50, 0),
 		d.makePin([]string{"P9.15", "gpmc_a0", "gpio1_16"}, []string{"gpio"}, 48, 0),
 		d.makePin([]string{"P9.16", "gpmc_a3", "gpio1_19"}, []string{"gpio"}, 51, 0),
-		d.makePin([]string{"P9.17", "spi0_cs0", "gpio0_5"}, []string{"gpio"}, 5, 0),
-		d.makePin([]string{"P9.18", "spi0_d1", "gpio0_4"}, []string{"gpio"}, 4, 0),
+		d.makePin([]string{"P9.17", "spi0_cs0", "gpio0_5"}, []string{"gpio", "spislave"}, 5, 0),
+		d.makePin([]string{"P9.18", "spi0_d1", "gpio0_4"}, []string{"gpio", "spislave"}, 4, 0),
 		d.makePin([]string{"P9.19", "uart1_rtsn", "gpio0_13"}, []string{"gpio", "i2c2"}, 13, 0), // preassigned via DT in default config
 		d.makePin([]string{"P9.20", "uart1_ctsn", "gpio0_12"}, []string{"gpio", "i2c2"}, 12, 0), // preassigned via DT in default config
-		d.makePin([]string{"P9.21", "spi0_d0", "gpio0_3", "ehrpwm0B"}, []string{"gpio", "pwm0"}, 3, 0),
-		d.makePin([]string{"P9.22", "spi0_sclk", "gpio0_2", "ehrpwm0A"}, []string{"gpio", "pwm0"}, 2, 0),
+		d.makePin([]string{"P9.21", "spi0_d0", "gpio0_3", "ehrpwm0B"}, []string{"gpio", "pwm0", "spislave"}, 3, 0),
+		d.makePin([]string{"P9.22", "spi0_sclk", "gpio0_2", "ehrpwm0A"}, []string{"gpio", "pwm0", "spislave"}, 2, 0),
 		d.makePin([]string{"P9.23", "gpmc_a1", "gpio1_17"}, []string{"gpio"}, 49, 0),
 		d.makePin([]string{"P9.24", "uart1_txd", "gpio0_15"}, []string{"gpio"}, 15, 0),
 		d.makePin([]string{"P9.25", "mcasp0_ahclkx", "gpio3_21"}, []string{"gpio", "mcasp0", "preallocated"}, 117, 0), // preassigned via DT in default config
@@ -204,6 +204,12 @@ func (d *BeagleBoneBlackDriver) initialiseModules() error {
 		return e
 	}
 
+	spislave := NewDTSPISlaveModule("spislave")
+	e = spislave.SetOptions(d.getSPISlaveOptions())
+	if e != nil {
+		return e
+	}
+
 	// Create the leds module which is BBB-specific. There are no options.
 	leds := NewDTLEDModule("leds")
 	e = leds.SetOptions(d.getLEDOptions("leds"))
@@ -218,6 +224,7 @@ func (d *BeagleBoneBlackDriver) initialiseModules() error {
 	d.modules["pwm1"] = pwm1
 	d.modules["pwm2"] = pwm2
 	d.modules["leds"] = leds
+	d.modules["spislave"] = spislave
 
 	// alias i2c to i2c2. This is for portability; getting the i2c module on any device should return the default i2c interface,
 	// but should not preclude addition of other i2c busses.
@@ -286,6 +293,34 @@ func (d *BeagleBoneBlackDriver) getI2C2Options() map[string]interface{} {
 	return result
 }
 
+// Return the options of the SPI slave module, which uses McSPI0 on P9.17 (CS0), P9.18 (D1), P9.21 (D0) and P9.22
+// (SCLK). McSPI1 shares its pins with the HDMI audio, which is preallocated. The controller must be made a slave with
+// an overlay setting spi-slave; without spi aliases in device tree, the kernel numbers McSPI0 bus 0.
+func (d *BeagleBoneBlackDriver) getSPISlaveOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSPISlaveModulePins, 0)
+	for _, name := range []string{"P9.17", "P9.18", "P9.21", "P9.22"} {
+		pins = append(pins, d.getPin(name))
+	}
+
+	result["pins"] = pins
+	result["controller"] = "spi0"
+	result["device"] = "/dev/spidev0.0"
+
+	return result
+}
+
+// Return the EHRPWM output of a pin from its names, e.g. "A" for "ehrpwm2A", or "" if it has none.
+func ehrpwmOutput(names []string) string {
+	for _, n := range names {
+		if strings.HasPrefix(n, "ehrpwm") {
+			return n[len(n)-1:]
+		}
+	}
+	return ""
+}
+
 func (d *BeagleBoneBlackDriver) getPWMOptions(name string) map[string]interface{} {
 	result := make(map[string]interface{})
 
@@ -294,7 +329,7 @@ func (d *BeagleBoneBlackDriver) getPWMOptions(name string) map[string]interface{
 		if d.usedBy(hw, name) {
 			n := hw.names[0]
 			n = strings.Replace(n, ".", "_", -1) // P8.13 => P8_13
-			pins[Pin(i)] = &BBPWMModulePinDef{pin: Pin(i), name: n}
+			pins[Pin(i)] = &BBPWMModulePinDef{pin: Pin(i), name: n, output: ehrpwmOutput(hw.names)}
 		}
 	}
 
@@ -355,6 +390,22 @@ func (d *BeagleBoneBlackDriver) usedBy(pinDef *BeaglePin, module string) bool {
 	return false
 }
 
+// Describe the board for DescribeDriver. The revision is stored in the board's EEPROM, which is not read.
+func (d *BeagleBoneBlackDriver) Describe() DriverInfo {
+	board := BoardModel()
+	if board == "" {
+		board = "BeagleBone Black"
+	}
+	return DriverInfo{
+		Board: board,
+		Limitations: []string{
+			"GPIO uses sysfs, so pull-ups, pull-downs and open-drain outputs are emulated or unavailable",
+			"analog and PWM require the cape manager of kernels 3.8 to 4.x",
+			"no support for SPI masters or serial; McSPI0 can be an SPI slave with an spi-slave overlay",
+		},
+	}
+}
+
 func (d *BeagleBoneBlackDriver) GetModules() map[string]Module {
 	return d.modules
 }
@@ -370,7 +421,12 @@ func (d *BeagleBoneBlackDriver) PinMap() (pinMap HardwarePinMap) {
 	pinMap = make(HardwarePinMap)
 
 	for i, hw := range d.beaglePins {
-		pinMap.add(Pin(i), hw.names, hw.modules)
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+
+		// canonical names are the header positions, e.g. P8.13
+		if header, position, ok := parseHeaderPinName(hw.names[0]); ok {
+			pinMap.SetHeader(Pin(i), header, position)
+		}
 	}
 
 	return
@@ -0,0 +1,153 @@
+package hwio
+
+// A fallback driver for boards no other driver knows, which builds its pin map from the GPIO chips the kernel
+// provides through the GPIO character device. Every line of every chip becomes a pin, so unknown boards get basic
+// GPIO without a driver of their own.
+//
+// Pins are numbered in order of chip and line, and are known as "<chip>.<line>", e.g. "gpiochip0.17", and as
+// "<label>.<line>" using the chip's label, e.g. "pinctrl-bcm2711.17", which doesn't change if chips are numbered
+// differently on the next boot. Lines named in device tree can also be found by their names, as with any driver
+// whose GPIO module uses the character device.
+//
+// Known issues:
+// - GPIO only; there is no way to find which lines are on a header, or what else they can do
+// - pin numbers depend on the chips present, so use names rather than numbers
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A GPIO chip found by enumeration.
+type gpioChipSummary struct {
+	path  string
+	name  string
+	label string
+	lines int
+}
+
+type GPIOChipDriver struct {
+	chips []gpioChipSummary
+
+	// all pins, and the chip and line of each
+	pinConfigs []*DTPinConfig
+	gpioLines  CdevGPIOModulePinDefMap
+
+	// a map of module names to module objects, created at initialisation
+	modules map[string]Module
+}
+
+func NewGPIOChipDriver() *GPIOChipDriver {
+	return &GPIOChipDriver{}
+}
+
+// The driver applies if there is at least one GPIO chip with lines.
+func (d *GPIOChipDriver) MatchesHardwareConfig() bool {
+	d.chips = enumerateGPIOChips()
+	return len(d.chips) > 0
+}
+
+// Return the GPIO chips with lines, in order of chip number.
+func enumerateGPIOChips() []gpioChipSummary {
+	matches, e := filepath.Glob("/dev/gpiochip*")
+	if e != nil {
+		return nil
+	}
+
+	result := make([]gpioChipSummary, 0)
+	for _, path := range matches {
+		info, e := readGPIOChipInfo(path)
+		if e != nil || info.lines == 0 {
+			continue
+		}
+		result = append(result, gpioChipSummary{
+			path:  path,
+			name:  gpioCString(info.name[:]),
+			label: gpioCString(info.label[:]),
+			lines: int(info.lines),
+		})
+	}
+
+	// gpiochip10 sorts after gpiochip9
+	number := func(path string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "gpiochip"))
+		return n
+	}
+	sort.Slice(result, func(i, j int) bool { return number(result[i].path) < number(result[j].path) })
+	return result
+}
+
+func (d *GPIOChipDriver) Init() error {
+	d.createPinData(d.chips)
+	return d.initialiseModules()
+}
+
+// Create a pin for each line of each chip.
+func (d *GPIOChipDriver) createPinData(chips []gpioChipSummary) {
+	d.pinConfigs = make([]*DTPinConfig, 0)
+	d.gpioLines = make(CdevGPIOModulePinDefMap)
+
+	labels := make(map[string]int)
+	for _, chip := range chips {
+		labels[chip.label]++
+	}
+
+	for _, chip := range chips {
+		name := chip.name
+		if name == "" {
+			name = filepath.Base(chip.path)
+		}
+		for line := 0; line < chip.lines; line++ {
+			pin := Pin(len(d.pinConfigs))
+			names := []string{fmt.Sprintf("%s.%d", name, line)}
+			// a label shared by more than one chip doesn't identify a line
+			if chip.label != "" && labels[chip.label] == 1 {
+				names = append(names, fmt.Sprintf("%s.%d", chip.label, line))
+			}
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, []string{"gpio"}, line, 0})
+			d.gpioLines[pin] = &CdevGPIOModulePinDef{pin: pin, chip: chip.path, line: line}
+		}
+	}
+}
+
+func (d *GPIOChipDriver) initialiseModules() error {
+	d.modules = make(map[string]Module)
+
+	gpio := NewCdevGPIOModule("gpio")
+	e := gpio.SetOptions(map[string]interface{}{"pins": d.gpioLines})
+	if e != nil {
+		return e
+	}
+	d.modules["gpio"] = gpio
+
+	return nil
+}
+
+// Describe the board for DescribeDriver.
+func (d *GPIOChipDriver) Describe() DriverInfo {
+	info := DriverInfo{Board: BoardModel()}
+	info.Limitations = []string{"board not recognised, so only GPIO is supported, with pins named by chip and line"}
+	return info
+}
+
+func (d *GPIOChipDriver) GetModules() map[string]Module {
+	return d.modules
+}
+
+func (d *GPIOChipDriver) Close() {
+	// Disable all the modules
+	for _, module := range d.modules {
+		module.Disable()
+	}
+}
+
+func (d *GPIOChipDriver) PinMap() (pinMap HardwarePinMap) {
+	pinMap = make(HardwarePinMap)
+	for i, hw := range d.pinConfigs {
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+	}
+	return
+}
@@ -0,0 +1,218 @@
+package hwio
+
+// A driver for Libre Computer boards with a Raspberry Pi compatible 40 pin header: AML-S905X-CC (Le Potato) and
+// ROC-RK3328-CC (Renegade), running mainline or Libre Computer kernels.
+//
+// GPIO uses the GPIO character device. Header pins are found by the line names the device tree gives them
+// ("7J1 Header Pin7" etc.), so the driver doesn't depend on how the SoC's GPIO banks are numbered. Header pins
+// without a named line are reported with the "unrouted" module. I2C on the header is found by controller, as the
+// adapter number depends on the device tree aliases.
+//
+// Pins can be referred to by header position ("pin7"), or by the equivalent Raspberry Pi GPIO name ("gpio4"), so
+// code written for a Raspberry Pi runs unchanged.
+//
+// Known issues:
+// - no support yet for SPI, serial or PWM
+//
+// References:
+// - https://hub.libre.computer/t/libre-computer-wiring-tool/40
+
+import (
+	"fmt"
+)
+
+// Describes a Libre Computer board supported by the driver.
+type libreComputerBoard struct {
+	// name of the board, for DescribeDriver
+	name string
+
+	// device tree compatible string identifying the board
+	compatible string
+
+	// device tree node of the I2C controller on header pins 3 and 5, and the device to use if it can't be found
+	i2cController string
+	i2cDefault    string
+}
+
+var libreComputerBoards = []*libreComputerBoard{
+	{"Libre Computer AML-S905X-CC (Le Potato)", "libretech,aml-s905x-cc", "c11087c0.i2c", "/dev/i2c-1"},
+	{"Libre Computer ROC-RK3328-CC (Renegade)", "firefly,roc-rk3328-cc", "ff160000.i2c", "/dev/i2c-1"},
+}
+
+// Physical header pins and the Raspberry Pi GPIO at the same position. Pins not listed are power or ground.
+var piHeaderGPIOs = map[int]int{
+	3: 2, 5: 3, 7: 4, 8: 14, 10: 15, 11: 17, 12: 18, 13: 27, 15: 22, 16: 23, 18: 24, 19: 10, 21: 9, 22: 25,
+	23: 11, 24: 8, 26: 7, 27: 0, 28: 1, 29: 5, 31: 6, 32: 12, 33: 13, 35: 19, 36: 16, 37: 26, 38: 20, 40: 21,
+}
+
+// Names of the power and ground pins of a Raspberry Pi compatible header.
+var piHeaderPower = map[int]string{
+	1: "3.3v-1", 2: "5v-1", 4: "5v-2", 6: "ground-1", 9: "ground-2", 14: "ground-3", 17: "3.3v-2", 20: "ground-4",
+	25: "ground-5", 30: "ground-6", 34: "ground-7", 39: "ground-8",
+}
+
+type LibreComputerDriver struct {
+	// the detected board
+	board *libreComputerBoard
+
+	// all pins understood by the driver
+	pinConfigs []*DTPinConfig
+
+	// GPIO chip device file and line offset of each GPIO pin
+	gpioLines map[Pin]*CdevGPIOModulePinDef
+
+	// a map of module names to module objects, created at initialisation
+	modules map[string]Module
+}
+
+func NewLibreComputerDriver() *LibreComputerDriver {
+	return &LibreComputerDriver{}
+}
+
+func (d *LibreComputerDriver) MatchesHardwareConfig() bool {
+	for _, b := range libreComputerBoards {
+		if BoardIsCompatible(b.compatible) {
+			d.board = b
+			return true
+		}
+	}
+	return false
+}
+
+func (d *LibreComputerDriver) Init() error {
+	d.createPinData(findGPIOLine)
+	return d.initialiseModules()
+}
+
+// Create the pin map of the header. findLine looks up the GPIO line for a header pin by name.
+func (d *LibreComputerDriver) createPinData(findLine func(name string) (string, int)) {
+	d.pinConfigs = []*DTPinConfig{
+		{[]string{"null"}, []string{"unassignable"}, 0, 0}, // 0 - spacer
+	}
+	d.gpioLines = make(map[Pin]*CdevGPIOModulePinDef)
+
+	for i := 1; i <= 40; i++ {
+		if name, ok := piHeaderPower[i]; ok {
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{[]string{name}, []string{"unassignable"}, 0, 0})
+			continue
+		}
+
+		names := []string{fmt.Sprintf("pin%d", i), fmt.Sprintf("gpio%d", piHeaderGPIOs[i])}
+		switch i {
+		case 3:
+			names = append(names, "sda")
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, []string{"i2c"}, 0, 0})
+			continue
+		case 5:
+			names = append(names, "scl")
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, []string{"i2c"}, 0, 0})
+			continue
+		}
+
+		chip, line := findLine(fmt.Sprintf("7J1 Header Pin%d", i))
+		if chip == "" {
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, []string{"unrouted"}, 0, 0})
+			continue
+		}
+
+		pin := Pin(len(d.pinConfigs))
+		d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, []string{"gpio"}, line, 0})
+		d.gpioLines[pin] = &CdevGPIOModulePinDef{pin: pin, chip: chip, line: line}
+	}
+}
+
+func (d *LibreComputerDriver) initialiseModules() error {
+	d.modules = make(map[string]Module)
+
+	gpio := NewCdevGPIOModule("gpio")
+	e := gpio.SetOptions(d.getGPIOOptions())
+	if e != nil {
+		return e
+	}
+
+	i2c := NewDTI2CModule("i2c")
+	e = i2c.SetOptions(d.getI2COptions())
+	if e != nil {
+		return e
+	}
+
+	d.modules["gpio"] = gpio
+	d.modules["i2c"] = i2c
+
+	return nil
+}
+
+func (d *LibreComputerDriver) getGPIOOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(CdevGPIOModulePinDefMap)
+	for pin, pd := range d.gpioLines {
+		pins[pin] = pd
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+func (d *LibreComputerDriver) getI2COptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTI2CModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("i2c") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+
+	device := findI2CDevice(d.board.i2cController)
+	if device == "" {
+		device = d.board.i2cDefault
+	}
+	result["device"] = device
+
+	return result
+}
+
+// Describe the board for DescribeDriver.
+func (d *LibreComputerDriver) Describe() DriverInfo {
+	info := DriverInfo{Board: BoardModel()}
+	if info.Board == "" && d.board != nil {
+		info.Board = d.board.name
+	}
+
+	for _, hw := range d.pinConfigs {
+		if hw.usedBy("unrouted") {
+			info.Limitations = append(info.Limitations, "some header pins have no named GPIO line in the device tree, and are unavailable")
+			break
+		}
+	}
+	info.Limitations = append(info.Limitations, "no support for SPI, serial or PWM")
+
+	return info
+}
+
+func (d *LibreComputerDriver) GetModules() map[string]Module {
+	return d.modules
+}
+
+func (d *LibreComputerDriver) Close() {
+	// Disable all the modules
+	for _, module := range d.modules {
+		module.Disable()
+	}
+}
+
+func (d *LibreComputerDriver) PinMap() (pinMap HardwarePinMap) {
+	pinMap = make(HardwarePinMap)
+
+	// pin numbers are the positions on the 7J1 header
+	for i, hw := range d.pinConfigs {
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+		if i > 0 {
+			pinMap.SetHeader(Pin(i), "7J1", i)
+		}
+	}
+
+	return
+}
@@ -4,6 +4,7 @@ package hwio
 import (
 	// 	"errors"
 	"fmt"
+	"sync"
 )
 
 type testDriverPin struct {
@@ -84,8 +85,14 @@ func (d *TestDriver) MatchesHardwareConfig() bool {
 func (d *TestDriver) PinMap() HardwarePinMap {
 	result := make(HardwarePinMap)
 
+	// the gpio pins are on header J1, and the analog pins on header J2
 	for i, hw := range d.pinDefs {
-		result.add(Pin(i), hw.names, hw.modules)
+		result.Add(Pin(i), hw.names, hw.modules)
+		if i < 10 {
+			result.SetHeader(Pin(i), "J1", i+1)
+		} else {
+			result.SetHeader(Pin(i), "J2", i-9)
+		}
 	}
 
 	return result
@@ -136,8 +143,8 @@ func (d *TestDriver) createPinData() {
 		d.makeTestPin([]string{"P6", "gpio6"}, []string{"gpio"}, "6"),
 		d.makeTestPin([]string{"P7", "gpio7"}, []string{"gpio"}, "7"),
 		d.makeTestPin([]string{"P8", "gpio8"}, []string{"gpio"}, "8"),
-		d.makeTestPin([]string{"P9", "gpio9"}, []string{"gpio"}, "9"),
-		d.makeTestPin([]string{"P10", "gpio10"}, []string{"gpio"}, "10"),
+		d.makeTestPin([]string{"P9", "gpio9"}, []string{"gpio", "pwm"}, "9"),
+		d.makeTestPin([]string{"P10", "gpio10"}, []string{"gpio", "pwm"}, "10"),
 		d.makeTestPin([]string{"P11", "ain4"}, []string{"analog"}, "11"),
 		d.makeTestPin([]string{"P12", "ain6"}, []string{"analog"}, "12"),
 	}
@@ -157,10 +164,13 @@ func (d *TestDriver) initialiseModules() {
 	analog := newTestAnalogModule("analog")
 	analog.SetOptions(d.getModuleOptions("analog"))
 
+	pwm := newTestPWMModule("pwm")
+
 	// i2c1 := NewDTI2CModule("i2c1")
 
 	d.modules["gpio"] = gpio
 	d.modules["analog"] = analog
+	d.modules["pwm"] = pwm
 	// d.modules["i2c1"] = i2c1
 }
 
@@ -187,14 +197,32 @@ type testGPIOModule struct {
 
 	pinModes map[Pin]PinIOMode
 
+	pinConfigs map[Pin]PinConfig
+
+	activeLow map[Pin]bool
+
 	// this simulates actual pin values. DigitalWrite ends up settin
 	pinValues map[Pin]int
+
+	// pins watched for edges, the channels their events go to, and the sequence number of the last event
+	edges      map[Pin]Edge
+	edgeEvents map[Pin]chan EdgeEvent
+	edgeSeq    map[Pin]uint32
+
+	// output pins wired to inputs by MockConnect, so writes to the output change the inputs
+	connections map[Pin][]Pin
 }
 
 func newTestGPIOModule(name string) *testGPIOModule {
 	result := &testGPIOModule{name: name}
 	result.pinModes = make(map[Pin]PinIOMode)
+	result.pinConfigs = make(map[Pin]PinConfig)
+	result.activeLow = make(map[Pin]bool)
 	result.pinValues = make(map[Pin]int)
+	result.edges = make(map[Pin]Edge)
+	result.edgeEvents = make(map[Pin]chan EdgeEvent)
+	result.edgeSeq = make(map[Pin]uint32)
+	result.connections = make(map[Pin][]Pin)
 	return result
 }
 
@@ -214,38 +242,140 @@ func (module *testGPIOModule) GetName() string {
 	return module.name
 }
 
+func (module *testGPIOModule) KernelInterface() (string, string) {
+	return "mock", ""
+}
+
 func (module *testGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
-	module.pinModes[pin] = mode
+	return module.PinModeConfig(pin, PinConfig{Mode: mode})
+}
+
+func (module *testGPIOModule) PinModeConfig(pin Pin, config PinConfig) error {
+	if config.Drive != DrivePushPull && config.Mode != Output {
+		return fmt.Errorf("pin %d can only use %s drive as an output", pin, config.Drive)
+	}
+	if config.Debounce != 0 && config.Mode == Output {
+		return fmt.Errorf("pin %d can only be debounced as an input", pin)
+	}
+	module.pinModes[pin] = config.Mode
+	module.pinConfigs[pin] = config
+	if config.Mode == Output && config.UseInitialValue {
+		return module.DigitalWrite(pin, config.InitialValue)
+	}
+	return nil
+}
+
+func (module *testGPIOModule) SetActiveLow(pin Pin, activeLow bool) error {
+	module.activeLow[pin] = activeLow
 	return nil
 }
 
+// Determine if values need to be inverted between logical and electrical levels
+func (module *testGPIOModule) inverted(pin Pin) bool {
+	return module.activeLow[pin] || module.pinConfigs[pin].ActiveLow
+}
+
 func (module *testGPIOModule) DigitalWrite(pin Pin, value int) error {
 	if module.pinModes[pin] == 0 {
 		return fmt.Errorf("pin %d has not had mode set", pin)
 	}
+	if module.inverted(pin) {
+		value = Negate(value)
+	}
+	module.setValue(pin, value)
+	for _, to := range module.connections[pin] {
+		module.setValue(to, value)
+	}
+	return nil
+}
+
+// Set the electrical value of a pin, generating an edge event if it's being watched and the value changed.
+func (module *testGPIOModule) setValue(pin Pin, value int) {
+	old := module.pinValues[pin]
 	module.pinValues[pin] = value
+	if old == value || module.edgeEvents[pin] == nil {
+		return
+	}
+
+	rising := value != Low
+	if module.inverted(pin) {
+		rising = !rising
+	}
+	edge := module.edges[pin]
+	if edge == EdgeBoth || (edge == EdgeRising) == rising {
+		module.edgeSeq[pin]++
+		select {
+		case module.edgeEvents[pin] <- EdgeEvent{Pin: pin, Rising: rising, Timestamp: MonotonicNow(), Seq: module.edgeSeq[pin]}:
+		default:
+		}
+	}
+}
+
+func (module *testGPIOModule) WatchEdges(pin Pin, edge Edge) (<-chan EdgeEvent, error) {
+	if module.pinModes[pin] == Output {
+		return nil, fmt.Errorf("pin %d is an output, so edges cannot be watched", pin)
+	}
+	if module.edgeEvents[pin] != nil {
+		return nil, fmt.Errorf("pin %d is already being watched for edges", pin)
+	}
+	ch := make(chan EdgeEvent, 64)
+	module.edges[pin] = edge
+	module.edgeEvents[pin] = ch
+	return ch, nil
+}
+
+func (module *testGPIOModule) StopWatchingEdges(pin Pin) error {
+	ch := module.edgeEvents[pin]
+	if ch == nil {
+		return fmt.Errorf("pin %d is not being watched for edges", pin)
+	}
+	close(ch)
+	delete(module.edgeEvents, pin)
+	delete(module.edges, pin)
 	return nil
 }
 
 func (module *testGPIOModule) DigitalRead(pin Pin) (int, error) {
+	if module.inverted(pin) {
+		return Negate(module.pinValues[pin]), nil
+	}
 	return module.pinValues[pin], nil
-
 }
 
 func (module *testGPIOModule) ClosePin(pin Pin) error {
 	return nil
 }
 
+// Return a line name for a couple of pins, as device tree might. P9's matches one of its names already.
+func (module *testGPIOModule) LineName(pin Pin) string {
+	switch pin {
+	case 7:
+		return "CAM_GPIO"
+	case 8:
+		return "GPIO9"
+	}
+	return ""
+}
+
 func (module *testGPIOModule) MockGetPinMode(pin Pin) PinIOMode {
 	return module.pinModes[pin]
 }
 
+func (module *testGPIOModule) MockGetPinConfig(pin Pin) PinConfig {
+	return module.pinConfigs[pin]
+}
+
 func (module *testGPIOModule) MockGetPinValue(pin Pin) int {
 	return module.pinValues[pin]
 }
 
 func (module *testGPIOModule) MockSetPinValue(pin Pin, value int) {
-	module.pinValues[pin] = value
+	module.setValue(pin, value)
+}
+
+// Wire an output pin to an input pin, so values written to the output appear on the input, as with a jumper.
+func (module *testGPIOModule) MockConnect(output Pin, input Pin) {
+	module.connections[output] = append(module.connections[output], input)
 }
 
 // Mock module to replicate analog module behaviour.
@@ -253,10 +383,14 @@ type testAnalogModule struct {
 	name string
 
 	pinDefs testDriverPinMap
+
+	// values set by MockSetAnalogValue, guarded by lock as they are read by background samplers
+	lock   sync.Mutex
+	values map[Pin]int
 }
 
 func newTestAnalogModule(name string) *testAnalogModule {
-	return &testAnalogModule{name: name}
+	return &testAnalogModule{name: name, values: make(map[Pin]int)}
 }
 
 func (module *testAnalogModule) SetOptions(map[string]interface{}) error {
@@ -276,6 +410,11 @@ func (module *testAnalogModule) GetName() string {
 }
 
 func (module *testAnalogModule) AnalogRead(pin Pin) (result int, e error) {
+	module.lock.Lock()
+	defer module.lock.Unlock()
+	if value, ok := module.values[pin]; ok {
+		return value, nil
+	}
 	if pin == 10 {
 		return 1, nil
 	}
@@ -284,3 +423,61 @@ func (module *testAnalogModule) AnalogRead(pin Pin) (result int, e error) {
 	}
 	return 0, nil
 }
+
+// Set the value AnalogRead returns for a pin.
+func (module *testAnalogModule) MockSetAnalogValue(pin Pin, value int) {
+	module.lock.Lock()
+	module.values[pin] = value
+	module.lock.Unlock()
+}
+
+// Mock module to replicate PWM module behaviour. It records the state of each pin.
+type testPWMModule struct {
+	name string
+
+	enabled map[Pin]bool
+	period  map[Pin]int64
+	duty    map[Pin]int64
+}
+
+func newTestPWMModule(name string) *testPWMModule {
+	result := &testPWMModule{name: name}
+	result.enabled = make(map[Pin]bool)
+	result.period = make(map[Pin]int64)
+	result.duty = make(map[Pin]int64)
+	return result
+}
+
+func (module *testPWMModule) SetOptions(map[string]interface{}) error {
+	return nil
+}
+
+func (module *testPWMModule) Enable() error {
+	return nil
+}
+
+func (module *testPWMModule) Disable() error {
+	return nil
+}
+
+func (module *testPWMModule) GetName() string {
+	return module.name
+}
+
+func (module *testPWMModule) EnablePin(pin Pin, enabled bool) error {
+	module.enabled[pin] = enabled
+	return nil
+}
+
+func (module *testPWMModule) SetPeriod(pin Pin, ns int64) error {
+	module.period[pin] = ns
+	return nil
+}
+
+func (module *testPWMModule) SetDuty(pin Pin, ns int64) error {
+	if ns > module.period[pin] {
+		return fmt.Errorf("PWM duty of %dns is longer than the period of %dns", ns, module.period[pin])
+	}
+	module.duty[pin] = ns
+	return nil
+}
@@ -1,5 +1,7 @@
 package hwio
 
+import "fmt"
+
 // A driver for Odroid C1's running Ubuntu 14.04 with Linux kernel 3.8 or higher.
 //
 // Known issues:
@@ -24,16 +26,9 @@ func NewOdroidCXDriver() *OdroidCXDriver {
 }
 
 // Examine the hardware environment and determine if this driver will handle it.
-// For Odroid C1, it's easy: /proc/cpuinfo identifies it.
+// The device tree compatible strings identify the board, or on older kernels, /proc/cpuinfo does.
 func (d *OdroidCXDriver) MatchesHardwareConfig() bool {
-	// we need to get CPU 3, because /proc/cpuinfo on odroid has a set of properties
-	// that are system wide, that are listed after CPU specific properties.
-	// CpuInfo associated these with CPU 3, the last one it saw. Not ideal, but works.
-	hw := CpuInfo(3, "Hardware")
-	if hw == "ODROIDC" || hw == "ODROID-C2" {
-		return true
-	}
-	return false
+	return odroidCXBoardRevision() != 0
 }
 
 func (d *OdroidCXDriver) Init() error {
@@ -255,6 +250,18 @@ func (d *OdroidCXDriver) getPin(name string) Pin {
 	return Pin(0)
 }
 
+// Describe the board for DescribeDriver.
+func (d *OdroidCXDriver) Describe() DriverInfo {
+	return DriverInfo{
+		Board:    fmt.Sprintf("Odroid-C%d", d.BoardRevision()),
+		Revision: BoardRevisionCode(),
+		Limitations: []string{
+			"GPIO uses sysfs, so pull-ups, pull-downs and open-drain outputs are emulated or unavailable",
+			"no support for PWM, SPI or serial",
+		},
+	}
+}
+
 func (d *OdroidCXDriver) GetModules() map[string]Module {
 	return d.modules
 }
@@ -269,8 +276,12 @@ func (d *OdroidCXDriver) Close() {
 func (d *OdroidCXDriver) PinMap() (pinMap HardwarePinMap) {
 	pinMap = make(HardwarePinMap)
 
+	// pins 1 to 40 are the positions on the J2 header
 	for i, hw := range d.pinConfigs {
-		pinMap.add(Pin(i), hw.names, hw.modules)
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+		if i > 0 && i <= 40 {
+			pinMap.SetHeader(Pin(i), "J2", i)
+		}
 	}
 
 	return
@@ -278,13 +289,27 @@ func (d *OdroidCXDriver) PinMap() (pinMap HardwarePinMap) {
 
 // Determine the version of Odroid-C.
 func (d *OdroidCXDriver) BoardRevision() int {
-	hw := CpuInfo(3, "Hardware")
-	if hw == "ODROIDC" {
+	if r := odroidCXBoardRevision(); r != 0 {
+		return r
+	}
+	return 1
+}
+
+// Determine the version of Odroid-C from the device tree, or from the "Hardware" property of /proc/cpuinfo on
+// kernels without one. Returns 0 if this is not an Odroid-C.
+func odroidCXBoardRevision() int {
+	if BoardIsCompatible("hardkernel,odroid-c1") {
 		return 1
 	}
-	if hw == "ODROID-C2" {
+	if BoardIsCompatible("hardkernel,odroid-c2") {
 		return 2
 	}
 
-	return 1
+	switch cpuInfoSystem("Hardware") {
+	case "ODROIDC":
+		return 1
+	case "ODROID-C2":
+		return 2
+	}
+	return 0
 }
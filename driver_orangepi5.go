@@ -0,0 +1,273 @@
+package hwio
+
+// A driver for the Orange Pi 5 family (RK3588S), which has a 26 pin header.
+//
+// GPIO uses the GPIO character device. The RK3588 has five GPIO banks of 32 lines, labelled gpio0 to gpio4, and
+// pins are known by their header position ("pin7") and Rockchip name ("gpio1_c6").
+//
+// The I2C buses and PWM channels on the header are only available if they are enabled in device tree (with the
+// overlays in the Orange Pi images); until a bus or PWM pin is enabled, its pins can be used as GPIO. The SARADC is
+// read through IIO, and its channels are available as analog pins "adc0" etc.
+//
+// Known issues:
+// - no support yet for SPI or serial
+//
+// References:
+// - Orange Pi 5 user manual, 26 pin interface pin description
+// - wiringOP, https://github.com/orangepi-xunlong/wiringOP
+
+import (
+	"fmt"
+)
+
+// A pin of the 26 pin header. gpio is the Linux GPIO number (bank * 32 + line), or -1 for power pins, which are
+// named by power.
+type orangePi5HeaderPin struct {
+	gpio  int
+	power string
+
+	// other modules that can use the pin, after gpio
+	modules []string
+}
+
+var orangePi5Header = []orangePi5HeaderPin{
+	{-1, "3.3v-1", nil},        // 1
+	{-1, "5v-1", nil},          // 2
+	{47, "", []string{"i2c"}},  // 3 - I2C5_SDA_M3
+	{-1, "5v-2", nil},          // 4
+	{46, "", []string{"i2c"}},  // 5 - I2C5_SCL_M3
+	{-1, "ground-1", nil},      // 6
+	{54, "", []string{"pwm"}},  // 7 - PWM15_IR_M1
+	{131, "", nil},             // 8 - UART0_TX_M2
+	{-1, "ground-2", nil},      // 9
+	{132, "", nil},             // 10 - UART0_RX_M2
+	{138, "", []string{"pwm"}}, // 11 - PWM14_M1
+	{29, "", []string{"i2c1"}}, // 12 - I2C1_SDA_M2
+	{139, "", nil},             // 13
+	{-1, "ground-3", nil},      // 14
+	{28, "", []string{"i2c1"}}, // 15 - I2C1_SCL_M2
+	{59, "", nil},              // 16
+	{-1, "3.3v-2", nil},        // 17
+	{58, "", nil},              // 18
+	{49, "", nil},              // 19 - SPI4_MOSI_M0
+	{-1, "ground-4", nil},      // 20
+	{48, "", nil},              // 21 - SPI4_MISO_M0
+	{92, "", nil},              // 22
+	{50, "", nil},              // 23 - SPI4_CLK_M0
+	{52, "", nil},              // 24 - SPI4_CS0_M0
+	{-1, "ground-5", nil},      // 25
+	{35, "", nil},              // 26
+}
+
+// PWM controllers of the header PWM pins, by header position. Each RK3588 PWM channel is a separate controller.
+var orangePi5PWM = map[int]string{
+	7:  "febf0030.pwm",
+	11: "febf0020.pwm",
+}
+
+// I2C controllers of the header buses, and the device to use if the controller can't be found.
+var orangePi5I2C = map[string][2]string{
+	"i2c":  {"fead0000.i2c", "/dev/i2c-5"},
+	"i2c1": {"fea90000.i2c", "/dev/i2c-1"},
+}
+
+type OrangePi5Driver struct {
+	// all pins understood by the driver
+	pinConfigs []*DTPinConfig
+
+	// header position of each pin, for pins on the header
+	headerPins map[Pin]int
+
+	// a map of module names to module objects, created at initialisation
+	modules map[string]Module
+}
+
+func NewOrangePi5Driver() *OrangePi5Driver {
+	return &OrangePi5Driver{}
+}
+
+func (d *OrangePi5Driver) MatchesHardwareConfig() bool {
+	return BoardIsCompatible("xunlong,orangepi-5", "xunlong,orangepi-5b", "rockchip,rk3588s-orangepi-5", "rockchip,rk3588s-orangepi-5b")
+}
+
+func (d *OrangePi5Driver) Init() error {
+	d.createPinData(iioVoltageChannels(defaultSysfs, findIIODevice(defaultSysfs, "saradc")))
+	return d.initialiseModules()
+}
+
+// Return the Rockchip name of a GPIO, e.g. "gpio1_c6" for GPIO 54.
+func rockchipGPIOName(gpio int) string {
+	return fmt.Sprintf("gpio%d_%c%d", gpio/32, 'a'+(gpio%32)/8, gpio%8)
+}
+
+// Create the pin map: the header, followed by analog pins for the given SARADC channels.
+func (d *OrangePi5Driver) createPinData(adcChannels []int) {
+	d.pinConfigs = []*DTPinConfig{
+		{[]string{"null"}, []string{"unassignable"}, 0, 0}, // 0 - spacer
+	}
+	d.headerPins = make(map[Pin]int)
+
+	for i, hp := range orangePi5Header {
+		position := i + 1
+		if hp.gpio < 0 {
+			d.pinConfigs = append(d.pinConfigs, &DTPinConfig{[]string{hp.power}, []string{"unassignable"}, 0, 0})
+			continue
+		}
+
+		d.headerPins[Pin(len(d.pinConfigs))] = position
+		names := []string{fmt.Sprintf("pin%d", position), rockchipGPIOName(hp.gpio)}
+		modules := append([]string{"gpio"}, hp.modules...)
+		d.pinConfigs = append(d.pinConfigs, &DTPinConfig{names, modules, hp.gpio, 0})
+	}
+
+	for _, ch := range adcChannels {
+		d.pinConfigs = append(d.pinConfigs, &DTPinConfig{[]string{fmt.Sprintf("adc%d", ch)}, []string{"analog"}, 0, ch})
+	}
+}
+
+func (d *OrangePi5Driver) initialiseModules() error {
+	d.modules = make(map[string]Module)
+
+	gpio := NewCdevGPIOModule("gpio")
+	e := gpio.SetOptions(d.getGPIOOptions())
+	if e != nil {
+		return e
+	}
+	d.modules["gpio"] = gpio
+
+	for name := range orangePi5I2C {
+		i2c := NewDTI2CModule(name)
+		e = i2c.SetOptions(d.getI2COptions(name))
+		if e != nil {
+			return e
+		}
+		d.modules[name] = i2c
+	}
+
+	pwm := NewSysfsPWMModule("pwm")
+	e = pwm.SetOptions(d.getPWMOptions())
+	if e != nil {
+		return e
+	}
+	d.modules["pwm"] = pwm
+
+	analog := NewIIOAnalogModule("analog")
+	e = analog.SetOptions(d.getAnalogOptions())
+	if e != nil {
+		return e
+	}
+	d.modules["analog"] = analog
+
+	return nil
+}
+
+// Get options for the GPIO module. The line offset within the bank's chip is the GPIO number modulo 32.
+func (d *OrangePi5Driver) getGPIOOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	chips := make(map[int]string)
+	pins := make(CdevGPIOModulePinDefMap)
+	for i, hw := range d.pinConfigs {
+		if !hw.usedBy("gpio") {
+			continue
+		}
+
+		bank := hw.gpioLogical / 32
+		if _, ok := chips[bank]; !ok {
+			chips[bank] = findGPIOChip(fmt.Sprintf("gpio%d", bank))
+		}
+		if chips[bank] != "" {
+			pins[Pin(i)] = &CdevGPIOModulePinDef{pin: Pin(i), chip: chips[bank], line: hw.gpioLogical % 32}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+func (d *OrangePi5Driver) getI2COptions(module string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTI2CModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy(module) {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+
+	device := findI2CDevice(orangePi5I2C[module][0])
+	if device == "" {
+		device = orangePi5I2C[module][1]
+	}
+	result["device"] = device
+
+	return result
+}
+
+func (d *OrangePi5Driver) getPWMOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(SysfsPWMModulePinDefMap)
+	for pin, position := range d.headerPins {
+		if controller, ok := orangePi5PWM[position]; ok {
+			pins[pin] = &SysfsPWMModulePinDef{pin: pin, controller: controller, channel: 0}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+func (d *OrangePi5Driver) getAnalogOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(IIOAnalogModulePinDefMap)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("analog") {
+			pins[Pin(i)] = &IIOAnalogModulePinDef{pin: Pin(i), channel: hw.analogLogical}
+		}
+	}
+	result["pins"] = pins
+	result["device"] = "saradc"
+
+	return result
+}
+
+// Describe the board for DescribeDriver.
+func (d *OrangePi5Driver) Describe() DriverInfo {
+	info := DriverInfo{Board: BoardModel()}
+	if info.Board == "" {
+		info.Board = "Orange Pi 5"
+	}
+	info.Limitations = []string{
+		"I2C buses and PWM channels on the header need their device tree overlays enabled",
+		"analog values are raw 12-bit readings with a 1.8V reference",
+		"no support for SPI or serial",
+	}
+	return info
+}
+
+func (d *OrangePi5Driver) GetModules() map[string]Module {
+	return d.modules
+}
+
+func (d *OrangePi5Driver) Close() {
+	// Disable all the modules
+	for _, module := range d.modules {
+		module.Disable()
+	}
+}
+
+func (d *OrangePi5Driver) PinMap() (pinMap HardwarePinMap) {
+	pinMap = make(HardwarePinMap)
+
+	for i, hw := range d.pinConfigs {
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+	}
+	for pin, position := range d.headerPins {
+		pinMap.SetHeader(pin, "26pin", position)
+	}
+
+	return
+}
@@ -6,8 +6,14 @@ package hwio
 // - digital write on all support ed GPIO pins
 // - digital read on all GPIO pins, for modes Input.
 //
+// GPIO uses the GPIO character device where the kernel provides it, which supports pull-ups, pull-downs and
+// open-drain outputs. Otherwise it falls back to sysfs.
+//
+// Boards are detected from the device tree, including the Zero 2 W and Compute Module 4. For Compute Modules,
+// the pins a carrier board does not route can be excluded with SetUnroutedPins.
+//
 // Known issues:
-// - InputPullUp and InputPullDown not implemented yet.
+// - InputPullUp and InputPullDown not implemented on kernels without the GPIO character device.
 // - no support yet for SPI, serial
 //
 // References:
@@ -17,12 +23,16 @@ package hwio
 
 import (
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 type RaspberryPiDTDriver struct { // all pins understood by the driver
 	pinConfigs []*DTPinConfig
 
+	// names of header pins that the carrier board does not route, set by SetUnroutedPins
+	unroutedPins []string
+
 	// a map of module names to module objects, created at initialisation
 	modules map[string]Module
 }
@@ -31,7 +41,32 @@ func NewRaspPiDTDriver() *RaspberryPiDTDriver {
 	return &RaspberryPiDTDriver{}
 }
 
+// Mark header pins as not routed, for Compute Module carrier boards that do not connect every pin of the 40 pin
+// header. These pins are reported with the "unrouted" module in the pin map, so they cannot be assigned. This must
+// be called before the driver is initialised by SetDriver, e.g.
+//
+//	d := hwio.NewRaspPiDTDriver()
+//	d.SetUnroutedPins("gpio5", "gpio6")
+//	hwio.SetDriver(d)
+func (d *RaspberryPiDTDriver) SetUnroutedPins(names ...string) {
+	d.unroutedPins = names
+}
+
+// Returns true if the board is a Compute Module, which has no header of its own; its pins are routed by the
+// carrier board.
+func (d *RaspberryPiDTDriver) IsComputeModule() bool {
+	if BoardIsCompatible("raspberrypi,compute-module", "raspberrypi,3-compute-module", "raspberrypi,4-compute-module") {
+		return true
+	}
+	return strings.Contains(BoardModel(), "Compute Module")
+}
+
 func (d *RaspberryPiDTDriver) MatchesHardwareConfig() bool {
+	if BoardIsCompatible("brcm,bcm2708", "brcm,bcm2709", "brcm,bcm2710", "brcm,bcm2835", "brcm,bcm2836", "brcm,bcm2837", "brcm,bcm2711") {
+		return true
+	}
+
+	// kernels without a device tree identify the SoC in /proc/cpuinfo
 	cpuinfo, e := exec.Command("cat", "/proc/cpuinfo").Output()
 	if e != nil {
 		return false
@@ -52,6 +87,8 @@ func (d *RaspberryPiDTDriver) Init() error {
 }
 
 // http://www.hobbytronics.co.uk/raspberry-pi-gpio-pinout
+// All boards since the B+, including the Zero 2 W, have the same 40 pin header. Compute Modules are given the same
+// map, as carrier boards such as the CM4 IO board route GPIO 2-27 to a 40 pin header in the same layout.
 func (d *RaspberryPiDTDriver) createPinData() {
 	switch d.BoardRevision() {
 	case 1:
@@ -129,7 +166,7 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"rxd"}, []string{"serial"}, 0, 0},
 			{[]string{"gpio17"}, []string{"gpio"}, 17, 0},
 			{[]string{"gpio18"}, []string{"gpio"}, 18, 0}, // also supports PWM
-			{[]string{"gpio27"}, []string{"gpio"}, 21, 0},
+			{[]string{"gpio27"}, []string{"gpio"}, 27, 0},
 			{[]string{"ground-3"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio22"}, []string{"gpio"}, 22, 0},
 			{[]string{"gpio23"}, []string{"gpio"}, 23, 0},
@@ -159,19 +196,45 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"gpio21"}, []string{"gpio"}, 21, 0},
 		}
 	}
+
+	for _, hw := range d.pinConfigs {
+		for _, name := range d.unroutedPins {
+			if hw.names[0] == name {
+				hw.modules = []string{"unrouted"}
+			}
+		}
+		if _, ok := piClockPins[hw.gpioLogical]; ok && hw.modules[0] == "gpio" {
+			hw.modules = append(hw.modules, "clock")
+		}
+	}
 }
 
 func (d *RaspberryPiDTDriver) initialiseModules() error {
 	d.modules = make(map[string]Module)
 
-	gpio := NewDTGPIOModule("gpio")
-	e := gpio.SetOptions(d.getGPIOOptions())
+	var gpio GPIOModule
+	if chip := findGPIOChip("pinctrl-bcm2"); chip != "" {
+		gpio = NewCdevGPIOModule("gpio")
+		e := gpio.SetOptions(d.getCdevGPIOOptions(chip))
+		if e != nil {
+			return e
+		}
+	} else {
+		gpio = NewDTGPIOModule("gpio")
+		e := gpio.SetOptions(d.getGPIOOptions())
+		if e != nil {
+			return e
+		}
+	}
+
+	i2c := NewDTI2CModule("i2c")
+	e := i2c.SetOptions(d.getI2COptions())
 	if e != nil {
 		return e
 	}
 
-	i2c := NewDTI2CModule("i2c")
-	e = i2c.SetOptions(d.getI2COptions())
+	spi := NewDTSPIModule("spi")
+	e = spi.SetOptions(d.getSPIOptions())
 	if e != nil {
 		return e
 	}
@@ -183,9 +246,17 @@ func (d *RaspberryPiDTDriver) initialiseModules() error {
 		return e
 	}
 
+	clock := NewPiClockModule("clock")
+	e = clock.SetOptions(d.getClockOptions())
+	if e != nil {
+		return e
+	}
+
 	d.modules["gpio"] = gpio
 	d.modules["i2c"] = i2c
+	d.modules["spi"] = spi
 	d.modules["leds"] = leds
+	d.modules["clock"] = clock
 
 	return nil
 }
@@ -207,6 +278,28 @@ func (d *RaspberryPiDTDriver) getGPIOOptions() map[string]interface{} {
 	return result
 }
 
+// Get options for the character device GPIO module. Line offsets on the Pi's GPIO chip are the BCM GPIO numbers.
+func (d *RaspberryPiDTDriver) getCdevGPIOOptions(chip string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(CdevGPIOModulePinDefMap)
+
+	for i, hw := range d.pinConfigs {
+		if hw.modules[0] == "gpio" {
+			pins[Pin(i)] = &CdevGPIOModulePinDef{pin: Pin(i), chip: chip, line: hw.gpioLogical}
+		}
+	}
+	result["pins"] = pins
+	result["driveStrength"] = GPIODriveStrengthFunc(d.setDriveStrength)
+
+	return result
+}
+
+// Set drive strength of a pin. Note this applies to the whole bank the pin is in.
+func (d *RaspberryPiDTDriver) setDriveStrength(pin Pin, milliamps int) error {
+	return piSetDriveStrength(d.pinConfigs[pin].gpioLogical, milliamps)
+}
+
 func (d *RaspberryPiDTDriver) getI2COptions() map[string]interface{} {
 	result := make(map[string]interface{})
 
@@ -216,11 +309,53 @@ func (d *RaspberryPiDTDriver) getI2COptions() map[string]interface{} {
 
 	result["pins"] = pins
 
+	// the bus is on GPIO 0 and 1 on revision 1 boards, and GPIO 2 and 3 since
+	sda := 2
 	if d.BoardRevision() == 1 {
 		result["device"] = "/dev/i2c-0"
+		sda = 0
 	} else {
 		result["device"] = "/dev/i2c-1"
 	}
+	if chip := findGPIOChip("pinctrl-bcm2"); chip != "" {
+		result["recovery"] = DTI2CRecoveryLines{Chip: chip, SDA: sda, SCL: sda + 1}
+	}
+
+	return result
+}
+
+// The GPIOs that can output a general purpose clock, with the clock and the alternate function that selects it.
+var piClockPins = map[int][2]int{4: {0, 0}, 5: {1, 0}, 6: {2, 0}, 20: {0, 5}, 21: {1, 5}}
+
+// The clock module drives the header pins that have a general purpose clock.
+func (d *RaspberryPiDTDriver) getClockOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(PiClockModulePinDefMap)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("clock") {
+			c := piClockPins[hw.gpioLogical]
+			pins[Pin(i)] = &PiClockModulePinDef{pin: Pin(i), gpioLogical: hw.gpioLogical, clock: c[0], alt: c[1]}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+// The SPI module uses SPI0, whose chip selects are /dev/spidev0.0 and /dev/spidev0.1.
+func (d *RaspberryPiDTDriver) getSPIOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSPIModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.modules[0] == "spi" {
+			pins = append(pins, Pin(i))
+		}
+	}
+
+	result["bus"] = 0
+	result["pins"] = pins
 
 	return result
 }
@@ -239,18 +374,27 @@ func (d *RaspberryPiDTDriver) getLEDOptions(name string) map[string]interface{}
 // Determine the version of Raspberry Pi.
 // This discussion http://www.raspberrypi.org/phpBB3/viewtopic.php?f=44&t=23989
 // was used to determine the algorithm, specifically the comment by gordon@drogon.net
-// It will return 1 or 2.
+// It will return 1 (original 26 pin header), 2 (revised 26 pin header) or 3 (40 pin header).
 func (d *RaspberryPiDTDriver) BoardRevision() int {
-	revision := CpuInfo(0, "Revision")
-	switch revision {
-	case "0002", "0003":
-		return 1
-	case "0010":
-		return 3
+	code, e := strconv.ParseUint(BoardRevisionCode(), 16, 32)
+	if e == nil {
+		// new-style revision codes have bit 23 set, and are all 40 pin boards
+		if code&(1<<23) != 0 {
+			return 3
+		}
+
+		// old-style codes may have warranty bits set above the board type
+		code &= 0xffff
+		if code == 0x0002 || code == 0x0003 {
+			return 1
+		}
+		if code >= 0x0010 {
+			return 3
+		}
 	}
 
 	// Pi 2 boards have different strings, but pinout is the same as B+
-	revision = CpuInfo(0, "CPU revision")
+	revision := CpuInfo(0, "CPU revision")
 	switch revision {
 	case "5":
 		return 3
@@ -259,6 +403,24 @@ func (d *RaspberryPiDTDriver) BoardRevision() int {
 	return 2
 }
 
+// Describe the board for DescribeDriver.
+func (d *RaspberryPiDTDriver) Describe() DriverInfo {
+	info := DriverInfo{Board: BoardModel(), Revision: BoardRevisionCode()}
+	if info.Board == "" {
+		info.Board = "Raspberry Pi"
+	}
+
+	if _, ok := d.modules["gpio"].(*DTGPIOModule); ok {
+		info.Limitations = append(info.Limitations, "GPIO uses sysfs as the GPIO character device was not found, so pull-ups, pull-downs and open-drain outputs are emulated or unavailable")
+	}
+	if d.IsComputeModule() {
+		info.Limitations = append(info.Limitations, "the pin map is that of a 40 pin header; pins the carrier board does not route must be set with SetUnroutedPins")
+	}
+	info.Limitations = append(info.Limitations, "no analog inputs", "no support for PWM, SPI or serial")
+
+	return info
+}
+
 func (d *RaspberryPiDTDriver) GetModules() map[string]Module {
 	return d.modules
 }
@@ -273,8 +435,16 @@ func (d *RaspberryPiDTDriver) Close() {
 func (d *RaspberryPiDTDriver) PinMap() (pinMap HardwarePinMap) {
 	pinMap = make(HardwarePinMap)
 
+	// pin numbers are the positions on the header, which is P1 on the 26 pin boards and J8 on the 40 pin ones
+	header := "J8"
+	if d.BoardRevision() < 3 {
+		header = "P1"
+	}
 	for i, hw := range d.pinConfigs {
-		pinMap.add(Pin(i), hw.names, hw.modules)
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+		if i > 0 {
+			pinMap.SetHeader(Pin(i), header, i)
+		}
 	}
 
 	return
@@ -0,0 +1,240 @@
+package hwio
+
+// A driver for x86 single board computers with a Raspberry Pi compatible 40 pin header, such as the UP board
+// family. GPIO uses the GPIO character device, and I2C the i2c-dev devices of the ACPI enumerated controllers, so
+// this needs no board specific kernel code beyond what the vendor kernel provides.
+//
+// Boards are identified from DMI. On UP boards, the UP pinctrl driver exposes the header as a GPIO chip whose
+// line offsets are the Raspberry Pi (BCM) GPIO numbers, so the pin names are the same as on a Raspberry Pi.
+//
+// Known issues:
+// - LattePanda boards are not supported, as their header GPIO is behind an ATmega co-processor rather than the
+//   SoC, and is not available through the GPIO character device.
+// - no support yet for SPI, serial or PWM
+//
+// References:
+// - https://github.com/up-board/up-community/wiki/Pinout
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// Location of the DMI identification files. This is a variable so tests can point it elsewhere.
+var dmiPath = "/sys/class/dmi/id"
+
+// Describes an x86 board supported by the driver.
+type x86Board struct {
+	// name of the board, for DescribeDriver
+	name string
+
+	// DMI board vendor and board names that identify the board
+	vendor     string
+	boardNames []string
+
+	// label prefix of the GPIO chip for the header
+	gpioChipLabel string
+
+	// i2c-dev device for the I2C pins of the header
+	i2cDevice string
+}
+
+var x86Boards = []*x86Board{
+	{"UP Board", "AAEON", []string{"UP-CHT01"}, "Raspberry Pi compatible UP GPIO", "/dev/i2c-1"},
+	{"UP Squared", "AAEON", []string{"UP-APL01"}, "Raspberry Pi compatible UP GPIO", "/dev/i2c-1"},
+	{"UP Core Plus", "AAEON", []string{"UPC-APL01", "UP-APL03"}, "Raspberry Pi compatible UP GPIO", "/dev/i2c-1"},
+	{"UP Xtreme", "AAEON", []string{"UP-WHL01"}, "Raspberry Pi compatible UP GPIO", "/dev/i2c-1"},
+}
+
+type X86BoardDriver struct {
+	// the detected board
+	board *x86Board
+
+	// GPIO chip device file for the header
+	gpioChip string
+
+	// all pins understood by the driver
+	pinConfigs []*DTPinConfig
+
+	// a map of module names to module objects, created at initialisation
+	modules map[string]Module
+}
+
+func NewX86BoardDriver() *X86BoardDriver {
+	return &X86BoardDriver{}
+}
+
+// The driver applies if DMI identifies a known board and its header GPIO chip is present.
+func (d *X86BoardDriver) MatchesHardwareConfig() bool {
+	d.board = findX86Board()
+	if d.board == nil {
+		return false
+	}
+
+	d.gpioChip = findGPIOChip(d.board.gpioChipLabel)
+	return d.gpioChip != ""
+}
+
+// Identify the board from DMI, returning nil if it's not one we know.
+func findX86Board() *x86Board {
+	vendor := readDMI("board_vendor")
+	name := readDMI("board_name")
+
+	for _, b := range x86Boards {
+		if !strings.EqualFold(vendor, b.vendor) {
+			continue
+		}
+		for _, n := range b.boardNames {
+			if strings.EqualFold(name, n) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func readDMI(field string) string {
+	b, e := ioutil.ReadFile(dmiPath + "/" + field)
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (d *X86BoardDriver) Init() error {
+	d.createPinData()
+	return d.initialiseModules()
+}
+
+// The header follows the Raspberry Pi B+ layout.
+func (d *X86BoardDriver) createPinData() {
+	d.pinConfigs = []*DTPinConfig{
+		{[]string{"null"}, []string{"unassignable"}, 0, 0}, // 0 - spacer
+		{[]string{"3.3v-1"}, []string{"unassignable"}, 0, 0},
+		{[]string{"5v-1"}, []string{"unassignable"}, 0, 0},
+		{[]string{"sda"}, []string{"i2c"}, 0, 0},
+		{[]string{"5v-2"}, []string{"unassignable"}, 0, 0},
+		{[]string{"scl"}, []string{"i2c"}, 0, 0},
+		{[]string{"ground-1"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio4"}, []string{"gpio"}, 4, 0},
+		{[]string{"txd"}, []string{"serial"}, 0, 0},
+		{[]string{"ground-2"}, []string{"unassignable"}, 0, 0},
+		{[]string{"rxd"}, []string{"serial"}, 0, 0},
+		{[]string{"gpio17"}, []string{"gpio"}, 17, 0},
+		{[]string{"gpio18"}, []string{"gpio"}, 18, 0},
+		{[]string{"gpio27"}, []string{"gpio"}, 27, 0},
+		{[]string{"ground-3"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio22"}, []string{"gpio"}, 22, 0},
+		{[]string{"gpio23"}, []string{"gpio"}, 23, 0},
+		{[]string{"3.3v-2"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio24"}, []string{"gpio"}, 24, 0},
+		{[]string{"mosi"}, []string{"spi"}, 0, 0},
+		{[]string{"ground-4"}, []string{"unassignable"}, 0, 0},
+		{[]string{"miso"}, []string{"spi"}, 0, 0},
+		{[]string{"gpio25"}, []string{"gpio"}, 25, 0},
+		{[]string{"sclk"}, []string{"spi"}, 0, 0},
+		{[]string{"gpio8"}, []string{"gpio"}, 8, 0},
+		{[]string{"ground-5"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio7"}, []string{"gpio"}, 7, 0},
+		{[]string{"id-sd"}, []string{"unassignable"}, 0, 0},
+		{[]string{"id-sc"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio5"}, []string{"gpio"}, 5, 0},
+		{[]string{"ground-6"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio6"}, []string{"gpio"}, 6, 0},
+		{[]string{"gpio12"}, []string{"gpio"}, 12, 0},
+		{[]string{"gpio13"}, []string{"gpio"}, 13, 0},
+		{[]string{"ground-7"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio19"}, []string{"gpio"}, 19, 0},
+		{[]string{"gpio16"}, []string{"gpio"}, 16, 0},
+		{[]string{"gpio26"}, []string{"gpio"}, 26, 0},
+		{[]string{"gpio20"}, []string{"gpio"}, 20, 0},
+		{[]string{"ground-8"}, []string{"unassignable"}, 0, 0},
+		{[]string{"gpio21"}, []string{"gpio"}, 21, 0},
+	}
+}
+
+func (d *X86BoardDriver) initialiseModules() error {
+	d.modules = make(map[string]Module)
+
+	gpio := NewCdevGPIOModule("gpio")
+	e := gpio.SetOptions(d.getGPIOOptions())
+	if e != nil {
+		return e
+	}
+
+	i2c := NewDTI2CModule("i2c")
+	e = i2c.SetOptions(d.getI2COptions())
+	if e != nil {
+		return e
+	}
+
+	d.modules["gpio"] = gpio
+	d.modules["i2c"] = i2c
+
+	return nil
+}
+
+// Get options for the GPIO module. Line offsets on the header's GPIO chip are the BCM GPIO numbers.
+func (d *X86BoardDriver) getGPIOOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(CdevGPIOModulePinDefMap)
+	for i, hw := range d.pinConfigs {
+		if hw.modules[0] == "gpio" {
+			pins[Pin(i)] = &CdevGPIOModulePinDef{pin: Pin(i), chip: d.gpioChip, line: hw.gpioLogical}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+func (d *X86BoardDriver) getI2COptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTI2CModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("i2c") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["device"] = d.board.i2cDevice
+
+	return result
+}
+
+// Describe the board for DescribeDriver.
+func (d *X86BoardDriver) Describe() DriverInfo {
+	info := DriverInfo{Revision: readDMI("board_version")}
+	if d.board != nil {
+		info.Board = d.board.name
+	}
+	info.Limitations = []string{"no support for SPI, serial or PWM"}
+	return info
+}
+
+func (d *X86BoardDriver) GetModules() map[string]Module {
+	return d.modules
+}
+
+func (d *X86BoardDriver) Close() {
+	// Disable all the modules
+	for _, module := range d.modules {
+		module.Disable()
+	}
+}
+
+func (d *X86BoardDriver) PinMap() (pinMap HardwarePinMap) {
+	pinMap = make(HardwarePinMap)
+
+	// pin numbers are the positions on the HAT header
+	for i, hw := range d.pinConfigs {
+		pinMap.Add(Pin(i), hw.names, hw.modules)
+		if i > 0 {
+			pinMap.SetHeader(Pin(i), "HAT", i)
+		}
+	}
+
+	return
+}
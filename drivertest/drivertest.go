@@ -0,0 +1,125 @@
+// Package drivertest is a conformance suite for hwio hardware drivers. Drivers in other packages can run it from
+// their own tests, to check they meet the same expectations as the drivers built into hwio:
+//
+//	func TestMyBoardDriver(t *testing.T) {
+//		drivertest.Run(t, func() hwio.HardwareDriver { return NewMyBoardDriver() })
+//	}
+//
+// The checks don't touch hardware beyond what the driver's Init does, so they can run on a development machine,
+// provided Init succeeds there.
+package drivertest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cinellodev/hwio"
+)
+
+// The interface each module is expected to implement, by module name or name prefix. Where several prefixes match,
+// the longest one applies.
+var moduleInterfaces = []struct {
+	prefix string
+	kind   string
+	check  func(m hwio.Module) bool
+}{
+	{"gpio", "GPIO", func(m hwio.Module) bool { _, ok := m.(hwio.GPIOModule); return ok }},
+	{"analog", "analog", func(m hwio.Module) bool { _, ok := m.(hwio.AnalogModule); return ok }},
+	{"i2c", "I2C", func(m hwio.Module) bool { _, ok := m.(hwio.I2CModule); return ok }},
+	{"pwm", "PWM", func(m hwio.Module) bool { _, ok := m.(hwio.PWMModule); return ok }},
+	{"spi", "SPI", func(m hwio.Module) bool { _, ok := m.(hwio.SPIModule); return ok }},
+	{"spislave", "SPI slave", func(m hwio.Module) bool { _, ok := m.(hwio.SPISlaveModule); return ok }},
+	{"leds", "LED", func(m hwio.Module) bool { _, ok := m.(hwio.LEDModule); return ok }},
+}
+
+// Run the conformance tests on a driver. newDriver is called to create a fresh, uninitialised driver; it is
+// initialised with Init, as SetDriver would, and closed at the end.
+func Run(t *testing.T, newDriver func() hwio.HardwareDriver) {
+	d := newDriver()
+	if d == nil {
+		t.Fatal("newDriver returned nil")
+	}
+
+	if e := d.Init(); e != nil {
+		t.Fatalf("Init returned error '%s'", e)
+	}
+	defer d.Close()
+
+	t.Run("PinMap", func(t *testing.T) { checkPinMap(t, d.PinMap()) })
+	t.Run("Modules", func(t *testing.T) { checkModules(t, d.GetModules()) })
+	if dd, ok := d.(hwio.DescribedDriver); ok {
+		t.Run("Describe", func(t *testing.T) { dd.Describe() })
+	}
+}
+
+// Check every pin has a number matching its key, at least one name and module, names that are unique across the
+// map, and a unique position if it's on a header.
+func checkPinMap(t *testing.T, pinMap hwio.HardwarePinMap) {
+	if len(pinMap) == 0 {
+		t.Fatal("PinMap returned no pins")
+	}
+
+	names := make(map[string]hwio.Pin)
+	positions := make(map[string]hwio.Pin)
+	for pin, pd := range pinMap {
+		if pd == nil {
+			t.Errorf("pin %d has no PinDef", pin)
+			continue
+		}
+		if pd.Pin() != pin {
+			t.Errorf("pin %d is in the map under pin number %d", pd.Pin(), pin)
+		}
+
+		if len(pd.NameList()) == 0 {
+			t.Errorf("pin %d has no names", pin)
+		}
+		for _, name := range pd.NameList() {
+			if name == "" {
+				t.Errorf("pin %d has an empty name", pin)
+				continue
+			}
+			key := strings.ToLower(name)
+			if other, ok := names[key]; ok && other != pin {
+				t.Errorf("pins %d and %d are both named '%s'", other, pin, name)
+			}
+			names[key] = pin
+		}
+
+		if len(pd.Modules()) == 0 {
+			t.Errorf("pin %d (%s) has no modules", pin, pd.Names())
+		}
+
+		header, position := pd.Header()
+		if header == "" {
+			continue
+		}
+		if position < 1 {
+			t.Errorf("pin %d (%s) is on header %s at position %d, positions are numbered from 1", pin, pd.Names(), header, position)
+		}
+		key := fmt.Sprintf("%s.%d", strings.ToLower(header), position)
+		if other, ok := positions[key]; ok && other != pin {
+			t.Errorf("pins %d and %d are both at %s.%d", other, pin, header, position)
+		}
+		positions[key] = pin
+	}
+}
+
+// Check every module implements the interface its name implies.
+func checkModules(t *testing.T, modules map[string]hwio.Module) {
+	for name, m := range modules {
+		if m == nil {
+			t.Errorf("module '%s' is nil", name)
+			continue
+		}
+		match := -1
+		for i, mi := range moduleInterfaces {
+			if strings.HasPrefix(name, mi.prefix) && (match < 0 || len(mi.prefix) > len(moduleInterfaces[match].prefix)) {
+				match = i
+			}
+		}
+		if match >= 0 && !moduleInterfaces[match].check(m) {
+			t.Errorf("module '%s' does not implement the %s module interface", name, moduleInterfaces[match].kind)
+		}
+	}
+}
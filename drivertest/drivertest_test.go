@@ -0,0 +1,29 @@
+package drivertest
+
+import (
+	"testing"
+
+	"github.com/cinellodev/hwio"
+)
+
+// The built-in drivers that can be initialised without their hardware present.
+
+func TestMockDriver(t *testing.T) {
+	Run(t, func() hwio.HardwareDriver { return new(hwio.TestDriver) })
+}
+
+func TestRaspPiDTDriver(t *testing.T) {
+	Run(t, func() hwio.HardwareDriver { return hwio.NewRaspPiDTDriver() })
+}
+
+func TestBeagleboneBlackDTDriver(t *testing.T) {
+	Run(t, func() hwio.HardwareDriver { return hwio.NewBeagleboneBlackDTDriver() })
+}
+
+func TestOdroidCXDriver(t *testing.T) {
+	Run(t, func() hwio.HardwareDriver { return hwio.NewOdroidCXDriver() })
+}
+
+func TestOrangePi5Driver(t *testing.T) {
+	Run(t, func() hwio.HardwareDriver { return hwio.NewOrangePi5Driver() })
+}
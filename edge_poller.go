@@ -0,0 +1,146 @@
+package hwio
+
+// Waiting for edges on all watched pins at once. Each line request of the GPIO character device that has a pin
+// being watched is added to a single epoll instance, and one goroutine waits on it, reading the events of each
+// request as it becomes readable and delivering them to the channel of their pin. Watching 50 pins costs one
+// goroutine and one thread blocked in epoll_wait, rather than a goroutine per pin, and starting or stopping a watch
+// doesn't stop the others.
+//
+// Known issues:
+// - events are delivered one request at a time, so a burst on many pins at once is delivered in the order the
+//   kernel reports the requests, not the order the edges happened in; their timestamps give the true order
+// - the kernel queues 16 events per line of a request; if the goroutine is delayed for longer than that many edges
+//   take, the kernel drops the oldest, which shows as a gap in EdgeEvent.Seq
+// - each request being watched is an open file, and counts towards the limit on open files, and the epoll
+//   instance is limited to /proc/sys/fs/epoll/max_user_watches files, normally many thousands
+// - the goroutine and its epoll instance are started on the first watch and never stop
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// The number of ready files taken from the kernel at a time, and the size of the buffer sources read into.
+const (
+	edgePollBatch  = 64
+	edgePollBuffer = 4096
+)
+
+// Counts of the edges delivered by the poller.
+type EdgePollerStats struct {
+	// Files being waited on, each a line request with one or more pins watched
+	Files int
+
+	// Times the poller woke up with files ready, and the events it delivered and dropped because their channel was
+	// full
+	Wakeups   uint64
+	Delivered uint64
+	Dropped   uint64
+}
+
+// A file the poller waits on, which reads and delivers its events when it's readable.
+type edgeSource interface {
+	// Read all the events that are ready into buffer, which may take several reads, and deliver them. Returns the
+	// number delivered and dropped.
+	readEdges(buffer []byte) (delivered int, dropped int)
+}
+
+type edgePoller struct {
+	start sync.Once
+	epfd  int
+
+	// guards err, sources and stats
+	sync.Mutex
+	err     error
+	sources map[int32]edgeSource
+	stats   EdgePollerStats
+}
+
+var edgePolling = edgePoller{sources: make(map[int32]edgeSource)}
+
+// Return counts of the edges delivered so far.
+func GetEdgePollerStats() EdgePollerStats {
+	edgePolling.Lock()
+	defer edgePolling.Unlock()
+	stats := edgePolling.stats
+	stats.Files = len(edgePolling.sources)
+	return stats
+}
+
+// Start waiting on a file, calling its source's readEdges when it is readable. The file must stay open until it's
+// removed.
+func (p *edgePoller) add(fd int, source edgeSource) error {
+	p.start.Do(func() {
+		var e error
+		p.epfd, e = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if e != nil {
+			p.err = fmt.Errorf("could not create an epoll instance for edges: %s", e)
+			return
+		}
+		go p.run()
+	})
+
+	p.Lock()
+	if e := p.err; e != nil {
+		p.Unlock()
+		return e
+	}
+	p.sources[int32(fd)] = source
+	p.Unlock()
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if e := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &event); e != nil {
+		p.Lock()
+		delete(p.sources, int32(fd))
+		p.Unlock()
+		return fmt.Errorf("could not wait for edges: %s", e)
+	}
+	return nil
+}
+
+// Stop waiting on a file. Its source may still be called once, if the file was ready when it was removed.
+func (p *edgePoller) remove(fd int) error {
+	p.Lock()
+	delete(p.sources, int32(fd))
+	p.Unlock()
+	if e := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil); e != nil {
+		return fmt.Errorf("could not stop waiting for edges: %s", e)
+	}
+	return nil
+}
+
+// Wait for files to be ready, and have their sources read them, for as long as the program runs.
+func (p *edgePoller) run() {
+	events := make([]syscall.EpollEvent, edgePollBatch)
+	buffer := make([]byte, edgePollBuffer)
+	for {
+		n, e := syscall.EpollWait(p.epfd, events, -1)
+		if e == syscall.EINTR {
+			continue
+		}
+		if e != nil {
+			p.Lock()
+			p.err = fmt.Errorf("could not wait for edges: %s", e)
+			p.Unlock()
+			return
+		}
+
+		for _, event := range events[:n] {
+			p.Lock()
+			source := p.sources[event.Fd]
+			p.Unlock()
+			if source == nil {
+				continue
+			}
+			delivered, dropped := source.readEdges(buffer)
+
+			p.Lock()
+			p.stats.Delivered += uint64(delivered)
+			p.stats.Dropped += uint64(dropped)
+			p.Unlock()
+		}
+		p.Lock()
+		p.stats.Wakeups++
+		p.Unlock()
+	}
+}
@@ -0,0 +1,49 @@
+// Blink
+//
+// Blinks an LED for one second on, one second off.
+// This is heavily annotated, with error handling.
+//
+// Usage:
+//   blink [-driver name] [-led pin]
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cinellodev/hwio"
+	"github.com/cinellodev/hwio/examples/internal/exampleflags"
+)
+
+// The pin of the LED, which can be changed with -led. On BeagleBone, USR1 is an on-board LED. On boards with a
+// Raspberry Pi style header, the default is position 11, which is GPIO17 on a Raspberry Pi.
+var led = exampleflags.Pin("led", "the LED to blink", exampleflags.Default{
+	Position: 11,
+	Names:    map[string]string{"beaglebone": "USR1", "mock": "gpio1"},
+})
+
+func main() {
+	// Parse the command line, which looks up the pin by name. You could also just use the
+	// logical pin number, but names are more readable. If the pin can't be found, this
+	// prints the error and exits.
+	exampleflags.Parse()
+	ledPin := *led
+
+	// Set the mode of the pin to output. This will return an error if, for example,
+	// we were trying to set an analog input to an output.
+	err := hwio.PinMode(ledPin, hwio.Output)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Run the blink forever
+	for {
+		hwio.DigitalWrite(ledPin, hwio.High)
+		hwio.Delay(1000)
+		hwio.DigitalWrite(ledPin, hwio.Low)
+		hwio.Delay(1000)
+	}
+}
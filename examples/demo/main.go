@@ -0,0 +1,96 @@
+// demo
+//
+// Lists the examples, and launches one, passing it the rest of the command line.
+//
+// Usage:
+//   demo
+//   demo name [-driver name] [example flags]
+//
+// An example is run from a binary of the same name next to demo's own, if there is one, as when the examples have
+// been built with "go build -o bin/ ./examples/...", otherwise with "go run", which needs the Go toolchain and the
+// hwio source. Each example takes -driver, to choose the driver rather than detecting the board ("-driver mock" needs
+// no hardware), and a flag for each pin it uses; "demo name -h" lists them.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// The package the examples are in.
+const examplesPackage = "github.com/cinellodev/hwio/examples/"
+
+// The examples, in the order they are listed.
+var examples = []struct {
+	name        string
+	description string
+}{
+	{"blink", "blink an LED, one second on, one second off"},
+	{"pinmap", "print the driver's map of pins"},
+	{"shiftout", "count in binary on the outputs of a 74HC595 shift register"},
+	{"tlc5940", "drive a TLC5940 LED driver"},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" {
+		list()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, example := range examples {
+		if example.name == name {
+			os.Exit(run(name, os.Args[2:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "demo: no example '%s'\n\n", name)
+	list()
+	os.Exit(2)
+}
+
+func list() {
+	fmt.Fprintln(os.Stderr, "usage: demo name [-driver name] [example flags]")
+	fmt.Fprintln(os.Stderr, "\nexamples:")
+	for _, example := range examples {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", example.name, example.description)
+	}
+}
+
+// Run an example, returning its exit status.
+func run(name string, args []string) int {
+	var cmd *exec.Cmd
+	if path := builtExample(name); path != "" {
+		cmd = exec.Command(path, args...)
+	} else {
+		cmd = exec.Command("go", append([]string{"run", examplesPackage + name}, args...)...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	e := cmd.Run()
+	if exit, ok := e.(*exec.ExitError); ok {
+		return exit.ExitCode()
+	}
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "demo: %s\n", e)
+		return 1
+	}
+	return 0
+}
+
+// Return the path of a binary of the example next to demo's own, or "" if there isn't one.
+func builtExample(name string) string {
+	self, e := os.Executable()
+	if e != nil {
+		return ""
+	}
+	path := filepath.Join(filepath.Dir(self), name)
+	if info, e := os.Stat(path); e != nil || info.IsDir() {
+		return ""
+	}
+	return path
+}
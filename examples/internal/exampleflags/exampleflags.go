@@ -0,0 +1,164 @@
+// The command line handling the examples share: a -driver flag to choose the driver rather than have hwio detect the
+// board, and a flag for each pin an example uses, whose default depends on the board, so the examples run on any
+// supported board without being edited:
+//
+//	led := exampleflags.Pin("led", "the LED to blink", exampleflags.Default{Position: 11, Names: map[string]string{"beaglebone": "USR1"}})
+//	exampleflags.Parse()
+//	hwio.PinMode(*led, hwio.Output)
+//
+// "-driver mock" runs an example on the mock driver, which needs no hardware.
+
+package exampleflags
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cinellodev/hwio"
+)
+
+// The drivers -driver can choose, by board name.
+var drivers = map[string]func() hwio.HardwareDriver{
+	"beaglebone":    func() hwio.HardwareDriver { return hwio.NewBeagleboneBlackDTDriver() },
+	"raspberrypi":   func() hwio.HardwareDriver { return hwio.NewRaspPiDTDriver() },
+	"odroid":        func() hwio.HardwareDriver { return hwio.NewOdroidCXDriver() },
+	"librecomputer": func() hwio.HardwareDriver { return hwio.NewLibreComputerDriver() },
+	"orangepi5":     func() hwio.HardwareDriver { return hwio.NewOrangePi5Driver() },
+	"x86":           func() hwio.HardwareDriver { return hwio.NewX86BoardDriver() },
+	"gpiochip":      func() hwio.HardwareDriver { return hwio.NewGPIOChipDriver() },
+	"mock":          func() hwio.HardwareDriver { return new(hwio.TestDriver) },
+}
+
+// The names of the Raspberry Pi style headers of the boards that have one, by board name. The Raspberry Pi's is P1 on
+// the first 26 pin boards, and J8 on the rest.
+var gpioHeaders = map[string][]string{
+	"raspberrypi":   {"J8", "P1"},
+	"odroid":        {"J2"},
+	"librecomputer": {"7J1"},
+	"orangepi5":     {"26pin"},
+	"x86":           {"HAT"},
+}
+
+var driverName = flag.String("driver", "", "the driver to use rather than detecting the board: "+strings.Join(Drivers(), ", "))
+
+// The default of a pin flag.
+type Default struct {
+	// The position on the board's Raspberry Pi style header, for boards that have one and aren't in Names.
+	Position int
+
+	// The names of the pin on particular boards, e.g. {"beaglebone": "P8.3"}.
+	Names map[string]string
+}
+
+type pinFlag struct {
+	name  string
+	value string
+	def   Default
+	pin   *hwio.Pin
+}
+
+var pinFlags []*pinFlag
+
+// Return the board names -driver accepts, in order.
+func Drivers() []string {
+	result := make([]string, 0, len(drivers))
+	for name := range drivers {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Define a flag for a pin, by name or as "<header>.<position>", e.g. "P8.13". The pin is set by Parse, from the flag
+// or from the default for the board.
+func Pin(name string, usage string, def Default) *hwio.Pin {
+	f := &pinFlag{name: name, def: def, pin: new(hwio.Pin)}
+	flag.StringVar(&f.value, name, "", usage+" (default depends on the board)")
+	pinFlags = append(pinFlags, f)
+	return f.pin
+}
+
+// Parse the command line, set the driver if -driver was given, and look up the pins, exiting with a message if any
+// of that fails.
+func Parse() {
+	flag.Parse()
+
+	if *driverName != "" {
+		newDriver, ok := drivers[*driverName]
+		if !ok {
+			exit(fmt.Errorf("unknown driver '%s', expected one of %s", *driverName, strings.Join(Drivers(), ", ")))
+		}
+		if e := hwio.SetDriver(newDriver()); e != nil {
+			exit(e)
+		}
+	}
+	if hwio.GetDriver() == nil {
+		exit(fmt.Errorf("no driver for this hardware, use -driver to choose one"))
+	}
+
+	board := Board()
+	for _, f := range pinFlags {
+		name := f.value
+		if name == "" {
+			name = f.def.name(board)
+		}
+		if name == "" {
+			exit(fmt.Errorf("no default for -%s on %s, use -%s to choose a pin", f.name, board, f.name))
+		}
+		pin, e := hwio.GetPin(name)
+		if e != nil {
+			exit(fmt.Errorf("-%s: %s", f.name, e))
+		}
+		*f.pin = pin
+	}
+}
+
+// Return the board name of the driver in use, as -driver takes, or "" if it isn't one of them.
+func Board() string {
+	switch hwio.GetDriver().(type) {
+	case *hwio.BeagleBoneBlackDriver:
+		return "beaglebone"
+	case *hwio.RaspberryPiDTDriver:
+		return "raspberrypi"
+	case *hwio.OdroidCXDriver:
+		return "odroid"
+	case *hwio.LibreComputerDriver:
+		return "librecomputer"
+	case *hwio.OrangePi5Driver:
+		return "orangepi5"
+	case *hwio.X86BoardDriver:
+		return "x86"
+	case *hwio.GPIOChipDriver:
+		return "gpiochip"
+	case *hwio.TestDriver:
+		return "mock"
+	}
+	return ""
+}
+
+// Return the name of the default pin on a board, or "" if there isn't one.
+func (d Default) name(board string) string {
+	if name, ok := d.Names[board]; ok {
+		return name
+	}
+	if d.Position == 0 {
+		return ""
+	}
+	headers := hwio.GetDefinedPins().Headers()
+	for _, header := range gpioHeaders[board] {
+		for _, h := range headers {
+			if h == header {
+				return fmt.Sprintf("%s.%d", header, d.Position)
+			}
+		}
+	}
+	return ""
+}
+
+func exit(e error) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], e)
+	os.Exit(1)
+}
@@ -3,13 +3,18 @@
 // This just prints a map of pins for the device you're using. The maps shows the
 // logical pin number, the name or names that the driver knows the pin by,
 // and the set of capabilities that the driver supports for that pin.
+//
+// Usage:
+//   pinmap [-driver name]
 
 package main
 
 import (
 	"github.com/cinellodev/hwio"
+	"github.com/cinellodev/hwio/examples/internal/exampleflags"
 )
 
 func main() {
+	exampleflags.Parse()
 	hwio.DebugPinMap()
 }
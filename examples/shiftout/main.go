@@ -2,16 +2,34 @@ package main
 
 // An example of shifting 8 bit data to a 74HC595 shift register.
 // Implements a continuous 8-bit binary counter.
+//
+// Usage:
+//   shiftout [-driver name] [-data pin] [-clock pin] [-store pin]
 
 import (
 	"github.com/cinellodev/hwio"
+	"github.com/cinellodev/hwio/examples/internal/exampleflags"
+)
+
+// The pins we're going to use, which can be changed with flags.
+var (
+	data = exampleflags.Pin("data", "the pin connected to the 74HC595's pin 14 (DS)", exampleflags.Default{
+		Position: 11,
+		Names:    map[string]string{"beaglebone": "P8.3", "mock": "gpio1"},
+	})
+	clock = exampleflags.Pin("clock", "the pin connected to the 74HC595's pin 11 (SHCP)", exampleflags.Default{
+		Position: 13,
+		Names:    map[string]string{"beaglebone": "P8.4", "mock": "gpio2"},
+	})
+	store = exampleflags.Pin("store", "the pin connected to the 74HC595's pin 12 (STCP)", exampleflags.Default{
+		Position: 15,
+		Names:    map[string]string{"beaglebone": "P8.5", "mock": "gpio3"},
+	})
 )
 
 func main() {
-	// Get the pins we're going to use. These are on a beaglebone.
-	dataPin, _ := hwio.GetPin("P8.3")  // connected to pin 14
-	clockPin, _ := hwio.GetPin("P8.4") // connected to pin 11
-	storePin, _ := hwio.GetPin("P8.5") // connected to pin 12
+	exampleflags.Parse()
+	dataPin, clockPin, storePin := *data, *clock, *store
 
 	// Make them all outputs
 	hwio.PinMode(dataPin, hwio.Output)
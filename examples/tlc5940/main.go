@@ -0,0 +1,67 @@
+package main
+
+// An example of driving a TLC5940 LED driver. The driver generates the greyscale clock on GSCLK, which should be a
+// PWM pin, and refreshes the outputs in the background. Fades all 16 outputs up from off to full brightness.
+//
+// Usage:
+//   tlc5940 [-driver name] [-sin pin] [-sclk pin] [-xlat pin] [-gsclk pin] [-blank pin]
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cinellodev/hwio/devices/tlc59xx"
+	"github.com/cinellodev/hwio/examples/internal/exampleflags"
+)
+
+// The pins we're going to use, which can be changed with flags.
+var (
+	sin = exampleflags.Pin("sin", "the pin connected to SIN", exampleflags.Default{
+		Position: 11,
+		Names:    map[string]string{"beaglebone": "P9.11", "mock": "gpio1"},
+	})
+	sclk = exampleflags.Pin("sclk", "the pin connected to SCLK", exampleflags.Default{
+		Position: 13,
+		Names:    map[string]string{"beaglebone": "P9.12", "mock": "gpio2"},
+	})
+	xlat = exampleflags.Pin("xlat", "the pin connected to XLAT", exampleflags.Default{
+		Position: 15,
+		Names:    map[string]string{"beaglebone": "P9.13", "mock": "gpio3"},
+	})
+	gsclk = exampleflags.Pin("gsclk", "the pin connected to GSCLK, preferably a PWM pin", exampleflags.Default{
+		Position: 12,
+		Names:    map[string]string{"beaglebone": "P9.14", "mock": "gpio9"},
+	})
+	blank = exampleflags.Pin("blank", "the pin connected to BLANK", exampleflags.Default{
+		Position: 18,
+		Names:    map[string]string{"beaglebone": "P9.15", "mock": "gpio5"},
+	})
+)
+
+func main() {
+	exampleflags.Parse()
+
+	// a single TLC5940, with VPRG tied to ground, so the dot correction stays at its default
+	tlc, e := tlc59xx.NewTLC5940(tlc59xx.TLC5940Config{
+		SIN:   *sin,
+		SCLK:  *sclk,
+		XLAT:  *xlat,
+		BLANK: *blank,
+		GSCLK: *gsclk,
+	})
+	if e != nil {
+		fmt.Printf("Could not initialise the TLC5940: %s", e)
+		return
+	}
+	defer tlc.Close()
+
+	for b := 0; b <= tlc59xx.TLC5940_MAX_GREYSCALE; b += 16 {
+		tlc.SetAll(b)
+		if e = tlc.Update(); e != nil {
+			fmt.Printf("Could not write data: %s", e)
+			return
+		}
+		fmt.Printf("wrote %d\n", b)
+		time.Sleep(20 * time.Millisecond)
+	}
+}
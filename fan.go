@@ -0,0 +1,275 @@
+package hwio
+
+// Control of 4-wire PC fans, for cooling enclosures: a PWM output sets the speed, and the tach output, which pulses
+// (usually twice) every revolution, gives it back. A FanController sets the speed from a temperature, following a
+// curve of temperature to duty, measures the speed from the tach, and reports a stall if the fan isn't turning when
+// it should be.
+//
+// Fans expect PWM at 25kHz, which needs hardware PWM. The tach is an open collector output, so the tach pin needs a
+// pull-up, and a GPIO module with edge detection.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A point on a fan curve: at Temperature, the fan runs at Duty (0 to 1).
+type FanCurvePoint struct {
+	Temperature float64
+	Duty        float64
+}
+
+type FanConfig struct {
+	// The PWM pin driving the fan, and its frequency, 25kHz if 0.
+	PWM   Pin
+	PWMHz float64
+
+	// The tach pin, if UseTach is true, and the tach pulses per revolution, 2 if 0.
+	Tach         Pin
+	UseTach      bool
+	PulsesPerRev int
+
+	// The lowest duty the fan runs reliably at. Lower duties from the curve turn the fan off. When the fan starts,
+	// it runs at full speed for one interval to get it turning.
+	MinDuty float64
+
+	// The temperature, and the curve of temperature to duty, with points in order of temperature. The duty is
+	// interpolated between points, and is that of the first or last point outside them. Without them, the duty is
+	// set with SetDuty.
+	Temperature func() (float64, error)
+	Curve       []FanCurvePoint
+
+	// How often the temperature is read and the speed measured, 2 seconds if 0.
+	Interval time.Duration
+
+	// The fan is stalled if it has been below StallRPM, 200 if 0, for StallTime, 5 seconds if 0, while it should
+	// be running.
+	StallRPM  float64
+	StallTime time.Duration
+
+	// Called from the controller's goroutine when the fan stalls, and when it recovers.
+	OnStall func(status FanStatus)
+}
+
+// The state of a fan.
+type FanStatus struct {
+	// The last temperature read, the duty set, and the speed measured. RPM is 0 without a tach.
+	Temperature float64
+	Duty        float64
+	RPM         float64
+
+	// True if the fan should be running but isn't.
+	Stalled bool
+
+	// The error from reading the temperature or setting the duty, if the last attempt failed. The fan is set to
+	// full speed when the temperature can't be read.
+	Err error
+}
+
+type FanController struct {
+	config FanConfig
+	pwm    PWMModule
+	period time.Duration
+	edges  <-chan EdgeEvent
+
+	stop chan bool
+	done chan bool
+
+	// guards status and manual
+	sync.Mutex
+	status FanStatus
+	manual float64
+}
+
+// Start controlling a fan. The fan starts off, and is set from the curve, or by SetDuty, from the first interval.
+func NewFanController(config FanConfig) (*FanController, error) {
+	if config.PWMHz <= 0 {
+		config.PWMHz = 25000
+	}
+	if config.PulsesPerRev <= 0 {
+		config.PulsesPerRev = 2
+	}
+	if config.Interval <= 0 {
+		config.Interval = 2 * time.Second
+	}
+	if config.StallRPM <= 0 {
+		config.StallRPM = 200
+	}
+	if config.StallTime <= 0 {
+		config.StallTime = 5 * time.Second
+	}
+	if config.Temperature != nil && len(config.Curve) == 0 {
+		return nil, errors.New("fan controller needs a curve to use the temperature")
+	}
+	if !sort.SliceIsSorted(config.Curve, func(i, j int) bool {
+		return config.Curve[i].Temperature < config.Curve[j].Temperature
+	}) {
+		return nil, errors.New("fan curve points must be in order of temperature")
+	}
+
+	pwm := findPWMModule(config.PWM)
+	if pwm == nil {
+		return nil, fmt.Errorf("pin %d is not a PWM pin", config.PWM)
+	}
+	f := &FanController{
+		config: config,
+		pwm:    pwm,
+		period: time.Duration(float64(time.Second) / config.PWMHz),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+	e := startPWM(pwm, config.PWM, f.period, 0)
+	if e != nil {
+		return nil, e
+	}
+
+	if config.UseTach {
+		e = PinMode(config.Tach, InputPullUp)
+		if e == nil {
+			f.edges, e = WatchEdges(config.Tach, EdgeFalling)
+		}
+		if e != nil {
+			pwm.EnablePin(config.PWM, false)
+			return nil, e
+		}
+	}
+
+	go f.run()
+	return f, nil
+}
+
+// Set the duty of a fan without a temperature curve, from 0 to 1. It takes effect at the next interval.
+func (f *FanController) SetDuty(duty float64) error {
+	if f.config.Temperature != nil {
+		return errors.New("fan duty is set by its temperature curve")
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.manual = clampFloat(duty, 0, 1)
+	return nil
+}
+
+// Return the state of the fan.
+func (f *FanController) Status() FanStatus {
+	f.Lock()
+	defer f.Unlock()
+	return f.status
+}
+
+// Stop controlling the fan. If full is true, the fan is left at full speed, which is the safe choice if nothing
+// else will cool the enclosure; otherwise it is turned off.
+func (f *FanController) Close(full bool) error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+
+	if f.config.UseTach {
+		StopWatchingEdges(f.config.Tach)
+	}
+	if full {
+		return f.pwm.SetDuty(f.config.PWM, int64(f.period))
+	}
+	return f.pwm.EnablePin(f.config.PWM, false)
+}
+
+// Return the duty for a temperature from a curve.
+func fanCurveDuty(curve []FanCurvePoint, temperature float64) float64 {
+	if temperature <= curve[0].Temperature {
+		return curve[0].Duty
+	}
+	for i := 1; i < len(curve); i++ {
+		a, b := curve[i-1], curve[i]
+		if temperature <= b.Temperature {
+			return a.Duty + (b.Duty-a.Duty)*(temperature-a.Temperature)/(b.Temperature-a.Temperature)
+		}
+	}
+	return curve[len(curve)-1].Duty
+}
+
+// Count tach pulses, and set the duty every interval.
+func (f *FanController) run() {
+	defer close(f.done)
+
+	ticker := clock.NewTicker(f.config.Interval)
+	defer ticker.Stop()
+
+	pulses := 0
+	last := MonotonicNow()
+	running := false
+	var slowSince time.Duration
+	for {
+		select {
+		case <-f.stop:
+			return
+		case _, ok := <-f.edges:
+			if ok {
+				pulses++
+			} else {
+				f.edges = nil
+			}
+			continue
+		case <-ticker.C():
+		}
+
+		now := MonotonicNow()
+		status := FanStatus{}
+		if f.config.UseTach {
+			status.RPM = float64(pulses) / float64(f.config.PulsesPerRev) * 60 / (now - last).Seconds()
+		}
+		pulses = 0
+		last = now
+
+		f.Lock()
+		duty := f.manual
+		stalled := f.status.Stalled
+		f.Unlock()
+		if f.config.Temperature != nil {
+			t, e := f.config.Temperature()
+			if e != nil {
+				status.Err = e
+				duty = 1
+			} else {
+				status.Temperature = t
+				duty = fanCurveDuty(f.config.Curve, t)
+			}
+		}
+		if duty < f.config.MinDuty {
+			duty = 0
+		}
+
+		// the fan should be turning if it was set running for the last interval
+		if running && status.RPM < f.config.StallRPM && f.config.UseTach {
+			if slowSince == 0 {
+				slowSince = now - f.config.Interval
+			}
+			status.Stalled = now-slowSince >= f.config.StallTime
+		} else {
+			slowSince = 0
+		}
+
+		// start a stopped fan at full speed
+		set := duty
+		if duty > 0 && !running {
+			set = 1
+		}
+		e := f.pwm.SetDuty(f.config.PWM, int64(set*float64(f.period)))
+		if e != nil && status.Err == nil {
+			status.Err = e
+		}
+		running = duty > 0
+		status.Duty = duty
+
+		f.Lock()
+		f.status = status
+		f.Unlock()
+		if status.Stalled != stalled && f.config.OnStall != nil {
+			f.config.OnStall(status)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package hwio
+
+// Managing the file descriptors that modules hold open. The sysfs GPIO module keeps each pin's value file open, and
+// the IIO analog module each channel's raw file, as reopening them for every read or write is an order of magnitude
+// slower. With many pins, e.g. on several GPIO expanders, that can run into the process's limit on open files.
+//
+// A budget limits the number of these files held open: once it is reached, the file used least recently is closed,
+// and reopened when it's next used. Files are also only opened when first used. Without a budget, the default,
+// files are opened straight away and kept open:
+//
+//	hwio.SetFileDescriptorBudget(64)
+//	...
+//	for module, n := range hwio.FileDescriptorsByModule() {
+//		fmt.Printf("%s: %d open\n", module, n)
+//	}
+//
+// Known issues:
+// - only the sysfs files above can be closed and reopened; GPIO line requests, buses and serial ports hold theirs
+//   while they're in use, and are only reported
+// - an operation on a file that was closed pays for reopening it, so a budget below the number of pins used in a
+//   loop makes every operation slower
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Modules that hold file descriptors open other than through the budget implement this interface, so
+// FileDescriptorsByModule can report them.
+type FileDescriptorModule interface {
+	Module
+
+	// Return the number of file descriptors the module holds open.
+	OpenFileDescriptors() int
+}
+
+// The files opened through the manager, and the budget they're held to.
+type fdManager struct {
+	// guards everything
+	sync.Mutex
+	budget int
+
+	// open files, most recently used first, and the number open by each module
+	open   *list.List
+	counts map[string]int
+}
+
+var fileDescriptors = fdManager{open: list.New(), counts: make(map[string]int)}
+
+// Limit the number of sysfs files modules hold open to budget, closing the least recently used when there are more,
+// or remove the limit if budget is 0.
+func SetFileDescriptorBudget(budget int) error {
+	if budget < 0 {
+		return fmt.Errorf("file descriptor budget can't be negative, got %d", budget)
+	}
+	m := &fileDescriptors
+	m.Lock()
+	defer m.Unlock()
+	m.budget = budget
+	m.evict(nil)
+	return nil
+}
+
+// Return the file descriptor budget, or 0 if there is none.
+func GetFileDescriptorBudget() int {
+	fileDescriptors.Lock()
+	defer fileDescriptors.Unlock()
+	return fileDescriptors.budget
+}
+
+// Return the number of file descriptors each module of the default board holds open, by module name. Modules
+// holding none are left out.
+func FileDescriptorsByModule() map[string]int {
+	result := make(map[string]int)
+	fileDescriptors.Lock()
+	for name, n := range fileDescriptors.counts {
+		if n > 0 {
+			result[name] = n
+		}
+	}
+	fileDescriptors.Unlock()
+
+	for name, module := range GetModules() {
+		if m, ok := module.(FileDescriptorModule); ok {
+			if n := m.OpenFileDescriptors(); n > 0 {
+				result[name] += n
+			}
+		}
+	}
+	return result
+}
+
+// Close the least recently used files until the budget is met, leaving keep open. Files being used are skipped. The
+// manager must be locked.
+func (m *fdManager) evict(keep *managedFile) {
+	if m.budget == 0 {
+		return
+	}
+	for e := m.open.Back(); e != nil && m.open.Len() > m.budget; {
+		f := e.Value.(*managedFile)
+		e = e.Prev()
+		if f == keep || !f.TryLock() {
+			continue
+		}
+		f.file.Close()
+		f.file = nil
+		m.remove(f)
+		f.Unlock()
+	}
+}
+
+// Take a file off the open list. The manager must be locked.
+func (m *fdManager) remove(f *managedFile) {
+	m.open.Remove(f.elem)
+	f.elem = nil
+	m.counts[f.owner]--
+}
+
+// A sysfs file opened through the manager, which may be closed while it isn't being used, and reopened when it is.
+type managedFile struct {
+	fsys  SysfsFS
+	owner string
+	name  string
+	write bool
+
+	// guards file and closed, and is held while the file is used, so it isn't closed under an operation
+	sync.Mutex
+	file   SysfsFile
+	closed bool
+
+	// the file's place in the manager's open list, guarded by the manager
+	elem *list.Element
+}
+
+// Open a file of a filesystem for a module, as SysfsFS.OpenFile does, through the manager. Without a budget the file
+// is opened straight away; with one, it is checked and opened when it's first used.
+func openManagedFile(fsys SysfsFS, owner string, name string, write bool) (SysfsFile, error) {
+	f := &managedFile{fsys: fsys, owner: owner, name: name, write: write}
+	if GetFileDescriptorBudget() > 0 {
+		if e := fsys.Access(name, write); e != nil {
+			return nil, e
+		}
+		return f, nil
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if e := f.ensureOpen(); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+// Open the file if it isn't, and mark it as used. The file must be locked.
+func (f *managedFile) ensureOpen() error {
+	if f.closed {
+		return errors.New("file " + f.name + " is closed")
+	}
+	m := &fileDescriptors
+	if f.file != nil {
+		m.Lock()
+		m.open.MoveToFront(f.elem)
+		m.Unlock()
+		return nil
+	}
+
+	file, e := f.fsys.OpenFile(f.name, f.write)
+	if e != nil {
+		return e
+	}
+	f.file = file
+	m.Lock()
+	f.elem = m.open.PushFront(f)
+	m.counts[f.owner]++
+	m.evict(f)
+	m.Unlock()
+	return nil
+}
+
+func (f *managedFile) ReadAt(b []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if e := f.ensureOpen(); e != nil {
+		return 0, e
+	}
+	return f.file.ReadAt(b, off)
+}
+
+func (f *managedFile) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if e := f.ensureOpen(); e != nil {
+		return 0, e
+	}
+	return f.file.Write(b)
+}
+
+// Seek the file. A file that is reopened starts at 0, which is where modules seek to.
+func (f *managedFile) Seek(offset int64, whence int) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	if e := f.ensureOpen(); e != nil {
+		return 0, e
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *managedFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	e := f.file.Close()
+	f.file = nil
+	fileDescriptors.Lock()
+	fileDescriptors.remove(f)
+	fileDescriptors.Unlock()
+	return e
+}
@@ -0,0 +1,241 @@
+// Definitions for the Linux GPIO character device interface (v2 uAPI, kernel 5.10+), as found in
+// include/uapi/linux/gpio.h. The structs mirror the kernel layout exactly, as they are passed directly
+// to ioctl.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/userspace-api/gpio/chardev.html
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	gpioMaxNameSize       = 32
+	gpioV2LinesMax        = 64
+	gpioV2LineNumAttrsMax = 10
+
+	// ioctl request numbers, which encode the size of the struct passed.
+	gpioGetChipInfoIoctl     = 0x8044B401
+	gpioV2GetLineInfoIoctl   = 0xC100B405
+	gpioV2GetLineIoctl       = 0xC250B407
+	gpioV2LineSetConfigIoctl = 0xC110B40D
+	gpioV2LineGetValuesIoctl = 0xC010B40E
+	gpioV2LineSetValuesIoctl = 0xC010B40F
+)
+
+// Line flags (enum gpio_v2_line_flag)
+const (
+	gpioV2LineFlagUsed          = 1 << 0
+	gpioV2LineFlagActiveLow     = 1 << 1
+	gpioV2LineFlagInput         = 1 << 2
+	gpioV2LineFlagOutput        = 1 << 3
+	gpioV2LineFlagEdgeRising    = 1 << 4
+	gpioV2LineFlagEdgeFalling   = 1 << 5
+	gpioV2LineFlagOpenDrain     = 1 << 6
+	gpioV2LineFlagOpenSource    = 1 << 7
+	gpioV2LineFlagBiasPullUp    = 1 << 8
+	gpioV2LineFlagBiasPullDown  = 1 << 9
+	gpioV2LineFlagBiasDisabled  = 1 << 10
+	gpioV2LineFlagEventRealtime = 1 << 11
+)
+
+// Line attribute ids (enum gpio_v2_line_attr_id)
+const (
+	gpioV2LineAttrIdFlags        = 1
+	gpioV2LineAttrIdOutputValues = 2
+	gpioV2LineAttrIdDebounce     = 3
+)
+
+// Edge event ids (enum gpio_v2_line_event_id)
+const (
+	gpioV2LineEventRisingEdge  = 1
+	gpioV2LineEventFallingEdge = 2
+)
+
+type gpioChipInfo struct {
+	name  [gpioMaxNameSize]byte
+	label [gpioMaxNameSize]byte
+	lines uint32
+}
+
+type gpioV2LineInfo struct {
+	name     [gpioMaxNameSize]byte
+	consumer [gpioMaxNameSize]byte
+	offset   uint32
+	numAttrs uint32
+	flags    uint64
+	attrs    [gpioV2LineNumAttrsMax]gpioV2LineAttribute
+	padding  [4]uint32
+}
+
+type gpioV2LineAttribute struct {
+	id      uint32
+	padding uint32
+	value   uint64 // flags, output values or debounce period, depending on id
+}
+
+type gpioV2LineConfigAttribute struct {
+	attr gpioV2LineAttribute
+	mask uint64
+}
+
+type gpioV2LineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [gpioV2LineNumAttrsMax]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	offsets         [gpioV2LinesMax]uint32
+	consumer        [gpioMaxNameSize]byte
+	config          gpioV2LineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+type gpioV2LineValues struct {
+	bits uint64
+	mask uint64
+}
+
+// An edge event, read from a line request's file.
+type gpioV2LineEvent struct {
+	timestampNs uint64
+	id          uint32
+	offset      uint32
+	seqno       uint32
+	lineSeqno   uint32
+	padding     [6]uint32
+}
+
+// Add an attribute to a line config that sets the (logical) output values of the lines in mask.
+func (lc *gpioV2LineConfig) setOutputValues(bits uint64, mask uint64) {
+	attr := &lc.attrs[lc.numAttrs]
+	attr.attr.id = gpioV2LineAttrIdOutputValues
+	attr.attr.value = bits
+	attr.mask = mask
+	lc.numAttrs++
+}
+
+// Add the line at index i to an attribute setting its debounce period, sharing one with lines of the same period.
+func (lc *gpioV2LineConfig) addDebounce(i int, period time.Duration) error {
+	us := uint64(period / time.Microsecond)
+	for a := uint32(0); a < lc.numAttrs; a++ {
+		if lc.attrs[a].attr.id == gpioV2LineAttrIdDebounce && lc.attrs[a].attr.value == us {
+			lc.attrs[a].mask |= 1 << uint(i)
+			return nil
+		}
+	}
+	// one attribute is always left for output values
+	if lc.numAttrs >= gpioV2LineNumAttrsMax-1 {
+		return errors.New("too many different pin configurations in one group")
+	}
+	attr := &lc.attrs[lc.numAttrs]
+	attr.attr.id = gpioV2LineAttrIdDebounce
+	attr.attr.value = us
+	attr.mask = 1 << uint(i)
+	lc.numAttrs++
+	return nil
+}
+
+// Perform a GPIO ioctl, returning the errno as an error if it fails. This is a variable so tests can record the
+// ioctls a module makes, and answer them without a GPIO chip.
+var gpioIoctl = sysGPIOIoctl
+
+func sysGPIOIoctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if err != 0 {
+		return syscall.Errno(err)
+	}
+	return nil
+}
+
+// Convert a fixed size, nul-terminated name from the kernel to a string.
+func gpioCString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// Read the chip info for a GPIO character device, e.g. /dev/gpiochip0.
+func readGPIOChipInfo(path string) (*gpioChipInfo, error) {
+	f, e := os.OpenFile(path, os.O_RDWR, 0)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	info := &gpioChipInfo{}
+	e = gpioIoctl(f.Fd(), gpioGetChipInfoIoctl, unsafe.Pointer(info))
+	if e != nil {
+		return nil, e
+	}
+	return info, nil
+}
+
+// Find a GPIO line by the name given to it in device tree (gpio-line-names), e.g. "7J1 Header Pin7". Returns the
+// chip device file and line offset, or "" if no chip has a line of that name.
+func findGPIOLine(name string) (string, int) {
+	matches, e := filepath.Glob("/dev/gpiochip*")
+	if e != nil {
+		return "", 0
+	}
+
+	for _, path := range matches {
+		info, e := readGPIOChipInfo(path)
+		if e != nil {
+			continue
+		}
+
+		f, e := os.OpenFile(path, os.O_RDWR, 0)
+		if e != nil {
+			continue
+		}
+		for i := uint32(0); i < info.lines; i++ {
+			li := &gpioV2LineInfo{offset: i}
+			if gpioIoctl(f.Fd(), gpioV2GetLineInfoIoctl, unsafe.Pointer(li)) != nil {
+				break
+			}
+			if gpioCString(li.name[:]) == name {
+				f.Close()
+				return path, int(i)
+			}
+		}
+		f.Close()
+	}
+	return "", 0
+}
+
+// Find the GPIO character device whose label starts with the given prefix, e.g. "pinctrl-bcm2" on a
+// Raspberry Pi. Returns "" if there is no such chip, including on kernels without the character device.
+func findGPIOChip(labelPrefix string) string {
+	matches, e := filepath.Glob("/dev/gpiochip*")
+	if e != nil {
+		return ""
+	}
+
+	for _, path := range matches {
+		info, e := readGPIOChipInfo(path)
+		if e != nil {
+			continue
+		}
+		if strings.HasPrefix(gpioCString(info.label[:]), labelPrefix) {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package hwio
+
+// Hot-plugging of USB adapters for I2C, SPI and serial, which come and go while a program runs. WatchHotplug
+// listens for the kernel's uevents on a netlink socket, and when an adapter's device appears it creates a module
+// for it and registers it with RegisterModule, so GetModule finds it like any other; when the device goes, the
+// module is disabled and unregistered. The application is told of each change through a callback.
+//
+// Only devices under a USB port are handled, so on-board buses are left to the driver. Modules are named after the
+// kind and device: "usb-i2c-7" for /dev/i2c-7, "usb-spi-1" for the /dev/spidev1.* devices of bus 1, and
+// "usb-serial-ttyUSB0" for /dev/ttyUSB0.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Location of the sysfs device classes, scanned for adapters already plugged in. This is a variable so tests can
+// point it elsewhere.
+var sysClassPath = "/sys/class"
+
+// A change in the adapters plugged in.
+type HotplugEvent struct {
+	// True if the adapter was plugged in, false if it was removed
+	Added bool
+
+	// "i2c", "spi" or "serial"
+	Kind string
+
+	// The device file, e.g. "/dev/i2c-7". For SPI this is the first chip select seen.
+	Device string
+
+	// The name the module is registered under, and the module, which is nil when it's removed. The module is not
+	// enabled; that is up to the callback.
+	Name   string
+	Module Module
+
+	// The error creating or registering the module, if that failed.
+	Err error
+}
+
+type HotplugWatcher struct {
+	callback func(HotplugEvent)
+
+	// guards modules
+	sync.Mutex
+	modules map[string]Module
+
+	file *os.File
+	done chan bool
+}
+
+// Start watching for USB adapters, calling callback for each one plugged in or removed. Adapters already plugged in
+// are reported first, before this returns. Later events are reported from the watcher's goroutine, so the callback
+// must not block for long, and an application that looks modules up from other goroutines while adapters come and
+// go should guard its use of them.
+func WatchHotplug(callback func(HotplugEvent)) (*HotplugWatcher, error) {
+	if callback == nil {
+		return nil, errors.New("WatchHotplug needs a callback")
+	}
+
+	fd, e := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if e != nil {
+		return nil, fmt.Errorf("could not open uevent socket: %s", e)
+	}
+	// group 1 is the kernel's uevents, as opposed to udev's
+	e = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1})
+	if e != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not bind uevent socket: %s", e)
+	}
+	syscall.SetNonblock(fd, true)
+
+	w := newHotplugWatcher(callback)
+	w.file = os.NewFile(uintptr(fd), "uevent")
+	w.scan()
+	go w.run()
+	return w, nil
+}
+
+func newHotplugWatcher(callback func(HotplugEvent)) *HotplugWatcher {
+	return &HotplugWatcher{callback: callback, modules: make(map[string]Module), done: make(chan bool)}
+}
+
+// Stop watching. Modules for adapters still plugged in stay registered.
+func (w *HotplugWatcher) Close() error {
+	e := w.file.Close()
+	<-w.done
+	return e
+}
+
+// Return the modules of the adapters plugged in, by name.
+func (w *HotplugWatcher) Modules() map[string]Module {
+	w.Lock()
+	defer w.Unlock()
+
+	result := make(map[string]Module)
+	for name, m := range w.modules {
+		result[name] = m
+	}
+	return result
+}
+
+func (w *HotplugWatcher) run() {
+	defer close(w.done)
+
+	buf := make([]byte, 8192)
+	for {
+		n, e := w.file.Read(buf)
+		if e != nil {
+			// the file is closed
+			return
+		}
+		w.handle(parseUevent(buf[:n]))
+	}
+}
+
+// Report the USB adapters already plugged in.
+func (w *HotplugWatcher) scan() {
+	for _, class := range []string{"i2c-dev", "spidev", "tty"} {
+		entries, _ := filepath.Glob(filepath.Join(sysClassPath, class, "*"))
+		for _, entry := range entries {
+			path, e := filepath.EvalSymlinks(entry)
+			if e != nil {
+				continue
+			}
+			w.handle(map[string]string{
+				"ACTION":    "add",
+				"DEVPATH":   path,
+				"SUBSYSTEM": class,
+				"DEVNAME":   filepath.Base(entry),
+			})
+		}
+	}
+}
+
+// Handle a uevent, creating or removing a module if it's for a USB adapter.
+func (w *HotplugWatcher) handle(env map[string]string) {
+	kind, name, device, ok := hotplugDevice(env)
+	if !ok {
+		return
+	}
+
+	w.Lock()
+	m, known := w.modules[name]
+	switch {
+	case env["ACTION"] == "add" && !known:
+		m, e := newHotplugModule(kind, name, device)
+		if e == nil {
+			e = RegisterModule(name, m)
+		}
+		if e != nil {
+			w.Unlock()
+			w.callback(HotplugEvent{Added: true, Kind: kind, Device: device, Name: name, Err: e})
+			return
+		}
+		w.modules[name] = m
+		w.Unlock()
+		w.callback(HotplugEvent{Added: true, Kind: kind, Device: device, Name: name, Module: m})
+
+	case env["ACTION"] == "remove" && known:
+		delete(w.modules, name)
+		m.Disable()
+		e := UnregisterModule(name)
+		w.Unlock()
+		w.callback(HotplugEvent{Kind: kind, Device: device, Name: name, Err: e})
+
+	default:
+		w.Unlock()
+	}
+}
+
+// Split a uevent into its variables. A uevent is a header, e.g. "add@/devices/...", followed by KEY=value strings,
+// all nul terminated.
+func parseUevent(b []byte) map[string]string {
+	result := make(map[string]string)
+	for _, field := range bytes.Split(b, []byte{0}) {
+		if i := bytes.IndexByte(field, '='); i > 0 {
+			result[string(field[:i])] = string(field[i+1:])
+		}
+	}
+	return result
+}
+
+// Work out the kind of adapter, the module name and the device file from a uevent, returning false if it's not
+// for a USB I2C, SPI or serial device.
+func hotplugDevice(env map[string]string) (kind string, name string, device string, ok bool) {
+	devName := env["DEVNAME"]
+	if devName == "" || !strings.Contains(env["DEVPATH"], "/usb") {
+		return "", "", "", false
+	}
+	device = "/dev/" + devName
+
+	switch env["SUBSYSTEM"] {
+	case "i2c-dev":
+		return "i2c", "usb-" + devName, device, true
+	case "spidev":
+		// spidev<bus>.<chip select>; one module serves all the chip selects of a bus
+		bus := strings.TrimPrefix(devName, "spidev")
+		if i := strings.IndexByte(bus, '.'); i > 0 {
+			bus = bus[:i]
+		}
+		if _, e := strconv.Atoi(bus); e != nil {
+			return "", "", "", false
+		}
+		return "spi", "usb-spi-" + bus, device, true
+	case "tty":
+		if strings.HasPrefix(devName, "ttyUSB") || strings.HasPrefix(devName, "ttyACM") {
+			return "serial", "usb-serial-" + devName, device, true
+		}
+	}
+	return "", "", "", false
+}
+
+// Create a module for an adapter's device. The pins are empty, as an adapter's pins aren't the board's.
+func newHotplugModule(kind string, name string, device string) (Module, error) {
+	switch kind {
+	case "i2c":
+		m := NewDTI2CModule(name)
+		return m, m.SetOptions(map[string]interface{}{"device": device, "pins": DTI2CModulePins{}})
+	case "spi":
+		bus, _ := strconv.Atoi(strings.TrimPrefix(name, "usb-spi-"))
+		m := NewDTSPIModule(name)
+		return m, m.SetOptions(map[string]interface{}{"bus": bus, "pins": DTSPIModulePins{}})
+	case "serial":
+		m := NewTTYSerialModule(name)
+		return m, m.SetOptions(map[string]interface{}{"device": device})
+	}
+	return nil, fmt.Errorf("no module for %s adapters", kind)
+}
@@ -11,9 +11,14 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
+// CLOCK_MONOTONIC, for clock_gettime
+const clockMonotonic = 1
+
 type BitShiftOrder byte
 
 const (
@@ -33,7 +38,9 @@ var definedPins HardwarePinMap
 type assignedPin struct {
 	pin    Pin    // pin being assigned
 	module Module // module that has assigned this pin
-	// pinIOMode PinIOMode // mode that was assigned to this pin
+
+	pinIOMode PinIOMode // mode that was assigned to this pin, if modeSet is true
+	modeSet   bool
 }
 
 // A map of pin numbers to the assigned dynamic properties of the pin. This is
@@ -41,12 +48,17 @@ type assignedPin struct {
 // to determine if the request is valid given the assigned properties of the pin.
 var assignedPins map[Pin]*assignedPin
 
+// Modules registered by the application or other packages, by name. These are in addition to the modules the driver
+// provides.
+var registeredModules map[string]Module
+
 // init() attempts to determine from the environment what the driver is. The
 // intent is that the consumer of the library would not generally have to worry
 // about it, it would just work. If it cannot determine the driver, it doesn't
 // set the driver to anything.
 func init() {
 	assignedPins = make(map[Pin]*assignedPin)
+	registeredModules = make(map[string]Module)
 	if err := determineDriver(); err != nil {
 		log.Printf("HWIO: %s", err)
 	}
@@ -64,7 +76,7 @@ func fileExists(name string) bool {
 // Work out the driver from environment if we can. If we have any problems,
 // don't generate an error, just return with the driver not set.
 func determineDriver() error {
-	drivers := [...]HardwareDriver{NewBeagleboneBlackDTDriver(), NewRaspPiDTDriver(), NewOdroidCXDriver()}
+	drivers := [...]HardwareDriver{NewBeagleboneBlackDTDriver(), NewRaspPiDTDriver(), NewOdroidCXDriver(), NewLibreComputerDriver(), NewOrangePi5Driver(), NewX86BoardDriver()}
 	for _, d := range drivers {
 		if d.MatchesHardwareConfig() {
 			SetDriver(d)
@@ -72,6 +84,12 @@ func determineDriver() error {
 		}
 	}
 
+	// a board we don't know still gets GPIO, if the kernel has the GPIO character device
+	if d := NewGPIOChipDriver(); d.MatchesHardwareConfig() {
+		SetDriver(d)
+		return nil
+	}
+
 	return fmt.Errorf("Unable to select a suitable driver for this board.\n")
 }
 
@@ -93,9 +111,23 @@ func SetDriver(d HardwareDriver) error {
 		return fmt.Errorf("could not initialise driver: %s", e)
 	}
 	definedPins = driver.PinMap()
+	addLineNames(driver, definedPins)
 	return nil
 }
 
+// Add the kernel's names for GPIO lines to the pin map, if the driver's GPIO module can find them.
+func addLineNames(d HardwareDriver, pins HardwarePinMap) {
+	m, ok := d.GetModules()["gpio"].(GPIOLineNameModule)
+	if !ok {
+		return
+	}
+	for pin, pd := range pins {
+		if pd.usedBy("gpio") {
+			pd.lineName = m.LineName(pin)
+		}
+	}
+}
+
 // Retrieve the current hardware driver.
 func GetDriver() HardwareDriver {
 	return driver
@@ -108,7 +140,13 @@ func GetDefinedPins() HardwarePinMap {
 }
 
 // Ensure that any resources external to the program that have been allocated are tidied up.
+// Modules registered with RegisterModule are disabled first.
 func CloseAll() {
+	stopAllAnalogWrites()
+	stopAllAnalogWatches()
+	for _, m := range registeredModules {
+		m.Disable()
+	}
 	if driver == nil {
 		return
 	}
@@ -120,14 +158,21 @@ func CloseAll() {
 //     pin := hwio.GetPin("P8.13")
 // Order of search is:
 // - search hwRefs in the pin map in order.
+// - search the kernel's names for GPIO lines, e.g. "CAM_GPIO", if the GPIO module can find them.
+// - if the name is of the form "<header>.<position>", e.g. "P2.07", look up that position on the header.
 // This function should not generally be relied on for performance. For max speed, call this
 // for each pin you use once on init, and use the returned Pin values thereafter.
 // Search is case sensitive at the moment
 // @todo GetPin: consider making it case-insensitive on name
 // @todo GetPin: consider allowing an int or int as string to identify logical pin directly
 func GetPin(pinName string) (Pin, error) {
+	return defaultBoard.GetPin(pinName)
+}
+
+// Find a pin in a pin map by name, as described for GetPin.
+func findPin(pins HardwarePinMap, pinName string) (Pin, error) {
 	pl := strings.ToLower(pinName)
-	for pin, pinDef := range definedPins {
+	for pin, pinDef := range pins {
 		for _, name := range pinDef.names {
 			if strings.ToLower(name) == pl {
 				return pin, nil
@@ -135,9 +180,33 @@ func GetPin(pinName string) (Pin, error) {
 		}
 	}
 
+	// the kernel's name for the pin's GPIO line, from device tree
+	for pin, pinDef := range pins {
+		if pinDef.lineName != "" && strings.ToLower(pinDef.lineName) == pl {
+			return pin, nil
+		}
+	}
+
+	// a name of the form "<header>.<position>", e.g. "P2.07", refers to a position on a header
+	if header, position, ok := parseHeaderPinName(pinName); ok {
+		if pd := pins.GetHeaderPin(header, position); pd != nil {
+			return pd.pin, nil
+		}
+	}
+
 	return Pin(0), fmt.Errorf("could not find a pin called %s", pinName)
 }
 
+// Returns the Pin at a position on a named header, e.g. GetHeaderPin("P9", 12). Header names depend on the driver,
+// and are listed by GetDefinedPins().Headers().
+func GetHeaderPin(header string, position int) (Pin, error) {
+	pd := definedPins.GetHeaderPin(header, position)
+	if pd == nil {
+		return Pin(0), fmt.Errorf("could not find pin %d on header %s", position, header)
+	}
+	return pd.pin, nil
+}
+
 // Shortcut for calling GetPin and then PinMode.
 func GetPinWithMode(cname string, mode PinIOMode) (pin Pin, e error) {
 	p, e := GetPin(cname)
@@ -151,55 +220,191 @@ func GetPinWithMode(cname string, mode PinIOMode) (pin Pin, e error) {
 
 // Helper function to get GPIO module
 func GetGPIOModule() (GPIOModule, error) {
-	m, e := GetModule("gpio")
+	return defaultBoard.GetGPIOModule()
+}
+
+// Given an internal pin number, return the canonical name for the pin, as defined by the driver. If the pin
+// is not to the driver, return "".
+func PinName(pin Pin) string {
+	return defaultBoard.PinName(pin)
+}
+
+// Set the mode of a pin. Analogous to Arduino pin mode. Options set line attributes as well, e.g.
+//     hwio.PinMode(pin, hwio.Input, hwio.WithPull(hwio.BiasPullUp), hwio.WithDebounce(5*time.Millisecond))
+// in which case the pin is configured as PinModeConfig does.
+func PinMode(pin Pin, mode PinIOMode, options ...PinOption) error {
+	return defaultBoard.PinMode(pin, mode, options...)
+}
+
+// Set the mode of a pin, along with line attributes such as drive, bias and drive strength. If the GPIO module
+// does not support extended configuration, this falls back to PinMode when only the mode is set, and returns
+// an error otherwise.
+func PinModeConfig(pin Pin, config PinConfig) error {
+	return defaultBoard.PinModeConfig(pin, config)
+}
+
+// Set a pin to output, starting at the given level. Unlike PinMode followed by DigitalWrite, the direction and value
+// are set together, so a relay or other load does not see a brief pulse at the level the kernel defaults to.
+func PinModeOutputInit(pin Pin, initialValue int) error {
+	return PinModeConfig(pin, PinConfig{Mode: Output, InitialValue: initialValue, UseInitialValue: true})
+}
+
+// Set whether a pin is active-low. When a pin is active-low, DigitalWrite(pin, High) asserts the pin by driving
+// it electrically low, and DigitalRead returns High when the line is low. This is typical of relay boards and
+// LEDs wired to sink current. The setting is remembered for the pin, so it can be set before or after PinMode.
+func SetActiveLow(pin Pin, activeLow bool) error {
+	gpio, e := GetGPIOModule()
 	if e != nil {
-		return nil, e
+		return e
 	}
 
-	if m == nil {
-		return nil, errors.New("driver does not support GPIO")
+	cm, ok := gpio.(GPIOConfigModule)
+	if !ok {
+		return fmt.Errorf("module '%s' does not support active-low pins", gpio.GetName())
 	}
+	return cm.SetActiveLow(pin, activeLow)
+}
 
-	return m.(GPIOModule), nil
+// Set what happens to GPIO pins when they are closed by ClosePin or CloseAll. This is only supported by GPIO modules
+// that export pins, such as sysfs.
+func SetClosePolicy(policy ClosePolicy) error {
+	cm, e := getClosePolicyModule()
+	if e != nil {
+		return e
+	}
+	cm.SetClosePolicy(policy)
+	return nil
 }
 
-// Given an internal pin number, return the canonical name for the pin, as defined by the driver. If the pin
-// is not to the driver, return "".
-func PinName(pin Pin) string {
-	p := definedPins[pin]
-	if p == nil {
-		return ""
+// Set what happens to a GPIO pin when it is closed, overriding the policy set by SetClosePolicy.
+func SetPinClosePolicy(pin Pin, policy ClosePolicy) error {
+	cm, e := getClosePolicyModule()
+	if e != nil {
+		return e
 	}
-	return p.names[0]
+	cm.SetPinClosePolicy(pin, policy)
+	return nil
 }
 
-// Set the mode of a pin. Analogous to Arduino pin mode.
-func PinMode(pin Pin, mode PinIOMode) error {
+func getClosePolicyModule() (GPIOClosePolicyModule, error) {
 	gpio, e := GetGPIOModule()
+	if e != nil {
+		return nil, e
+	}
+
+	cm, ok := gpio.(GPIOClosePolicyModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' does not support close policies", gpio.GetName())
+	}
+	return cm, nil
+}
+
+// Start watching an input pin for edges, which are delivered on the returned channel with the time the kernel saw
+// them. The pin must already be set as an input. Events are dropped if the channel is full, which shows as a gap in
+// EdgeEvent.Seq.
+func WatchEdges(pin Pin, edge Edge) (<-chan EdgeEvent, error) {
+	em, e := getEdgeModule()
+	if e != nil {
+		return nil, e
+	}
+	if edge == EdgeNone {
+		return nil, errors.New("WatchEdges needs an edge to watch for")
+	}
+	return em.WatchEdges(pin, edge)
+}
+
+// Stop watching a pin for edges, closing the channel returned by WatchEdges.
+func StopWatchingEdges(pin Pin) error {
+	em, e := getEdgeModule()
 	if e != nil {
 		return e
 	}
+	return em.StopWatchingEdges(pin)
+}
+
+// The error WaitForEdge returns if no edge is seen before the timeout.
+var ErrEdgeTimeout = errors.New("timed out waiting for an edge")
 
-	return gpio.PinMode(pin, mode)
+// Wait for an edge on an input pin, returning the pin's value after the edge and when the edge happened, on the same
+// clock as MonotonicNow. A timeout of 0 or less waits indefinitely; otherwise ErrEdgeTimeout is returned if no edge is
+// seen in time. The pin is only watched while waiting, so edges between calls are missed, and it can't be waited on
+// while it is watched by WatchEdges.
+func WaitForEdge(pin Pin, edge Edge, timeout time.Duration) (value int, timestamp time.Duration, e error) {
+	edges, e := WatchEdges(pin, edge)
+	if e != nil {
+		return 0, 0, e
+	}
+	defer StopWatchingEdges(pin)
+	return waitForEdgeEvent(pin, edges, timeout)
 }
 
-// Close a specific pin that has been assigned as GPIO by PinMode
-func ClosePin(pin Pin) error {
+// Wait for an edge event on a channel, for WaitForEdge.
+func waitForEdgeEvent(pin Pin, edges <-chan EdgeEvent, timeout time.Duration) (value int, timestamp time.Duration, e error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case event, ok := <-edges:
+		if !ok {
+			return 0, 0, fmt.Errorf("pin %d stopped being watched while waiting for an edge", pin)
+		}
+		value = Low
+		if event.Rising {
+			value = High
+		}
+		return value, event.Timestamp, nil
+	case <-expired:
+		return 0, 0, ErrEdgeTimeout
+	}
+}
+
+func getEdgeModule() (GPIOEdgeModule, error) {
 	gpio, e := GetGPIOModule()
 	if e != nil {
-		return e
+		return nil, e
 	}
 
-	return gpio.ClosePin(pin)
+	em, ok := gpio.(GPIOEdgeModule)
+	if !ok {
+		return nil, fmt.Errorf("module '%s' does not support edge detection", gpio.GetName())
+	}
+	return em, nil
+}
+
+// Return the time on the kernel's monotonic clock, which is the clock edge events are timestamped with. It counts
+// from an arbitrary point, usually boot, and is not affected by changes to the time of day.
+func MonotonicNow() time.Duration {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	return time.Duration(ts.Nano())
+}
+
+// Close a specific pin that has been assigned as GPIO by PinMode
+func ClosePin(pin Pin) error {
+	return defaultBoard.ClosePin(pin)
 }
 
 // Assign a pin to a module. This is typically called by modules when they allocate pins. If the pin is already assigned,
 // an error is generated. ethod is public in case it is needed to hack around default driver settings.
+// The pin is assigned on the board the module belongs to, so boards opened with NewBoard don't conflict with each
+// other over pin numbers.
 func AssignPin(pin Pin, module Module) error {
-	if a := assignedPins[pin]; a != nil {
+	b := boardOf(module)
+	assigned := b.assignments()
+	if a := assigned[pin]; a != nil {
 		return fmt.Errorf("pin %d is already assigned to module %s", pin, a.module.GetName())
 	}
-	assignedPins[pin] = &assignedPin{pin, module}
+	// lock files are per pin number, so only the default board's pins are locked
+	if b == defaultBoard {
+		if e := lockPin(pin, module); e != nil {
+			return e
+		}
+	}
+	assigned[pin] = &assignedPin{pin: pin, module: module}
 	return nil
 }
 
@@ -214,18 +419,33 @@ func AssignPins(pins PinList, module Module) error {
 	return nil
 }
 
-// Unassign a pin. Method is public in case it is needed to hack around default driver settings.
+// Unassign a pin. Method is public in case it is needed to hack around default driver settings. This unassigns the
+// pin on the default board; modules use UnassignPinFrom, which works for any board.
 func UnassignPin(pin Pin) error {
-	delete(assignedPins, pin)
+	return UnassignPinFrom(pin, nil)
+}
+
+// Unassign a pin assigned to a module, on the board the module belongs to.
+func UnassignPinFrom(pin Pin, module Module) error {
+	b := boardOf(module)
+	delete(b.assignments(), pin)
+	if b == defaultBoard {
+		unlockPin(pin)
+	}
 	return nil
 }
 
 // Unassign a set of pins. Method is public in case it is needed to hack around default driver settings.
 func UnassignPins(pins PinList) (er error) {
+	return UnassignPinsFrom(pins, nil)
+}
+
+// Unassign a set of pins assigned to a module, on the board the module belongs to.
+func UnassignPinsFrom(pins PinList, module Module) (er error) {
 	er = nil
 
 	for _, pin := range pins {
-		e := UnassignPin(pin)
+		e := UnassignPinFrom(pin, module)
 		if e != nil {
 			er = e
 		}
@@ -236,31 +456,97 @@ func UnassignPins(pins PinList) (er error) {
 
 // Write a value to a digital pin
 func DigitalWrite(pin Pin, value int) (e error) {
+	return defaultBoard.DigitalWrite(pin, value)
+}
+
+// Read a value from a digital pin
+func DigitalRead(pin Pin) (result int, e error) {
+	return defaultBoard.DigitalRead(pin)
+}
+
+// given a logic level of High or Low, return the opposite. Invalid values returned as Low.
+func Negate(logicLevel int) int {
+	if logicLevel == Low {
+		return High
+	}
+	return Low
+}
+
+// Set the mode of a set of pins that will be read or written together with DigitalReadGroup or
+// DigitalWriteGroup. Where the GPIO module supports it (e.g. the GPIO character device), the pins are requested
+// together so the group operations are atomic; otherwise this is the same as calling PinMode on each pin.
+func PinModeGroup(pins PinList, mode PinIOMode) error {
 	gpio, e := GetGPIOModule()
 	if e != nil {
 		return e
 	}
 
-	return gpio.DigitalWrite(pin, value)
+	if gm, ok := gpio.(GPIOGroupModule); ok {
+		e = gm.PinModeGroup(pins, mode)
+		if e != nil {
+			return e
+		}
+		for _, pin := range pins {
+			defaultBoard.recordPinMode(pin, mode)
+		}
+		return nil
+	}
+
+	for _, pin := range pins {
+		e = gpio.PinMode(pin, mode)
+		if e != nil {
+			return e
+		}
+		defaultBoard.recordPinMode(pin, mode)
+	}
+	return nil
 }
 
-// Read a value from a digital pin
-func DigitalRead(pin Pin) (result int, e error) {
-	// @todo consider memorizing
+// Read a set of pins, returning the values packed into an integer with the first pin as the most significant bit.
+// This is useful for DIP switches and parallel buses. If the pins were set up with PinModeGroup on a module that
+// supports it, the values are a consistent snapshot taken at one instant; otherwise the pins are read in turn.
+func DigitalReadGroup(pins PinList) (uint32, error) {
+	if len(pins) > 32 {
+		return 0, errors.New("DigitalReadGroup only supports up to 32 pins")
+	}
+
 	gpio, e := GetGPIOModule()
 	if e != nil {
 		return 0, e
 	}
 
-	return gpio.DigitalRead(pin)
+	if gm, ok := gpio.(GPIOGroupModule); ok {
+		return gm.DigitalReadGroup(pins)
+	}
+
+	result := uint32(0)
+	for _, pin := range pins {
+		v, e := gpio.DigitalRead(pin)
+		if e != nil {
+			return 0, e
+		}
+		result = result<<1 | uint32(v&1)
+	}
+	return result, nil
 }
 
-// given a logic level of High or Low, return the opposite. Invalid values returned as Low.
-func Negate(logicLevel int) int {
-	if logicLevel == Low {
-		return High
+// Write a value across a set of pins, with the most significant bit written to the first pin. If the pins were set
+// up with PinModeGroup on a module that supports it, all pins change at once; otherwise this is the same as
+// WriteUIntToPins.
+func DigitalWriteGroup(pins PinList, value uint32) error {
+	if len(pins) > 32 {
+		return errors.New("DigitalWriteGroup only supports up to 32 pins")
 	}
-	return Low
+
+	gpio, e := GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	if gm, ok := gpio.(GPIOGroupModule); ok {
+		return gm.DigitalWriteGroup(pins, value)
+	}
+	return WriteUIntToPins(value, pins)
 }
 
 // Helper function to pulse a pin, which must have been set as GPIO.
@@ -285,38 +571,30 @@ func Pulse(pin Pin, active int, durationMicroseconds int) error {
 	return DigitalWrite(pin, Negate(active))
 }
 
-// Helper function to get GPIO module
+// Helper function to get analog module
 func GetAnalogModule() (AnalogModule, error) {
-	m, e := GetModule("analog")
-	if e != nil {
-		return nil, e
-	}
-
-	if m == nil {
-		return nil, errors.New("driver does not support analog")
-	}
-
-	return m.(AnalogModule), nil
+	return defaultBoard.GetAnalogModule()
 }
 
 // Read an analog value from a pin. The range of values is hardware driver dependent.
 func AnalogRead(pin Pin) (int, error) {
-	analog, e := GetAnalogModule()
-	if e != nil {
-		return 0, e
-	}
+	return defaultBoard.AnalogRead(pin)
+}
 
-	return analog.AnalogRead(pin)
+// Read several analog pins as close together in time as the hardware allows, e.g. the voltage and current of a
+// supply, to calculate its power. Each sample has the time it was taken. An IIO ADC reads the pins in one buffered
+// scan where it can; otherwise the pins are read one after another on the IO thread.
+func AnalogReadMulti(pins ...Pin) ([]AnalogSample, error) {
+	return defaultBoard.AnalogReadMulti(pins...)
 }
 
 // Helper to turn an on-board LED on or off. Uses LED module
 func Led(name string, on bool) error {
-	m, e := GetModule("leds")
+	leds, e := GetLEDModule("leds")
 	if e != nil {
 		return e
 	}
 
-	leds := m.(LEDModule)
 	led, e := leds.GetLED(name)
 	if e != nil {
 		return e
@@ -331,17 +609,15 @@ func Led(name string, on bool) error {
 }
 
 // Delay execution by the specified number of milliseconds. This is a helper
-// function for similarity with Arduino. It is implemented using standard go
-// time package.
+// function for similarity with Arduino. It sleeps on the package's Clock.
 func Delay(duration int) {
-	time.Sleep(time.Duration(duration) * time.Millisecond)
+	clock.Sleep(time.Duration(duration) * time.Millisecond)
 }
 
 // Delay execution by the specified number of microseconds. This is a helper
-// function for similarity with Arduino. It is implemented using standard go
-// time package
+// function for similarity with Arduino. It sleeps on the package's Clock.
 func DelayMicroseconds(duration int) {
-	time.Sleep(time.Duration(duration) * time.Microsecond)
+	clock.Sleep(time.Duration(duration) * time.Microsecond)
 }
 
 // @todo DebugPinMap: sort
@@ -466,21 +742,139 @@ func ReverseBytes32(value uint32) uint32 {
 	return 0
 }
 
-// Get a module by name. If driver is not set, it will return an error. If the driver does not support that module,
-//
+// Get a module by name. Modules registered with RegisterModule are returned first, then modules provided by the
+// driver. If driver is not set and no module of that name is registered, it will return an error. If the driver does
+// not support that module, nil is returned.
 func GetModule(name string) (Module, error) {
-	driver := GetDriver()
-	if driver == nil {
-		return nil, errors.New("GetModule: Driver is not set")
+	return defaultBoard.GetModule(name)
+}
+
+// Get an I2C module by name, e.g. "i2c". Unlike GetModule, this returns an error if the module doesn't exist or
+// isn't an I2C module, rather than leaving the caller to make a type assertion.
+func GetI2CModule(name string) (I2CModule, error) {
+	return defaultBoard.GetI2CModule(name)
+}
+
+// Get an SPI module by name, e.g. "spi0".
+func GetSPIModule(name string) (SPIModule, error) {
+	return defaultBoard.GetSPIModule(name)
+}
+
+// Get a PWM module by name, e.g. "pwm" or "pwm2".
+func GetPWMModule(name string) (PWMModule, error) {
+	return defaultBoard.GetPWMModule(name)
+}
+
+// Get a clock module by name, e.g. "clock".
+func GetClockModule(name string) (ClockModule, error) {
+	return defaultBoard.GetClockModule(name)
+}
+
+// Get a serial module by name, e.g. "serial".
+func GetSerialModule(name string) (SerialModule, error) {
+	return defaultBoard.GetSerialModule(name)
+}
+
+// Drive PWM pin b as the inverse of pin a, with deadTime nanoseconds around each edge where both are low. Both
+// pins must be enabled on their PWM module. Returns an error if the module has no hardware support for this; it
+// is never done in software, as a late edge could turn on both switches of a bridge.
+func SetComplementaryPWM(a Pin, b Pin, deadTime int64) error {
+	m, e := getComplementaryPWMModule(a)
+	if e != nil {
+		return e
+	}
+	return m.SetComplementary(a, b, deadTime)
+}
+
+// Return a pair of PWM pins set with SetComplementaryPWM to independent outputs.
+func ClearComplementaryPWM(a Pin, b Pin) error {
+	m, e := getComplementaryPWMModule(a)
+	if e != nil {
+		return e
+	}
+	return m.ClearComplementary(a, b)
+}
+
+// Find the PWM module of a pin, and check it supports complementary outputs.
+func getComplementaryPWMModule(pin Pin) (ComplementaryPWMModule, error) {
+	pd := definedPins[pin]
+	if pd == nil {
+		return nil, fmt.Errorf("pin %d is not known to the driver", pin)
 	}
 
-	modules := driver.GetModules()
-	return modules[name], nil
+	modules := GetModules()
+	for _, name := range pd.modules {
+		if _, ok := modules[name].(PWMModule); !ok {
+			continue
+		}
+		m, ok := modules[name].(ComplementaryPWMModule)
+		if !ok {
+			return nil, fmt.Errorf("module '%s' cannot generate complementary outputs with dead time", name)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("pin %d is not a PWM pin", pin)
+}
+
+// Get an LED module by name, e.g. "leds".
+func GetLEDModule(name string) (LEDModule, error) {
+	return defaultBoard.GetLEDModule(name)
+}
+
+// Register a module under a name, so that it can be retrieved with GetModule like the modules the driver provides.
+// This lets applications and other packages contribute modules such as port expanders or bit-banged buses. It is an
+// error if the name is already used by a registered module or a driver module. The module is not enabled; that is
+// up to the caller.
+func RegisterModule(name string, module Module) error {
+	if name == "" {
+		return errors.New("RegisterModule: module name cannot be empty")
+	}
+	if module == nil {
+		return fmt.Errorf("RegisterModule: module '%s' is nil", name)
+	}
+	if _, ok := registeredModules[name]; ok {
+		return fmt.Errorf("RegisterModule: a module named '%s' is already registered", name)
+	}
+	if driver != nil {
+		if _, ok := driver.GetModules()[name]; ok {
+			return fmt.Errorf("RegisterModule: module name '%s' is already used by the driver", name)
+		}
+	}
+
+	registeredModules[name] = module
+	return nil
+}
+
+// Remove a module registered with RegisterModule. The module is not disabled.
+func UnregisterModule(name string) error {
+	if _, ok := registeredModules[name]; !ok {
+		return fmt.Errorf("UnregisterModule: no module named '%s' is registered", name)
+	}
+	delete(registeredModules, name)
+	return nil
+}
+
+// Return all modules available by name: those provided by the driver, plus those registered with RegisterModule.
+func GetModules() map[string]Module {
+	result := make(map[string]Module)
+	if driver != nil {
+		for name, m := range driver.GetModules() {
+			result[name] = m
+		}
+	}
+	for name, m := range registeredModules {
+		result[name] = m
+	}
+	return result
 }
 
 // This is the interface that hardware drivers implement. Generally all drivers are created
 // but not initialised. If MatchesHardwareConfig() is true and the driver is selected, Init()
 // will be called.
+//
+// Drivers in other packages are selected with SetDriver. They build their pin map with HardwarePinMap.Add and
+// SetHeader, and provide modules implementing the interfaces in module.go; modules claim pins with AssignPin. The
+// drivertest package checks a driver meets the same expectations as the built-in ones.
 type HardwareDriver interface {
 	// Each driver is responsible for evaluating whether it applies to the current hardware
 	// configuration or not. If this function returns false, the driver will not be used and Init
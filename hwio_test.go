@@ -4,7 +4,33 @@ package hwio
 // same uninitialised state.
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 // Get the driver's pin map and check for the pins in it. Tests that the
@@ -60,6 +86,205 @@ func TestGetPin(t *testing.T) {
 	if e == nil {
 		t.Error("function GetPin('P99') should have returned an error but didn't")
 	}
+
+	// the kernel's name for a GPIO line
+	p8, e := GetPin("cam_gpio")
+	if e != nil || p8 != 7 {
+		t.Errorf("function GetPin('cam_gpio') should return 7 from the line name, got %d, %v", p8, e)
+	}
+	pd := GetDefinedPins().GetPin(7)
+	if pd.LineName() != "CAM_GPIO" || !strings.HasSuffix(pd.String(), "line:CAM_GPIO") {
+		t.Errorf("pin 7 should show its line name, got '%s'", pd)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	m := GetDefinedPins()
+	if h := m.Headers(); len(h) != 2 || h[0] != "J1" || h[1] != "J2" {
+		t.Errorf("expected headers J1 and J2, got %v", h)
+	}
+
+	pins := m.HeaderPins("J2")
+	if len(pins) != 2 || pins[0] != 10 || pins[1] != 11 {
+		t.Errorf("expected header J2 to have pins 10 and 11 in order, got %v", pins)
+	}
+
+	p, e := GetPin("J1.03")
+	if e != nil {
+		t.Errorf("function GetPin('J1.03') should not return an error, returned '%s'", e)
+	}
+	if p != 2 {
+		t.Errorf("expected J1.03 to be pin 2, got %d", p)
+	}
+
+	p, e = GetHeaderPin("j2", 1)
+	if e != nil || p != 10 {
+		t.Errorf("expected GetHeaderPin('j2', 1) to return pin 10, got %d, error '%v'", p, e)
+	}
+	if header, position := m.GetPin(p).Header(); header != "J2" || position != 1 {
+		t.Errorf("expected pin 10 to be at J2.1, got %s.%d", header, position)
+	}
+
+	_, e = GetPin("J1.11")
+	if e == nil {
+		t.Error("function GetPin('J1.11') should have returned an error but didn't")
+	}
+}
+
+func TestPinMapFormat(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// the mock GPIO module doesn't assign pins itself
+	AssignPin(2, getMockGPIO(t))
+	e := PinMode(2, Output)
+	if e != nil {
+		t.Fatalf("PinMode(2, Output) returned error '%s'", e)
+	}
+
+	b, e := json.Marshal(GetDefinedPins())
+	if e != nil {
+		t.Fatalf("json.Marshal of the pin map returned error '%s'", e)
+	}
+	var pins []map[string]interface{}
+	if e = json.Unmarshal(b, &pins); e != nil {
+		t.Fatalf("pin map JSON could not be parsed: %s", e)
+	}
+	if len(pins) != len(GetDefinedPins()) {
+		t.Fatalf("expected %d pins in JSON, got %d", len(GetDefinedPins()), len(pins))
+	}
+	p := pins[2]
+	if p["pin"] != 2.0 || p["header"] != "J1" || p["position"] != 3.0 || p["assignedTo"] != "gpio" || p["mode"] != "Output" {
+		t.Errorf("unexpected JSON for pin 2: %v", p)
+	}
+	if _, ok := pins[3]["assignedTo"]; ok {
+		t.Errorf("expected unassigned pin 3 to have no assignment in JSON, got %v", pins[3])
+	}
+	if pins[7]["line"] != "CAM_GPIO" {
+		t.Errorf("expected pin 7 to have its line name in JSON, got %v", pins[7])
+	}
+
+	d := GetDefinedPins().HeaderDiagram("J1")
+	lines := strings.Split(strings.TrimRight(d, "\n"), "\n")
+	if len(lines) != 6 || lines[0] != "J1" {
+		t.Fatalf("expected a title and 5 rows in the J1 diagram, got:\n%s", d)
+	}
+	if !strings.Contains(lines[2], "P3 [gpio Output]   3 | 4   P4") {
+		t.Errorf("expected row 2 of the J1 diagram to show P3 assigned and P4, got '%s'", lines[2])
+	}
+	if GetDefinedPins().HeaderDiagram("J3") != "" {
+		t.Error("expected an empty diagram for a header that doesn't exist")
+	}
+
+	table := GetDefinedPins().Table()
+	if !strings.Contains(table, "J1.3") || !strings.Contains(table, "Output") {
+		t.Errorf("expected the table to show pin 2's header position and mode, got:\n%s", table)
+	}
+
+	UnassignPin(2)
+}
+
+func TestSaveRestoreState(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-state")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pins.json")
+
+	// the mock GPIO module doesn't assign pins itself
+	gpio := getMockGPIO(t)
+	AssignPins(PinList{1, 3}, gpio)
+	defer UnassignPins(PinList{1, 3})
+	PinMode(1, Output)
+	DigitalWrite(1, High)
+	PinMode(3, InputPullUp)
+
+	e = SaveState(path)
+	if e != nil {
+		t.Fatalf("SaveState returned error '%s'", e)
+	}
+
+	SetDriver(new(TestDriver))
+	e = RestoreState(path)
+	if e != nil {
+		t.Fatalf("RestoreState returned error '%s'", e)
+	}
+
+	gpio = getMockGPIO(t)
+	if gpio.pinModes[1] != Output || gpio.pinValues[1] != High || !gpio.pinConfigs[1].UseInitialValue {
+		t.Errorf("expected pin 1 to be restored as an output initialised High, got mode %s value %d", gpio.pinModes[1], gpio.pinValues[1])
+	}
+	if gpio.pinModes[3] != InputPullUp {
+		t.Errorf("expected pin 3 to be restored as InputPullUp, got %s", gpio.pinModes[3])
+	}
+	if _, ok := gpio.pinModes[2]; ok {
+		t.Error("expected pin 2 not to be restored as it wasn't saved")
+	}
+
+	ioutil.WriteFile(path, []byte(`{"version":1,"pins":[{"pin":1,"name":"P9","mode":"Output","value":1}]}`), 0644)
+	if RestoreState(path) == nil {
+		t.Error("expected RestoreState to fail for a pin saved under a different name")
+	}
+}
+
+func TestPinLocking(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-lock")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	e = EnablePinLocking(dir)
+	if e != nil {
+		t.Fatalf("EnablePinLocking returned error '%s'", e)
+	}
+	defer DisablePinLocking()
+
+	// another process is simulated by taking the lock on a separate open file
+	otherLock := func(pin Pin) (*os.File, error) {
+		f, e := os.OpenFile(filepath.Join(dir, "pin"+strconv.Itoa(int(pin))), os.O_RDWR|os.O_CREATE, 0666)
+		if e != nil {
+			return nil, e
+		}
+		e = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if e != nil {
+			f.Close()
+			return nil, e
+		}
+		return f, nil
+	}
+
+	gpio := getMockGPIO(t)
+	e = AssignPin(4, gpio)
+	if e != nil {
+		t.Fatalf("AssignPin should not return an error, returned '%s'", e)
+	}
+	if f, e := otherLock(4); e == nil {
+		f.Close()
+		t.Error("another process should not be able to lock an assigned pin")
+	}
+
+	UnassignPin(4)
+	f, e := otherLock(4)
+	if e != nil {
+		t.Fatalf("another process should be able to lock an unassigned pin, got '%s'", e)
+	}
+	f.WriteAt([]byte("4321\nother\n"), 0)
+
+	e = AssignPin(4, gpio)
+	if e == nil {
+		UnassignPin(4)
+		t.Error("AssignPin of a pin locked by another process should return an error")
+	} else if !strings.Contains(e.Error(), "pid 4321") {
+		t.Errorf("expected the error to name the other process, got '%s'", e)
+	}
+	f.Close()
 }
 
 func TestPinMode(t *testing.T) {
@@ -86,6 +311,70 @@ func TestPinMode(t *testing.T) {
 	}
 }
 
+func TestPinModeConfig(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	pin3, _ := GetPin("p3")
+	e := PinModeConfig(pin3, PinConfig{Mode: Output, Drive: DriveOpenDrain, Bias: BiasPullUp})
+	if e != nil {
+		t.Errorf("function PinModeConfig should not return an error, returned '%s'", e)
+	}
+	c := gpio.MockGetPinConfig(pin3)
+	if c.Mode != Output || c.Drive != DriveOpenDrain || c.Bias != BiasPullUp {
+		t.Errorf("pin config was not passed to the driver, got %+v", c)
+	}
+
+	e = PinModeConfig(pin3, PinConfig{Mode: Input, Drive: DriveOpenDrain})
+	if e == nil {
+		t.Error("open drain on an input should return an error")
+	}
+
+	if b := (PinConfig{Mode: InputPullDown}).effectiveBias(); b != BiasPullDown {
+		t.Errorf("InputPullDown should imply a pull down bias, got %s", b)
+	}
+}
+
+func TestPinModeOptions(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	e := PinMode(4, Input, WithPull(BiasPullUp), WithDebounce(5*time.Millisecond), WithActiveLow())
+	if e != nil {
+		t.Errorf("PinMode with options returned an error: %s", e)
+	}
+	c := gpio.MockGetPinConfig(4)
+	if c.Mode != Input || c.Bias != BiasPullUp || c.Debounce != 5*time.Millisecond || !c.ActiveLow {
+		t.Errorf("pin options were not passed to the driver, got %+v", c)
+	}
+
+	if e := PinMode(5, Output, WithDebounce(time.Millisecond)); e == nil {
+		t.Error("debouncing an output should return an error")
+	}
+
+	c = NewPinConfig(Output, WithDrive(DriveOpenDrain), WithInitialValue(High))
+	if c.Drive != DriveOpenDrain || !c.UseInitialValue || c.InitialValue != High {
+		t.Errorf("NewPinConfig did not apply the options, got %+v", c)
+	}
+
+	// lines with the same debounce period share an attribute
+	configs := []PinConfig{
+		{Mode: Input, Debounce: time.Millisecond},
+		{Mode: Input, Debounce: time.Millisecond},
+		{Mode: Input, Debounce: 10 * time.Millisecond},
+	}
+	lc, e := cdevLineConfigFor(configs, make([]Edge, 3), 0)
+	if e != nil {
+		t.Fatalf("cdevLineConfigFor returned an error: %s", e)
+	}
+	if lc.numAttrs != 2 || lc.attrs[0].attr.id != gpioV2LineAttrIdDebounce || lc.attrs[0].attr.value != 1000 ||
+		lc.attrs[0].mask != 3 || lc.attrs[1].attr.value != 10000 || lc.attrs[1].mask != 4 {
+		t.Errorf("debounce attributes are wrong, got %+v", lc.attrs[:lc.numAttrs])
+	}
+}
+
 func TestDigitalWrite(t *testing.T) {
 	SetDriver(new(TestDriver))
 
@@ -107,84 +396,5067 @@ func TestDigitalWrite(t *testing.T) {
 	}
 }
 
-func TestDigitalRead(t *testing.T) {
-	driver := new(TestDriver)
-	SetDriver(driver)
+func TestActiveLow(t *testing.T) {
+	SetDriver(new(TestDriver))
 
-	pin1, _ := GetPin("p1")
+	gpio := getMockGPIO(t)
 
-	PinMode(pin1, Input)
-	writePinAndCheck(t, pin1, Low)
-	writePinAndCheck(t, pin1, High)
+	pin4, _ := GetPin("p4")
+	SetActiveLow(pin4, true)
+	PinMode(pin4, Output)
+
+	DigitalWrite(pin4, High)
+	if v := gpio.MockGetPinValue(pin4); v != Low {
+		t.Error("writing High to an active-low pin should drive the line low")
+	}
+
+	gpio.MockSetPinValue(pin4, High)
+	if v, _ := DigitalRead(pin4); v != Low {
+		t.Error("reading an active-low pin whose line is high should return Low")
+	}
 }
 
-func getMockGPIO(t *testing.T) *testGPIOModule {
-	g, e := GetModule("gpio")
+func TestPinModeOutputInit(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	pin5, _ := GetPin("p5")
+	e := PinModeOutputInit(pin5, High)
 	if e != nil {
-		t.Errorf("fetching gpio module should not return an error, returned %s", e)
+		t.Errorf("function PinModeOutputInit should not return an error, returned '%s'", e)
 	}
-	if g == nil {
-		t.Error("could not get 'gpio' module")
+	if gpio.MockGetPinMode(pin5) != Output {
+		t.Error("PinModeOutputInit should set the pin to output")
+	}
+	if gpio.MockGetPinValue(pin5) != High {
+		t.Error("PinModeOutputInit should set the initial value of the pin")
 	}
+}
 
-	return g.(*testGPIOModule)
+func TestDigitalGroup(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	pins := PinList{5, 6, 7}
+	e := PinModeGroup(pins, Output)
+	if e != nil {
+		t.Errorf("function PinModeGroup should not return an error, returned '%s'", e)
+	}
+
+	DigitalWriteGroup(pins, 0x6)
+	if gpio.MockGetPinValue(5) != High || gpio.MockGetPinValue(6) != High || gpio.MockGetPinValue(7) != Low {
+		t.Error("DigitalWriteGroup should write the most significant bit to the first pin")
+	}
+
+	gpio.MockSetPinValue(5, Low)
+	gpio.MockSetPinValue(7, High)
+	v, e := DigitalReadGroup(pins)
+	if e != nil {
+		t.Errorf("function DigitalReadGroup should not return an error, returned '%s'", e)
+	}
+	if v != 0x3 {
+		t.Errorf("DigitalReadGroup expected to return 0x3, got 0x%x", v)
+	}
 }
 
-func writePinAndCheck(t *testing.T, pin Pin, value int) {
+func TestParallelBus(t *testing.T) {
+	SetDriver(new(TestDriver))
+
 	gpio := getMockGPIO(t)
 
-	gpio.MockSetPinValue(pin, value)
-	v, e := DigitalRead(pin)
+	bus, e := NewParallelBus(PinList{0, 1, 2, 3}, 4)
 	if e != nil {
-		t.Error("function DigitalRead returned an error")
+		t.Errorf("function NewParallelBus should not return an error, returned '%s'", e)
 	}
-	if v != value {
-		t.Errorf("after writing %d to driver, DigitalRead method should return this value", value)
+	bus.SetTiming(0, 0, 0)
+
+	bus.WriteByte(0xa)
+	if gpio.MockGetPinValue(0) != High || gpio.MockGetPinValue(1) != Low || gpio.MockGetPinValue(2) != High || gpio.MockGetPinValue(3) != Low {
+		t.Error("parallel bus write did not put the value on the data pins")
+	}
+	if gpio.MockGetPinValue(4) != Low {
+		t.Error("parallel bus strobe should be inactive after a write")
+	}
+
+	if _, e = bus.ReadByte(); e == nil {
+		t.Error("parallel bus read without a read/write pin should return an error")
+	}
+
+	bus.SetReadWritePin(5, High)
+	gpio.MockSetPinValue(0, Low)
+	gpio.MockSetPinValue(3, High)
+	v, e := bus.ReadByte()
+	if e != nil {
+		t.Errorf("parallel bus read should not return an error, returned '%s'", e)
+	}
+	if v != 0x3 {
+		t.Errorf("parallel bus read expected 0x3, got 0x%x", v)
 	}
 }
 
-func TestBitManipulation(t *testing.T) {
-	v := UInt16FromUInt8(0x45, 0x65)
-	if v != 0x4565 {
-		t.Errorf("function UInt16FromUInt8 does not work correctly, expected 0x4565, got %04x", v)
+func TestWatchEdges(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	PinMode(1, Input)
+
+	edges, e := WatchEdges(1, EdgeRising)
+	if e != nil {
+		t.Fatalf("WatchEdges should not return an error, returned '%s'", e)
+	}
+	if _, e = WatchEdges(1, EdgeRising); e == nil {
+		t.Error("watching a pin twice should return an error")
+	}
+
+	before := MonotonicNow()
+	gpio.MockSetPinValue(1, High)
+	gpio.MockSetPinValue(1, Low)
+	gpio.MockSetPinValue(1, High)
+
+	for _, seq := range []uint32{1, 2} {
+		event := <-edges
+		if !event.Rising || event.Pin != 1 || event.Seq != seq || event.Timestamp < before {
+			t.Errorf("unexpected edge event %+v", event)
+		}
+	}
+	if len(edges) != 0 {
+		t.Error("falling edges should not be reported when watching for rising edges")
+	}
+
+	StopWatchingEdges(1)
+	if _, ok := <-edges; ok {
+		t.Error("StopWatchingEdges should close the channel")
 	}
 }
 
-func TestCpuInfo(t *testing.T) {
-	s := CpuInfo(0, "processor")
-	if s != "0" {
-		t.Errorf("expected 'processor' property of processor 0 to be 0 from CpuInfo, got '%s'", s)
+func TestWaitForEdge(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	PinMode(1, Input)
+	gpio.MockSetPinValue(1, Low)
+
+	if _, _, e := WaitForEdge(1, EdgeRising, 10*time.Millisecond); e != ErrEdgeTimeout {
+		t.Errorf("WaitForEdge with no edge should time out, returned '%v'", e)
+	}
+
+	edges := make(chan EdgeEvent, 1)
+	edges <- EdgeEvent{Pin: 1, Rising: false, Timestamp: 1234}
+	if v, ts, e := waitForEdgeEvent(1, edges, 0); e != nil || v != Low || ts != 1234 {
+		t.Errorf("waiting for a falling edge returned %d at %v, error '%v'", v, ts, e)
+	}
+	edges <- EdgeEvent{Pin: 1, Rising: true, Timestamp: 5678}
+	if v, ts, e := waitForEdgeEvent(1, edges, time.Second); e != nil || v != High || ts != 5678 {
+		t.Errorf("waiting for a rising edge returned %d at %v, error '%v'", v, ts, e)
+	}
+	close(edges)
+	if _, _, e := waitForEdgeEvent(1, edges, 0); e == nil {
+		t.Error("waiting on a closed channel should return an error")
+	}
+
+	if _, e := WatchEdges(1, EdgeRising); e != nil {
+		t.Errorf("WaitForEdge should stop watching the pin when it returns, got '%s'", e)
 	}
+	if _, _, e := WaitForEdge(1, EdgeRising, time.Millisecond); e == nil || e == ErrEdgeTimeout {
+		t.Error("WaitForEdge on a pin already being watched should return an error")
+	}
+	StopWatchingEdges(1)
 }
 
-func TestAnalogRead(t *testing.T) {
+func TestCameraTrigger(t *testing.T) {
 	SetDriver(new(TestDriver))
 
-	ap1, e := GetPin("p11")
+	gpio := getMockGPIO(t)
+	gpio.MockConnect(0, 1)
+
+	trigger, e := NewCameraTrigger(CameraTriggerConfig{
+		TriggerPin:    0,
+		TriggerActive: High,
+		StrobePin:     1,
+		UseStrobePin:  true,
+		StrobeTimeout: 10 * time.Millisecond,
+	})
 	if e != nil {
-		t.Errorf("function GetPin('p11') should not return an error, returned '%s'", e)
+		t.Fatalf("NewCameraTrigger should not return an error, returned '%s'", e)
 	}
 
-	v, e := AnalogRead(ap1)
+	result, e := trigger.Trigger()
 	if e != nil {
-		t.Errorf("after reading from pin %d, got an unexpected error: %s", ap1, e)
+		t.Errorf("Trigger should not return an error, returned '%s'", e)
 	}
-	if v != 1 {
-		t.Errorf("after reading from pin %d, did not get the expected value 1, got %d", ap1, v)
+	if !result.Strobed || result.Latency < 0 {
+		t.Errorf("trigger should have been matched to its strobe, got %+v", result)
+	}
+	if gpio.MockGetPinValue(0) != Low {
+		t.Error("trigger pin should be inactive after the pulse")
 	}
 
-	ap2, _ := GetPin("p12")
-	v, _ = AnalogRead(ap2)
+	start := MonotonicNow()
+	results, e := trigger.Burst(3, 2*time.Millisecond)
 	if e != nil {
-		t.Errorf("after reading from pin %d, got an unexpected error: %s", ap2, e)
+		t.Errorf("Burst should not return an error, returned '%s'", e)
 	}
-	if v != 1000 {
-		t.Errorf("after reading from pin %d, did not get the expected value 1000, got %d", ap2, v)
+	if len(results) != 3 {
+		t.Fatalf("Burst expected 3 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if !r.Strobed {
+			t.Errorf("burst trigger %d should have been matched to its strobe", i)
+		}
+		if i > 0 && r.Triggered-results[i-1].Triggered < 2*time.Millisecond {
+			t.Errorf("burst triggers should be 2ms apart, got %s", r.Triggered-results[i-1].Triggered)
+		}
+	}
+	if results[0].Triggered < start {
+		t.Error("burst trigger time is before the burst started")
+	}
+	if trigger.StrobeCount() != 4 {
+		t.Errorf("expected 4 strobes, got %d", trigger.StrobeCount())
+	}
+
+	// a camera that doesn't respond
+	trigger.Close()
+	gpio.connections = make(map[Pin][]Pin)
+	trigger, _ = NewCameraTrigger(CameraTriggerConfig{TriggerPin: 0, TriggerActive: High, StrobePin: 1, UseStrobePin: true, StrobeTimeout: time.Millisecond})
+	result, e = trigger.Trigger()
+	if e != nil || result.Strobed {
+		t.Errorf("trigger without a strobe should not be strobed or fail, got %+v, %v", result, e)
 	}
 }
 
-func TestNoErrorCheck(t *testing.T) {
+func TestRegisterModule(t *testing.T) {
 	SetDriver(new(TestDriver))
 
-	// @todo implement TestNoErrorCheck
+	expander := newTestGPIOModule("expander")
+	e := RegisterModule("expander", expander)
+	if e != nil {
+		t.Errorf("RegisterModule should not return an error, returned '%s'", e)
+	}
+
+	m, e := GetModule("expander")
+	if e != nil {
+		t.Errorf("GetModule of a registered module should not return an error, returned '%s'", e)
+	}
+	if m != expander {
+		t.Error("GetModule did not return the registered module")
+	}
+	if GetModules()["expander"] != expander || GetModules()["gpio"] == nil {
+		t.Error("GetModules should include both driver and registered modules")
+	}
+
+	if RegisterModule("expander", newTestGPIOModule("other")) == nil {
+		t.Error("registering a module with a name already registered should return an error")
+	}
+	if RegisterModule("gpio", newTestGPIOModule("gpio")) == nil {
+		t.Error("registering a module with a name used by the driver should return an error")
+	}
+
+	e = UnregisterModule("expander")
+	if e != nil {
+		t.Errorf("UnregisterModule should not return an error, returned '%s'", e)
+	}
+	m, _ = GetModule("expander")
+	if m != nil {
+		t.Error("GetModule should return nil for an unregistered module")
+	}
+}
+
+func TestTypedModuleGetters(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	i2c := NewDTI2CModule("i2c")
+	RegisterModule("i2c", i2c)
+	defer UnregisterModule("i2c")
+
+	m, e := GetI2CModule("i2c")
+	if e != nil || m != i2c {
+		t.Errorf("GetI2CModule should return the registered module, got %v, error '%v'", m, e)
+	}
+	if _, e = GetI2CModule("gpio"); e == nil {
+		t.Error("GetI2CModule of a GPIO module should return an error")
+	}
+	if _, e = GetPWMModule("pwm"); e != nil {
+		t.Errorf("GetPWMModule should return the driver's PWM module, returned error '%s'", e)
+	}
+	if _, e = GetPWMModule("pwm3"); e == nil {
+		t.Error("GetPWMModule of a module that doesn't exist should return an error")
+	}
+	if _, e = GetSPIModule("i2c"); e == nil {
+		t.Error("GetSPIModule of an I2C module should return an error")
+	}
+	if _, e = GetLEDModule("leds"); e == nil {
+		t.Error("GetLEDModule of a module that doesn't exist should return an error")
+	}
+}
+
+func TestDescribeDriver(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	RegisterModule("expander", newTestGPIOModule("expander"))
+	defer UnregisterModule("expander")
+
+	info, e := DescribeDriver()
+	if e != nil {
+		t.Errorf("DescribeDriver should not return an error, returned '%s'", e)
+	}
+	if info.Driver != "TestDriver" {
+		t.Errorf("DescribeDriver expected driver 'TestDriver', got '%s'", info.Driver)
+	}
+
+	found := make(map[string]ModuleInfo)
+	for _, m := range info.Modules {
+		found[m.Name] = m
+	}
+	if m := found["gpio"]; m.Kind != "gpio" || m.Interface != "mock" || m.Registered {
+		t.Errorf("DescribeDriver returned unexpected info for gpio module: %+v", m)
+	}
+	if m := found["analog"]; m.Kind != "analog" {
+		t.Errorf("DescribeDriver returned unexpected info for analog module: %+v", m)
+	}
+	if m := found["expander"]; !m.Registered {
+		t.Errorf("DescribeDriver should report the expander module as registered: %+v", m)
+	}
+	if !strings.Contains(info.String(), "gpio: gpio via mock") {
+		t.Errorf("DriverInfo.String() did not describe the gpio module:\n%s", info.String())
+	}
+}
+
+func TestDigitalRead(t *testing.T) {
+	driver := new(TestDriver)
+	SetDriver(driver)
+
+	pin1, _ := GetPin("p1")
+
+	PinMode(pin1, Input)
+	writePinAndCheck(t, pin1, Low)
+	writePinAndCheck(t, pin1, High)
+}
+
+func getMockGPIO(t *testing.T) *testGPIOModule {
+	g, e := GetModule("gpio")
+	if e != nil {
+		t.Errorf("fetching gpio module should not return an error, returned %s", e)
+	}
+	if g == nil {
+		t.Error("could not get 'gpio' module")
+	}
+
+	return g.(*testGPIOModule)
+}
+
+func writePinAndCheck(t *testing.T, pin Pin, value int) {
+	gpio := getMockGPIO(t)
+
+	gpio.MockSetPinValue(pin, value)
+	v, e := DigitalRead(pin)
+	if e != nil {
+		t.Error("function DigitalRead returned an error")
+	}
+	if v != value {
+		t.Errorf("after writing %d to driver, DigitalRead method should return this value", value)
+	}
+}
+
+func TestBitManipulation(t *testing.T) {
+	v := UInt16FromUInt8(0x45, 0x65)
+	if v != 0x4565 {
+		t.Errorf("function UInt16FromUInt8 does not work correctly, expected 0x4565, got %04x", v)
+	}
+}
+
+func TestCpuInfo(t *testing.T) {
+	s := CpuInfo(0, "processor")
+	if s != "0" {
+		t.Errorf("expected 'processor' property of processor 0 to be 0 from CpuInfo, got '%s'", s)
+	}
+}
+
+func TestBoardInfo(t *testing.T) {
+	dir, e := ioutil.TempDir("", "hwio-dt")
+	if e != nil {
+		t.Fatalf("could not create temporary device tree: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "system"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "model"), []byte("Hardkernel ODROID-C2\x00"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "compatible"), []byte("hardkernel,odroid-c2\x00amlogic,meson-gxbb\x00"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "system", "linux,revision"), []byte{0x00, 0xa0, 0x20, 0x82}, 0644)
+
+	savedPath := deviceTreePath
+	deviceTreePath = dir
+	boardInfoLoaded, boardModel, boardCompatible, boardRevisionCode = false, "", nil, ""
+	defer func() {
+		deviceTreePath = savedPath
+		boardInfoLoaded, boardModel, boardCompatible, boardRevisionCode = false, "", nil, ""
+	}()
+
+	if m := BoardModel(); m != "Hardkernel ODROID-C2" {
+		t.Errorf("BoardModel expected 'Hardkernel ODROID-C2', got '%s'", m)
+	}
+	if c := BoardCompatible(); len(c) != 2 || c[1] != "amlogic,meson-gxbb" {
+		t.Errorf("BoardCompatible returned unexpected strings %v", c)
+	}
+	if !BoardIsCompatible("hardkernel,odroid-c1", "hardkernel,odroid-c2") {
+		t.Error("BoardIsCompatible should match hardkernel,odroid-c2")
+	}
+	if BoardIsCompatible("brcm,bcm2835") {
+		t.Error("BoardIsCompatible should not match brcm,bcm2835")
+	}
+	if r := BoardRevisionCode(); r != "a02082" {
+		t.Errorf("BoardRevisionCode expected 'a02082', got '%s'", r)
+	}
+	if r := odroidCXBoardRevision(); r != 2 {
+		t.Errorf("odroidCXBoardRevision expected 2, got %d", r)
+	}
+}
+
+func TestOdroidAnalogPaths(t *testing.T) {
+	dir, e := ioutil.TempDir("", "hwio-adc")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedSaradc, savedIIO := odroidSaradcPath, iioDevicesPath
+	odroidSaradcPath = filepath.Join(dir, "saradc")
+	iioDevicesPath = filepath.Join(dir, "iio")
+	defer func() {
+		odroidSaradcPath, iioDevicesPath = savedSaradc, savedIIO
+	}()
+
+	for _, dev := range []string{"iio:device0", "iio:device1"} {
+		os.MkdirAll(filepath.Join(iioDevicesPath, dev), 0755)
+		ioutil.WriteFile(filepath.Join(iioDevicesPath, dev, "in_voltage0_raw"), []byte("512\n"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(iioDevicesPath, "iio:device0", "name"), []byte("other-adc\n"), 0644)
+	ioutil.WriteFile(filepath.Join(iioDevicesPath, "iio:device1", "name"), []byte("meson-gxbb-saradc\n"), 0644)
+
+	module := NewODroidCXAnalogModule("analog")
+
+	path, iface, e := module.findAnalogFile(0)
+	if e != nil {
+		t.Errorf("findAnalogFile should find the IIO file, returned '%s'", e)
+	}
+	if iface != "iio" || path != filepath.Join(iioDevicesPath, "iio:device1", "in_voltage0_raw") {
+		t.Errorf("findAnalogFile expected the saradc IIO device, got '%s' via %s", path, iface)
+	}
+
+	os.MkdirAll(odroidSaradcPath, 0755)
+	ioutil.WriteFile(filepath.Join(odroidSaradcPath, "ch0"), []byte("512\n"), 0644)
+	path, iface, _ = module.findAnalogFile(0)
+	if iface != "sysfs" || path != filepath.Join(odroidSaradcPath, "ch0") {
+		t.Errorf("findAnalogFile should prefer the saradc driver, got '%s' via %s", path, iface)
+	}
+
+	_, _, e = module.findAnalogFile(1)
+	if e == nil {
+		t.Error("findAnalogFile should return an error for a channel with no file")
+	}
+}
+
+func TestPiPinMap(t *testing.T) {
+	savedCpuInfo := cpuInfo
+	boardInfoLoaded, boardRevisionCode, cpuInfo = true, "", map[string]string{}
+	defer func() {
+		boardInfoLoaded, boardRevisionCode, cpuInfo = false, "", savedCpuInfo
+	}()
+
+	// each header layout names its GPIO pins after their logical line numbers
+	for code, revision := range map[string]int{"0002": 1, "0004": 2, "a02082": 3} {
+		boardRevisionCode = code
+		d := NewRaspPiDTDriver()
+		if r := d.BoardRevision(); r != revision {
+			t.Errorf("revision code %s should be board revision %d, got %d", code, revision, r)
+		}
+		d.createPinData()
+
+		found27 := false
+		for i, hw := range d.pinConfigs {
+			if hw.modules[0] != "gpio" {
+				continue
+			}
+			if name := fmt.Sprintf("gpio%d", hw.gpioLogical); hw.names[0] != name {
+				t.Errorf("revision %d pin %d is named %s but is logical line %d", revision, i, hw.names[0], hw.gpioLogical)
+			}
+			found27 = found27 || hw.names[0] == "gpio27"
+		}
+		if revision > 1 && !found27 {
+			t.Errorf("revision %d should have gpio27 on its header", revision)
+		}
+	}
+}
+
+func TestPiUnroutedPins(t *testing.T) {
+	d := NewRaspPiDTDriver()
+	d.SetUnroutedPins("gpio17")
+	d.createPinData()
+
+	pinMap := d.PinMap()
+	for _, pd := range pinMap {
+		if pd.Names() != "gpio17" {
+			continue
+		}
+		if len(pd.modules) != 1 || pd.modules[0] != "unrouted" {
+			t.Errorf("unrouted pin gpio17 should only have module 'unrouted', has %v", pd.modules)
+		}
+		if _, ok := d.getGPIOOptions()["pins"].(DTGPIOModulePinDefMap)[pd.pin]; ok {
+			t.Error("unrouted pin gpio17 should not be available to the gpio module")
+		}
+		return
+	}
+	t.Error("gpio17 missing from the Raspberry Pi pin map")
+}
+
+func TestPiClock(t *testing.T) {
+	tests := []struct {
+		hz                    float64
+		source, divisor, mash uint32
+	}{
+		{4.8e6, piClockSourceOscillator, 4 << 12, 0},
+		{10e6, piClockSourcePLLD, 50 << 12, 0},
+		{12.288e6, piClockSourcePLLD, 40<<12 | 2827, 1},
+		{30e6, piClockSourcePLLD, 17 << 12, 0},
+		{5000, piClockSourceOscillator, 3840 << 12, 0},
+	}
+	for _, test := range tests {
+		source, divisor, mash, e := piClockDivisor(test.hz, 19.2e6, 500e6)
+		if e != nil || source != test.source || divisor != test.divisor || mash != test.mash {
+			t.Errorf("clock of %gHz expected source %d divisor %x MASH %d, got %d %x %d, %v", test.hz, test.source, test.divisor, test.mash, source, divisor, mash, e)
+		}
+	}
+	for _, hz := range []float64{1000, 200e6, 0} {
+		if _, _, _, e := piClockDivisor(hz, 19.2e6, 500e6); e == nil {
+			t.Errorf("clock of %gHz should be an error", hz)
+		}
+	}
+
+	// GPCLK0 on GPIO 4, header pin 7, of a Pi 4
+	SetDriver(new(TestDriver))
+	d := NewRaspPiDTDriver()
+	d.createPinData()
+	if def := d.getClockOptions()["pins"].(PiClockModulePinDefMap)[7]; def == nil || def.gpioLogical != 4 || def.clock != 0 || def.alt != 0 {
+		t.Errorf("header pin 7 should be GPCLK0 on GPIO 4, got %+v", def)
+	}
+	module := NewPiClockModule("clock")
+	module.SetOptions(map[string]interface{}{"pins": PiClockModulePinDefMap{
+		7:  {pin: 7, gpioLogical: 4, clock: 0, alt: 0},
+		38: {pin: 38, gpioLogical: 20, clock: 0, alt: 5},
+	}})
+
+	defer func(old func() (*piPeripherals, error), path string) {
+		piMapPeripherals, piCompatiblePath = old, path
+	}(piMapPeripherals, piCompatiblePath)
+	piCompatiblePath = "/nonexistent"
+	registers := &piPeripherals{base: 0xfe000000, clock: make([]byte, 4096), gpio: make([]byte, 4096), close: func() error { return nil }}
+	piMapPeripherals = func() (*piPeripherals, error) { return registers, nil }
+
+	var clock ClockModule = module
+	hz, e := clock.SetClock(7, 27e6)
+	if e != nil || hz != 27e6 {
+		t.Fatalf("SetClock of 27MHz should be exact from a 54MHz oscillator, got %g, %v", hz, e)
+	}
+	if c, div := *piRegister(registers.clock, 0x70), *piRegister(registers.clock, 0x74); c != piClockPassword|piClockEnable|piClockSourceOscillator || div != piClockPassword|2<<12 {
+		t.Errorf("GPCLK0 registers expected enabled from the oscillator divided by 2, got %08x %08x", c, div)
+	}
+	if fsel := *piRegister(registers.gpio, 0) >> 12 & 7; fsel != 4 {
+		t.Errorf("GPIO 4 should be set to ALT0, got function %d", fsel)
+	}
+	if _, e = clock.SetClock(38, 1e6); e == nil {
+		t.Error("SetClock on GPIO 20 should fail while GPCLK0 is on GPIO 4")
+	}
+	if e = clock.StopClock(7); e != nil {
+		t.Errorf("StopClock should not return an error, returned '%s'", e)
+	}
+	if c, fsel := *piRegister(registers.clock, 0x70), *piRegister(registers.gpio, 0)>>12&7; c&piClockEnable != 0 || fsel != 0 {
+		t.Errorf("StopClock should disable GPCLK0 and make GPIO 4 an input, got %08x and function %d", c, fsel)
+	}
+	if _, e = clock.SetClock(38, 1e6); e != nil {
+		t.Errorf("SetClock on GPIO 20 should work once GPIO 4 is stopped, returned '%v'", e)
+	}
+	module.Disable()
+}
+
+func TestX86BoardDetection(t *testing.T) {
+	dir, e := ioutil.TempDir("", "hwio-dmi")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedPath := dmiPath
+	dmiPath = dir
+	defer func() {
+		dmiPath = savedPath
+	}()
+
+	if findX86Board() != nil {
+		t.Error("findX86Board should not find a board without DMI information")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "board_vendor"), []byte("AAEON\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "board_name"), []byte("UP-APL01\n"), 0644)
+	b := findX86Board()
+	if b == nil || b.name != "UP Squared" {
+		t.Errorf("findX86Board expected 'UP Squared', got %v", b)
+	}
+}
+
+func TestLibreComputerPinMap(t *testing.T) {
+	d := NewLibreComputerDriver()
+	d.createPinData(func(name string) (string, int) {
+		if name == "7J1 Header Pin7" {
+			return "/dev/gpiochip1", 98
+		}
+		return "", 0
+	})
+
+	if len(d.pinConfigs) != 41 {
+		t.Fatalf("Libre Computer pin map should have 40 header pins, has %d", len(d.pinConfigs)-1)
+	}
+
+	pin7 := d.pinConfigs[7]
+	if pin7.names[0] != "pin7" || pin7.names[1] != "gpio4" || !pin7.usedBy("gpio") {
+		t.Errorf("header pin 7 should be gpio, known as pin7 and gpio4, got %v %v", pin7.names, pin7.modules)
+	}
+	if pd := d.gpioLines[Pin(7)]; pd == nil || pd.chip != "/dev/gpiochip1" || pd.line != 98 {
+		t.Errorf("header pin 7 should be line 98 of /dev/gpiochip1, got %v", pd)
+	}
+	if !d.pinConfigs[11].usedBy("unrouted") {
+		t.Error("header pin 11 has no named line, so should be unrouted")
+	}
+	if !d.pinConfigs[3].usedBy("i2c") || !d.pinConfigs[6].usedBy("unassignable") {
+		t.Error("header pin 3 should be i2c and pin 6 ground")
+	}
+}
+
+func TestGPIOChipPinMap(t *testing.T) {
+	d := NewGPIOChipDriver()
+	d.chips = []gpioChipSummary{
+		{path: "/dev/gpiochip0", name: "gpiochip0", label: "pinctrl-bcm2711", lines: 58},
+		{path: "/dev/gpiochip1", name: "gpiochip1", label: "raspberrypi-exp-gpio", lines: 8},
+	}
+	e := SetDriver(d)
+	if e != nil {
+		t.Fatalf("SetDriver returned error '%s'", e)
+	}
+
+	if len(GetDefinedPins()) != 66 {
+		t.Errorf("expected a pin for each of the 66 lines, got %d", len(GetDefinedPins()))
+	}
+	p, e := GetPin("pinctrl-bcm2711.17")
+	if e != nil || p != 17 {
+		t.Errorf("GetPin by chip label and line should return 17, got %d, %v", p, e)
+	}
+	p, e = GetPin("gpiochip1.2")
+	if e != nil || p != 60 {
+		t.Errorf("GetPin by chip name and line should return 60, got %d, %v", p, e)
+	}
+	if pd := d.gpioLines[60]; pd.chip != "/dev/gpiochip1" || pd.line != 2 {
+		t.Errorf("pin 60 should be line 2 of /dev/gpiochip1, got %v", pd)
+	}
+	SetDriver(new(TestDriver))
+}
+
+func TestOrangePi5PinMap(t *testing.T) {
+	d := NewOrangePi5Driver()
+	d.createPinData([]int{2, 3})
+
+	if len(d.pinConfigs) != 29 {
+		t.Fatalf("Orange Pi 5 pin map should have 26 header pins and 2 analog pins, has %d", len(d.pinConfigs)-1)
+	}
+
+	pin7 := d.pinConfigs[7]
+	if pin7.names[0] != "pin7" || pin7.names[1] != "gpio1_c6" || !pin7.usedBy("gpio") || !pin7.usedBy("pwm") {
+		t.Errorf("header pin 7 should be gpio1_c6, usable for gpio and pwm, got %v %v", pin7.names, pin7.modules)
+	}
+	if !d.pinConfigs[3].usedBy("i2c") || !d.pinConfigs[12].usedBy("i2c1") {
+		t.Error("header pins 3 and 12 should be usable by the i2c buses")
+	}
+	if adc := d.pinConfigs[28]; adc.names[0] != "adc3" || !adc.usedBy("analog") || adc.analogLogical != 3 {
+		t.Errorf("last pin should be analog channel 3, got %v %v", adc.names, adc.modules)
+	}
+	if pins := d.getPWMOptions()["pins"].(SysfsPWMModulePinDefMap); len(pins) != 2 || pins[Pin(11)].controller != "febf0020.pwm" {
+		t.Errorf("PWM pins should be header pins 7 and 11, got %v", pins)
+	}
+}
+
+func TestSysfsPWM(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// two controllers, with the one the pin uses numbered second
+	fake := NewFakeSysfs()
+	other, chip := pwmSysfsPath+"/pwmchip0", pwmSysfsPath+"/pwmchip1"
+	fake.AddPWMChip(other)
+	fake.SetLink(other, "/sys/devices/platform/febf0020.pwm/pwm/pwmchip0")
+	fake.AddPWMChip(chip)
+	fake.SetLink(chip, "/sys/devices/platform/febf0030.pwm/pwm/pwmchip1")
+
+	pin, _ := GetPin("p7")
+	missing, _ := GetPin("p8")
+	pwm := NewSysfsPWMModule("pwm")
+	pwm.SetFilesystem(fake)
+	pwm.SetOptions(map[string]interface{}{"pins": SysfsPWMModulePinDefMap{
+		pin:     {pin: pin, controller: "febf0030.pwm"},
+		missing: {pin: missing, controller: "fe000000.pwm"},
+	}})
+
+	read := func(f string) string {
+		v, _ := fake.File(chip + "/pwm0/" + f)
+		return strings.TrimSpace(v)
+	}
+
+	access := pwm.RequiredAccess()
+	if len(access) != 2 || access[0].Path != chip+"/export" || access[1].Path != chip+"/unexport" {
+		t.Errorf("expected the export and unexport files of %s to be required, got %+v", chip, access)
+	}
+	if e := pwm.EnablePin(missing, true); e == nil {
+		t.Error("EnablePin on a controller that isn't enabled should return an error")
+	}
+
+	e := pwm.EnablePin(pin, true)
+	if e != nil {
+		t.Fatalf("EnablePin should not return an error, returned '%s'", e)
+	}
+	if j := fake.Journal(); len(j) == 0 || j[0] != `write `+chip+`/export "0"` {
+		t.Errorf("expected channel 0 of %s to be exported, got %v", chip, j)
+	}
+	if read("enable") != "0" {
+		t.Error("PWM channel should not be enabled until it has a period")
+	}
+
+	pwm.SetPeriod(pin, 1000000)
+	pwm.SetDuty(pin, 250000)
+	if read("enable") != "1" || read("period") != "1000000" || read("duty_cycle") != "250000" {
+		t.Errorf("PWM channel expected enabled with period 1000000 and duty 250000, got %s %s %s", read("enable"), read("period"), read("duty_cycle"))
+	}
+
+	pwm.SetPeriod(pin, 100000)
+	if read("duty_cycle") != "100000" {
+		t.Errorf("reducing the period below the duty should reduce the duty, got %s", read("duty_cycle"))
+	}
+	if pwm.SetDuty(pin, 200000) == nil {
+		t.Error("SetDuty longer than the period should return an error")
+	}
+
+	// frequency and duty cycle set independently
+	pwm.SetDutyCycle(pin, 0.25)
+	pwm.SetFrequency(pin, 1000)
+	if read("period") != "1000000" || read("duty_cycle") != "250000" {
+		t.Errorf("SetFrequency should keep the duty cycle, got period %s duty %s", read("period"), read("duty_cycle"))
+	}
+	pwm.SetFrequency(pin, 20000)
+	if read("period") != "50000" || read("duty_cycle") != "12500" {
+		t.Errorf("SetFrequency should keep the duty cycle, got period %s duty %s", read("period"), read("duty_cycle"))
+	}
+	if pwm.SetDutyCycle(pin, 1.5) == nil {
+		t.Error("SetDutyCycle above 1 should return an error")
+	}
+	if pwm.SetPhase(pin, 0) != nil || pwm.SetPhase(pin, 1000) == nil {
+		t.Error("sysfs PWM should accept only a phase of 0")
+	}
+
+	fake.SetFile(chip+"/pwm0/enable", "0")
+	if e := pwm.Sync(PinList{pin}); e != nil || read("enable") != "1" {
+		t.Errorf("Sync should start the channel, got enable %s and error %v", read("enable"), e)
+	}
+
+	pwm.Disable()
+	if _, ok := fake.File(chip + "/pwm0/enable"); ok {
+		t.Error("Disable should unexport the PWM channel")
+	}
+	if j := fake.Journal(); j[len(j)-2] != `write `+chip+`/pwm0/enable "0"` {
+		t.Errorf("Disable should stop the PWM channel before unexporting it, got %v", j[len(j)-2:])
+	}
+}
+
+func TestComplementaryPWM(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// the test PWM module has no dead band hardware
+	e := SetComplementaryPWM(8, 9, 1000)
+	if e == nil || !strings.Contains(e.Error(), "cannot generate complementary") {
+		t.Errorf("SetComplementaryPWM on a module without support should refuse, returned %v", e)
+	}
+	if SetComplementaryPWM(1, 2, 1000) == nil {
+		t.Error("SetComplementaryPWM on a pin without PWM should return an error")
+	}
+
+	// EHRPWM registers, with TBCTL dividing SYSCLKOUT by 4 (CLKDIV 2) * 2 (HSPCLKDIV 1) to 12.5MHz
+	regs := make([]byte, 0x40)
+	*ehrpwmRegister(regs, ehrpwmTBCTL) = 2<<10 | 1<<7
+	e = ehrpwmSetDeadBand(regs, 500)
+	if e != nil {
+		t.Fatalf("ehrpwmSetDeadBand returned an error: %s", e)
+	}
+	if red, fed := *ehrpwmRegister(regs, ehrpwmDBRED), *ehrpwmRegister(regs, ehrpwmDBFED); red != 7 || fed != 7 {
+		t.Errorf("500ns at 12.5MHz should round up to 7 clocks, got %d and %d", red, fed)
+	}
+	if *ehrpwmRegister(regs, ehrpwmDBCTL) != ehrpwmDBCTLComplementary {
+		t.Errorf("DBCTL should be set for complementary outputs, got %#x", *ehrpwmRegister(regs, ehrpwmDBCTL))
+	}
+	if ehrpwmSetDeadBand(regs, 100000) == nil {
+		t.Error("a dead time longer than the dead band counter should return an error")
+	}
+	ehrpwmClearDeadBand(regs)
+	if *ehrpwmRegister(regs, ehrpwmDBCTL) != 0 {
+		t.Error("ehrpwmClearDeadBand should bypass the dead band generator")
+	}
+
+	if ehrpwmOutput([]string{"P8.19", "gpmc_ad8", "gpio0_22", "ehrpwm2A"}) != "A" || ehrpwmOutput([]string{"P8.7"}) != "" {
+		t.Error("ehrpwmOutput should return the EHRPWM output from the pin names")
+	}
+}
+
+func TestDTSPI(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	spi := NewDTSPIModule("spi")
+	e := spi.SetOptions(map[string]interface{}{"bus": 99, "mode": 3, "pins": DTSPIModulePins{4, 5, 6}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an error: %s", e)
+	}
+	if e = spi.Write(0, []byte{1}); e == nil {
+		t.Error("Write on a module that isn't enabled should return an error")
+	}
+
+	e = spi.Enable()
+	if e != nil {
+		t.Fatalf("Enable returned an error: %s", e)
+	}
+	if assignedPins[4] == nil || assignedPins[6] == nil {
+		t.Error("Enable should assign the bus's pins")
+	}
+
+	e = spi.Transfer(0, []SPISegment{{Tx: []byte{1, 2}, Rx: make([]byte, 3)}})
+	if e == nil || !strings.Contains(e.Error(), "2 bytes to send but 3") {
+		t.Errorf("Transfer should reject a segment with different send and receive lengths, returned %v", e)
+	}
+	e = spi.Transfer(0, []SPISegment{{Tx: []byte{1}, DelayAfter: time.Second}})
+	if e == nil {
+		t.Error("Transfer should reject a delay longer than spidev supports")
+	}
+	if e = spi.Transfer(0, make([]SPISegment, spiMaxSegments+1)); e == nil {
+		t.Error("Transfer should reject more segments than fit in one ioctl")
+	}
+	e = spi.Write(0, []byte{1})
+	if e == nil || !strings.Contains(e.Error(), "/dev/spidev99.0") {
+		t.Errorf("Write to a bus that doesn't exist should fail to open its device, returned %v", e)
+	}
+
+	if tr, e := spi.makeTransfer(SPISegment{Rx: make([]byte, 4), SpeedHz: 1000000, CSChange: true, DelayAfter: 10 * time.Microsecond}); e != nil ||
+		tr.length != 4 || tr.txBuf != 0 || tr.rxBuf == 0 || tr.speedHz != 1000000 || tr.csChange != 1 || tr.delayUsecs != 10 {
+		t.Errorf("makeTransfer did not convert a receive segment correctly, got %+v, error %v", tr, e)
+	}
+	if size := unsafe.Sizeof(spiIocTransfer{}); size != 32 {
+		t.Errorf("spiIocTransfer should match the kernel's 32 byte struct, is %d bytes", size)
+	}
+
+	spi.Disable()
+	if assignedPins[4] != nil {
+		t.Error("Disable should release the bus's pins")
+	}
+}
+
+func TestSPISlave(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-spislave")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedPath := spiSlaveSysfsPath
+	spiSlaveSysfsPath = dir
+	defer func() {
+		spiSlaveSysfsPath = savedPath
+	}()
+
+	// a controller with no protocol driver bound, and a device file standing in for spidev
+	os.MkdirAll(filepath.Join(dir, "spi1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "spi1", "slave"), []byte("(null)\n"), 0644)
+	device := filepath.Join(dir, "spidev1.0")
+	ioutil.WriteFile(device, []byte{0x12, 0x34}, 0644)
+
+	slave := NewDTSPISlaveModule("spislave")
+	e = slave.SetOptions(map[string]interface{}{"device": device, "controller": "spi1", "pins": DTSPISlaveModulePins{4, 5}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an error: %s", e)
+	}
+
+	e = slave.Enable()
+	if e != nil {
+		t.Fatalf("Enable returned an error: %s", e)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "spi1", "slave")); string(b) != "spidev" {
+		t.Errorf("Enable should bind spidev to the controller, slave file has '%s'", b)
+	}
+	if assignedPins[4] == nil || assignedPins[5] == nil {
+		t.Error("Enable should assign the slave's pins")
+	}
+
+	data := make([]byte, 2)
+	n, e := slave.Receive(data)
+	if e != nil || n != 2 || data[0] != 0x12 || data[1] != 0x34 {
+		t.Errorf("Receive expected 0x12 0x34, got %d bytes % x, error %v", n, data[:n], e)
+	}
+
+	slave.Disable()
+	if assignedPins[4] != nil || assignedPins[5] != nil {
+		t.Error("Disable should release the slave's pins")
+	}
+	if _, e = slave.Receive(data); e == nil {
+		t.Error("Receive on a disabled module should return an error")
+	}
+
+	// a controller bound to another protocol driver is left alone
+	ioutil.WriteFile(filepath.Join(dir, "spi1", "slave"), []byte("spi-slave-time\n"), 0644)
+	if e = slave.Enable(); e == nil || !strings.Contains(e.Error(), "spi-slave-time") {
+		t.Errorf("Enable should refuse a controller in use by another driver, returned %v", e)
+	}
+}
+
+func TestBeagleBoneSPISlave(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	d := NewBeagleboneBlackDTDriver()
+	d.createPinData()
+	if e := d.initialiseModules(); e != nil {
+		t.Fatalf("initialiseModules returned an error: %s", e)
+	}
+	defer d.Close()
+
+	slave, ok := d.GetModules()["spislave"].(*DTSPISlaveModule)
+	if !ok {
+		t.Fatal("the BeagleBone driver should have an spislave module")
+	}
+	if slave.controller != "spi0" || slave.deviceFile != "/dev/spidev0.0" {
+		t.Errorf("expected the slave on spi0 as /dev/spidev0.0, got %s as %s", slave.controller, slave.deviceFile)
+	}
+	var names []string
+	for _, pin := range slave.definedPins {
+		names = append(names, d.beaglePins[pin].names[0])
+	}
+	if !reflect.DeepEqual(names, []string{"P9.17", "P9.18", "P9.21", "P9.22"}) {
+		t.Errorf("expected the slave on the McSPI0 pins, got %v", names)
+	}
+}
+
+// Open a pseudo-terminal, returning the master and the path of the slave, which stands in for a UART.
+func openTestPty(t *testing.T) (*os.File, string) {
+	master, e := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if e != nil {
+		t.Skipf("could not open /dev/ptmx: %s", e)
+	}
+
+	unlock := 0
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		master.Close()
+		t.Skipf("could not unlock pty: %s", errno)
+	}
+	var n uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		master.Close()
+		t.Skipf("could not get pty number: %s", errno)
+	}
+	return master, "/dev/pts/" + strconv.Itoa(int(n))
+}
+
+func TestTTYSerial(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	master, device := openTestPty(t)
+	defer master.Close()
+
+	serial := NewTTYSerialModule("serial")
+	e := serial.SetOptions(map[string]interface{}{"device": device, "baud": 250000, "pins": TTYSerialModulePins{4, 5}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an error: %s", e)
+	}
+	e = serial.Enable()
+	if e != nil {
+		t.Fatalf("Enable returned an error: %s", e)
+	}
+	defer serial.Disable()
+	if assignedPins[4] == nil || assignedPins[5] == nil {
+		t.Error("Enable should assign the serial port's pins")
+	}
+
+	master.Write([]byte("hello"))
+	data := make([]byte, 16)
+	n, e := serial.Read(data)
+	if e != nil || string(data[:n]) != "hello" {
+		t.Errorf("Read expected 'hello', got '%s', error %v", data[:n], e)
+	}
+
+	serial.Write([]byte("abc"))
+	n, e = master.Read(data)
+	if e != nil || string(data[:n]) != "abc" {
+		t.Errorf("master expected 'abc', got '%s', error %v", data[:n], e)
+	}
+
+	// a pty has no kernel RS-485 support, so only a direction pin can be used
+	if e = serial.SetRS485(&RS485Config{}); e == nil {
+		t.Error("SetRS485 without a direction pin should fail on a tty without kernel RS-485 support")
+	}
+
+	e = serial.SetRS485(&RS485Config{DirectionPin: 2, UseDirectionPin: true, RxDuringTx: true})
+	if e != nil {
+		t.Fatalf("SetRS485 with a direction pin returned an error: %s", e)
+	}
+	gpio := getMockGPIO(t)
+	if gpio.MockGetPinMode(2) != Output || gpio.MockGetPinValue(2) != Low {
+		t.Error("SetRS485 should set the direction pin to output, low")
+	}
+
+	start := time.Now()
+	n, e = serial.Write(make([]byte, 250))
+	if e != nil || n != 250 {
+		t.Errorf("Write in RS-485 mode should write 250 bytes, wrote %d, error %v", n, e)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("Write in RS-485 mode should wait for 250 bytes to be sent at 250000 baud, returned after %s", elapsed)
+	}
+	if gpio.MockGetPinValue(2) != Low {
+		t.Error("the direction pin should be low again after Write")
+	}
+
+	if e = serial.SetRS485(nil); e != nil {
+		t.Errorf("SetRS485(nil) returned an error: %s", e)
+	}
+
+	// the direction pin is held for the whole frame, including the parity and stop bits
+	framed := NewTTYSerialModule("framed")
+	for _, c := range []struct {
+		options map[string]interface{}
+		bits    int64
+	}{
+		{map[string]interface{}{}, 10},
+		{map[string]interface{}{"parity": "even"}, 11},
+		{map[string]interface{}{"parity": "odd", "stopBits": 2}, 12},
+		{map[string]interface{}{"dataBits": 7, "parity": "none", "stopBits": 1}, 9},
+	} {
+		c.options["device"] = device
+		c.options["baud"] = 1000
+		if e := framed.SetOptions(c.options); e != nil {
+			t.Fatalf("SetOptions(%v) returned an error: %s", c.options, e)
+		}
+		if bt := framed.byteTime(); bt != time.Duration(c.bits)*time.Millisecond {
+			t.Errorf("expected %d bits of 1ms per character with %v, got %s", c.bits, c.options, bt)
+		}
+	}
+	if e := framed.SetOptions(map[string]interface{}{"device": device, "dataBits": 9}); e == nil {
+		t.Error("SetOptions should reject 9 data bits")
+	}
+	if e := framed.SetOptions(map[string]interface{}{"device": device, "stopBits": 3}); e == nil {
+		t.Error("SetOptions should reject 3 stop bits")
+	}
+}
+
+func TestReadFrames(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	master, device := openTestPty(t)
+	defer master.Close()
+
+	serial := NewTTYSerialModule("serial")
+	serial.SetOptions(map[string]interface{}{"device": device, "baud": 9600})
+	e := serial.Enable()
+	if e != nil {
+		t.Fatalf("Enable returned an error: %s", e)
+	}
+	defer serial.Disable()
+
+	if _, e = ReadFrames(serial, SerialFraming{}); e == nil {
+		t.Error("ReadFrames without a way to end frames should return an error")
+	}
+
+	expectFrame := func(r *SerialFrameReader, expected string) {
+		select {
+		case f, ok := <-r.Frames:
+			if !ok || string(f.Data) != expected {
+				t.Errorf("expected frame '%s', got '%s'", expected, f.Data)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for frame '%s'", expected)
+		}
+	}
+
+	// delimited frames, split across writes
+	r, e := ReadFrames(serial, SerialFraming{Delimiter: []byte("\r\n"), MaxLength: 10})
+	if e != nil {
+		t.Fatalf("ReadFrames returned an error: %s", e)
+	}
+	master.Write([]byte("$GPGGA,1\r\n$GP"))
+	expectFrame(r, "$GPGGA,1")
+	master.Write([]byte("RMC\r\n0123456789ab"))
+	expectFrame(r, "$GPRMC")
+	expectFrame(r, "0123456789")
+	r.Stop()
+	if _, ok := <-r.Frames; ok {
+		t.Error("Frames should be closed after Stop")
+	}
+
+	// frames ended by the line going idle, or at a fixed length
+	r, _ = ReadFrames(serial, SerialFraming{Idle: 20 * time.Millisecond, Length: 4})
+	master.Write([]byte("\x01\x03"))
+	time.Sleep(5 * time.Millisecond)
+	master.Write([]byte("\x02"))
+	expectFrame(r, "\x01\x03\x02")
+	master.Write([]byte("abcdef"))
+	expectFrame(r, "abcd")
+	expectFrame(r, "ef")
+	r.Stop()
+	if r.Err() != nil {
+		t.Errorf("Err should be nil after Stop, got %s", r.Err())
+	}
+
+	// the port can be read directly again once the reader is stopped
+	master.Write([]byte("x"))
+	data := make([]byte, 4)
+	if n, e := serial.Read(data); e != nil || string(data[:n]) != "x" {
+		t.Errorf("Read after Stop expected 'x', got '%s', error %v", data[:n], e)
+	}
+
+	if d := ModbusRTUIdle(9600); d < 3600*time.Microsecond || d > 3700*time.Microsecond {
+		t.Errorf("ModbusRTUIdle(9600) should be about 3.65ms, got %s", d)
+	}
+}
+
+func TestSoftSerial(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	gpio.MockConnect(2, 3)
+
+	serial := NewSoftSerialModule("softserial")
+	if e := serial.SetOptions(map[string]interface{}{"baud": 9600}); e == nil {
+		t.Error("software serial without pins should return an error")
+	}
+	if e := serial.SetOptions(map[string]interface{}{"tx": Pin(2), "baud": 115200}); e == nil {
+		t.Error("software serial should not accept 115200 baud")
+	}
+	if e := serial.SetOptions(map[string]interface{}{"tx": Pin(2), "baud": 9600}); e != nil {
+		t.Fatalf("SetOptions should not return an error, returned '%s'", e)
+	}
+	if e := serial.Enable(); e != nil {
+		t.Fatalf("Enable should not return an error, returned '%s'", e)
+	}
+	defer serial.Disable()
+	if gpio.MockGetPinValue(2) != High {
+		t.Error("software serial transmit pin should idle high")
+	}
+
+	// the order of the edges of what is sent, as the timing depends on the machine running the test
+	PinMode(3, Input)
+	edges, _ := WatchEdges(3, EdgeBoth)
+	if n, e := serial.Write([]byte{0x68}); e != nil || n != 1 {
+		t.Fatalf("Write should send 1 byte, sent %d, %v", n, e)
+	}
+	expected := []bool{false, true, false, true, false, true}
+	for i, rising := range expected {
+		if len(edges) == 0 || (<-edges).Rising != rising {
+			t.Fatalf("software serial sent the wrong edges for 0x68 at edge %d", i)
+		}
+	}
+	if len(edges) != 0 {
+		t.Errorf("software serial sent %d more edges than expected", len(edges))
+	}
+	StopWatchingEdges(3)
+
+	// receive bytes from edges with exact timestamps, in the past so each byte is complete
+	serial.useRx = true
+	serial.wake = make(chan bool, 1)
+	serial.stopped = make(chan bool)
+	received := make(chan EdgeEvent, 64)
+	go serial.receive(received, serial.stopped)
+
+	sent := []byte{'h', 'i', 0x00, 0xff, 0x55}
+	bitTime := time.Second / 9600
+	t0 := MonotonicNow() - time.Second
+	level := true
+	seq := uint32(0)
+	for n, b := range sent {
+		frame := uint(b)<<1 | 1<<9
+		for i := uint(0); i < 10; i++ {
+			bit := frame&(1<<i) != 0
+			if bit != level {
+				seq++
+				received <- EdgeEvent{Pin: 3, Rising: bit, Timestamp: t0 + time.Duration(n*10+int(i))*bitTime, Seq: seq}
+				level = bit
+			}
+		}
+	}
+
+	result := make([]byte, 0)
+	serial.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 16)
+	for len(result) < len(sent) {
+		n, e := serial.Read(buffer)
+		if e != nil {
+			t.Fatalf("Read returned '%s' after %q", e, result)
+		}
+		result = append(result, buffer[:n]...)
+	}
+	if string(result) != string(sent) {
+		t.Errorf("software serial expected %q, got %q", sent, result)
+	}
+	if serial.Errors() != 0 {
+		t.Errorf("software serial expected no errors, got %d", serial.Errors())
+	}
+
+	serial.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, e := serial.Read(buffer); e != os.ErrDeadlineExceeded {
+		t.Errorf("Read past the deadline should return os.ErrDeadlineExceeded, returned %v", e)
+	}
+	close(received)
+	<-serial.stopped
+	serial.useRx = false
+}
+
+func TestSoftSerialParity(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	serial := NewSoftSerialModule("softserial")
+	if e := serial.SetOptions(map[string]interface{}{"tx": Pin(2), "pin": Pin(3)}); e == nil {
+		t.Error("software serial should not accept 'pin' with 'tx'")
+	}
+	serial = NewSoftSerialModule("softserial")
+	if e := serial.SetOptions(map[string]interface{}{"tx": Pin(2), "parity": "mark"}); e == nil {
+		t.Error("software serial should not accept mark parity")
+	}
+	serial = NewSoftSerialModule("softserial")
+	if e := serial.SetOptions(map[string]interface{}{"tx": Pin(2), "baud": 1200, "dataBits": 7, "parity": "even"}); e != nil {
+		t.Fatalf("SetOptions should not return an error, returned '%s'", e)
+	}
+	if serial.addParity('a') != 0x61|1<<7 || serial.addParity('0') != 0x30 {
+		t.Errorf("software serial even parity is wrong, got %x and %x", serial.addParity('a'), serial.addParity('0'))
+	}
+
+	serial.useRx = true
+	serial.enabled = true
+	serial.wake = make(chan bool, 1)
+	serial.stopped = make(chan bool)
+	received := make(chan EdgeEvent, 64)
+	go serial.receive(received, serial.stopped)
+
+	// the last byte has the wrong parity, so is counted as an error
+	frames := []uint{serial.addParity('a'), serial.addParity('0'), serial.addParity('0') ^ 1<<7}
+	bitTime := time.Second / 1200
+	t0 := MonotonicNow() - time.Second
+	level := true
+	seq := uint32(0)
+	for n, bits := range frames {
+		frame := bits<<1 | 1<<9
+		for i := uint(0); i < 10; i++ {
+			bit := frame&(1<<i) != 0
+			if bit != level {
+				seq++
+				received <- EdgeEvent{Pin: 3, Rising: bit, Timestamp: t0 + time.Duration(n*10+int(i))*bitTime, Seq: seq}
+				level = bit
+			}
+		}
+	}
+
+	result := make([]byte, 0)
+	serial.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 16)
+	for len(result) < 2 {
+		n, e := serial.Read(buffer)
+		if e != nil {
+			t.Fatalf("Read returned '%s' after %q", e, result)
+		}
+		result = append(result, buffer[:n]...)
+	}
+	if string(result) != "a0" {
+		t.Errorf("software serial expected \"a0\", got %q", result)
+	}
+	for wait := time.Now().Add(time.Second); serial.Errors() == 0 && time.Now().Before(wait); {
+		time.Sleep(time.Millisecond)
+	}
+	close(received)
+	<-serial.stopped
+	if serial.Errors() != 1 {
+		t.Errorf("software serial expected 1 parity error, got %d", serial.Errors())
+	}
+}
+
+func TestCurrentLoop(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// a 250 ohm shunt, read in millivolts, for a 0-10 bar transmitter
+	loop := CurrentLoop{Pin: 11, ShuntOhms: 250, VoltsPerCount: 0.001, Min: 0, Max: 10}
+	tests := []struct {
+		raw   int
+		value float64
+		fault CurrentLoopFault
+	}{
+		{0, -2.5, LoopOpen},
+		{925, -0.1875, LoopUnderRange},
+		{1000, 0, LoopOK},
+		{3000, 5, LoopOK},
+		{5000, 10, LoopOK},
+		{5200, 10.5, LoopOverRange},
+		{6000, 12.5, LoopShorted},
+	}
+	for _, test := range tests {
+		r := loop.Convert(test.raw)
+		if math.Abs(r.Value-test.value) > 1e-9 || r.Fault != test.fault {
+			t.Errorf("current loop reading %d expected %v %s, got %v %s", test.raw, test.value, test.fault, r.Value, r.Fault)
+		}
+		if r.Valid() != (test.fault != LoopOpen && test.fault != LoopShorted) {
+			t.Errorf("current loop reading %d with fault %s should have Valid %v", test.raw, r.Fault, !r.Valid())
+		}
+	}
+
+	r, e := loop.Read()
+	if e != nil || r.MilliAmps != 4 {
+		t.Errorf("current loop Read expected 4mA, got %v, %v", r.MilliAmps, e)
+	}
+	if _, e = (CurrentLoop{Pin: 11}).Read(); e == nil {
+		t.Error("current loop Read without a shunt resistance should return an error")
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	gpio.MockConnect(2, 3)
+	PinMode(2, Output)
+	PinMode(4, Output)
+	PinMode(3, Input)
+	edges, _ := WatchEdges(3, EdgeRising)
+	defer StopWatchingEdges(3)
+
+	if _, e := NewSequencer(StepRepeat(0, StepSet(2, High), StepSet(2, Low))); e == nil {
+		t.Error("a sequence that repeats forever without waiting should be an error")
+	}
+	if _, e := NewSequencer(StepWait(-time.Second)); e == nil {
+		t.Error("a sequence with a negative wait should be an error")
+	}
+
+	seq, e := NewSequencer(
+		StepSet(4, High),
+		StepRepeat(3, StepPulse(2, High, 5*time.Millisecond), StepWait(5*time.Millisecond)),
+		StepSet(4, Low),
+	)
+	if e != nil {
+		t.Fatalf("NewSequencer should not return an error, returned '%s'", e)
+	}
+	start := time.Now()
+	if e = seq.Start(); e != nil {
+		t.Fatalf("Start should not return an error, returned '%s'", e)
+	}
+	if e = seq.Start(); e == nil {
+		t.Error("starting a running sequence should return an error")
+	}
+	if e = seq.Wait(); e != nil {
+		t.Errorf("Wait should not return an error, returned '%s'", e)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("sequence should take at least 30ms, took %s", elapsed)
+	}
+	if len(edges) != 3 {
+		t.Errorf("sequence should pulse 3 times, pulsed %d", len(edges))
+	}
+	if gpio.MockGetPinValue(2) != Low || gpio.MockGetPinValue(4) != Low {
+		t.Error("sequence should leave pins low")
+	}
+
+	// a sequence that repeats forever runs until it is stopped
+	seq, _ = NewSequencer(StepRepeat(0, StepSet(4, High), StepWait(time.Millisecond), StepSet(4, Low), StepWait(time.Millisecond)))
+	seq.Start()
+	time.Sleep(10 * time.Millisecond)
+	if !seq.Running() {
+		t.Error("sequence that repeats forever should still be running")
+	}
+	if e = seq.Stop(); e != nil || seq.Running() {
+		t.Errorf("Stop should stop the sequence, returned %v", e)
+	}
+
+	// an error writing a pin stops the sequence
+	seq, _ = NewSequencer(StepSet(9, High))
+	seq.Start()
+	if e = seq.Wait(); e == nil {
+		t.Error("a sequence writing a pin without a mode set should return an error")
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	analog, _ := GetModule("analog")
+	mockAnalog := analog.(*testAnalogModule)
+	mockAnalog.MockSetAnalogValue(12, 500)
+
+	if _, e := NewRuleEngine(Rule{When: 3, Edge: EdgeRising}); e == nil {
+		t.Error("a rule without an action should be an error")
+	}
+
+	engine, e := NewRuleEngine(
+		Rule{When: 3, Edge: EdgeRising, Set: 4, UseSet: true, Value: High, For: 100 * time.Millisecond, Publish: "pressed"},
+		Rule{When: 3, Edge: EdgeFalling, Publish: "released"},
+		Rule{When: 12, Analog: true, Edge: EdgeBoth, Threshold: 1000, Hysteresis: 100, Interval: time.Millisecond, Publish: "level"},
+	)
+	if e != nil {
+		t.Fatalf("NewRuleEngine should not return an error, returned '%s'", e)
+	}
+	defer engine.Close()
+
+	next := func() RuleEvent {
+		select {
+		case event := <-engine.Events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("rule engine did not publish an event")
+		}
+		return RuleEvent{}
+	}
+
+	// the output is set before the event is published
+	gpio.MockSetPinValue(3, High)
+	if event := next(); event.Name != "pressed" || !event.Rising || event.Pin != 3 {
+		t.Errorf("rule engine expected a rising 'pressed' event, got %+v", event)
+	}
+	if gpio.MockGetPinValue(4) != High {
+		t.Error("rule should set pin 4 high")
+	}
+	engine.Err() // orders the read above before the timer's write, which is under the same lock
+
+	// the output is set back after its time; Err takes the lock the timer writes under
+	time.Sleep(300 * time.Millisecond)
+	if engine.Err() != nil || gpio.MockGetPinValue(4) != Low {
+		t.Errorf("rule should set pin 4 back low after 100ms, error %v", engine.Err())
+	}
+	gpio.MockSetPinValue(3, Low)
+	if event := next(); event.Name != "released" || event.Rising {
+		t.Errorf("rule engine expected a falling 'released' event, got %+v", event)
+	}
+
+	// analog crossings, with hysteresis
+	mockAnalog.MockSetAnalogValue(12, 1001)
+	if event := next(); event.Name != "level" || !event.Rising || event.Value != 1001 {
+		t.Errorf("rule engine expected a rising 'level' event at 1001, got %+v", event)
+	}
+	mockAnalog.MockSetAnalogValue(12, 950)
+	time.Sleep(20 * time.Millisecond)
+	mockAnalog.MockSetAnalogValue(12, 899)
+	if event := next(); event.Name != "level" || event.Rising || event.Value != 899 {
+		t.Errorf("rule engine expected a falling 'level' event at 899, got %+v", event)
+	}
+
+	engine.Close()
+	if _, ok := <-engine.Events; ok {
+		t.Error("Close should close the Events channel")
+	}
+}
+
+func TestWatchAnalog(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	analog, _ := GetModule("analog")
+	mockAnalog := analog.(*testAnalogModule)
+	mockAnalog.MockSetAnalogValue(12, 1200)
+
+	if _, e := WatchAnalog(12, 1000, 50, 0); e == nil {
+		t.Error("WatchAnalog without an interval should return an error")
+	}
+	events, e := WatchAnalog(12, 1000, 50, time.Millisecond)
+	if e != nil {
+		t.Fatalf("WatchAnalog should not return an error, returned '%s'", e)
+	}
+	if _, e = WatchAnalog(12, 1000, 50, time.Millisecond); e == nil {
+		t.Error("watching a pin twice should return an error")
+	}
+
+	next := func() AnalogEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("WatchAnalog did not send an event")
+		}
+		return AnalogEvent{}
+	}
+
+	// the first event says which side the value starts on
+	if event := next(); !event.Rising || event.Value != 1200 || event.Pin != 12 {
+		t.Errorf("WatchAnalog expected a first event above the threshold at 1200, got %+v", event)
+	}
+
+	// within the hysteresis band nothing happens, below it is a falling crossing
+	mockAnalog.MockSetAnalogValue(12, 960)
+	time.Sleep(20 * time.Millisecond)
+	mockAnalog.MockSetAnalogValue(12, 940)
+	if event := next(); event.Rising || event.Value != 940 {
+		t.Errorf("WatchAnalog expected a falling event at 940, got %+v", event)
+	}
+	mockAnalog.MockSetAnalogValue(12, 1001)
+	if event := next(); !event.Rising || event.Value != 1001 {
+		t.Errorf("WatchAnalog expected a rising event at 1001, got %+v", event)
+	}
+
+	if e = StopWatchingAnalog(12); e != nil {
+		t.Errorf("StopWatchingAnalog should not return an error, returned '%s'", e)
+	}
+	if _, ok := <-events; ok {
+		t.Error("StopWatchingAnalog should close the events channel")
+	}
+	if e = StopWatchingAnalog(12); e == nil {
+		t.Error("StopWatchingAnalog on a pin not being watched should return an error")
+	}
+
+	// samples are taken, and events timed, on the package's clock
+	start := time.Now()
+	fake := NewFakeClock(start)
+	SetClock(fake)
+	defer SetClock(nil)
+	events, _ = WatchAnalog(12, 1000, 50, time.Minute)
+	defer StopWatchingAnalog(12)
+	if event := next(); !event.Time.Equal(start) {
+		t.Errorf("expected the first event at the fake clock's time, got %s", event.Time)
+	}
+	mockAnalog.MockSetAnalogValue(12, 900)
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	if event := next(); event.Rising || !event.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected a falling event a minute on, got %+v", event)
+	}
+}
+
+func TestAnalogReadMulti(t *testing.T) {
+	SetDriver(new(TestDriver))
+	m, _ := GetAnalogModule()
+	m.(*testAnalogModule).MockSetAnalogValue(10, 500)
+	m.(*testAnalogModule).MockSetAnalogValue(11, 700)
+
+	if _, e := AnalogReadMulti(); e == nil {
+		t.Error("AnalogReadMulti without pins should return an error")
+	}
+	samples, e := AnalogReadMulti(11, 10)
+	if e != nil {
+		t.Fatalf("AnalogReadMulti returned error '%s'", e)
+	}
+	if len(samples) != 2 || samples[0].Pin != 11 || samples[0].Value != 700 || samples[1].Pin != 10 || samples[1].Value != 500 {
+		t.Errorf("AnalogReadMulti returned %+v", samples)
+	}
+	if samples[0].Timestamp == 0 || samples[1].Timestamp < samples[0].Timestamp {
+		t.Errorf("expected samples read in turn to be timestamped in order, got %+v", samples)
+	}
+
+	// an IIO device that scans a 12-bit unsigned channel, a 12-bit signed one stored big-endian 4 bits up, and a
+	// timestamp
+	dir, e := ioutil.TempDir("", "hwio-iio")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	savedPath, savedDev := iioDevicesPath, iioCharDevicesPath
+	iioDevicesPath, iioCharDevicesPath = filepath.Join(dir, "iio"), filepath.Join(dir, "dev")
+	defer func() { iioDevicesPath, iioCharDevicesPath = savedPath, savedDev }()
+	device := filepath.Join(iioDevicesPath, "iio:device0")
+	files := map[string]string{
+		"name":                             "saradc\n",
+		"in_voltage0_raw":                  "291\n",
+		"in_voltage1_raw":                  "-5\n",
+		"current_timestamp_clock":          "realtime\n",
+		"buffer/enable":                    "0\n",
+		"scan_elements/in_voltage0_en":     "0\n",
+		"scan_elements/in_voltage0_index":  "0\n",
+		"scan_elements/in_voltage0_type":   "le:u12/16>>0\n",
+		"scan_elements/in_voltage1_en":     "1\n",
+		"scan_elements/in_voltage1_index":  "1\n",
+		"scan_elements/in_voltage1_type":   "be:s12/16>>4\n",
+		"scan_elements/in_voltage2_en":     "1\n",
+		"scan_elements/in_voltage2_index":  "2\n",
+		"scan_elements/in_voltage2_type":   "le:u12/16>>0\n",
+		"scan_elements/in_timestamp_en":    "0\n",
+		"scan_elements/in_timestamp_index": "3\n",
+		"scan_elements/in_timestamp_type":  "le:s64/64>>0\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(device, name)), 0755)
+		ioutil.WriteFile(filepath.Join(device, name), []byte(content), 0644)
+	}
+	os.Mkdir(iioCharDevicesPath, 0755)
+	scan := []byte{0x23, 0x01, 0xff, 0xb0, 0, 0, 0, 0, 0x15, 0xcd, 0x5b, 0x07, 0, 0, 0, 0}
+	ioutil.WriteFile(filepath.Join(iioCharDevicesPath, "iio:device0"), scan, 0644)
+
+	analog := NewIIOAnalogModule("analog")
+	analog.SetOptions(map[string]interface{}{"device": "saradc", "pins": IIOAnalogModulePinDefMap{
+		20: {pin: 20, channel: 0},
+		21: {pin: 21, channel: 1},
+	}})
+	if e = analog.Enable(); e != nil {
+		t.Fatal(e)
+	}
+	defer analog.Disable()
+	samples, e = analog.AnalogReadMulti(PinList{21, 20})
+	if e != nil {
+		t.Fatalf("AnalogReadMulti returned error '%s'", e)
+	}
+	expected := []AnalogSample{{Pin: 21, Value: -5, Timestamp: 123456789}, {Pin: 20, Value: 291, Timestamp: 123456789}}
+	if len(samples) != 2 || samples[0] != expected[0] || samples[1] != expected[1] {
+		t.Errorf("expected a scan of %+v, got %+v", expected, samples)
+	}
+	for name, content := range map[string]string{
+		"buffer/enable":                "0",
+		"current_timestamp_clock":      "realtime",
+		"scan_elements/in_voltage0_en": "0",
+		"scan_elements/in_voltage2_en": "1",
+	} {
+		if data, _ := ioutil.ReadFile(filepath.Join(device, name)); strings.TrimSpace(string(data)) != content {
+			t.Errorf("expected %s to be put back to %s after the scan, got '%s'", name, content, data)
+		}
+	}
+
+	// without a trigger the channels are read in turn
+	os.Mkdir(filepath.Join(device, "trigger"), 0755)
+	ioutil.WriteFile(filepath.Join(device, "trigger", "current_trigger"), nil, 0644)
+	analog.Enable()
+	samples, e = analog.AnalogReadMulti(PinList{20, 21})
+	if e != nil || len(samples) != 2 || samples[0].Value != 291 || samples[1].Value != -5 || samples[0].Timestamp == 123456789 {
+		t.Errorf("expected the channels to be read in turn without a trigger, got %+v, '%v'", samples, e)
+	}
+}
+
+func TestAnalogMux(t *testing.T) {
+	SetDriver(new(TestDriver))
+	m, _ := GetGPIOModule()
+	gpio := m.(*testGPIOModule)
+
+	// the input reads 100 times the channel the select pins choose
+	SimulatePin(10, PinSimulation{Read: func(time.Duration) int {
+		channel := 0
+		for i := 0; i < 4; i++ {
+			channel |= gpio.MockGetPinValue(Pin(i)) << uint(i)
+		}
+		return 100 * channel
+	}})
+	defer StopSimulatingPin(10)
+
+	if _, e := NewAnalogMux("mux", AnalogMuxConfig{Select: PinList{0, 1, 2, 3, 5}, Input: 10}); e == nil {
+		t.Error("NewAnalogMux with 5 select pins should return an error")
+	}
+	settle := 2 * time.Millisecond
+	mux, e := NewAnalogMux("mux", AnalogMuxConfig{Select: PinList{0, 1, 2, 3}, Input: 10, Enable: 4, UseEnable: true, Settle: settle})
+	if e != nil {
+		t.Fatalf("NewAnalogMux returned error '%s'", e)
+	}
+	if _, e = NewAnalogMux("mux", AnalogMuxConfig{Select: PinList{5, 6, 7}, Input: 11}); e == nil {
+		t.Error("NewAnalogMux should not register a second module called mux")
+	}
+	if len(mux.Pins()) != 16 {
+		t.Fatalf("expected 16 channels, got %d", len(mux.Pins()))
+	}
+	if gpio.MockGetPinValue(4) != Low {
+		t.Error("expected the enable pin to be low")
+	}
+
+	for channel := 15; channel >= 0; channel-- {
+		pin, e := GetPin(fmt.Sprintf("mux.C%d", channel))
+		if e != nil || pin != mux.Pins()[channel] {
+			t.Fatalf("GetPin of channel %d returned %d, '%v', expected %d", channel, pin, e, mux.Pins()[channel])
+		}
+		if value, e := AnalogRead(pin); e != nil || value != 100*channel {
+			t.Errorf("AnalogRead of channel %d returned %d, '%v', expected %d", channel, value, e, 100*channel)
+		}
+	}
+
+	// changing channel waits for the input to settle
+	pin, _ := mux.Channel(9)
+	start := time.Now()
+	AnalogRead(pin)
+	if elapsed := time.Since(start); elapsed < settle {
+		t.Errorf("expected changing channel to wait %s to settle, took %s", settle, elapsed)
+	}
+	if _, e = mux.Channel(16); e == nil {
+		t.Error("Channel 16 should return an error")
+	}
+
+	if e = mux.Close(); e != nil {
+		t.Errorf("Close returned error '%s'", e)
+	}
+	if _, e = GetPin("mux.C3"); e == nil {
+		t.Error("the channels' pins should be removed by Close")
+	}
+	if m, _ := GetModule("mux"); m != nil {
+		t.Error("the mux should be unregistered by Close")
+	}
+	if gpio.MockGetPinValue(4) != High {
+		t.Error("expected Close to disable the mux")
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-rules")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{"rules": [
+		{"when": "P3", "edge": "rising", "set": "P4", "value": "high", "for": "5s"},
+		{"when": "ain6", "analog": true, "edge": "falling", "threshold": 1150, "hysteresis": 20, "publish": "low"}
+	]}`), 0644)
+
+	rules, e := LoadRules(path)
+	if e != nil {
+		t.Fatalf("LoadRules should not return an error, returned '%s'", e)
+	}
+	expected := []Rule{
+		{When: 2, Edge: EdgeRising, Set: 3, UseSet: true, Value: High, For: 5 * time.Second},
+		{When: 11, Analog: true, Edge: EdgeFalling, Threshold: 1150, Hysteresis: 20, Interval: defaultRuleInterval, Publish: "low"},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("LoadRules expected %d rules, got %d", len(expected), len(rules))
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Errorf("LoadRules rule %d expected %+v, got %+v", i, expected[i], rules[i])
+		}
+	}
+
+	ioutil.WriteFile(path, []byte(`{"rules": [{"when": "P3", "edge": "up", "publish": "x"}]}`), 0644)
+	if _, e = LoadRules(path); e == nil {
+		t.Error("LoadRules should return an error for an unknown edge")
+	}
+}
+
+func TestResistiveSensors(t *testing.T) {
+	SetDriver(new(TestDriver))
+	m, _ := GetAnalogModule()
+	mock := m.(*testAnalogModule)
+
+	// a 10 bit ADC whose reference supplies the divider
+	divider := Divider{Pin: 10, VoltsPerCount: 3.3 / 1024, SupplyVolts: 3.3, FixedOhms: 10000}
+	if r, e := divider.Resistance(256); e != nil || math.Abs(r-10000.0/3) > 1e-6 {
+		t.Errorf("divider reading 256 should be 3333 ohms, got %v, %v", r, e)
+	}
+	divider.SensorHigh = true
+	if r, e := divider.Resistance(256); e != nil || math.Abs(r-30000) > 1e-6 {
+		t.Errorf("divider reading 256 with the sensor high should be 30000 ohms, got %v, %v", r, e)
+	}
+	divider.SensorHigh = false
+	for _, raw := range []int{0, 1024} {
+		if _, e := divider.Resistance(raw); e == nil {
+			t.Errorf("divider reading %d should be an error", raw)
+		}
+	}
+
+	// a 10k B3950 thermistor reads 10k at 25°C
+	beta := BetaCoefficients(10000, Celsius(25), 3950)
+	if c := beta.Temperature(10000).Celsius(); math.Abs(c-25) > 1e-9 {
+		t.Errorf("thermistor at its reference resistance should be 25°C, got %v", c)
+	}
+	mock.MockSetAnalogValue(10, 512)
+	var thermometer Thermometer = Thermistor{Divider: divider, Coefficients: beta}
+	if temp, e := thermometer.ReadTemperature(); e != nil || math.Abs(temp.Celsius()-25) > 1e-9 {
+		t.Errorf("thermistor read at half the supply should be 25°C, got %v, %v", temp, e)
+	}
+
+	// the coefficients from three points of a datasheet's table give back those points
+	sh, e := SteinhartHartFromPoints(32650, Celsius(0), 10000, Celsius(25), 3603, Celsius(50))
+	if e != nil {
+		t.Fatalf("SteinhartHartFromPoints should not return an error, returned '%s'", e)
+	}
+	for _, p := range []struct{ ohms, celsius float64 }{{32650, 0}, {10000, 25}, {3603, 50}} {
+		if c := sh.Temperature(p.ohms).Celsius(); math.Abs(c-p.celsius) > 1e-6 {
+			t.Errorf("Steinhart-Hart at %v ohms expected %v°C, got %v", p.ohms, p.celsius, c)
+		}
+	}
+	if _, e := SteinhartHartFromPoints(10000, Celsius(0), 10000, Celsius(25), 3603, Celsius(50)); e == nil {
+		t.Error("SteinhartHartFromPoints with equal resistances should return an error")
+	}
+
+	// an LDR at its 10 lux resistance
+	ldr := LDR{Divider: divider, Ohms10Lux: 10000, Gamma: 0.7}
+	if lux, e := ldr.ReadLux(); e != nil || math.Abs(lux-10) > 1e-9 {
+		t.Errorf("LDR at its 10 lux resistance should read 10 lux, got %v, %v", lux, e)
+	}
+
+	pot := Potentiometer{Pin: 10, VoltsPerCount: 3.3 / 1024, SupplyVolts: 3.3, Min: 0, Max: 270}
+	if v, e := pot.Read(); e != nil || math.Abs(v-135) > 1e-9 {
+		t.Errorf("potentiometer at half way should read 135, got %v, %v", v, e)
+	}
+	if position, value := (Potentiometer{VoltsPerCount: 0.004, SupplyVolts: 3.3}).Convert(1000); position != 1 || value != 1 {
+		t.Errorf("potentiometer reading past its end should be at 1, got %v, %v", position, value)
+	}
+}
+
+func TestDataLogger(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-log")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.csv")
+	PinMode(3, Input)
+	logger, e := NewDataLogger(DataLoggerConfig{
+		Channels: []LogChannel{
+			LogAnalog("level", 11),
+			LogDigital("door", 3),
+			{Name: "broken", Read: func() (float64, error) { return 0, errors.New("sensor missing") }},
+		},
+		Interval:   time.Millisecond,
+		Sinks:      []LogSink{NewCSVSink(path, LogRotation{})},
+		BufferSize: 4,
+	})
+	if e != nil {
+		t.Fatalf("NewDataLogger should not return an error, returned '%s'", e)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if e = logger.Close(); e != nil {
+		t.Errorf("Close should not return an error, returned '%s'", e)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 2 || lines[0] != "time,level,door,broken" {
+		t.Fatalf("CSV log should have a header and rows, got %q", b)
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasSuffix(line, ",1000,0,") {
+			t.Errorf("CSV log row should end with the values and an empty field, got %q", line)
+		}
+	}
+
+	// rotation by size keeps the newest files
+	sink := NewCSVSink(path, LogRotation{MaxSize: 100, MaxFiles: 2})
+	records := []LogRecord{{Time: time.Now(), Values: []float64{1, 2}}}
+	for i := 0; i < 10; i++ {
+		if e = sink.WriteRecords([]string{"a", "b"}, records); e != nil {
+			t.Fatalf("WriteRecords should not return an error, returned '%s'", e)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	sink.Close()
+	if rotated := sink.file.rotated(); len(rotated) != 2 {
+		t.Errorf("CSV sink should keep 2 rotated files, kept %d", len(rotated))
+	}
+	if info, e := os.Stat(path); e != nil || info.Size() > 100 {
+		t.Errorf("CSV sink should keep the current file under 100 bytes, %v", e)
+	}
+}
+
+func TestMeasurements(t *testing.T) {
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	if c := Fahrenheit(212).Celsius(); !near(c, 100) {
+		t.Errorf("212°F should be 100°C, got %v", c)
+	}
+	if f := Kelvin(273.15).Fahrenheit(); !near(f, 32) {
+		t.Errorf("273.15K should be 32°F, got %v", f)
+	}
+	if v := 3300 * Millivolt; !near(v.Volts(), 3.3) || v.String() != "3.3V" {
+		t.Errorf("3300mV should be 3.3V, got %v, %s", v.Volts(), v)
+	}
+	if s := (12.5 * Millivolt).String(); s != "12.5mV" {
+		t.Errorf("12.5mV should be formatted as 12.5mV, got %s", s)
+	}
+	if p := 1013.25 * Hectopascal; !near(p.Pascals(), float64(Atmosphere)) || p.String() != "101.3kPa" {
+		t.Errorf("1013.25hPa should be 1 atmosphere, got %v, %s", p.Pascals(), p)
+	}
+	if d := 12 * Inch; !near(d.Feet(), 1) || !near(d.Millimetres(), 304.8) {
+		t.Errorf("12 inches should be a foot, got %v", d.Feet())
+	}
+
+	if a := 0.5 * StandardGravity; !near(a.Gs(), 0.5) || (Humidity(45)).String() != "45%RH" {
+		t.Errorf("0.5g should be half standard gravity, got %v", a.Gs())
+	}
+
+	var m Measurement = Celsius(21.5)
+	if m.Unit() != "°C" || m.String() != "21.5°C" {
+		t.Errorf("a temperature should be in °C, got %s", m)
+	}
+
+	// the CSV header records the units of channels that have them
+	dir, e := ioutil.TempDir("", "hwio-log")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.csv")
+	logger, e := NewDataLogger(DataLoggerConfig{
+		Channels: []LogChannel{
+			LogVoltage("supply", func() (Voltage, error) { return 5 * Volt, nil }),
+			LogTemperature("case", func() (Temperature, error) { return Fahrenheit(212), nil }),
+			{Name: "count", Read: func() (float64, error) { return 7, nil }},
+		},
+		Interval: time.Millisecond,
+		Sinks:    []LogSink{NewCSVSink(path, LogRotation{})},
+	})
+	if e != nil {
+		t.Fatalf("NewDataLogger should not return an error, returned '%s'", e)
+	}
+	time.Sleep(10 * time.Millisecond)
+	logger.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 2 || lines[0] != "time,supply (V),case (°C),count" {
+		t.Fatalf("CSV log should have a header with units, got %q", b)
+	}
+	if !strings.HasSuffix(lines[1], ",5,100,7") {
+		t.Errorf("CSV log should have values in base units, got %q", lines[1])
+	}
+}
+
+// An I2C bus of chips whose registers hold fixed values. Reads of a missing chip or register fail.
+type fakeRegisterBus map[int]map[byte][]byte
+
+func (bus fakeRegisterBus) SetOptions(options map[string]interface{}) error { return nil }
+func (bus fakeRegisterBus) Enable() error                                   { return nil }
+func (bus fakeRegisterBus) Disable() error                                  { return nil }
+func (bus fakeRegisterBus) GetName() string                                 { return "fake-i2c" }
+
+func (bus fakeRegisterBus) GetDevice(address int) I2CDevice {
+	return fakeRegisterDevice(bus[address])
+}
+
+type fakeRegisterDevice map[byte][]byte
+
+func (d fakeRegisterDevice) Read(command byte, numBytes int) ([]byte, error) {
+	b, ok := d[command]
+	if !ok || len(b) < numBytes {
+		return nil, errors.New("no acknowledgement")
+	}
+	return b[:numBytes], nil
+}
+
+func (d fakeRegisterDevice) ReadByte(command byte) (byte, error) {
+	b, e := d.Read(command, 1)
+	if e != nil {
+		return 0, e
+	}
+	return b[0], nil
+}
+
+func (d fakeRegisterDevice) Write(command byte, buffer []byte) error {
+	if d == nil {
+		return errors.New("no acknowledgement")
+	}
+	d[command] = buffer
+	return nil
+}
+
+func (d fakeRegisterDevice) WriteByte(command byte, value byte) error {
+	return d.Write(command, []byte{value})
+}
+
+func TestProbeI2C(t *testing.T) {
+	type chip struct{ address int }
+	newChip := func(module I2CModule, address int) (interface{}, error) { return chip{address}, nil }
+
+	// register the test drivers in a copy of the registry, so the test can be run again
+	deviceDrivers.Lock()
+	savedDrivers := deviceDrivers.drivers
+	deviceDrivers.drivers = make(map[string]DeviceDriver)
+	for name, d := range savedDrivers {
+		deviceDrivers.drivers[name] = d
+	}
+	deviceDrivers.Unlock()
+	defer func() {
+		deviceDrivers.Lock()
+		deviceDrivers.drivers = savedDrivers
+		deviceDrivers.Unlock()
+	}()
+
+	if e := RegisterDeviceDriver(DeviceDriver{Name: "test-whoami", Addresses: []int{0x68, 0x69}, Identify: IdentifyByRegister(0x75, 1, 0x7e, 0x68), New: newChip}); e != nil {
+		t.Fatalf("RegisterDeviceDriver should not return an error, returned '%s'", e)
+	}
+	if e := RegisterDeviceDriver(DeviceDriver{Name: "test-whoami", Addresses: []int{0x68}, Identify: IdentifyByRegister(0x75, 1, 0xff, 0x68), New: newChip}); e == nil {
+		t.Error("RegisterDeviceDriver should not register a name twice")
+	}
+	if e := RegisterDeviceDriver(DeviceDriver{Name: "test-bad", Addresses: []int{0x80}, Identify: IdentifyByRegister(0, 1, 0xff, 0), New: newChip}); e == nil {
+		t.Error("RegisterDeviceDriver should reject an address above 0x77")
+	}
+	RegisterDeviceDriver(DeviceDriver{Name: "test-word", Addresses: []int{0x48, 0x69}, Identify: IdentifyByRegister(0x01, 2, 0x600f, 0x6000), New: newChip})
+
+	bus := fakeRegisterBus{
+		0x69: {0x75: {0x69}, 0x01: {0x60, 0xa0}},
+		0x48: {0x01: {0x61, 0xa0}},
+		0x49: {0x01: {0x61, 0xa1}},
+	}
+	found, e := ProbeI2C(bus)
+	if e != nil {
+		t.Fatalf("ProbeI2C should not return an error, returned '%s'", e)
+	}
+	expected := []ProbedDevice{{"test-word", 0x48, chip{0x48}}, {"test-whoami", 0x69, chip{0x69}}}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("ProbeI2C expected %+v, got %+v", expected, found)
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	names := []string{"temp", "rel humidity", "missing"}
+	records := []LogRecord{
+		{Time: time.Unix(1700000000, 5), Values: []float64{21.5, 40, math.NaN()}},
+		{Time: time.Unix(1700000001, 0), Values: []float64{math.NaN(), math.NaN(), math.NaN()}},
+	}
+	tags := formatLineProtocolTags(map[string]string{"site": "north shed", "board": "pi"})
+	expected := "env,board=pi,site=north\\ shed temp=21.5,rel\\ humidity=40 1700000000000000005\n"
+	if got := string(formatLineProtocol("env", tags, names, records)); got != expected {
+		t.Errorf("line protocol expected %q, got %q", expected, got)
+	}
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewInfluxDBSink(server.URL+"/api/v2/write?org=o&bucket=b", "secret", "env", map[string]string{"site": "north shed", "board": "pi"})
+	if e := sink.WriteRecords(names, records); e != nil || body != expected {
+		t.Errorf("InfluxDB sink expected to send %q, sent %q, %v", expected, body, e)
+	}
+	sink = NewInfluxDBSink(server.URL, "wrong", "env", nil)
+	if e := sink.WriteRecords(names, records); e == nil {
+		t.Error("InfluxDB sink should return an error when the server rejects the write")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// wrapping around, and overflowing
+	buffer := NewCaptureBuffer(3)
+	for i := 0; i < 6; i++ {
+		buffer.Put(CaptureSample{Time: time.Duration(i), Value: i})
+		if i%2 == 1 {
+			buffer.Get()
+		}
+	}
+	if buffer.Len() != 3 || buffer.Overflows() != 0 {
+		t.Errorf("capture buffer expected 3 samples and no overflows, got %d and %d", buffer.Len(), buffer.Overflows())
+	}
+	buffer.Put(CaptureSample{Value: 6})
+	if buffer.Put(CaptureSample{Value: 7}) || buffer.Overflows() != 1 {
+		t.Errorf("capture buffer of 4 should overflow at the 5th sample, overflows %d", buffer.Overflows())
+	}
+	samples := buffer.Drain(nil)
+	if len(samples) != 4 || samples[0].Value != 3 || samples[3].Value != 6 || buffer.Len() != 0 {
+		t.Errorf("capture buffer should drain samples 3 to 6, got %v", samples)
+	}
+
+	// edges, with the level after each
+	gpio := getMockGPIO(t)
+	PinMode(3, Input)
+	capture, e := CaptureEdges(3, EdgeBoth, NewCaptureBuffer(16))
+	if e != nil {
+		t.Fatalf("CaptureEdges should not return an error, returned '%s'", e)
+	}
+	gpio.MockSetPinValue(3, High)
+	gpio.MockSetPinValue(3, Low)
+	gpio.MockSetPinValue(3, High)
+	capture.Stop()
+	samples = capture.Buffer.Drain(nil)
+	if len(samples) != 3 || samples[0].Value != High || samples[1].Value != Low || samples[1].Time < samples[0].Time {
+		t.Errorf("CaptureEdges expected 3 edges in order, got %v", samples)
+	}
+
+	// analog readings
+	capture, e = CaptureAnalog(11, time.Millisecond, NewCaptureBuffer(1024))
+	if e != nil {
+		t.Fatalf("CaptureAnalog should not return an error, returned '%s'", e)
+	}
+	time.Sleep(10 * time.Millisecond)
+	capture.Stop()
+	if samples = capture.Buffer.Drain(nil); len(samples) == 0 || samples[0].Value != 1000 {
+		t.Errorf("CaptureAnalog expected readings of 1000, got %v", samples)
+	}
+
+	dir, e := ioutil.TempDir("", "hwio-capture")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.bin")
+	if e = WriteCaptureFile(path, samples); e != nil {
+		t.Fatalf("WriteCaptureFile should not return an error, returned '%s'", e)
+	}
+	read, e := ReadCaptureFile(path)
+	if e != nil || len(read) != len(samples) || read[0] != samples[0] {
+		t.Errorf("ReadCaptureFile should return the samples written, got %d samples, %v", len(read), e)
+	}
+}
+
+func TestLogicAnalyzer(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	pins := PinList{2, 3, 4}
+	PinModeGroup(pins, Input)
+	gpio.MockSetPinValue(2, High)
+	gpio.MockSetPinValue(4, High)
+
+	for _, mode := range []LogicCaptureMode{LogicEdges, LogicPolling} {
+		capture, e := CaptureLogic(LogicAnalyzerConfig{Pins: pins, Mode: mode, SampleRate: 10000}, 5*time.Millisecond)
+		if e != nil {
+			t.Fatalf("CaptureLogic should not return an error, returned '%s'", e)
+		}
+		if capture.Initial != 5 || len(capture.Changes) != 0 || capture.Names[0] != "P3" {
+			t.Errorf("CaptureLogic mode %d expected levels 101 with no changes, got %b, %v, names %v", mode, capture.Initial, capture.Changes, capture.Names)
+		}
+	}
+	if _, e := CaptureLogic(LogicAnalyzerConfig{Pins: pins, Mode: LogicPolling}, time.Millisecond); e == nil {
+		t.Error("CaptureLogic should need a sample rate to poll at")
+	}
+
+	capture := &LogicCapture{
+		Names:      []string{"CLK", "DATA"},
+		SampleRate: 1000,
+		Duration:   5 * time.Millisecond,
+		Initial:    0,
+		Changes:    []LogicChange{{time.Millisecond, 1}, {2 * time.Millisecond, 3}, {4 * time.Millisecond, 2}},
+	}
+	if capture.LevelsAt(0) != 0 || capture.LevelsAt(time.Millisecond) != 1 || capture.LevelsAt(3*time.Millisecond) != 3 {
+		t.Error("LevelsAt should return the levels after the last change")
+	}
+
+	var vcd strings.Builder
+	capture.WriteVCD(&vcd)
+	for _, expected := range []string{"$var wire 1 ! CLK $end", "$var wire 1 \" DATA $end", "#2000000\n1\"\n", "#4000000\n0!\n", "#5000000\n"} {
+		if !strings.Contains(vcd.String(), expected) {
+			t.Errorf("WriteVCD expected %q in:\n%s", expected, vcd.String())
+		}
+	}
+
+	var sr bytes.Buffer
+	if e := capture.WriteSigrok(&sr); e != nil {
+		t.Fatalf("WriteSigrok should not return an error, returned '%s'", e)
+	}
+	z, e := zip.NewReader(bytes.NewReader(sr.Bytes()), int64(sr.Len()))
+	if e != nil {
+		t.Fatalf("WriteSigrok should write a zip file: %s", e)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, _ := f.Open()
+		b, _ := ioutil.ReadAll(r)
+		files[f.Name] = string(b)
+	}
+	if files["version"] != "2" || !strings.Contains(files["metadata"], "samplerate=1 kHz\n") || !strings.Contains(files["metadata"], "probe2=DATA\n") {
+		t.Errorf("WriteSigrok wrote unexpected version or metadata: %v", files)
+	}
+	if files["logic-1-1"] != "\x00\x01\x03\x03\x02" {
+		t.Errorf("WriteSigrok expected samples 0 1 3 3 2, got %v", []byte(files["logic-1-1"]))
+	}
+}
+
+func TestAudio(t *testing.T) {
+	SetDriver(new(TestDriver))
+	pwm := GetDriver().(*TestDriver).modules["pwm"].(*testPWMModule)
+
+	// a 16 bit stereo WAV file of 4 frames, mixed down to 8 bit mono
+	var wav bytes.Buffer
+	wav.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	for _, v := range []interface{}{
+		[4]byte{'f', 'm', 't', ' '}, uint32(16), uint16(1), uint16(2), uint32(8000), uint32(32000), uint16(4), uint16(16),
+		[4]byte{'d', 'a', 't', 'a'}, uint32(16), []int16{0, 0, 32767, 32767, -32768, -32768, 256, -256},
+	} {
+		binary.Write(&wav, binary.LittleEndian, v)
+	}
+	clip, e := LoadWAV(&wav)
+	if e != nil {
+		t.Fatalf("LoadWAV should not return an error, returned '%s'", e)
+	}
+	if clip.SampleRate != 8000 || !bytes.Equal(clip.Samples, []byte{128, 255, 0, 128}) {
+		t.Errorf("LoadWAV expected 8000Hz and [128 255 0 128], got %d and %v", clip.SampleRate, clip.Samples)
+	}
+	if _, e = LoadWAV(strings.NewReader("RIFF\x00\x00\x00\x00AVI ")); e == nil {
+		t.Error("LoadWAV of another RIFF file should return an error")
+	}
+
+	tone, e := Tone(1000, 10*time.Millisecond, 8000, 1)
+	if e != nil || len(tone.Samples) != 80 || tone.Duration() != 10*time.Millisecond {
+		t.Fatalf("Tone expected 80 samples, got %v, %v", tone, e)
+	}
+	if tone.Samples[0] != 128 || tone.Samples[79] != 128 {
+		t.Errorf("Tone should fade in and out from silence, got %d and %d", tone.Samples[0], tone.Samples[79])
+	}
+	if _, e = Tone(5000, time.Millisecond, 8000, 1); e == nil {
+		t.Error("Tone above half the sample rate should return an error")
+	}
+
+	// PWM audio plays for the length of the clip, and disables the pin at half duty
+	if _, e = NewPWMAudio(2, 0); e == nil {
+		t.Error("NewPWMAudio on a pin without PWM should return an error")
+	}
+	audio, e := NewPWMAudio(8, 0)
+	if e != nil {
+		t.Fatalf("NewPWMAudio should not return an error, returned '%s'", e)
+	}
+	var output AudioOutput = audio
+	start := time.Now()
+	player, e := output.Play(tone)
+	if e != nil {
+		t.Fatalf("Play should not return an error, returned '%s'", e)
+	}
+	if e = player.Wait(); e != nil || time.Since(start) < 10*time.Millisecond {
+		t.Errorf("Play should take the clip's 10ms, took %s, %v", time.Since(start), e)
+	}
+	if pwm.period[8] != 16000 || pwm.duty[8] != 8000 || pwm.enabled[8] {
+		t.Errorf("PWM audio should end at half duty and disabled, got %d/%d, %v", pwm.duty[8], pwm.period[8], pwm.enabled[8])
+	}
+	if _, e = output.Play(&AudioClip{SampleRate: 44100}); e == nil {
+		t.Error("Play at a sample rate too close to the carrier should return an error")
+	}
+
+	// ALSA audio writes the samples to aplay
+	dir, e := ioutil.TempDir("", "hwio-audio")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+	played := filepath.Join(dir, "played")
+	defer func(old func(string, int) *exec.Cmd) { alsaPlayCommand = old }(alsaPlayCommand)
+	alsaPlayCommand = func(device string, sampleRate int) *exec.Cmd {
+		return exec.Command("sh", "-c", "cat > "+played)
+	}
+	if player, e = NewALSAAudio("").Play(tone); e != nil {
+		t.Fatalf("Play should not return an error, returned '%s'", e)
+	}
+	if e = player.Wait(); e != nil {
+		t.Errorf("Wait should not return an error, returned '%s'", e)
+	}
+	if b, _ := ioutil.ReadFile(played); !bytes.Equal(b, tone.Samples) {
+		t.Errorf("ALSA audio should write the clip's samples, wrote %d bytes", len(b))
+	}
+}
+
+func TestSignalGenerator(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+	pwm := GetDriver().(*TestDriver).modules["pwm"].(*testPWMModule)
+
+	// a PWM pin uses its PWM module
+	g, e := GenerateSquare(8, 1000, 0.25, 0)
+	if e != nil {
+		t.Fatalf("GenerateSquare should not return an error, returned '%s'", e)
+	}
+	if pwm.period[8] != 1000000 || pwm.duty[8] != 250000 || !pwm.enabled[8] {
+		t.Errorf("GenerateSquare expected a 1ms period with 0.25ms duty, got %d and %d", pwm.period[8], pwm.duty[8])
+	}
+	g.Stop()
+	if pwm.enabled[8] {
+		t.Error("GenerateSquare should disable the PWM pin when stopped")
+	}
+
+	// other pins are bit-banged, ending low
+	PinModeOutputInit(2, Low)
+	g, _ = GenerateSquare(2, 1000, 0.5, 5*time.Millisecond)
+	if e = g.Wait(); e != nil || gpio.MockGetPinValue(2) != Low {
+		t.Errorf("GenerateSquare on a GPIO pin should end low, got %d, %v", gpio.MockGetPinValue(2), e)
+	}
+	if _, e = GenerateSquare(2, 1000, 1.5, 0); e == nil {
+		t.Error("GenerateSquare should not accept a duty above 1")
+	}
+
+	sweep := PWMSweep{FromHz: 10, ToHz: 1000, Steps: 3, Dwell: time.Millisecond, Duty: 0.5, UseLog: true}
+	f := sweep.Frequencies()
+	if len(f) != 3 || math.Abs(f[1]-100) > 1e-9 || f[2] != 1000 {
+		t.Errorf("PWMSweep expected frequencies 10, 100, 1000, got %v", f)
+	}
+	g, e = GeneratePWMSweep(8, sweep)
+	if e != nil {
+		t.Fatalf("GeneratePWMSweep should not return an error, returned '%s'", e)
+	}
+	g.Wait()
+	if pwm.period[8] != 1000000 || pwm.duty[8] != 500000 || pwm.enabled[8] {
+		t.Errorf("GeneratePWMSweep should end at 1kHz, disabled, got period %d", pwm.period[8])
+	}
+	if _, e = GeneratePWMSweep(2, sweep); e == nil {
+		t.Error("GeneratePWMSweep should need a PWM pin")
+	}
+
+	pins := PinList{3, 4}
+	PinModeGroup(pins, Output)
+	g, _ = GeneratePattern(pins, []uint32{0, 1, 3, 2}, time.Millisecond, 1)
+	if e = g.Wait(); e != nil || gpio.MockGetPinValue(3) != High || gpio.MockGetPinValue(4) != Low {
+		t.Errorf("GeneratePattern should leave the pins at the last value, got %d%d, %v", gpio.MockGetPinValue(3), gpio.MockGetPinValue(4), e)
+	}
+
+	// the standard sequences are maximal length
+	for _, order := range []int{7, 9, 15} {
+		prbs, _ := NewPRBS(order)
+		first := make([]int, 64)
+		for i := range first {
+			first[i] = prbs.Next()
+		}
+		for i := 64; i < prbs.Length(); i++ {
+			prbs.Next()
+		}
+		for i := range first {
+			if prbs.Next() != first[i] {
+				t.Errorf("PRBS%d should repeat after %d bits", order, prbs.Length())
+				break
+			}
+		}
+	}
+	if _, e = NewPRBS(8); e == nil {
+		t.Error("NewPRBS should not accept order 8")
+	}
+	g, _ = GeneratePRBS(2, 7, 10000, 20)
+	if e = g.Wait(); e != nil || gpio.MockGetPinValue(2) != Low {
+		t.Errorf("GeneratePRBS should end low, got %d, %v", gpio.MockGetPinValue(2), e)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	gpio.MockConnect(2, 3)
+	gpio.MockConnect(4, 5)
+	gpio.MockConnect(4, 7)
+
+	report, e := SelfTest(
+		SelfTestLink{Out: 2, In: 3},
+		SelfTestLink{Name: "shorted", Out: 4, In: 5},
+		SelfTestLink{Out: 6, In: 7},
+		SelfTestLink{Kind: SelfTestAnalog, Out: 0, In: 11, LowMax: 100, HighMin: 900},
+	)
+	if e != nil {
+		t.Fatalf("SelfTest should not return an error, returned '%s'", e)
+	}
+	r := report.Results
+	if !r[0].Passed || r[0].Link.Name != "P3->P4" {
+		t.Errorf("SelfTest expected P3->P4 to pass, got %v", r[0])
+	}
+	if r[1].Passed || r[1].Detail != "shorted to P7->P8" {
+		t.Errorf("SelfTest expected the second pair to be shorted to the third, got %v", r[1])
+	}
+	if r[2].Passed || r[2].Detail != "stuck low or not connected" {
+		t.Errorf("SelfTest expected the third pair to be not connected, got %v", r[2])
+	}
+	// the mock analog input always reads 1000
+	if r[3].Passed || r[3].Detail != "read 1000 low and 1000 high" {
+		t.Errorf("SelfTest expected the analog pair to fail low, got %v", r[3])
+	}
+	if report.Passed() || !strings.HasSuffix(report.String(), "FAIL 3 of 4 pairs\n") {
+		t.Errorf("SelfTest report should fail 3 of 4 pairs, got:\n%s", report)
+	}
+	if gpio.MockGetPinValue(2) != Low || gpio.MockGetPinMode(3) != Input {
+		t.Error("SelfTest should leave outputs low and inputs as inputs")
+	}
+
+	if _, e = SelfTest(SelfTestLink{Out: 2, In: 2}); e == nil {
+		t.Error("SelfTest should not accept a pair on one pin")
+	}
+}
+
+func TestHotplug(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	env := parseUevent([]byte("add@/devices/pci0000:00/usb1/1-1/1-1:1.0/i2c-7/i2c-dev/i2c-7\x00ACTION=add\x00" +
+		"DEVPATH=/devices/pci0000:00/usb1/1-1/1-1:1.0/i2c-7/i2c-dev/i2c-7\x00SUBSYSTEM=i2c-dev\x00DEVNAME=i2c-7\x00"))
+	if env["ACTION"] != "add" || env["DEVNAME"] != "i2c-7" || env["SUBSYSTEM"] != "i2c-dev" {
+		t.Fatalf("parseUevent returned unexpected variables %v", env)
+	}
+
+	events := make([]HotplugEvent, 0)
+	w := newHotplugWatcher(func(event HotplugEvent) { events = append(events, event) })
+	w.handle(env)
+	w.handle(map[string]string{"ACTION": "add", "DEVPATH": "/devices/platform/soc/i2c-1/i2c-dev/i2c-1", "SUBSYSTEM": "i2c-dev", "DEVNAME": "i2c-1"})
+	w.handle(map[string]string{"ACTION": "add", "DEVPATH": "/devices/usb2/2-1/spi_master/spi3/spi3.0/spidev/spidev3.0", "SUBSYSTEM": "spidev", "DEVNAME": "spidev3.0"})
+	w.handle(map[string]string{"ACTION": "add", "DEVPATH": "/devices/usb2/2-1/spi_master/spi3/spi3.1/spidev/spidev3.1", "SUBSYSTEM": "spidev", "DEVNAME": "spidev3.1"})
+
+	if len(events) != 2 || !events[0].Added || events[0].Name != "usb-i2c-7" || events[0].Device != "/dev/i2c-7" || events[1].Name != "usb-spi-3" {
+		t.Fatalf("expected USB I2C and SPI adapters to be added once each, got %v", events)
+	}
+	if _, e := GetI2CModule("usb-i2c-7"); e != nil {
+		t.Errorf("the I2C adapter should be registered as a module, got '%s'", e)
+	}
+	if _, e := GetSPIModule("usb-spi-3"); e != nil {
+		t.Errorf("the SPI adapter should be registered as a module, got '%s'", e)
+	}
+
+	env["ACTION"] = "remove"
+	w.handle(env)
+	if len(events) != 3 || events[2].Added || events[2].Name != "usb-i2c-7" || events[2].Err != nil {
+		t.Errorf("expected the I2C adapter to be removed, got %v", events)
+	}
+	if m, _ := GetModule("usb-i2c-7"); m != nil {
+		t.Error("the I2C adapter should be unregistered when removed")
+	}
+	if len(w.Modules()) != 1 {
+		t.Errorf("expected one adapter left, got %v", w.Modules())
+	}
+	UnregisterModule("usb-spi-3")
+
+	// adapters already plugged in are found in sysfs
+	dir, e := ioutil.TempDir("", "hwio-hotplug")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+	savedPath := sysClassPath
+	sysClassPath = filepath.Join(dir, "class")
+	defer func() {
+		sysClassPath = savedPath
+	}()
+	device := filepath.Join(dir, "devices", "usb1", "1-2", "tty", "ttyUSB0")
+	os.MkdirAll(device, 0755)
+	os.MkdirAll(filepath.Join(sysClassPath, "tty"), 0755)
+	os.Symlink(device, filepath.Join(sysClassPath, "tty", "ttyUSB0"))
+
+	events = events[:0]
+	w = newHotplugWatcher(func(event HotplugEvent) { events = append(events, event) })
+	w.scan()
+	if len(events) != 1 || events[0].Name != "usb-serial-ttyUSB0" || events[0].Kind != "serial" {
+		t.Errorf("expected the serial adapter to be found by scanning, got %v", events)
+	}
+	UnregisterModule("usb-serial-ttyUSB0")
+}
+
+func TestPermissions(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-perm")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	device := filepath.Join(dir, "i2c-1")
+	ioutil.WriteFile(device, nil, 0600)
+
+	if e = Permissions(); e != nil {
+		t.Errorf("Permissions should not return an error when no module needs access checked, returned '%s'", e)
+	}
+
+	present := NewDTI2CModule("present")
+	present.SetOptions(map[string]interface{}{"pins": DTI2CModulePins{}, "device": device})
+	missing := NewDTI2CModule("missing")
+	missing.SetOptions(map[string]interface{}{"pins": DTI2CModulePins{}, "device": filepath.Join(dir, "i2c-9")})
+	RegisterModule("present", present)
+	RegisterModule("missing", missing)
+	defer UnregisterModule("present")
+	defer UnregisterModule("missing")
+
+	e = Permissions()
+	pe, ok := e.(*PermissionsError)
+	if !ok {
+		t.Fatalf("expected Permissions to return a *PermissionsError, got '%v'", e)
+	}
+	if len(pe.Problems) != 1 || pe.Problems[0].Module != "missing" || !pe.Problems[0].Write {
+		t.Fatalf("expected one problem, writing the missing device, got '%s'", e)
+	}
+	if !strings.Contains(pe.Problems[0].Advice, "does not exist") {
+		t.Errorf("expected advice that the device does not exist, got '%s'", pe.Problems[0].Advice)
+	}
+}
+
+func TestExportRetry(t *testing.T) {
+	saved := exportRetry
+	defer SetExportRetryPolicy(saved)
+	SetExportRetryPolicy(ExportRetry{Attempts: 3, InitialDelay: time.Microsecond, MaxDelay: 2 * time.Microsecond})
+
+	calls := 0
+	e := retryExport(func() error {
+		calls++
+		if calls < 3 {
+			return os.ErrPermission
+		}
+		return nil
+	})
+	if e != nil || calls != 3 {
+		t.Errorf("expected success on the third call, got %d calls and error '%v'", calls, e)
+	}
+
+	calls = 0
+	e = retryExport(func() error {
+		calls++
+		return os.ErrPermission
+	})
+	if e == nil || calls != 4 {
+		t.Errorf("expected the permission error after 3 retries, got %d calls and error '%v'", calls, e)
+	}
+
+	calls = 0
+	retryExport(func() error {
+		calls++
+		return os.ErrNotExist
+	})
+	if calls != 1 {
+		t.Errorf("errors other than permission errors should not be retried, got %d calls", calls)
+	}
+}
+
+func TestDTGPIOClosePolicy(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-gpio")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedPath := gpioSysfsPath
+	gpioSysfsPath = dir
+	defer func() {
+		gpioSysfsPath = savedPath
+	}()
+
+	// the pins are already exported, as a fake sysfs can't create them on export. gpio5 is an output left high by
+	// another program.
+	for _, g := range []string{"gpio5", "gpio6", "gpio7"} {
+		os.MkdirAll(filepath.Join(dir, g), 0755)
+		ioutil.WriteFile(filepath.Join(dir, g, "direction"), []byte("in\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, g, "value"), []byte("0\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, g, "active_low"), []byte("0\n"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "gpio5", "direction"), []byte("out\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "gpio5", "value"), []byte("1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "unexport"), nil, 0644)
+
+	read := func(f string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, f))
+		return strings.TrimSpace(string(b))
+	}
+
+	gpio := NewDTGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{
+		0: {pin: 0, gpioLogical: 5},
+		1: {pin: 1, gpioLogical: 6},
+		2: {pin: 2, gpioLogical: 7},
+	}})
+	gpio.SetPinClosePolicy(0, RestorePrevious)
+	gpio.SetPinClosePolicy(1, LeaveExported)
+
+	for pin, mode := range map[Pin]PinIOMode{0: Input, 1: Output, 2: Output} {
+		if e = gpio.PinMode(pin, mode); e != nil {
+			t.Fatalf("PinMode(%d) returned error '%s'", pin, e)
+		}
+	}
+	if read("gpio5/direction") != "in" {
+		t.Errorf("expected gpio5 to be an input while open, got '%s'", read("gpio5/direction"))
+	}
+
+	gpio.ClosePin(0)
+	if read("gpio5/direction") != "high" || read("unexport") != "" {
+		t.Errorf("RestorePrevious should set gpio5 back to an output at high without unexporting, got direction '%s' unexport '%s'", read("gpio5/direction"), read("unexport"))
+	}
+
+	gpio.ClosePin(1)
+	if read("gpio6/direction") != "out" || read("unexport") != "" {
+		t.Errorf("LeaveExported should leave gpio6 as an exported output, got direction '%s' unexport '%s'", read("gpio6/direction"), read("unexport"))
+	}
+
+	gpio.Disable()
+	if read("unexport") != "7" {
+		t.Errorf("the default policy should unexport gpio7, got unexport '%s'", read("unexport"))
+	}
+}
+
+func TestPinInUse(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-gpio")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedPath, savedDebugfs := gpioSysfsPath, gpioDebugfsPath
+	gpioSysfsPath, gpioDebugfsPath = dir, filepath.Join(dir, "debug-gpio")
+	defer func() {
+		gpioSysfsPath, gpioDebugfsPath = savedPath, savedDebugfs
+	}()
+
+	// a newer kernel's format, with line names, and an older one's with just consumers
+	debugfs := `gpiochip0: GPIOs 512-565, parent: platform/fe200000.gpio, pinctrl-bcm2711:
+ gpio-512 (ID_SDA              )
+ gpio-529 (GPIO17              |sysfs               ) in  lo
+ gpio-554 (                    |led0                ) out lo ACTIVE LOW
+ gpio-9   (spi0 CS0            ) out hi
+`
+	consumers := parseGPIODebugfs(bufio.NewScanner(strings.NewReader(debugfs)))
+	if len(consumers) != 3 || consumers[529] != "sysfs" || consumers[554] != "led0" || consumers[9] != "spi0 CS0" {
+		t.Errorf("parseGPIODebugfs expected consumers of 529, 554 and 9, got %v", consumers)
+	}
+	ioutil.WriteFile(gpioDebugfsPath, []byte(debugfs), 0644)
+
+	gpio := NewDTGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{0: {pin: 0, gpioLogical: 554}}})
+	e = gpio.PinMode(0, Output)
+	if inUse, ok := e.(*PinInUseError); !ok || inUse.Consumer != "led0" || inUse.Line != "gpio 554" {
+		t.Fatalf("PinMode on a line claimed by a driver should return a PinInUseError for led0, got '%v'", e)
+	}
+	if assignedPins[0] != nil {
+		t.Error("PinMode should not leave a pin assigned when its line is in use")
+	}
+}
+
+func TestDTGPIOPinModeIdempotent(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-gpio")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	savedPath := gpioSysfsPath
+	gpioSysfsPath = dir
+	defer func() {
+		gpioSysfsPath = savedPath
+	}()
+
+	// gpio8 is already exported as an output, and is high
+	g := filepath.Join(dir, "gpio8")
+	os.MkdirAll(g, 0755)
+	ioutil.WriteFile(filepath.Join(g, "direction"), []byte("out\n"), 0644)
+	ioutil.WriteFile(filepath.Join(g, "value"), []byte("1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(g, "active_low"), []byte("0\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "unexport"), nil, 0644)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(g, "direction"), past, past)
+
+	gpio := NewDTGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{0: {pin: 0, gpioLogical: 8}}})
+	defer gpio.Disable()
+
+	if e = gpio.PinMode(0, Output); e != nil {
+		t.Fatalf("PinMode returned error '%s'", e)
+	}
+	if fi, _ := os.Stat(filepath.Join(g, "direction")); !fi.ModTime().Equal(past) {
+		t.Error("PinMode should not write the direction of a pin that is already an output")
+	}
+
+	if e = gpio.PinMode(0, Output); e != nil {
+		t.Errorf("PinMode of a pin already in that mode should not return an error, returned '%s'", e)
+	}
+
+	if e = gpio.PinModeConfig(0, PinConfig{Mode: Input}); e != nil {
+		t.Fatalf("PinModeConfig to input returned error '%s'", e)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(g, "direction"))
+	if string(b) != "in" {
+		t.Errorf("changing mode should write the direction, got '%s'", b)
+	}
+}
+
+func TestAnalogWrite(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	m, _ := GetModule("pwm")
+	pwm := m.(*testPWMModule)
+
+	// pin 8 has hardware PWM
+	e := AnalogWrite(8, 51)
+	if e != nil {
+		t.Fatalf("AnalogWrite returned error '%s'", e)
+	}
+	period := defaultAnalogWritePeriod
+	if !pwm.enabled[8] || pwm.period[8] != period || pwm.duty[8] != period/5 {
+		t.Errorf("expected PWM pin 8 enabled with period %d and duty %d, got %v %d %d", period, period/5, pwm.enabled[8], pwm.period[8], pwm.duty[8])
+	}
+
+	SetAnalogWriteFrequency(1000)
+	defer SetAnalogWriteFrequency(490)
+	AnalogWrite(8, 255)
+	if pwm.period[8] != 1000000 || pwm.duty[8] != 1000000 {
+		t.Errorf("expected period and duty of 1000000 at 1kHz and 255, got %d %d", pwm.period[8], pwm.duty[8])
+	}
+
+	if AnalogWrite(8, 256) == nil {
+		t.Error("AnalogWrite of a value over 255 should return an error")
+	}
+
+	StopAnalogWrite(8)
+	if pwm.enabled[8] {
+		t.Error("StopAnalogWrite should disable the PWM pin")
+	}
+
+	// pin 1 only has GPIO, so gets software PWM
+	gpio := getMockGPIO(t)
+	e = AnalogWrite(1, 127)
+	if e != nil {
+		t.Fatalf("AnalogWrite on a GPIO pin returned error '%s'", e)
+	}
+	if gpio.MockGetPinMode(1) != Output {
+		t.Error("software PWM should set the pin to output")
+	}
+	e = StopAnalogWrite(1)
+	if e != nil || gpio.MockGetPinValue(1) != Low {
+		t.Errorf("StopAnalogWrite should leave a software PWM pin low, got %d, error '%v'", gpio.MockGetPinValue(1), e)
+	}
+
+	if AnalogWrite(10, 100) == nil {
+		t.Error("AnalogWrite on an analog input pin should return an error")
+	}
+}
+
+func TestAnalogRead(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	ap1, e := GetPin("p11")
+	if e != nil {
+		t.Errorf("function GetPin('p11') should not return an error, returned '%s'", e)
+	}
+
+	v, e := AnalogRead(ap1)
+	if e != nil {
+		t.Errorf("after reading from pin %d, got an unexpected error: %s", ap1, e)
+	}
+	if v != 1 {
+		t.Errorf("after reading from pin %d, did not get the expected value 1, got %d", ap1, v)
+	}
+
+	ap2, _ := GetPin("p12")
+	v, _ = AnalogRead(ap2)
+	if e != nil {
+		t.Errorf("after reading from pin %d, got an unexpected error: %s", ap2, e)
+	}
+	if v != 1000 {
+		t.Errorf("after reading from pin %d, did not get the expected value 1000, got %d", ap2, v)
+	}
+}
+
+func TestNoErrorCheck(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// @todo implement TestNoErrorCheck
+}
+
+func TestBoards(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	if DefaultBoard().Driver() != GetDriver() {
+		t.Error("the default board should have the global driver")
+	}
+	if _, e := NewBoard(GetDriver()); e == nil {
+		t.Error("NewBoard with the default board's driver should return an error")
+	}
+
+	d := new(TestDriver)
+	b, e := NewBoard(d)
+	if e != nil {
+		t.Fatalf("NewBoard returned an error: %s", e)
+	}
+	defer b.Close()
+
+	pin, e := b.GetPin("P3")
+	if e != nil || pin != 2 || b.PinName(pin) != "P3" {
+		t.Errorf("GetPin('P3') on the board returned %d, error '%v'", pin, e)
+	}
+
+	// the boards' GPIO pins are independent
+	PinMode(2, Output)
+	DigitalWrite(2, Low)
+	if e := b.PinMode(2, Output); e != nil {
+		t.Errorf("PinMode on the board returned an error: %s", e)
+	}
+	b.DigitalWrite(2, High)
+	if v, _ := DigitalRead(2); v != Low {
+		t.Error("writing a pin of the board should not change the default board's pin")
+	}
+	if v, _ := b.DigitalRead(2); v != High {
+		t.Error("the board's pin should read back what was written")
+	}
+	if v, e := b.AnalogRead(11); e != nil || v != 1000 {
+		t.Errorf("AnalogRead on the board returned %d, error '%v'", v, e)
+	}
+
+	// the boards' LED modules are independent
+	leds := NewDTLEDModule("leds")
+	d.modules["leds"] = leds
+	if m, e := b.GetLEDModule("leds"); e != nil || m != leds {
+		t.Errorf("GetLEDModule on the board should return its LED module, got %v, error '%v'", m, e)
+	}
+	if _, e := b.GetLEDModule("gpio"); e == nil {
+		t.Error("GetLEDModule on the board of a GPIO module should return an error")
+	}
+	if _, e := GetLEDModule("leds"); e == nil {
+		t.Error("the default board should not have the other board's LED module")
+	}
+	delete(d.modules, "leds")
+
+	// pins are assigned per board
+	dm, _ := GetModule("gpio")
+	bm, _ := b.GetModule("gpio")
+	if dm == bm {
+		t.Fatal("the boards should have different GPIO modules")
+	}
+	if e := AssignPin(5, dm); e != nil {
+		t.Errorf("AssignPin on the default board returned an error: %s", e)
+	}
+	defer UnassignPin(5)
+	if e := AssignPin(5, bm); e != nil {
+		t.Errorf("assigning the same pin number on another board should not conflict, got '%s'", e)
+	}
+	if e := AssignPin(5, bm); e == nil {
+		t.Error("assigning a pin twice on a board should return an error")
+	}
+	UnassignPinFrom(5, bm)
+	if assignedPins[5] == nil {
+		t.Error("unassigning a pin of the board should not unassign the default board's pin")
+	}
+	if e := AssignPin(5, bm); e != nil {
+		t.Errorf("a pin should be assignable again after it's unassigned, got '%s'", e)
+	}
+}
+
+func TestNew(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	if b, e := New(nil); e != nil || b != DefaultBoard() {
+		t.Errorf("New(nil) should return the default board, got error '%v'", e)
+	}
+	if b, e := New(GetDriver()); e != nil || b != DefaultBoard() {
+		t.Errorf("New with the default driver should return the default board, got error '%v'", e)
+	}
+
+	b, e := New(new(TestDriver))
+	if e != nil || b == DefaultBoard() {
+		t.Fatalf("New with another driver should open a new board, got error '%v'", e)
+	}
+	defer b.Close()
+
+	// code written against IO works with any board
+	blink := func(io IO, name string) (int, error) {
+		pin, e := io.GetPin(name)
+		if e != nil {
+			return 0, e
+		}
+		if e = io.PinMode(pin, Output); e != nil {
+			return 0, e
+		}
+		if e = io.DigitalWrite(pin, High); e != nil {
+			return 0, e
+		}
+		return io.DigitalRead(pin)
+	}
+	if v, e := blink(b, "P4"); e != nil || v != High {
+		t.Errorf("using the board through IO returned %d, error '%v'", v, e)
+	}
+	if v, _ := DigitalRead(3); v != Low {
+		t.Error("using another board should not change the default board's pins")
+	}
+}
+
+func TestPID(t *testing.T) {
+	p := NewPID(0.5, 0.1, 0)
+	p.Setpoint = 10
+
+	// proportional and integral terms
+	if out := p.Update(9, time.Second); math.Abs(out-0.6) > 1e-9 {
+		t.Errorf("PID output should be 0.5 + 0.1, got %g", out)
+	}
+
+	// saturated: the output is clamped, and the integral doesn't wind up beyond the output range
+	for i := 0; i < 100; i++ {
+		if out := p.Update(0, time.Second); out != 1 {
+			t.Fatalf("a large error should saturate the output at 1, got %g", out)
+		}
+	}
+	if out := p.Update(10, time.Second); out != 1 {
+		t.Errorf("at the setpoint, the integral should hold the output at its limit, got %g", out)
+	}
+	if out := p.Update(12, time.Second); out >= 0.1 {
+		t.Errorf("the integral should not have wound up beyond the output range, got %g", out)
+	}
+
+	// derivative on the process variable, so a setpoint change doesn't kick the output
+	d := &PID{Kd: 1, OutMin: -100, OutMax: 100}
+	d.Update(0, time.Second)
+	d.Setpoint = 50
+	if out := d.Update(0, time.Second); out != 0 {
+		t.Errorf("a setpoint change should not kick the derivative, got %g", out)
+	}
+	if out := d.Update(2, time.Second); out != -2 {
+		t.Errorf("derivative of a rising process variable should be -2, got %g", out)
+	}
+}
+
+func TestPIDLoop(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	// a heater: the temperature rises with the output, and falls towards 0
+	var mu sync.Mutex
+	temperature := 0.0
+	input := func() (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return temperature, nil
+	}
+	output := func(out float64) error {
+		mu.Lock()
+		defer mu.Unlock()
+		temperature += out*10 - temperature*0.1
+		return nil
+	}
+
+	p := NewPID(0.2, 2, 0)
+	p.Setpoint = 50
+	l, e := RunPID(p, time.Millisecond, input, output)
+	if e != nil {
+		t.Fatalf("RunPID returned an error: %s", e)
+	}
+	time.Sleep(300 * time.Millisecond)
+	l.Stop()
+	if pv, _ := l.Last(); math.Abs(pv-50) > 5 {
+		t.Errorf("the loop should hold the temperature near 50, got %g", pv)
+	}
+
+	failing := func(float64) error { return errors.New("broken") }
+	l, _ = RunPID(NewPID(1, 0, 0), time.Millisecond, input, failing)
+	if e := l.Wait(); e == nil || e.Error() != "broken" {
+		t.Errorf("the loop should stop on an output error, got '%v'", e)
+	}
+
+	// PWM output on P9, pin 8
+	out, e := PWMOutput(8, time.Millisecond)
+	if e != nil {
+		t.Fatalf("PWMOutput returned an error: %s", e)
+	}
+	out(0.25)
+	pwm := GetDriver().(*TestDriver).modules["pwm"].(*testPWMModule)
+	if pwm.duty[8] != int64(250*time.Microsecond) || !pwm.enabled[8] {
+		t.Errorf("PWMOutput should set a quarter duty, got %d", pwm.duty[8])
+	}
+	if _, e := PWMOutput(0, time.Millisecond); e == nil {
+		t.Error("PWMOutput on a pin without PWM should return an error")
+	}
+
+	if v, e := AnalogInput(11, func(r int) float64 { return float64(r) / 10 })(); e != nil || v != 100 {
+		t.Errorf("AnalogInput should scale the reading to 100, got %g, error '%v'", v, e)
+	}
+}
+
+func TestFanController(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	curve := []FanCurvePoint{{40, 0.3}, {60, 0.7}, {80, 1}}
+	for _, c := range []struct{ temperature, duty float64 }{{20, 0.3}, {50, 0.5}, {70, 0.85}, {90, 1}} {
+		if d := fanCurveDuty(curve, c.temperature); math.Abs(d-c.duty) > 1e-9 {
+			t.Errorf("fan duty at %g should be %g, got %g", c.temperature, c.duty, d)
+		}
+	}
+
+	if _, e := NewFanController(FanConfig{PWM: 8, Curve: []FanCurvePoint{{60, 1}, {40, 0}}}); e == nil {
+		t.Error("a curve out of order should return an error")
+	}
+	if _, e := NewFanController(FanConfig{PWM: 0}); e == nil {
+		t.Error("a fan on a pin without PWM should return an error")
+	}
+
+	// the fan starts at full speed, then follows the curve; with no tach pulses it stalls
+	stalls := make(chan FanStatus, 1)
+	f, e := NewFanController(FanConfig{
+		PWM:         8,
+		PWMHz:       1000,
+		Tach:        1,
+		UseTach:     true,
+		Temperature: func() (float64, error) { return 50, nil },
+		Curve:       curve,
+		Interval:    5 * time.Millisecond,
+		StallTime:   20 * time.Millisecond,
+		OnStall:     func(s FanStatus) { stalls <- s },
+	})
+	if e != nil {
+		t.Fatalf("NewFanController returned an error: %s", e)
+	}
+	select {
+	case s := <-stalls:
+		if !s.Stalled || s.RPM != 0 || math.Abs(s.Duty-0.5) > 1e-9 || s.Temperature != 50 {
+			t.Errorf("unexpected stall status %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Error("a fan without tach pulses should stall")
+	}
+	f.Close(true)
+
+	pwm := GetDriver().(*TestDriver).modules["pwm"].(*testPWMModule)
+	if pwm.duty[8] != pwm.period[8] || pwm.period[8] != int64(time.Millisecond) {
+		t.Errorf("closing with full set should leave the fan at full speed, got duty %d of %d", pwm.duty[8], pwm.period[8])
+	}
+
+	// without a curve, the duty is set directly
+	f, _ = NewFanController(FanConfig{PWM: 8, PWMHz: 1000, Interval: time.Millisecond, MinDuty: 0.2})
+	f.SetDuty(0.1)
+	time.Sleep(10 * time.Millisecond)
+	if s := f.Status(); s.Duty != 0 {
+		t.Errorf("a duty below the minimum should turn the fan off, got %g", s.Duty)
+	}
+	f.SetDuty(0.4)
+	time.Sleep(10 * time.Millisecond)
+	if s := f.Status(); s.Duty != 0.4 {
+		t.Errorf("the duty should be 0.4, got %g", s.Duty)
+	}
+	f.Close(false)
+	if pwm.enabled[8] || pwm.duty[8] != int64(400*time.Microsecond) {
+		t.Errorf("closing should turn the fan off, enabled %v duty %d", pwm.enabled[8], pwm.duty[8])
+	}
+}
+
+func TestRTC(t *testing.T) {
+	dir, e := ioutil.TempDir("", "hwio-rtc")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	saved := rtcSysfsPath
+	rtcSysfsPath = dir
+	defer func() { rtcSysfsPath = saved }()
+
+	os.MkdirAll(filepath.Join(dir, "rtc0"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "rtc0", "since_epoch"), []byte("1700000000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "rtc0", "wakealarm"), []byte(""), 0644)
+
+	if _, e := OpenRTC("rtc1"); e == nil {
+		t.Error("opening a missing RTC should return an error")
+	}
+	rtc, e := OpenRTC("")
+	if e != nil {
+		t.Fatalf("OpenRTC returned an error: %s", e)
+	}
+	if now, _ := rtc.Now(); now.Unix() != 1700000000 {
+		t.Errorf("RTC time should be 1700000000, got %d", now.Unix())
+	}
+	if _, set, _ := rtc.WakeAlarm(); set {
+		t.Error("wake alarm should not be set")
+	}
+	if e = rtc.SetWakeAlarm(time.Now().Add(-time.Minute)); e == nil {
+		t.Error("a wake alarm in the past should return an error")
+	}
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	rtc.SetWakeAlarm(at)
+	if alarm, set, _ := rtc.WakeAlarm(); !set || !alarm.Equal(at) {
+		t.Errorf("wake alarm should be set for %s, got %s", at, alarm)
+	}
+	rtc.ClearWakeAlarm()
+	if alarm, _, _ := rtc.WakeAlarm(); alarm.Unix() != 0 {
+		t.Errorf("wake alarm should be cleared, got %s", alarm)
+	}
+
+	// the scheduler keeps the wake alarm set to its next action
+	s := NewScheduler(rtc)
+	s.At(at, StepWait(0))
+	s.At(at.Add(-30*time.Minute), StepWait(0))
+	for i := 0; i < 100; i++ {
+		if alarm, _, _ := rtc.WakeAlarm(); alarm.Equal(at.Add(-30 * time.Minute)) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if next, _ := s.Next(); !next.Equal(at.Add(-30 * time.Minute)) {
+		t.Errorf("next action should be at %s, got %s", at.Add(-30*time.Minute), next)
+	}
+	s.Close()
+	if alarm, _, _ := rtc.WakeAlarm(); alarm.Unix() != 0 {
+		t.Errorf("closing the scheduler should clear the wake alarm, got %s", alarm)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+	PinMode(2, Output)
+	PinMode(4, Output)
+	PinMode(3, Output)
+
+	s := NewScheduler(nil)
+	if _, e := s.Every(time.Now(), 0, StepSet(2, High)); e == nil {
+		t.Error("an interval of 0 should return an error")
+	}
+	s.At(time.Now().Add(10*time.Millisecond), StepSet(2, High))
+	id, _ := s.At(time.Now().Add(10*time.Millisecond), StepSet(4, High))
+	if !s.Cancel(id) || s.Cancel(id) {
+		t.Error("an action should only be cancelled once")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// the mock GPIO isn't safe for concurrent writes, so this starts after the first action
+	every, _ := s.Every(time.Now(), 5*time.Millisecond, StepPulse(3, High, time.Millisecond))
+	time.Sleep(50 * time.Millisecond)
+	if !s.Cancel(every) {
+		t.Error("a repeating action should stay scheduled")
+	}
+	if _, ok := s.Next(); ok {
+		t.Error("there should be no actions left")
+	}
+	if e := s.Close(); e != nil {
+		t.Errorf("Close returned an error: %s", e)
+	}
+	if gpio.MockGetPinValue(2) != High || gpio.MockGetPinValue(4) != Low {
+		t.Errorf("pin 2 should be set and pin 4 not, got %d and %d", gpio.MockGetPinValue(2), gpio.MockGetPinValue(4))
+	}
+}
+
+func TestLowPower(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-lowpower")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	saved := lowPowerSysfsPath
+	lowPowerSysfsPath = dir
+	defer func() { lowPowerSysfsPath = saved }()
+
+	files := map[string]string{
+		"class/leds/ACT/trigger":                              "none [mmc0] timer\n",
+		"class/leds/ACT/brightness":                           "255\n",
+		"class/rfkill/rfkill0/soft":                           "0\n",
+		"bus/usb/devices/1-1/power/control":                   "on\n",
+		"devices/system/cpu/cpufreq/policy0/scaling_governor": "ondemand\n",
+		"devices/system/cpu/cpu1/online":                      "1\n",
+		"devices/system/cpu/cpu2/online":                      "1\n",
+		"devices/system/cpu/cpu3/online":                      "1\n",
+	}
+	for name, value := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+	}
+	read := func(name string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return strings.TrimSpace(string(b))
+	}
+
+	// the mock GPIO module doesn't assign pins itself
+	gpio := getMockGPIO(t)
+	PinMode(2, Output)
+	AssignPin(2, gpio)
+	defer UnassignPin(2)
+	if unused := UnusedPins(); len(unused) != 9 || unused[2] != 3 {
+		t.Errorf("pins 0-9 except 2 should be unused, got %v", unused)
+	}
+
+	s, e := EnterLowPower(LowPowerOptions{
+		ParkUnusedPins: true,
+		DisableModules: []string{"pwm"},
+		LEDsOff:        true,
+		RadiosOff:      true,
+		USBAutosuspend: true,
+		CPUGovernor:    "powersave",
+		OnlineCPUs:     2,
+	})
+	if e != nil {
+		t.Fatalf("EnterLowPower returned an error: %s", e)
+	}
+	if gpio.MockGetPinConfig(3).Mode != InputPullDown || gpio.MockGetPinConfig(2).Mode != Output {
+		t.Error("unused pins should be parked with a pull-down, and used pins left")
+	}
+	for name, value := range map[string]string{
+		"class/leds/ACT/trigger":                              "none",
+		"class/leds/ACT/brightness":                           "0",
+		"class/rfkill/rfkill0/soft":                           "1",
+		"bus/usb/devices/1-1/power/control":                   "auto",
+		"devices/system/cpu/cpufreq/policy0/scaling_governor": "powersave",
+		"devices/system/cpu/cpu1/online":                      "1",
+		"devices/system/cpu/cpu2/online":                      "0",
+		"devices/system/cpu/cpu3/online":                      "0",
+	} {
+		if v := read(name); v != value {
+			t.Errorf("%s should be %s, got %s", name, value, v)
+		}
+	}
+
+	if e = s.Restore(); e != nil {
+		t.Errorf("Restore returned an error: %s", e)
+	}
+	if read("class/leds/ACT/trigger") != "mmc0" || read("class/leds/ACT/brightness") != "255" || read("devices/system/cpu/cpu3/online") != "1" {
+		t.Error("Restore should put the sysfs settings back")
+	}
+	if _, e = EnterLowPower(LowPowerOptions{DisableModules: []string{"nosuch"}}); e == nil {
+		t.Error("disabling a missing module should return an error")
+	}
+}
+
+func TestDebugProbe(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	for _, c := range []struct {
+		ap, read bool
+		addr     uint8
+		request  byte
+	}{{false, true, 0, 0xa5}, {true, true, 0, 0x87}, {false, true, 0xc, 0xbd}, {false, false, 0, 0x81}} {
+		if r := swdRequest(c.ap, c.read, c.addr); r != c.request {
+			t.Errorf("SWD request for ap %v read %v addr %x should be %02x, got %02x", c.ap, c.read, c.addr, c.request, r)
+		}
+	}
+
+	// TDI looped back to TDO, as a chain with a single bit bypass register would, but without the delay
+	gpio := getMockGPIO(t)
+	gpio.MockConnect(2, 3)
+	p, e := NewDebugProbe(DebugProbeConfig{TCK: 0, TMS: 1, TDI: 2, TDO: 3, UseJTAG: true, SRST: 4, UseSRST: true})
+	if e != nil {
+		t.Fatalf("NewDebugProbe returned an error: %s", e)
+	}
+	if gpio.MockGetPinConfig(4).Mode != InputPullUp {
+		t.Error("SRST should be released")
+	}
+	out, e := p.ShiftDR([]byte{0xa5, 0x03}, 10)
+	if e != nil || out[0] != 0xa5 || out[1] != 0x03 {
+		t.Errorf("ShiftDR through a loopback should return its input, got %x, %v", out, e)
+	}
+	if id, _ := p.IDCODE(); id != 0xffffffff {
+		t.Errorf("IDCODE through a loopback should be ffffffff, got %08x", id)
+	}
+
+	// OpenOCD sets TDI high then low, reading TDO each time, then asserts SRST and quits
+	client, server := net.Pipe()
+	done := make(chan error)
+	go func() { done <- p.serveRemoteBitbang(server) }()
+	client.Write([]byte("3R2Rs"))
+	reply := make([]byte, 2)
+	if _, e := io.ReadFull(client, reply); e != nil || string(reply) != "10" {
+		t.Errorf("remote bitbang should reply 10, got %q, %v", reply, e)
+	}
+	client.Write([]byte("Q"))
+	if e := <-done; e != nil {
+		t.Errorf("remote bitbang should end cleanly on Q, got %s", e)
+	}
+	client.Close()
+	if gpio.MockGetPinConfig(4).Mode != Output || gpio.MockGetPinValue(4) != Low {
+		t.Error("remote bitbang s should assert SRST")
+	}
+	p.Close()
+}
+
+// A serial module that replies to each write with the next of a list of replies, as a bootloader would.
+type testBootloaderSerial struct {
+	replies [][]byte
+	written []byte
+	pending []byte
+}
+
+func (s *testBootloaderSerial) SetOptions(map[string]interface{}) error { return nil }
+func (s *testBootloaderSerial) Enable() error                           { return nil }
+func (s *testBootloaderSerial) Disable() error                          { return nil }
+func (s *testBootloaderSerial) GetName() string                         { return "test-bootloader" }
+func (s *testBootloaderSerial) SetBaudRate(baud int) error              { return nil }
+func (s *testBootloaderSerial) SetReadDeadline(t time.Time) error       { return nil }
+
+func (s *testBootloaderSerial) Write(data []byte) (int, error) {
+	s.written = append(s.written, data...)
+	if len(s.replies) > 0 {
+		s.pending = append(s.pending, s.replies[0]...)
+		s.replies = s.replies[1:]
+	}
+	return len(data), nil
+}
+
+func (s *testBootloaderSerial) Read(data []byte) (int, error) {
+	if len(s.pending) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(data, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func TestMCUBootloaders(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+	pins := MCUResetPins{Reset: 4, Boot0: 5, UseBoot0: true, Hold: time.Millisecond, Start: time.Millisecond}
+
+	serial := &testBootloaderSerial{replies: [][]byte{
+		{0x79},
+		{0x79, 2, 0x31, 0x00, 0x44, 0x79},
+		{0x79, 1, 0x04, 0x10, 0x79},
+	}}
+	stm32 := NewSTM32Bootloader(serial, pins)
+	if e := stm32.Connect(); e != nil {
+		t.Fatalf("STM32 Connect returned an error: %s", e)
+	}
+	if gpio.MockGetPinValue(5) != High || gpio.MockGetPinConfig(4).Mode != InputPullUp {
+		t.Error("the target should be released from reset with BOOT0 high")
+	}
+	if id, e := stm32.GetID(); e != nil || id != 0x410 || stm32.Version() != 0x31 {
+		t.Errorf("STM32 ID should be 410 and version 31, got %x and %x, %v", id, stm32.Version(), e)
+	}
+	if !bytes.Equal(serial.written, []byte{0x7f, 0x00, 0xff, 0x02, 0xfd}) {
+		t.Errorf("unexpected STM32 requests %x", serial.written)
+	}
+
+	serial = &testBootloaderSerial{replies: [][]byte{
+		{0x14, 0x10},
+		{0x14, 0x10},
+		{0x14, 0x1e, 0x95, 0x0f, 0x10},
+	}}
+	avr := NewOptibootBootloader(serial, pins)
+	if e := avr.Connect(); e != nil {
+		t.Fatalf("optiboot Connect returned an error: %s", e)
+	}
+	if sig, e := avr.Signature(); e != nil || sig != 0x1e950f {
+		t.Errorf("optiboot signature should be 1e950f, got %x, %v", sig, e)
+	}
+	if !bytes.Equal(serial.written, []byte{0x30, 0x20, 0x50, 0x20, 0x75, 0x20}) {
+		t.Errorf("unexpected optiboot requests %x", serial.written)
+	}
+
+	image, start, e := ParseIntelHex(strings.NewReader(":020000040800F2\n:0400000001020304F2\n:02000600AABB93\n:00000001FF\n"))
+	if e != nil || start != 0x08000000 || !bytes.Equal(image, []byte{1, 2, 3, 4, 0xff, 0xff, 0xaa, 0xbb}) {
+		t.Errorf("unexpected hex image %x at %x, %v", image, start, e)
+	}
+	if _, _, e = ParseIntelHex(strings.NewReader(":0400000001020304F3\n:00000001FF\n")); e == nil {
+		t.Error("a bad checksum should return an error")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+	PinModeOutputInit(2, High)
+
+	f, e := ioutil.TempFile("", "hwio-watchdog")
+	if e != nil {
+		t.Fatal(e)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, e := NewWatchdog(WatchdogConfig{}); e == nil {
+		t.Error("a watchdog without a timeout should return an error")
+	}
+
+	missed := make(chan bool, 1)
+	w, e := NewWatchdog(WatchdogConfig{
+		Timeout:          20 * time.Millisecond,
+		OnMiss:           func() { missed <- true },
+		Hardware:         f.Name(),
+		HardwareInterval: 5 * time.Millisecond,
+		HardwareReboot:   true,
+	})
+	if e != nil {
+		t.Fatalf("NewWatchdog returned an error: %s", e)
+	}
+	w.SafeState(2, Low)
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(5 * time.Millisecond)
+		w.Kick()
+	}
+	if w.Tripped() {
+		t.Error("a watchdog kicked within its timeout should not trip")
+	}
+
+	select {
+	case <-missed:
+	case <-time.After(time.Second):
+		t.Fatal("a watchdog that isn't kicked should trip")
+	}
+	if !w.Tripped() || gpio.MockGetPinValue(2) != Low {
+		t.Error("a tripped watchdog should set the pin to its safe level")
+	}
+
+	w.Rearm()
+	if w.Tripped() {
+		t.Error("Rearm should clear the trip")
+	}
+	if e = w.Close(); e != nil {
+		t.Errorf("Close returned an error: %s", e)
+	}
+	b, _ := ioutil.ReadFile(f.Name())
+	if len(b) < 3 || b[0] != 0 || b[len(b)-1] != 'V' {
+		t.Errorf("the hardware watchdog should be fed, then disarmed, got %q", b)
+	}
+}
+
+func TestOutputLimits(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+
+	e := PinMode(2, Output, WithMaxToggleRate(10))
+	if e != nil {
+		t.Fatalf("PinMode with a toggle rate returned error '%s'", e)
+	}
+	defer ClosePin(2)
+
+	if e = DigitalWrite(2, High); e != nil {
+		t.Fatalf("first write should not be limited, got '%s'", e)
+	}
+	if e = DigitalWrite(2, High); e != nil {
+		t.Errorf("writing the same level should not be limited, got '%s'", e)
+	}
+	if e = DigitalWrite(2, Low); e != ErrRateLimited {
+		t.Errorf("expected ErrRateLimited for a change too soon after the last, got '%v'", e)
+	}
+	if gpio.MockGetPinValue(2) != High {
+		t.Error("a rate limited write should leave the pin as it was")
+	}
+	time.Sleep(110 * time.Millisecond)
+	if e = DigitalWrite(2, Low); e != nil || gpio.MockGetPinValue(2) != Low {
+		t.Errorf("a change after the limit's interval should be written, got %d, error '%v'", gpio.MockGetPinValue(2), e)
+	}
+
+	ClosePin(2)
+	DigitalWrite(2, High)
+	if e = DigitalWrite(2, Low); e != nil {
+		t.Errorf("ClosePin should remove the limit, got '%s'", e)
+	}
+
+	// pin 8 has hardware PWM; at full scale a second, the ramp takes a second to reach 255
+	m, _ := GetModule("pwm")
+	pwm := m.(*testPWMModule)
+	LimitOutput(8, WithDutySlew(1))
+	defer ClosePin(8)
+	AnalogWrite(8, 255)
+	time.Sleep(100 * time.Millisecond)
+	StopAnalogWrite(8)
+	period := defaultAnalogWritePeriod
+	if pwm.duty[8] <= 0 || pwm.duty[8] >= period/2 {
+		t.Errorf("expected the duty to be part way up its ramp, got %d of %d", pwm.duty[8], period)
+	}
+
+	LimitOutput(8, WithDutySlew(1000))
+	AnalogWrite(8, 255)
+	time.Sleep(50 * time.Millisecond)
+	StopAnalogWrite(8)
+	if pwm.duty[8] != period {
+		t.Errorf("expected a fast ramp to reach full duty, got %d of %d", pwm.duty[8], period)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	timer := clock.NewTimer(2 * time.Second)
+	ticker := clock.NewTicker(time.Second)
+	clock.Advance(1500 * time.Millisecond)
+	if at := <-ticker.C(); !at.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the ticker to fire at 1s, got %s", at.Sub(start))
+	}
+	select {
+	case <-timer.C():
+		t.Error("the timer should not fire before its time")
+	default:
+	}
+	clock.Advance(time.Second)
+	if at := <-timer.C(); !at.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("expected the timer to fire at 2s, got %s", at.Sub(start))
+	}
+	if !clock.Now().Equal(start.Add(2500 * time.Millisecond)) {
+		t.Errorf("expected the clock to have advanced 2.5s, got %s", clock.Now().Sub(start))
+	}
+	ticker.Stop()
+
+	// a sequence runs on the package's clock, so an hour's pulse takes no time
+	SetDriver(new(TestDriver))
+	SetClock(clock)
+	defer SetClock(nil)
+	gpio := getMockGPIO(t)
+	PinMode(3, Output)
+	seq, _ := NewSequencer(StepPulse(3, High, time.Hour))
+	seq.Start()
+	clock.BlockUntil(1)
+	if gpio.MockGetPinValue(3) != High {
+		t.Error("the pulse should have started")
+	}
+	clock.Advance(time.Hour)
+	if e := seq.Wait(); e != nil {
+		t.Errorf("sequence returned error '%s'", e)
+	}
+	if gpio.MockGetPinValue(3) != Low {
+		t.Error("the pulse should have ended once the clock passed its duration")
+	}
+}
+
+func TestFakeSysfs(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	fake := NewFakeSysfs()
+	fake.AddGPIOClass(gpioSysfsPath)
+	read := func(f string) string {
+		content, _ := fake.File(gpioSysfsPath + "/" + f)
+		return content
+	}
+
+	gpio := NewDTGPIOModule("gpio")
+	gpio.SetFilesystem(fake)
+	gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{
+		0: {pin: 0, gpioLogical: 17},
+		1: {pin: 1, gpioLogical: 27},
+	}})
+
+	e := gpio.PinModeConfig(0, PinConfig{Mode: Output, InitialValue: High, UseInitialValue: true})
+	if e != nil {
+		t.Fatalf("PinModeConfig returned error '%s'", e)
+	}
+	if read("export") != "17" || read("gpio17/direction") != "out" || read("gpio17/value") != "1" {
+		t.Errorf("expected gpio17 exported as an output at high, got export '%s' direction '%s' value '%s'", read("export"), read("gpio17/direction"), read("gpio17/value"))
+	}
+	gpio.DigitalWrite(0, Low)
+	if read("gpio17/value") != "0" {
+		t.Errorf("DigitalWrite should write the value file, got '%s'", read("gpio17/value"))
+	}
+
+	e = gpio.PinMode(1, Input)
+	if e != nil {
+		t.Fatalf("PinMode returned error '%s'", e)
+	}
+	fake.SetFile(gpioSysfsPath+"/gpio27/value", "1\n")
+	if v, e := gpio.DigitalRead(1); e != nil || v != High {
+		t.Errorf("DigitalRead should read the value file, got %d, error '%v'", v, e)
+	}
+
+	gpio.Disable()
+	if _, ok := fake.File(gpioSysfsPath + "/gpio17/value"); ok {
+		t.Error("closing a pin should unexport it")
+	}
+
+	fake.SetFile(iioDevicesPath+"/iio:device0/name", "fe720000.saradc\n")
+	fake.SetFile(iioDevicesPath+"/iio:device0/in_voltage2_raw", "1234\n")
+	analog := NewIIOAnalogModule("analog")
+	analog.SetFilesystem(fake)
+	analog.SetOptions(map[string]interface{}{"device": "saradc", "pins": IIOAnalogModulePinDefMap{10: {pin: 10, channel: 2}}})
+	if e = analog.Enable(); e != nil {
+		t.Fatalf("Enable returned error '%s'", e)
+	}
+	defer analog.Disable()
+	if v, e := analog.AnalogRead(10); e != nil || v != 1234 {
+		t.Errorf("expected 1234 from the channel's raw file, got %d, error '%v'", v, e)
+	}
+	if channels := iioVoltageChannels(fake, iioDevicesPath+"/iio:device0"); len(channels) != 1 || channels[0] != 2 {
+		t.Errorf("expected voltage channel 2, got %v", channels)
+	}
+
+	// a channel the device doesn't have fails only when its pin is read
+	analog.Disable()
+	analog.SetOptions(map[string]interface{}{"device": "saradc", "pins": IIOAnalogModulePinDefMap{10: {pin: 10, channel: 2}, 11: {pin: 11, channel: 3}}})
+	if e = analog.Enable(); e != nil {
+		t.Fatalf("Enable should skip a missing channel, returned error '%s'", e)
+	}
+	if v, e := analog.AnalogRead(10); e != nil || v != 1234 {
+		t.Errorf("expected 1234 from the channel that exists, got %d, error '%v'", v, e)
+	}
+	if _, e := analog.AnalogRead(11); e == nil {
+		t.Error("reading a missing channel should return an error")
+	}
+
+	// a pin that can't be assigned leaves nothing assigned or open
+	analog.Disable()
+	AssignPin(11, gpio)
+	if e = analog.Enable(); e == nil {
+		t.Error("Enable should fail when a pin is already assigned")
+	}
+	if analog.rawFiles != nil || boardOf(analog).assignments()[10] != nil {
+		t.Error("a failed Enable should close its files and release the pins it assigned")
+	}
+	UnassignPinFrom(11, gpio)
+
+	fake.SetFile(odroidSaradcPath+"/ch0", "512\n")
+	odroid := NewODroidCXAnalogModule("odroid-analog")
+	odroid.SetFilesystem(fake)
+	odroid.SetOptions(map[string]interface{}{"pins": ODroidCXAnalogModulePinDefMap{12: {pin: 12, analogLogical: 0}}})
+	if e = odroid.Enable(); e != nil {
+		t.Fatalf("Odroid analog Enable returned error '%s'", e)
+	}
+	defer odroid.Disable()
+	if v, e := odroid.AnalogRead(12); e != nil || v != 512 {
+		t.Errorf("expected 512 from the saradc channel file, got %d, error '%v'", v, e)
+	}
+
+	fake.SetFile("/sys/devices/bone_capemgr.9/slots", " 0: 54:PF--- \n")
+	fake.SetFile("/sys/devices/ocp.3/helper.15/AIN0", "0\n")
+	fake.SetFile("/sys/devices/ocp.3/helper.15/AIN2", "1799\n")
+	bb := NewBBAnalogModule("bb-analog")
+	bb.SetFilesystem(fake)
+	bb.SetOptions(map[string]interface{}{"pins": BBAnalogModulePinDefMap{13: {pin: 13, analogLogical: 2}}})
+	if e = bb.Enable(); e != nil {
+		t.Fatalf("BeagleBone analog Enable returned error '%s'", e)
+	}
+	defer bb.Disable()
+	if slots, _ := fake.File("/sys/devices/bone_capemgr.9/slots"); slots != "cape-bone-iio" {
+		t.Errorf("BeagleBone analog should load cape-bone-iio through the slots file, wrote '%s'", slots)
+	}
+	if v, e := bb.AnalogRead(13); e != nil || v != 1799 {
+		t.Errorf("expected 1799 from the AIN2 helper file, got %d, error '%v'", v, e)
+	}
+}
+
+// Rewrite the golden files in testdata/golden with what the tests got, after checking the change is right:
+//
+//	go test -run Golden -update-golden
+var updateGolden = flag.Bool("update-golden", false, "rewrite golden files with the results of the tests")
+
+// Compare the journal of a scenario with its golden file, testdata/golden/<name>.golden.
+func checkGolden(t *testing.T, name string, journal []string) {
+	t.Helper()
+	got := strings.Join(journal, "\n") + "\n"
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *updateGolden {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if e := ioutil.WriteFile(path, []byte(got), 0644); e != nil {
+			t.Fatalf("could not write golden file: %s", e)
+		}
+		return
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		t.Fatalf("could not read golden file, run with -update-golden to create it: %s", e)
+	}
+	want := string(b)
+	if got == want {
+		return
+	}
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("%s differs from %s at line %d:\n got:  %s\n want: %s", name, path, i+1, g, w)
+			return
+		}
+	}
+}
+
+// Replace gpioIoctl with one that answers GPIO character device ioctls as a chip would, recording each in journal
+// unless it is nil. Line requests are pipes, whose write ends are kept in fakeLineEventWriters. Returns a function
+// that puts the real one back.
+func fakeGPIOIoctls(t testing.TB, journal *[]string) func() {
+	record := func(format string, args ...interface{}) {
+		if journal != nil {
+			*journal = append(*journal, fmt.Sprintf(format, args...))
+		}
+	}
+	config := func(lc *gpioV2LineConfig) string {
+		s := fmt.Sprintf("flags=%#x", lc.flags)
+		for _, a := range lc.attrs[:lc.numAttrs] {
+			s += fmt.Sprintf(" attr(id=%d value=%#x mask=%#x)", a.attr.id, a.attr.value, a.mask)
+		}
+		return s
+	}
+
+	values := make(map[uintptr]uint64)
+	gpioIoctl = func(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+		switch request {
+		case gpioV2GetLineIoctl:
+			req := (*gpioV2LineRequest)(arg)
+			record("get line %v consumer=%q %s", req.offsets[:req.numLines], gpioCString(req.consumer[:]), config(&req.config))
+			var p [2]int
+			if e := syscall.Pipe2(p[:], syscall.O_CLOEXEC); e != nil {
+				t.Fatalf("could not create a pipe: %s", e)
+			}
+			fakeLineEventWriters[p[0]] = p[1]
+			req.fd = int32(p[0])
+		case gpioV2GetLineInfoIoctl:
+			li := (*gpioV2LineInfo)(arg)
+			record("get line info %d", li.offset)
+		case gpioV2LineSetConfigIoctl:
+			record("set config %s", config((*gpioV2LineConfig)(arg)))
+		case gpioV2LineSetValuesIoctl:
+			lv := (*gpioV2LineValues)(arg)
+			record("set values bits=%#x mask=%#x", lv.bits, lv.mask)
+			values[fd] = values[fd]&^lv.mask | lv.bits&lv.mask
+		case gpioV2LineGetValuesIoctl:
+			lv := (*gpioV2LineValues)(arg)
+			record("get values mask=%#x", lv.mask)
+			lv.bits = values[fd] & lv.mask
+		default:
+			record("ioctl %#x", request)
+			return syscall.ENOTTY
+		}
+		return nil
+	}
+	return func() {
+		gpioIoctl = sysGPIOIoctl
+		for fd, w := range fakeLineEventWriters {
+			syscall.Close(w)
+			delete(fakeLineEventWriters, fd)
+		}
+	}
+}
+
+// The write ends of the pipes fakeGPIOIoctls gives as line requests, by the request's file descriptor, for tests to
+// send edge events on.
+var fakeLineEventWriters = make(map[int]int)
+
+func TestGoldenDTGPIO(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	newModule := func() (*DTGPIOModule, *FakeSysfs) {
+		fake := NewFakeSysfs()
+		fake.AddGPIOClass(gpioSysfsPath)
+		gpio := NewDTGPIOModule("gpio")
+		gpio.SetFilesystem(fake)
+		gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{0: {pin: 0, gpioLogical: 17}}})
+		return gpio, fake
+	}
+
+	// an output set up at high, written, then closed
+	gpio, fake := newModule()
+	gpio.PinModeConfig(0, PinConfig{Mode: Output, InitialValue: High, UseInitialValue: true})
+	gpio.DigitalWrite(0, Low)
+	gpio.DigitalWrite(0, High)
+	gpio.ClosePin(0)
+	checkGolden(t, "dtgpio_output", fake.Journal())
+
+	// an emulated open-drain output, which is switched between input and output low
+	gpio, fake = newModule()
+	gpio.PinModeConfig(0, PinConfig{Mode: Output, Drive: DriveOpenDrain})
+	gpio.DigitalWrite(0, Low)
+	gpio.DigitalWrite(0, High)
+	gpio.ClosePin(0)
+	checkGolden(t, "dtgpio_open_drain", fake.Journal())
+
+	// a line already exported as an output at high, used as an input, then restored
+	gpio, fake = newModule()
+	fake.WriteFile(sysfsName(gpioSysfsPath+"/export"), []byte("17"))
+	fake.WriteFile(sysfsName(gpioSysfsPath+"/gpio17/direction"), []byte("high"))
+	fake.ClearJournal()
+	gpio.SetPinClosePolicy(0, RestorePrevious)
+	gpio.PinMode(0, Input)
+	gpio.DigitalRead(0)
+	gpio.ClosePin(0)
+	checkGolden(t, "dtgpio_restore_previous", fake.Journal())
+}
+
+func TestGoldenCdevGPIO(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	chip, e := ioutil.TempFile("", "hwio-gpiochip")
+	if e != nil {
+		t.Fatalf("could not create temporary file: %s", e)
+	}
+	chip.Close()
+	defer os.Remove(chip.Name())
+
+	var journal []string
+	defer fakeGPIOIoctls(t, &journal)()
+
+	gpio := NewCdevGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": CdevGPIOModulePinDefMap{
+		0: {pin: 0, chip: chip.Name(), line: 17},
+		1: {pin: 1, chip: chip.Name(), line: 27},
+	}})
+
+	// an output set up at high and written, then changed to an input with a pull-up and read
+	gpio.PinModeConfig(0, PinConfig{Mode: Output, InitialValue: High, UseInitialValue: true})
+	gpio.DigitalWrite(0, Low)
+	gpio.PinModeConfig(0, PinConfig{Mode: Input, Bias: BiasPullUp, Debounce: 5 * time.Millisecond})
+	gpio.DigitalRead(0)
+
+	// two pins as a group
+	gpio.ClosePin(0)
+	gpio.PinModeGroup(PinList{0, 1}, Output)
+	gpio.DigitalWriteGroup(PinList{0, 1}, 2)
+	gpio.Disable()
+	checkGolden(t, "cdev_gpio", journal)
+}
+
+func TestCdevGPIOGroupFailure(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	var chips []string
+	for i := 0; i < 2; i++ {
+		chip, e := ioutil.TempFile("", "hwio-gpiochip")
+		if e != nil {
+			t.Fatalf("could not create temporary file: %s", e)
+		}
+		chip.Close()
+		defer os.Remove(chip.Name())
+		chips = append(chips, chip.Name())
+	}
+
+	// the second line request is refused, as if another process held its lines
+	defer fakeGPIOIoctls(t, nil)()
+	fake := gpioIoctl
+	requests := 0
+	gpioIoctl = func(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+		if request == gpioV2GetLineIoctl {
+			requests++
+			if requests == 2 {
+				return syscall.EBUSY
+			}
+		}
+		return fake(fd, request, arg)
+	}
+
+	gpio := NewCdevGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": CdevGPIOModulePinDefMap{
+		0: {pin: 0, chip: chips[0], line: 17},
+		1: {pin: 1, chip: chips[0], line: 27},
+		2: {pin: 2, chip: chips[1], line: 5},
+		3: {pin: 3, chip: chips[1], line: 6},
+	}})
+	defer gpio.Disable()
+
+	group := PinList{0, 1, 2, 3}
+	if e := gpio.PinModeGroup(group, Output); e == nil {
+		t.Fatal("expected an error when the second chip's request fails")
+	}
+	if len(gpio.requests) != 0 || len(gpio.openPins) != 0 {
+		t.Errorf("expected the first chip's request to be released, have %d requests and %d open pins", len(gpio.requests), len(gpio.openPins))
+	}
+	for _, pin := range group {
+		if defaultBoard.assignments()[pin] != nil {
+			t.Errorf("expected pin %d to be unassigned after the group failed", pin)
+		}
+	}
+
+	// a pin held by another module fails the group without releasing that pin
+	other := newTestGPIOModule("other")
+	AssignPin(2, other)
+	defer UnassignPin(2)
+	if e := gpio.PinModeGroup(group, Output); e == nil {
+		t.Error("expected an error when a pin of the group is assigned to another module")
+	}
+	if a := defaultBoard.assignments()[2]; a == nil || a.module != other {
+		t.Error("the failed group should leave pin 2 assigned to the other module")
+	}
+	if defaultBoard.assignments()[0] != nil || defaultBoard.assignments()[1] != nil {
+		t.Error("the failed group should unassign the pins it assigned")
+	}
+
+	// the pins can be opened once the lines are free
+	if e := gpio.PinModeGroup(PinList{0, 1}, Output); e != nil {
+		t.Errorf("PinModeGroup after a failure returned error '%s'", e)
+	}
+}
+
+func TestCdevEdgePoller(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	chip, e := ioutil.TempFile("", "hwio-gpiochip")
+	if e != nil {
+		t.Fatalf("could not create temporary file: %s", e)
+	}
+	chip.Close()
+	defer os.Remove(chip.Name())
+	defer fakeGPIOIoctls(t, nil)()
+
+	// each pin is a line request of its own, so a file to wait on
+	const count = 50
+	pins := make(CdevGPIOModulePinDefMap)
+	for i := 0; i < count; i++ {
+		pins[Pin(i)] = &CdevGPIOModulePinDef{pin: Pin(i), chip: chip.Name(), line: i}
+	}
+	gpio := NewCdevGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": pins})
+	defer gpio.Disable()
+
+	before := GetEdgePollerStats()
+	goroutines := runtime.NumGoroutine()
+	channels := make([]<-chan EdgeEvent, count)
+	for i := range channels {
+		if e = gpio.PinMode(Pin(i), Input); e != nil {
+			t.Fatalf("PinMode returned error '%s'", e)
+		}
+		if channels[i], e = gpio.WatchEdges(Pin(i), EdgeBoth); e != nil {
+			t.Fatalf("WatchEdges returned error '%s'", e)
+		}
+	}
+	if n := runtime.NumGoroutine() - goroutines; n > 1 {
+		t.Errorf("expected watching %d pins to start at most one goroutine, started %d", count, n)
+	}
+	if files := GetEdgePollerStats().Files - before.Files; files != count {
+		t.Errorf("expected the poller to wait on %d files, got %d", count, files)
+	}
+
+	send := func(pin Pin, id uint32, timestamp uint64) {
+		event := gpioV2LineEvent{timestampNs: timestamp, id: id, offset: uint32(pin), lineSeqno: 1}
+		w := fakeLineEventWriters[gpio.openPins[pin].request.fd()]
+		syscall.Write(w, (*[unsafe.Sizeof(event)]byte)(unsafe.Pointer(&event))[:])
+	}
+	for i := count - 1; i >= 0; i-- {
+		send(Pin(i), gpioV2LineEventRisingEdge, uint64(1000+i))
+	}
+	for i, ch := range channels {
+		select {
+		case event := <-ch:
+			if event.Pin != Pin(i) || !event.Rising || event.Timestamp != time.Duration(1000+i) {
+				t.Errorf("pin %d got the wrong event %+v", i, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("pin %d got no event", i)
+		}
+	}
+
+	// stopping one watch leaves the others delivering
+	if e = gpio.StopWatchingEdges(7); e != nil {
+		t.Fatalf("StopWatchingEdges returned error '%s'", e)
+	}
+	if _, ok := <-channels[7]; ok {
+		t.Error("StopWatchingEdges should close the channel")
+	}
+	send(8, gpioV2LineEventFallingEdge, 2000)
+	select {
+	case event := <-channels[8]:
+		if event.Rising || event.Timestamp != 2000 {
+			t.Errorf("pin 8 got the wrong event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pin 8 got no event after another pin stopped being watched")
+	}
+
+	gpio.Disable()
+	if files := GetEdgePollerStats().Files - before.Files; files != 0 {
+		t.Errorf("expected the poller to wait on no files once the module is disabled, got %d", files)
+	}
+}
+
+// Benchmarks of pin operations on each backend, run with:
+//
+//	go test -run XXX -bench .
+//
+// Off the board, "mock" is the TestDriver through the package functions, "sysfs" is DTGPIOModule on files in a
+// temporary directory, and "cdev" is CdevGPIOModule with its ioctls answered by fakeGPIOIoctls, so they measure hwio's
+// own overhead. On a board, the "board" backend measures the board's own driver through the package functions; it
+// needs HWIO_BENCH_OUT and HWIO_BENCH_IN set to the names of two GPIO pins wired together, HWIO_BENCH_ANALOG to an
+// analog pin, and HWIO_BENCH_I2C to an I2C module, device address and register, e.g. "i2c1:0x48:0". Backends that
+// aren't available are skipped. See "Benchmarks" in README.md.
+
+// A GPIO backend for the benchmarks, with an output wired to an input. edges is nil if the backend can't watch edges.
+type benchGPIO struct {
+	write func(value int) error
+	read  func() (int, error)
+	edges func() (<-chan EdgeEvent, error)
+	close func()
+}
+
+var benchGPIOBackends = []struct {
+	name  string
+	setup func(b testing.TB) *benchGPIO
+}{
+	{"mock", benchMockGPIO},
+	{"sysfs", benchSysfsGPIO},
+	{"cdev", benchCdevGPIO},
+	{"board", benchBoardGPIO},
+}
+
+// Run a benchmark on each GPIO backend.
+func runGPIOBenchmark(b *testing.B, run func(b *testing.B, gpio *benchGPIO)) {
+	for _, backend := range benchGPIOBackends {
+		setup := backend.setup
+		b.Run(backend.name, func(b *testing.B) {
+			gpio := setup(b)
+			defer gpio.close()
+			b.ResetTimer()
+			run(b, gpio)
+		})
+	}
+}
+
+func benchMockGPIO(b testing.TB) *benchGPIO {
+	SetDriver(new(TestDriver))
+	m, _ := GetGPIOModule()
+	m.(*testGPIOModule).MockConnect(2, 3)
+	PinMode(2, Output)
+	PinMode(3, Input)
+	return &benchGPIO{
+		write: func(value int) error { return DigitalWrite(2, value) },
+		read:  func() (int, error) { return DigitalRead(3) },
+		edges: func() (<-chan EdgeEvent, error) { return WatchEdges(3, EdgeBoth) },
+		close: func() {},
+	}
+}
+
+func benchSysfsGPIO(b testing.TB) *benchGPIO {
+	SetDriver(new(TestDriver))
+	dir, e := ioutil.TempDir("", "hwio-bench")
+	if e != nil {
+		b.Fatalf("could not create temporary directory: %s", e)
+	}
+	savedPath := gpioSysfsPath
+	gpioSysfsPath = dir
+	for _, f := range []string{"export", "unexport"} {
+		ioutil.WriteFile(filepath.Join(dir, f), nil, 0644)
+	}
+	for _, g := range []string{"gpio5", "gpio6"} {
+		os.Mkdir(filepath.Join(dir, g), 0755)
+		for _, f := range []string{"direction", "value", "active_low"} {
+			ioutil.WriteFile(filepath.Join(dir, g, f), []byte("0"), 0644)
+		}
+	}
+
+	gpio := NewDTGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": DTGPIOModulePinDefMap{
+		0: {pin: 0, gpioLogical: 5},
+		1: {pin: 1, gpioLogical: 6},
+	}})
+	gpio.SetClosePolicy(LeaveExported)
+	if e = gpio.PinMode(0, Output); e == nil {
+		e = gpio.PinMode(1, Input)
+	}
+	if e != nil {
+		b.Fatalf("PinMode returned error '%s'", e)
+	}
+	return &benchGPIO{
+		write: func(value int) error { return gpio.DigitalWrite(0, value) },
+		read:  func() (int, error) { return gpio.DigitalRead(1) },
+		close: func() {
+			gpio.Disable()
+			gpioSysfsPath = savedPath
+			os.RemoveAll(dir)
+		},
+	}
+}
+
+func benchCdevGPIO(b testing.TB) *benchGPIO {
+	SetDriver(new(TestDriver))
+	chip, e := ioutil.TempFile("", "hwio-gpiochip")
+	if e != nil {
+		b.Fatalf("could not create temporary file: %s", e)
+	}
+	chip.Close()
+	restore := fakeGPIOIoctls(b, nil)
+
+	gpio := NewCdevGPIOModule("gpio")
+	gpio.SetOptions(map[string]interface{}{"pins": CdevGPIOModulePinDefMap{
+		0: {pin: 0, chip: chip.Name(), line: 5},
+		1: {pin: 1, chip: chip.Name(), line: 6},
+	}})
+	if e = gpio.PinMode(0, Output); e == nil {
+		e = gpio.PinMode(1, Input)
+	}
+	if e != nil {
+		b.Fatalf("PinMode returned error '%s'", e)
+	}
+	return &benchGPIO{
+		write: func(value int) error { return gpio.DigitalWrite(0, value) },
+		read:  func() (int, error) { return gpio.DigitalRead(1) },
+		close: func() {
+			gpio.Disable()
+			restore()
+			os.Remove(chip.Name())
+		},
+	}
+}
+
+// The board's own driver, if HWIO_BENCH_OUT and HWIO_BENCH_IN name two pins wired together.
+func benchBoardGPIO(b testing.TB) *benchGPIO {
+	outName, inName := os.Getenv("HWIO_BENCH_OUT"), os.Getenv("HWIO_BENCH_IN")
+	if outName == "" || inName == "" {
+		b.Skip("set HWIO_BENCH_OUT and HWIO_BENCH_IN to two GPIO pins wired together to benchmark the board")
+	}
+	benchBoardDriver(b)
+	out, e := GetPin(outName)
+	if e != nil {
+		b.Fatal(e)
+	}
+	in, e := GetPin(inName)
+	if e != nil {
+		b.Fatal(e)
+	}
+	if e = PinMode(out, Output); e == nil {
+		e = PinMode(in, Input)
+	}
+	if e != nil {
+		b.Fatalf("PinMode returned error '%s'", e)
+	}
+	return &benchGPIO{
+		write: func(value int) error { return DigitalWrite(out, value) },
+		read:  func() (int, error) { return DigitalRead(in) },
+		edges: func() (<-chan EdgeEvent, error) { return WatchEdges(in, EdgeBoth) },
+		close: func() {
+			StopWatchingEdges(in)
+			ClosePin(out)
+			ClosePin(in)
+		},
+	}
+}
+
+// Select the board's own driver in place of the TestDriver the tests use, skipping if there isn't one.
+func benchBoardDriver(b testing.TB) {
+	if e := determineDriver(); e != nil {
+		b.Skip("no driver for this board")
+	}
+}
+
+func BenchmarkDigitalWrite(b *testing.B) {
+	runGPIOBenchmark(b, func(b *testing.B, gpio *benchGPIO) {
+		for i := 0; i < b.N; i++ {
+			if e := gpio.write(i & 1); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+}
+
+func BenchmarkDigitalRead(b *testing.B) {
+	runGPIOBenchmark(b, func(b *testing.B, gpio *benchGPIO) {
+		for i := 0; i < b.N; i++ {
+			if _, e := gpio.read(); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+}
+
+// The time from writing the output to receiving the edge it causes on the input.
+func BenchmarkEdgeLatency(b *testing.B) {
+	runGPIOBenchmark(b, func(b *testing.B, gpio *benchGPIO) {
+		if gpio.edges == nil {
+			b.Skip("backend can't watch edges without hardware")
+		}
+		edges, e := gpio.edges()
+		if e != nil {
+			b.Fatal(e)
+		}
+		timeout := time.NewTimer(time.Hour)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			gpio.write((i + 1) & 1)
+			timeout.Reset(time.Second)
+			select {
+			case <-edges:
+			case <-timeout.C:
+				b.Fatal("no edge within a second; are the pins wired together?")
+			}
+			timeout.Stop()
+		}
+	})
+}
+
+func BenchmarkAnalogRead(b *testing.B) {
+	b.Run("mock", func(b *testing.B) {
+		SetDriver(new(TestDriver))
+		for i := 0; i < b.N; i++ {
+			if _, e := AnalogRead(10); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+
+	b.Run("iio", func(b *testing.B) {
+		SetDriver(new(TestDriver))
+		dir, e := ioutil.TempDir("", "hwio-bench")
+		if e != nil {
+			b.Fatalf("could not create temporary directory: %s", e)
+		}
+		defer os.RemoveAll(dir)
+		savedPath := iioDevicesPath
+		iioDevicesPath = dir
+		defer func() { iioDevicesPath = savedPath }()
+		os.Mkdir(filepath.Join(dir, "iio:device0"), 0755)
+		ioutil.WriteFile(filepath.Join(dir, "iio:device0", "name"), []byte("saradc\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "iio:device0", "in_voltage0_raw"), []byte("2048\n"), 0644)
+
+		analog := NewIIOAnalogModule("analog")
+		analog.SetOptions(map[string]interface{}{"device": "saradc", "pins": IIOAnalogModulePinDefMap{0: {pin: 0, channel: 0}}})
+		if e = analog.Enable(); e != nil {
+			b.Fatal(e)
+		}
+		defer analog.Disable()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, e := analog.AnalogRead(0); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+
+	b.Run("board", func(b *testing.B) {
+		name := os.Getenv("HWIO_BENCH_ANALOG")
+		if name == "" {
+			b.Skip("set HWIO_BENCH_ANALOG to an analog pin to benchmark the board")
+		}
+		benchBoardDriver(b)
+		pin, e := GetPin(name)
+		if e != nil {
+			b.Fatal(e)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, e := AnalogRead(pin); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+}
+
+// A DTI2CModule on a temporary file, whose ioctls are answered by the test: reads return 0x42. The function returned
+// cleans up.
+func fakeI2CDevice(t testing.TB) (I2CDevice, func()) {
+	file, e := ioutil.TempFile("", "hwio-i2c")
+	if e != nil {
+		t.Fatalf("could not create temporary file: %s", e)
+	}
+	file.Close()
+
+	i2c := NewDTI2CModule("i2c")
+	i2c.SetOptions(map[string]interface{}{"device": file.Name(), "pins": DTI2CModulePins{}})
+	if e = i2c.Enable(); e != nil {
+		t.Fatal(e)
+	}
+	saved := i2cIoctl
+	i2cIoctl = func(fd uintptr, request uintptr, arg uintptr) error {
+		if request == I2CSMBus && i2c.smbus.readWrite == I2CSMBusRead {
+			if i2c.smbus.size == I2CSMBusByteData {
+				i2c.block[0] = 0x42
+			} else {
+				for i := 1; i <= int(i2c.block[0]); i++ {
+					i2c.block[i] = 0x42
+				}
+			}
+		}
+		return nil
+	}
+	return i2c.GetDevice(0x48), func() {
+		i2cIoctl = saved
+		i2c.Disable()
+		os.Remove(file.Name())
+	}
+}
+
+// A one byte register read, the smallest I2C transaction. The "ioctl" backend is DTI2CModule with its ioctls
+// answered in the test; "board" needs a board and a device on its bus.
+func BenchmarkI2CReadByte(b *testing.B) {
+	b.Run("ioctl", func(b *testing.B) {
+		device, close := fakeI2CDevice(b)
+		defer close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, e := device.ReadByte(0); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+
+	b.Run("board", func(b *testing.B) {
+		parts := strings.Split(os.Getenv("HWIO_BENCH_I2C"), ":")
+		if len(parts) != 3 {
+			b.Skip("set HWIO_BENCH_I2C to an I2C module, device address and register, e.g. i2c1:0x48:0, to benchmark I2C")
+		}
+		address, e1 := strconv.ParseInt(parts[1], 0, 16)
+		register, e2 := strconv.ParseUint(parts[2], 0, 8)
+		if e1 != nil || e2 != nil {
+			b.Fatalf("could not parse HWIO_BENCH_I2C '%s'", os.Getenv("HWIO_BENCH_I2C"))
+		}
+		benchBoardDriver(b)
+		i2c, e := GetI2CModule(parts[0])
+		if e != nil {
+			b.Fatal(e)
+		}
+		if e = i2c.Enable(); e != nil {
+			b.Fatal(e)
+		}
+		device := i2c.GetDevice(int(address))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, e := device.ReadByte(byte(register)); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+}
+
+// A serial port that receives the same bytes on every read, as fast as they are read.
+type repeatingSerial struct {
+	data []byte
+}
+
+func (s *repeatingSerial) SetOptions(map[string]interface{}) error { return nil }
+func (s *repeatingSerial) Enable() error                           { return nil }
+func (s *repeatingSerial) Disable() error                          { return nil }
+func (s *repeatingSerial) GetName() string                         { return "repeating" }
+func (s *repeatingSerial) Read(data []byte) (int, error)           { return copy(data, s.data), nil }
+func (s *repeatingSerial) Write(data []byte) (int, error)          { return len(data), nil }
+func (s *repeatingSerial) SetBaudRate(baud int) error              { return nil }
+
+// Framing NMEA sentences, from reading the port to delivering each frame.
+func BenchmarkReadFrames(b *testing.B) {
+	serial := &repeatingSerial{data: []byte("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n")}
+	r, e := ReadFrames(serial, SerialFraming{Delimiter: []byte("\r\n")})
+	if e != nil {
+		b.Fatal(e)
+	}
+	defer r.Stop()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		<-r.Frames
+	}
+}
+
+// The package functions must not allocate on the hot path of reading and writing pins, which performance-sensitive
+// code (software PWM, bit-banged protocols) calls in tight loops.
+func TestPinOperationAllocations(t *testing.T) {
+	SetDriver(new(TestDriver))
+	PinMode(2, Output)
+	PinMode(3, Input)
+	value := 0
+	for name, op := range map[string]func(){
+		"DigitalWrite": func() { value ^= 1; DigitalWrite(2, value) },
+		"DigitalRead":  func() { DigitalRead(3) },
+		"AnalogRead":   func() { AnalogRead(10) },
+	} {
+		if allocs := testing.AllocsPerRun(100, op); allocs != 0 {
+			t.Errorf("%s allocated %.1f times per call, expected none", name, allocs)
+		}
+	}
+}
+
+// The modules' own hot paths must not allocate either, beyond the slice a read returns.
+func TestModuleAllocations(t *testing.T) {
+	value := 0
+	for _, backend := range benchGPIOBackends[1:3] {
+		gpio := backend.setup(t)
+		for op, f := range map[string]func(){
+			"DigitalWrite": func() { value ^= 1; gpio.write(value) },
+			"DigitalRead":  func() { gpio.read() },
+		} {
+			if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+				t.Errorf("%s %s allocated %.1f times per call, expected none", backend.name, op, allocs)
+			}
+		}
+		gpio.close()
+	}
+
+	device, closeI2C := fakeI2CDevice(t)
+	defer closeI2C()
+
+	dir, e := ioutil.TempDir("", "hwio-allocs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	savedPath := iioDevicesPath
+	iioDevicesPath = dir
+	defer func() { iioDevicesPath = savedPath }()
+	os.Mkdir(filepath.Join(dir, "iio:device0"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "iio:device0", "name"), []byte("saradc\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "iio:device0", "in_voltage0_raw"), []byte("2048\n"), 0644)
+	analog := NewIIOAnalogModule("analog")
+	analog.SetOptions(map[string]interface{}{"device": "saradc", "pins": IIOAnalogModulePinDefMap{0: {pin: 0, channel: 0}}})
+	if e = analog.Enable(); e != nil {
+		t.Fatal(e)
+	}
+	defer analog.Disable()
+	if v, e := analog.AnalogRead(0); e != nil || v != 2048 {
+		t.Errorf("expected 2048 from the IIO channel, got %d, %v", v, e)
+	}
+	if v, e := device.Read(0, 4); e != nil || !bytes.Equal(v, []byte{0x42, 0x42, 0x42, 0x42}) {
+		t.Errorf("expected 4 bytes of 0x42 from the I2C device, got % x, %v", v, e)
+	}
+
+	data := []byte{1, 2}
+	for _, c := range []struct {
+		name   string
+		allocs float64
+		op     func()
+	}{
+		{"iio AnalogRead", 0, func() { analog.AnalogRead(0) }},
+		{"i2c ReadByte", 0, func() { device.ReadByte(0) }},
+		{"i2c WriteByte", 0, func() { device.WriteByte(0, 1) }},
+		{"i2c Write", 0, func() { device.Write(0, data) }},
+		{"i2c Read", 1, func() { device.Read(0, 2) }},
+	} {
+		if allocs := testing.AllocsPerRun(100, c.op); allocs > c.allocs {
+			t.Errorf("%s allocated %.1f times per call, expected %.0f", c.name, allocs, c.allocs)
+		}
+	}
+}
+
+func TestFileDescriptorBudget(t *testing.T) {
+	if e := SetFileDescriptorBudget(-1); e == nil {
+		t.Error("SetFileDescriptorBudget accepted a negative budget")
+	}
+	if e := SetFileDescriptorBudget(1); e != nil {
+		t.Fatal(e)
+	}
+	defer SetFileDescriptorBudget(0)
+
+	// both value files can't be open at once, so each is closed when the other is used
+	gpio := benchSysfsGPIO(t)
+	if n := FileDescriptorsByModule()["gpio"]; n != 0 {
+		t.Errorf("expected no files open before the pins are used, got %d", n)
+	}
+	ioutil.WriteFile(filepath.Join(gpioSysfsPath, "gpio6", "value"), []byte("1"), 0644)
+	for i := 0; i < 3; i++ {
+		if e := gpio.write(i % 2); e != nil {
+			t.Fatalf("DigitalWrite returned error '%s'", e)
+		}
+		value, e := gpio.read()
+		if e != nil || value != High {
+			t.Fatalf("DigitalRead returned %d, '%v', expected 1", value, e)
+		}
+		if n := FileDescriptorsByModule()["gpio"]; n != 1 {
+			t.Errorf("expected 1 file open within the budget, got %d", n)
+		}
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(gpioSysfsPath, "gpio5", "value"))
+	if string(data) != "0" {
+		t.Errorf("expected the output to be written as 0 after reopening, got '%s'", data)
+	}
+
+	gpio.close()
+	if n := FileDescriptorsByModule()["gpio"]; n != 0 {
+		t.Errorf("expected no files open after the module is disabled, got %d", n)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fake := NewFakeSysfs()
+	fake.SetFile(kernelReleasePath, "6.1.21-v8+\n")
+	fake.SetFile(deviceTreePath+"/model", "Raspberry Pi 4 Model B Rev 1.4\x00")
+	fake.SetFile(gpioDebugfsPath, `gpiochip0: GPIOs 512-565, parent: platform/fe200000.gpio, pinctrl-bcm2711:
+ gpio-529 (GPIO17              |sysfs               ) in  lo
+ gpio-554 (                    |led0                ) out lo ACTIVE LOW
+`)
+	fake.SetFile(gpioSysfsPath+"/gpiochip512/base", "512\n")
+	fake.SetFile(gpioSysfsPath+"/gpio529/direction", "in\n")
+	fake.SetFile(gpioSysfsPath+"/gpio529/value", "0\n")
+	fake.SetFile(gpioSysfsPath+"/gpio529/edge", "none\n")
+	fake.SetFile(gpioSysfsPath+"/gpio529/active_low", "0\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/npwm", "2\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/pwm0/period", "1000000\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/pwm0/duty_cycle", "250000\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/pwm0/enable", "1\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/pwm0/polarity", "normal\n")
+	fake.SetFile(i2cDevicesPath+"/i2c-1/name", "bcm2835 (i2c@7e804000)\n")
+	fake.SetFile(i2cDevicesPath+"/1-0068/name", "ds1307\n")
+
+	before, e := TakeSnapshot(SnapshotOptions{Filesystem: fake})
+	if e != nil {
+		t.Fatalf("TakeSnapshot returned error '%s'", e)
+	}
+	if before.Kernel != "6.1.21-v8+" || before.Model != "Raspberry Pi 4 Model B Rev 1.4" {
+		t.Errorf("unexpected kernel '%s' and model '%s'", before.Kernel, before.Model)
+	}
+	if len(before.GPIOs) != 2 || !before.GPIOs[0].Exported || before.GPIOs[0].Name != "GPIO17" ||
+		before.GPIOs[0].Edge != "none" || before.GPIOs[1].Consumer != "led0" || !before.GPIOs[1].ActiveLow ||
+		before.GPIOs[1].Value == nil || *before.GPIOs[1].Value != 0 {
+		t.Errorf("unexpected GPIOs %+v", before.GPIOs)
+	}
+	if len(before.PWMs) != 1 || before.PWMs[0] != (PWMState{"pwmchip0", 0, 1000000, 250000, true, "normal"}) {
+		t.Errorf("unexpected PWMs %+v", before.PWMs)
+	}
+	if len(before.I2CDevices) != 1 || before.I2CDevices[0] != (I2CDeviceState{1, 0x68, "ds1307"}) {
+		t.Errorf("unexpected I2C devices %+v", before.I2CDevices)
+	}
+	if changes := DiffSnapshots(before, before); len(changes) != 0 {
+		t.Errorf("expected no differences between a snapshot and itself, got %v", changes)
+	}
+
+	// save and load it, as "hwio snapshot" and "hwio diff" do
+	dir, e := ioutil.TempDir("", "hwio-snapshot")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+	if e = before.Save(filepath.Join(dir, "before.json")); e != nil {
+		t.Fatalf("Save returned error '%s'", e)
+	}
+	before, e = LoadSnapshot(filepath.Join(dir, "before.json"))
+	if e != nil {
+		t.Fatalf("LoadSnapshot returned error '%s'", e)
+	}
+
+	// gpio17 becomes an output, the PWM is disabled, and the RTC goes missing
+	fake.SetFile(gpioSysfsPath+"/gpio529/direction", "out\n")
+	fake.SetFile(gpioSysfsPath+"/gpio529/value", "1\n")
+	fake.SetFile(pwmSysfsPath+"/pwmchip0/pwm0/enable", "0\n")
+	fake.Remove(i2cDevicesPath + "/1-0068")
+	fake.SetFile(i2cDevicesPath+"/1-0048/name", "ads1015\n")
+	after, e := TakeSnapshot(SnapshotOptions{Filesystem: fake})
+	if e != nil {
+		t.Fatalf("TakeSnapshot returned error '%s'", e)
+	}
+
+	var got []string
+	for _, c := range DiffSnapshots(before, after) {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"gpio529 direction: in -> out",
+		"gpio529 value: 0 -> 1",
+		"i2c-1 0x48: added (name=ads1015)",
+		"i2c-1 0x68: removed (was name=ds1307)",
+		"pwmchip0/pwm0 enabled: true -> false",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected differences\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestJournal(t *testing.T) {
+	SetDriver(new(TestDriver))
+	defer func() {
+		DisableJournal()
+		ClearJournal()
+	}()
+
+	pin, _ := GetPin("gpio1")
+	ain, _ := GetPin("ain4")
+	PinMode(pin, Output)
+	if entries, _ := JournalEntries(); len(entries) != 0 {
+		t.Errorf("expected nothing recorded before the journal is enabled, got %v", entries)
+	}
+
+	EnableJournal(3)
+	PinMode(pin, Output)
+	DigitalWrite(pin, High)
+	DigitalRead(pin)
+	AnalogRead(ain)
+	DigitalWrite(99, High)
+
+	entries, dropped := JournalEntries()
+	if len(entries) != 3 || dropped != 2 {
+		t.Fatalf("expected the last 3 operations with 2 dropped, got %d with %d dropped", len(entries), dropped)
+	}
+	if entries[0].Op != "DigitalRead" || entries[0].Result != "1" || entries[1].Op != "AnalogRead" ||
+		entries[1].Result != "1" || entries[2].Op != "DigitalWrite" || entries[2].Args != "1" || entries[2].Err == nil {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	if entries[0].Target != PinName(pin) {
+		t.Errorf("expected entries to name the pin %s, got %s", PinName(pin), entries[0].Target)
+	}
+
+	var b bytes.Buffer
+	DumpJournal(&b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || lines[0] != "hwio journal: 3 operations (2 older dropped)" ||
+		!strings.Contains(lines[2], " AnalogRead "+PinName(ain)+" -> 1 (") {
+		t.Errorf("unexpected dump\n%s", b.String())
+	}
+
+	// a panic dumps the journal, and carries on
+	b.Reset()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("DumpJournalOnPanic should carry on panicking")
+			}
+		}()
+		defer DumpJournalOnPanic(&b)
+		panic("test")
+	}()
+	if !strings.HasPrefix(b.String(), "hwio journal: 3 operations") {
+		t.Errorf("expected the journal to be dumped on panic, got '%s'", b.String())
+	}
+
+	// disabling keeps what was recorded
+	DisableJournal()
+	DigitalWrite(pin, Low)
+	if entries, _ = JournalEntries(); len(entries) != 3 || entries[2].Op != "DigitalWrite" || entries[2].Args != "1" {
+		t.Errorf("expected recording to stop when disabled, got %v", entries)
+	}
+}
+
+func TestSimulatePin(t *testing.T) {
+	SetDriver(new(TestDriver))
+	fake := NewFakeClock(time.Now())
+	SetClock(fake)
+	defer SetClock(nil)
+
+	button, _ := GetPin("gpio1")
+	relay, _ := GetPin("gpio2")
+	level, _ := GetPin("ain4")
+	real, _ := GetPin("gpio3")
+	defer func() {
+		for _, pin := range SimulatedPins() {
+			StopSimulatingPin(pin)
+		}
+	}()
+
+	if e := SimulatePin(button, PinSimulation{Value: High}); e != nil {
+		t.Fatalf("SimulatePin returned error '%s'", e)
+	}
+	var written []int
+	SimulatePin(relay, PinSimulation{Write: func(value int) { written = append(written, value) }})
+	SimulatePin(level, PinSimulation{Read: func(elapsed time.Duration) int { return 500 + int(elapsed/time.Second) }})
+	if e := SimulatePin(999, PinSimulation{}); e == nil {
+		t.Error("expected an error simulating a pin the board doesn't have")
+	}
+	if pins := SimulatedPins(); len(pins) != 3 || pins[0] != button || pins[2] != level {
+		t.Errorf("unexpected simulated pins %v", pins)
+	}
+
+	// the simulated button reads as set, and as changed
+	PinMode(button, Input)
+	if v, e := DigitalRead(button); e != nil || v != High {
+		t.Errorf("expected the simulated button to read high, got %d, %v", v, e)
+	}
+	SetSimulatedValue(button, Low)
+	if v, _ := DigitalRead(button); v != Low {
+		t.Errorf("expected the simulated button to read low after SetSimulatedValue, got %d", v)
+	}
+	if e := DigitalWrite(button, High); e == nil {
+		t.Error("expected an error writing to a simulated input")
+	}
+
+	// writes to the relay are held, and passed on
+	PinModeOutputInit(relay, High)
+	DigitalWrite(relay, Low)
+	if v, _ := DigitalRead(relay); v != Low || len(written) != 2 || written[0] != High || written[1] != Low {
+		t.Errorf("expected the relay to hold its writes, got %d after %v", v, written)
+	}
+
+	// the level follows its function
+	fake.Advance(3 * time.Second)
+	if v, e := AnalogRead(level); e != nil || v != 503 {
+		t.Errorf("expected the simulated level to read 503, got %d, %v", v, e)
+	}
+
+	// other pins use the hardware
+	if e := PinMode(real, Output); e != nil {
+		t.Errorf("PinMode on a pin that isn't simulated returned error '%s'", e)
+	}
+	if e := SetSimulatedValue(real, High); e == nil {
+		t.Error("expected an error setting the value of a pin that isn't simulated")
+	}
+
+	// simulated pins are configured without a GPIO module, and hold the electrical level of active-low outputs
+	d := new(TestDriver)
+	b, e := NewBoard(d)
+	if e != nil {
+		t.Fatalf("NewBoard returned an error: %s", e)
+	}
+	gpio := d.modules["gpio"]
+	delete(d.modules, "gpio")
+	defer func() {
+		d.modules["gpio"] = gpio
+		b.Close()
+	}()
+	b.SimulatePin(relay, PinSimulation{})
+	if e := b.PinModeConfig(relay, NewPinConfig(Output, WithActiveLow(), WithInitialValue(High))); e != nil {
+		t.Fatalf("PinModeConfig on a simulated pin of a board without GPIO returned error '%s'", e)
+	}
+	if v, _ := b.DigitalRead(relay); v != High || b.simulated[relay].Value != Low {
+		t.Errorf("expected the active-low relay to read high at electrical low, got %d at %d", v, b.simulated[relay].Value)
+	}
+	b.DigitalWrite(relay, Low)
+	if v, _ := b.DigitalRead(relay); v != Low || b.simulated[relay].Value != High {
+		t.Errorf("expected the active-low relay to read low at electrical high, got %d at %d", v, b.simulated[relay].Value)
+	}
+	b.PinModeConfig(relay, NewPinConfig(Output, WithActiveLow()))
+	if b.simulated[relay].Value != High {
+		t.Error("expected an active-low output without an initial value to start deasserted")
+	}
+	if e := b.PinModeConfig(real, NewPinConfig(Output)); e == nil {
+		t.Error("expected an error configuring a pin that isn't simulated on a board without GPIO")
+	}
+}
+
+func TestI2CBusRecover(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	dir, e := ioutil.TempDir("", "hwio-i2c")
+	if e != nil {
+		t.Fatalf("could not create temporary directory: %s", e)
+	}
+	defer os.RemoveAll(dir)
+
+	// /sys/bus/i2c/devices/i2c-1 is the adapter of controller fe804000.i2c, whose driver is i2c-bcm2835
+	savedPath := i2cDevicesPath
+	i2cDevicesPath = filepath.Join(dir, "devices")
+	defer func() {
+		i2cDevicesPath = savedPath
+	}()
+	controller := filepath.Join(dir, "platform", "fe804000.i2c")
+	driverDir := filepath.Join(dir, "drivers", "i2c-bcm2835")
+	os.MkdirAll(filepath.Join(controller, "i2c-1"), 0755)
+	os.MkdirAll(driverDir, 0755)
+	os.MkdirAll(i2cDevicesPath, 0755)
+	os.Symlink(filepath.Join(controller, "i2c-1"), filepath.Join(i2cDevicesPath, "i2c-1"))
+	os.Symlink(driverDir, filepath.Join(controller, "driver"))
+	ioutil.WriteFile(filepath.Join(driverDir, "bind"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(driverDir, "unbind"), nil, 0644)
+	device := filepath.Join(dir, "i2c-1")
+	ioutil.WriteFile(device, nil, 0644)
+	chip := filepath.Join(dir, "gpiochip0")
+	ioutil.WriteFile(chip, nil, 0644)
+
+	// a device holds SDA low until SCL has risen three times
+	var journal []string
+	defer fakeGPIOIoctls(t, &journal)()
+	fake := gpioIoctl
+	rises, scl := 0, uint64(1)
+	gpioIoctl = func(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+		if request == gpioV2LineSetValuesIoctl {
+			bits := (*gpioV2LineValues)(arg).bits
+			if scl == 0 && bits&2 != 0 {
+				rises++
+			}
+			scl = bits & 2 >> 1
+		}
+		e := fake(fd, request, arg)
+		if request == gpioV2LineGetValuesIoctl {
+			lv := (*gpioV2LineValues)(arg)
+			lv.bits = 2
+			if rises >= 3 {
+				lv.bits = 3
+			}
+		}
+		return e
+	}
+
+	i2c := NewDTI2CModule("i2c")
+	i2c.SetOptions(map[string]interface{}{"pins": DTI2CModulePins{}, "device": device})
+	if e = i2c.Enable(); e != nil {
+		t.Fatalf("could not enable the I2C module: %s", e)
+	}
+	defer i2c.Disable()
+	if e = i2c.BusRecover(); e == nil {
+		t.Error("expected an error recovering a bus whose GPIO lines aren't known")
+	}
+
+	i2c.SetOptions(map[string]interface{}{"pins": DTI2CModulePins{}, "device": device,
+		"recovery": DTI2CRecoveryLines{Chip: chip, SDA: 2, SCL: 3}})
+	var recovery I2CBusRecoveryModule = i2c
+	if e = recovery.BusRecover(); e != nil {
+		t.Fatalf("BusRecover returned error '%s'", e)
+	}
+	if rises != 4 {
+		t.Errorf("expected SCL to be clocked until SDA was released after 3 clocks, then once for the STOP, got %d rises", rises)
+	}
+	for _, name := range []string{"unbind", "bind"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(driverDir, name)); string(b) != "fe804000.i2c" {
+			t.Errorf("expected the controller to be written to %s, got '%s'", name, b)
+		}
+	}
+	if i2c.fd == nil {
+		t.Error("expected the adapter to be opened again")
+	}
+	if len(journal) == 0 || journal[0] != "get line [2 3] consumer=\"hwio\" flags=0x48 attr(id=2 value=0x3 mask=0x3)" {
+		t.Errorf("expected SDA and SCL to be requested as open-drain outputs, got %v", journal)
+	}
+	// the last values set are a STOP: SDA low then high, with SCL high
+	n := len(journal)
+	if n < 3 || journal[n-3] != "set values bits=0x0 mask=0x3" || journal[n-2] != "set values bits=0x2 mask=0x3" ||
+		journal[n-1] != "set values bits=0x3 mask=0x3" {
+		t.Errorf("expected the recovery to end with a STOP, got %v", journal)
+	}
+
+	// a device that never lets go
+	rises = -100
+	if e = i2c.BusRecover(); e != ErrI2CBusStuck {
+		t.Errorf("expected ErrI2CBusStuck for a bus that stays stuck, got '%v'", e)
+	}
+}
+
+// An I2C module whose devices fail with an error a number of times before each transfer succeeds.
+type flakyI2CModule struct {
+	failures int
+	err      error
+	attempts int
+}
+
+func (m *flakyI2CModule) SetOptions(map[string]interface{}) error { return nil }
+func (m *flakyI2CModule) Enable() error                           { return nil }
+func (m *flakyI2CModule) Disable() error                          { return nil }
+func (m *flakyI2CModule) GetName() string                         { return "flaky" }
+func (m *flakyI2CModule) GetDevice(address int) I2CDevice         { return m }
+
+func (m *flakyI2CModule) transfer() error {
+	m.attempts++
+	if m.attempts <= m.failures {
+		return m.err
+	}
+	return nil
+}
+
+func (m *flakyI2CModule) ReadByte(command byte) (byte, error) { return 0x42, m.transfer() }
+func (m *flakyI2CModule) WriteByte(command byte, value byte) error {
+	return m.transfer()
+}
+func (m *flakyI2CModule) Read(command byte, numBytes int) ([]byte, error) {
+	return make([]byte, numBytes), m.transfer()
+}
+func (m *flakyI2CModule) Write(command byte, buffer []byte) error { return m.transfer() }
+
+func TestBusRetry(t *testing.T) {
+	if !IsTransientBusError(syscall.EREMOTEIO) || !IsTransientBusError(errors.New("SPI transfer failed: "+syscall.EAGAIN.Error())) ||
+		IsTransientBusError(syscall.EACCES) || IsTransientBusError(nil) {
+		t.Error("IsTransientBusError did not recognise transient errors")
+	}
+
+	flaky := &flakyI2CModule{failures: 2, err: syscall.EREMOTEIO}
+	policy := RetryPolicy{Attempts: 3, InitialDelay: time.Microsecond}
+	i2c := NewRetryI2CModule("test-i2c", flaky, policy)
+	device := i2c.GetDevice(0x48)
+
+	// a device that doesn't acknowledge twice is retried until it does
+	if v, e := device.ReadByte(0); e != nil || v != 0x42 || flaky.attempts != 3 {
+		t.Errorf("expected ReadByte to succeed on the third attempt, got %#x, %v after %d", v, e, flaky.attempts)
+	}
+
+	// one that fails more often than the policy allows gives up, with the device's error
+	flaky.attempts, flaky.failures = 0, 10
+	if e := device.WriteByte(0, 1); e != syscall.EREMOTEIO || flaky.attempts != 4 {
+		t.Errorf("expected WriteByte to fail after 4 attempts, got %v after %d", e, flaky.attempts)
+	}
+
+	// errors that aren't transient aren't retried
+	flaky.attempts, flaky.err = 0, syscall.EACCES
+	if e := device.Write(0, []byte{1}); e != syscall.EACCES || flaky.attempts != 1 {
+		t.Errorf("expected Write to fail straight away, got %v after %d", e, flaky.attempts)
+	}
+
+	// a device's own policy
+	flaky.attempts, flaky.err = 0, syscall.EAGAIN
+	i2c.SetDevicePolicy(0x48, RetryPolicy{Attempts: 20})
+	if _, e := device.Read(0, 2); e != nil || flaky.attempts != 11 {
+		t.Errorf("expected Read to succeed on the 11th attempt with the device's policy, got %v after %d", e, flaky.attempts)
+	}
+
+	expected := BusRetryCounts{Transfers: 4, Errors: 2 + 4 + 1 + 10, Retries: 2 + 3 + 10, Failures: 2}
+	if c := i2c.Counts(0x48); c != expected {
+		t.Errorf("expected counts %+v, got %+v", expected, c)
+	}
+	if c := BusRetryCounters()["test-i2c 0x48"]; c != expected {
+		t.Errorf("expected BusRetryCounters to list the device's counts %+v, got %+v", expected, c)
+	}
+}
+
+func TestBusMonitor(t *testing.T) {
+	fake := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	SetClock(fake)
+	defer SetClock(nil)
+
+	monitor, e := NewBusMonitor(BusMonitorConfig{Interval: time.Second})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	flaky := &flakyI2CModule{err: syscall.EREMOTEIO}
+	monitor.AddDevice("sensor", I2CWhoAmI(flaky.GetDevice(0x48), 0x0f, 0x42))
+	monitor.AddDevice("wrong", I2CWhoAmI(flaky.GetDevice(0x49), 0x0f, 0x33))
+
+	expect := func(device string, present bool) {
+		t.Helper()
+		select {
+		case event := <-monitor.Events():
+			if event.Device != device || event.Present != present || (event.Err == nil) != present {
+				t.Errorf("expected %s present=%v, got %+v", device, present, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected an event for %s", device)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case event := <-monitor.Events():
+			t.Errorf("expected no event, got %+v", event)
+		default:
+		}
+	}
+
+	// the first check gives each device's state; a device with the wrong identity isn't present
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	first := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-monitor.Events():
+			first[event.Device] = event.Present
+		case <-time.After(time.Second):
+			t.Fatal("expected an event for each device from the first check")
+		}
+	}
+	if !first["sensor"] || first["wrong"] {
+		t.Errorf("expected sensor present and wrong missing, got %v", first)
+	}
+	monitor.RemoveDevice("wrong")
+
+	// a single failure isn't reported, two in a row are
+	flaky.attempts, flaky.failures = 0, 1
+	monitor.Check()
+	expectNone()
+	flaky.attempts, flaky.failures = 0, 3
+	monitor.Check()
+	monitor.Check()
+	expect("sensor", false)
+	if monitor.Present("sensor") || len(monitor.Missing()) != 1 {
+		t.Errorf("expected sensor to be missing, got %v", monitor.Missing())
+	}
+
+	// it is reported once, and again when it returns
+	monitor.Check()
+	expectNone()
+	flaky.attempts, flaky.failures = 0, 0
+	monitor.Check()
+	expect("sensor", true)
+	if !monitor.Present("sensor") {
+		t.Error("expected sensor to be present again")
+	}
+
+	monitor.Close()
+	if _, ok := <-monitor.Events(); ok {
+		t.Error("expected Close to close the events channel")
+	}
+}
+
+func TestIOScheduler(t *testing.T) {
+	before := GetIOSchedulerStats()
+
+	// a bulk operation waits for a critical one that is running
+	started, release := make(chan bool), make(chan bool)
+	criticalDone := make(chan error)
+	go func() {
+		criticalDone <- runCriticalIO(func() error {
+			close(started)
+			<-release
+			return errors.New("critical")
+		})
+	}()
+	<-started
+
+	var order []string
+	var lock sync.Mutex
+	bulkDone := make(chan error)
+	go func() {
+		bulkDone <- runBulkIO(func() error {
+			lock.Lock()
+			order = append(order, "bulk")
+			lock.Unlock()
+			return nil
+		})
+	}()
+	time.Sleep(2 * time.Millisecond)
+	lock.Lock()
+	order = append(order, "critical")
+	lock.Unlock()
+	close(release)
+
+	if e := <-criticalDone; e == nil || e.Error() != "critical" {
+		t.Errorf("expected the critical operation's error, got %v", e)
+	}
+	if e := <-bulkDone; e != nil {
+		t.Error(e)
+	}
+	if len(order) != 2 || order[0] != "critical" {
+		t.Errorf("expected the bulk operation to wait for the critical one, got %v", order)
+	}
+
+	stats := GetIOSchedulerStats()
+	if stats.Critical != before.Critical+1 || stats.Bulk != before.Bulk+1 || stats.BulkDelay <= before.BulkDelay {
+		t.Errorf("expected one more of each operation, and the bulk one delayed, got %+v after %+v", stats, before)
+	}
+
+	if e := SetIOThreadPriority(100); e == nil {
+		t.Error("expected an error for a priority out of range")
+	}
+	if e := SetIOThreadPriority(0); e != nil {
+		t.Errorf("expected the normal priority to be set, got %v", e)
+	}
+}
+
+func TestRealtimeCPUConfig(t *testing.T) {
+	if cpus, e := ParseCPUList("domain,managed_irq,1-3,5\n"); e != nil || FormatCPUList(cpus) != "1-3,5" {
+		t.Errorf("expected CPUs 1-3,5, got %v, %v", cpus, e)
+	}
+	if _, e := ParseCPUList("3-1"); e == nil {
+		t.Error("expected an error for a backwards range")
+	}
+
+	dir, e := ioutil.TempDir("", "hwio-cpu")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	defer func(cpu string, cmdline string) {
+		cpuSysfsPath, kernelCmdlinePath = cpu, cmdline
+	}(cpuSysfsPath, kernelCmdlinePath)
+	cpuSysfsPath, kernelCmdlinePath = dir, filepath.Join(dir, "cmdline")
+
+	files := map[string]string{
+		"online":                        "0-3\n",
+		"isolated":                      "3\n",
+		"nohz_full":                     "2-3\n",
+		"cpu1/cpufreq/scaling_governor": "ondemand\n",
+		"cpu3/cpufreq/scaling_governor": "performance\n",
+		"cmdline":                       "console=serial0,115200 isolcpus=3 nohz_full=2-3 rcu_nocbs=3 irqaffinity=0-2 quiet\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	config, e := GetRealtimeCPUConfig()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if FormatCPUList(config.Online) != "0-3" || FormatCPUList(config.RCUNoCallbacks) != "3" ||
+		FormatCPUList(config.IRQAffinity) != "0-2" || config.Governors[1] != "ondemand" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if problems := config.Check(3); len(problems) != 0 {
+		t.Errorf("expected CPU 3 to be set up well, got %v", problems)
+	}
+	if problems := config.Check(1); len(problems) != 5 {
+		t.Errorf("expected 5 problems with CPU 1, got %v", problems)
+	}
+	if problems := config.Check(7); len(problems) != 1 || problems[0] != "not online" {
+		t.Errorf("expected CPU 7 to be offline, got %v", problems)
+	}
+
+	// pinning to every CPU changes nothing, but exercises the system call
+	all := make([]int, runtime.NumCPU())
+	for i := range all {
+		all[i] = i
+	}
+	if e := SetIOThreadCPUs(all...); e != nil {
+		t.Error(e)
+	}
+	if e := SetIOThreadCPUs(); e == nil {
+		t.Error("expected an error pinning to no CPUs")
+	}
+}
+
+func TestPeriodicTask(t *testing.T) {
+	if _, e := NewPeriodicTask(PeriodicTaskConfig{}, func() {}); e == nil {
+		t.Error("expected an error for a task without a period")
+	}
+
+	var runs int32
+	var missed int32
+	task, e := NewPeriodicTask(PeriodicTaskConfig{
+		Period: time.Millisecond,
+		OnOverrun: func(n int) {
+			atomic.AddInt32(&missed, int32(n))
+		},
+	}, func() {
+		// the fifth run takes several periods, so some are missed
+		if atomic.AddInt32(&runs, 1) == 5 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if e := task.Stop(); e != nil {
+		t.Error(e)
+	}
+	n := atomic.LoadInt32(&runs)
+	if e := task.Stop(); e != nil || atomic.LoadInt32(&runs) != n {
+		t.Errorf("expected Stop to be safe to call again, and the task stopped, got %v", e)
+	}
+
+	stats := task.Stats()
+	if stats.Runs != uint64(n) || n < 5 || n > 60 {
+		t.Errorf("expected about 25 runs in 30ms, got %d and stats %+v", n, stats)
+	}
+	if stats.Overruns == 0 || stats.Missed < 3 || stats.Missed != uint64(atomic.LoadInt32(&missed)) {
+		t.Errorf("expected the slow run to miss periods, got %+v and %d reported", stats, missed)
+	}
+	if stats.MaxRunTime < 5*time.Millisecond || stats.MinLatency < 0 || stats.MaxLatency < stats.MeanLatency ||
+		stats.Jitter() != stats.MaxLatency-stats.MinLatency {
+		t.Errorf("unexpected stats %+v", stats)
+	}
 }
@@ -0,0 +1,181 @@
+package hwio
+
+// Recovering an I2C bus whose SDA line is stuck low. This happens when a device is reset or hot-plugged part way
+// through a transfer: it is left waiting to clock out the rest of a byte, and holds SDA low, so the controller can't
+// start another transfer and every access fails with a timeout or "remote I/O error". The standard cure, from the
+// I2C specification, is to clock SCL up to nine times until the device lets SDA go, then send a STOP.
+//
+// The controller has the bus pins, so BusRecover unbinds its kernel driver to free them, drives them as open-drain
+// GPIO lines through the character device, then binds the driver again, which sets the pins back to I2C and
+// recreates the adapter:
+//
+//	i2c, e := hwio.GetI2CModule("i2c")
+//	if r, ok := i2c.(hwio.I2CBusRecoveryModule); ok {
+//		e = r.BusRecover()
+//	}
+//
+// Known issues:
+// - only the Raspberry Pi driver gives its I2C module the GPIO lines of the bus; other drivers' modules need the
+//   "recovery" option set
+// - unbinding and binding the controller needs root, and devices on the bus that have kernel drivers are probed
+//   again when it is bound
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+// The GPIO lines of an I2C bus's SDA and SCL pins, for the "recovery" option of DTI2CModule.
+type DTI2CRecoveryLines struct {
+	// The GPIO character device, e.g. "/dev/gpiochip0", and the offsets of the lines on it
+	Chip string
+	SDA  int
+	SCL  int
+}
+
+// I2C modules that can recover a bus stuck by a device implement this interface.
+type I2CBusRecoveryModule interface {
+	I2CModule
+
+	// Clock the bus until SDA is released, and send a STOP, if SDA is held low. The adapter is reinitialised
+	// either way. Returns ErrI2CBusStuck if SDA is still held low after nine clocks.
+	BusRecover() error
+}
+
+// Returned by BusRecover if a device still holds SDA low after recovery.
+var ErrI2CBusStuck = errors.New("I2C bus SDA is still held low after recovery")
+
+// How many times SCL is clocked to release SDA, and the time SCL spends low and high on each, about 100kHz.
+const (
+	i2cRecoveryClocks    = 9
+	i2cRecoveryHalfClock = 5
+)
+
+// How long to wait for the adapter's device file to appear after the controller's driver is bound.
+var i2cAdapterTimeout = time.Second
+
+// Recover the bus, as described by I2CBusRecoveryModule. Needs the "recovery" option.
+func (module *DTI2CModule) BusRecover() error {
+	if module.recovery == nil {
+		return fmt.Errorf("module '%s' does not know the GPIO lines of its bus, so can't recover it", module.GetName())
+	}
+
+	module.Lock()
+	defer module.Unlock()
+
+	controller, driverDir, e := i2cControllerDriver(module.deviceFile)
+	if e != nil {
+		return e
+	}
+
+	if module.fd != nil {
+		module.fd.Close()
+		module.fd = nil
+	}
+	e = ioutil.WriteFile(filepath.Join(driverDir, "unbind"), []byte(controller), 0200)
+	if e != nil {
+		return fmt.Errorf("could not unbind I2C controller %s: %s", controller, e)
+	}
+
+	recoverError := clockI2CBus(module.recovery)
+
+	e = ioutil.WriteFile(filepath.Join(driverDir, "bind"), []byte(controller), 0200)
+	if e != nil {
+		return fmt.Errorf("could not bind I2C controller %s again: %s", controller, e)
+	}
+	module.fd, e = openI2CAdapter(module.deviceFile)
+	if e != nil {
+		return e
+	}
+	return recoverError
+}
+
+// Return the name of the controller of an adapter, e.g. "fe804000.i2c" for "/dev/i2c-1", and the directory of its
+// driver, which has the bind and unbind files.
+func i2cControllerDriver(deviceFile string) (string, string, error) {
+	adapter, e := filepath.EvalSymlinks(filepath.Join(i2cDevicesPath, filepath.Base(deviceFile)))
+	if e != nil {
+		return "", "", fmt.Errorf("could not find the controller of %s: %s", deviceFile, e)
+	}
+	controllerDir := filepath.Dir(adapter)
+	driverDir, e := filepath.EvalSymlinks(filepath.Join(controllerDir, "driver"))
+	if e != nil {
+		return "", "", fmt.Errorf("could not find the driver of I2C controller %s: %s", filepath.Base(controllerDir), e)
+	}
+	return filepath.Base(controllerDir), driverDir, nil
+}
+
+// Open the adapter's device file, waiting for udev to create it and set its permissions.
+func openI2CAdapter(deviceFile string) (*os.File, error) {
+	deadline := time.Now().Add(i2cAdapterTimeout)
+	for {
+		fd, e := os.OpenFile(deviceFile, os.O_RDWR, os.ModeExclusive)
+		if e == nil || (!os.IsNotExist(e) && !os.IsPermission(e)) || time.Now().After(deadline) {
+			return fd, e
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Clock SCL until SDA is released, then send a STOP, with the lines as open-drain outputs.
+func clockI2CBus(lines *DTI2CRecoveryLines) error {
+	released := PinConfig{Mode: Output, Drive: DriveOpenDrain, InitialValue: High, UseInitialValue: true}
+	lc, e := cdevLineConfigFor([]PinConfig{released, released}, []Edge{EdgeNone, EdgeNone}, 3)
+	if e != nil {
+		return e
+	}
+	f, e := cdevRequestLines(lines.Chip, []int{lines.SDA, lines.SCL}, lc)
+	if e != nil {
+		return fmt.Errorf("could not request the I2C bus lines %s %d and %d: %s", lines.Chip, lines.SDA, lines.SCL, e)
+	}
+	req := &cdevLineRequest{file: f}
+	defer f.Close()
+
+	// bit 0 is SDA and bit 1 is SCL
+	set := func(sda int, scl int) error {
+		lv := gpioV2LineValues{bits: uint64(sda | scl<<1), mask: 3}
+		e := req.ioctl(gpioV2LineSetValuesIoctl, unsafe.Pointer(&lv))
+		DelayMicroseconds(i2cRecoveryHalfClock)
+		return e
+	}
+	get := func() (sda int, scl int, e error) {
+		lv := gpioV2LineValues{mask: 3}
+		e = req.ioctl(gpioV2LineGetValuesIoctl, unsafe.Pointer(&lv))
+		return int(lv.bits & 1), int(lv.bits >> 1 & 1), e
+	}
+
+	sda, scl, e := get()
+	if e != nil {
+		return e
+	}
+	if scl == Low {
+		return errors.New("I2C bus SCL is held low, which recovery can't clear")
+	}
+	for i := 0; i < i2cRecoveryClocks && sda == Low; i++ {
+		if e = set(High, Low); e == nil {
+			e = set(High, High)
+		}
+		if e == nil {
+			sda, _, e = get()
+		}
+		if e != nil {
+			return e
+		}
+	}
+	if sda == Low {
+		return ErrI2CBusStuck
+	}
+
+	// a STOP is SDA rising while SCL is high
+	for _, v := range [][2]int{{High, Low}, {Low, Low}, {Low, High}, {High, High}} {
+		if e = set(v[0], v[1]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package hwio
+
+// Reading several channels of an IIO ADC in one scan, through the device's buffer. The channels to read are enabled
+// in scan_elements and the buffer is enabled, and the ADC then samples all of them together when triggered, and
+// delivers them as one record on the device's character device (/dev/iio:deviceN), with a timestamp if the device
+// has one. This is how AnalogReadMulti gets samples of several channels microseconds apart rather than the time of
+// several sysfs reads.
+//
+// Known issues:
+// - many ADCs only scan when a trigger is set in trigger/current_trigger, e.g. an hrtimer trigger created through
+//   configfs; without one the channels are read one after another
+// - the buffer is enabled for each scan and disabled afterwards, which takes a few sysfs writes, so a scan is slower
+//   than reading one channel, though its samples are closer together
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location of the IIO character devices. This is a variable so tests can point it elsewhere.
+var iioCharDevicesPath = "/dev"
+
+// How long to wait for the ADC to deliver a scan.
+const iioScanTimeout = 100 * time.Millisecond
+
+// The error for a device that can't scan, because it has no buffer, a channel isn't in its scans, or it has no
+// trigger set.
+var errIIONoScan = errors.New("IIO device can't scan these channels")
+
+// The format of a value in a scan, from its scan_elements type, e.g. "le:s12/16>>4" is a little-endian signed
+// 12-bit value stored in 16 bits, 4 bits up.
+type iioScanType struct {
+	bigEndian   bool
+	signed      bool
+	bits        uint
+	storageBits uint
+	shift       uint
+}
+
+// An element of a scan: a channel that is enabled, and where its value is.
+type iioScanElement struct {
+	name   string
+	index  int
+	format iioScanType
+	offset int
+}
+
+func parseIIOScanType(s string) (iioScanType, error) {
+	var t iioScanType
+	s = strings.TrimSpace(s)
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || len(parts[1]) < 2 {
+		return t, fmt.Errorf("bad IIO scan type '%s'", s)
+	}
+	t.bigEndian = parts[0] == "be"
+	t.signed = parts[1][0] == 's'
+
+	// e.g. "12/16>>4", where the storage may be followed by a repeat, "16X2", which isn't supported
+	var e error
+	format := parts[1][1:]
+	shift := "0"
+	if i := strings.Index(format, ">>"); i >= 0 {
+		format, shift = format[:i], format[i+2:]
+	}
+	sizes := strings.SplitN(format, "/", 2)
+	if len(sizes) != 2 || strings.Contains(sizes[1], "X") {
+		return t, fmt.Errorf("bad IIO scan type '%s'", s)
+	}
+	var n [3]uint64
+	for i, v := range []string{sizes[0], sizes[1], shift} {
+		if n[i], e = strconv.ParseUint(v, 10, 8); e != nil {
+			return t, fmt.Errorf("bad IIO scan type '%s'", s)
+		}
+	}
+	t.bits, t.storageBits, t.shift = uint(n[0]), uint(n[1]), uint(n[2])
+	if t.bits == 0 || t.bits > t.storageBits || t.storageBits%8 != 0 || t.storageBits > 64 {
+		return t, fmt.Errorf("bad IIO scan type '%s'", s)
+	}
+	return t, nil
+}
+
+// Decode a value from the start of b, which must hold its storage.
+func (t iioScanType) decode(b []byte) int64 {
+	size := int(t.storageBits / 8)
+	var v uint64
+	for i := 0; i < size; i++ {
+		if t.bigEndian {
+			v = v<<8 | uint64(b[i])
+		} else {
+			v |= uint64(b[i]) << uint(8*i)
+		}
+	}
+	v = (v >> t.shift) & (1<<t.bits - 1)
+	if t.signed && v&(1<<(t.bits-1)) != 0 {
+		return int64(v) - 1<<t.bits
+	}
+	return int64(v)
+}
+
+// Place the elements of a scan in order of index, each aligned to its own size as the kernel does, returning the
+// size of a scan.
+func layoutIIOScan(elements []*iioScanElement) int {
+	sort.Slice(elements, func(i, j int) bool { return elements[i].index < elements[j].index })
+	offset, largest := 0, 1
+	for _, el := range elements {
+		size := int(el.format.storageBits / 8)
+		offset = (offset + size - 1) / size * size
+		el.offset = offset
+		offset += size
+		if size > largest {
+			largest = size
+		}
+	}
+	// scans are padded to the alignment of their largest element
+	return (offset + largest - 1) / largest * largest
+}
+
+// Read the index and format of a scan element, e.g. "in_voltage2".
+func readIIOScanElement(fsys SysfsFS, scanPath string, name string) (*iioScanElement, error) {
+	index, e := sysfsReadFile(fsys, scanPath+"/"+name+"_index")
+	if e != nil {
+		return nil, errIIONoScan
+	}
+	format, e := sysfsReadFile(fsys, scanPath+"/"+name+"_type")
+	if e != nil {
+		return nil, errIIONoScan
+	}
+	el := &iioScanElement{name: name}
+	if el.index, e = strconv.Atoi(strings.TrimSpace(string(index))); e != nil {
+		return nil, fmt.Errorf("bad IIO scan index '%s' for %s", index, name)
+	}
+	if el.format, e = parseIIOScanType(string(format)); e != nil {
+		return nil, e
+	}
+	return el, nil
+}
+
+// Read one scan of some voltage channels of an IIO device, returning their values by channel and when the scan
+// was taken, on the clock of MonotonicNow. Returns errIIONoScan if the device can't scan them.
+func readIIOScan(fsys SysfsFS, devicePath string, channels []int) (map[int]int, time.Duration, error) {
+	scanPath := devicePath + "/scan_elements"
+	if !sysfsExists(fsys, scanPath) {
+		return nil, 0, errIIONoScan
+	}
+	if trigger, e := sysfsReadFile(fsys, devicePath+"/trigger/current_trigger"); e == nil && strings.TrimSpace(string(trigger)) == "" {
+		return nil, 0, errIIONoScan
+	}
+
+	var elements []*iioScanElement
+	byChannel := make(map[int]*iioScanElement)
+	for _, channel := range channels {
+		if byChannel[channel] != nil {
+			continue
+		}
+		el, e := readIIOScanElement(fsys, scanPath, fmt.Sprintf("in_voltage%d", channel))
+		if e != nil {
+			return nil, 0, e
+		}
+		elements = append(elements, el)
+		byChannel[channel] = el
+	}
+
+	// the timestamp is on the monotonic clock if the device lets it be set; otherwise the scan is timestamped
+	// when it's read
+	var timestamp *iioScanElement
+	if el, e := readIIOScanElement(fsys, scanPath, "in_timestamp"); e == nil && el.format.storageBits == 64 {
+		clockPath := devicePath + "/current_timestamp_clock"
+		if old, e := sysfsReadFile(fsys, clockPath); e == nil && sysfsWriteString(fsys, clockPath, "monotonic") == nil {
+			defer sysfsWriteString(fsys, clockPath, strings.TrimSpace(string(old)))
+			timestamp = el
+			elements = append(elements, el)
+		}
+	}
+	size := layoutIIOScan(elements)
+
+	// enable just the elements wanted, putting back any that were enabled when done
+	wanted := make(map[string]bool)
+	for _, el := range elements {
+		wanted[scanPath+"/"+el.name+"_en"] = true
+	}
+	for _, en := range sysfsMatches(fsys, scanPath+"/*_en") {
+		old, _ := sysfsReadFile(fsys, en)
+		was := strings.TrimSpace(string(old)) == "1"
+		if was == wanted[en] {
+			continue
+		}
+		value := "0"
+		if wanted[en] {
+			value = "1"
+		}
+		if e := sysfsWriteString(fsys, en, value); e != nil {
+			return nil, 0, fmt.Errorf("could not enable IIO scan element %s: %s", en, e)
+		}
+		defer sysfsWriteString(fsys, en, strings.TrimSpace(string(old)))
+	}
+
+	// the buffer is enabled only while reading, so the scan is a fresh one
+	buffer := devicePath + "/buffer/enable"
+	if e := sysfsWriteString(fsys, buffer, "1"); e != nil {
+		return nil, 0, errIIONoScan
+	}
+	defer sysfsWriteString(fsys, buffer, "0")
+
+	dev, e := os.Open(filepath.Join(iioCharDevicesPath, filepath.Base(devicePath)))
+	if e != nil {
+		return nil, 0, e
+	}
+	defer dev.Close()
+	dev.SetReadDeadline(time.Now().Add(iioScanTimeout))
+	scan := make([]byte, size)
+	if _, e = io.ReadFull(dev, scan); e != nil {
+		return nil, 0, fmt.Errorf("could not read an IIO scan: %s", e)
+	}
+	taken := MonotonicNow()
+	if timestamp != nil {
+		taken = time.Duration(timestamp.format.decode(scan[timestamp.offset:]))
+	}
+
+	values := make(map[int]int)
+	for channel, el := range byChannel {
+		values[channel] = int(el.format.decode(scan[el.offset:]))
+	}
+	return values, taken, nil
+}
@@ -0,0 +1,157 @@
+package hwio
+
+// Scheduling IO by priority, to reduce the jitter of time-critical operations when the system is busy. Critical
+// operations, such as the edges of software PWM and bit-banged protocols, run one at a time on a dedicated goroutine
+// locked to its OS thread, which can be given a real-time priority with SetIOThreadPriority. Bulk operations, such
+// as the reads of a data logger, run on the goroutine that asks for them, but wait while critical operations are
+// queued or running, so they don't compete with them for the CPU or a module's lock:
+//
+//	hwio.SetIOThreadPriority(50)  // needs root or CAP_SYS_NICE
+//
+// Known issues:
+// - critical operations run one at a time, so a long one, e.g. a software serial write, delays the edges of
+//   software PWM until it finishes
+// - bulk operations wait for at most ioBulkMaxDelay, so they aren't starved, and a bulk operation that has started
+//   isn't interrupted
+// - the signal generators and camera triggers lock their own threads rather than using the scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How long a bulk operation waits for critical ones to finish before running anyway, and how often it looks.
+const (
+	ioBulkMaxDelay = 10 * time.Millisecond
+	ioBulkPoll     = 100 * time.Microsecond
+)
+
+// The scheduling policies of the IO thread, normal and real-time, from sched.h.
+const (
+	schedOther = 0
+	schedFIFO  = 1
+)
+
+// Counts of the IO run by the scheduler.
+type IOSchedulerStats struct {
+	// Critical and bulk operations run
+	Critical uint64
+	Bulk     uint64
+
+	// The longest a critical operation has waited for the IO thread, and the total time bulk operations have
+	// waited for critical ones
+	MaxCriticalDelay time.Duration
+	BulkDelay        time.Duration
+}
+
+// A critical operation waiting to run.
+type ioJob struct {
+	run    func() error
+	queued time.Time
+	done   chan error
+}
+
+type ioScheduler struct {
+	jobs  chan ioJob
+	start sync.Once
+
+	// critical operations queued or running
+	pending int32
+
+	// guards stats
+	sync.Mutex
+	stats IOSchedulerStats
+}
+
+var scheduler = ioScheduler{jobs: make(chan ioJob, 16)}
+
+// Run a critical operation on the IO thread, waiting for it to finish. It must not ask for IO itself.
+func runCriticalIO(f func() error) error {
+	return scheduler.critical(f)
+}
+
+// Run a bulk operation, once critical operations have finished.
+func runBulkIO(f func() error) error {
+	return scheduler.bulk(f)
+}
+
+// Run time-critical IO with the real-time FIFO scheduling policy at a priority of 1 to 99, ahead of all normal
+// threads, or with the normal policy if priority is 0. Setting a real-time priority needs root or CAP_SYS_NICE.
+func SetIOThreadPriority(priority int) error {
+	if priority < 0 || priority > 99 {
+		return fmt.Errorf("IO thread priority must be 0 to 99, got %d", priority)
+	}
+	return runCriticalIO(func() error {
+		return setThreadPriority(priority)
+	})
+}
+
+// Set the priority of the calling thread, real-time if it is 1 to 99.
+func setThreadPriority(priority int) error {
+	policy := schedFIFO
+	if priority == 0 {
+		policy = schedOther
+	}
+	param := int32(priority)
+	// pid 0 is the calling thread
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return fmt.Errorf("could not set the thread priority to %d: %s", priority, errno)
+	}
+	return nil
+}
+
+// Return counts of the IO run by the scheduler so far.
+func GetIOSchedulerStats() IOSchedulerStats {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+	return scheduler.stats
+}
+
+func (s *ioScheduler) critical(f func() error) error {
+	s.start.Do(func() {
+		go s.run()
+	})
+	atomic.AddInt32(&s.pending, 1)
+	job := ioJob{run: f, queued: time.Now(), done: make(chan error, 1)}
+	s.jobs <- job
+	return <-job.done
+}
+
+func (s *ioScheduler) bulk(f func() error) error {
+	start := time.Now()
+	for atomic.LoadInt32(&s.pending) > 0 && time.Since(start) < ioBulkMaxDelay {
+		time.Sleep(ioBulkPoll)
+	}
+	delay := time.Since(start)
+
+	s.Lock()
+	s.stats.Bulk++
+	s.stats.BulkDelay += delay
+	s.Unlock()
+	return f()
+}
+
+// Run critical operations in turn, on a thread of their own, which is never given back to the runtime.
+func (s *ioScheduler) run() {
+	runtime.LockOSThread()
+	for job := range s.jobs {
+		delay := time.Since(job.queued)
+		e := job.run()
+
+		s.Lock()
+		s.stats.Critical++
+		if delay > s.stats.MaxCriticalDelay {
+			s.stats.MaxCriticalDelay = delay
+		}
+		s.Unlock()
+
+		atomic.AddInt32(&s.pending, -1)
+		job.done <- e
+	}
+}
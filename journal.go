@@ -0,0 +1,195 @@
+package hwio
+
+// An optional journal of hardware operations, kept in memory so the last ones before a failure can be seen
+// afterwards. When enabled, each pin operation (PinMode, DigitalWrite, DigitalRead, AnalogRead, ClosePin), each I2C
+// and SPI transfer, and each PWM setting is recorded with its time, arguments, result and how long it took. The
+// journal holds a fixed number of entries, dropping the oldest:
+//
+//	hwio.EnableJournal(1000)
+//	defer hwio.DumpJournalOnPanic(os.Stderr)
+//	...
+//	if e != nil {
+//		hwio.DumpJournal(os.Stderr)
+//	}
+//
+// Custom modules and devices can add their own operations with RecordJournalEvent.
+//
+// Known issues:
+// - only the DT I2C, DT SPI and sysfs PWM modules record their operations; other bus modules (USB adapters, the
+//   software serial and so on) do not, though their pins' GPIO operations are recorded
+// - the arguments of I2C and SPI transfers are recorded in full, so large transfers make large entries
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A recorded operation.
+type JournalEntry struct {
+	Time     time.Time
+	Duration time.Duration
+
+	// The operation, e.g. "DigitalWrite" or "i2c Write", and what it acted on, e.g. "GPIO17" or "/dev/i2c-1 0x48"
+	Op     string
+	Target string
+
+	// The arguments and result, formatted, e.g. "1" for a DigitalWrite of High, or "" if there are none
+	Args   string
+	Result string
+
+	// The error returned, or nil
+	Err error
+}
+
+func (entry JournalEntry) String() string {
+	s := entry.Time.Format("15:04:05.000000") + " " + entry.Op
+	if entry.Target != "" {
+		s += " " + entry.Target
+	}
+	if entry.Args != "" {
+		s += " " + entry.Args
+	}
+	switch {
+	case entry.Err != nil:
+		s += ": error: " + entry.Err.Error()
+	case entry.Result != "":
+		s += " -> " + entry.Result
+	}
+	return s + " (" + entry.Duration.String() + ")"
+}
+
+// The journal, as a ring buffer of entries.
+type eventJournal struct {
+	// guards entries, next, full and dropped
+	sync.Mutex
+	entries []JournalEntry
+	next    int
+	full    bool
+	dropped int
+}
+
+var journal eventJournal
+
+// Non-zero while the journal is enabled, so operations can skip recording cheaply when it isn't.
+var journalEnabled int32
+
+// Start recording operations, keeping the last size of them. Enabling the journal again clears it.
+func EnableJournal(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("journal size must be positive, got %d", size)
+	}
+	journal.Lock()
+	journal.entries = make([]JournalEntry, size)
+	journal.next = 0
+	journal.full = false
+	journal.dropped = 0
+	journal.Unlock()
+	atomic.StoreInt32(&journalEnabled, 1)
+	return nil
+}
+
+// Stop recording operations. The entries already recorded are kept, and can still be read and dumped, until the
+// journal is enabled again or ClearJournal is called.
+func DisableJournal() {
+	atomic.StoreInt32(&journalEnabled, 0)
+}
+
+// Discard the recorded entries and the count of dropped ones, without enabling or disabling the journal.
+func ClearJournal() {
+	journal.Lock()
+	defer journal.Unlock()
+	for i := range journal.entries {
+		journal.entries[i] = JournalEntry{}
+	}
+	journal.next = 0
+	journal.full = false
+	journal.dropped = 0
+}
+
+// Return true if the journal is recording operations.
+func JournalEnabled() bool {
+	return atomic.LoadInt32(&journalEnabled) != 0
+}
+
+// Record an operation that started at start. This does nothing if the journal is disabled. Custom modules call this
+// so their operations appear in the journal alongside hwio's own.
+func RecordJournalEvent(start time.Time, op string, target string, args string, result string, e error) {
+	if !JournalEnabled() {
+		return
+	}
+	entry := JournalEntry{Time: start, Duration: time.Since(start), Op: op, Target: target, Args: args, Result: result, Err: e}
+
+	journal.Lock()
+	defer journal.Unlock()
+	if len(journal.entries) == 0 {
+		return
+	}
+	if journal.full {
+		journal.dropped++
+	}
+	journal.entries[journal.next] = entry
+	journal.next++
+	if journal.next == len(journal.entries) {
+		journal.next = 0
+		journal.full = true
+	}
+}
+
+// Return the recorded operations, oldest first, and how many older ones were dropped to make room for them.
+func JournalEntries() ([]JournalEntry, int) {
+	journal.Lock()
+	defer journal.Unlock()
+	var result []JournalEntry
+	if journal.full {
+		result = append(result, journal.entries[journal.next:]...)
+	}
+	result = append(result, journal.entries[:journal.next]...)
+	return result, journal.dropped
+}
+
+// Write the recorded operations to w, one per line, oldest first.
+func DumpJournal(w io.Writer) error {
+	entries, dropped := JournalEntries()
+	_, e := fmt.Fprintf(w, "hwio journal: %d operations", len(entries))
+	if e == nil && dropped > 0 {
+		_, e = fmt.Fprintf(w, " (%d older dropped)", dropped)
+	}
+	if e == nil {
+		_, e = fmt.Fprintln(w)
+	}
+	for _, entry := range entries {
+		if e != nil {
+			break
+		}
+		_, e = fmt.Fprintln(w, entry)
+	}
+	return e
+}
+
+// If the program is panicking, write the recorded operations to w and carry on panicking. Defer this at the top of
+// main, or of a goroutine, as it only sees panics in the goroutine that defers it:
+//
+//	defer hwio.DumpJournalOnPanic(os.Stderr)
+func DumpJournalOnPanic(w io.Writer) {
+	if r := recover(); r != nil {
+		DumpJournal(w)
+		panic(r)
+	}
+}
+
+// Record an operation on a pin of a board.
+func (b *Board) recordPinEvent(start time.Time, op string, pin Pin, args string, result string, e error) {
+	RecordJournalEvent(start, op, b.PinName(pin), args, result, e)
+}
+
+// Format a pin value for the journal, or "" if the operation failed.
+func journalValue(value int, e error) string {
+	if e != nil {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
@@ -0,0 +1,104 @@
+package hwio
+
+// Advisory locking of pins between processes. hwio tracks which module each pin is assigned to, but only within
+// a process; two programs using the same pin would otherwise fight over it without either knowing. With pin
+// locking enabled, AssignPin takes an flock on a file per pin, e.g. /var/lock/hwio/pin17, and fails if another
+// process holds it. The locks are released by UnassignPin, or by the kernel if the process exits.
+//
+// Locks are advisory, so they only help between programs that enable them.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The directory lock files are kept in, if EnablePinLocking is given "".
+const DefaultPinLockDir = "/var/lock/hwio"
+
+// The directory of pin lock files, or "" if locking is not enabled.
+var pinLockDir string
+
+// Lock files currently held, by pin.
+var pinLocks = make(map[Pin]*os.File)
+
+// Enable advisory locking of pins against other processes, with lock files in dir, or DefaultPinLockDir if dir is
+// "". The directory is created if needed. Locking should be enabled before any pins are assigned; pins already
+// assigned are not locked.
+func EnablePinLocking(dir string) error {
+	if dir == "" {
+		dir = DefaultPinLockDir
+	}
+	e := os.MkdirAll(dir, 0777)
+	if e != nil {
+		return fmt.Errorf("could not create pin lock directory: %s", e)
+	}
+	pinLockDir = dir
+	return nil
+}
+
+// Disable pin locking, releasing any locks held.
+func DisablePinLocking() {
+	for pin := range pinLocks {
+		unlockPin(pin)
+	}
+	pinLockDir = ""
+}
+
+// Take the lock for a pin, returning an error if another process holds it. The lock file records the process and
+// module holding it, so the error can say who has the pin.
+func lockPin(pin Pin, module Module) error {
+	if pinLockDir == "" || pinLocks[pin] != nil {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s/pin%d", pinLockDir, pin)
+	f, e := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if e != nil {
+		return fmt.Errorf("could not open lock file for pin %d: %s", pin, e)
+	}
+
+	e = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if e != nil {
+		f.Close()
+		if e == syscall.EWOULDBLOCK {
+			return fmt.Errorf("pin %d is in use by another process%s", pin, describeLockHolder(name))
+		}
+		return fmt.Errorf("could not lock pin %d: %s", pin, e)
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), module.GetName())), 0)
+	pinLocks[pin] = f
+	return nil
+}
+
+// Release the lock for a pin, if it is held.
+func unlockPin(pin Pin) {
+	f := pinLocks[pin]
+	if f == nil {
+		return
+	}
+	delete(pinLocks, pin)
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// Describe the holder of a lock from the lock file, e.g. " (pid 1234, module gpio)".
+func describeLockHolder(name string) string {
+	b, e := ioutil.ReadFile(name)
+	if e != nil {
+		return ""
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return ""
+	}
+	if _, e := strconv.Atoi(fields[0]); e != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (pid %s, module %s)", fields[0], fields[1])
+}
@@ -0,0 +1,372 @@
+package hwio
+
+// A poor man's logic analyzer: a set of GPIO pins is recorded for a while, and the result written out as a VCD
+// file, which GTKWave and PulseView open, or a sigrok session file for PulseView, for looking at what is really
+// happening on the wires.
+//
+// Pins are recorded either from their edges, with the kernel's timestamps, which catches short pulses and costs
+// nothing while the pins are quiet but needs a GPIO module with edge detection, or by polling them at a fixed rate
+// with DigitalReadGroup, which works on any GPIO module and can record outputs too. Polling is only as fast as a
+// group read; with the cdev module that is one ioctl for all the pins.
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How a logic analyzer records its pins.
+type LogicCaptureMode int
+
+const (
+	LogicEdges LogicCaptureMode = iota
+	LogicPolling
+)
+
+type LogicAnalyzerConfig struct {
+	// The pins to record, up to 32. They must be set up with PinMode first.
+	Pins PinList
+
+	// The names of the pins in the files written. Defaults to the pins' names.
+	Names []string
+
+	Mode LogicCaptureMode
+
+	// The rate, in Hz, to poll at, and to write samples at in a sigrok file. Defaults to 1MHz for edges, which is
+	// only used for sigrok files, and must be given for polling.
+	SampleRate int
+}
+
+// A change in the levels of the pins, at a time since the start of the capture. Bit i of Levels is the level of
+// pin i.
+type LogicChange struct {
+	Time   time.Duration
+	Levels uint32
+}
+
+// A recording of a set of pins, as the levels at the start and each change after.
+type LogicCapture struct {
+	Names      []string
+	SampleRate int
+
+	// When the capture started, on the clock of MonotonicNow, and how long it ran for
+	Start    time.Duration
+	Duration time.Duration
+
+	Initial uint32
+	Changes []LogicChange
+
+	// Edges the kernel dropped, or poll samples that were missed because a read took too long.
+	Overflows uint64
+}
+
+// Record the pins for a time, returning when it's done.
+func CaptureLogic(config LogicAnalyzerConfig, duration time.Duration) (*LogicCapture, error) {
+	if len(config.Pins) == 0 || len(config.Pins) > 32 {
+		return nil, errors.New("logic analyzer needs between 1 and 32 pins")
+	}
+	if config.Names != nil && len(config.Names) != len(config.Pins) {
+		return nil, errors.New("logic analyzer needs a name for each pin")
+	}
+	if duration <= 0 {
+		return nil, errors.New("logic analyzer needs a time to capture for")
+	}
+	if config.SampleRate <= 0 {
+		if config.Mode == LogicPolling {
+			return nil, errors.New("logic analyzer needs a sample rate to poll at")
+		}
+		config.SampleRate = 1000000
+	}
+
+	c := &LogicCapture{Names: config.Names, SampleRate: config.SampleRate, Duration: duration}
+	if c.Names == nil {
+		for i, pin := range config.Pins {
+			name := PinName(pin)
+			if name == "" {
+				name = fmt.Sprintf("D%d", i)
+			}
+			c.Names = append(c.Names, name)
+		}
+	}
+
+	if config.Mode == LogicPolling {
+		return c, c.poll(config.Pins)
+	}
+	return c, c.watch(config.Pins)
+}
+
+// Record the pins from their edges.
+func (c *LogicCapture) watch(pins PinList) error {
+	type pinEvent struct {
+		bit   uint
+		event EdgeEvent
+	}
+
+	var lock sync.Mutex
+	var running sync.WaitGroup
+	received := make([]pinEvent, 0)
+	watched := make(PinList, 0, len(pins))
+	defer func() {
+		for _, pin := range watched {
+			StopWatchingEdges(pin)
+		}
+	}()
+
+	// start watching before reading the levels, so no edge is missed between the two
+	for i, pin := range pins {
+		events, e := WatchEdges(pin, EdgeBoth)
+		if e != nil {
+			return e
+		}
+		watched = append(watched, pin)
+
+		running.Add(1)
+		go func(bit uint, events <-chan EdgeEvent) {
+			defer running.Done()
+
+			var lastSeq uint32
+			for event := range events {
+				lock.Lock()
+				if lastSeq != 0 && event.Seq != lastSeq+1 {
+					c.Overflows += uint64(event.Seq - lastSeq - 1)
+				}
+				lastSeq = event.Seq
+				received = append(received, pinEvent{bit, event})
+				lock.Unlock()
+			}
+		}(uint(i), events)
+	}
+
+	levels, e := readLogicLevels(pins)
+	if e != nil {
+		return e
+	}
+	c.Start, c.Initial = MonotonicNow(), levels
+
+	time.Sleep(c.Duration)
+	for _, pin := range watched {
+		StopWatchingEdges(pin)
+	}
+	watched = nil
+	running.Wait()
+
+	sort.SliceStable(received, func(i, j int) bool { return received[i].event.Timestamp < received[j].event.Timestamp })
+	for _, r := range received {
+		t := r.event.Timestamp - c.Start
+		// edges from before the levels were read are already in them
+		if t < 0 || t > c.Duration {
+			continue
+		}
+		if r.event.Rising {
+			levels |= 1 << r.bit
+		} else {
+			levels &^= 1 << r.bit
+		}
+		c.addChange(t, levels)
+	}
+	return nil
+}
+
+// Record the pins by reading them at the sample rate.
+func (c *LogicCapture) poll(pins PinList) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	period := time.Second / time.Duration(c.SampleRate)
+	if period <= 0 {
+		return errors.New("logic analyzer sample rate is too high")
+	}
+
+	levels, e := readLogicLevels(pins)
+	if e != nil {
+		return e
+	}
+	c.Start, c.Initial = MonotonicNow(), levels
+
+	for next := period; next <= c.Duration; next += period {
+		spinUntil(c.Start + next)
+		t := MonotonicNow() - c.Start
+		if late := (t - next) / period; late > 0 {
+			c.Overflows += uint64(late)
+			next += late * period
+		}
+
+		v, e := readLogicLevels(pins)
+		if e != nil {
+			return e
+		}
+		if v != levels {
+			levels = v
+			c.addChange(next, levels)
+		}
+	}
+	return nil
+}
+
+func (c *LogicCapture) addChange(t time.Duration, levels uint32) {
+	last := c.Initial
+	if len(c.Changes) > 0 {
+		last = c.Changes[len(c.Changes)-1].Levels
+	}
+	if levels != last {
+		c.Changes = append(c.Changes, LogicChange{Time: t, Levels: levels})
+	}
+}
+
+// Read the pins, with pin i as bit i, rather than the most significant bit first of DigitalReadGroup.
+func readLogicLevels(pins PinList) (uint32, error) {
+	v, e := DigitalReadGroup(pins)
+	if e != nil {
+		return 0, e
+	}
+	levels := uint32(0)
+	for i := range pins {
+		levels |= (v >> uint(len(pins)-1-i) & 1) << uint(i)
+	}
+	return levels, nil
+}
+
+// Return the levels of the pins at a time since the start of the capture.
+func (c *LogicCapture) LevelsAt(t time.Duration) uint32 {
+	n := sort.Search(len(c.Changes), func(i int) bool { return c.Changes[i].Time > t })
+	if n == 0 {
+		return c.Initial
+	}
+	return c.Changes[n-1].Levels
+}
+
+// Write the capture as a Value Change Dump, with nanosecond times.
+func (c *LogicCapture) WriteVCD(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "$date %s $end\n", time.Now().Format(time.RFC1123))
+	fmt.Fprintf(bw, "$version hwio logic analyzer $end\n")
+	fmt.Fprintf(bw, "$timescale 1ns $end\n")
+	fmt.Fprintf(bw, "$scope module logic $end\n")
+	for i, name := range c.Names {
+		fmt.Fprintf(bw, "$var wire 1 %s %s $end\n", vcdIdentifier(i), name)
+	}
+	fmt.Fprintf(bw, "$upscope $end\n$enddefinitions $end\n")
+
+	fmt.Fprintf(bw, "#0\n$dumpvars\n")
+	for i := range c.Names {
+		fmt.Fprintf(bw, "%d%s\n", c.Initial>>uint(i)&1, vcdIdentifier(i))
+	}
+	fmt.Fprintf(bw, "$end\n")
+
+	levels := c.Initial
+	for _, change := range c.Changes {
+		fmt.Fprintf(bw, "#%d\n", change.Time.Nanoseconds())
+		for i := range c.Names {
+			if (change.Levels^levels)>>uint(i)&1 != 0 {
+				fmt.Fprintf(bw, "%d%s\n", change.Levels>>uint(i)&1, vcdIdentifier(i))
+			}
+		}
+		levels = change.Levels
+	}
+	fmt.Fprintf(bw, "#%d\n", c.Duration.Nanoseconds())
+	return bw.Flush()
+}
+
+// The identifier of a signal in a VCD file, from the printable characters.
+func vcdIdentifier(i int) string {
+	return string(rune('!' + i))
+}
+
+// Write the capture as a sigrok session file, which PulseView opens. The levels are written as samples at the
+// sample rate.
+func (c *LogicCapture) WriteSigrok(w io.Writer) error {
+	unitSize := (len(c.Names) + 7) / 8
+	if unitSize == 3 {
+		unitSize = 4
+	}
+
+	z := zip.NewWriter(w)
+	f, e := z.Create("version")
+	if e == nil {
+		_, e = io.WriteString(f, "2")
+	}
+	if e == nil {
+		f, e = z.Create("metadata")
+	}
+	if e == nil {
+		_, e = io.WriteString(f, c.sigrokMetadata(unitSize))
+	}
+	if e == nil {
+		f, e = z.Create("logic-1-1")
+	}
+	if e == nil {
+		e = c.writeSigrokSamples(f, unitSize)
+	}
+	if e != nil {
+		return e
+	}
+	return z.Close()
+}
+
+func (c *LogicCapture) sigrokMetadata(unitSize int) string {
+	s := "[global]\nsigrok version=0.5.2\n\n[device 1]\ncapturefile=logic-1\n"
+	s += fmt.Sprintf("total probes=%d\nsamplerate=%s\ntotal analog=0\n", len(c.Names), formatSampleRate(c.SampleRate))
+	for i, name := range c.Names {
+		s += fmt.Sprintf("probe%d=%s\n", i+1, name)
+	}
+	return s + fmt.Sprintf("unitsize=%d\n", unitSize)
+}
+
+func (c *LogicCapture) writeSigrokSamples(w io.Writer, unitSize int) error {
+	bw := bufio.NewWriter(w)
+	samples := int64(c.Duration) * int64(c.SampleRate) / int64(time.Second)
+	levels, next := c.Initial, 0
+	unit := make([]byte, unitSize)
+
+	for n := int64(0); n < samples; n++ {
+		t := time.Duration(n * int64(time.Second) / int64(c.SampleRate))
+		for next < len(c.Changes) && c.Changes[next].Time <= t {
+			levels = c.Changes[next].Levels
+			next++
+		}
+		for i := range unit {
+			unit[i] = byte(levels >> uint(8*i))
+		}
+		if _, e := bw.Write(unit); e != nil {
+			return e
+		}
+	}
+	return bw.Flush()
+}
+
+// Format a sample rate the way sigrok does, e.g. "1 MHz".
+func formatSampleRate(rate int) string {
+	switch {
+	case rate%1000000 == 0:
+		return fmt.Sprintf("%d MHz", rate/1000000)
+	case rate%1000 == 0:
+		return fmt.Sprintf("%d kHz", rate/1000)
+	}
+	return fmt.Sprintf("%d Hz", rate)
+}
+
+// Write the capture to a file, as VCD if the name ends in ".vcd", otherwise as a sigrok session file.
+func (c *LogicCapture) WriteFile(path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+
+	if len(path) > 4 && path[len(path)-4:] == ".vcd" {
+		e = c.WriteVCD(f)
+	} else {
+		e = c.WriteSigrok(f)
+	}
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	return e
+}
@@ -0,0 +1,243 @@
+package hwio
+
+// Reducing a board's idle power, for solar and battery powered deployments. EnterLowPower parks unused GPIO pins,
+// disables modules the program doesn't need, and turns off SoC peripherals through sysfs: LEDs, radios, USB devices
+// and CPUs. It returns a LowPowerState that puts everything back as it was.
+//
+// A floating input can sit halfway between high and low, where its input buffer draws current, so parked pins are
+// set as inputs with a pull resistor. Pins a module or PinMode has assigned are left alone.
+//
+// Known issues:
+// - the sysfs settings need root, and each is skipped if the board doesn't have it
+// - HDMI and other outputs that are only controlled through firmware tools (e.g. vcgencmd) are not covered
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var lowPowerSysfsPath = "/sys"
+
+// What EnterLowPower turns off. The zero value changes nothing.
+type LowPowerOptions struct {
+	// Park GPIO pins that haven't been assigned as inputs with a pull-down, or a pull-up if ParkPullUp is true, e.g.
+	// for pins with external pull-ups.
+	ParkUnusedPins bool
+	ParkPullUp     bool
+
+	// Names of modules to disable, e.g. "spi0" or "serial".
+	DisableModules []string
+
+	// Turn off the board's LEDs, e.g. the power and activity LEDs, including any used by the program.
+	LEDsOff bool
+
+	// Block WiFi and Bluetooth with rfkill.
+	RadiosOff bool
+
+	// Let the kernel suspend idle USB devices.
+	USBAutosuspend bool
+
+	// The CPU frequency governor, e.g. "powersave", or "" to leave it.
+	CPUGovernor string
+
+	// The number of CPUs to leave online, taking the rest offline, or 0 to leave them all.
+	OnlineCPUs int
+}
+
+// A sysfs file that has been changed, and its value before.
+type savedSysfsValue struct {
+	path  string
+	value string
+}
+
+// What EnterLowPower changed, so it can be put back.
+type LowPowerState struct {
+	parked   PinList
+	disabled []Module
+	files    []savedSysfsValue
+}
+
+// Reduce idle power. Each setting the board doesn't support is skipped; if changing one fails the rest are still
+// tried, and the first error is returned along with the state, which can restore what was changed.
+func EnterLowPower(options LowPowerOptions) (*LowPowerState, error) {
+	s := &LowPowerState{}
+	var first error
+	fail := func(e error) {
+		if e != nil && first == nil {
+			first = e
+		}
+	}
+
+	if options.ParkUnusedPins {
+		pins := UnusedPins()
+		e := ParkPins(pins, options.ParkPullUp)
+		fail(e)
+		if e == nil {
+			s.parked = pins
+		}
+	}
+
+	for _, name := range options.DisableModules {
+		m, e := GetModule(name)
+		if e == nil && m == nil {
+			e = fmt.Errorf("driver does not support module '%s'", name)
+		}
+		if e == nil {
+			e = m.Disable()
+		}
+		fail(e)
+		if e == nil {
+			s.disabled = append(s.disabled, m)
+		}
+	}
+
+	if options.LEDsOff {
+		for _, led := range sysfsGlob("class/leds/*") {
+			fail(s.set(led+"/trigger", "none"))
+			fail(s.set(led+"/brightness", "0"))
+		}
+	}
+	if options.RadiosOff {
+		for _, rfkill := range sysfsGlob("class/rfkill/rfkill*") {
+			fail(s.set(rfkill+"/soft", "1"))
+		}
+	}
+	if options.USBAutosuspend {
+		for _, dev := range sysfsGlob("bus/usb/devices/*/power/control") {
+			fail(s.set(dev, "auto"))
+		}
+	}
+	if options.CPUGovernor != "" {
+		for _, cpu := range sysfsGlob("devices/system/cpu/cpufreq/policy*") {
+			fail(s.set(cpu+"/scaling_governor", options.CPUGovernor))
+		}
+	}
+	if options.OnlineCPUs > 0 {
+		// cpu0 usually can't be taken offline, and has no online file
+		cpus := sysfsGlob("devices/system/cpu/cpu[0-9]*/online")
+		sort.Slice(cpus, func(i, j int) bool { return cpuNumber(cpus[i]) < cpuNumber(cpus[j]) })
+		online := 1
+		for _, cpu := range cpus {
+			if cpuNumber(cpu) == 0 {
+				continue
+			}
+			if online < options.OnlineCPUs {
+				online++
+				continue
+			}
+			fail(s.set(cpu, "0"))
+		}
+	}
+	return s, first
+}
+
+// Put back everything EnterLowPower changed, in reverse order: sysfs settings, then modules, then pins. Returns the
+// first error, after trying everything.
+func (s *LowPowerState) Restore() error {
+	var first error
+	for i := len(s.files) - 1; i >= 0; i-- {
+		f := s.files[i]
+		if e := WriteStringToFile(f.path, f.value); e != nil && first == nil {
+			first = e
+		}
+	}
+	s.files = nil
+
+	for i := len(s.disabled) - 1; i >= 0; i-- {
+		if e := s.disabled[i].Enable(); e != nil && first == nil {
+			first = e
+		}
+	}
+	s.disabled = nil
+
+	for _, pin := range s.parked {
+		if e := ClosePin(pin); e != nil && first == nil {
+			first = e
+		}
+	}
+	s.parked = nil
+	return first
+}
+
+// Set a sysfs file, remembering its old value. A file that already has the value is left alone.
+func (s *LowPowerState) set(path string, value string) error {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return e
+	}
+	old := strings.TrimSpace(string(b))
+	if filepath.Base(path) == "trigger" {
+		old = selectedTrigger(old)
+	}
+	if old == value {
+		return nil
+	}
+	e = WriteStringToFile(path, value)
+	if e != nil {
+		return e
+	}
+	s.files = append(s.files, savedSysfsValue{path: path, value: old})
+	return nil
+}
+
+// Return the selected trigger of an LED from its trigger file, which lists them all with the selected one in
+// brackets, e.g. "none [mmc0] timer".
+func selectedTrigger(triggers string) string {
+	for _, t := range strings.Fields(triggers) {
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			return t[1 : len(t)-1]
+		}
+	}
+	return triggers
+}
+
+// Return the CPU number of a path under /sys/devices/system/cpu.
+func cpuNumber(path string) int {
+	n := -1
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "cpu") {
+			fmt.Sscanf(part, "cpu%d", &n)
+		}
+	}
+	return n
+}
+
+// Return the paths under /sys matching a pattern.
+func sysfsGlob(pattern string) []string {
+	matches, _ := filepath.Glob(filepath.Join(lowPowerSysfsPath, pattern))
+	return matches
+}
+
+// Return the GPIO pins of the default board that haven't been assigned, in order.
+func UnusedPins() PinList {
+	result := PinList{}
+	for pin, pd := range definedPins {
+		if pd.usedBy("gpio") && assignedPins[pin] == nil {
+			result = append(result, pin)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Park pins as inputs with a pull-down, or a pull-up if pullUp is true, so they don't float. ClosePin releases
+// them.
+func ParkPins(pins PinList, pullUp bool) error {
+	mode := InputPullDown
+	if pullUp {
+		mode = InputPullUp
+	}
+	for i, pin := range pins {
+		e := PinMode(pin, mode)
+		if e != nil {
+			for _, parked := range pins[:i] {
+				ClosePin(parked)
+			}
+			return fmt.Errorf("could not park pin %d: %s", pin, e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build !tinygo
+
+package machine
+
+import (
+	"errors"
+
+	"github.com/cinellodev/hwio"
+)
+
+// An I2C bus, as machine.I2C. I2CBus gets one.
+type I2C struct {
+	name   string
+	module hwio.I2CModule
+}
+
+// The configuration of an I2C bus, as machine.I2CConfig. On Linux the bus's frequency and pins are set by device
+// tree, so they are ignored.
+type I2CConfig struct {
+	Frequency uint32
+	SCL       Pin
+	SDA       Pin
+}
+
+// Return an I2C module of hwio's driver by name, e.g. "i2c1". It must be configured before it is used.
+func I2CBus(name string) *I2C {
+	return &I2C{name: name}
+}
+
+// Enable the bus. The config is ignored.
+func (i2c *I2C) Configure(config I2CConfig) error {
+	m, e := hwio.GetI2CModule(i2c.name)
+	if e != nil {
+		return e
+	}
+	e = m.Enable()
+	if e != nil {
+		return e
+	}
+	i2c.module = m
+	return nil
+}
+
+// Write w to the device at addr, then read r from it. w must be a register followed by the data to write to it, with
+// r empty, or just a register, to read r from.
+func (i2c *I2C) Tx(addr uint16, w, r []byte) error {
+	if i2c.module == nil {
+		return errors.New("machine: I2C bus is not configured")
+	}
+	if len(w) == 0 {
+		return errors.New("machine: I2C Tx must write a register")
+	}
+	device := i2c.module.GetDevice(int(addr))
+	if len(r) == 0 {
+		return device.Write(w[0], w[1:])
+	}
+	if len(w) > 1 {
+		return errors.New("machine: I2C Tx can't write data and read in one transaction")
+	}
+	data, e := device.Read(w[0], len(r))
+	if e != nil {
+		return e
+	}
+	copy(r, data)
+	return nil
+}
+
+// Read data from a register of the device at address.
+func (i2c *I2C) ReadRegister(address uint8, register uint8, data []byte) error {
+	return i2c.Tx(uint16(address), []byte{register}, data)
+}
+
+// Write data to a register of the device at address.
+func (i2c *I2C) WriteRegister(address uint8, register uint8, data []byte) error {
+	return i2c.Tx(uint16(address), append([]byte{register}, data...), nil)
+}
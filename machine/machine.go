@@ -0,0 +1,174 @@
+//go:build !tinygo
+
+// A façade over hwio that mirrors TinyGo's machine package, so code written for microcontroller firmware, such as a
+// device driver or control loop, can also run on a Linux board. Code that is shared imports "machine" when built with
+// TinyGo, and this package otherwise, from a pair of files with build tags:
+//
+//	//go:build tinygo
+//	package app
+//	import "machine"
+//
+//	//go:build !tinygo
+//	package app
+//	import "github.com/cinellodev/hwio/machine"
+//
+// Only the parts of machine that hwio can provide are here: GPIO pins, with interrupts, and I2C. Pins are hwio's
+// logical pins, which are different to the microcontroller's, so the board-specific files that name pins differ too;
+// PinByName helps on Linux.
+//
+// The machine package's methods don't return errors where hwio's functions do, e.g. Pin.Set, so errors from those are
+// passed to ErrorHandler, which logs them by default.
+//
+// Current status:
+// - the I2C Tx only supports a register write, or a register address write followed by a read, as hwio's I2CDevice
+//   can't do arbitrary transactions
+// - SPI, PWM, ADC and UART aren't mirrored
+
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/cinellodev/hwio"
+)
+
+// A GPIO pin, as machine.Pin. It is a logical pin of hwio's driver.
+type Pin int
+
+// A pin that isn't connected, as machine.NoPin.
+const NoPin = Pin(-1)
+
+// The modes a pin can be configured to, as machine.PinMode.
+type PinMode uint8
+
+const (
+	PinInput PinMode = iota
+	PinOutput
+	PinInputPullup
+	PinInputPulldown
+)
+
+// The configuration of a pin, as machine.PinConfig.
+type PinConfig struct {
+	Mode PinMode
+}
+
+// The changes of a pin that cause an interrupt, as machine.PinChange.
+type PinChange uint8
+
+const (
+	PinRising PinChange = 1 << iota
+	PinFalling
+	PinToggle = PinRising | PinFalling
+)
+
+// Called with errors from the methods that can't return them, such as Configure and Set. It logs them by default.
+var ErrorHandler = func(e error) {
+	log.Printf("machine: %s", e)
+}
+
+// guards interrupts
+var interruptLock sync.Mutex
+
+// The pins that have an interrupt handler, and a channel that is closed when its goroutine has finished.
+var interrupts = make(map[Pin]chan bool)
+
+// Return a pin of hwio's driver by name, as hwio.GetPin does, or NoPin if there isn't one.
+func PinByName(name string) Pin {
+	pin, e := hwio.GetPin(name)
+	if e != nil {
+		return NoPin
+	}
+	return Pin(pin)
+}
+
+// Configure the pin as an input or output.
+func (p Pin) Configure(config PinConfig) {
+	modes := map[PinMode]hwio.PinIOMode{
+		PinInput:         hwio.Input,
+		PinOutput:        hwio.Output,
+		PinInputPullup:   hwio.InputPullUp,
+		PinInputPulldown: hwio.InputPullDown,
+	}
+	mode, ok := modes[config.Mode]
+	if !ok {
+		ErrorHandler(fmt.Errorf("pin %d: unknown mode %d", p, config.Mode))
+		return
+	}
+	report(hwio.PinMode(hwio.Pin(p), mode))
+}
+
+// Set the pin high if high is true, or low.
+func (p Pin) Set(high bool) {
+	value := hwio.Low
+	if high {
+		value = hwio.High
+	}
+	report(hwio.DigitalWrite(hwio.Pin(p), value))
+}
+
+// Return true if the pin is high.
+func (p Pin) Get() bool {
+	value, e := hwio.DigitalRead(hwio.Pin(p))
+	report(e)
+	return value == hwio.High
+}
+
+// Set the pin high.
+func (p Pin) High() {
+	p.Set(true)
+}
+
+// Set the pin low.
+func (p Pin) Low() {
+	p.Set(false)
+}
+
+// Call callback from a goroutine each time the pin changes as given, replacing any callback the pin already has. A
+// nil callback stops calling the one the pin has. Unlike on a microcontroller, the callback isn't an interrupt
+// handler, so it can block, but while it does, further changes are queued, and dropped if too many are.
+func (p Pin) SetInterrupt(change PinChange, callback func(Pin)) error {
+	interruptLock.Lock()
+	defer interruptLock.Unlock()
+
+	if done := interrupts[p]; done != nil {
+		e := hwio.StopWatchingEdges(hwio.Pin(p))
+		if e != nil {
+			return e
+		}
+		<-done
+		delete(interrupts, p)
+	}
+	if callback == nil {
+		return nil
+	}
+
+	edges := map[PinChange]hwio.Edge{PinRising: hwio.EdgeRising, PinFalling: hwio.EdgeFalling, PinToggle: hwio.EdgeBoth}
+	edge, ok := edges[change]
+	if !ok {
+		return errors.New("machine: unknown pin change")
+	}
+	events, e := hwio.WatchEdges(hwio.Pin(p), edge)
+	if e != nil {
+		return e
+	}
+	done := make(chan bool)
+	interrupts[p] = done
+	go func() {
+		defer close(done)
+		for range events {
+			callback(p)
+		}
+	}()
+	return nil
+}
+
+// Pass an error, if there is one, to ErrorHandler.
+func report(e error) {
+	if e != nil {
+		ErrorHandler(e)
+	}
+}
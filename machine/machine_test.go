@@ -0,0 +1,62 @@
+//go:build !tinygo
+
+package machine
+
+import (
+	"testing"
+
+	"github.com/cinellodev/hwio"
+)
+
+func TestPin(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+
+	var errs []error
+	ErrorHandler = func(e error) { errs = append(errs, e) }
+
+	led := PinByName("gpio1")
+	if led == NoPin {
+		t.Fatal("PinByName didn't find gpio1")
+	}
+	if PinByName("nope") != NoPin {
+		t.Error("PinByName found a pin that doesn't exist")
+	}
+
+	led.Configure(PinConfig{Mode: PinOutput})
+	led.High()
+	if !led.Get() {
+		t.Error("pin is low after High")
+	}
+	led.Set(false)
+	if led.Get() {
+		t.Error("pin is high after Set(false)")
+	}
+	if v, _ := hwio.DigitalRead(hwio.Pin(led)); v != hwio.Low {
+		t.Errorf("hwio reads %d, expected Low", v)
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	// a pin that hasn't been configured can't be set, and the error goes to ErrorHandler
+	PinByName("gpio3").High()
+	if len(errs) != 1 {
+		t.Errorf("expected an error from setting a pin that isn't configured, got %v", errs)
+	}
+
+	button := PinByName("gpio2")
+	button.Configure(PinConfig{Mode: PinInputPullup})
+	if e := button.SetInterrupt(PinFalling, func(Pin) {}); e != nil {
+		t.Fatalf("SetInterrupt failed: %s", e)
+	}
+	if e := button.SetInterrupt(PinToggle, func(Pin) {}); e != nil {
+		t.Errorf("replacing the interrupt failed: %s", e)
+	}
+	if e := button.SetInterrupt(0, nil); e != nil {
+		t.Errorf("removing the interrupt failed: %s", e)
+	}
+	if e := led.SetInterrupt(PinRising, func(Pin) {}); e == nil {
+		t.Error("expected an error setting an interrupt on an output")
+	}
+}
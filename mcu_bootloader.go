@@ -0,0 +1,586 @@
+package hwio
+
+// Flashing a co-processor's firmware from Go, through its serial bootloader, so boards that pair an SBC with a
+// microcontroller can update it in the field. ResetMCU resets the target with GPIO pins, into its bootloader or its
+// application, and STM32Bootloader and OptibootBootloader speak the bootloaders' protocols over a serial module:
+//
+// - STM32Bootloader: the ROM bootloader of STM32 microcontrollers (ST's AN3155), started by holding BOOT0 high
+//   through reset, on USART1 at any baud rate up to 115200, with even parity.
+// - OptibootBootloader: the STK500v1 protocol of optiboot and the Arduino bootloaders on AVR microcontrollers, which
+//   runs for a moment after reset before starting the application, at 115200 baud on an Uno.
+//
+// ParseIntelHex reads the .hex files compilers produce.
+//
+// Replies are read with a timeout, which needs a serial module with read deadlines (SerialDeadlineModule); the tty
+// serial module has them.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The pins used to reset a microcontroller.
+type MCUResetPins struct {
+	// The target's active low reset pin. It is driven low to reset the target, and otherwise released to be pulled
+	// up, so it doesn't fight a reset button.
+	Reset Pin
+
+	// An STM32's BOOT0 pin, if UseBoot0 is true. It is set high to start the ROM bootloader.
+	Boot0    Pin
+	UseBoot0 bool
+
+	// How long the reset is held, 10ms if 0, and how long to wait after releasing it for the target to start, 50ms
+	// if 0.
+	Hold  time.Duration
+	Start time.Duration
+}
+
+// Reset a microcontroller into its bootloader, or into its application if bootloader is false. BOOT0 is left set,
+// so the target starts the same way if it is reset again.
+func ResetMCU(pins MCUResetPins, bootloader bool) error {
+	if pins.Hold <= 0 {
+		pins.Hold = 10 * time.Millisecond
+	}
+	if pins.Start <= 0 {
+		pins.Start = 50 * time.Millisecond
+	}
+
+	e := setOpenDrain(pins.Reset, true)
+	if e == nil && pins.UseBoot0 {
+		e = PinModeOutputInit(pins.Boot0, boolToLevel(bootloader))
+	}
+	if e != nil {
+		return e
+	}
+	time.Sleep(pins.Hold)
+	e = setOpenDrain(pins.Reset, false)
+	if e != nil {
+		return e
+	}
+	time.Sleep(pins.Start)
+	return nil
+}
+
+// Read exactly len(data) bytes from a serial module within a timeout.
+func serialReadFull(serial SerialModule, data []byte, timeout time.Duration) error {
+	if d, ok := serial.(SerialDeadlineModule); ok {
+		e := d.SetReadDeadline(time.Now().Add(timeout))
+		if e != nil {
+			return e
+		}
+		defer d.SetReadDeadline(time.Time{})
+	}
+	_, e := io.ReadFull(serial, data)
+	return e
+}
+
+// Discard whatever has been received, e.g. the target's output before it was reset.
+func serialDrain(serial SerialModule) {
+	d, ok := serial.(SerialDeadlineModule)
+	if !ok {
+		return
+	}
+	buffer := make([]byte, 64)
+	for {
+		d.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if n, e := serial.Read(buffer); n == 0 || e != nil {
+			break
+		}
+	}
+	d.SetReadDeadline(time.Time{})
+}
+
+const (
+	stm32Ack  = 0x79
+	stm32Nack = 0x1f
+
+	stm32Get           = 0x00
+	stm32GetID         = 0x02
+	stm32ReadMemory    = 0x11
+	stm32Go            = 0x21
+	stm32WriteMemory   = 0x31
+	stm32Erase         = 0x43
+	stm32ExtendedErase = 0x44
+)
+
+// The start of an STM32's flash.
+const STM32_FLASH_BASE = 0x08000000
+
+// Timeouts for an STM32 bootloader's replies. A mass erase can take tens of seconds on parts with large flash.
+var (
+	stm32Timeout      = time.Second
+	stm32EraseTimeout = 60 * time.Second
+)
+
+// The ROM bootloader of an STM32 microcontroller.
+type STM32Bootloader struct {
+	serial SerialModule
+	pins   MCUResetPins
+
+	// the bootloader's version and the commands it supports, from Connect
+	version  byte
+	commands []byte
+}
+
+// Create an STM32 bootloader on a serial module, with the pins that reset the target and set BOOT0.
+func NewSTM32Bootloader(serial SerialModule, pins MCUResetPins) *STM32Bootloader {
+	return &STM32Bootloader{serial: serial, pins: pins}
+}
+
+// Reset the target into its bootloader and connect to it. The serial module is set to even parity, if it
+// supports SetParity; otherwise it must already be.
+func (b *STM32Bootloader) Connect() error {
+	if p, ok := b.serial.(SerialParityModule); ok {
+		if e := p.SetParity("even"); e != nil {
+			return e
+		}
+	}
+	e := ResetMCU(b.pins, true)
+	if e != nil {
+		return e
+	}
+	serialDrain(b.serial)
+
+	// the bootloader measures the baud rate from 0x7f, and acknowledges it
+	_, e = b.serial.Write([]byte{0x7f})
+	if e == nil {
+		e = b.ack(stm32Timeout)
+	}
+	if e != nil {
+		return fmt.Errorf("STM32 bootloader did not respond: %s", e)
+	}
+
+	e = b.command(stm32Get)
+	if e != nil {
+		return e
+	}
+	n := make([]byte, 2)
+	e = serialReadFull(b.serial, n, stm32Timeout)
+	if e != nil {
+		return e
+	}
+	b.version = n[1]
+	b.commands = make([]byte, n[0])
+	e = serialReadFull(b.serial, b.commands, stm32Timeout)
+	if e != nil {
+		return e
+	}
+	return b.ack(stm32Timeout)
+}
+
+// Return the bootloader's version, e.g. 0x31 for 3.1.
+func (b *STM32Bootloader) Version() byte {
+	return b.version
+}
+
+// Wait for an acknowledgement.
+func (b *STM32Bootloader) ack(timeout time.Duration) error {
+	reply := make([]byte, 1)
+	e := serialReadFull(b.serial, reply, timeout)
+	if e != nil {
+		return e
+	}
+	switch reply[0] {
+	case stm32Ack:
+		return nil
+	case stm32Nack:
+		return errors.New("STM32 bootloader refused the request")
+	}
+	return fmt.Errorf("STM32 bootloader replied %02x", reply[0])
+}
+
+// Send a command and wait for it to be accepted.
+func (b *STM32Bootloader) command(c byte) error {
+	_, e := b.serial.Write([]byte{c, ^c})
+	if e != nil {
+		return e
+	}
+	return b.ack(stm32Timeout)
+}
+
+// Send bytes followed by their XOR checksum, and wait for them to be accepted.
+func (b *STM32Bootloader) send(data []byte, timeout time.Duration) error {
+	checksum := byte(0)
+	for _, d := range data {
+		checksum ^= d
+	}
+	if len(data) == 1 {
+		// a single byte is sent with its complement
+		checksum = ^data[0]
+	}
+	_, e := b.serial.Write(append(append([]byte(nil), data...), checksum))
+	if e != nil {
+		return e
+	}
+	return b.ack(timeout)
+}
+
+func stm32Address(address uint32) []byte {
+	return []byte{byte(address >> 24), byte(address >> 16), byte(address >> 8), byte(address)}
+}
+
+// Return the target's product ID, e.g. 0x410 for an STM32F103 with medium density flash.
+func (b *STM32Bootloader) GetID() (uint16, error) {
+	e := b.command(stm32GetID)
+	if e != nil {
+		return 0, e
+	}
+	reply := make([]byte, 3)
+	e = serialReadFull(b.serial, reply, stm32Timeout)
+	if e != nil {
+		return 0, e
+	}
+	if reply[0] != 1 {
+		return 0, fmt.Errorf("STM32 bootloader returned a %d byte ID", reply[0]+1)
+	}
+	return uint16(reply[1])<<8 | uint16(reply[2]), b.ack(stm32Timeout)
+}
+
+// Read up to 256 bytes of memory.
+func (b *STM32Bootloader) ReadMemory(address uint32, n int) ([]byte, error) {
+	if n < 1 || n > 256 {
+		return nil, errors.New("STM32 bootloader reads 1 to 256 bytes at a time")
+	}
+	e := b.command(stm32ReadMemory)
+	if e == nil {
+		e = b.send(stm32Address(address), stm32Timeout)
+	}
+	if e == nil {
+		e = b.send([]byte{byte(n - 1)}, stm32Timeout)
+	}
+	if e != nil {
+		return nil, e
+	}
+	data := make([]byte, n)
+	return data, serialReadFull(b.serial, data, stm32Timeout)
+}
+
+// Write up to 256 bytes of memory, a multiple of 4 bytes long. Flash must be erased first.
+func (b *STM32Bootloader) WriteMemory(address uint32, data []byte) error {
+	if len(data) < 1 || len(data) > 256 || len(data)%4 != 0 {
+		return errors.New("STM32 bootloader writes 4 to 256 bytes at a time, in multiples of 4")
+	}
+	e := b.command(stm32WriteMemory)
+	if e == nil {
+		e = b.send(stm32Address(address), stm32Timeout)
+	}
+	if e == nil {
+		e = b.send(append([]byte{byte(len(data) - 1)}, data...), stm32Timeout)
+	}
+	return e
+}
+
+// Erase all of the flash, with the erase command the bootloader supports.
+func (b *STM32Bootloader) EraseAll() error {
+	if bytes.IndexByte(b.commands, stm32ExtendedErase) >= 0 {
+		e := b.command(stm32ExtendedErase)
+		if e == nil {
+			e = b.send([]byte{0xff, 0xff}, stm32EraseTimeout)
+		}
+		return e
+	}
+	e := b.command(stm32Erase)
+	if e == nil {
+		e = b.send([]byte{0xff}, stm32EraseTimeout)
+	}
+	return e
+}
+
+// Start the code at an address, e.g. STM32_FLASH_BASE for the application just written. The bootloader jumps to
+// the reset handler in the vector table there.
+func (b *STM32Bootloader) Go(address uint32) error {
+	e := b.command(stm32Go)
+	if e == nil {
+		e = b.send(stm32Address(address), stm32Timeout)
+	}
+	return e
+}
+
+// Erase the flash, write an image to it at an address, usually STM32_FLASH_BASE, and read it back to check it.
+// progress, if not nil, is called with the bytes written and verified so far, out of twice the image's length.
+func (b *STM32Bootloader) Flash(image []byte, address uint32, progress func(done int, total int)) error {
+	// the bootloader writes whole words
+	padded := append([]byte(nil), image...)
+	for len(padded)%4 != 0 {
+		padded = append(padded, 0xff)
+	}
+
+	e := b.EraseAll()
+	if e != nil {
+		return fmt.Errorf("could not erase flash: %s", e)
+	}
+	total := 2 * len(padded)
+	for i := 0; i < len(padded); i += 256 {
+		chunk := padded[i:minInt(i+256, len(padded))]
+		if e = b.WriteMemory(address+uint32(i), chunk); e != nil {
+			return fmt.Errorf("could not write flash at %08x: %s", address+uint32(i), e)
+		}
+		if progress != nil {
+			progress(i+len(chunk), total)
+		}
+	}
+	for i := 0; i < len(padded); i += 256 {
+		n := minInt(256, len(padded)-i)
+		data, e := b.ReadMemory(address+uint32(i), n)
+		if e != nil {
+			return fmt.Errorf("could not read flash at %08x: %s", address+uint32(i), e)
+		}
+		if !bytes.Equal(data, padded[i:i+n]) {
+			return fmt.Errorf("flash at %08x does not match the image", address+uint32(i))
+		}
+		if progress != nil {
+			progress(len(padded)+i+n, total)
+		}
+	}
+	return nil
+}
+
+// Reset the target into its application, releasing BOOT0.
+func (b *STM32Bootloader) Run() error {
+	return ResetMCU(b.pins, false)
+}
+
+const (
+	stkOK      = 0x10
+	stkInSync  = 0x14
+	stkCRCEOP  = 0x20
+	stkGetSync = 0x30
+
+	stkEnterProgMode = 0x50
+	stkLeaveProgMode = 0x51
+	stkLoadAddress   = 0x55
+	stkProgPage      = 0x64
+	stkReadPage      = 0x74
+	stkReadSign      = 0x75
+)
+
+// How long to wait for optiboot's replies, and how many times to try to get in sync after reset.
+var (
+	optibootTimeout   = 200 * time.Millisecond
+	optibootSyncTries = 10
+)
+
+// The optiboot or Arduino bootloader of an AVR microcontroller.
+type OptibootBootloader struct {
+	serial SerialModule
+	pins   MCUResetPins
+}
+
+// Create an optiboot bootloader on a serial module, with the pin that resets the target. BOOT0 is not used.
+func NewOptibootBootloader(serial SerialModule, pins MCUResetPins) *OptibootBootloader {
+	pins.UseBoot0 = false
+	// optiboot waits for the programmer for a moment after reset, so don't wait long before talking to it
+	if pins.Start <= 0 {
+		pins.Start = time.Millisecond
+	}
+	return &OptibootBootloader{serial: serial, pins: pins}
+}
+
+// Reset the target and get in sync with its bootloader.
+func (b *OptibootBootloader) Connect() error {
+	e := ResetMCU(b.pins, true)
+	if e != nil {
+		return e
+	}
+	serialDrain(b.serial)
+
+	for i := 0; i < optibootSyncTries; i++ {
+		if _, e = b.request([]byte{stkGetSync}, 0); e == nil {
+			break
+		}
+		serialDrain(b.serial)
+	}
+	if e != nil {
+		return fmt.Errorf("optiboot did not respond: %s", e)
+	}
+	_, e = b.request([]byte{stkEnterProgMode}, 0)
+	return e
+}
+
+// Send a request, ending it with CRC_EOP, and read the reply, which is framed with INSYNC and OK.
+func (b *OptibootBootloader) request(data []byte, replyLength int) ([]byte, error) {
+	_, e := b.serial.Write(append(append([]byte(nil), data...), stkCRCEOP))
+	if e != nil {
+		return nil, e
+	}
+	reply := make([]byte, replyLength+2)
+	e = serialReadFull(b.serial, reply, optibootTimeout)
+	if e != nil {
+		return nil, e
+	}
+	if reply[0] != stkInSync || reply[len(reply)-1] != stkOK {
+		return nil, fmt.Errorf("optiboot is out of sync, replied %x", reply)
+	}
+	return reply[1 : len(reply)-1], nil
+}
+
+// Return the target's signature, e.g. 0x1e950f for an ATmega328P.
+func (b *OptibootBootloader) Signature() (uint32, error) {
+	s, e := b.request([]byte{stkReadSign}, 3)
+	if e != nil {
+		return 0, e
+	}
+	return uint32(s[0])<<16 | uint32(s[1])<<8 | uint32(s[2]), nil
+}
+
+// Set the address of the next page read or written. AVR flash is addressed in words.
+func (b *OptibootBootloader) loadAddress(address int) error {
+	word := address / 2
+	_, e := b.request([]byte{stkLoadAddress, byte(word), byte(word >> 8)}, 0)
+	return e
+}
+
+// Write a page of flash at an address, which must be the start of a page. The bootloader erases the page first.
+func (b *OptibootBootloader) WritePage(address int, data []byte) error {
+	e := b.loadAddress(address)
+	if e != nil {
+		return e
+	}
+	request := append([]byte{stkProgPage, byte(len(data) >> 8), byte(len(data)), 'F'}, data...)
+	_, e = b.request(request, 0)
+	return e
+}
+
+// Read n bytes of flash from an address.
+func (b *OptibootBootloader) ReadPage(address int, n int) ([]byte, error) {
+	e := b.loadAddress(address)
+	if e != nil {
+		return nil, e
+	}
+	return b.request([]byte{stkReadPage, byte(n >> 8), byte(n), 'F'}, n)
+}
+
+// Write an image to flash from address 0, a page at a time, and read it back to check it. pageSize is the target's
+// flash page size, 128 bytes for an ATmega328P. progress, if not nil, is called with the bytes written and verified
+// so far, out of twice the image's length rounded up to whole pages.
+func (b *OptibootBootloader) Flash(image []byte, pageSize int, progress func(done int, total int)) error {
+	if pageSize <= 0 {
+		return errors.New("optiboot needs the flash page size")
+	}
+	padded := append([]byte(nil), image...)
+	for len(padded)%pageSize != 0 {
+		padded = append(padded, 0xff)
+	}
+
+	total := 2 * len(padded)
+	for i := 0; i < len(padded); i += pageSize {
+		if e := b.WritePage(i, padded[i:i+pageSize]); e != nil {
+			return fmt.Errorf("could not write flash at %04x: %s", i, e)
+		}
+		if progress != nil {
+			progress(i+pageSize, total)
+		}
+	}
+	for i := 0; i < len(padded); i += pageSize {
+		data, e := b.ReadPage(i, pageSize)
+		if e != nil {
+			return fmt.Errorf("could not read flash at %04x: %s", i, e)
+		}
+		if !bytes.Equal(data, padded[i:i+pageSize]) {
+			return fmt.Errorf("flash at %04x does not match the image", i)
+		}
+		if progress != nil {
+			progress(len(padded)+i+pageSize, total)
+		}
+	}
+	return nil
+}
+
+// Leave the bootloader, which starts the application.
+func (b *OptibootBootloader) Run() error {
+	_, e := b.request([]byte{stkLeaveProgMode}, 0)
+	return e
+}
+
+// Read an Intel HEX file, returning the image it describes and the address it starts at. Gaps between records are
+// filled with 0xff, the value of erased flash.
+func ParseIntelHex(r io.Reader) (image []byte, start uint32, e error) {
+	var records []hexRecord
+	var base uint32
+	first := true
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, 0, fmt.Errorf("hex line %d does not start with ':'", line)
+		}
+		b, e := hex.DecodeString(text[1:])
+		if e != nil || len(b) < 5 || len(b) != int(b[0])+5 {
+			return nil, 0, fmt.Errorf("hex line %d is malformed", line)
+		}
+		sum := byte(0)
+		for _, v := range b {
+			sum += v
+		}
+		if sum != 0 {
+			return nil, 0, fmt.Errorf("hex line %d has a bad checksum", line)
+		}
+
+		data := b[4 : len(b)-1]
+		switch b[3] {
+		case 0x00:
+			address := base + uint32(b[1])<<8 + uint32(b[2])
+			if first || address < start {
+				start = address
+				first = false
+			}
+			records = append(records, hexRecord{address, data})
+		case 0x01:
+			return buildHexImage(records, start), start, nil
+		case 0x02:
+			if len(data) != 2 {
+				return nil, 0, fmt.Errorf("hex line %d is malformed", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case 0x04:
+			if len(data) != 2 {
+				return nil, 0, fmt.Errorf("hex line %d is malformed", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case 0x03, 0x05:
+			// start addresses, which don't affect the image
+		default:
+			return nil, 0, fmt.Errorf("hex line %d has an unknown record type %02x", line, b[3])
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		return nil, 0, e
+	}
+	return nil, 0, errors.New("hex file has no end of file record")
+}
+
+// A data record of a hex file.
+type hexRecord struct {
+	address uint32
+	data    []byte
+}
+
+// Place hex records in an image starting at an address.
+func buildHexImage(records []hexRecord, start uint32) []byte {
+	var image []byte
+	for _, r := range records {
+		end := int(r.address-start) + len(r.data)
+		for len(image) < end {
+			image = append(image, 0xff)
+		}
+		copy(image[r.address-start:], r.data)
+	}
+	return image
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
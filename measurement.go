@@ -0,0 +1,255 @@
+package hwio
+
+// Types for measured quantities, so a value carries its unit: a Temperature is a temperature whether it's wanted in
+// Celsius or Fahrenheit, and a Voltage can't be mistaken for millivolts. Each type stores its value in a base unit
+// and has constants or functions to make one from other units, and methods to get it back in them:
+//
+//	t := hwio.Fahrenheit(70)
+//	fmt.Println(t.Celsius())      // 21.11...
+//	v := 3300 * hwio.Millivolt
+//	fmt.Println(v)                // 3.3V
+//	if v < 3*hwio.Volt {
+//		...
+//	}
+//
+// Drivers of sensors return these, and LogTemperature etc. log them with their units.
+
+import (
+	"fmt"
+	"math"
+)
+
+// A measured quantity, such as a Temperature or Voltage.
+type Measurement interface {
+	// Return the value in the type's base unit, e.g. volts for a Voltage.
+	BaseValue() float64
+
+	// Return the symbol of the base unit, e.g. "V".
+	Unit() string
+
+	String() string
+}
+
+// Format a value in a base unit with a metric prefix that keeps it between 1 and 1000, e.g. 0.0123V as "12.3mV".
+func formatMetric(value float64, unit string) string {
+	prefixes := []struct {
+		scale  float64
+		prefix string
+	}{{1e3, "k"}, {1, ""}, {1e-3, "m"}, {1e-6, "µ"}}
+	for _, p := range prefixes {
+		if math.Abs(value) >= p.scale {
+			return fmt.Sprintf("%.4g%s%s", value/p.scale, p.prefix, unit)
+		}
+	}
+	if value == 0 {
+		return "0" + unit
+	}
+	return fmt.Sprintf("%.4g%s", value, unit)
+}
+
+// A temperature, in degrees Celsius.
+type Temperature float64
+
+// Return a temperature given in degrees Celsius.
+func Celsius(c float64) Temperature {
+	return Temperature(c)
+}
+
+// Return a temperature given in degrees Fahrenheit.
+func Fahrenheit(f float64) Temperature {
+	return Temperature((f - 32) * 5 / 9)
+}
+
+// Return a temperature given in kelvin.
+func Kelvin(k float64) Temperature {
+	return Temperature(k - 273.15)
+}
+
+func (t Temperature) Celsius() float64 {
+	return float64(t)
+}
+
+func (t Temperature) Fahrenheit() float64 {
+	return float64(t)*9/5 + 32
+}
+
+func (t Temperature) Kelvin() float64 {
+	return float64(t) + 273.15
+}
+
+func (t Temperature) BaseValue() float64 {
+	return float64(t)
+}
+
+func (t Temperature) Unit() string {
+	return "°C"
+}
+
+func (t Temperature) String() string {
+	return fmt.Sprintf("%.4g°C", float64(t))
+}
+
+// A voltage, in volts. Multiply a number by a unit to make one, e.g. 3300 * Millivolt.
+type Voltage float64
+
+const (
+	Microvolt Voltage = 1e-6
+	Millivolt Voltage = 1e-3
+	Volt      Voltage = 1
+	Kilovolt  Voltage = 1e3
+)
+
+func (v Voltage) Volts() float64 {
+	return float64(v)
+}
+
+func (v Voltage) Millivolts() float64 {
+	return float64(v / Millivolt)
+}
+
+func (v Voltage) BaseValue() float64 {
+	return float64(v)
+}
+
+func (v Voltage) Unit() string {
+	return "V"
+}
+
+func (v Voltage) String() string {
+	return formatMetric(float64(v), "V")
+}
+
+// A pressure, in pascals. Multiply a number by a unit to make one, e.g. 1013.25 * Hectopascal.
+type Pressure float64
+
+const (
+	Pascal              Pressure = 1
+	Hectopascal         Pressure = 100
+	Millibar            Pressure = 100
+	Kilopascal          Pressure = 1e3
+	Bar                 Pressure = 1e5
+	PSI                 Pressure = 6894.757293168361
+	Atmosphere          Pressure = 101325
+	MillimetreOfMercury Pressure = 133.322387415
+)
+
+func (p Pressure) Pascals() float64 {
+	return float64(p)
+}
+
+func (p Pressure) Hectopascals() float64 {
+	return float64(p / Hectopascal)
+}
+
+func (p Pressure) Bars() float64 {
+	return float64(p / Bar)
+}
+
+func (p Pressure) PSI() float64 {
+	return float64(p / PSI)
+}
+
+func (p Pressure) BaseValue() float64 {
+	return float64(p)
+}
+
+func (p Pressure) Unit() string {
+	return "Pa"
+}
+
+func (p Pressure) String() string {
+	return formatMetric(float64(p), "Pa")
+}
+
+// A distance, in metres. Multiply a number by a unit to make one, e.g. 12 * Inch.
+type Distance float64
+
+const (
+	Micrometre Distance = 1e-6
+	Millimetre Distance = 1e-3
+	Centimetre Distance = 1e-2
+	Metre      Distance = 1
+	Kilometre  Distance = 1e3
+	Inch       Distance = 0.0254
+	Foot       Distance = 0.3048
+)
+
+func (d Distance) Metres() float64 {
+	return float64(d)
+}
+
+func (d Distance) Millimetres() float64 {
+	return float64(d / Millimetre)
+}
+
+func (d Distance) Centimetres() float64 {
+	return float64(d / Centimetre)
+}
+
+func (d Distance) Inches() float64 {
+	return float64(d / Inch)
+}
+
+func (d Distance) Feet() float64 {
+	return float64(d / Foot)
+}
+
+func (d Distance) BaseValue() float64 {
+	return float64(d)
+}
+
+func (d Distance) Unit() string {
+	return "m"
+}
+
+func (d Distance) String() string {
+	return formatMetric(float64(d), "m")
+}
+
+// A relative humidity, in percent.
+type Humidity float64
+
+func (h Humidity) Percent() float64 {
+	return float64(h)
+}
+
+func (h Humidity) BaseValue() float64 {
+	return float64(h)
+}
+
+func (h Humidity) Unit() string {
+	return "%RH"
+}
+
+func (h Humidity) String() string {
+	return fmt.Sprintf("%.4g%%RH", float64(h))
+}
+
+// An acceleration, in metres per second squared. Multiply a number by a unit to make one, e.g. 0.5 * StandardGravity.
+type Acceleration float64
+
+const (
+	MetrePerSecondSquared Acceleration = 1
+	StandardGravity       Acceleration = 9.80665
+)
+
+func (a Acceleration) MetresPerSecondSquared() float64 {
+	return float64(a)
+}
+
+// Return the acceleration in multiples of standard gravity, g.
+func (a Acceleration) Gs() float64 {
+	return float64(a / StandardGravity)
+}
+
+func (a Acceleration) BaseValue() float64 {
+	return float64(a)
+}
+
+func (a Acceleration) Unit() string {
+	return "m/s²"
+}
+
+func (a Acceleration) String() string {
+	return fmt.Sprintf("%.4gm/s²", float64(a))
+}
@@ -3,6 +3,10 @@
 
 package hwio
 
+import (
+	"time"
+)
+
 // Generic interface type for all modules.
 type Module interface {
 	// Set parameters require to initialise the module. Generally should be called before Enable() is called,
@@ -19,6 +23,28 @@ type Module interface {
 	GetName() string
 }
 
+// Modules that can report which kernel interface they use implement this interface. It returns the interface,
+// e.g. "sysfs", "cdev", "i2c-dev" or "mmap", and the device file or path used. This is reported by DescribeDriver.
+type KernelInterfaceModule interface {
+	Module
+
+	KernelInterface() (iface string, device string)
+}
+
+// A file or device node a module needs access to, and whether it needs to write to it.
+type RequiredAccess struct {
+	Path  string
+	Write bool
+}
+
+// Modules that open files or device nodes implement this interface, so Permissions can check they are accessible
+// before they are used.
+type AccessCheckModule interface {
+	Module
+
+	RequiredAccess() []RequiredAccess
+}
+
 type GPIOModule interface {
 	Module
 
@@ -28,6 +54,79 @@ type GPIOModule interface {
 	ClosePin(pin Pin) (e error)
 }
 
+// GPIO modules that can configure line attributes beyond direction (drive, bias, drive strength, active-low)
+// implement this interface. Modules should return an error for any attribute they cannot apply, rather than
+// silently ignoring it.
+type GPIOConfigModule interface {
+	GPIOModule
+
+	PinModeConfig(pin Pin, config PinConfig) (e error)
+
+	// Set whether a pin is active-low. The setting persists for the pin, and is combined with
+	// PinConfig.ActiveLow.
+	SetActiveLow(pin Pin, activeLow bool) (e error)
+}
+
+// GPIO modules that can request a set of pins together, and read or write them in a single operation, implement
+// this interface. Values are packed into an integer with the first pin as the most significant bit, as for
+// WriteUIntToPins.
+type GPIOGroupModule interface {
+	GPIOModule
+
+	PinModeGroup(pins PinList, mode PinIOMode) (e error)
+	DigitalReadGroup(pins PinList) (value uint32, e error)
+	DigitalWriteGroup(pins PinList, value uint32) (e error)
+}
+
+// GPIO modules that export pins to use them (e.g. sysfs), and so have a choice of how to leave a pin when it is
+// closed, implement this interface. The GPIO character device has no such choice, as the kernel releases lines
+// when they are closed.
+type GPIOClosePolicyModule interface {
+	GPIOModule
+
+	// Set the policy for all pins of the module
+	SetClosePolicy(policy ClosePolicy)
+
+	// Set the policy for a pin, overriding the module's policy
+	SetPinClosePolicy(pin Pin, policy ClosePolicy)
+}
+
+// GPIO modules that can find the name the kernel gives each line, from gpio-line-names in device tree, e.g.
+// "GPIO18" or "CAM_GPIO", implement this interface. The names are added to the pin map when the driver is set, so
+// GetPin can find pins by them.
+type GPIOLineNameModule interface {
+	GPIOModule
+
+	// Return the kernel's name for the line of a pin, or "" if it has none.
+	LineName(pin Pin) string
+}
+
+// An edge seen on an input pin that is being watched.
+type EdgeEvent struct {
+	Pin    Pin
+	Rising bool
+
+	// When the edge happened, on the same clock as MonotonicNow. The kernel timestamps edges as they are
+	// detected, so this is accurate even if the event is read some time later.
+	Timestamp time.Duration
+
+	// The sequence number of the edge on this pin, starting at 1. A gap means events were dropped because they
+	// weren't read quickly enough.
+	Seq uint32
+}
+
+// GPIO modules that can detect edges on input pins implement this interface.
+type GPIOEdgeModule interface {
+	GPIOModule
+
+	// Start watching an input pin for edges, which are delivered on the returned channel. The pin must already
+	// be open as an input. Events are dropped if the channel is full.
+	WatchEdges(pin Pin, edge Edge) (<-chan EdgeEvent, error)
+
+	// Stop watching a pin, and close its channel. Closing the pin also stops it being watched.
+	StopWatchingEdges(pin Pin) error
+}
+
 type PWMModule interface {
 	Module
 
@@ -40,6 +139,54 @@ type PWMModule interface {
 	SetDuty(pin Pin, ns int64) error
 }
 
+// PWM modules that can set frequency and duty cycle independently, and align channels, implement this interface.
+// This is what motor control (e.g. the two sides of an H-bridge) needs. Modules return an error for anything the
+// hardware or kernel interface can't do, rather than approximating it.
+type PWMFrequencyModule interface {
+	PWMModule
+
+	// Set the frequency of a pin in Hz, keeping its duty cycle as a fraction of the period.
+	SetFrequency(pin Pin, hz float64) error
+
+	// Set the duty cycle of a pin as a fraction of the period, from 0 to 1. The new duty takes effect without
+	// stopping the output.
+	SetDutyCycle(pin Pin, fraction float64) error
+
+	// Set the delay of a pin's active pulse from the start of the period, in nanoseconds, relative to other
+	// channels of the same controller.
+	SetPhase(pin Pin, ns int64) error
+
+	// Start a set of pins together, so their periods are aligned.
+	Sync(pins PinList) error
+}
+
+// PWM modules whose hardware can generate complementary outputs with dead time implement this interface. This is
+// used to drive the high and low side switches of a half bridge in motor and inverter control, where both must
+// never be on at once.
+type ComplementaryPWMModule interface {
+	PWMModule
+
+	// Drive pin b as the inverse of pin a, with both low for deadTime nanoseconds around each edge. The period and
+	// duty are those set on pin a. Both pins must be enabled, and must be the two outputs of one PWM channel.
+	SetComplementary(a Pin, b Pin, deadTime int64) error
+
+	// Return pins a and b to independent outputs.
+	ClearComplementary(a Pin, b Pin) error
+}
+
+// Interface for modules that output clock signals on pins, such as the general purpose clocks of a Raspberry Pi, to
+// drive the clock inputs of external chips.
+type ClockModule interface {
+	Module
+
+	// Output a clock of a frequency in Hz on a pin, returning the frequency generated, which is as close as the
+	// hardware's dividers allow.
+	SetClock(pin Pin, hz float64) (float64, error)
+
+	// Stop the clock on a pin, leaving the pin an input.
+	StopClock(pin Pin) error
+}
+
 type AnalogModule interface {
 	Module
 
@@ -49,6 +196,22 @@ type AnalogModule interface {
 	// reference voltage
 }
 
+// An analog value read by AnalogReadMulti, and when it was sampled, on the same clock as MonotonicNow.
+type AnalogSample struct {
+	Pin       Pin
+	Value     int
+	Timestamp time.Duration
+}
+
+// Analog modules that can sample several pins together implement this interface.
+type MultiAnalogModule interface {
+	AnalogModule
+
+	// Read several pins as close together in time as the hardware allows, returning a sample of each in the
+	// order given.
+	AnalogReadMulti(pins PinList) ([]AnalogSample, error)
+}
+
 // Interface for I2C implementations. Assumes that this device is the only bus master, so initiates all transactions. An I2C module
 // supports exactly one i2c bus, so for systems with multiple i2c busses, the driver will create an instance for each accessible
 // i2c bus.
@@ -85,6 +248,105 @@ type SPIModule interface {
 	Read(slaveSelect int, data []byte) (nBytes int, e error)
 }
 
+// One segment of an SPI transfer. Tx is sent, and Rx receives the bytes clocked in at the same time; either can be
+// nil, and if both are given they must be the same length. SpeedHz and BitsPerWord override the module's settings
+// for this segment if they are non-zero.
+type SPISegment struct {
+	Tx []byte
+	Rx []byte
+
+	SpeedHz     uint32
+	BitsPerWord uint8
+
+	// Time to wait after the segment, before chip select changes or the next segment starts. spidev supports whole
+	// microseconds, up to 65535.
+	DelayAfter time.Duration
+
+	// Release chip select after this segment, before the next one. On the last segment, this leaves the device
+	// selected after the transfer, which some devices need between transfers.
+	CSChange bool
+}
+
+// SPI modules that can perform a list of segments as a single transfer implement this interface. This cuts the
+// latency of devices that take a command followed by data, such as displays, as chip select is held across the
+// segments without a system call for each.
+type SPITransferModule interface {
+	SPIModule
+
+	Transfer(slaveSelect int, segments []SPISegment) (e error)
+}
+
+// Interface for SPI controllers running as a slave, where another device is the master and clocks each transfer.
+type SPISlaveModule interface {
+	Module
+
+	// Wait for the master to clock a transfer, and read the data received
+	Receive(data []byte) (nBytes int, e error)
+
+	// Wait for the master to clock a transfer, and send data in it
+	Transmit(data []byte) (e error)
+}
+
+// Interface for serial ports (UARTs). Ports are set to raw mode with 8 data bits, no parity and 1 stop bit, so
+// bytes are read and written as they are.
+type SerialModule interface {
+	Module
+
+	// Read the bytes received, waiting for at least one
+	Read(data []byte) (nBytes int, e error)
+
+	// Write bytes to the port
+	Write(data []byte) (nBytes int, e error)
+
+	// Set the baud rate. Any rate the UART can generate can be used, not only the standard ones.
+	SetBaudRate(baud int) (e error)
+}
+
+// How to switch an RS-485 transceiver between transmit and receive.
+type RS485Config struct {
+	// A GPIO pin driving the transceiver's DE and /RE inputs, high to transmit, when UseDirectionPin is true.
+	// Otherwise the UART's RTS line is driven by the kernel's RS-485 support (TIOCSRS485), which switches in the
+	// interrupt handler and so has the tightest turnaround.
+	DirectionPin    Pin
+	UseDirectionPin bool
+
+	// Delays between switching to transmit and the first bit, and between the last bit and switching back to
+	// receive. The kernel only supports whole milliseconds.
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+
+	// If true, the receiver is left on while transmitting, so what is sent is also received. If false, it is
+	// disabled (kernel), or the echo is discarded (GPIO direction pin).
+	RxDuringTx bool
+}
+
+// Serial modules that can drive an RS-485 transceiver for half duplex buses such as Modbus RTU and DMX implement
+// this interface.
+type RS485Module interface {
+	SerialModule
+
+	// Set RS-485 mode, or turn it off with a nil config.
+	SetRS485(config *RS485Config) (e error)
+}
+
+// Serial modules that can send a break, holding the line at the space level for longer than a byte, implement this
+// interface. Buses such as SDI-12 use a break to wake devices before a command.
+type SerialBreakModule interface {
+	SerialModule
+
+	// Send a break lasting duration, returning once the line is back at idle.
+	SendBreak(duration time.Duration) (e error)
+}
+
+// Serial modules whose parity can be changed while enabled implement this interface. Bootloaders such as the STM32's
+// expect even parity.
+type SerialParityModule interface {
+	SerialModule
+
+	// Set the parity, "none", "even" or "odd".
+	SetParity(parity string) (e error)
+}
+
 // Interface for controlling on-board LEDs, modelled on /sys/class/leds
 type LEDModule interface {
 	Module
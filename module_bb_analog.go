@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strconv"
 	"strings"
 )
@@ -20,6 +18,9 @@ type BBAnalogModule struct {
 	definedPins BBAnalogModulePinDefMap
 
 	openPins map[Pin]*BBAnalogModuleOpenPin
+
+	// the filesystem the cape manager and helper files are on
+	fs SysfsFS
 }
 
 // Represents the definition of an analog pin, which should contain all the info required to open, close, read and write the pin
@@ -39,15 +40,21 @@ type BBAnalogModuleOpenPin struct {
 	// path to file representing analog pin
 	analogFile string
 
-	valueFile *os.File
+	valueFile SysfsFile
 }
 
 func NewBBAnalogModule(name string) (result *BBAnalogModule) {
-	result = &BBAnalogModule{name: name}
+	result = &BBAnalogModule{name: name, fs: defaultSysfs}
 	result.openPins = make(map[Pin]*BBAnalogModuleOpenPin)
 	return result
 }
 
+// Set the filesystem the module finds the cape manager and helper files on, e.g. a FakeSysfs in tests. This must be
+// called before Enable.
+func (module *BBAnalogModule) SetFilesystem(fsys SysfsFS) {
+	module.fs = fsys
+}
+
 // Set options of the module. Parameters we look for include:
 // - "pins" - an object of type BBAnalogModulePinDefMap
 func (module *BBAnalogModule) SetOptions(options map[string]interface{}) error {
@@ -64,28 +71,27 @@ func (module *BBAnalogModule) SetOptions(options map[string]interface{}) error {
 func (module *BBAnalogModule) Enable() error {
 	// once-off initialisation of analog
 	if !module.analogInitialised {
-		path, e := findFirstMatchingFile("/sys/devices/bone_capemgr.*/slots")
-		if e != nil {
-			return e
+		slots := sysfsMatches(module.fs, "/sys/devices/bone_capemgr.*/slots")
+		if len(slots) == 0 {
+			return errors.New("could not locate /sys/devices/bone_capemgr.*/slots")
 		}
+		path := slots[0]
 
 		// determine if cape-bone-iio is already in the file. If so, we've already initialised it.
 		if !module.hasCapeBoneIIO(path) {
 			// enable analog
-			e = WriteStringToFile(path, "cape-bone-iio")
+			e := sysfsWriteString(module.fs, path, "cape-bone-iio")
 			if e != nil {
 				return e
 			}
 		}
 
 		// determine path where analog files are
-		path, e = findFirstMatchingFile("/sys/devices/ocp.*/helper.*/AIN0")
-		if e != nil {
-			return e
-		}
-		if path == "" {
+		helpers := sysfsMatches(module.fs, "/sys/devices/ocp.*/helper.*/AIN0")
+		if len(helpers) == 0 {
 			return errors.New("could not locate /sys/devices/ocp.*/helper.*/AIN0")
 		}
+		path = helpers[0]
 
 		// remove AIN0 to get the path where these files are
 		module.analogValueFilesPath = strings.TrimSuffix(path, "AIN0")
@@ -95,7 +101,7 @@ func (module *BBAnalogModule) Enable() error {
 		// attempt to assign all pins to this module
 		for pin := range module.definedPins {
 			// attempt to assign this pin for this module.
-			e = AssignPin(pin, module)
+			e := AssignPin(pin, module)
 			if e != nil {
 				return e
 			}
@@ -105,7 +111,7 @@ func (module *BBAnalogModule) Enable() error {
 }
 
 func (module *BBAnalogModule) hasCapeBoneIIO(path string) bool {
-	f, e := ioutil.ReadFile(path)
+	f, e := sysfsReadFile(module.fs, path)
 	if e != nil {
 		return false
 	}
@@ -120,7 +126,7 @@ func (module *BBAnalogModule) Disable() error {
 	// Unassign any pins we may have assigned
 	for pin := range module.definedPins {
 		// attempt to assign this pin for this module.
-		UnassignPin(pin)
+		UnassignPinFrom(pin, module)
 	}
 
 	// if there are any open analog pins, close them
@@ -134,6 +140,11 @@ func (module *BBAnalogModule) GetName() string {
 	return module.name
 }
 
+// Analog values are read from the helper files the cape-bone-iio overlay creates in sysfs.
+func (module *BBAnalogModule) KernelInterface() (string, string) {
+	return "sysfs", module.analogValueFilesPath
+}
+
 // func (module *BBAnalogModule) AnalogWrite(pin Pin, value int) (e error) {
 // 	return nil
 // }
@@ -162,7 +173,7 @@ func (module *BBAnalogModule) makeOpenAnalogPin(pin Pin) (*BBAnalogModuleOpenPin
 
 	path := module.analogValueFilesPath + fmt.Sprintf("AIN%d", p.analogLogical)
 	result := &BBAnalogModuleOpenPin{pin: pin, analogLogical: p.analogLogical, analogFile: path}
-	e := result.analogOpen()
+	e := result.analogOpen(module.fs)
 	if e != nil {
 		return nil, e
 	}
@@ -172,9 +183,9 @@ func (module *BBAnalogModule) makeOpenAnalogPin(pin Pin) (*BBAnalogModuleOpenPin
 	return result, nil
 }
 
-func (op *BBAnalogModuleOpenPin) analogOpen() error {
+func (op *BBAnalogModuleOpenPin) analogOpen(fsys SysfsFS) error {
 	// Open analog input file computed from the calculated path of actual analog files and the analog pin name
-	f, e := os.OpenFile(op.analogFile, os.O_RDONLY, 0666)
+	f, e := fsys.OpenFile(sysfsName(op.analogFile), false)
 	op.valueFile = f
 
 	return e
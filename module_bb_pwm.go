@@ -32,6 +32,9 @@ type BBPWMModulePinDef struct {
 	// used to derive the slot if not there, and the folder which contains the PWM files. This is of the form
 	// "P8_13" and is case-sensitive
 	name string
+
+	// the EHRPWM output of the pin, "A" or "B", or "" if it is not an EHRPWM output
+	output string
 }
 
 type BBPWMModulePinDefMap map[Pin]*BBPWMModulePinDef
@@ -91,6 +94,11 @@ func (module *BBPWMModule) GetName() string {
 	return module.name
 }
 
+// PWM uses the pwm_test device tree overlays, controlled through sysfs.
+func (module *BBPWMModule) KernelInterface() (string, string) {
+	return "sysfs", "/sys/devices/ocp.*/pwm_test_*"
+}
+
 // Enable a specific PWM pin. You need to call this explicitly after enabling the module, as the
 // module will not by default allocate all pins, since there are a few.
 func (module *BBPWMModule) EnablePin(pin Pin, enabled bool) error {
@@ -138,6 +146,38 @@ func (module *BBPWMModule) SetDuty(pin Pin, ns int64) error {
 	return openPin.setDuty(ns)
 }
 
+// Drive pin b as the inverse of pin a with dead time, using the EHRPWM dead band generator. a must be the A output
+// and b the B output of the module, and both must be enabled.
+func (module *BBPWMModule) SetComplementary(a Pin, b Pin, deadTime int64) error {
+	e := module.checkComplementaryPair(a, b)
+	if e != nil {
+		return e
+	}
+	return ehrpwmWithRegisters(module.name, func(regs []byte) error {
+		return ehrpwmSetDeadBand(regs, deadTime)
+	})
+}
+
+// Return the A and B outputs to independent outputs.
+func (module *BBPWMModule) ClearComplementary(a Pin, b Pin) error {
+	e := module.checkComplementaryPair(a, b)
+	if e != nil {
+		return e
+	}
+	return ehrpwmWithRegisters(module.name, ehrpwmClearDeadBand)
+}
+
+func (module *BBPWMModule) checkComplementaryPair(a Pin, b Pin) error {
+	pa, pb := module.definedPins[a], module.definedPins[b]
+	if pa == nil || pb == nil || pa.output != "A" || pb.output != "B" {
+		return fmt.Errorf("module '%s' can only make complementary outputs from its A and B outputs, in that order", module.GetName())
+	}
+	if module.openPins[a] == nil || module.openPins[b] == nil {
+		return fmt.Errorf("both PWM pins must be enabled, call EnablePin")
+	}
+	return nil
+}
+
 // create an openPin object and put it in the map.
 func (module *BBPWMModule) makeOpenPin(pin Pin) (*BBPWMModuleOpenPin, error) {
 	p := module.definedPins[pin]
@@ -0,0 +1,861 @@
+// A GPIO module that uses the Linux GPIO character device (/dev/gpiochipN) rather than sysfs. Unlike sysfs, the
+// character device can configure bias, open-drain/open-source drive and active-low in the kernel, and lines are
+// released automatically if the process exits. Edges on inputs are timestamped by the kernel as they happen, and can
+// be watched with WatchEdges. Requires kernel 5.10+ for the v2 interface.
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The consumer label the kernel reports for lines requested by hwio.
+const cdevGPIOConsumer = "hwio"
+
+type CdevGPIOModule struct {
+	name          string
+	definedPins   CdevGPIOModulePinDefMap
+	openPins      map[Pin]*CdevGPIOModuleOpenPin
+	driveStrength GPIODriveStrengthFunc
+
+	// line requests currently held from the kernel
+	requests []*cdevLineRequest
+
+	// pins set active-low by SetActiveLow. This persists across PinMode and ClosePin.
+	activeLow map[Pin]bool
+}
+
+// Represents the definition of a GPIO pin for the character device: the chip device file and the line offset
+// within that chip.
+type CdevGPIOModulePinDef struct {
+	pin  Pin
+	chip string
+	line int
+}
+
+// A map of GPIO pin definitions.
+type CdevGPIOModulePinDefMap map[Pin]*CdevGPIOModulePinDef
+
+// A function that sets the drive strength of a pin, in milliamps. The character device has no support for
+// drive strength, so drivers for SoC's that can set it pass one of these in the module options.
+type GPIODriveStrengthFunc func(pin Pin, milliamps int) error
+
+type CdevGPIOModuleOpenPin struct {
+	pin Pin
+
+	// the line request this pin belongs to, and its index within the request. Pins opened with PinModeGroup
+	// share a request, so they can be read and written in one operation.
+	request *cdevLineRequest
+	index   int
+}
+
+// A line request held from the kernel, for one or more lines of a single chip. All ioctls to get and set values
+// go to its file.
+type cdevLineRequest struct {
+	chip    string
+	file    *os.File
+	pins    []Pin
+	lines   []int
+	configs []PinConfig
+
+	// whether each pin is open. A line stays held until every pin in the request is closed, and is reused
+	// if its pin is opened again in the meantime.
+	open []bool
+
+	// the edges watched on each line, by index. While any line is watched the file is waited on by the edge
+	// poller, which delivers its events.
+	edges []Edge
+
+	// guards events, the channels events are delivered on, by index
+	sync.Mutex
+	events map[int]chan EdgeEvent
+}
+
+func NewCdevGPIOModule(name string) (result *CdevGPIOModule) {
+	result = &CdevGPIOModule{name: name}
+	result.openPins = make(map[Pin]*CdevGPIOModuleOpenPin)
+	result.activeLow = make(map[Pin]bool)
+	return result
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type CdevGPIOModulePinDefMap
+// - "driveStrength" - optional, a GPIODriveStrengthFunc used when PinConfig.DriveStrength is set
+func (module *CdevGPIOModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+	module.definedPins = v.(CdevGPIOModulePinDefMap)
+
+	if ds := options["driveStrength"]; ds != nil {
+		module.driveStrength = ds.(GPIODriveStrengthFunc)
+	}
+
+	return nil
+}
+
+// enable GPIO module. It doesn't allocate any pins immediately.
+func (module *CdevGPIOModule) Enable() error {
+	return nil
+}
+
+// disables module and release any pins assigned.
+func (module *CdevGPIOModule) Disable() error {
+	for pin := range module.openPins {
+		module.ClosePin(pin)
+	}
+	return nil
+}
+
+func (module *CdevGPIOModule) GetName() string {
+	return module.name
+}
+
+// Return the number of line requests held, each of which is a file descriptor.
+func (module *CdevGPIOModule) OpenFileDescriptors() int {
+	return len(module.requests)
+}
+
+// The device is the list of GPIO chips the module's pins are on.
+func (module *CdevGPIOModule) KernelInterface() (string, string) {
+	return "cdev", strings.Join(module.chips(), ",")
+}
+
+// Lines are requested through the chip device files, which must be readable and writable.
+func (module *CdevGPIOModule) RequiredAccess() []RequiredAccess {
+	result := make([]RequiredAccess, 0)
+	for _, chip := range module.chips() {
+		result = append(result, RequiredAccess{Path: chip, Write: true})
+	}
+	return result
+}
+
+// Return the GPIO chip device files of the module's pins, sorted.
+func (module *CdevGPIOModule) chips() []string {
+	chips := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pd := range module.definedPins {
+		if !seen[pd.chip] {
+			seen[pd.chip] = true
+			chips = append(chips, pd.chip)
+		}
+	}
+	sort.Strings(chips)
+	return chips
+}
+
+func (module *CdevGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
+	return module.PinModeConfig(pin, PinConfig{Mode: mode})
+}
+
+// Set the mode and line attributes of a pin. If the pin is already open, it is reconfigured in place so an
+// output does not glitch.
+func (module *CdevGPIOModule) PinModeConfig(pin Pin, config PinConfig) error {
+	p := module.definedPins[pin]
+	if p == nil {
+		return fmt.Errorf("pin %d is not known as a GPIO pin", pin)
+	}
+	config.ActiveLow = config.ActiveLow || module.activeLow[pin]
+
+	_, e := cdevLineFlags(config)
+	if e != nil {
+		return fmt.Errorf("pin %d: %s", pin, e)
+	}
+
+	if config.DriveStrength != 0 {
+		if module.driveStrength == nil {
+			return fmt.Errorf("module '%s' cannot set drive strength on pin %d", module.GetName(), pin)
+		}
+		e = module.driveStrength(pin, config.DriveStrength)
+		if e != nil {
+			return e
+		}
+	}
+
+	if openPin := module.openPins[pin]; openPin != nil {
+		// nothing to do if the line is already configured this way, unless an initial value is to be set again
+		if openPin.request.configs[openPin.index] == config && !config.UseInitialValue {
+			return nil
+		}
+		return openPin.request.reconfigure(map[int]PinConfig{openPin.index: config})
+	}
+
+	// attempt to assign this pin for this module.
+	e = AssignPin(pin, module)
+	if e != nil {
+		return e
+	}
+
+	// the line may still be held by a group that other pins are using
+	if req, i := module.heldRequest(pin); req != nil {
+		e = req.reconfigure(map[int]PinConfig{i: config})
+		if e != nil {
+			UnassignPinFrom(pin, module)
+			return e
+		}
+		req.open[i] = true
+		module.openPins[pin] = &CdevGPIOModuleOpenPin{pin: pin, request: req, index: i}
+		return nil
+	}
+
+	return module.requestLines(p.chip, []Pin{pin}, []PinConfig{config})
+}
+
+// Set the mode of a set of pins, requesting them from the kernel together so that DigitalReadGroup and
+// DigitalWriteGroup on them take a single operation. This is only possible for pins on the same GPIO chip; if
+// they span chips, there is one request per chip. Pins that are already open are released and requested again.
+func (module *CdevGPIOModule) PinModeGroup(pins PinList, mode PinIOMode) error {
+	byChip := make(map[string][]Pin)
+	chips := []string{}
+	for _, pin := range pins {
+		p := module.definedPins[pin]
+		if p == nil {
+			return fmt.Errorf("pin %d is not known as a GPIO pin", pin)
+		}
+		if _, ok := byChip[p.chip]; !ok {
+			chips = append(chips, p.chip)
+		}
+		byChip[p.chip] = append(byChip[p.chip], pin)
+	}
+
+	for _, pin := range pins {
+		if module.openPins[pin] != nil {
+			e := module.ClosePin(pin)
+			if e != nil {
+				return e
+			}
+		}
+		if req, _ := module.heldRequest(pin); req != nil {
+			return fmt.Errorf("pin %d is still held by a group with other open pins", pin)
+		}
+	}
+
+	for i, pin := range pins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			UnassignPinsFrom(pins[:i], module)
+			return e
+		}
+	}
+
+	// the group is opened completely or not at all, so a failed request releases the chips already requested
+	requests := []*cdevLineRequest{}
+	for _, chip := range chips {
+		chipPins := byChip[chip]
+		configs := make([]PinConfig, len(chipPins))
+		for i, pin := range chipPins {
+			configs[i] = PinConfig{Mode: mode, ActiveLow: module.activeLow[pin]}
+		}
+
+		e := module.requestLines(chip, chipPins, configs)
+		if e != nil {
+			for _, req := range requests {
+				module.removeRequest(req)
+				req.file.Close()
+				for _, pin := range req.pins {
+					delete(module.openPins, pin)
+				}
+			}
+			UnassignPinsFrom(pins, module)
+			return e
+		}
+		requests = append(requests, module.requests[len(module.requests)-1])
+	}
+	return nil
+}
+
+// Line values for DigitalWrite and DigitalRead, pooled so they don't allocate, as a value passed to gpioIoctl
+// escapes to the heap.
+var gpioLineValues = sync.Pool{New: func() interface{} { return new(gpioV2LineValues) }}
+
+func (module *CdevGPIOModule) DigitalWrite(pin Pin, value int) (e error) {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return errors.New("pin is being written but has not been opened, called PinMode")
+	}
+
+	lv := gpioLineValues.Get().(*gpioV2LineValues)
+	defer gpioLineValues.Put(lv)
+	*lv = gpioV2LineValues{mask: 1 << uint(openPin.index)}
+	if value != Low {
+		lv.bits = lv.mask
+	}
+	return openPin.request.ioctl(gpioV2LineSetValuesIoctl, unsafe.Pointer(lv))
+}
+
+func (module *CdevGPIOModule) DigitalRead(pin Pin) (value int, e error) {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return 0, errors.New("pin is being read from but has not been opened, call PinMode")
+	}
+
+	lv := gpioLineValues.Get().(*gpioV2LineValues)
+	defer gpioLineValues.Put(lv)
+	*lv = gpioV2LineValues{mask: 1 << uint(openPin.index)}
+	e = openPin.request.ioctl(gpioV2LineGetValuesIoctl, unsafe.Pointer(lv))
+	if e != nil {
+		return 0, e
+	}
+
+	if lv.bits&lv.mask != 0 {
+		return High, nil
+	}
+	return Low, nil
+}
+
+// Read a set of pins, packing the values into an integer with the first pin as the most significant bit. Pins
+// opened together by PinModeGroup are read in a single ioctl, so the result is a consistent snapshot.
+func (module *CdevGPIOModule) DigitalReadGroup(pins PinList) (uint32, error) {
+	masks, e := module.groupMasks(pins)
+	if e != nil {
+		return 0, e
+	}
+
+	values := make(map[*cdevLineRequest]uint64)
+	for req, mask := range masks {
+		lv := gpioV2LineValues{mask: mask}
+		e = req.ioctl(gpioV2LineGetValuesIoctl, unsafe.Pointer(&lv))
+		if e != nil {
+			return 0, e
+		}
+		values[req] = lv.bits
+	}
+
+	result := uint32(0)
+	for _, pin := range pins {
+		openPin := module.openPins[pin]
+		result = result << 1
+		if values[openPin.request]&(1<<uint(openPin.index)) != 0 {
+			result |= 1
+		}
+	}
+	return result, nil
+}
+
+// Write a value across a set of pins, with the most significant bit written to the first pin. Pins opened
+// together by PinModeGroup change at the same time.
+func (module *CdevGPIOModule) DigitalWriteGroup(pins PinList, value uint32) error {
+	masks, e := module.groupMasks(pins)
+	if e != nil {
+		return e
+	}
+
+	bits := make(map[*cdevLineRequest]uint64)
+	for i, pin := range pins {
+		openPin := module.openPins[pin]
+		if value&(1<<uint(len(pins)-1-i)) != 0 {
+			bits[openPin.request] |= 1 << uint(openPin.index)
+		}
+	}
+
+	for req, mask := range masks {
+		lv := gpioV2LineValues{bits: bits[req], mask: mask}
+		e = req.ioctl(gpioV2LineSetValuesIoctl, unsafe.Pointer(&lv))
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Set whether a pin is active-low, using the kernel's active-low flag. When active-low, High means the line is
+// asserted (electrically low). The setting is remembered for the pin, and takes effect immediately if the pin
+// is open, without changing the electrical level of an output.
+func (module *CdevGPIOModule) SetActiveLow(pin Pin, activeLow bool) error {
+	if module.definedPins[pin] == nil {
+		return fmt.Errorf("pin %d is not known as a GPIO pin", pin)
+	}
+
+	module.activeLow[pin] = activeLow
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return nil
+	}
+
+	config := openPin.request.configs[openPin.index]
+	if config.ActiveLow == activeLow {
+		return nil
+	}
+	config.ActiveLow = activeLow
+	return openPin.request.reconfigure(map[int]PinConfig{openPin.index: config})
+}
+
+func (module *CdevGPIOModule) ClosePin(pin Pin) error {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return errors.New("pin is being closed but has not been opened, call PinMode")
+	}
+
+	req := openPin.request
+	if req.events[openPin.index] != nil {
+		e := module.StopWatchingEdges(pin)
+		if e != nil {
+			return e
+		}
+	}
+	req.open[openPin.index] = false
+	delete(module.openPins, pin)
+
+	// closing the line request releases the lines back to the kernel, once none of them are in use.
+	if !req.inUse() {
+		module.removeRequest(req)
+		e := req.file.Close()
+		if e != nil {
+			return e
+		}
+	}
+
+	return UnassignPinFrom(pin, module)
+}
+
+// Start watching an input pin for edges. The line is reconfigured with edge detection, which doesn't change its
+// value or bias.
+func (module *CdevGPIOModule) WatchEdges(pin Pin, edge Edge) (<-chan EdgeEvent, error) {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return nil, errors.New("pin is being watched but has not been opened, call PinMode")
+	}
+	req, i := openPin.request, openPin.index
+	if req.events[i] != nil {
+		return nil, fmt.Errorf("pin %d is already being watched for edges", pin)
+	}
+
+	req.edges[i] = edge
+	e := req.reconfigure(nil)
+	if e != nil {
+		req.edges[i] = EdgeNone
+		return nil, e
+	}
+
+	ch := make(chan EdgeEvent, 64)
+	req.Lock()
+	if req.events == nil {
+		req.events = make(map[int]chan EdgeEvent)
+	}
+	req.events[i] = ch
+	watched := len(req.events)
+	req.Unlock()
+
+	// the first line watched starts the request's file being waited on
+	if watched == 1 {
+		if e = edgePolling.add(req.fd(), req); e != nil {
+			req.stopWatching(i)
+			return nil, e
+		}
+	}
+	return ch, nil
+}
+
+// Stop watching a pin for edges, and close its channel.
+func (module *CdevGPIOModule) StopWatchingEdges(pin Pin) error {
+	openPin := module.openPins[pin]
+	if openPin == nil || openPin.request.events[openPin.index] == nil {
+		return fmt.Errorf("pin %d is not being watched for edges", pin)
+	}
+	req, i := openPin.request, openPin.index
+
+	req.Lock()
+	watched := len(req.events)
+	req.Unlock()
+	if watched == 1 {
+		edgePolling.remove(req.fd())
+	}
+	return req.stopWatching(i)
+}
+
+// Return the name of a pin's line from the kernel, which comes from gpio-line-names in device tree.
+func (module *CdevGPIOModule) LineName(pin Pin) string {
+	p := module.definedPins[pin]
+	if p == nil {
+		return ""
+	}
+	info, e := readGPIOLineInfo(p.chip, p.line)
+	if e != nil {
+		return ""
+	}
+	return gpioCString(info.name[:])
+}
+
+// Request lines from a chip for a set of pins, which must already be assigned, and open them. Lines that are
+// already in use, by a kernel driver or another program, give a PinInUseError.
+func (module *CdevGPIOModule) requestLines(chip string, pins []Pin, configs []PinConfig) error {
+	req := &cdevLineRequest{chip: chip, pins: pins}
+	req.lines = make([]int, len(pins))
+	req.configs = make([]PinConfig, len(pins))
+	req.open = make([]bool, len(pins))
+	req.edges = make([]Edge, len(pins))
+
+	changes := make(map[int]PinConfig)
+	for i, pin := range pins {
+		req.lines[i] = module.definedPins[pin].line
+		changes[i] = configs[i]
+
+		e := checkGPIOLineFree(pin, chip, req.lines[i])
+		if e != nil {
+			UnassignPinsFrom(pins, module)
+			return e
+		}
+	}
+
+	e := req.reconfigure(changes)
+	if e != nil {
+		UnassignPinsFrom(pins, module)
+		return e
+	}
+
+	module.requests = append(module.requests, req)
+	for i, pin := range pins {
+		req.open[i] = true
+		module.openPins[pin] = &CdevGPIOModuleOpenPin{pin: pin, request: req, index: i}
+	}
+	return nil
+}
+
+// Find a request that still holds the line for a pin that has been closed.
+func (module *CdevGPIOModule) heldRequest(pin Pin) (*cdevLineRequest, int) {
+	for _, req := range module.requests {
+		for i, p := range req.pins {
+			if p == pin && !req.open[i] {
+				return req, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+func (module *CdevGPIOModule) removeRequest(req *cdevLineRequest) {
+	for i, r := range module.requests {
+		if r == req {
+			module.requests = append(module.requests[:i], module.requests[i+1:]...)
+			return
+		}
+	}
+}
+
+// Work out which lines of which requests a set of pins refers to. All pins must be open.
+func (module *CdevGPIOModule) groupMasks(pins PinList) (map[*cdevLineRequest]uint64, error) {
+	if len(pins) > 32 {
+		return nil, errors.New("groups of pins are limited to 32 pins")
+	}
+
+	masks := make(map[*cdevLineRequest]uint64)
+	for _, pin := range pins {
+		openPin := module.openPins[pin]
+		if openPin == nil {
+			return nil, fmt.Errorf("pin %d is being used in a group but has not been opened, call PinModeGroup", pin)
+		}
+		masks[openPin.request] |= 1 << uint(openPin.index)
+	}
+	return masks, nil
+}
+
+// Perform an ioctl on the request's file. This goes through the file's raw connection rather than Fd, which would
+// put the file into blocking mode.
+func (req *cdevLineRequest) ioctl(request uintptr, arg unsafe.Pointer) error {
+	rc, e := req.file.SyscallConn()
+	if e != nil {
+		return e
+	}
+	var ioctlError error
+	e = rc.Control(func(fd uintptr) {
+		ioctlError = gpioIoctl(fd, request, arg)
+	})
+	if e != nil {
+		return e
+	}
+	return ioctlError
+}
+
+// Return the request's file descriptor, without putting the file into blocking mode as Fd would.
+func (req *cdevLineRequest) fd() int {
+	var result int
+	rc, e := req.file.SyscallConn()
+	if e == nil {
+		rc.Control(func(fd uintptr) {
+			result = int(fd)
+		})
+	}
+	return result
+}
+
+// Stop delivering the events of a line, close its channel and reconfigure it without edge detection.
+func (req *cdevLineRequest) stopWatching(i int) error {
+	req.Lock()
+	close(req.events[i])
+	delete(req.events, i)
+	req.Unlock()
+	req.edges[i] = EdgeNone
+	return req.reconfigure(nil)
+}
+
+// Read the edge events that are ready from the request's file, delivering them to the channel of their line, for
+// the edge poller. Events of lines that aren't watched any more are dropped.
+func (req *cdevLineRequest) readEdges(buffer []byte) (delivered int, dropped int) {
+	rc, e := req.file.SyscallConn()
+	if e != nil {
+		return 0, 0
+	}
+	size := int(unsafe.Sizeof(gpioV2LineEvent{}))
+	buffer = buffer[:len(buffer)/size*size]
+
+	req.Lock()
+	defer req.Unlock()
+	for {
+		var n int
+		rc.Control(func(fd uintptr) {
+			n, e = syscall.Read(int(fd), buffer)
+		})
+		if e != nil || n <= 0 {
+			return delivered, dropped
+		}
+		for offset := 0; offset+size <= n; offset += size {
+			event := (*gpioV2LineEvent)(unsafe.Pointer(&buffer[offset]))
+			for i, line := range req.lines {
+				ch := req.events[i]
+				if line != int(event.offset) || ch == nil {
+					continue
+				}
+				select {
+				case ch <- EdgeEvent{
+					Pin:       req.pins[i],
+					Rising:    event.id == gpioV2LineEventRisingEdge,
+					Timestamp: time.Duration(event.timestampNs),
+					Seq:       event.lineSeqno,
+				}:
+					delivered++
+				default:
+					dropped++
+				}
+			}
+		}
+	}
+}
+
+// Determine if any pins of the request are open.
+func (req *cdevLineRequest) inUse() bool {
+	for _, open := range req.open {
+		if open {
+			return true
+		}
+	}
+	return false
+}
+
+// Change the configuration of some lines of the request, given by index, and apply the config of all lines to
+// the kernel. If the lines have not been requested yet, they are requested. The kernel sets outputs to the
+// value given in the config, so unless an initial value is given the current level of each output is read first
+// and carried across, to avoid glitching them. New outputs start at Low.
+func (req *cdevLineRequest) reconfigure(changes map[int]PinConfig) error {
+	current := uint64(0)
+	if req.file != nil {
+		lv := gpioV2LineValues{mask: 1<<uint(len(req.pins)) - 1}
+		e := req.ioctl(gpioV2LineGetValuesIoctl, unsafe.Pointer(&lv))
+		if e != nil {
+			return e
+		}
+		current = lv.bits
+	}
+
+	configs := make([]PinConfig, len(req.configs))
+	copy(configs, req.configs)
+
+	values := uint64(0)
+	for i := range configs {
+		old := configs[i]
+		if c, ok := changes[i]; ok {
+			configs[i] = c
+		}
+		c := configs[i]
+		bit := uint64(1) << uint(i)
+
+		switch {
+		case c.UseInitialValue:
+			if c.InitialValue != Low {
+				values |= bit
+			}
+		case req.file != nil && old.ActiveLow != c.ActiveLow:
+			// values are logical, so correct for a change in polarity
+			values |= (current ^ bit) & bit
+		default:
+			values |= current & bit
+		}
+	}
+
+	lc, e := cdevLineConfigFor(configs, req.edges, values)
+	if e != nil {
+		return e
+	}
+
+	if req.file == nil {
+		req.file, e = cdevRequestLines(req.chip, req.lines, lc)
+		if e != nil {
+			return fmt.Errorf("could not request pins %v (%s lines %v): %s", req.pins, req.chip, req.lines, e)
+		}
+	} else {
+		e = req.ioctl(gpioV2LineSetConfigIoctl, unsafe.Pointer(&lc))
+		if e != nil {
+			return fmt.Errorf("could not configure pins %v: %s", req.pins, e)
+		}
+	}
+
+	// initial values have been applied, so they should not be applied again when other lines change.
+	for i := range configs {
+		configs[i].UseInitialValue = false
+	}
+	req.configs = configs
+	return nil
+}
+
+// Build the kernel line config for a set of lines. The flags of the first line are the default, and lines with
+// different flags are described by attributes, as are the output values.
+func cdevLineConfigFor(configs []PinConfig, edges []Edge, values uint64) (gpioV2LineConfig, error) {
+	lc := gpioV2LineConfig{}
+	outputs := uint64(0)
+
+	for i, config := range configs {
+		flags, e := cdevLineFlags(config)
+		if e != nil {
+			return lc, e
+		}
+		if edges[i] != EdgeNone {
+			if config.Mode == Output {
+				return lc, errors.New("edges can only be watched on an input")
+			}
+			flags |= cdevEdgeFlags(edges[i])
+		}
+		if config.Mode == Output {
+			outputs |= 1 << uint(i)
+		}
+		if config.Debounce != 0 {
+			if config.Mode == Output {
+				return lc, errors.New("debouncing can only be applied to an input")
+			}
+			if e := lc.addDebounce(i, config.Debounce); e != nil {
+				return lc, e
+			}
+		}
+
+		if i == 0 {
+			lc.flags = flags
+			continue
+		}
+		if flags == lc.flags {
+			continue
+		}
+
+		// add to an existing attribute with the same flags, or create a new one
+		found := false
+		for a := uint32(0); a < lc.numAttrs; a++ {
+			if lc.attrs[a].attr.id == gpioV2LineAttrIdFlags && lc.attrs[a].attr.value == flags {
+				lc.attrs[a].mask |= 1 << uint(i)
+				found = true
+			}
+		}
+		if !found {
+			// one attribute is always left for output values
+			if lc.numAttrs >= gpioV2LineNumAttrsMax-1 {
+				return lc, errors.New("too many different pin configurations in one group")
+			}
+			attr := &lc.attrs[lc.numAttrs]
+			attr.attr.id = gpioV2LineAttrIdFlags
+			attr.attr.value = flags
+			attr.mask = 1 << uint(i)
+			lc.numAttrs++
+		}
+	}
+
+	if outputs != 0 {
+		lc.setOutputValues(values&outputs, outputs)
+	}
+	return lc, nil
+}
+
+// Translate a PinConfig into character device line flags.
+func cdevLineFlags(config PinConfig) (uint64, error) {
+	var flags uint64
+
+	if config.Mode == Output {
+		flags |= gpioV2LineFlagOutput
+	} else {
+		flags |= gpioV2LineFlagInput
+	}
+
+	switch config.Drive {
+	case DriveOpenDrain:
+		flags |= gpioV2LineFlagOpenDrain
+	case DriveOpenSource:
+		flags |= gpioV2LineFlagOpenSource
+	}
+	if config.Drive != DrivePushPull && config.Mode != Output {
+		return 0, fmt.Errorf("%s drive can only be used on an output", config.Drive)
+	}
+
+	switch config.effectiveBias() {
+	case BiasDisabled:
+		flags |= gpioV2LineFlagBiasDisabled
+	case BiasPullUp:
+		flags |= gpioV2LineFlagBiasPullUp
+	case BiasPullDown:
+		flags |= gpioV2LineFlagBiasPullDown
+	}
+
+	if config.ActiveLow {
+		flags |= gpioV2LineFlagActiveLow
+	}
+
+	return flags, nil
+}
+
+// Translate an edge into character device line flags. Edges are timestamped with the monotonic clock, which is
+// the kernel's default.
+func cdevEdgeFlags(edge Edge) uint64 {
+	switch edge {
+	case EdgeRising:
+		return gpioV2LineFlagEdgeRising
+	case EdgeFalling:
+		return gpioV2LineFlagEdgeFalling
+	case EdgeBoth:
+		return gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling
+	}
+	return 0
+}
+
+// Request lines from a chip, returning the file for the line request. The file is non-blocking, so the edge poller
+// can read all the events that are ready without waiting for more.
+func cdevRequestLines(chip string, lines []int, lc gpioV2LineConfig) (*os.File, error) {
+	if len(lines) > gpioV2LinesMax {
+		return nil, fmt.Errorf("at most %d lines can be requested together", gpioV2LinesMax)
+	}
+
+	chipFile, e := os.OpenFile(chip, os.O_RDWR, 0)
+	if e != nil {
+		return nil, e
+	}
+	// the line request stays valid after the chip is closed.
+	defer chipFile.Close()
+
+	req := gpioV2LineRequest{numLines: uint32(len(lines))}
+	for i, line := range lines {
+		req.offsets[i] = uint32(line)
+	}
+	copy(req.consumer[:], cdevGPIOConsumer)
+	req.config = lc
+
+	e = gpioIoctl(chipFile.Fd(), gpioV2GetLineIoctl, unsafe.Pointer(&req))
+	if e != nil {
+		return nil, e
+	}
+
+	syscall.SetNonblock(int(req.fd), true)
+	return os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%v", chip, lines)), nil
+}
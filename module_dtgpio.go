@@ -6,14 +6,41 @@ package hwio
 import (
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
+	"strings"
 )
 
+// Location of the GPIO class. This is a variable so tests can point it elsewhere.
+var gpioSysfsPath = "/sys/class/gpio"
+
 type DTGPIOModule struct {
 	name        string
 	definedPins DTGPIOModulePinDefMap
 	openPins    map[Pin]*DTGPIOModuleOpenPin
+
+	// pins set active-low by SetActiveLow. This persists across PinMode and ClosePin.
+	activeLow map[Pin]bool
+
+	// what to do with pins when they are closed, for the module and for individual pins
+	closePolicy    ClosePolicy
+	pinClosePolicy map[Pin]ClosePolicy
+
+	// the state of pins before they were opened, for RestorePrevious. This persists while a pin is reopened
+	// with a different mode.
+	previous map[Pin]*dtGPIOPreviousState
+
+	// the filesystem the GPIO class is on
+	fs SysfsFS
+}
+
+// The state of a pin before it was opened.
+type dtGPIOPreviousState struct {
+	exported bool
+
+	// if exported, the direction ("in" or "out"), the electrical level of an output, and active_low
+	direction string
+	level     int
+	activeLow string
 }
 
 // Represents the definition of a GPIO pin, which should contain all the info required to open, close, read and write the pin
@@ -31,12 +58,21 @@ type DTGPIOModuleOpenPin struct {
 	gpioLogical  int
 	gpioBaseName string
 	mode         PinIOMode
-	valueFile    *os.File
+	config       PinConfig
+	valueFile    SysfsFile
+	fs           SysfsFS
+
+	// the name of the module, which the value file is opened for
+	owner string
 }
 
 func NewDTGPIOModule(name string) (result *DTGPIOModule) {
 	result = &DTGPIOModule{name: name}
 	result.openPins = make(map[Pin]*DTGPIOModuleOpenPin)
+	result.activeLow = make(map[Pin]bool)
+	result.pinClosePolicy = make(map[Pin]ClosePolicy)
+	result.previous = make(map[Pin]*dtGPIOPreviousState)
+	result.fs = defaultSysfs
 	return result
 }
 
@@ -52,15 +88,21 @@ func (module *DTGPIOModule) SetOptions(options map[string]interface{}) error {
 	return nil
 }
 
+// Set the filesystem the module finds the GPIO class on, e.g. a FakeSysfs in tests. This must be called before any
+// pins are opened.
+func (module *DTGPIOModule) SetFilesystem(fsys SysfsFS) {
+	module.fs = fsys
+}
+
 // enable GPIO module. It doesn't allocate any pins immediately.
 func (module *DTGPIOModule) Enable() error {
 	return nil
 }
 
-// disables module and release any pins assigned.
+// disables module and release any pins assigned. Each pin is left according to its close policy.
 func (module *DTGPIOModule) Disable() error {
-	for _, openPin := range module.openPins {
-		openPin.gpioUnexport()
+	for pin := range module.openPins {
+		module.ClosePin(pin)
 	}
 	return nil
 }
@@ -69,14 +111,68 @@ func (module *DTGPIOModule) GetName() string {
 	return module.name
 }
 
+func (module *DTGPIOModule) KernelInterface() (string, string) {
+	return "sysfs", gpioSysfsPath
+}
+
+func (module *DTGPIOModule) RequiredAccess() []RequiredAccess {
+	return []RequiredAccess{{Path: gpioSysfsPath + "/export", Write: true}, {Path: gpioSysfsPath + "/unexport", Write: true}}
+}
+
+// Set what happens to pins when they are closed or the module is disabled. The default is UnexportOnClose.
+func (module *DTGPIOModule) SetClosePolicy(policy ClosePolicy) {
+	module.closePolicy = policy
+}
+
+// Set what happens to a pin when it is closed, overriding the module's close policy.
+func (module *DTGPIOModule) SetPinClosePolicy(pin Pin, policy ClosePolicy) {
+	module.pinClosePolicy[pin] = policy
+}
+
+func (module *DTGPIOModule) getClosePolicy(pin Pin) ClosePolicy {
+	if policy, ok := module.pinClosePolicy[pin]; ok {
+		return policy
+	}
+	return module.closePolicy
+}
+
 func (module *DTGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
+	return module.PinModeConfig(pin, PinConfig{Mode: mode})
+}
+
+// Set the mode of a pin with extended attributes. The sysfs interface has no notion of drive or bias, so:
+// - open-drain and open-source outputs are emulated by switching the line to input when it should float.
+// - active-low is applied in software, so it behaves the same on kernels without the active_low attribute.
+// - an explicit bias or drive strength returns an error.
+func (module *DTGPIOModule) PinModeConfig(pin Pin, config PinConfig) error {
 	if module.definedPins[pin] == nil {
 		return fmt.Errorf("pin %d is not known as a GPIO pin", pin)
 	}
 
-	// close if already open and the new mode in different
-	if oldOpenPin, ok := module.openPins[pin]; ok && mode != oldOpenPin.mode {
-		ClosePin(pin)
+	if config.Bias != BiasDefault {
+		return fmt.Errorf("module '%s' cannot set bias on pin %d, sysfs does not support it", module.GetName(), pin)
+	}
+	if config.DriveStrength != 0 {
+		return fmt.Errorf("module '%s' cannot set drive strength on pin %d, sysfs does not support it", module.GetName(), pin)
+	}
+	if config.Debounce != 0 {
+		return fmt.Errorf("module '%s' cannot debounce pin %d, sysfs does not support it", module.GetName(), pin)
+	}
+	if config.Drive != DrivePushPull && config.Mode != Output {
+		return fmt.Errorf("pin %d can only use %s drive as an output", pin, config.Drive)
+	}
+	config.ActiveLow = config.ActiveLow || module.activeLow[pin]
+
+	// if already open in the same mode, there is nothing to do but set the initial value if there is one. Otherwise
+	// close it, leaving it exported as it is reopened straight away.
+	if oldOpenPin, ok := module.openPins[pin]; ok {
+		if config == oldOpenPin.config {
+			if config.UseInitialValue {
+				return module.DigitalWrite(pin, config.InitialValue)
+			}
+			return nil
+		}
+		module.releasePin(oldOpenPin)
 	}
 
 	// attempt to assign this pin for this module.
@@ -91,17 +187,44 @@ func (module *DTGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
 		return e
 	}
 
+	if module.previous[pin] == nil {
+		module.previous[pin] = openPin.gpioPreviousState()
+	}
+
 	e = openPin.gpioExport()
 	if e != nil {
+		module.releasePin(openPin)
 		return e
 	}
 
-	if mode == Output {
-		e = openPin.gpioDirection("out")
-		if e != nil {
-			return e
+	e = openPin.gpioResetActiveLow()
+	if e != nil {
+		return e
+	}
+
+	// the direction values "high" and "low" are electrical levels, unaffected by active-low.
+	initial := config.InitialValue
+	if config.ActiveLow {
+		initial = Negate(initial)
+	}
+
+	switch {
+	case config.Drive != DrivePushPull:
+		// emulated outputs start floating, which is the inactive state for both open-drain and open-source.
+		e = openPin.gpioDirection("in")
+		if e == nil && config.UseInitialValue {
+			e = openPin.gpioSetEmulatedValue(initial)
 		}
-	} else {
+	case config.Mode == Output && (config.UseInitialValue || config.ActiveLow):
+		// an active-low output without an initial value starts at Low (deasserted), like other outputs
+		if initial == Low {
+			e = openPin.gpioDirection("low")
+		} else {
+			e = openPin.gpioDirection("high")
+		}
+	case config.Mode == Output:
+		e = openPin.gpioDirection("out")
+	default:
 		e = openPin.gpioDirection("in")
 		// @todo implement pull up and pull down support
 
@@ -112,12 +235,13 @@ func (module *DTGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
 		// } else if mode == InputPullDown {
 		// 	pull = BB_CONF_PULLDOWN
 		// }
-
-		if e != nil {
-			return e
-		}
 	}
-	openPin.mode = mode
+	if e != nil {
+		return e
+	}
+
+	openPin.mode = config.Mode
+	openPin.config = config
 	return nil
 }
 
@@ -129,6 +253,12 @@ func (module *DTGPIOModule) DigitalWrite(pin Pin, value int) (e error) {
 	// 	if a.pinIOMode != Output {
 	// 		return errors.New(fmt.Sprintf("DigitalWrite: pin %d mode is not set for output", pin))
 	// 	}
+	if openPin.config.ActiveLow {
+		value = Negate(value)
+	}
+	if openPin.config.Drive != DrivePushPull {
+		return openPin.gpioSetEmulatedValue(value)
+	}
 	openPin.gpioSetValue(value)
 	return nil
 }
@@ -143,24 +273,63 @@ func (module *DTGPIOModule) DigitalRead(pin Pin) (value int, e error) {
 	// 		return
 	// 	}
 
-	return openPin.gpioGetValue()
+	value, e = openPin.gpioGetValue()
+	if openPin.config.ActiveLow {
+		value = Negate(value)
+	}
+	return value, e
+}
+
+// Set whether a pin is active-low. When active-low, High means the line is asserted (electrically low), so
+// DigitalWrite(pin, High) turns on an inverted relay. The setting is remembered for the pin, and takes effect
+// immediately if the pin is open.
+func (module *DTGPIOModule) SetActiveLow(pin Pin, activeLow bool) error {
+	if module.definedPins[pin] == nil {
+		return fmt.Errorf("pin %d is not known as a GPIO pin", pin)
+	}
+
+	module.activeLow[pin] = activeLow
+	if openPin := module.openPins[pin]; openPin != nil {
+		openPin.config.ActiveLow = activeLow
+	}
+	return nil
 }
 
+// Close a pin, leaving it according to its close policy.
 func (module *DTGPIOModule) ClosePin(pin Pin) error {
 	openPin := module.openPins[pin]
 	if openPin == nil {
 		return errors.New("pin is being closed but has not been opened, call PinMode")
 	}
-	e := openPin.gpioUnexport()
-	if e != nil {
-		return e
+
+	previous := module.previous[pin]
+	delete(module.previous, pin)
+
+	var e error
+	switch policy := module.getClosePolicy(pin); {
+	case policy == LeaveExported:
+	case policy == RestorePrevious && previous != nil && previous.exported:
+		e = openPin.gpioRestore(previous)
+	default:
+		e = openPin.gpioUnexport()
 	}
-	e = openPin.valueFile.Close()
 	if e != nil {
 		return e
 	}
-	delete(module.openPins, pin)
-	return UnassignPin(pin)
+
+	return module.releasePin(openPin)
+}
+
+// Release an open pin without changing its state.
+func (module *DTGPIOModule) releasePin(openPin *DTGPIOModuleOpenPin) error {
+	if openPin.valueFile != nil {
+		e := openPin.valueFile.Close()
+		if e != nil {
+			return e
+		}
+	}
+	delete(module.openPins, openPin.pin)
+	return UnassignPinFrom(openPin.pin, module)
 }
 
 // create an openPin object and put it in the map.
@@ -170,7 +339,7 @@ func (module *DTGPIOModule) makeOpenGPIOPin(pin Pin) (*DTGPIOModuleOpenPin, erro
 		return nil, fmt.Errorf("pin %d is not known to GPIO module", pin)
 	}
 
-	result := &DTGPIOModuleOpenPin{pin: pin, gpioLogical: p.gpioLogical}
+	result := &DTGPIOModuleOpenPin{pin: pin, gpioLogical: p.gpioLogical, fs: module.fs, owner: module.name}
 	module.openPins[pin] = result
 
 	return result, nil
@@ -183,13 +352,19 @@ func (module *DTGPIOModule) makeOpenGPIOPin(pin Pin) (*DTGPIOModuleOpenPin, erro
 
 // Needs to be called to allocate the GPIO pin
 func (op *DTGPIOModuleOpenPin) gpioExport() error {
-	bn := "/sys/class/gpio/gpio" + strconv.Itoa(op.gpioLogical)
-	if !fileExists(bn) {
+	bn := gpioSysfsPath + "/gpio" + strconv.Itoa(op.gpioLogical)
+	if !sysfsExists(op.fs, bn) {
+		// a line exported through sysfs is reused, but one claimed by a kernel driver can't be
+		if consumer := gpioDebugfsConsumer(op.fs, op.gpioLogical); consumer != "" && consumer != "sysfs" {
+			return &PinInUseError{Pin: op.pin, Line: fmt.Sprintf("gpio %d", op.gpioLogical), Consumer: consumer}
+		}
+
 		s := strconv.FormatInt(int64(op.gpioLogical), 10)
-		e := WriteStringToFile("/sys/class/gpio/export", s)
+		e := sysfsWriteString(op.fs, gpioSysfsPath+"/export", s)
 		if e != nil {
 			return e
 		}
+		sysfsWaitForExport(op.fs, bn+"/direction")
 	}
 
 	// calculate the base name for the gpio pin
@@ -200,7 +375,7 @@ func (op *DTGPIOModuleOpenPin) gpioExport() error {
 // Needs to be called to allocate the GPIO pin
 func (op *DTGPIOModuleOpenPin) gpioUnexport() error {
 	s := strconv.FormatInt(int64(op.gpioLogical), 10)
-	e := WriteStringToFile("/sys/class/gpio/unexport", s)
+	e := sysfsWriteString(op.fs, gpioSysfsPath+"/unexport", s)
 	if e != nil {
 		return e
 	}
@@ -208,33 +383,115 @@ func (op *DTGPIOModuleOpenPin) gpioUnexport() error {
 	return nil
 }
 
-// Once exported, the direction of a GPIO can be set
+// Get the state of the pin before it is exported by us, for RestorePrevious.
+func (op *DTGPIOModuleOpenPin) gpioPreviousState() *dtGPIOPreviousState {
+	bn := gpioSysfsPath + "/gpio" + strconv.Itoa(op.gpioLogical)
+	if !sysfsExists(op.fs, bn) {
+		return &dtGPIOPreviousState{}
+	}
+
+	read := func(f string) string {
+		b, _ := sysfsReadFile(op.fs, bn+"/"+f)
+		return strings.TrimSpace(string(b))
+	}
+
+	result := &dtGPIOPreviousState{exported: true, direction: read("direction"), activeLow: read("active_low")}
+	if read("value") == "1" {
+		result.level = High
+	}
+	// the value is logical, so invert it to get the electrical level if the pin was active-low
+	if result.activeLow == "1" {
+		result.level = Negate(result.level)
+	}
+	return result
+}
+
+// Put a pin back to its state before it was opened. Outputs are restored with direction and level set together.
+func (op *DTGPIOModuleOpenPin) gpioRestore(previous *dtGPIOPreviousState) error {
+	if previous.activeLow != "" {
+		e := sysfsWriteString(op.fs, op.gpioBaseName+"/active_low", previous.activeLow)
+		if e != nil {
+			return e
+		}
+	}
+
+	dir := "in"
+	if previous.direction == "out" {
+		dir = "low"
+		if previous.level == High {
+			dir = "high"
+		}
+	}
+	return sysfsWriteString(op.fs, op.gpioBaseName+"/direction", dir)
+}
+
+// Once exported, the direction of a GPIO can be set. "high" and "low" set the direction to output and the
+// value at the same time.
 func (op *DTGPIOModuleOpenPin) gpioDirection(dir string) error {
-	if dir != "in" && dir != "out" {
-		return errors.New("direction must be in or out")
+	if dir != "in" && dir != "out" && dir != "high" && dir != "low" {
+		return errors.New("direction must be in, out, high or low")
 	}
+	// "in" and "out" are not written if the direction is already right. As well as saving the write, this leaves
+	// the level of an output alone, where writing "out" would set it low.
 	f := op.gpioBaseName + "/direction"
-	e := WriteStringToFile(f, dir)
-
-	mode := os.O_WRONLY | os.O_TRUNC
-	if dir == "in" {
-		mode = os.O_RDONLY
+	if (dir != "in" && dir != "out") || op.gpioReadDirection() != dir {
+		e := sysfsWriteExported(op.fs, f, dir)
+		if e != nil {
+			return e
+		}
 	}
 
 	// open the value file with the correct mode. Put that file in 'op'. Note that we keep this file open
-	// continuously for performance.
+	// continuously for performance, unless there is a file descriptor budget.
 	// Preliminary tests on 200,000 DigitalWrites indicate an order of magnitude improvement when we don't have
 	// to re-open the file each time. Re-seeking and writing a new value suffices.
-	op.valueFile, e = os.OpenFile(op.gpioBaseName+"/value", mode, 0666)
+	return retryExport(func() (e error) {
+		op.valueFile, e = openManagedFile(op.fs, op.owner, sysfsName(op.gpioBaseName+"/value"), dir != "in")
+		return e
+	})
+}
+
+// Read the current direction of the exported pin, "in" or "out", or "" if it can't be read.
+func (op *DTGPIOModuleOpenPin) gpioReadDirection() string {
+	b, e := sysfsReadFile(op.fs, op.gpioBaseName+"/direction")
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
 
-	return e
+// Clear the active_low attribute of the exported pin, in case a previous user of the pin left it set, as
+// inversion is done in software. Kernels without the attribute, and pins already clear, are left alone.
+func (op *DTGPIOModuleOpenPin) gpioResetActiveLow() error {
+	f := op.gpioBaseName + "/active_low"
+	b, e := sysfsReadFile(op.fs, f)
+	if e != nil || strings.TrimSpace(string(b)) == "0" {
+		return nil
+	}
+	return sysfsWriteExported(op.fs, f, "0")
+}
+
+// Set the value of an emulated open-drain or open-source output. The line is driven only for the active level
+// of the drive (low for open-drain, high for open-source); otherwise it is switched to an input so it floats.
+// The direction values "low" and "high" set direction and value in one write, so the line does not glitch.
+func (op *DTGPIOModuleOpenPin) gpioSetEmulatedValue(value int) error {
+	level := value != Low
+
+	dir := "in"
+	if op.config.Drive == DriveOpenDrain && !level {
+		dir = "low"
+	} else if op.config.Drive == DriveOpenSource && level {
+		dir = "high"
+	}
+
+	return sysfsWriteString(op.fs, op.gpioBaseName+"/direction", dir)
 }
 
 // Get the value. Will return High or Low
 func (op *DTGPIOModuleOpenPin) gpioGetValue() (int, error) {
-	var b []byte
-	b = make([]byte, 1)
-	n, e := op.valueFile.ReadAt(b, 0)
+	b := sysfsBuffers.Get().(*sysfsBuffer)
+	defer sysfsBuffers.Put(b)
+	n, e := op.valueFile.ReadAt(b[:1], 0)
 
 	value := 0
 	if n > 0 {
@@ -247,6 +504,9 @@ func (op *DTGPIOModuleOpenPin) gpioGetValue() (int, error) {
 	return value, e
 }
 
+// The contents of a value file for Low and High.
+var gpioValueBytes = [2][]byte{[]byte("0"), []byte("1")}
+
 // Set the value, Expects High or Low
 func (op *DTGPIOModuleOpenPin) gpioSetValue(value int) error {
 	if op.valueFile == nil {
@@ -259,14 +519,12 @@ func (op *DTGPIOModuleOpenPin) gpioSetValue(value int) error {
 		return e
 	}
 
-	// Write a 1 or 0.
-	// @todo investigate if we'd get better performance if we have precalculated []byte values with 0 and 1, and
-	// use write directly instead of WriteString. Probably only marginal.
-	// @todo also check out http://hackaday.com/2013/12/07/speeding-up-beaglebone-black-gpio-a-thousand-times/
+	// Write a 1 or 0, preformatted so the write doesn't allocate.
+	// @todo check out http://hackaday.com/2013/12/07/speeding-up-beaglebone-black-gpio-a-thousand-times/
 	if value == 0 {
-		op.valueFile.WriteString("0")
+		op.valueFile.Write(gpioValueBytes[0])
 	} else {
-		op.valueFile.WriteString("1")
+		op.valueFile.Write(gpioValueBytes[1])
 	}
 
 	return nil
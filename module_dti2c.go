@@ -13,8 +13,11 @@ package hwio
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -29,8 +32,16 @@ type DTI2CModule struct {
 	deviceFile  string
 	definedPins DTI2CModulePins
 
+	// GPIO lines of the bus, for BusRecover, or nil if not known
+	recovery *DTI2CRecoveryLines
+
 	// File used to represent the bus once it's opened
 	fd *os.File
+
+	// The arguments and data of the transfer in progress, guarded by the lock. They are kept in the module so
+	// transfers don't allocate, and so they stay put while the kernel uses them.
+	smbus i2cSmbusIoctlData
+	block [I2CSMBusBlockMax + 2]byte
 }
 
 // Data that is passed to/from ioctl calls
@@ -65,6 +76,7 @@ func NewDTI2CModule(name string) (result *DTI2CModule) {
 // - "device" - a string that identifies the device file, e.g. "/dev/i2c-1".
 // - "pins" - an object of type DTI2CModulePins that identifies the pins that will be assigned
 //	 when this module is enabled.
+// - "recovery" - optionally, a DTI2CRecoveryLines giving the GPIO lines of the bus's SDA and SCL, for BusRecover.
 func (module *DTI2CModule) SetOptions(options map[string]interface{}) error {
 	// get the device
 	vd := options["device"]
@@ -82,6 +94,10 @@ func (module *DTI2CModule) SetOptions(options map[string]interface{}) error {
 
 	module.definedPins = vp.(DTI2CModulePins)
 
+	if vr, ok := options["recovery"].(DTI2CRecoveryLines); ok {
+		module.recovery = &vr
+	}
+
 	return nil
 }
 
@@ -105,12 +121,15 @@ func (module *DTI2CModule) Enable() error {
 
 // disables module and release any pins assigned.
 func (module *DTI2CModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
 	if e := module.fd.Close(); e != nil {
 		return e
 	}
+	module.fd = nil
 
 	for _, pin := range module.definedPins {
-		UnassignPin(pin)
+		UnassignPinFrom(pin, module)
 	}
 
 	return nil
@@ -120,6 +139,41 @@ func (module *DTI2CModule) GetName() string {
 	return module.name
 }
 
+// Return 1 while the bus is open, and 0 otherwise.
+func (module *DTI2CModule) OpenFileDescriptors() int {
+	module.Lock()
+	defer module.Unlock()
+	if module.fd == nil {
+		return 0
+	}
+	return 1
+}
+
+func (module *DTI2CModule) KernelInterface() (string, string) {
+	return "i2c-dev", module.deviceFile
+}
+
+func (module *DTI2CModule) RequiredAccess() []RequiredAccess {
+	return []RequiredAccess{{Path: module.deviceFile, Write: true}}
+}
+
+// Find the i2c-dev device file for the adapter of an I2C controller, given the controller's device tree node
+// name, e.g. "ff160000.i2c". Adapter numbers depend on probe order and aliases, so they can't be assumed on
+// boards with several controllers. Returns "" if the controller has no adapter.
+func findI2CDevice(controller string) string {
+	adapters, _ := filepath.Glob("/sys/bus/i2c/devices/i2c-*")
+	for _, a := range adapters {
+		path, e := filepath.EvalSymlinks(a)
+		if e != nil {
+			continue
+		}
+		if strings.Contains(path, "/"+controller+"/") {
+			return "/dev/" + filepath.Base(a)
+		}
+	}
+	return ""
+}
+
 func (module *DTI2CModule) GetDevice(address int) I2CDevice {
 	return NewDTI2CDevice(module, address)
 }
@@ -134,116 +188,136 @@ func NewDTI2CDevice(module *DTI2CModule, address int) *DTI2CDevice {
 }
 
 func (device *DTI2CDevice) Write(command byte, data []byte) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "i2c Write", device.journalTarget(), fmt.Sprintf("0x%02x % x", command, data), "", e)
+		}(time.Now())
+	}
+	if len(data) > I2CSMBusBlockMax {
+		return fmt.Errorf("I2C writes are limited to %d bytes, got %d", I2CSMBusBlockMax, len(data))
+	}
+
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	device.sendSlaveAddress()
-
-	buffer := make([]byte, len(data)+1)
-	buffer[0] = byte(len(data))
-	copy(buffer[1:], data)
+	e = device.sendSlaveAddress()
+	if e != nil {
+		return e
+	}
 
-	//	buffer := make([]byte, numBytes+2)
+	block := &device.module.block
+	block[0] = byte(len(data))
+	copy(block[1:], data)
+	return device.transfer(I2CSMBusWrite, command, I2CSMBusI2CBlockData)
+}
 
-	busData := i2cSmbusIoctlData{
-		readWrite: I2CSMBusWrite,
-		command:   command,
-		size:      I2CSMBusI2CBlockData,
-		data:      uintptr(unsafe.Pointer(&buffer[0])),
+func (device *DTI2CDevice) Read(command byte, numBytes int) (read []byte, e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "i2c Read", device.journalTarget(), fmt.Sprintf("0x%02x %d", command, numBytes), fmt.Sprintf("% x", read), e)
+		}(time.Now())
 	}
-
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2CSMBus, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return syscall.Errno(err)
+	if numBytes < 0 || numBytes > I2CSMBusBlockMax {
+		return nil, fmt.Errorf("I2C reads are limited to %d bytes, got %d", I2CSMBusBlockMax, numBytes)
 	}
 
-	return nil
-}
-
-func (device *DTI2CDevice) Read(command byte, numBytes int) ([]byte, error) {
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	device.sendSlaveAddress()
-
-	buffer := make([]byte, numBytes+1)
-	buffer[0] = byte(numBytes)
-
-	//	buffer := make([]byte, numBytes+2)
-
-	busData := i2cSmbusIoctlData{
-		readWrite: I2CSMBusRead,
-		command:   command,
-		size:      I2CSMBusI2CBlockData,
-		data:      uintptr(unsafe.Pointer(&buffer[0])),
+	e = device.sendSlaveAddress()
+	if e != nil {
+		return nil, e
 	}
 
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2CSMBus, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return nil, syscall.Errno(err)
+	block := &device.module.block
+	block[0] = byte(numBytes)
+	e = device.transfer(I2CSMBusRead, command, I2CSMBusI2CBlockData)
+	if e != nil {
+		return nil, e
 	}
 
 	result := make([]byte, numBytes)
-	copy(result, buffer[1:])
+	copy(result, block[1:])
 
 	return result, nil
 }
 
 // Read 1 byte from the bus
-func (device *DTI2CDevice) ReadByte(command byte) (byte, error) {
+func (device *DTI2CDevice) ReadByte(command byte) (value byte, e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "i2c ReadByte", device.journalTarget(), fmt.Sprintf("0x%02x", command), fmt.Sprintf("0x%02x", value), e)
+		}(time.Now())
+	}
+
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	e := device.sendSlaveAddress()
+	e = device.sendSlaveAddress()
 	if e != nil {
 		return 0, e
 	}
 
-	data := uint8(0)
-
-	busData := i2cSmbusIoctlData{
-		readWrite: I2CSMBusRead,
-		command:   command,
-		size:      I2CSMBusByteData,
-		data:      uintptr(unsafe.Pointer(&data)),
+	e = device.transfer(I2CSMBusRead, command, I2CSMBusByteData)
+	if e != nil {
+		return 0, e
 	}
+	return device.module.block[0], nil
+}
 
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2CSMBus, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return 0, syscall.Errno(err)
+func (device *DTI2CDevice) WriteByte(command byte, value byte) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "i2c WriteByte", device.journalTarget(), fmt.Sprintf("0x%02x 0x%02x", command, value), "", e)
+		}(time.Now())
 	}
 
-	return data, nil
-}
-
-func (device *DTI2CDevice) WriteByte(command byte, value byte) error {
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	e := device.sendSlaveAddress()
+	e = device.sendSlaveAddress()
 	if e != nil {
 		return e
 	}
 
-	busData := i2cSmbusIoctlData{
-		readWrite: I2CSMBusWrite,
-		command:   command,
-		size:      I2CSMBusByteData,
-		data:      uintptr(unsafe.Pointer(&value)),
-	}
+	device.module.block[0] = value
+	return device.transfer(I2CSMBusWrite, command, I2CSMBusByteData)
+}
 
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2CSMBus, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return syscall.Errno(err)
+// Perform an SMBus transfer of the module's block, which holds the data to write or receives the data read. The
+// module must be locked.
+func (device *DTI2CDevice) transfer(readWrite uint8, command byte, size int) error {
+	m := device.module
+	m.smbus = i2cSmbusIoctlData{
+		readWrite: readWrite,
+		command:   command,
+		size:      size,
+		data:      uintptr(unsafe.Pointer(&m.block[0])),
 	}
+	return i2cIoctl(m.fd.Fd(), I2CSMBus, uintptr(unsafe.Pointer(&m.smbus)))
+}
 
-	return nil
+// Return the bus and address, as the journal shows them, e.g. "/dev/i2c-1 0x48".
+func (device *DTI2CDevice) journalTarget() string {
+	return fmt.Sprintf("%s 0x%02x", device.module.deviceFile, device.address)
 }
 
 func (device *DTI2CDevice) sendSlaveAddress() error {
-	_, _, enum := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2CSlave, uintptr(device.address))
-	if enum != 0 {
+	if i2cIoctl(device.module.fd.Fd(), I2CSlave, uintptr(device.address)) != nil {
 		return fmt.Errorf("could not open I2C bus on module %s", device.module.GetName())
 	}
 	return nil
 }
+
+// Perform an I2C ioctl, returning the errno as an error if it fails. arg is a number, or the address of a field of a
+// DTI2CModule, which is on the heap, so it doesn't move. This is a variable so tests can answer the ioctls without
+// an adapter.
+var i2cIoctl = sysI2CIoctl
+
+func sysI2CIoctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if err != 0 {
+		return syscall.Errno(err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package hwio
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -42,6 +43,20 @@ func (m *DTLEDModule) GetName() string {
 	return m.name
 }
 
+func (m *DTLEDModule) KernelInterface() (string, string) {
+	return "sysfs", "/sys/class/leds"
+}
+
+// Each LED's brightness and trigger are written.
+func (m *DTLEDModule) RequiredAccess() []RequiredAccess {
+	result := make([]RequiredAccess, 0)
+	for _, path := range m.definedPins {
+		result = append(result, RequiredAccess{Path: path + "brightness", Write: true}, RequiredAccess{Path: path + "trigger", Write: true})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
 func (m *DTLEDModule) SetOptions(options map[string]interface{}) error {
 	// get the pins
 	if p := options["pins"]; p != "" {
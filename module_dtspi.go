@@ -0,0 +1,325 @@
+// Implementation of the SPI module interface using spidev, for SPI controllers running as master. Each chip select
+// of a bus has its own device, /dev/spidev<bus>.<chip select>, which is opened the first time it's used.
+//
+// A transfer is a list of segments that spidev performs as a single SPI_IOC_MESSAGE ioctl, with chip select held
+// between segments unless a segment asks for it to be released. Devices such as displays and radios, where each
+// operation is a command followed by data, can then be driven with one system call instead of several.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/spi/spidev.html
+// - include/uapi/linux/spi/spidev.h in the kernel tree.
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The pins used by an SPI bus, which are assigned when the module is enabled.
+type DTSPIModulePins []Pin
+
+// ioctls from spidev.h
+const (
+	spiIocWrMaxSpeedHz  = 0x40046b04
+	spiIocWrBitsPerWord = 0x40016b03
+
+	// SPI_IOC_MESSAGE(n) is this plus the size of n transfers shifted into the size field
+	spiIocMessageBase = 0x40006b00
+
+	// the size field of an ioctl request is 14 bits, which limits the number of segments in a message
+	spiMaxSegments = (1<<14 - 1) / int(unsafe.Sizeof(spiIocTransfer{}))
+)
+
+// struct spi_ioc_transfer. Buffers are passed as addresses; they must be kept alive until the ioctl returns.
+type spiIocTransfer struct {
+	txBuf          uint64
+	rxBuf          uint64
+	length         uint32
+	speedHz        uint32
+	delayUsecs     uint16
+	bitsPerWord    uint8
+	csChange       uint8
+	txNbits        uint8
+	rxNbits        uint8
+	wordDelayUsecs uint8
+	pad            uint8
+}
+
+type DTSPIModule struct {
+	sync.Mutex
+
+	name        string
+	bus         int
+	mode        int
+	speedHz     uint32
+	bitsPerWord uint8
+	definedPins DTSPIModulePins
+
+	// open devices, by chip select
+	devices map[int]*os.File
+	enabled bool
+}
+
+func NewDTSPIModule(name string) (result *DTSPIModule) {
+	result = &DTSPIModule{name: name, mode: -1, devices: make(map[int]*os.File)}
+	return result
+}
+
+// Accept options for the SPI module. Expected options include:
+//   - "bus" - the SPI bus number as an int, so chip select n is /dev/spidev<bus>.<n>.
+//   - "mode" - optional, the SPI mode 0-3. If not given, the mode set in device tree is used.
+//   - "speed" - optional, the clock speed in Hz as an int. If not given, the device's maximum speed is used.
+//   - "bits" - optional, the bits per word as an int. Defaults to 8.
+//   - "pins" - an object of type DTSPIModulePins that identifies the pins that will be assigned when this module is
+//     enabled.
+func (module *DTSPIModule) SetOptions(options map[string]interface{}) error {
+	vb := options["bus"]
+	if vb == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'bus' value", module.GetName())
+	}
+	module.bus = vb.(int)
+
+	if vm := options["mode"]; vm != nil {
+		module.mode = vm.(int)
+		if module.mode < 0 || module.mode > 3 {
+			return fmt.Errorf("module '%s' SPI mode must be 0-3, got %d", module.GetName(), module.mode)
+		}
+	}
+
+	if vs := options["speed"]; vs != nil {
+		module.speedHz = uint32(vs.(int))
+	}
+
+	if vbits := options["bits"]; vbits != nil {
+		module.bitsPerWord = uint8(vbits.(int))
+	}
+
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+	module.definedPins = vp.(DTSPIModulePins)
+
+	return nil
+}
+
+// Enable the module, assigning its pins. Devices are opened when they are first used.
+func (module *DTSPIModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.enabled {
+		return nil
+	}
+	for _, pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			module.unassignPins()
+			return e
+		}
+	}
+	module.enabled = true
+	return nil
+}
+
+// Disable the module, closing its devices and releasing the pins.
+func (module *DTSPIModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for cs, fd := range module.devices {
+		fd.Close()
+		delete(module.devices, cs)
+	}
+	if module.enabled {
+		module.unassignPins()
+		module.enabled = false
+	}
+	return nil
+}
+
+func (module *DTSPIModule) unassignPins() {
+	for _, pin := range module.definedPins {
+		UnassignPinFrom(pin, module)
+	}
+}
+
+func (module *DTSPIModule) GetName() string {
+	return module.name
+}
+
+// Return the number of chip selects whose devices are open.
+func (module *DTSPIModule) OpenFileDescriptors() int {
+	module.Lock()
+	defer module.Unlock()
+	return len(module.devices)
+}
+
+func (module *DTSPIModule) deviceFile(slaveSelect int) string {
+	return fmt.Sprintf("/dev/spidev%d.%d", module.bus, slaveSelect)
+}
+
+func (module *DTSPIModule) KernelInterface() (string, string) {
+	return "spidev", module.deviceFile(0)
+}
+
+func (module *DTSPIModule) RequiredAccess() []RequiredAccess {
+	return []RequiredAccess{{Path: module.deviceFile(0), Write: true}}
+}
+
+// Get the open device for a chip select, opening it and applying the module's settings if needed.
+func (module *DTSPIModule) device(slaveSelect int) (*os.File, error) {
+	if !module.enabled {
+		return nil, fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	if fd := module.devices[slaveSelect]; fd != nil {
+		return fd, nil
+	}
+
+	name := module.deviceFile(slaveSelect)
+	fd, e := os.OpenFile(name, os.O_RDWR, 0)
+	if e != nil {
+		return nil, fmt.Errorf("module '%s' could not open %s: %s", module.GetName(), name, e)
+	}
+
+	if module.mode >= 0 {
+		mode := uint8(module.mode)
+		e = spiIoctl(fd, spiIocWrMode, uintptr(unsafe.Pointer(&mode)))
+		if e != nil {
+			fd.Close()
+			return nil, fmt.Errorf("module '%s' could not set SPI mode %d: %s", module.GetName(), module.mode, e)
+		}
+	}
+	if module.speedHz > 0 {
+		speed := module.speedHz
+		e = spiIoctl(fd, spiIocWrMaxSpeedHz, uintptr(unsafe.Pointer(&speed)))
+		if e != nil {
+			fd.Close()
+			return nil, fmt.Errorf("module '%s' could not set SPI speed %dHz: %s", module.GetName(), speed, e)
+		}
+	}
+	if module.bitsPerWord > 0 {
+		bits := module.bitsPerWord
+		e = spiIoctl(fd, spiIocWrBitsPerWord, uintptr(unsafe.Pointer(&bits)))
+		if e != nil {
+			fd.Close()
+			return nil, fmt.Errorf("module '%s' could not set %d bits per word: %s", module.GetName(), bits, e)
+		}
+	}
+
+	module.devices[slaveSelect] = fd
+	return fd, nil
+}
+
+// Select the device, and send data to it.
+func (module *DTSPIModule) Write(slaveSelect int, data []byte) error {
+	return module.Transfer(slaveSelect, []SPISegment{{Tx: data}})
+}
+
+// Select the device, and read data from it. Zeros are sent while the data is clocked in.
+func (module *DTSPIModule) Read(slaveSelect int, data []byte) (int, error) {
+	e := module.Transfer(slaveSelect, []SPISegment{{Rx: data}})
+	if e != nil {
+		return 0, e
+	}
+	return len(data), nil
+}
+
+// Perform a list of segments as a single transfer to a device, with one ioctl.
+func (module *DTSPIModule) Transfer(slaveSelect int, segments []SPISegment) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			tx, rx := spiJournalData(segments)
+			RecordJournalEvent(start, "spi Transfer", module.deviceFile(slaveSelect), tx, rx, e)
+		}(time.Now())
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+	if len(segments) > spiMaxSegments {
+		return fmt.Errorf("module '%s' can send at most %d segments in one transfer, got %d", module.GetName(), spiMaxSegments, len(segments))
+	}
+
+	transfers := make([]spiIocTransfer, len(segments))
+	for i, s := range segments {
+		t, e := module.makeTransfer(s)
+		if e != nil {
+			return e
+		}
+		transfers[i] = t
+	}
+
+	module.Lock()
+	defer module.Unlock()
+
+	fd, e := module.device(slaveSelect)
+	if e != nil {
+		return e
+	}
+
+	request := uintptr(spiIocMessageBase | len(transfers)*int(unsafe.Sizeof(spiIocTransfer{}))<<16)
+	e = spiIoctl(fd, request, uintptr(unsafe.Pointer(&transfers[0])))
+	runtime.KeepAlive(segments)
+	if e != nil {
+		return fmt.Errorf("module '%s' SPI transfer failed: %s", module.GetName(), e)
+	}
+	return nil
+}
+
+// Format the data sent and received by a transfer for the journal, with the segments separated by "|".
+func spiJournalData(segments []SPISegment) (tx string, rx string) {
+	for i, s := range segments {
+		if i > 0 {
+			tx += " | "
+			rx += " | "
+		}
+		tx += fmt.Sprintf("% x", s.Tx)
+		rx += fmt.Sprintf("% x", s.Rx)
+	}
+	return tx, rx
+}
+
+// Convert a segment to the kernel's struct.
+func (module *DTSPIModule) makeTransfer(s SPISegment) (spiIocTransfer, error) {
+	t := spiIocTransfer{speedHz: s.SpeedHz, bitsPerWord: s.BitsPerWord}
+
+	switch {
+	case s.Tx != nil && s.Rx != nil && len(s.Tx) != len(s.Rx):
+		return t, fmt.Errorf("module '%s' SPI segment has %d bytes to send but %d to receive", module.GetName(), len(s.Tx), len(s.Rx))
+	case s.Tx != nil:
+		t.length = uint32(len(s.Tx))
+	default:
+		t.length = uint32(len(s.Rx))
+	}
+	if len(s.Tx) > 0 {
+		t.txBuf = uint64(uintptr(unsafe.Pointer(&s.Tx[0])))
+	}
+	if len(s.Rx) > 0 {
+		t.rxBuf = uint64(uintptr(unsafe.Pointer(&s.Rx[0])))
+	}
+
+	delay := s.DelayAfter / time.Microsecond
+	if delay > 0xffff {
+		return t, fmt.Errorf("module '%s' SPI segment delay %s is longer than spidev supports", module.GetName(), s.DelayAfter)
+	}
+	t.delayUsecs = uint16(delay)
+	if s.CSChange {
+		t.csChange = 1
+	}
+	return t, nil
+}
+
+func spiIoctl(fd *os.File, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Implementation of an SPI slave module, for controllers the kernel can run in slave mode (CONFIG_SPI_SLAVE), such as
+// the McSPI controllers of the AM335x. The controller is set up as a slave in device tree (spi-slave), and the
+// spidev protocol driver is bound to it through /sys/class/spi_slave, which makes a /dev/spidevN.0 device. Each read
+// or write of the device is a transfer, which waits until the master selects the slave and clocks it.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/spi/spidev.html
+// - Documentation/ABI/testing/sysfs-class-spi-slave in the kernel tree.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The pins used by an SPI slave controller, which are assigned when the module is enabled.
+type DTSPISlaveModulePins []Pin
+
+// The SPI slave controllers, by name, e.g. "spi1".
+var spiSlaveSysfsPath = "/sys/class/spi_slave"
+
+// ioctl to set the SPI mode (clock polarity and phase), from spidev.h
+const spiIocWrMode = 0x40016b01
+
+type DTSPISlaveModule struct {
+	sync.Mutex
+
+	name        string
+	controller  string
+	deviceFile  string
+	mode        int
+	definedPins DTSPISlaveModulePins
+
+	fd *os.File
+}
+
+func NewDTSPISlaveModule(name string) (result *DTSPISlaveModule) {
+	result = &DTSPISlaveModule{name: name, mode: -1}
+	return result
+}
+
+// Accept options for the SPI slave module. Expected options include:
+//   - "device" - the spidev device file, e.g. "/dev/spidev1.0".
+//   - "controller" - optional, the SPI slave controller, e.g. "spi1". If given, spidev is bound to the controller when
+//     the module is enabled, if it isn't already.
+//   - "mode" - optional, the SPI mode 0-3. If not given, the mode set in device tree is used.
+//   - "pins" - an object of type DTSPISlaveModulePins that identifies the pins that will be assigned when this module
+//     is enabled.
+func (module *DTSPISlaveModule) SetOptions(options map[string]interface{}) error {
+	vd := options["device"]
+	if vd == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'device' value", module.GetName())
+	}
+	module.deviceFile = vd.(string)
+
+	if vc := options["controller"]; vc != nil {
+		module.controller = vc.(string)
+	}
+
+	if vm := options["mode"]; vm != nil {
+		module.mode = vm.(int)
+		if module.mode < 0 || module.mode > 3 {
+			return fmt.Errorf("module '%s' SPI mode must be 0-3, got %d", module.GetName(), module.mode)
+		}
+	}
+
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+	module.definedPins = vp.(DTSPISlaveModulePins)
+
+	return nil
+}
+
+// Enable the module: bind spidev to the controller if needed, assign the pins, and open the device.
+func (module *DTSPISlaveModule) Enable() error {
+	if module.controller != "" {
+		e := module.bindSpidev()
+		if e != nil {
+			return e
+		}
+	}
+
+	for _, pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			module.unassignPins()
+			return e
+		}
+	}
+
+	fd, e := os.OpenFile(module.deviceFile, os.O_RDWR, 0)
+	if e != nil {
+		module.unassignPins()
+		return fmt.Errorf("module '%s' could not open %s: %s", module.GetName(), module.deviceFile, e)
+	}
+	module.fd = fd
+
+	if module.mode >= 0 {
+		mode := uint8(module.mode)
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), spiIocWrMode, uintptr(unsafe.Pointer(&mode)))
+		if errno != 0 {
+			module.Disable()
+			return fmt.Errorf("module '%s' could not set SPI mode %d: %s", module.GetName(), module.mode, errno)
+		}
+	}
+
+	return nil
+}
+
+// Disable the module, closing the device and releasing the pins. spidev is left bound to the controller.
+func (module *DTSPISlaveModule) Disable() error {
+	if module.fd != nil {
+		if e := module.fd.Close(); e != nil {
+			return e
+		}
+		module.fd = nil
+	}
+	module.unassignPins()
+	return nil
+}
+
+func (module *DTSPISlaveModule) unassignPins() {
+	for _, pin := range module.definedPins {
+		UnassignPinFrom(pin, module)
+	}
+}
+
+func (module *DTSPISlaveModule) GetName() string {
+	return module.name
+}
+
+func (module *DTSPISlaveModule) KernelInterface() (string, string) {
+	return "spidev", module.deviceFile
+}
+
+func (module *DTSPISlaveModule) RequiredAccess() []RequiredAccess {
+	result := []RequiredAccess{{Path: module.deviceFile, Write: true}}
+	if module.controller != "" {
+		result = append(result, RequiredAccess{Path: spiSlaveSysfsPath + "/" + module.controller + "/slave", Write: true})
+	}
+	return result
+}
+
+// Bind the spidev protocol driver to the slave controller, unless it already has a protocol driver bound.
+func (module *DTSPISlaveModule) bindSpidev() error {
+	slaveFile := spiSlaveSysfsPath + "/" + module.controller + "/slave"
+	b, e := ioutil.ReadFile(slaveFile)
+	if e != nil {
+		return fmt.Errorf("module '%s' could not find SPI slave controller %s; check the kernel has CONFIG_SPI_SLAVE and the controller has spi-slave in device tree", module.GetName(), module.controller)
+	}
+	switch current := strings.TrimSpace(string(b)); current {
+	case "spidev":
+		return nil
+	case "", "(null)":
+		e = WriteStringToFile(slaveFile, "spidev")
+		if e != nil {
+			return e
+		}
+		waitForExport(module.deviceFile)
+		return nil
+	default:
+		return fmt.Errorf("module '%s' controller %s is in use by SPI slave driver '%s'", module.GetName(), module.controller, current)
+	}
+}
+
+// Wait for the master to clock a transfer, and return the bytes received, up to len(data). The master must select
+// the slave and clock len(data) bytes for the transfer to complete; a transfer that is cut short returns an error.
+func (module *DTSPISlaveModule) Receive(data []byte) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.fd == nil {
+		return 0, fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	return module.fd.Read(data)
+}
+
+// Send data to the master in its next transfer. This waits until the master clocks the transfer.
+func (module *DTSPISlaveModule) Transmit(data []byte) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.fd == nil {
+		return fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	_, e := module.fd.Write(data)
+	return e
+}
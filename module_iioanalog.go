@@ -0,0 +1,254 @@
+// An analog module for ADCs exposed through the Linux industrial I/O (IIO) subsystem, which is how mainline kernels
+// expose SoC ADCs such as the Rockchip and Amlogic SARADCs.
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Location of IIO devices. This is a variable so tests can point it elsewhere.
+var iioDevicesPath = "/sys/bus/iio/devices"
+
+type IIOAnalogModule struct {
+	name string
+
+	// IIO device name to look for, or part of it, e.g. "saradc"
+	deviceName string
+
+	// directory of the IIO device, once enabled
+	devicePath string
+
+	definedPins IIOAnalogModulePinDefMap
+
+	// the raw value file of each pin's channel, kept open once enabled so reads don't open it or format its path,
+	// unless there is a file descriptor budget
+	rawFiles map[Pin]SysfsFile
+
+	// the filesystem the IIO devices are on
+	fs SysfsFS
+
+	// serialises scans, which change the device's buffer setup, and whether the device has been found unable to
+	// scan, so AnalogReadMulti doesn't try again
+	scanning sync.Mutex
+	noScan   bool
+}
+
+// Represents an analog pin, which is a voltage channel of the IIO device.
+type IIOAnalogModulePinDef struct {
+	pin     Pin
+	channel int
+}
+
+// A map of analog pin definitions.
+type IIOAnalogModulePinDefMap map[Pin]*IIOAnalogModulePinDef
+
+func NewIIOAnalogModule(name string) *IIOAnalogModule {
+	return &IIOAnalogModule{name: name, fs: defaultSysfs}
+}
+
+// Set the filesystem the module finds IIO devices on, e.g. a FakeSysfs in tests. This must be called before Enable.
+func (module *IIOAnalogModule) SetFilesystem(fsys SysfsFS) {
+	module.fs = fsys
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type IIOAnalogModulePinDefMap
+// - "device" - the name of the IIO device, or part of it
+func (module *IIOAnalogModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+	module.definedPins = v.(IIOAnalogModulePinDefMap)
+
+	d := options["device"]
+	if d == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'device' value", module.GetName())
+	}
+	module.deviceName = d.(string)
+
+	return nil
+}
+
+// Enable the module, which finds the IIO device and assigns all the analog pins. Enabling it again finds the device
+// afresh.
+func (module *IIOAnalogModule) Enable() error {
+	path := findIIODevice(module.fs, module.deviceName)
+	if path == "" {
+		return fmt.Errorf("module '%s' could not find IIO device '%s'", module.GetName(), module.deviceName)
+	}
+	module.devicePath = path
+	module.noScan = false
+
+	// enabling again, e.g. after the device's triggers have changed, starts afresh
+	if module.rawFiles != nil {
+		module.Disable()
+	}
+
+	// device tree can leave some of an ADC's channels out, so pins whose channel has no file are skipped, and only
+	// fail when they're read
+	module.rawFiles = make(map[Pin]SysfsFile)
+	for pin, p := range module.definedPins {
+		name := fmt.Sprintf("%s/in_voltage%d_raw", path, p.channel)
+		if !sysfsExists(module.fs, name) {
+			continue
+		}
+		f, e := openManagedFile(module.fs, module.name, sysfsName(name), false)
+		if e != nil {
+			module.closeRawFiles()
+			return e
+		}
+		module.rawFiles[pin] = f
+	}
+
+	var assigned PinList
+	for pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			for _, p := range assigned {
+				UnassignPinFrom(p, module)
+			}
+			module.closeRawFiles()
+			return e
+		}
+		assigned = append(assigned, pin)
+	}
+	return nil
+}
+
+// disables module and release any pins assigned.
+func (module *IIOAnalogModule) Disable() error {
+	module.closeRawFiles()
+	for pin := range module.definedPins {
+		UnassignPinFrom(pin, module)
+	}
+	return nil
+}
+
+func (module *IIOAnalogModule) closeRawFiles() {
+	for _, f := range module.rawFiles {
+		f.Close()
+	}
+	module.rawFiles = nil
+}
+
+func (module *IIOAnalogModule) GetName() string {
+	return module.name
+}
+
+func (module *IIOAnalogModule) KernelInterface() (string, string) {
+	return "iio", module.devicePath
+}
+
+// Read the raw value of the pin's channel. The range depends on the ADC, e.g. 0-4095 for a 12-bit ADC.
+func (module *IIOAnalogModule) AnalogRead(pin Pin) (int, error) {
+	p := module.definedPins[pin]
+	if p == nil {
+		return 0, fmt.Errorf("pin %d is not known to analog module '%s'", pin, module.GetName())
+	}
+	if module.rawFiles == nil {
+		return 0, errors.New("analog module is being read but has not been enabled, call Enable")
+	}
+	f := module.rawFiles[pin]
+	if f == nil {
+		return 0, fmt.Errorf("IIO device %s has no voltage channel %d for pin %d", module.devicePath, p.channel, pin)
+	}
+
+	b := sysfsBuffers.Get().(*sysfsBuffer)
+	defer sysfsBuffers.Put(b)
+	n, e := f.ReadAt(b[:], 0)
+	if n == 0 {
+		return 0, e
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b[:n])))
+}
+
+// Read several pins in one scan of the device's buffer, so they're sampled together and share the scan's timestamp.
+// If the device can't scan them, e.g. because it has no trigger set, they're read one after another instead.
+func (module *IIOAnalogModule) AnalogReadMulti(pins PinList) ([]AnalogSample, error) {
+	channels := make([]int, len(pins))
+	for i, pin := range pins {
+		p := module.definedPins[pin]
+		if p == nil {
+			return nil, fmt.Errorf("pin %d is not known to analog module '%s'", pin, module.GetName())
+		}
+		channels[i] = p.channel
+	}
+	if module.rawFiles == nil {
+		return nil, errors.New("analog module is being read but has not been enabled, call Enable")
+	}
+
+	module.scanning.Lock()
+	defer module.scanning.Unlock()
+	if !module.noScan {
+		values, taken, e := readIIOScan(module.fs, module.devicePath, channels)
+		if e == nil {
+			samples := make([]AnalogSample, len(pins))
+			for i, pin := range pins {
+				samples[i] = AnalogSample{Pin: pin, Value: values[channels[i]], Timestamp: taken}
+			}
+			return samples, nil
+		}
+		if e == errIIONoScan {
+			module.noScan = true
+		}
+	}
+	return readAnalogInTurn(pins, module.AnalogRead)
+}
+
+// Find an IIO device whose name contains nameContains, returning its directory or "" if there is none.
+func findIIODevice(fsys SysfsFS, nameContains string) string {
+	devices := sysfsMatches(fsys, iioDevicesPath+"/iio:device*")
+	for _, dev := range devices {
+		name, _ := sysfsReadFile(fsys, dev+"/name")
+		if strings.Contains(string(name), nameContains) {
+			return dev
+		}
+	}
+	return ""
+}
+
+// Return the voltage channels an IIO device has, from its in_voltageN_raw files.
+func iioVoltageChannels(fsys SysfsFS, devicePath string) []int {
+	files := sysfsMatches(fsys, devicePath+"/in_voltage*_raw")
+
+	result := make([]int, 0)
+	for _, f := range files {
+		s := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "in_voltage"), "_raw")
+		if n, e := strconv.Atoi(s); e == nil {
+			result = append(result, n)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// Find the raw voltage file for a channel of an IIO device. Devices whose name contains nameContains are preferred,
+// otherwise the first device with that channel is used. Returns an empty string if there is none.
+func findIIOVoltageFile(fsys SysfsFS, nameContains string, channel int) string {
+	devices := sysfsMatches(fsys, iioDevicesPath+"/iio:device*")
+
+	fallback := ""
+	for _, dev := range devices {
+		path := fmt.Sprintf("%s/in_voltage%d_raw", dev, channel)
+		if !sysfsExists(fsys, path) {
+			continue
+		}
+
+		name, _ := sysfsReadFile(fsys, dev+"/name")
+		if strings.Contains(string(name), nameContains) {
+			return path
+		}
+		if fallback == "" {
+			fallback = path
+		}
+	}
+	return fallback
+}
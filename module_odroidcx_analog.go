@@ -3,19 +3,31 @@ package hwio
 import (
 	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
-// ODroidCXAnalogModule is a module for handling the Odroid C1 analog hardware, which is not generic.
+// Location of the Hardkernel saradc driver files. This is a variable so tests can point it elsewhere.
+var odroidSaradcPath = "/sys/class/saradc"
+
+// ODroidCXAnalogModule is a module for handling the Odroid C1 and C2 analog hardware, which is not generic. It reads
+// from the saradc driver of the Hardkernel kernels, or from IIO on mainline kernels.
 type ODroidCXAnalogModule struct {
 	name string
 
 	analogInitialised bool
 
+	// kernel interface ("sysfs" or "iio") and directory the channel files were found in, once pins are opened
+	kernelInterface string
+	analogDir       string
+
 	definedPins ODroidCXAnalogModulePinDefMap
 
 	openPins map[Pin]*ODroidCXAnalogModuleOpenPin
+
+	// the filesystem the saradc and IIO files are on
+	fs SysfsFS
 }
 
 // Represents the definition of an analog pin, which should contain all the info required to open, close, read and write the pin
@@ -35,15 +47,21 @@ type ODroidCXAnalogModuleOpenPin struct {
 	// path to file representing analog pin
 	analogFile string
 
-	valueFile *os.File
+	valueFile SysfsFile
 }
 
 func NewODroidCXAnalogModule(name string) (result *ODroidCXAnalogModule) {
-	result = &ODroidCXAnalogModule{name: name}
+	result = &ODroidCXAnalogModule{name: name, fs: defaultSysfs}
 	result.openPins = make(map[Pin]*ODroidCXAnalogModuleOpenPin)
 	return result
 }
 
+// Set the filesystem the module finds the saradc and IIO files on, e.g. a FakeSysfs in tests. This must be called
+// before Enable.
+func (module *ODroidCXAnalogModule) SetFilesystem(fsys SysfsFS) {
+	module.fs = fsys
+}
+
 // Set options of the module. Parameters we look for include:
 // - "pins" - an object of type ODroidCXAnalogModulePinDefMap
 func (module *ODroidCXAnalogModule) SetOptions(options map[string]interface{}) error {
@@ -83,7 +101,7 @@ func (module *ODroidCXAnalogModule) Disable() error {
 	// Unassign any pins we may have assigned
 	for pin := range module.definedPins {
 		// attempt to assign this pin for this module.
-		UnassignPin(pin)
+		UnassignPinFrom(pin, module)
 	}
 
 	// if there are any open analog pins, close them
@@ -97,6 +115,13 @@ func (module *ODroidCXAnalogModule) GetName() string {
 	return module.name
 }
 
+func (module *ODroidCXAnalogModule) KernelInterface() (string, string) {
+	if module.kernelInterface == "" {
+		return "sysfs", odroidSaradcPath
+	}
+	return module.kernelInterface, module.analogDir
+}
+
 func (module *ODroidCXAnalogModule) AnalogRead(pin Pin) (value int, e error) {
 	openPin := module.openPins[pin]
 	if openPin == nil {
@@ -111,16 +136,18 @@ func (module *ODroidCXAnalogModule) makeOpenAnalogPin(pin Pin) error {
 		return fmt.Errorf("pin %d is not known to analog module", pin)
 	}
 
-	path := fmt.Sprintf("/sys/class/saradc/saradc_ch%d", p.analogLogical)
-	hw := CpuInfo(3, "Hardware")
-	if hw == "ODROID-C2" {
-		path = fmt.Sprintf("/sys/class/saradc/ch%d", p.analogLogical)
+	path, iface, e := module.findAnalogFile(p.analogLogical)
+	if e != nil {
+		return e
 	}
+	module.kernelInterface = iface
+	module.analogDir = filepath.Dir(path)
+
 	result := &ODroidCXAnalogModuleOpenPin{pin: pin, analogLogical: p.analogLogical, analogFile: path}
 
 	module.openPins[pin] = result
 
-	e := result.analogOpen()
+	e = result.analogOpen(module.fs)
 	if e != nil {
 		return e
 	}
@@ -128,9 +155,35 @@ func (module *ODroidCXAnalogModule) makeOpenAnalogPin(pin Pin) error {
 	return nil
 }
 
-func (op *ODroidCXAnalogModuleOpenPin) analogOpen() error {
+// Find the file to read an analog channel from. The saradc driver names channel files saradc_chN on C1 kernels and
+// chN on C2 kernels, so the name for the detected revision is tried first. Mainline kernels have no saradc driver,
+// and expose the ADC through IIO instead. Both give 10-bit values.
+func (module *ODroidCXAnalogModule) findAnalogFile(channel int) (path string, iface string, e error) {
+	candidates := []string{
+		fmt.Sprintf("%s/saradc_ch%d", odroidSaradcPath, channel),
+		fmt.Sprintf("%s/ch%d", odroidSaradcPath, channel),
+	}
+	if odroidCXBoardRevision() == 2 {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+
+	for _, c := range candidates {
+		if sysfsExists(module.fs, c) {
+			return c, "sysfs", nil
+		}
+	}
+
+	path = findIIOVoltageFile(module.fs, "saradc", channel)
+	if path != "" {
+		return path, "iio", nil
+	}
+
+	return "", "", fmt.Errorf("module '%s' could not find a saradc or IIO file for analog channel %d", module.GetName(), channel)
+}
+
+func (op *ODroidCXAnalogModuleOpenPin) analogOpen(fsys SysfsFS) error {
 	// Open analog input file computed from the calculated path of actual analog files and the analog pin name
-	f, e := os.OpenFile(op.analogFile, os.O_RDONLY, 0666)
+	f, e := fsys.OpenFile(sysfsName(op.analogFile), false)
 	op.valueFile = f
 
 	return e
@@ -138,7 +191,7 @@ func (op *ODroidCXAnalogModuleOpenPin) analogOpen() error {
 
 func (op *ODroidCXAnalogModuleOpenPin) analogGetValue() (int, error) {
 	var b []byte
-	b = make([]byte, 5)
+	b = make([]byte, 16)
 	n, e := op.valueFile.ReadAt(b, 0)
 
 	// if there's an error and no byte were read, quit now. If we didn't get all the bytes we asked for, which
@@ -147,11 +200,14 @@ func (op *ODroidCXAnalogModuleOpenPin) analogGetValue() (int, error) {
 		return 0, e
 	}
 
-	value, e := strconv.Atoi(string(b[:n-1]))
+	value, e := strconv.Atoi(strings.TrimSpace(string(b[:n])))
 
 	return value, e
 }
 
 func (op *ODroidCXAnalogModuleOpenPin) analogClose() error {
+	if op.valueFile == nil {
+		return nil
+	}
 	return op.valueFile.Close()
 }
@@ -0,0 +1,322 @@
+// A clock module for the general purpose clocks of the Raspberry Pi (GPCLK0-2), which output a clock divided down
+// from the crystal oscillator or a PLL on a GPIO pin. They are a precise, jitter-free reference for chips that need
+// an external clock, such as audio codecs, cameras and some microcontrollers, at up to 125MHz. The kernel has no
+// interface for them, so the clock manager and GPIO function select registers are written through /dev/mem, which
+// requires root.
+//
+// Known issues:
+// - GPCLK1 is used by the firmware on some models, e.g. for the Ethernet chip of the Model B+, and GPCLK0 by the
+//   camera interface; check the pins are free before using them
+// - the BCM2712 of the Raspberry Pi 5 has its GPIO on the RP1, whose clocks are different, and isn't supported
+// - fractional division dithers the divisor, which adds jitter of one source period; an integer division of the
+//   oscillator or PLLD is jitter-free, so frequencies that divide them exactly are best
+
+package hwio
+
+// References:
+// - BCM2835 ARM peripherals, section 6.3 "General Purpose GPIO Clocks".
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// offsets of the clock manager and GPIO registers from the peripheral base address
+	piClockOffset = 0x101000
+	piGPIOOffset  = 0x200000
+
+	// writes to a clock manager register are ignored unless they include this value
+	piClockPassword = 0x5a000000
+
+	// bits of a control register
+	piClockEnable = 1 << 4
+	piClockBusy   = 1 << 7
+	piClockMASH   = 9
+
+	// clock sources
+	piClockSourceOscillator = 1
+	piClockSourcePLLD       = 6
+
+	// the fastest clock the GPIO pads can output, and the fastest a fractional divisor works at
+	piClockMaxHz        = 125e6
+	piClockMaxMASHHz    = 25e6
+	piClockDivisorLimit = 4095
+
+	// how long to wait for a clock to stop before changing its divisor
+	piClockStopTimeout = 10 * time.Millisecond
+)
+
+// The control register of each clock. Its divisor register follows it.
+var piClockControl = [3]int{0x70, 0x78, 0x80}
+
+// Location of the device tree's compatible property, to recognise the BCM2712. This is a variable so tests can point
+// it elsewhere.
+var piCompatiblePath = "/proc/device-tree/compatible"
+
+// The clock manager and GPIO registers, mapped into memory.
+type piPeripherals struct {
+	base  int64
+	clock []byte
+	gpio  []byte
+	close func() error
+}
+
+// Map the registers the clock module uses. This is a variable so tests can replace it with memory of their own.
+var piMapPeripherals = func() (*piPeripherals, error) {
+	base, e := piPeripheralBase()
+	if e != nil {
+		return nil, e
+	}
+	f, e := os.OpenFile("/dev/mem", os.O_RDWR|os.O_SYNC, 0)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	p := &piPeripherals{base: base}
+	p.clock, e = syscall.Mmap(int(f.Fd()), base+piClockOffset, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		return nil, e
+	}
+	p.gpio, e = syscall.Mmap(int(f.Fd()), base+piGPIOOffset, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		syscall.Munmap(p.clock)
+		return nil, e
+	}
+	p.close = func() error {
+		syscall.Munmap(p.gpio)
+		return syscall.Munmap(p.clock)
+	}
+	return p, nil
+}
+
+// Return a register of mapped memory.
+func piRegister(mem []byte, offset int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&mem[offset]))
+}
+
+type PiClockModule struct {
+	name        string
+	definedPins PiClockModulePinDefMap
+
+	// the registers, mapped when a clock is first set, and the frequencies of the oscillator and PLLD
+	peripherals    *piPeripherals
+	oscillatorHz   float64
+	pllDHz         float64
+	clocksByPin    map[Pin]int
+	pinsByClock    map[int]Pin
+	frequencyByPin map[Pin]float64
+}
+
+// Represents a pin that can output a clock: its GPIO, the clock, 0-2, and the alternate function, 0-5, that
+// connects the clock to the pin.
+type PiClockModulePinDef struct {
+	pin         Pin
+	gpioLogical int
+	clock       int
+	alt         int
+}
+
+type PiClockModulePinDefMap map[Pin]*PiClockModulePinDef
+
+func NewPiClockModule(name string) *PiClockModule {
+	return &PiClockModule{name: name, clocksByPin: make(map[Pin]int), pinsByClock: make(map[int]Pin), frequencyByPin: make(map[Pin]float64)}
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type PiClockModulePinDefMap
+func (module *PiClockModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(PiClockModulePinDefMap)
+	return nil
+}
+
+// Enable the module. The registers aren't mapped until a clock is set, so the module can be enabled without root.
+func (module *PiClockModule) Enable() error {
+	return nil
+}
+
+// Stop any clocks, and release their pins.
+func (module *PiClockModule) Disable() error {
+	for pin := range module.clocksByPin {
+		module.StopClock(pin)
+	}
+	if module.peripherals != nil {
+		module.peripherals.close()
+		module.peripherals = nil
+	}
+	return nil
+}
+
+func (module *PiClockModule) GetName() string {
+	return module.name
+}
+
+func (module *PiClockModule) RequiredAccess() []RequiredAccess {
+	return []RequiredAccess{{Path: "/dev/mem", Write: true}}
+}
+
+// Return the frequency of the clock on a pin, or 0 if there isn't one.
+func (module *PiClockModule) GetClock(pin Pin) float64 {
+	return module.frequencyByPin[pin]
+}
+
+// Output a clock on a pin. The divisor is chosen from the oscillator and PLLD, preferring an integer division, which
+// is jitter-free, and otherwise dividing PLLD fractionally. The frequency generated is returned.
+func (module *PiClockModule) SetClock(pin Pin, hz float64) (float64, error) {
+	def := module.definedPins[pin]
+	if def == nil {
+		return 0, fmt.Errorf("pin %d is not known as a clock pin on module %s", pin, module.GetName())
+	}
+	if other, ok := module.pinsByClock[def.clock]; ok && other != pin {
+		return 0, fmt.Errorf("GPCLK%d is already output on pin %d", def.clock, other)
+	}
+	if e := module.mapPeripherals(); e != nil {
+		return 0, e
+	}
+
+	source, divisor, mash, e := piClockDivisor(hz, module.oscillatorHz, module.pllDHz)
+	if e != nil {
+		return 0, e
+	}
+	sourceHz := module.oscillatorHz
+	if source == piClockSourcePLLD {
+		sourceHz = module.pllDHz
+	}
+
+	_, running := module.clocksByPin[pin]
+	if !running {
+		if e := AssignPin(pin, module); e != nil {
+			return 0, e
+		}
+	}
+	if e := module.stopClock(def.clock); e != nil {
+		if !running {
+			UnassignPinFrom(pin, module)
+		}
+		return 0, e
+	}
+	control := piRegister(module.peripherals.clock, piClockControl[def.clock])
+	*piRegister(module.peripherals.clock, piClockControl[def.clock]+4) = piClockPassword | divisor
+	*control = piClockPassword | mash<<piClockMASH | source
+	*control = piClockPassword | mash<<piClockMASH | source | piClockEnable
+	module.setFunction(def.gpioLogical, def.alt)
+
+	module.clocksByPin[pin] = def.clock
+	module.pinsByClock[def.clock] = pin
+	actual := sourceHz / (float64(divisor>>12) + float64(divisor&0xfff)/4096)
+	module.frequencyByPin[pin] = actual
+	return actual, nil
+}
+
+// Stop the clock on a pin, making the pin an input.
+func (module *PiClockModule) StopClock(pin Pin) error {
+	clock, ok := module.clocksByPin[pin]
+	if !ok {
+		return fmt.Errorf("pin %d has no clock to stop", pin)
+	}
+	e := module.stopClock(clock)
+	module.setFunction(module.definedPins[pin].gpioLogical, -1)
+	delete(module.clocksByPin, pin)
+	delete(module.pinsByClock, clock)
+	delete(module.frequencyByPin, pin)
+	UnassignPinFrom(pin, module)
+	return e
+}
+
+// Map the registers if they aren't, and work out the source frequencies from the SoC.
+func (module *PiClockModule) mapPeripherals() error {
+	if module.peripherals != nil {
+		return nil
+	}
+	if b, e := ioutil.ReadFile(piCompatiblePath); e == nil && strings.Contains(string(b), "bcm2712") {
+		return fmt.Errorf("module '%s' does not support the general purpose clocks of the BCM2712", module.GetName())
+	}
+	p, e := piMapPeripherals()
+	if e != nil {
+		return fmt.Errorf("module '%s' could not map the clock registers: %s", module.GetName(), e)
+	}
+	module.peripherals = p
+
+	// the BCM2711 has a faster oscillator and PLLD, and its peripherals at a different address
+	module.oscillatorHz, module.pllDHz = 19.2e6, 500e6
+	if p.base == 0xfe000000 {
+		module.oscillatorHz, module.pllDHz = 54e6, 750e6
+	}
+	return nil
+}
+
+// Stop a clock, waiting until it has, as its divisor can't be changed while it's running.
+func (module *PiClockModule) stopClock(clock int) error {
+	control := piRegister(module.peripherals.clock, piClockControl[clock])
+	*control = piClockPassword | (*control & 0xffffff &^ piClockEnable)
+	deadline := MonotonicNow() + piClockStopTimeout
+	for *control&piClockBusy != 0 {
+		if MonotonicNow() > deadline {
+			return fmt.Errorf("GPCLK%d did not stop", clock)
+		}
+	}
+	return nil
+}
+
+// Set the function of a GPIO to an alternate function, or to an input if alt is -1.
+func (module *PiClockModule) setFunction(gpio int, alt int) {
+	// function select values of ALT0-5; 0 is an input
+	fsel := uint32(0)
+	if alt >= 0 {
+		fsel = [6]uint32{4, 5, 6, 7, 3, 2}[alt]
+	}
+	reg := piRegister(module.peripherals.gpio, 4*(gpio/10))
+	shift := uint(3 * (gpio % 10))
+	*reg = *reg&^(7<<shift) | fsel<<shift
+}
+
+// A source of the clocks, and its frequency.
+type piClockSource struct {
+	source uint32
+	hz     float64
+}
+
+// Choose the source, divisor register value and MASH mode for a frequency. An integer division of either source is
+// used if there is one, as it's jitter-free; otherwise PLLD is divided fractionally with MASH 1, or rounded to an
+// integer division above the fastest a fractional divisor works at.
+func piClockDivisor(hz float64, oscillatorHz float64, pllDHz float64) (source uint32, divisor uint32, mash uint32, e error) {
+	if hz <= 0 || hz > piClockMaxHz {
+		return 0, 0, 0, fmt.Errorf("clock frequency must be above 0 and at most 125MHz, got %gHz", hz)
+	}
+	oscillator := piClockSource{piClockSourceOscillator, oscillatorHz}
+	pllD := piClockSource{piClockSourcePLLD, pllDHz}
+	for _, s := range []piClockSource{oscillator, pllD} {
+		d := s.hz / hz
+		if r := math.Round(d); math.Abs(d-r) < 1e-9*d && r >= 1 && r <= piClockDivisorLimit {
+			return s.source, uint32(r) << 12, 0, nil
+		}
+	}
+
+	for _, s := range []piClockSource{pllD, oscillator} {
+		d := s.hz / hz
+		if hz > piClockMaxMASHHz {
+			return s.source, uint32(math.Round(d)) << 12, 0, nil
+		}
+		integer := math.Floor(d)
+		fraction := math.Round((d - integer) * 4096)
+		if fraction == 4096 {
+			integer, fraction = integer+1, 0
+		}
+		if integer >= 2 && integer <= piClockDivisorLimit {
+			return s.source, uint32(integer)<<12 | uint32(fraction), 1, nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("clock frequency %gHz is below the slowest the dividers can make, %.0fHz", hz, oscillatorHz/(piClockDivisorLimit+4095.0/4096))
+}
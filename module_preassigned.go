@@ -32,9 +32,14 @@ func (module *PreassignedModule) Enable() error {
 }
 
 func (module *PreassignedModule) Disable() error {
-	return UnassignPins(module.pins)
+	return UnassignPinsFrom(module.pins, module)
 }
 
 func (module *PreassignedModule) GetName() string {
 	return module.name
 }
+
+// This module only reserves pins, so it does not use any kernel interface.
+func (module *PreassignedModule) KernelInterface() (string, string) {
+	return "none", ""
+}
@@ -0,0 +1,562 @@
+// Implementation of the serial module interface in software, on GPIO pins, for boards whose UARTs are all taken
+// by the console or Bluetooth. Bytes are sent by writing the transmit pin in a tight loop, and received by watching
+// the receive pin for edges and working out the bits from the kernel's timestamps. Framing is 8N1 by default, and
+// 7 data bits, parity and inverted logic can be set for buses such as SDI-12, which also run half duplex on a
+// single pin.
+//
+// Timing is only as good as the system allows. Transmitted bits are scheduled from the start of each Write, so an
+// error in one bit doesn't accumulate, but if the goroutine is preempted mid-byte that bit is stretched and the
+// byte is likely to be corrupted; on an idle system expect a few microseconds of jitter, against a bit time of 26µs
+// at 38400 baud. Receiving depends only on the edge timestamps, so it is more reliable, but needs the GPIO character
+// device, and bursts of edges faster than they can be read are dropped, and reported by Errors. 9600 baud is a
+// comfortable rate; 38400 is the limit.
+
+package hwio
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The fastest baud rate the software serial module accepts.
+const maxSoftSerialBaud = 38400
+
+type SoftSerialModule struct {
+	// held while writing
+	sync.Mutex
+
+	name       string
+	baud       int
+	dataBits   int
+	parity     string
+	inverted   bool
+	txPin      Pin
+	rxPin      Pin
+	useTx      bool
+	useRx      bool
+	halfDuplex bool
+	enabled    bool
+
+	// whether the receive goroutine is running. In half duplex it is stopped while transmitting.
+	receiving bool
+
+	// received bytes not yet read, and the read deadline, which are guarded by rxLock. wake is signalled when
+	// either changes.
+	rxLock   sync.Mutex
+	received []byte
+	deadline time.Time
+	wake     chan bool
+	errors   uint64
+
+	// closed when the receive goroutine exits
+	stopped chan bool
+}
+
+func NewSoftSerialModule(name string) (result *SoftSerialModule) {
+	result = &SoftSerialModule{name: name, baud: 9600, dataBits: 8, parity: "none"}
+	return result
+}
+
+// Accept options for the software serial module. Expected options include:
+//   - "tx" - the Pin to transmit on. Optional, but at least one of "tx", "rx" and "pin" must be given.
+//   - "rx" - the Pin to receive on, which must be on a GPIO module that supports edge detection.
+//   - "pin" - a single Pin to transmit and receive on, half duplex, instead of "tx" and "rx". The pin is an input
+//     except while transmitting.
+//   - "baud" - optional, the baud rate as an int, up to 38400. Defaults to 9600.
+//   - "dataBits" - optional, 7 or 8. Defaults to 8.
+//   - "parity" - optional, "none", "even" or "odd". Defaults to "none".
+//   - "inverted" - optional, true if the line is inverted, idling low, as on SDI-12. Needs a GPIO module that
+//     supports active-low pins.
+func (module *SoftSerialModule) SetOptions(options map[string]interface{}) error {
+	if vt := options["tx"]; vt != nil {
+		module.txPin = vt.(Pin)
+		module.useTx = true
+	}
+	if vr := options["rx"]; vr != nil {
+		module.rxPin = vr.(Pin)
+		module.useRx = true
+	}
+	if vp := options["pin"]; vp != nil {
+		if module.useTx || module.useRx {
+			return fmt.Errorf("module '%s' SetOptions() cannot have a 'pin' value with 'tx' or 'rx'", module.GetName())
+		}
+		module.txPin = vp.(Pin)
+		module.rxPin = module.txPin
+		module.useTx = true
+		module.useRx = true
+		module.halfDuplex = true
+	}
+	if !module.useTx && !module.useRx {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'tx', 'rx' or 'pin' value", module.GetName())
+	}
+
+	if vd := options["dataBits"]; vd != nil {
+		bits := vd.(int)
+		if bits != 7 && bits != 8 {
+			return fmt.Errorf("module '%s' data bits must be 7 or 8, got %d", module.GetName(), bits)
+		}
+		module.dataBits = bits
+	}
+	if vp := options["parity"]; vp != nil {
+		parity := vp.(string)
+		if e := checkSerialParity(module, parity); e != nil {
+			return e
+		}
+		module.parity = parity
+	}
+	if vi := options["inverted"]; vi != nil {
+		module.inverted = vi.(bool)
+	}
+
+	if vb := options["baud"]; vb != nil {
+		return module.SetBaudRate(vb.(int))
+	}
+	return nil
+}
+
+// Enable the module, setting the transmit pin to an output at the idle level, and watching the receive pin for
+// edges.
+func (module *SoftSerialModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.enabled {
+		return nil
+	}
+	if module.inverted {
+		for _, pin := range module.pins() {
+			e := SetActiveLow(pin, true)
+			if e != nil {
+				return fmt.Errorf("module '%s' cannot invert pin %d: %s", module.GetName(), pin, e)
+			}
+		}
+	}
+	if module.useTx && !module.halfDuplex {
+		e := PinModeOutputInit(module.txPin, High)
+		if e != nil {
+			return e
+		}
+	}
+	if module.useRx {
+		module.rxLock.Lock()
+		module.received = nil
+		module.wake = make(chan bool, 1)
+		module.rxLock.Unlock()
+
+		e := module.startReceiving()
+		if e != nil {
+			return fmt.Errorf("module '%s' cannot receive: %s", module.GetName(), e)
+		}
+	}
+
+	module.rxLock.Lock()
+	module.enabled = true
+	module.rxLock.Unlock()
+	return nil
+}
+
+// Disable the module, stopping reception and closing the pins. Bytes received but not read are discarded.
+func (module *SoftSerialModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+	module.rxLock.Lock()
+	module.enabled = false
+	module.rxLock.Unlock()
+	module.stopReceiving()
+	for _, pin := range module.pins() {
+		ClosePin(pin)
+	}
+	return nil
+}
+
+func (module *SoftSerialModule) GetName() string {
+	return module.name
+}
+
+// Set the baud rate, which takes effect from the next byte sent or received.
+func (module *SoftSerialModule) SetBaudRate(baud int) error {
+	if baud <= 0 || baud > maxSoftSerialBaud {
+		return fmt.Errorf("module '%s' baud rate must be between 1 and %d, got %d", module.GetName(), maxSoftSerialBaud, baud)
+	}
+
+	module.Lock()
+	defer module.Unlock()
+	module.rxLock.Lock()
+	defer module.rxLock.Unlock()
+	module.baud = baud
+	return nil
+}
+
+// Return the number of bytes that have been lost, because their stop bit was missing, their parity was wrong, or
+// edges were dropped.
+func (module *SoftSerialModule) Errors() uint64 {
+	module.rxLock.Lock()
+	defer module.rxLock.Unlock()
+	return module.errors
+}
+
+// Send bytes, returning once the stop bit of the last one has been sent. With 7 data bits, the top bit of each
+// byte is ignored.
+func (module *SoftSerialModule) Write(data []byte) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	e := module.startTransmitting()
+	if e != nil {
+		return 0, e
+	}
+
+	// run on the IO thread, so the loop isn't moved between CPUs mid-byte, and bulk IO waits for it
+	n := 0
+	e = runCriticalIO(func() (e error) {
+		bitTime := time.Second / time.Duration(module.baud)
+		bits := uint(module.frameBits())
+		t := MonotonicNow()
+		for ; n < len(data) && e == nil; n++ {
+			// a low start bit, the data least significant bit first, the parity bit if any, and a high stop bit
+			frame := module.addParity(data[n])<<1 | 1<<(bits+1)
+			for i := uint(0); i < bits+2 && e == nil; i++ {
+				level := Low
+				if frame&(1<<i) != 0 {
+					level = High
+				}
+				e = DigitalWrite(module.txPin, level)
+				t += bitTime
+				spinUntil(t)
+			}
+		}
+		return e
+	})
+
+	if e != nil {
+		module.finishTransmitting()
+		return n - 1, e
+	}
+	return n, module.finishTransmitting()
+}
+
+// Send a break, holding the line at the low (space) level for the duration, then returning it to idle.
+func (module *SoftSerialModule) SendBreak(duration time.Duration) error {
+	module.Lock()
+	defer module.Unlock()
+
+	e := module.startTransmitting()
+	if e != nil {
+		return e
+	}
+	e = DigitalWrite(module.txPin, Low)
+	if e == nil {
+		time.Sleep(duration)
+		e = DigitalWrite(module.txPin, High)
+	}
+	if e != nil {
+		module.finishTransmitting()
+		return e
+	}
+	return module.finishTransmitting()
+}
+
+// Read the bytes received, waiting for at least one.
+func (module *SoftSerialModule) Read(data []byte) (int, error) {
+	if !module.useRx {
+		return 0, fmt.Errorf("module '%s' has no receive pin", module.GetName())
+	}
+
+	for {
+		module.rxLock.Lock()
+		if !module.enabled {
+			module.rxLock.Unlock()
+			return 0, fmt.Errorf("module '%s' is not enabled", module.GetName())
+		}
+		if len(module.received) > 0 {
+			n := copy(data, module.received)
+			module.received = module.received[n:]
+			module.rxLock.Unlock()
+			return n, nil
+		}
+		deadline := module.deadline
+		wake, stopped := module.wake, module.stopped
+		module.rxLock.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-wake:
+		case <-timeout:
+		case <-stopped:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Make reads waiting past t return os.ErrDeadlineExceeded. A zero t means reads wait indefinitely.
+func (module *SoftSerialModule) SetReadDeadline(t time.Time) error {
+	module.rxLock.Lock()
+	module.deadline = t
+	module.rxLock.Unlock()
+	module.signal()
+	return nil
+}
+
+// Wake a waiting Read.
+func (module *SoftSerialModule) signal() {
+	select {
+	case module.wake <- true:
+	default:
+	}
+}
+
+// Check the module can transmit, and in half duplex stop receiving and make the pin an output at the idle level.
+func (module *SoftSerialModule) startTransmitting() error {
+	if !module.enabled {
+		return fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	if !module.useTx {
+		return fmt.Errorf("module '%s' has no transmit pin", module.GetName())
+	}
+	if !module.halfDuplex {
+		return nil
+	}
+	module.stopReceiving()
+	return PinModeOutputInit(module.txPin, High)
+}
+
+// In half duplex, release the line and start receiving again.
+func (module *SoftSerialModule) finishTransmitting() error {
+	if !module.halfDuplex {
+		return nil
+	}
+	return module.startReceiving()
+}
+
+// Make the receive pin an input, pulled to the idle level, and start decoding its edges.
+func (module *SoftSerialModule) startReceiving() error {
+	mode := InputPullUp
+	if module.inverted {
+		// the pull is electrical, so an inverted line idles with a pull-down
+		mode = InputPullDown
+	}
+	e := PinMode(module.rxPin, mode)
+	if e != nil {
+		return e
+	}
+	edges, e := WatchEdges(module.rxPin, EdgeBoth)
+	if e != nil {
+		return e
+	}
+
+	stopped := make(chan bool)
+	module.rxLock.Lock()
+	module.stopped = stopped
+	module.rxLock.Unlock()
+	module.receiving = true
+	go module.receive(edges, stopped)
+	return nil
+}
+
+// Stop watching the receive pin, if it is being watched, and wait for the receive goroutine to finish.
+func (module *SoftSerialModule) stopReceiving() {
+	if !module.receiving {
+		return
+	}
+	StopWatchingEdges(module.rxPin)
+	<-module.stopped
+	module.receiving = false
+}
+
+// The pins the module uses.
+func (module *SoftSerialModule) pins() []Pin {
+	pins := make([]Pin, 0, 2)
+	if module.useTx {
+		pins = append(pins, module.txPin)
+	}
+	if module.useRx && !module.halfDuplex {
+		pins = append(pins, module.rxPin)
+	}
+	return pins
+}
+
+// The number of bits in a frame between the start and stop bits.
+func (module *SoftSerialModule) frameBits() int {
+	if module.parity == "none" {
+		return module.dataBits
+	}
+	return module.dataBits + 1
+}
+
+// Return the data bits of b, followed by the parity bit if there is one.
+func (module *SoftSerialModule) addParity(b byte) uint {
+	data := uint(b) & (1<<uint(module.dataBits) - 1)
+	if module.parity == "none" {
+		return data
+	}
+	return data | module.parityBit(data)<<uint(module.dataBits)
+}
+
+// Check the parity of bits received, returning the data byte, and whether the parity was right.
+func (module *SoftSerialModule) checkParity(bits uint) (byte, bool) {
+	data := bits & (1<<uint(module.dataBits) - 1)
+	if module.parity == "none" {
+		return byte(data), true
+	}
+	return byte(data), bits>>uint(module.dataBits)&1 == module.parityBit(data)
+}
+
+// The parity bit for data: the bit that makes the number of ones even, or odd.
+func (module *SoftSerialModule) parityBit(data uint) uint {
+	p := uint(0)
+	for ; data != 0; data >>= 1 {
+		p ^= data & 1
+	}
+	if module.parity == "odd" {
+		p ^= 1
+	}
+	return p
+}
+
+// Decode bytes from the edges of the receive pin, until the edges channel is closed. A byte starts with the falling
+// edge of its start bit, and each bit is the level at the middle of its bit time. The byte is decoded when the
+// next start bit arrives, or when the line has been quiet until the end of the stop bit.
+func (module *SoftSerialModule) receive(edges <-chan EdgeEvent, stopped chan bool) {
+	defer close(stopped)
+
+	var start, end, bitTime time.Duration
+	bits := module.frameBits()
+	var frame []EdgeEvent
+	var lastSeq uint32
+	inFrame := false
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	// set the timer for the end of the frame
+	arm := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(end - MonotonicNow())
+	}
+
+	finish := func() {
+		value, ok := decodeSoftSerialFrame(start, bitTime, bits, frame)
+		b, parityOK := module.checkParity(value)
+		module.rxLock.Lock()
+		if ok && parityOK {
+			module.received = append(module.received, b)
+		} else {
+			module.errors++
+		}
+		module.rxLock.Unlock()
+		module.signal()
+		inFrame = false
+	}
+
+	handle := func(event EdgeEvent) {
+		if lastSeq != 0 && event.Seq != lastSeq+1 && inFrame {
+			// edges were dropped, so the byte can't be trusted
+			module.rxLock.Lock()
+			module.errors++
+			module.rxLock.Unlock()
+			inFrame = false
+		}
+		lastSeq = event.Seq
+
+		if inFrame && event.Timestamp >= end {
+			finish()
+		}
+		if inFrame {
+			frame = append(frame, event)
+			return
+		}
+		if event.Rising {
+			return
+		}
+
+		module.rxLock.Lock()
+		bitTime = time.Second / time.Duration(module.baud)
+		module.rxLock.Unlock()
+		start, end = event.Timestamp, event.Timestamp+bitTime*time.Duration(2*bits+3)/2
+		frame = frame[:0]
+		inFrame = true
+
+		arm()
+	}
+
+	for {
+		var frameEnd <-chan time.Time
+		if inFrame {
+			frameEnd = timer.C
+		}
+
+		select {
+		case event, ok := <-edges:
+			if !ok {
+				return
+			}
+			handle(event)
+
+		case <-frameEnd:
+			// take edges that happened before the end of the frame but haven't been read yet
+		drain:
+			for {
+				select {
+				case event, ok := <-edges:
+					if !ok {
+						return
+					}
+					handle(event)
+				default:
+					break drain
+				}
+			}
+			if inFrame && MonotonicNow() >= end {
+				finish()
+			} else if inFrame {
+				arm()
+			}
+		}
+	}
+}
+
+// Work out the bits of a frame, data and parity, from the edges after its start bit. It is only valid if the stop
+// bit is high.
+func decodeSoftSerialFrame(start time.Duration, bitTime time.Duration, bits int, edges []EdgeEvent) (uint, bool) {
+	levelAt := func(t time.Duration) bool {
+		high := false
+		for _, e := range edges {
+			if e.Timestamp > t {
+				break
+			}
+			high = e.Rising
+		}
+		return high
+	}
+
+	value := uint(0)
+	for i := 0; i < bits; i++ {
+		if levelAt(start + bitTime*time.Duration(2*i+3)/2) {
+			value |= 1 << uint(i)
+		}
+	}
+	return value, levelAt(start + bitTime*time.Duration(2*bits+3)/2)
+}
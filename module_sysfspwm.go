@@ -0,0 +1,380 @@
+// A PWM module using the generic Linux PWM sysfs interface (/sys/class/pwm). Each PWM pin is a channel of a PWM
+// controller, identified by the controller's device tree node name, so the module doesn't depend on the order the
+// kernel numbers pwmchips in.
+
+package hwio
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location of the PWM class. This is a variable so tests can point it elsewhere.
+var pwmSysfsPath = "/sys/class/pwm"
+
+type SysfsPWMModule struct {
+	name        string
+	definedPins SysfsPWMModulePinDefMap
+	openPins    map[Pin]*SysfsPWMModuleOpenPin
+
+	// the filesystem the PWM class is on
+	fs SysfsFS
+}
+
+// Represents a PWM pin: the device tree node of its controller, e.g. "febf0030.pwm", and the channel within it.
+type SysfsPWMModulePinDef struct {
+	pin        Pin
+	controller string
+	channel    int
+}
+
+type SysfsPWMModulePinDefMap map[Pin]*SysfsPWMModulePinDef
+
+type SysfsPWMModuleOpenPin struct {
+	pin Pin
+
+	// directory of the exported channel, e.g. /sys/class/pwm/pwmchip2/pwm0/
+	dir string
+
+	// the pwmchip directory, for unexporting
+	chipDir string
+	channel int
+
+	fs SysfsFS
+
+	period int64
+	duty   int64
+
+	// whether the channel should be running. The kernel won't enable a channel with no period, so this is applied
+	// once a period is set.
+	enable bool
+}
+
+func NewSysfsPWMModule(name string) (result *SysfsPWMModule) {
+	result = &SysfsPWMModule{name: name, fs: defaultSysfs}
+	result.openPins = make(map[Pin]*SysfsPWMModuleOpenPin)
+	return result
+}
+
+// Set the filesystem the module finds the PWM class on, e.g. a FakeSysfs in tests. This must be called before any
+// pins are enabled.
+func (module *SysfsPWMModule) SetFilesystem(fsys SysfsFS) {
+	module.fs = fsys
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type SysfsPWMModulePinDefMap
+func (module *SysfsPWMModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SysfsPWMModulePinDefMap)
+	return nil
+}
+
+// enable PWM module. It doesn't allocate any pins until EnablePin is called.
+func (module *SysfsPWMModule) Enable() error {
+	return nil
+}
+
+// disables module, stopping and unexporting any channels and releasing their pins.
+func (module *SysfsPWMModule) Disable() error {
+	for pin, openPin := range module.openPins {
+		openPin.closePin()
+		UnassignPinFrom(pin, module)
+	}
+	module.openPins = make(map[Pin]*SysfsPWMModuleOpenPin)
+	return nil
+}
+
+func (module *SysfsPWMModule) GetName() string {
+	return module.name
+}
+
+func (module *SysfsPWMModule) KernelInterface() (string, string) {
+	return "sysfs", pwmSysfsPath
+}
+
+// Channels are exported through their controller's pwmchip. Controllers that aren't enabled in device tree can't
+// be checked, and are left for EnablePin to report.
+func (module *SysfsPWMModule) RequiredAccess() []RequiredAccess {
+	result := make([]RequiredAccess, 0)
+	seen := make(map[string]bool)
+	for _, p := range module.definedPins {
+		chip := findPWMChip(module.fs, p.controller)
+		if chip != "" && !seen[chip] {
+			seen[chip] = true
+			result = append(result, RequiredAccess{Path: chip + "/export", Write: true}, RequiredAccess{Path: chip + "/unexport", Write: true})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Enable or disable a PWM pin. The pin is assigned and its channel exported the first time it is enabled. If no
+// period has been set yet, the channel starts running once SetPeriod is called.
+func (module *SysfsPWMModule) EnablePin(pin Pin, enabled bool) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "pwm EnablePin", module.journalTarget(pin), strconv.FormatBool(enabled), "", e)
+		}(time.Now())
+	}
+
+	if module.definedPins[pin] == nil {
+		return fmt.Errorf("pin %d is not known as a PWM pin on module %s", pin, module.GetName())
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		if !enabled {
+			return nil
+		}
+		p, e := module.makeOpenPin(pin)
+		if e != nil {
+			return e
+		}
+		openPin = p
+	}
+
+	openPin.enable = enabled
+	return openPin.applyEnable()
+}
+
+// Set the period of this pin, in nanoseconds
+func (module *SysfsPWMModule) SetPeriod(pin Pin, ns int64) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "pwm SetPeriod", module.journalTarget(pin), strconv.FormatInt(ns, 10), "", e)
+		}(time.Now())
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return fmt.Errorf("the PWM pin is being written but is not enabled, call EnablePin")
+	}
+
+	return openPin.setPeriod(ns)
+}
+
+// Set the duty time, the amount of time during each period that that output is High.
+func (module *SysfsPWMModule) SetDuty(pin Pin, ns int64) (e error) {
+	if JournalEnabled() {
+		defer func(start time.Time) {
+			RecordJournalEvent(start, "pwm SetDuty", module.journalTarget(pin), strconv.FormatInt(ns, 10), "", e)
+		}(time.Now())
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return fmt.Errorf("the PWM pin is being written but is not enabled, call EnablePin")
+	}
+
+	return openPin.setDuty(ns)
+}
+
+// Return the module and pin, as the journal shows them, e.g. "pwm GPIO18".
+func (module *SysfsPWMModule) journalTarget(pin Pin) string {
+	return module.GetName() + " " + boardOf(module).PinName(pin)
+}
+
+// Set the frequency, keeping the duty cycle in proportion. The period and duty are written in whichever order
+// keeps the duty within the period, so the kernel accepts both writes and the output isn't stopped.
+func (module *SysfsPWMModule) SetFrequency(pin Pin, hz float64) error {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return fmt.Errorf("the PWM pin is being written but is not enabled, call EnablePin")
+	}
+	if hz <= 0 {
+		return fmt.Errorf("PWM frequency must be positive, got %g", hz)
+	}
+
+	period := int64(1e9/hz + 0.5)
+	duty := int64(0)
+	if openPin.period > 0 {
+		duty = int64(float64(openPin.duty) * float64(period) / float64(openPin.period))
+	}
+
+	if period < openPin.period {
+		// shorter period: reduce the duty first
+		e := openPin.setDuty(duty)
+		if e != nil {
+			return e
+		}
+		return openPin.setPeriod(period)
+	}
+
+	e := openPin.setPeriod(period)
+	if e != nil {
+		return e
+	}
+	return openPin.setDuty(duty)
+}
+
+// Set the duty cycle as a fraction of the current period.
+func (module *SysfsPWMModule) SetDutyCycle(pin Pin, fraction float64) error {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return fmt.Errorf("the PWM pin is being written but is not enabled, call EnablePin")
+	}
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("PWM duty cycle must be 0 to 1, got %g", fraction)
+	}
+	if openPin.period == 0 {
+		return fmt.Errorf("PWM pin %d has no period, call SetPeriod or SetFrequency first", pin)
+	}
+
+	return openPin.setDuty(int64(float64(openPin.period)*fraction + 0.5))
+}
+
+// The sysfs interface has no control of phase, so only a phase of 0 is accepted.
+func (module *SysfsPWMModule) SetPhase(pin Pin, ns int64) error {
+	if module.openPins[pin] == nil {
+		return fmt.Errorf("the PWM pin is being written but is not enabled, call EnablePin")
+	}
+	if ns != 0 {
+		return fmt.Errorf("module '%s' cannot set phase, the sysfs PWM interface does not support it", module.GetName())
+	}
+	return nil
+}
+
+// Start a set of pins together. All the pins are stopped, then enabled one after the other, so they start within
+// a few microseconds of each other. Channels of a controller that share a counter (e.g. the A and B outputs of an
+// AM335x EHRPWM) are exactly aligned; sysfs gives no way to align separate controllers more closely. The pins
+// must have a period set.
+func (module *SysfsPWMModule) Sync(pins PinList) error {
+	openPins := make([]*SysfsPWMModuleOpenPin, len(pins))
+	for i, pin := range pins {
+		openPins[i] = module.openPins[pin]
+		if openPins[i] == nil {
+			return fmt.Errorf("PWM pin %d is not enabled, call EnablePin", pin)
+		}
+		if openPins[i].period == 0 {
+			return fmt.Errorf("PWM pin %d has no period, call SetPeriod or SetFrequency first", pin)
+		}
+	}
+
+	for _, op := range openPins {
+		e := sysfsWriteString(op.fs, op.dir+"enable", "0")
+		if e != nil {
+			return e
+		}
+	}
+	for _, op := range openPins {
+		op.enable = true
+		e := sysfsWriteString(op.fs, op.dir+"enable", "1")
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (module *SysfsPWMModule) makeOpenPin(pin Pin) (*SysfsPWMModuleOpenPin, error) {
+	p := module.definedPins[pin]
+
+	chipDir := findPWMChip(module.fs, p.controller)
+	if chipDir == "" {
+		return nil, fmt.Errorf("module '%s' could not find PWM controller %s, check it is enabled in device tree", module.GetName(), p.controller)
+	}
+
+	e := AssignPin(pin, module)
+	if e != nil {
+		return nil, e
+	}
+
+	result := &SysfsPWMModuleOpenPin{pin: pin, chipDir: chipDir, channel: p.channel, fs: module.fs}
+	result.dir = fmt.Sprintf("%s/pwm%d/", chipDir, p.channel)
+
+	if !sysfsExists(module.fs, result.dir+"period") {
+		e = sysfsWriteString(module.fs, chipDir+"/export", strconv.Itoa(p.channel))
+		if e != nil {
+			UnassignPinFrom(pin, module)
+			return nil, e
+		}
+		sysfsWaitForExport(module.fs, result.dir+"period")
+	}
+
+	// the channel may have been left running by another program, so pick up its current settings
+	result.period = readPWMValue(module.fs, result.dir+"period")
+	result.duty = readPWMValue(module.fs, result.dir+"duty_cycle")
+
+	// normal polarity, so that the duty time represents the time the signal is high. Not all controllers support
+	// setting polarity, and those that don't are normal.
+	sysfsWriteString(module.fs, result.dir+"polarity", "normal")
+
+	module.openPins[pin] = result
+	return result, nil
+}
+
+// Find the pwmchip directory for a PWM controller, given its device tree node name. Returns "" if not found.
+func findPWMChip(fsys SysfsFS, controller string) string {
+	for _, c := range sysfsMatches(fsys, pwmSysfsPath+"/pwmchip*") {
+		if strings.Contains(sysfsEvalSymlinks(fsys, c), "/"+controller+"/") {
+			return c
+		}
+	}
+	return ""
+}
+
+func readPWMValue(fsys SysfsFS, file string) int64 {
+	b, e := sysfsReadFile(fsys, file)
+	if e != nil {
+		return 0
+	}
+	v, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return v
+}
+
+// Set the period. The kernel rejects a period shorter than the duty cycle, so if the period is being reduced
+// below the current duty, the duty is reduced first.
+func (op *SysfsPWMModuleOpenPin) setPeriod(ns int64) error {
+	if ns < op.duty {
+		e := sysfsWriteString(op.fs, op.dir+"duty_cycle", strconv.FormatInt(ns, 10))
+		if e != nil {
+			return e
+		}
+		op.duty = ns
+	}
+
+	e := sysfsWriteString(op.fs, op.dir+"period", strconv.FormatInt(ns, 10))
+	if e != nil {
+		return e
+	}
+	op.period = ns
+
+	return op.applyEnable()
+}
+
+func (op *SysfsPWMModuleOpenPin) setDuty(ns int64) error {
+	if ns > op.period {
+		return fmt.Errorf("PWM duty of %dns is longer than the period of %dns", ns, op.period)
+	}
+
+	e := sysfsWriteString(op.fs, op.dir+"duty_cycle", strconv.FormatInt(ns, 10))
+	if e != nil {
+		return e
+	}
+	op.duty = ns
+	return nil
+}
+
+// Write the enable state to the channel, if it can be applied.
+func (op *SysfsPWMModuleOpenPin) applyEnable() error {
+	if op.enable {
+		if op.period == 0 {
+			return nil
+		}
+		return sysfsWriteString(op.fs, op.dir+"enable", "1")
+	}
+	return sysfsWriteString(op.fs, op.dir+"enable", "0")
+}
+
+func (op *SysfsPWMModuleOpenPin) closePin() error {
+	sysfsWriteString(op.fs, op.dir+"enable", "0")
+	return sysfsWriteString(op.fs, op.chipDir+"/unexport", strconv.Itoa(op.channel))
+}
@@ -0,0 +1,457 @@
+// Implementation of a serial module over a tty device, such as a UART (/dev/ttyS1, /dev/ttyAMA0) or a USB serial
+// adapter (/dev/ttyUSB0). The port is put in raw mode, and the baud rate is set with termios2, so rates other than
+// the standard ones can be used where the UART can generate them (e.g. 250000 for DMX).
+//
+// RS-485 transceivers are switched between transmit and receive either by the kernel, which drives RTS from the
+// UART driver's interrupt handler (TIOCSRS485), or by a GPIO pin that the module raises around each Write. The
+// kernel is preferred where the UART driver supports it, as the turnaround is much tighter.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/driver-api/serial/serial-rs485.html
+// - include/uapi/asm-generic/termbits.h and include/uapi/linux/serial.h in the kernel tree.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The pins used by a UART, which are assigned when the module is enabled. This is empty for USB adapters.
+type TTYSerialModulePins []Pin
+
+// ioctls and flags used for termios2 and RS-485, from termbits.h and serial.h
+const (
+	ttyIocGetTermios2 = 0x802c542a // TCGETS2
+	ttyIocSetTermios2 = 0x402c542b // TCSETS2
+	ttyIocFlush       = 0x540b     // TCFLSH
+	ttyIocDrain       = 0x5409     // TCSBRK, which waits for output to drain when the argument is non-zero
+	ttyIocSetRS485    = 0x542f     // TIOCSRS485
+
+	ttyCBaud     = 0010017
+	ttyBOther    = 0010000
+	ttyFlushRead = 0 // TCIFLUSH
+
+	serRS485Enabled    = 1 << 0
+	serRS485RTSOnSend  = 1 << 1
+	serRS485RxDuringTx = 1 << 4
+)
+
+// struct termios2, which unlike struct termios has the input and output speeds as numbers.
+type ttyTermios2 struct {
+	iflag  uint32
+	oflag  uint32
+	cflag  uint32
+	lflag  uint32
+	line   uint8
+	cc     [19]uint8
+	ispeed uint32
+	ospeed uint32
+}
+
+// struct serial_rs485
+type ttySerialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32
+	delayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+type TTYSerialModule struct {
+	sync.Mutex
+
+	name        string
+	deviceFile  string
+	baud        int
+	dataBits    int
+	parity      string
+	stopBits    int
+	definedPins TTYSerialModulePins
+
+	// RS-485 configuration, or nil if not in RS-485 mode. If kernelRS485 is false, the direction pin is used.
+	rs485       *RS485Config
+	kernelRS485 bool
+
+	fd *os.File
+}
+
+func NewTTYSerialModule(name string) (result *TTYSerialModule) {
+	result = &TTYSerialModule{name: name, baud: 9600, dataBits: 8, parity: "none", stopBits: 1}
+	return result
+}
+
+// Accept options for the serial module. Expected options include:
+//   - "device" - the tty device file, e.g. "/dev/ttyS1".
+//   - "baud" - optional, the baud rate as an int. Defaults to 9600.
+//   - "dataBits" - optional, 5 to 8. Defaults to 8.
+//   - "parity" - optional, "none", "even" or "odd". Defaults to "none".
+//   - "stopBits" - optional, 1 or 2. Defaults to 1.
+//   - "pins" - optional, an object of type TTYSerialModulePins that identifies the pins that will be assigned when
+//     this module is enabled.
+//   - "rs485" - optional, an *RS485Config to set RS-485 mode when the module is enabled.
+func (module *TTYSerialModule) SetOptions(options map[string]interface{}) error {
+	vd := options["device"]
+	if vd == nil {
+		return fmt.Errorf("module '%s' SetOptions() did not get 'device' value", module.GetName())
+	}
+	module.deviceFile = vd.(string)
+
+	if vb := options["baud"]; vb != nil {
+		module.baud = vb.(int)
+		if module.baud <= 0 {
+			return fmt.Errorf("module '%s' baud rate must be positive, got %d", module.GetName(), module.baud)
+		}
+	}
+
+	if vd := options["dataBits"]; vd != nil {
+		bits := vd.(int)
+		if bits < 5 || bits > 8 {
+			return fmt.Errorf("module '%s' data bits must be 5 to 8, got %d", module.GetName(), bits)
+		}
+		module.dataBits = bits
+	}
+
+	if vp := options["parity"]; vp != nil {
+		if e := checkSerialParity(module, vp.(string)); e != nil {
+			return e
+		}
+		module.parity = vp.(string)
+	}
+
+	if vs := options["stopBits"]; vs != nil {
+		bits := vs.(int)
+		if bits != 1 && bits != 2 {
+			return fmt.Errorf("module '%s' stop bits must be 1 or 2, got %d", module.GetName(), bits)
+		}
+		module.stopBits = bits
+	}
+
+	if vp := options["pins"]; vp != nil {
+		module.definedPins = vp.(TTYSerialModulePins)
+	}
+
+	if vr := options["rs485"]; vr != nil {
+		module.rs485 = vr.(*RS485Config)
+	}
+
+	return nil
+}
+
+// Enable the module: assign the pins, open the device and set it to raw mode at the baud rate, and set RS-485 mode
+// if it was given in the options.
+func (module *TTYSerialModule) Enable() error {
+	for _, pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			module.unassignPins()
+			return e
+		}
+	}
+
+	fd, e := os.OpenFile(module.deviceFile, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if e != nil {
+		module.unassignPins()
+		return fmt.Errorf("module '%s' could not open %s: %s", module.GetName(), module.deviceFile, e)
+	}
+	module.fd = fd
+
+	e = module.setTermios()
+	if e != nil {
+		module.Disable()
+		return e
+	}
+
+	if module.rs485 != nil {
+		config := module.rs485
+		module.rs485 = nil
+		e = module.SetRS485(config)
+		if e != nil {
+			module.Disable()
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Disable the module, turning off RS-485 mode, closing the device and releasing the pins.
+func (module *TTYSerialModule) Disable() error {
+	if module.rs485 != nil && module.fd != nil {
+		module.SetRS485(nil)
+	}
+	if module.fd != nil {
+		if e := module.fd.Close(); e != nil {
+			return e
+		}
+		module.fd = nil
+	}
+	module.unassignPins()
+	return nil
+}
+
+func (module *TTYSerialModule) unassignPins() {
+	for _, pin := range module.definedPins {
+		UnassignPinFrom(pin, module)
+	}
+}
+
+func (module *TTYSerialModule) GetName() string {
+	return module.name
+}
+
+// Return 1 while the port is open, and 0 otherwise.
+func (module *TTYSerialModule) OpenFileDescriptors() int {
+	module.Lock()
+	defer module.Unlock()
+	if module.fd == nil {
+		return 0
+	}
+	return 1
+}
+
+func (module *TTYSerialModule) KernelInterface() (string, string) {
+	return "tty", module.deviceFile
+}
+
+func (module *TTYSerialModule) RequiredAccess() []RequiredAccess {
+	return []RequiredAccess{{Path: module.deviceFile, Write: true}}
+}
+
+// Set the port to raw mode, 8N1, at the module's baud rate. Reads wait for at least one byte.
+func (module *TTYSerialModule) setTermios() error {
+	var t ttyTermios2
+	e := ttyIoctl(module.fd, ttyIocGetTermios2, uintptr(unsafe.Pointer(&t)))
+	if e != nil {
+		return fmt.Errorf("module '%s' could not get the settings of %s: %s", module.GetName(), module.deviceFile, e)
+	}
+
+	// the same as cfmakeraw
+	t.iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.oflag &^= syscall.OPOST
+	t.lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | ttyCBaud
+	t.cflag |= ttyCharSize[module.dataBits] | syscall.CLOCAL | syscall.CREAD | ttyBOther
+	t.cflag &^= syscall.PARODD
+	if module.stopBits == 2 {
+		t.cflag |= syscall.CSTOPB
+	}
+	switch module.parity {
+	case "even":
+		t.cflag |= syscall.PARENB
+	case "odd":
+		t.cflag |= syscall.PARENB | syscall.PARODD
+	}
+	t.cc[syscall.VMIN] = 1
+	t.cc[syscall.VTIME] = 0
+	t.ispeed = uint32(module.baud)
+	t.ospeed = uint32(module.baud)
+
+	e = ttyIoctl(module.fd, ttyIocSetTermios2, uintptr(unsafe.Pointer(&t)))
+	if e != nil {
+		return fmt.Errorf("module '%s' could not set %s to %d baud: %s", module.GetName(), module.deviceFile, module.baud, e)
+	}
+	return nil
+}
+
+// The termios character size flag for each number of data bits.
+var ttyCharSize = map[int]uint32{5: syscall.CS5, 6: syscall.CS6, 7: syscall.CS7, 8: syscall.CS8}
+
+// Set the baud rate. If the module is enabled, this takes effect immediately.
+func (module *TTYSerialModule) SetBaudRate(baud int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if baud <= 0 {
+		return fmt.Errorf("module '%s' baud rate must be positive, got %d", module.GetName(), baud)
+	}
+	module.baud = baud
+	if module.fd == nil {
+		return nil
+	}
+	return module.setTermios()
+}
+
+// Check a serial module's parity setting is one of "none", "even" or "odd".
+func checkSerialParity(module Module, parity string) error {
+	if parity != "none" && parity != "even" && parity != "odd" {
+		return fmt.Errorf("module '%s' parity must be 'none', 'even' or 'odd', got '%s'", module.GetName(), parity)
+	}
+	return nil
+}
+
+// Set the parity, "none", "even" or "odd". If the module is enabled, this takes effect immediately.
+func (module *TTYSerialModule) SetParity(parity string) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if e := checkSerialParity(module, parity); e != nil {
+		return e
+	}
+	module.parity = parity
+	if module.fd == nil {
+		return nil
+	}
+	return module.setTermios()
+}
+
+// Read the bytes received, waiting until there is at least one. Reads can be made while another goroutine writes.
+func (module *TTYSerialModule) Read(data []byte) (int, error) {
+	fd := module.fd
+	if fd == nil {
+		return 0, fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	return fd.Read(data)
+}
+
+// Make reads waiting past t return os.ErrDeadlineExceeded. A zero t means reads wait indefinitely.
+func (module *TTYSerialModule) SetReadDeadline(t time.Time) error {
+	fd := module.fd
+	if fd == nil {
+		return fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	return fd.SetReadDeadline(t)
+}
+
+// Write bytes to the port. In RS-485 mode with a direction pin, the pin is held high from DelayBeforeSend before
+// the first bit until DelayAfterSend after the last, so Write returns once the bytes have been sent on the wire.
+func (module *TTYSerialModule) Write(data []byte) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.fd == nil {
+		return 0, fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+	if module.rs485 == nil || module.kernelRS485 {
+		return module.fd.Write(data)
+	}
+	return module.writeWithDirectionPin(data)
+}
+
+// Write with the RS-485 direction pin raised. tcdrain waits for the UART to empty, but on some drivers returns
+// when the last byte reaches the FIFO rather than the wire, so the time the bytes take at the baud rate is also
+// waited out before the transceiver is turned around.
+func (module *TTYSerialModule) writeWithDirectionPin(data []byte) (int, error) {
+	config := module.rs485
+
+	e := DigitalWrite(config.DirectionPin, High)
+	if e != nil {
+		return 0, e
+	}
+	defer DigitalWrite(config.DirectionPin, Low)
+	if config.DelayBeforeSend > 0 {
+		time.Sleep(config.DelayBeforeSend)
+	}
+
+	start := time.Now()
+	n, e := module.fd.Write(data)
+	if e != nil {
+		return n, e
+	}
+	e = ttyIoctl(module.fd, ttyIocDrain, 1)
+	if e != nil {
+		return n, e
+	}
+	end := start.Add(module.byteTime() * time.Duration(n))
+	for time.Now().Before(end) {
+		// busy wait, as a sleep would overshoot the turnaround by a scheduler tick
+	}
+	if config.DelayAfterSend > 0 {
+		time.Sleep(config.DelayAfterSend)
+	}
+
+	if !config.RxDuringTx {
+		// discard the transceiver's echo of what was sent
+		e = ttyIoctl(module.fd, ttyIocFlush, ttyFlushRead)
+	}
+	return n, e
+}
+
+// The time taken to send one character: the start bit, the data bits, the parity bit if there is one, and the stop
+// bits.
+func (module *TTYSerialModule) byteTime() time.Duration {
+	bits := 1 + module.dataBits + module.stopBits
+	if module.parity != "none" {
+		bits++
+	}
+	return time.Duration(int64(bits) * int64(time.Second) / int64(module.baud))
+}
+
+// Set RS-485 mode, or turn it off with a nil config. Without a direction pin, the UART driver must support
+// TIOCSRS485, and RTS is used to drive the transceiver. With a direction pin, the pin is set as an output, low
+// (receive), and the module drives it on each Write. The module must be enabled.
+func (module *TTYSerialModule) SetRS485(config *RS485Config) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.fd == nil {
+		return fmt.Errorf("module '%s' is not enabled", module.GetName())
+	}
+
+	// turn off the current mode
+	if module.rs485 != nil {
+		if module.kernelRS485 {
+			off := ttySerialRS485{}
+			e := ttyIoctl(module.fd, ttyIocSetRS485, uintptr(unsafe.Pointer(&off)))
+			if e != nil {
+				return fmt.Errorf("module '%s' could not turn off RS-485 mode: %s", module.GetName(), e)
+			}
+		} else {
+			ClosePin(module.rs485.DirectionPin)
+		}
+		module.rs485 = nil
+	}
+
+	if config == nil {
+		return nil
+	}
+
+	if config.UseDirectionPin {
+		e := PinModeOutputInit(config.DirectionPin, Low)
+		if e != nil {
+			return fmt.Errorf("module '%s' could not set RS-485 direction pin: %s", module.GetName(), e)
+		}
+		module.kernelRS485 = false
+		module.rs485 = config
+		return nil
+	}
+
+	rs := ttySerialRS485{
+		flags:              serRS485Enabled | serRS485RTSOnSend,
+		delayRTSBeforeSend: uint32(config.DelayBeforeSend / time.Millisecond),
+		delayRTSAfterSend:  uint32(config.DelayAfterSend / time.Millisecond),
+	}
+	if config.RxDuringTx {
+		rs.flags |= serRS485RxDuringTx
+	}
+	e := ttyIoctl(module.fd, ttyIocSetRS485, uintptr(unsafe.Pointer(&rs)))
+	if e != nil {
+		return fmt.Errorf("module '%s' UART does not support RS-485 in the kernel (%s); use a direction pin instead", module.GetName(), e)
+	}
+	module.kernelRS485 = true
+	module.rs485 = config
+	return nil
+}
+
+// Make an ioctl on a tty. This goes through SyscallConn, as Fd would put the file in blocking mode.
+func ttyIoctl(fd *os.File, request uintptr, arg uintptr) error {
+	rc, e := fd.SyscallConn()
+	if e != nil {
+		return e
+	}
+
+	var errno syscall.Errno
+	e = rc.Control(func(f uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, f, request, arg)
+	})
+	if e != nil {
+		return e
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
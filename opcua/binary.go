@@ -0,0 +1,556 @@
+package opcua
+
+// The OPC UA binary encoding (Part 6, section 5.2) of the built-in types the server's services use. Encoding never
+// fails; decoding keeps the first error, and returns zero values after it, so a message is decoded field by field and
+// checked once at the end.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A NodeId. Numeric ids have Name "", string ids have ID 0. GUID and opaque ids are decoded with their bytes in Name,
+// behind a prefix, so they are never equal to a node of the server.
+type NodeId struct {
+	Namespace uint16
+	ID        uint32
+	Name      string
+}
+
+func (n NodeId) String() string {
+	if n.Name != "" {
+		return fmt.Sprintf("ns=%d;s=%s", n.Namespace, n.Name)
+	}
+	return fmt.Sprintf("ns=%d;i=%d", n.Namespace, n.ID)
+}
+
+// A numeric id in namespace 0, the namespace of the OPC UA specification.
+func ns0(id uint32) NodeId {
+	return NodeId{ID: id}
+}
+
+type QualifiedName struct {
+	Namespace uint16
+	Name      string
+}
+
+// A LocalizedText with no locale, as a value of a Variant.
+type localizedText string
+
+// Seconds between 1601-01-01, the epoch of OPC UA's DateTime, and the Unix epoch.
+const epochOffset = 11644473600
+
+// The types of Variant, as encoded in its mask.
+const (
+	typeBoolean       = 1
+	typeSByte         = 2
+	typeByte          = 3
+	typeInt16         = 4
+	typeUInt16        = 5
+	typeInt32         = 6
+	typeUInt32        = 7
+	typeInt64         = 8
+	typeUInt64        = 9
+	typeFloat         = 10
+	typeDouble        = 11
+	typeString        = 12
+	typeDateTime      = 13
+	typeByteString    = 15
+	typeNodeId        = 17
+	typeStatusCode    = 19
+	typeQualifiedName = 20
+	typeLocalizedText = 21
+	typeExtension     = 22
+
+	variantArray = 0x80
+)
+
+// The parts of a DataValue, as encoded in its mask.
+const (
+	dataValueValue           = 0x01
+	dataValueStatus          = 0x02
+	dataValueSourceTimestamp = 0x04
+	dataValueServerTimestamp = 0x08
+	dataValueSourcePico      = 0x10
+	dataValueServerPico      = 0x20
+)
+
+// A DataValue: a value, with its status and when it was read. A nil Value is left out of the encoding.
+type DataValue struct {
+	Value  interface{}
+	Status uint32
+	Time   time.Time
+}
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) uint8(v byte) {
+	e.WriteByte(v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *encoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) double(v float64) {
+	e.int64(int64(math.Float64bits(v)))
+}
+
+// Encode a String, with "" as the null string.
+func (e *encoder) string(v string) {
+	if v == "" {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.WriteString(v)
+}
+
+// Encode a ByteString, with nil as the null string.
+func (e *encoder) byteString(v []byte) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.Write(v)
+}
+
+func (e *encoder) strings(v []string) {
+	e.int32(int32(len(v)))
+	for _, s := range v {
+		e.string(s)
+	}
+}
+
+// Encode a DateTime, with the zero time as 0, which OPC UA takes as no time.
+func (e *encoder) dateTime(t time.Time) {
+	if t.IsZero() {
+		e.int64(0)
+		return
+	}
+	e.int64((t.Unix()+epochOffset)*10000000 + int64(t.Nanosecond()/100))
+}
+
+// Encode a NodeId in the smallest of its forms.
+func (e *encoder) nodeId(n NodeId) {
+	switch {
+	case n.Name != "":
+		e.uint8(0x03)
+		e.uint16(n.Namespace)
+		e.string(n.Name)
+	case n.Namespace == 0 && n.ID < 256:
+		e.uint8(0x00)
+		e.uint8(byte(n.ID))
+	case n.Namespace < 256 && n.ID < 65536:
+		e.uint8(0x01)
+		e.uint8(byte(n.Namespace))
+		e.uint16(uint16(n.ID))
+	default:
+		e.uint8(0x02)
+		e.uint16(n.Namespace)
+		e.uint32(n.ID)
+	}
+}
+
+func (e *encoder) qualifiedName(q QualifiedName) {
+	e.uint16(q.Namespace)
+	e.string(q.Name)
+}
+
+// Encode a LocalizedText with no locale.
+func (e *encoder) localizedText(text string) {
+	if text == "" {
+		e.uint8(0)
+		return
+	}
+	e.uint8(0x02)
+	e.string(text)
+}
+
+// Encode an ExtensionObject whose body is encoded by body, or the null object if body is nil.
+func (e *encoder) extensionObject(typeId NodeId, body func(*encoder)) {
+	if body == nil {
+		e.nodeId(NodeId{})
+		e.uint8(0)
+		return
+	}
+	var b encoder
+	body(&b)
+	e.nodeId(typeId)
+	e.uint8(1)
+	e.byteString(b.Bytes())
+}
+
+// Encode a Variant holding one of the types the server's attributes have, or an array of strings.
+func (e *encoder) variant(v interface{}) error {
+	switch v := v.(type) {
+	case bool:
+		e.uint8(typeBoolean)
+		e.bool(v)
+	case byte:
+		e.uint8(typeByte)
+		e.uint8(v)
+	case int32:
+		e.uint8(typeInt32)
+		e.int32(v)
+	case uint32:
+		e.uint8(typeUInt32)
+		e.uint32(v)
+	case float64:
+		e.uint8(typeDouble)
+		e.double(v)
+	case string:
+		e.uint8(typeString)
+		e.string(v)
+	case time.Time:
+		e.uint8(typeDateTime)
+		e.dateTime(v)
+	case NodeId:
+		e.uint8(typeNodeId)
+		e.nodeId(v)
+	case QualifiedName:
+		e.uint8(typeQualifiedName)
+		e.qualifiedName(v)
+	case localizedText:
+		e.uint8(typeLocalizedText)
+		e.localizedText(string(v))
+	case []string:
+		e.uint8(typeString | variantArray)
+		e.strings(v)
+	case extensionObject:
+		e.uint8(typeExtension)
+		e.extensionObject(v.typeId, v.body)
+	default:
+		return fmt.Errorf("type %T can't be encoded as a Variant", v)
+	}
+	return nil
+}
+
+// A structure to encode in a Variant, as an ExtensionObject.
+type extensionObject struct {
+	typeId NodeId
+	body   func(*encoder)
+}
+
+func (e *encoder) dataValue(v DataValue) {
+	mask := byte(0)
+	if v.Value != nil {
+		mask |= dataValueValue
+	}
+	if v.Status != 0 {
+		mask |= dataValueStatus
+	}
+	if !v.Time.IsZero() {
+		mask |= dataValueSourceTimestamp | dataValueServerTimestamp
+	}
+	e.uint8(mask)
+	if v.Value != nil {
+		e.variant(v.Value)
+	}
+	if v.Status != 0 {
+		e.uint32(v.Status)
+	}
+	if !v.Time.IsZero() {
+		e.dateTime(v.Time)
+		e.dateTime(v.Time)
+	}
+}
+
+type decoder struct {
+	b []byte
+	e error
+}
+
+// Take the next n bytes, or nil if there aren't that many left.
+func (d *decoder) next(n int) []byte {
+	if d.e != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.e = errors.New("message is truncated")
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.uint8() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func (d *decoder) double() float64 {
+	return math.Float64frombits(uint64(d.int64()))
+}
+
+// Decode a String, with the null string as "".
+func (d *decoder) string() string {
+	return string(d.byteString())
+}
+
+func (d *decoder) byteString() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// Decode the length of an array, checking it's no longer than the rest of the message could hold.
+func (d *decoder) arrayLength() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		d.e = errors.New("array is longer than the message")
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) strings() []string {
+	n := d.arrayLength()
+	result := make([]string, 0, n)
+	for i := 0; i < n && d.e == nil; i++ {
+		result = append(result, d.string())
+	}
+	return result
+}
+
+func (d *decoder) dateTime() time.Time {
+	v := d.int64()
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v/10000000-epochOffset, v%10000000*100).UTC()
+}
+
+func (d *decoder) nodeId() NodeId {
+	n, _ := d.expandedNodeId()
+	return n
+}
+
+// Decode an ExpandedNodeId, returning whether it is on this server, and in a namespace given by index.
+func (d *decoder) expandedNodeId() (NodeId, bool) {
+	var n NodeId
+	format := d.uint8()
+	switch format & 0x3f {
+	case 0x00:
+		n.ID = uint32(d.uint8())
+	case 0x01:
+		n.Namespace = uint16(d.uint8())
+		n.ID = uint32(d.uint16())
+	case 0x02:
+		n.Namespace = d.uint16()
+		n.ID = d.uint32()
+	case 0x03:
+		n.Namespace = d.uint16()
+		n.Name = d.string()
+	case 0x04:
+		n.Namespace = d.uint16()
+		n.Name = "guid:" + string(d.next(16))
+	case 0x05:
+		n.Namespace = d.uint16()
+		n.Name = "opaque:" + string(d.byteString())
+	default:
+		if d.e == nil {
+			d.e = fmt.Errorf("unknown NodeId encoding %#x", format)
+		}
+	}
+	local := true
+	if format&0x80 != 0 {
+		d.string()
+		local = false
+	}
+	if format&0x40 != 0 {
+		if d.uint32() != 0 {
+			local = false
+		}
+	}
+	return n, local
+}
+
+func (d *decoder) qualifiedName() QualifiedName {
+	return QualifiedName{Namespace: d.uint16(), Name: d.string()}
+}
+
+// Decode a LocalizedText, returning its text.
+func (d *decoder) localizedText() string {
+	mask := d.uint8()
+	if mask&0x01 != 0 {
+		d.string()
+	}
+	if mask&0x02 != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+// Decode an ExtensionObject, returning its type and its body, if encoded in binary.
+func (d *decoder) extensionObject() (NodeId, []byte) {
+	typeId := d.nodeId()
+	switch d.uint8() {
+	case 0:
+		return typeId, nil
+	case 1:
+		return typeId, d.byteString()
+	default:
+		d.byteString()
+		return typeId, nil
+	}
+}
+
+// Decode a Variant of a numeric, boolean, string or time type, or an array of them. Other types are an error.
+func (d *decoder) variant() interface{} {
+	mask := d.uint8()
+	if mask&variantArray != 0 {
+		if mask&0x40 != 0 {
+			d.e = errors.New("multi-dimensional arrays are not supported")
+			return nil
+		}
+		n := d.arrayLength()
+		result := make([]interface{}, 0, n)
+		for i := 0; i < n && d.e == nil; i++ {
+			result = append(result, d.scalar(mask&0x3f))
+		}
+		return result
+	}
+	return d.scalar(mask & 0x3f)
+}
+
+func (d *decoder) scalar(t byte) interface{} {
+	switch t {
+	case 0:
+		return nil
+	case typeBoolean:
+		return d.bool()
+	case typeSByte:
+		return int8(d.uint8())
+	case typeByte:
+		return d.uint8()
+	case typeInt16:
+		return int16(d.uint16())
+	case typeUInt16:
+		return d.uint16()
+	case typeInt32:
+		return d.int32()
+	case typeUInt32:
+		return d.uint32()
+	case typeInt64:
+		return d.int64()
+	case typeUInt64:
+		return uint64(d.int64())
+	case typeFloat:
+		return math.Float32frombits(d.uint32())
+	case typeDouble:
+		return d.double()
+	case typeString:
+		return d.string()
+	case typeDateTime:
+		return d.dateTime()
+	case typeByteString:
+		return d.byteString()
+	case typeStatusCode:
+		return d.uint32()
+	case typeLocalizedText:
+		return d.localizedText()
+	}
+	if d.e == nil {
+		d.e = fmt.Errorf("Variant type %d is not supported", t)
+	}
+	return nil
+}
+
+func (d *decoder) dataValue() DataValue {
+	var v DataValue
+	mask := d.uint8()
+	if mask&dataValueValue != 0 {
+		v.Value = d.variant()
+	}
+	if mask&dataValueStatus != 0 {
+		v.Status = d.uint32()
+	}
+	if mask&dataValueSourceTimestamp != 0 {
+		v.Time = d.dateTime()
+	}
+	if mask&dataValueSourcePico != 0 {
+		d.uint16()
+	}
+	if mask&dataValueServerTimestamp != 0 {
+		d.dateTime()
+	}
+	if mask&dataValueServerPico != 0 {
+		d.uint16()
+	}
+	return v
+}
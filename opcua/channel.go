@@ -0,0 +1,291 @@
+package opcua
+
+// The OPC UA TCP transport and secure channel (Part 6, sections 6.7 and 7.1), with security policy None only: a
+// connection starts with Hello and Acknowledge, then opens a secure channel, then sends requests as messages of one
+// or more chunks.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	protocolVersion = 0
+
+	// The largest chunk the server receives or sends, and the largest request it accepts.
+	bufferSize     = 65536
+	maxMessageSize = 4 << 20
+
+	securityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	transportProfile   = "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary"
+
+	messageSecurityModeNone = 1
+
+	// The length of a chunk's header: message type, chunk type and size.
+	chunkHeaderSize = 8
+)
+
+// The encoding ids of the secure channel's requests and responses.
+const (
+	idOpenSecureChannelRequest  = 446
+	idOpenSecureChannelResponse = 449
+)
+
+// A client's connection, with the secure channel opened on it.
+type channel struct {
+	server *Server
+	conn   net.Conn
+	r      *bufio.Reader
+
+	// the secure channel and its current token, 0 before the channel is opened
+	id      uint32
+	tokenId uint32
+
+	// the largest chunk the client receives
+	sendBufferSize uint32
+	sequence       uint32
+
+	// the URL the client connected to, which is returned as the server's endpoint
+	endpointUrl string
+
+	// the body of a request being received in chunks
+	pending []byte
+}
+
+// Serve a connection until the client closes the secure channel or an error ends it.
+func (s *Server) serveConnection(conn net.Conn) error {
+	c := &channel{server: s, conn: conn, r: bufio.NewReader(conn)}
+	defer s.closeSessions(c)
+
+	messageType, _, body, e := c.readChunk()
+	if e != nil {
+		return e
+	}
+	if messageType != "HEL" {
+		return c.sendError(statusBadTcpMessageTypeInvalid, "expected Hello")
+	}
+	e = c.hello(body)
+	if e != nil {
+		return e
+	}
+
+	for {
+		messageType, chunkType, body, e := c.readChunk()
+		if e != nil {
+			return e
+		}
+		switch messageType {
+		case "OPN":
+			e = c.open(body)
+		case "MSG":
+			e = c.message(chunkType, body)
+		case "CLO":
+			return nil
+		default:
+			return c.sendError(statusBadTcpMessageTypeInvalid, fmt.Sprintf("unexpected message type %q", messageType))
+		}
+		if e != nil {
+			return e
+		}
+	}
+}
+
+// Read a chunk, returning its message type, chunk type and the rest of it.
+func (c *channel) readChunk() (string, byte, []byte, error) {
+	header := make([]byte, chunkHeaderSize)
+	_, e := io.ReadFull(c.r, header)
+	if e != nil {
+		return "", 0, nil, e
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < chunkHeaderSize || size > bufferSize {
+		return "", 0, nil, c.sendError(statusBadTcpMessageTooLarge, fmt.Sprintf("chunk of %d bytes", size))
+	}
+	body := make([]byte, size-chunkHeaderSize)
+	_, e = io.ReadFull(c.r, body)
+	if e != nil {
+		return "", 0, nil, e
+	}
+	return string(header[:3]), header[3], body, nil
+}
+
+// Send an Error message and return it as an error, to end the connection.
+func (c *channel) sendError(status uint32, reason string) error {
+	var e encoder
+	e.uint32(status)
+	e.string(reason)
+	c.writeChunk("ERR", 'F', e.Bytes())
+	return fmt.Errorf("OPC UA error %#08x: %s", status, reason)
+}
+
+func (c *channel) writeChunk(messageType string, chunkType byte, body []byte) error {
+	var e encoder
+	e.WriteString(messageType)
+	e.uint8(chunkType)
+	e.uint32(uint32(chunkHeaderSize + len(body)))
+	e.Write(body)
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(e.Bytes())
+	return err
+}
+
+// Answer the client's Hello with the buffer sizes both will use.
+func (c *channel) hello(body []byte) error {
+	d := decoder{b: body}
+	d.uint32() // protocol version
+	receiveBufferSize := d.uint32()
+	d.uint32() // send buffer size
+	d.uint32() // max message size
+	d.uint32() // max chunk count
+	c.endpointUrl = d.string()
+	if d.e != nil {
+		return c.sendError(statusBadDecodingError, d.e.Error())
+	}
+	if receiveBufferSize < 8192 {
+		return c.sendError(statusBadTcpInternalError, "receive buffer smaller than 8192 bytes")
+	}
+	c.sendBufferSize = receiveBufferSize
+	if c.sendBufferSize > bufferSize {
+		c.sendBufferSize = bufferSize
+	}
+
+	var e encoder
+	e.uint32(protocolVersion)
+	e.uint32(bufferSize)
+	e.uint32(c.sendBufferSize)
+	e.uint32(maxMessageSize)
+	e.uint32(0)
+	return c.writeChunk("ACK", 'F', e.Bytes())
+}
+
+// Issue or renew the secure channel.
+func (c *channel) open(body []byte) error {
+	d := decoder{b: body}
+	d.uint32() // secure channel id
+	policy := d.string()
+	d.byteString() // sender certificate
+	d.byteString() // receiver certificate thumbprint
+	if d.e == nil && policy != securityPolicyNone {
+		return c.sendError(statusBadSecurityPolicyRejected, fmt.Sprintf("security policy %s is not supported", policy))
+	}
+	d.uint32() // sequence number
+	requestId := d.uint32()
+	typeId := d.nodeId()
+	header := decodeRequestHeader(&d)
+	d.uint32() // client protocol version
+	requestType := d.int32()
+	mode := d.int32()
+	d.byteString() // client nonce
+	lifetime := d.uint32()
+	if d.e != nil || typeId != ns0(idOpenSecureChannelRequest) {
+		return c.sendError(statusBadDecodingError, "invalid OpenSecureChannel request")
+	}
+	if mode != messageSecurityModeNone {
+		return c.sendError(statusBadSecurityModeRejected, fmt.Sprintf("security mode %d is not supported", mode))
+	}
+
+	c.server.Lock()
+	switch {
+	case requestType == 0 && c.id == 0:
+		c.id = c.server.newId()
+	case requestType == 1 && c.id != 0:
+	default:
+		c.server.Unlock()
+		return c.sendError(statusBadSecureChannelIdInvalid, "secure channel can't be issued or renewed")
+	}
+	c.tokenId = c.server.newId()
+	c.server.Unlock()
+
+	if lifetime == 0 {
+		lifetime = 3600000
+	}
+	var e encoder
+	e.nodeId(ns0(idOpenSecureChannelResponse))
+	encodeResponseHeader(&e, header.handle, statusGood)
+	e.uint32(protocolVersion)
+	e.uint32(c.id)
+	e.uint32(c.tokenId)
+	e.dateTime(time.Now())
+	e.uint32(lifetime)
+	e.byteString([]byte{})
+
+	var security encoder
+	security.uint32(c.id)
+	security.string(securityPolicyNone)
+	security.byteString(nil)
+	security.byteString(nil)
+	return c.send("OPN", security.Bytes(), requestId, e.Bytes())
+}
+
+// Receive a chunk of a request, and handle the request once all of its chunks are in.
+func (c *channel) message(chunkType byte, body []byte) error {
+	d := decoder{b: body}
+	channelId := d.uint32()
+	d.uint32() // token id
+	d.uint32() // sequence number
+	requestId := d.uint32()
+	if d.e != nil {
+		return c.sendError(statusBadDecodingError, d.e.Error())
+	}
+	if c.id == 0 || channelId != c.id {
+		return c.sendError(statusBadSecureChannelIdInvalid, "secure channel is not open")
+	}
+
+	switch chunkType {
+	case 'A':
+		c.pending = nil
+		return nil
+	case 'C', 'F':
+		if len(c.pending)+len(d.b) > maxMessageSize {
+			return c.sendError(statusBadTcpMessageTooLarge, "request is too large")
+		}
+		c.pending = append(c.pending, d.b...)
+		if chunkType == 'C' {
+			return nil
+		}
+	default:
+		return c.sendError(statusBadTcpMessageTypeInvalid, fmt.Sprintf("unknown chunk type %q", chunkType))
+	}
+	request := c.pending
+	c.pending = nil
+
+	response := c.server.handle(c, request)
+	var security encoder
+	security.uint32(c.id)
+	security.uint32(c.tokenId)
+	return c.send("MSG", security.Bytes(), requestId, response)
+}
+
+// Send a message in as many chunks as the client's receive buffer needs, each with the security header and a
+// sequence header.
+func (c *channel) send(messageType string, security []byte, requestId uint32, body []byte) error {
+	room := int(c.sendBufferSize) - chunkHeaderSize - len(security) - 8
+	if room <= 0 {
+		return errors.New("send buffer is too small for the security header")
+	}
+	for {
+		part := body
+		chunkType := byte('F')
+		if len(part) > room {
+			part = part[:room]
+			chunkType = 'C'
+		}
+		body = body[len(part):]
+
+		c.sequence++
+		var e encoder
+		e.Write(security)
+		e.uint32(c.sequence)
+		e.uint32(requestId)
+		e.Write(part)
+		err := c.writeChunk(messageType, chunkType, e.Bytes())
+		if err != nil || chunkType == 'F' {
+			return err
+		}
+	}
+}
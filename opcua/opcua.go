@@ -0,0 +1,355 @@
+// An OPC UA server exposing pins of the board as variables, for SCADA systems and other industrial clients. The
+// program chooses the pins to expose, and serves them on a listener:
+//
+//	s := opcua.NewServer()
+//	s.AddInput("P8.14")   // Boolean, read only
+//	s.AddOutput("P8.13")  // Boolean, writable
+//	s.AddAnalog("AIN0")   // Int32, read only
+//	listener, err := net.Listen("tcp", ":4840")
+//	s.Serve(listener)
+//
+// Clients connect to opc.tcp://<board>:4840. The pins are in the Pins folder of the Objects folder, with node ids in
+// namespace 1 named after the pins as they were added, e.g. "ns=1;s=P8.13". Their values are read from the pins when
+// a client reads them; outputs are written with DigitalWrite.
+//
+// Current status:
+// - only the binary protocol over TCP, with security policy None and anonymous sessions: use it on a trusted network
+// - the services are Browse, Read and Write, with the discovery and session services; there are no subscriptions, so
+//   clients must poll
+// - Browse returns all the references of a node at once, with no continuation points
+// - sessions end when their secure channel closes
+
+package opcua
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+const (
+	// The namespace of the nodes of the pins.
+	namespaceUri = "urn:hwio:pins"
+
+	applicationUri  = "urn:hwio:server"
+	productUri      = "https://github.com/cinellodev/hwio"
+	applicationName = "hwio"
+)
+
+// Node classes.
+const (
+	classObject       = 1
+	classVariable     = 2
+	classObjectType   = 8
+	classVariableType = 16
+)
+
+// Well-known nodes of namespace 0.
+const (
+	idBoolean                    = 1
+	idInt32                      = 6
+	idString                     = 12
+	idReferences                 = 31
+	idNonHierarchicalReferences  = 32
+	idHierarchicalReferences     = 33
+	idHasChild                   = 34
+	idOrganizes                  = 35
+	idHasTypeDefinition          = 40
+	idAggregates                 = 44
+	idHasProperty                = 46
+	idHasComponent               = 47
+	idBaseObjectType             = 58
+	idFolderType                 = 61
+	idBaseDataVariableType       = 63
+	idPropertyType               = 68
+	idRootFolder                 = 84
+	idObjectsFolder              = 85
+	idTypesFolder                = 86
+	idViewsFolder                = 87
+	idUtcTime                    = 294
+	idServerState                = 852
+	idServerStatusDataType       = 862
+	idServerStatusDataTypeBinary = 864
+	idServerType                 = 2004
+	idServerStatusType           = 2138
+	idServer                     = 2253
+	idServerArray                = 2254
+	idNamespaceArray             = 2255
+	idServerStatus               = 2256
+	idServerStatusStartTime      = 2257
+	idServerStatusCurrentTime    = 2258
+	idServerStatusState          = 2259
+)
+
+// The supertype of each reference type the server uses, for browsing with IncludeSubtypes.
+var referenceSupertypes = map[uint32]uint32{
+	idNonHierarchicalReferences: idReferences,
+	idHierarchicalReferences:    idReferences,
+	idHasChild:                  idHierarchicalReferences,
+	idOrganizes:                 idHierarchicalReferences,
+	idHasTypeDefinition:         idNonHierarchicalReferences,
+	idAggregates:                idHasChild,
+	idHasProperty:               idAggregates,
+	idHasComponent:              idAggregates,
+}
+
+// Access levels of variables.
+const (
+	accessRead  = 0x01
+	accessWrite = 0x02
+)
+
+// A node of the address space.
+type node struct {
+	id          NodeId
+	class       int32
+	browseName  QualifiedName
+	displayName string
+	references  []reference
+
+	// for variables
+	dataType  NodeId
+	valueRank int32
+	read      func() DataValue
+	write     func(value interface{}) uint32
+}
+
+type reference struct {
+	typeId  NodeId
+	forward bool
+	target  NodeId
+}
+
+// Return the type definition of the node, or the null NodeId if it has none.
+func (n *node) typeDefinition() NodeId {
+	for _, r := range n.references {
+		if r.forward && r.typeId == ns0(idHasTypeDefinition) {
+			return r.target
+		}
+	}
+	return NodeId{}
+}
+
+type Server struct {
+	// serialises the server's use of hwio, and its address space and sessions
+	sync.Mutex
+
+	nodes    map[NodeId]*node
+	sessions map[NodeId]*session
+	lastId   uint32
+	start    time.Time
+	conns    map[net.Conn]bool
+}
+
+// Create a server with an empty Pins folder.
+func NewServer() *Server {
+	s := &Server{nodes: make(map[NodeId]*node), sessions: make(map[NodeId]*session), start: time.Now()}
+
+	objectTypes := map[uint32]string{idBaseObjectType: "BaseObjectType", idFolderType: "FolderType", idServerType: "ServerType"}
+	for id, name := range objectTypes {
+		s.addNode(&node{id: ns0(id), class: classObjectType, browseName: QualifiedName{Name: name}})
+	}
+	variableTypes := map[uint32]string{idBaseDataVariableType: "BaseDataVariableType", idPropertyType: "PropertyType", idServerStatusType: "ServerStatusType"}
+	for id, name := range variableTypes {
+		s.addNode(&node{id: ns0(id), class: classVariableType, browseName: QualifiedName{Name: name}})
+	}
+
+	s.addObject(NodeId{}, 0, ns0(idRootFolder), "Root", idFolderType)
+	s.addObject(ns0(idRootFolder), idOrganizes, ns0(idObjectsFolder), "Objects", idFolderType)
+	s.addObject(ns0(idRootFolder), idOrganizes, ns0(idTypesFolder), "Types", idFolderType)
+	s.addObject(ns0(idRootFolder), idOrganizes, ns0(idViewsFolder), "Views", idFolderType)
+
+	s.addObject(ns0(idObjectsFolder), idOrganizes, ns0(idServer), "Server", idServerType)
+	s.addVariable(ns0(idServer), idHasProperty, &node{id: ns0(idNamespaceArray), browseName: QualifiedName{Name: "NamespaceArray"},
+		dataType: ns0(idString), valueRank: 1, read: constant([]string{"http://opcfoundation.org/UA/", namespaceUri})}, idPropertyType)
+	s.addVariable(ns0(idServer), idHasProperty, &node{id: ns0(idServerArray), browseName: QualifiedName{Name: "ServerArray"},
+		dataType: ns0(idString), valueRank: 1, read: constant([]string{applicationUri})}, idPropertyType)
+	s.addVariable(ns0(idServer), idHasComponent, &node{id: ns0(idServerStatus), browseName: QualifiedName{Name: "ServerStatus"},
+		dataType: ns0(idServerStatusDataType), valueRank: -1, read: s.readServerStatus}, idServerStatusType)
+	s.addVariable(ns0(idServerStatus), idHasComponent, &node{id: ns0(idServerStatusStartTime), browseName: QualifiedName{Name: "StartTime"},
+		dataType: ns0(idUtcTime), valueRank: -1, read: constant(s.start)}, idBaseDataVariableType)
+	s.addVariable(ns0(idServerStatus), idHasComponent, &node{id: ns0(idServerStatusCurrentTime), browseName: QualifiedName{Name: "CurrentTime"},
+		dataType: ns0(idUtcTime), valueRank: -1, read: func() DataValue { return DataValue{Value: time.Now()} }}, idBaseDataVariableType)
+	s.addVariable(ns0(idServerStatus), idHasComponent, &node{id: ns0(idServerStatusState), browseName: QualifiedName{Name: "State"},
+		dataType: ns0(idServerState), valueRank: -1, read: constant(int32(0))}, idBaseDataVariableType)
+
+	s.addObject(ns0(idObjectsFolder), idOrganizes, pinsFolder, "Pins", idFolderType)
+	return s
+}
+
+// The folder of the pins' variables.
+var pinsFolder = NodeId{Namespace: 1, Name: "Pins"}
+
+// Expose an input pin, as a read only Boolean variable. The pin must already be set up with PinMode.
+func (s *Server) AddInput(name string) error {
+	return s.addPin(name, ns0(idBoolean), readDigital, nil)
+}
+
+// Expose an output pin, as a Boolean variable clients can write to set the pin. The pin must already be set up with
+// PinMode.
+func (s *Server) AddOutput(name string) error {
+	return s.addPin(name, ns0(idBoolean), readDigital, writeDigital)
+}
+
+// Expose an analog input, as a read only Int32 variable with the value AnalogRead returns.
+func (s *Server) AddAnalog(name string) error {
+	return s.addPin(name, ns0(idInt32), readAnalog, nil)
+}
+
+func (s *Server) addPin(name string, dataType NodeId, read func(hwio.Pin) DataValue, write func(hwio.Pin, interface{}) uint32) error {
+	pin, e := hwio.GetPin(name)
+	if e != nil {
+		return e
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	id := NodeId{Namespace: 1, Name: name}
+	if s.nodes[id] != nil {
+		return fmt.Errorf("pin '%s' has already been added", name)
+	}
+	n := &node{id: id, browseName: QualifiedName{Namespace: 1, Name: name}, dataType: dataType, valueRank: -1}
+	n.read = func() DataValue { return read(pin) }
+	if write != nil {
+		n.write = func(value interface{}) uint32 { return write(pin, value) }
+	}
+	s.addVariable(pinsFolder, idOrganizes, n, idBaseDataVariableType)
+	return nil
+}
+
+func readDigital(pin hwio.Pin) DataValue {
+	value, e := hwio.DigitalRead(pin)
+	if e != nil {
+		return DataValue{Status: statusBadDeviceFailure, Time: time.Now()}
+	}
+	return DataValue{Value: value == hwio.High, Time: time.Now()}
+}
+
+func writeDigital(pin hwio.Pin, value interface{}) uint32 {
+	high, ok := value.(bool)
+	if !ok {
+		return statusBadTypeMismatch
+	}
+	v := hwio.Low
+	if high {
+		v = hwio.High
+	}
+	if hwio.DigitalWrite(pin, v) != nil {
+		return statusBadDeviceFailure
+	}
+	return statusGood
+}
+
+func readAnalog(pin hwio.Pin) DataValue {
+	value, e := hwio.AnalogRead(pin)
+	if e != nil {
+		return DataValue{Status: statusBadDeviceFailure, Time: time.Now()}
+	}
+	return DataValue{Value: int32(value), Time: time.Now()}
+}
+
+// Read the Server object's ServerStatus, a ServerStatusDataType.
+func (s *Server) readServerStatus() DataValue {
+	now := time.Now()
+	body := func(e *encoder) {
+		e.dateTime(s.start)
+		e.dateTime(now)
+		e.int32(0) // running
+
+		// the BuildInfo
+		e.string(productUri)
+		e.string(applicationName)
+		e.string(applicationName)
+		e.string("")
+		e.string("")
+		e.dateTime(time.Time{})
+
+		e.uint32(0)
+		e.localizedText("")
+	}
+	return DataValue{Value: extensionObject{typeId: ns0(idServerStatusDataTypeBinary), body: body}, Time: now}
+}
+
+// Return a read function for a value that doesn't change.
+func constant(value interface{}) func() DataValue {
+	return func() DataValue {
+		return DataValue{Value: value}
+	}
+}
+
+func (s *Server) addNode(n *node) {
+	if n.displayName == "" {
+		n.displayName = n.browseName.Name
+	}
+	s.nodes[n.id] = n
+}
+
+// Add an object as a child of parent, with a reference of the given type, unless parent is the null NodeId.
+func (s *Server) addObject(parent NodeId, referenceType uint32, id NodeId, name string, typeDefinition uint32) {
+	s.addNode(&node{id: id, class: classObject, browseName: QualifiedName{Namespace: id.Namespace, Name: name}})
+	if parent != (NodeId{}) {
+		s.addReference(parent, ns0(referenceType), id)
+	}
+	s.addReference(id, ns0(idHasTypeDefinition), ns0(typeDefinition))
+}
+
+func (s *Server) addVariable(parent NodeId, referenceType uint32, n *node, typeDefinition uint32) {
+	n.class = classVariable
+	s.addNode(n)
+	s.addReference(parent, ns0(referenceType), n.id)
+	s.addReference(n.id, ns0(idHasTypeDefinition), ns0(typeDefinition))
+}
+
+// Add a reference, and its inverse. Both nodes must exist.
+func (s *Server) addReference(from NodeId, referenceType NodeId, to NodeId) {
+	s.nodes[from].references = append(s.nodes[from].references, reference{typeId: referenceType, forward: true, target: to})
+	s.nodes[to].references = append(s.nodes[to].references, reference{typeId: referenceType, forward: false, target: from})
+}
+
+// Return a new id, for a secure channel, a security token or a session.
+func (s *Server) newId() uint32 {
+	s.lastId++
+	return s.lastId
+}
+
+// Serve clients on a listener until it is closed, then close their connections. Returns the error that closed the
+// listener.
+func (s *Server) Serve(l net.Listener) error {
+	var running sync.WaitGroup
+	defer running.Wait()
+	defer s.closeConnections()
+
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			return e
+		}
+		s.Lock()
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]bool)
+		}
+		s.conns[conn] = true
+		s.Unlock()
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			s.serveConnection(conn)
+			conn.Close()
+			s.Lock()
+			delete(s.conns, conn)
+			s.Unlock()
+		}()
+	}
+}
+
+func (s *Server) closeConnections() {
+	s.Lock()
+	defer s.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
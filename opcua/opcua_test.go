@@ -0,0 +1,390 @@
+package opcua
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+// A minimal OPC UA client, enough to call the server's services.
+type testClient struct {
+	t         *testing.T
+	conn      net.Conn
+	r         *bufio.Reader
+	channelId uint32
+	tokenId   uint32
+	sequence  uint32
+	token     NodeId
+}
+
+func (c *testClient) writeChunk(messageType string, body []byte) {
+	var e encoder
+	e.WriteString(messageType)
+	e.uint8('F')
+	e.uint32(uint32(chunkHeaderSize + len(body)))
+	e.Write(body)
+	_, err := c.conn.Write(e.Bytes())
+	if err != nil {
+		c.t.Fatalf("could not send %s: %s", messageType, err)
+	}
+}
+
+func (c *testClient) readChunk() (string, byte, []byte) {
+	header := make([]byte, chunkHeaderSize)
+	_, err := io.ReadFull(c.r, header)
+	if err != nil {
+		c.t.Fatalf("could not read a chunk: %s", err)
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(header[4:])-chunkHeaderSize)
+	_, err = io.ReadFull(c.r, body)
+	if err != nil {
+		c.t.Fatalf("could not read a chunk: %s", err)
+	}
+	return string(header[:3]), header[3], body
+}
+
+func (c *testClient) requestHeader(e *encoder) {
+	e.nodeId(c.token)
+	e.dateTime(time.Now())
+	e.uint32(1)
+	e.uint32(0)
+	e.string("")
+	e.uint32(10000)
+	e.extensionObject(NodeId{}, nil)
+}
+
+func (c *testClient) connect(address string) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		c.t.Fatalf("could not connect: %s", err)
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+
+	var e encoder
+	e.uint32(0)
+	e.uint32(8192)
+	e.uint32(8192)
+	e.uint32(0)
+	e.uint32(0)
+	e.string("opc.tcp://" + address)
+	c.writeChunk("HEL", e.Bytes())
+	if messageType, _, _ := c.readChunk(); messageType != "ACK" {
+		c.t.Fatalf("Hello was answered with %s", messageType)
+	}
+
+	e.Reset()
+	e.uint32(0)
+	e.string(securityPolicyNone)
+	e.byteString(nil)
+	e.byteString(nil)
+	e.uint32(1)
+	e.uint32(1)
+	e.nodeId(ns0(idOpenSecureChannelRequest))
+	c.requestHeader(&e)
+	e.uint32(0)
+	e.int32(0)
+	e.int32(messageSecurityModeNone)
+	e.byteString(nil)
+	e.uint32(600000)
+	c.writeChunk("OPN", e.Bytes())
+
+	messageType, _, body := c.readChunk()
+	d := decoder{b: body}
+	d.uint32()
+	d.string()
+	d.byteString()
+	d.byteString()
+	d.uint32()
+	d.uint32()
+	if messageType != "OPN" || d.nodeId() != ns0(idOpenSecureChannelResponse) {
+		c.t.Fatalf("OpenSecureChannel was answered with %s", messageType)
+	}
+	c.responseHeader(&d)
+	d.uint32()
+	c.channelId = d.uint32()
+	c.tokenId = d.uint32()
+}
+
+// Decode a response header, returning its service result.
+func (c *testClient) responseHeader(d *decoder) uint32 {
+	d.dateTime()
+	d.uint32()
+	status := d.uint32()
+	d.uint8()
+	d.strings()
+	d.extensionObject()
+	return status
+}
+
+// Call a service, returning the encoding id of the response, its service result, and the rest of it.
+func (c *testClient) call(request uint32, body func(e *encoder)) (uint32, uint32, *decoder) {
+	var e encoder
+	e.uint32(c.channelId)
+	e.uint32(c.tokenId)
+	c.sequence++
+	e.uint32(c.sequence)
+	e.uint32(c.sequence)
+	e.nodeId(ns0(request))
+	c.requestHeader(&e)
+	body(&e)
+	c.writeChunk("MSG", e.Bytes())
+
+	var response []byte
+	for {
+		messageType, chunkType, chunk := c.readChunk()
+		if messageType != "MSG" {
+			c.t.Fatalf("request was answered with %s", messageType)
+		}
+		response = append(response, chunk[16:]...)
+		if chunkType == 'F' {
+			break
+		}
+	}
+	d := &decoder{b: response}
+	typeId := d.nodeId()
+	status := c.responseHeader(d)
+	return typeId.ID, status, d
+}
+
+// Read the values of nodes.
+func (c *testClient) read(ids ...NodeId) []DataValue {
+	response, status, d := c.call(idReadRequest, func(e *encoder) {
+		e.double(0)
+		e.int32(2)
+		e.int32(int32(len(ids)))
+		for _, id := range ids {
+			e.nodeId(id)
+			e.uint32(attributeValue)
+			e.string("")
+			e.qualifiedName(QualifiedName{})
+		}
+	})
+	if response != idReadResponse || status != statusGood {
+		c.t.Fatalf("Read returned %d, status %#x", response, status)
+	}
+	values := make([]DataValue, d.arrayLength())
+	for i := range values {
+		values[i] = d.dataValue()
+	}
+	return values
+}
+
+// Write the value of a node, returning the status of the write.
+func (c *testClient) write(id NodeId, value interface{}) uint32 {
+	response, status, d := c.call(idWriteRequest, func(e *encoder) {
+		e.int32(1)
+		e.nodeId(id)
+		e.uint32(attributeValue)
+		e.string("")
+		e.dataValue(DataValue{Value: value})
+	})
+	if response != idWriteResponse || status != statusGood || d.arrayLength() != 1 {
+		c.t.Fatalf("Write returned %d, status %#x", response, status)
+	}
+	return d.uint32()
+}
+
+func TestServer(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	led, _ := hwio.GetPin("gpio1")
+	button, _ := hwio.GetPin("gpio2")
+	hwio.PinMode(led, hwio.Output)
+	hwio.PinMode(button, hwio.Input)
+
+	s := NewServer()
+	for _, e := range []error{s.AddOutput("gpio1"), s.AddInput("gpio2"), s.AddAnalog("ain4")} {
+		if e != nil {
+			t.Fatalf("could not add a pin: %s", e)
+		}
+	}
+	if s.AddInput("gpio2") == nil {
+		t.Error("adding a pin twice should have returned an error")
+	}
+	if s.AddInput("nosuchpin") == nil {
+		t.Error("adding an unknown pin should have returned an error")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+
+	c := &testClient{t: t}
+	c.connect(l.Addr().String())
+
+	// the pins can't be read without a session
+	response, status, _ := c.call(idReadRequest, func(e *encoder) {})
+	if response != idServiceFault || status != statusBadSessionIdInvalid {
+		t.Errorf("Read without a session returned %d, status %#x", response, status)
+	}
+
+	response, status, d := c.call(idGetEndpointsRequest, func(e *encoder) {
+		e.string("opc.tcp://" + l.Addr().String())
+		e.int32(0)
+		e.int32(0)
+	})
+	if response != idGetEndpointsResponse || status != statusGood || d.arrayLength() != 1 {
+		t.Fatalf("GetEndpoints returned %d, status %#x", response, status)
+	}
+	if url := d.string(); url != "opc.tcp://"+l.Addr().String() {
+		t.Errorf("endpoint URL is %s, expected the URL connected to", url)
+	}
+
+	response, status, d = c.call(idCreateSessionRequest, func(e *encoder) {
+		e.string("urn:test")
+		e.string("")
+		e.localizedText("test")
+		e.int32(1)
+		e.string("")
+		e.string("")
+		e.int32(0)
+		e.string("")
+		e.string("opc.tcp://" + l.Addr().String())
+		e.string("test")
+		e.byteString(nil)
+		e.byteString(nil)
+		e.double(60000)
+		e.uint32(0)
+	})
+	if response != idCreateSessionResponse || status != statusGood {
+		t.Fatalf("CreateSession returned %d, status %#x", response, status)
+	}
+	d.nodeId()
+	c.token = d.nodeId()
+
+	activate := func(identity NodeId) uint32 {
+		_, status, _ := c.call(idActivateSessionRequest, func(e *encoder) {
+			e.string("")
+			e.byteString(nil)
+			e.int32(0)
+			e.int32(0)
+			e.extensionObject(identity, func(e *encoder) { e.string("anonymous") })
+			e.string("")
+			e.byteString(nil)
+		})
+		return status
+	}
+	if status := activate(ns0(324)); status != statusBadIdentityTokenRejected {
+		t.Errorf("activating a session with a user name returned status %#x", status)
+	}
+	if status := activate(ns0(idAnonymousIdentityToken)); status != statusGood {
+		t.Fatalf("activating an anonymous session returned status %#x", status)
+	}
+
+	// the pins are in the Pins folder, in the order they were added
+	response, status, d = c.call(idBrowseRequest, func(e *encoder) {
+		e.nodeId(NodeId{})
+		e.dateTime(time.Time{})
+		e.uint32(0)
+		e.uint32(0)
+		e.int32(1)
+		e.nodeId(pinsFolder)
+		e.int32(browseForward)
+		e.nodeId(ns0(idHierarchicalReferences))
+		e.bool(true)
+		e.uint32(0)
+		e.uint32(0x3f)
+	})
+	if response != idBrowseResponse || status != statusGood || d.arrayLength() != 1 {
+		t.Fatalf("Browse returned %d, status %#x", response, status)
+	}
+	if status := d.uint32(); status != statusGood {
+		t.Fatalf("browsing the Pins folder returned status %#x", status)
+	}
+	d.byteString()
+	var names []string
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		d.nodeId()
+		d.bool()
+		d.expandedNodeId()
+		names = append(names, d.qualifiedName().Name)
+		d.localizedText()
+		d.int32()
+		d.expandedNodeId()
+	}
+	if d.e != nil || len(names) != 3 || names[0] != "gpio1" || names[1] != "gpio2" || names[2] != "ain4" {
+		t.Errorf("unexpected pins %v, %v", names, d.e)
+	}
+
+	// outputs can be written, with Booleans only; inputs can't
+	gpio1 := NodeId{Namespace: 1, Name: "gpio1"}
+	gpio2 := NodeId{Namespace: 1, Name: "gpio2"}
+	if status := c.write(gpio1, true); status != statusGood {
+		t.Errorf("writing the output returned status %#x", status)
+	}
+	if v, _ := hwio.DigitalRead(led); v != hwio.High {
+		t.Errorf("led is %d, expected High", v)
+	}
+	if status := c.write(gpio1, int32(0)); status != statusBadTypeMismatch {
+		t.Errorf("writing an Int32 to the output returned status %#x", status)
+	}
+	if status := c.write(gpio2, true); status != statusBadNotWritable {
+		t.Errorf("writing the input returned status %#x", status)
+	}
+
+	values := c.read(gpio1, gpio2, NodeId{Namespace: 1, Name: "ain4"}, ns0(idNamespaceArray), NodeId{Namespace: 1, Name: "nosuchpin"})
+	if values[0].Value != true || values[1].Value != false || values[2].Value != int32(1) {
+		t.Errorf("unexpected values of the pins %+v", values[:3])
+	}
+	if namespaces, ok := values[3].Value.([]interface{}); !ok || len(namespaces) != 2 || namespaces[1] != namespaceUri {
+		t.Errorf("unexpected namespace array %+v", values[3])
+	}
+	if values[4].Status != statusBadNodeIdUnknown {
+		t.Errorf("reading an unknown node returned %+v", values[4])
+	}
+
+	// closing the listener closes the connections
+	l.Close()
+	if e := <-served; e == nil {
+		t.Error("Serve should have returned the listener's error")
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, e := c.r.ReadByte(); e == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestChunks(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := &channel{conn: server, sendBufferSize: 8192}
+	body := make([]byte, 20000)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	go func() {
+		c.send("MSG", []byte{1, 0, 0, 0, 2, 0, 0, 0}, 7, body)
+		server.Close()
+	}()
+
+	tc := &testClient{t: t, r: bufio.NewReader(client)}
+	var received []byte
+	for chunks := 1; ; chunks++ {
+		_, chunkType, chunk := tc.readChunk()
+		if len(chunk)+chunkHeaderSize > 8192 {
+			t.Fatalf("chunk %d is %d bytes, larger than the send buffer", chunks, len(chunk)+chunkHeaderSize)
+		}
+		if sequence := binary.LittleEndian.Uint32(chunk[8:]); sequence != uint32(chunks) {
+			t.Errorf("chunk %d has sequence number %d", chunks, sequence)
+		}
+		received = append(received, chunk[16:]...)
+		if chunkType == 'F' {
+			if chunks != 3 {
+				t.Errorf("expected 3 chunks, got %d", chunks)
+			}
+			break
+		}
+	}
+	if string(received) != string(body) {
+		t.Error("the chunks don't add up to the message")
+	}
+}
@@ -0,0 +1,596 @@
+package opcua
+
+// The services of the server (Part 4, section 5), decoded from and encoded to the binary encoding. Each request is
+// handled with the server locked, so requests from all clients are handled one at a time.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Status codes.
+const (
+	statusGood                      = 0
+	statusBadInternalError          = 0x80020000
+	statusBadDecodingError          = 0x80070000
+	statusBadServiceUnsupported     = 0x800b0000
+	statusBadNothingToDo            = 0x800f0000
+	statusBadTooManyOperations      = 0x80100000
+	statusBadIdentityTokenRejected  = 0x80210000
+	statusBadSecureChannelIdInvalid = 0x80220000
+	statusBadSessionIdInvalid       = 0x80250000
+	statusBadSessionNotActivated    = 0x80270000
+	statusBadNodeIdUnknown          = 0x80340000
+	statusBadAttributeIdInvalid     = 0x80350000
+	statusBadIndexRangeInvalid      = 0x80360000
+	statusBadDataEncodingInvalid    = 0x80380000
+	statusBadNotWritable            = 0x803b0000
+	statusBadReferenceTypeIdInvalid = 0x804c0000
+	statusBadBrowseDirectionInvalid = 0x804d0000
+	statusBadSecurityModeRejected   = 0x80540000
+	statusBadSecurityPolicyRejected = 0x80550000
+	statusBadTooManySessions        = 0x80560000
+	statusBadViewIdUnknown          = 0x806b0000
+	statusBadTypeMismatch           = 0x80740000
+	statusBadTcpMessageTypeInvalid  = 0x807e0000
+	statusBadTcpMessageTooLarge     = 0x80800000
+	statusBadTcpInternalError       = 0x80820000
+	statusBadDeviceFailure          = 0x808b0000
+)
+
+// Attributes of nodes.
+const (
+	attributeNodeId                  = 1
+	attributeNodeClass               = 2
+	attributeBrowseName              = 3
+	attributeDisplayName             = 4
+	attributeDescription             = 5
+	attributeWriteMask               = 6
+	attributeUserWriteMask           = 7
+	attributeEventNotifier           = 12
+	attributeValue                   = 13
+	attributeDataType                = 14
+	attributeValueRank               = 15
+	attributeAccessLevel             = 17
+	attributeUserAccessLevel         = 18
+	attributeMinimumSamplingInterval = 19
+	attributeHistorizing             = 20
+)
+
+// The most sessions open at once, and the most operations in one request.
+const (
+	maxSessions   = 32
+	maxOperations = 1000
+)
+
+// The encoding ids of the services' requests and responses.
+const (
+	idServiceFault            = 397
+	idAnonymousIdentityToken  = 321
+	idFindServersRequest      = 422
+	idFindServersResponse     = 425
+	idGetEndpointsRequest     = 428
+	idGetEndpointsResponse    = 431
+	idCreateSessionRequest    = 461
+	idCreateSessionResponse   = 464
+	idActivateSessionRequest  = 467
+	idActivateSessionResponse = 470
+	idCloseSessionRequest     = 473
+	idCloseSessionResponse    = 476
+	idBrowseRequest           = 527
+	idBrowseResponse          = 530
+	idReadRequest             = 631
+	idReadResponse            = 634
+	idWriteRequest            = 673
+	idWriteResponse           = 676
+)
+
+// A service: the encoding id of its response, whether it needs an activated session, and its handler, which decodes
+// the rest of the request and encodes the rest of the response, returning the service result.
+type service struct {
+	response uint32
+	session  bool
+	handle   func(s *Server, c *channel, h *requestHeader, d *decoder, e *encoder) uint32
+}
+
+var services = map[uint32]service{
+	idFindServersRequest:     {idFindServersResponse, false, (*Server).findServers},
+	idGetEndpointsRequest:    {idGetEndpointsResponse, false, (*Server).getEndpoints},
+	idCreateSessionRequest:   {idCreateSessionResponse, false, (*Server).createSession},
+	idActivateSessionRequest: {idActivateSessionResponse, false, (*Server).activateSession},
+	idCloseSessionRequest:    {idCloseSessionResponse, true, (*Server).closeSession},
+	idBrowseRequest:          {idBrowseResponse, true, (*Server).browse},
+	idReadRequest:            {idReadResponse, true, (*Server).read},
+	idWriteRequest:           {idWriteResponse, true, (*Server).write},
+}
+
+type requestHeader struct {
+	authenticationToken NodeId
+	handle              uint32
+}
+
+func decodeRequestHeader(d *decoder) *requestHeader {
+	h := &requestHeader{authenticationToken: d.nodeId()}
+	d.dateTime()
+	h.handle = d.uint32()
+	d.uint32()          // return diagnostics
+	d.string()          // audit entry id
+	d.uint32()          // timeout hint
+	d.extensionObject() // additional header
+	return h
+}
+
+func encodeResponseHeader(e *encoder, handle uint32, status uint32) {
+	e.dateTime(time.Now())
+	e.uint32(handle)
+	e.uint32(status)
+	e.uint8(0) // no diagnostics
+	e.int32(0) // string table
+	e.extensionObject(NodeId{}, nil)
+}
+
+// A session, known to clients by its authentication token.
+type session struct {
+	id        NodeId
+	channel   *channel
+	activated bool
+}
+
+// Handle a request, returning the response or a ServiceFault.
+func (s *Server) handle(c *channel, request []byte) []byte {
+	s.Lock()
+	defer s.Unlock()
+
+	d := &decoder{b: request}
+	typeId := d.nodeId()
+	h := decodeRequestHeader(d)
+	if d.e != nil {
+		return serviceFault(0, statusBadDecodingError)
+	}
+	handler, ok := services[typeId.ID]
+	if !ok || typeId.Namespace != 0 || typeId.Name != "" {
+		return serviceFault(h.handle, statusBadServiceUnsupported)
+	}
+	if handler.session {
+		current := s.sessions[h.authenticationToken]
+		if current == nil {
+			return serviceFault(h.handle, statusBadSessionIdInvalid)
+		}
+		if !current.activated || current.channel != c {
+			return serviceFault(h.handle, statusBadSessionNotActivated)
+		}
+	}
+
+	var body encoder
+	status := handler.handle(s, c, h, d, &body)
+	if status == statusGood && d.e != nil {
+		status = statusBadDecodingError
+	}
+	if status != statusGood {
+		return serviceFault(h.handle, status)
+	}
+	var e encoder
+	e.nodeId(ns0(handler.response))
+	encodeResponseHeader(&e, h.handle, statusGood)
+	e.Write(body.Bytes())
+	return e.Bytes()
+}
+
+func serviceFault(handle uint32, status uint32) []byte {
+	var e encoder
+	e.nodeId(ns0(idServiceFault))
+	encodeResponseHeader(&e, handle, status)
+	return e.Bytes()
+}
+
+// Close the sessions of a secure channel, when its connection ends.
+func (s *Server) closeSessions(c *channel) {
+	s.Lock()
+	defer s.Unlock()
+	for token, session := range s.sessions {
+		if session.channel == c {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// Encode the server's ApplicationDescription.
+func (c *channel) encodeApplication(e *encoder) {
+	e.string(applicationUri)
+	e.string(productUri)
+	e.localizedText(applicationName)
+	e.int32(0) // server
+	e.string("")
+	e.string("")
+	e.strings([]string{c.endpointUrl})
+}
+
+// Encode the server's only EndpointDescription, at the URL the client connected to.
+func (c *channel) encodeEndpoint(e *encoder) {
+	e.string(c.endpointUrl)
+	c.encodeApplication(e)
+	e.byteString(nil)
+	e.int32(messageSecurityModeNone)
+	e.string(securityPolicyNone)
+
+	// an anonymous UserTokenPolicy
+	e.int32(1)
+	e.string("anonymous")
+	e.int32(0)
+	e.string("")
+	e.string("")
+	e.string("")
+
+	e.string(transportProfile)
+	e.uint8(0)
+}
+
+func (s *Server) findServers(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	d.string()  // endpoint URL
+	d.strings() // locale ids
+	d.strings() // server URIs
+	e.int32(1)
+	c.encodeApplication(e)
+	return statusGood
+}
+
+func (s *Server) getEndpoints(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	d.string()  // endpoint URL
+	d.strings() // locale ids
+	profiles := d.strings()
+	matches := len(profiles) == 0
+	for _, p := range profiles {
+		matches = matches || p == transportProfile
+	}
+	if !matches {
+		e.int32(0)
+		return statusGood
+	}
+	e.int32(1)
+	c.encodeEndpoint(e)
+	return statusGood
+}
+
+func (s *Server) createSession(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	// the client's ApplicationDescription
+	d.string()
+	d.string()
+	d.localizedText()
+	d.int32()
+	d.string()
+	d.string()
+	d.strings()
+
+	d.string()     // server URI
+	d.string()     // endpoint URL
+	d.string()     // session name
+	d.byteString() // client nonce
+	d.byteString() // client certificate
+	timeout := d.double()
+	d.uint32() // max response message size
+	if d.e != nil {
+		return statusBadDecodingError
+	}
+	if len(s.sessions) >= maxSessions {
+		return statusBadTooManySessions
+	}
+
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return statusBadInternalError
+	}
+	created := &session{id: NodeId{Namespace: 1, ID: s.newId()}, channel: c}
+	authenticationToken := NodeId{Namespace: 1, Name: "session:" + hex.EncodeToString(token)}
+	s.sessions[authenticationToken] = created
+
+	if timeout < 10000 {
+		timeout = 10000
+	}
+	if timeout > 3600000 {
+		timeout = 3600000
+	}
+	e.nodeId(created.id)
+	e.nodeId(authenticationToken)
+	e.double(timeout)
+	e.byteString(nonce())
+	e.byteString(nil) // server certificate
+	e.int32(1)
+	c.encodeEndpoint(e)
+	e.int32(0)        // software certificates
+	e.string("")      // signature algorithm
+	e.byteString(nil) // signature
+	e.uint32(0)       // no limit on request size
+	return statusGood
+}
+
+// Return a new server nonce.
+func nonce() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+func (s *Server) activateSession(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	// the client signature
+	d.string()
+	d.byteString()
+
+	// software certificates
+	n := d.arrayLength()
+	for i := 0; i < n && d.e == nil; i++ {
+		d.byteString()
+		d.byteString()
+	}
+
+	d.strings() // locale ids
+	identity, _ := d.extensionObject()
+
+	// the user token signature
+	d.string()
+	d.byteString()
+	if d.e != nil {
+		return statusBadDecodingError
+	}
+
+	activated := s.sessions[h.authenticationToken]
+	if activated == nil {
+		return statusBadSessionIdInvalid
+	}
+	if identity != (NodeId{}) && identity != ns0(idAnonymousIdentityToken) {
+		return statusBadIdentityTokenRejected
+	}
+	activated.activated = true
+	activated.channel = c
+
+	e.byteString(nonce())
+	e.int32(0) // results
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+func (s *Server) closeSession(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	d.bool() // delete subscriptions
+	delete(s.sessions, h.authenticationToken)
+	return statusGood
+}
+
+// Browse directions.
+const (
+	browseForward = 0
+	browseInverse = 1
+	browseBoth    = 2
+)
+
+// The fields of a ReferenceDescription, for a BrowseDescription's result mask.
+const (
+	resultReferenceType  = 0x01
+	resultIsForward      = 0x02
+	resultNodeClass      = 0x04
+	resultBrowseName     = 0x08
+	resultDisplayName    = 0x10
+	resultTypeDefinition = 0x20
+)
+
+func (s *Server) browse(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	view := d.nodeId()
+	d.dateTime()
+	d.uint32() // view version
+	d.uint32() // max references per node
+	if view != (NodeId{}) {
+		return statusBadViewIdUnknown
+	}
+
+	n := d.arrayLength()
+	if d.e == nil && n == 0 {
+		return statusBadNothingToDo
+	}
+	if n > maxOperations {
+		return statusBadTooManyOperations
+	}
+	e.int32(int32(n))
+	for i := 0; i < n && d.e == nil; i++ {
+		id := d.nodeId()
+		direction := d.int32()
+		referenceType := d.nodeId()
+		includeSubtypes := d.bool()
+		classMask := d.uint32()
+		resultMask := d.uint32()
+
+		node := s.nodes[id]
+		status := uint32(statusGood)
+		switch {
+		case node == nil:
+			status = statusBadNodeIdUnknown
+		case direction < browseForward || direction > browseBoth:
+			status = statusBadBrowseDirectionInvalid
+		case referenceType != (NodeId{}) && (referenceType.Namespace != 0 || referenceType.Name != "" ||
+			(referenceType.ID != idReferences && referenceSupertypes[referenceType.ID] == 0)):
+			status = statusBadReferenceTypeIdInvalid
+		}
+		e.uint32(status)
+		e.byteString(nil) // continuation point
+		if status != statusGood {
+			e.int32(0)
+			continue
+		}
+
+		var matches []reference
+		for _, r := range node.references {
+			if (r.forward && direction == browseInverse) || (!r.forward && direction == browseForward) {
+				continue
+			}
+			if referenceType != (NodeId{}) && !isReferenceType(r.typeId.ID, referenceType.ID, includeSubtypes) {
+				continue
+			}
+			if classMask != 0 && uint32(s.nodes[r.target].class)&classMask == 0 {
+				continue
+			}
+			matches = append(matches, r)
+		}
+		e.int32(int32(len(matches)))
+		for _, r := range matches {
+			s.encodeReference(e, r, resultMask)
+		}
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+// Return whether a reference type is the type wanted, or a subtype of it if subtypes are included.
+func isReferenceType(referenceType uint32, wanted uint32, includeSubtypes bool) bool {
+	for referenceType != 0 {
+		if referenceType == wanted {
+			return true
+		}
+		if !includeSubtypes {
+			return false
+		}
+		referenceType = referenceSupertypes[referenceType]
+	}
+	return false
+}
+
+// Encode a ReferenceDescription, with the fields of the result mask.
+func (s *Server) encodeReference(e *encoder, r reference, resultMask uint32) {
+	target := s.nodes[r.target]
+	if resultMask&resultReferenceType != 0 {
+		e.nodeId(r.typeId)
+	} else {
+		e.nodeId(NodeId{})
+	}
+	e.bool(r.forward && resultMask&resultIsForward != 0)
+	e.nodeId(r.target)
+	if resultMask&resultBrowseName != 0 {
+		e.qualifiedName(target.browseName)
+	} else {
+		e.qualifiedName(QualifiedName{})
+	}
+	if resultMask&resultDisplayName != 0 {
+		e.localizedText(target.displayName)
+	} else {
+		e.localizedText("")
+	}
+	if resultMask&resultNodeClass != 0 {
+		e.int32(target.class)
+	} else {
+		e.int32(0)
+	}
+	if resultMask&resultTypeDefinition != 0 {
+		e.nodeId(target.typeDefinition())
+	} else {
+		e.nodeId(NodeId{})
+	}
+}
+
+func (s *Server) read(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	d.double() // max age
+	d.int32()  // timestamps to return
+	n := d.arrayLength()
+	if d.e == nil && n == 0 {
+		return statusBadNothingToDo
+	}
+	if n > maxOperations {
+		return statusBadTooManyOperations
+	}
+	e.int32(int32(n))
+	for i := 0; i < n && d.e == nil; i++ {
+		id := d.nodeId()
+		attribute := d.uint32()
+		indexRange := d.string()
+		encoding := d.qualifiedName()
+
+		node := s.nodes[id]
+		switch {
+		case node == nil:
+			e.dataValue(DataValue{Status: statusBadNodeIdUnknown})
+		case indexRange != "":
+			e.dataValue(DataValue{Status: statusBadIndexRangeInvalid})
+		case encoding != (QualifiedName{}):
+			e.dataValue(DataValue{Status: statusBadDataEncodingInvalid})
+		default:
+			e.dataValue(node.attribute(attribute))
+		}
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+// Read an attribute of a node.
+func (n *node) attribute(attribute uint32) DataValue {
+	switch attribute {
+	case attributeNodeId:
+		return DataValue{Value: n.id}
+	case attributeNodeClass:
+		return DataValue{Value: n.class}
+	case attributeBrowseName:
+		return DataValue{Value: n.browseName}
+	case attributeDisplayName:
+		return DataValue{Value: localizedText(n.displayName)}
+	case attributeDescription:
+		return DataValue{Value: localizedText("")}
+	case attributeWriteMask, attributeUserWriteMask:
+		return DataValue{Value: uint32(0)}
+	}
+
+	if n.class == classObject && attribute == attributeEventNotifier {
+		return DataValue{Value: byte(0)}
+	}
+	if n.class != classVariable {
+		return DataValue{Status: statusBadAttributeIdInvalid}
+	}
+	switch attribute {
+	case attributeValue:
+		return n.read()
+	case attributeDataType:
+		return DataValue{Value: n.dataType}
+	case attributeValueRank:
+		return DataValue{Value: n.valueRank}
+	case attributeAccessLevel, attributeUserAccessLevel:
+		access := byte(accessRead)
+		if n.write != nil {
+			access |= accessWrite
+		}
+		return DataValue{Value: access}
+	case attributeMinimumSamplingInterval:
+		return DataValue{Value: float64(0)}
+	case attributeHistorizing:
+		return DataValue{Value: false}
+	}
+	return DataValue{Status: statusBadAttributeIdInvalid}
+}
+
+func (s *Server) write(c *channel, h *requestHeader, d *decoder, e *encoder) uint32 {
+	n := d.arrayLength()
+	if d.e == nil && n == 0 {
+		return statusBadNothingToDo
+	}
+	if n > maxOperations {
+		return statusBadTooManyOperations
+	}
+	e.int32(int32(n))
+	for i := 0; i < n && d.e == nil; i++ {
+		id := d.nodeId()
+		attribute := d.uint32()
+		indexRange := d.string()
+		value := d.dataValue()
+		if d.e != nil {
+			break
+		}
+
+		node := s.nodes[id]
+		switch {
+		case node == nil:
+			e.uint32(statusBadNodeIdUnknown)
+		case attribute != attributeValue || node.write == nil:
+			e.uint32(statusBadNotWritable)
+		case indexRange != "":
+			e.uint32(statusBadIndexRangeInvalid)
+		default:
+			e.uint32(node.write(value.Value))
+		}
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
@@ -0,0 +1,191 @@
+package hwio
+
+// Limits on how fast outputs can change, to protect relays, motors and power stages from an application that
+// misbehaves, e.g. one stuck in a loop toggling a relay, or stepping a motor from stopped to full duty. They are set
+// with pin options, either with PinMode, or with LimitOutput for pins that aren't set up by PinMode, such as hardware
+// PWM pins:
+//
+//	hwio.PinMode(relay, hwio.Output, hwio.WithMaxToggleRate(2))
+//	hwio.LimitOutput(motor, hwio.WithDutySlew(0.5))
+//
+// A toggle rate limit is enforced by DigitalWrite, which returns ErrRateLimited for a change that comes too soon
+// after the last, leaving the pin as it was. Writing the level the pin already has is always allowed. A duty slew
+// limit is enforced by AnalogWrite, which ramps the duty towards each value from a goroutine, rather than setting it
+// straight away. The limits stay with the pin until ClosePin.
+//
+// Known issues:
+// - limits are only enforced by DigitalWrite and AnalogWrite, not by writes to a module directly, or by group writes
+// - a toggle rate limit on a pin driven by software PWM stops the PWM from running at its frequency
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned by DigitalWrite for a change that would exceed the pin's toggle rate.
+var ErrRateLimited = errors.New("output change rate limited")
+
+// How often a duty ramp steps towards its target.
+var dutySlewInterval = 10 * time.Millisecond
+
+// The limits of an output, and what it was last set to.
+type outputLimit struct {
+	maxToggleRate float64
+	maxDutySlew   float64
+
+	// guards last, lastChange and written
+	sync.Mutex
+	last       int
+	lastChange time.Time
+	written    bool
+}
+
+// Limit the number of times a second an output can change level. DigitalWrite returns ErrRateLimited for changes
+// that come faster than this.
+func WithMaxToggleRate(perSecond float64) PinOption {
+	return func(config *PinConfig) {
+		config.MaxToggleRate = perSecond
+	}
+}
+
+// Limit how fast AnalogWrite changes the duty of a pin, as a fraction of full scale per second, so 0.5 takes two
+// seconds to go from 0 to 255.
+func WithDutySlew(fractionPerSecond float64) PinOption {
+	return func(config *PinConfig) {
+		config.MaxDutySlew = fractionPerSecond
+	}
+}
+
+// Set rate limits on a pin without changing its mode. Only the limiting options, WithMaxToggleRate and
+// WithDutySlew, are used.
+func LimitOutput(pin Pin, options ...PinOption) {
+	defaultBoard.LimitOutput(pin, options...)
+}
+
+// Set rate limits on a pin of the board, as LimitOutput does for the default board.
+func (b *Board) LimitOutput(pin Pin, options ...PinOption) {
+	b.setOutputLimit(pin, NewPinConfig(Output, options...))
+}
+
+// Take the limits out of a config, remembering them for the pin if there are any, and return the config without
+// them for the GPIO module.
+func (b *Board) takeOutputLimit(pin Pin, config PinConfig) PinConfig {
+	if config.MaxToggleRate > 0 || config.MaxDutySlew > 0 {
+		b.setOutputLimit(pin, config)
+	}
+	config.MaxToggleRate = 0
+	config.MaxDutySlew = 0
+	return config
+}
+
+func (b *Board) setOutputLimit(pin Pin, config PinConfig) {
+	if b.limits == nil {
+		b.limits = make(map[Pin]*outputLimit)
+	}
+	if config.MaxToggleRate <= 0 && config.MaxDutySlew <= 0 {
+		delete(b.limits, pin)
+		return
+	}
+	b.limits[pin] = &outputLimit{maxToggleRate: config.MaxToggleRate, maxDutySlew: config.MaxDutySlew}
+}
+
+// Return the duty slew limit of a pin of the default board, or 0 if there isn't one.
+func dutySlewLimit(pin Pin) float64 {
+	if l := defaultBoard.limits[pin]; l != nil {
+		return l.maxDutySlew
+	}
+	return 0
+}
+
+// Write a value through a toggle rate limit, if there is one.
+func (l *outputLimit) write(value int, write func(int) error) error {
+	if l == nil || l.maxToggleRate <= 0 {
+		return write(value)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	now := clock.Now()
+	if l.written && value != l.last {
+		if now.Sub(l.lastChange) < time.Duration(float64(time.Second)/l.maxToggleRate) {
+			return ErrRateLimited
+		}
+	}
+	e := write(value)
+	if e != nil {
+		return e
+	}
+	if !l.written || value != l.last {
+		l.lastChange = now
+	}
+	l.last = value
+	l.written = true
+	return nil
+}
+
+// Ramps the AnalogWrite value of a pin towards a target, run by a goroutine.
+type dutySlew struct {
+	perStep float64
+	clock   Clock
+	targets chan int
+	done    chan bool
+}
+
+// Start ramping from 0, calling set with each value on the way to a target.
+func newDutySlew(fractionPerSecond float64, set func(int)) *dutySlew {
+	d := &dutySlew{
+		perStep: fractionPerSecond * 255 * dutySlewInterval.Seconds(),
+		clock:   clock,
+		targets: make(chan int, 1),
+		done:    make(chan bool),
+	}
+	go d.run(set)
+	return d
+}
+
+// Set the target. A target that hasn't been picked up yet is replaced.
+func (d *dutySlew) set(target int) {
+	select {
+	case <-d.targets:
+	default:
+	}
+	d.targets <- target
+}
+
+// Stop the goroutine, leaving the value where it has got to, and wait for it to finish.
+func (d *dutySlew) stop() {
+	close(d.targets)
+	<-d.done
+}
+
+func (d *dutySlew) run(set func(int)) {
+	defer close(d.done)
+
+	ticker := d.clock.NewTicker(dutySlewInterval)
+	defer ticker.Stop()
+	value, target := 0.0, 0.0
+	for {
+		if value == target {
+			t, ok := <-d.targets
+			if !ok {
+				return
+			}
+			target = float64(t)
+			continue
+		}
+
+		select {
+		case t, ok := <-d.targets:
+			if !ok {
+				return
+			}
+			target = float64(t)
+			continue
+		case <-ticker.C():
+		}
+
+		value += clampFloat(target-value, -d.perStep, d.perStep)
+		set(int(value + 0.5))
+	}
+}
@@ -0,0 +1,192 @@
+// A helper for parallel buses: a set of data pins plus a strobe (enable) line, and optionally a read/write line.
+// This is the interface of HD44780 displays in 8-bit mode, Centronics printers, and many older peripherals.
+
+package hwio
+
+import (
+	"errors"
+	"time"
+)
+
+type ParallelBus struct {
+	// data pins, most significant bit first
+	dataPins PinList
+
+	strobe       Pin
+	strobeActive int
+
+	// optional read/write select line
+	readWrite    Pin
+	hasReadWrite bool
+	readLevel    int
+
+	// setup is the time data (and read/write) must be stable before the strobe, pulse the width of the strobe,
+	// and hold the time after the strobe before anything else changes.
+	setup time.Duration
+	pulse time.Duration
+	hold  time.Duration
+
+	// current direction of the data pins. -1 until set.
+	dataMode PinIOMode
+}
+
+// Create a new parallel bus from up to 16 data pins, most significant bit first, and a strobe pin. The strobe is
+// active high by default, and timing defaults to 1us setup, pulse and hold, which suits most slow peripherals.
+// The data pins are set up when the bus is first read or written.
+func NewParallelBus(dataPins PinList, strobe Pin) (*ParallelBus, error) {
+	if len(dataPins) == 0 || len(dataPins) > 16 {
+		return nil, errors.New("a parallel bus needs between 1 and 16 data pins")
+	}
+
+	result := &ParallelBus{
+		dataPins:     dataPins,
+		strobe:       strobe,
+		strobeActive: High,
+		setup:        time.Microsecond,
+		pulse:        time.Microsecond,
+		hold:         time.Microsecond,
+		dataMode:     -1,
+	}
+
+	e := PinModeOutputInit(strobe, Low)
+	if e != nil {
+		return nil, e
+	}
+	return result, nil
+}
+
+// Set the level of the strobe line that latches data, e.g. Low for a Centronics /STROBE.
+func (bus *ParallelBus) SetStrobeActive(level int) error {
+	bus.strobeActive = level
+	return DigitalWrite(bus.strobe, Negate(level))
+}
+
+// Add a read/write select line. readLevel is the level that selects a read, e.g. High for the HD44780 R/W pin.
+func (bus *ParallelBus) SetReadWritePin(pin Pin, readLevel int) error {
+	bus.readWrite = pin
+	bus.hasReadWrite = true
+	bus.readLevel = readLevel
+	return PinModeOutputInit(pin, Negate(readLevel))
+}
+
+// Set the bus timing. Durations under 100us are busy-waited, as sleeping is not that precise.
+func (bus *ParallelBus) SetTiming(setup time.Duration, pulse time.Duration, hold time.Duration) {
+	bus.setup = setup
+	bus.pulse = pulse
+	bus.hold = hold
+}
+
+// Write a value to the bus, and strobe it into the peripheral. Only the lowest n bits are written, where n is the
+// number of data pins.
+func (bus *ParallelBus) Write(value uint16) error {
+	e := bus.setDataMode(Output)
+	if e != nil {
+		return e
+	}
+
+	if bus.hasReadWrite {
+		e = DigitalWrite(bus.readWrite, Negate(bus.readLevel))
+		if e != nil {
+			return e
+		}
+	}
+
+	e = DigitalWriteGroup(bus.dataPins, uint32(value))
+	if e != nil {
+		return e
+	}
+
+	return bus.strobeCycle(nil)
+}
+
+// Strobe the peripheral and read a value from the bus. The data is sampled after the setup time, while the strobe
+// is still active.
+func (bus *ParallelBus) Read() (uint16, error) {
+	if !bus.hasReadWrite {
+		return 0, errors.New("parallel bus cannot be read without a read/write pin, call SetReadWritePin")
+	}
+
+	e := bus.setDataMode(Input)
+	if e != nil {
+		return 0, e
+	}
+
+	e = DigitalWrite(bus.readWrite, bus.readLevel)
+	if e != nil {
+		return 0, e
+	}
+
+	value := uint32(0)
+	e = bus.strobeCycle(func() error {
+		v, e := DigitalReadGroup(bus.dataPins)
+		value = v
+		return e
+	})
+	return uint16(value), e
+}
+
+// Write a byte to the bus.
+func (bus *ParallelBus) WriteByte(value byte) error {
+	return bus.Write(uint16(value))
+}
+
+// Read a byte from the bus.
+func (bus *ParallelBus) ReadByte() (byte, error) {
+	v, e := bus.Read()
+	return byte(v), e
+}
+
+// Pulse the strobe with the configured timing. If sample is given, it is called while the strobe is active,
+// once the setup time has passed.
+func (bus *ParallelBus) strobeCycle(sample func() error) error {
+	delayFor(bus.setup)
+
+	e := DigitalWrite(bus.strobe, bus.strobeActive)
+	if e != nil {
+		return e
+	}
+
+	if sample != nil {
+		delayFor(bus.setup)
+		e = sample()
+	}
+	delayFor(bus.pulse)
+
+	e2 := DigitalWrite(bus.strobe, Negate(bus.strobeActive))
+	delayFor(bus.hold)
+
+	if e != nil {
+		return e
+	}
+	return e2
+}
+
+// Change the direction of the data pins if necessary.
+func (bus *ParallelBus) setDataMode(mode PinIOMode) error {
+	if bus.dataMode == mode {
+		return nil
+	}
+
+	e := PinModeGroup(bus.dataPins, mode)
+	if e != nil {
+		return e
+	}
+	bus.dataMode = mode
+	return nil
+}
+
+// Delay for a duration. Short delays are busy-waited, since time.Sleep typically sleeps for tens of microseconds
+// at least.
+func delayFor(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if d >= 100*time.Microsecond {
+		time.Sleep(d)
+		return
+	}
+
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+	}
+}
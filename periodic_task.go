@@ -0,0 +1,233 @@
+package hwio
+
+// Running a task at a fixed rate with little drift or jitter, e.g. a control loop or sampling. A time.Ticker is
+// served by the Go scheduler, so a tick can be late by a GC pause or a busy scheduler, and ticks that are missed are
+// silently dropped. PeriodicTask instead waits on a timerfd, a kernel timer, from a goroutine locked to its thread:
+// the schedule is kept by the kernel on the monotonic clock, so it never drifts, each wake up is as prompt as the
+// thread's priority allows, and the kernel counts the periods that were missed, which are reported as overruns.
+//
+//	task, e := hwio.NewPeriodicTask(hwio.PeriodicTaskConfig{
+//		Period: time.Millisecond,
+//		CPUs:   []int{3},
+//		OnOverrun: func(missed int) {
+//			log.Printf("control loop missed %d periods", missed)
+//		},
+//	}, func() {
+//		...
+//	})
+//	...
+//	stats := task.Stats()  // e.g. the worst latency
+//	task.Stop()
+//
+// Known issues:
+// - the timer is the kernel's, so the task isn't driven by a FakeClock
+// - the latency of each run is measured after the thread wakes, so it doesn't include time the task spends
+//   waiting for locks or GC once it has started
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Flags of timerfd_settime, from timerfd.h.
+const tfdTimerAbstime = 1
+
+// The interval and first expiry of a timer, as timerfd_settime takes them.
+type itimerspec struct {
+	interval syscall.Timespec
+	value    syscall.Timespec
+}
+
+type PeriodicTaskConfig struct {
+	// The time between the starts of each run. The first run is a period after the task is started.
+	Period time.Duration
+
+	// Called, before the task runs, when one or more periods have passed since the last run without it running,
+	// because it or the system was running late.
+	OnOverrun func(missed int)
+
+	// The CPUs to pin the task's thread to, or nil for any. See LockThreadToCPUs.
+	CPUs []int
+
+	// A real-time priority for the task's thread, of 1 to 99, or 0 for normal. See SetIOThreadPriority.
+	Priority int
+}
+
+// Statistics of a periodic task's runs. Latency is from when a run was due to when the task's thread woke up.
+type PeriodicTaskStats struct {
+	Runs uint64
+
+	// Runs that followed missed periods, and the number of periods missed
+	Overruns uint64
+	Missed   uint64
+
+	MinLatency  time.Duration
+	MaxLatency  time.Duration
+	MeanLatency time.Duration
+
+	// The longest the task has taken to run
+	MaxRunTime time.Duration
+}
+
+// Return the range of latencies, the jitter of the task's start times.
+func (stats PeriodicTaskStats) Jitter() time.Duration {
+	return stats.MaxLatency - stats.MinLatency
+}
+
+// A task run periodically in the background.
+type PeriodicTask struct {
+	config PeriodicTaskConfig
+	task   func()
+	fd     int
+
+	// non-zero once the task is being stopped
+	stopping int32
+	stopOnce sync.Once
+	done     chan bool
+
+	// guards stats, totalLatency and err
+	sync.Mutex
+	stats        PeriodicTaskStats
+	totalLatency time.Duration
+	err          error
+}
+
+// Start running a task every period, until it is stopped.
+func NewPeriodicTask(config PeriodicTaskConfig, task func()) (*PeriodicTask, error) {
+	if config.Period <= 0 {
+		return nil, errors.New("periodic task needs a positive period")
+	}
+	if config.Priority < 0 || config.Priority > 99 {
+		return nil, fmt.Errorf("periodic task priority must be 0 to 99, got %d", config.Priority)
+	}
+
+	fd, _, errno := syscall.Syscall(syscall.SYS_TIMERFD_CREATE, clockMonotonic, syscall.O_CLOEXEC, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("could not create a timer: %s", errno)
+	}
+	t := &PeriodicTask{config: config, task: task, fd: int(fd), done: make(chan bool)}
+
+	started := make(chan error)
+	go t.run(started)
+	if e := <-started; e != nil {
+		<-t.done
+		syscall.Close(t.fd)
+		return nil, e
+	}
+	return t, nil
+}
+
+// Set the timer to expire at an absolute time on the monotonic clock, and every interval after.
+func (t *PeriodicTask) setTimer(first time.Duration, interval time.Duration, flags int) error {
+	spec := itimerspec{interval: syscall.NsecToTimespec(int64(interval)), value: syscall.NsecToTimespec(int64(first))}
+	_, _, errno := syscall.Syscall6(syscall.SYS_TIMERFD_SETTIME, uintptr(t.fd), uintptr(flags), uintptr(unsafe.Pointer(&spec)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("could not set the timer: %s", errno)
+	}
+	return nil
+}
+
+func (t *PeriodicTask) run(started chan error) {
+	defer close(t.done)
+
+	// a thread whose affinity or priority is changed is discarded when the goroutine exits, rather than going back
+	// to the runtime
+	runtime.LockOSThread()
+	if t.config.CPUs == nil && t.config.Priority == 0 {
+		defer runtime.UnlockOSThread()
+	}
+
+	var e error
+	if t.config.CPUs != nil {
+		e = setThreadAffinity(t.config.CPUs)
+	}
+	if e == nil && t.config.Priority > 0 {
+		e = setThreadPriority(t.config.Priority)
+	}
+	due := MonotonicNow() + t.config.Period
+	if e == nil {
+		e = t.setTimer(due, t.config.Period, tfdTimerAbstime)
+	}
+	started <- e
+	if e != nil {
+		return
+	}
+
+	var expirations uint64
+	for {
+		_, e := syscall.Read(t.fd, (*[8]byte)(unsafe.Pointer(&expirations))[:])
+		if e == syscall.EINTR {
+			continue
+		}
+		woke := MonotonicNow()
+		if atomic.LoadInt32(&t.stopping) != 0 {
+			return
+		}
+		if e != nil {
+			t.Lock()
+			t.err = fmt.Errorf("could not read the timer: %s", e)
+			t.Unlock()
+			return
+		}
+
+		// the run is for the latest expiry, so the missed ones are skipped
+		missed := int(expirations) - 1
+		due += time.Duration(missed) * t.config.Period
+		if missed > 0 && t.config.OnOverrun != nil {
+			t.config.OnOverrun(missed)
+		}
+		t.task()
+		t.record(woke-due, MonotonicNow()-woke, missed)
+		due += t.config.Period
+	}
+}
+
+func (t *PeriodicTask) record(latency time.Duration, runTime time.Duration, missed int) {
+	t.Lock()
+	defer t.Unlock()
+	s := &t.stats
+	s.Runs++
+	if missed > 0 {
+		s.Overruns++
+		s.Missed += uint64(missed)
+	}
+	if s.Runs == 1 || latency < s.MinLatency {
+		s.MinLatency = latency
+	}
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+	t.totalLatency += latency
+	s.MeanLatency = t.totalLatency / time.Duration(s.Runs)
+	if runTime > s.MaxRunTime {
+		s.MaxRunTime = runTime
+	}
+}
+
+// Return the statistics of the task's runs so far.
+func (t *PeriodicTask) Stats() PeriodicTaskStats {
+	t.Lock()
+	defer t.Unlock()
+	return t.stats
+}
+
+// Stop running the task, waiting for a run in progress to finish. Returns the error that stopped it earlier, if
+// there was one.
+func (t *PeriodicTask) Stop() error {
+	t.stopOnce.Do(func() {
+		atomic.StoreInt32(&t.stopping, 1)
+		// wake the goroutine straight away, rather than at the next period
+		t.setTimer(1, 0, 0)
+		<-t.done
+		syscall.Close(t.fd)
+	})
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
@@ -0,0 +1,182 @@
+package hwio
+
+// Checking that the program has access to the files and device nodes the driver's modules use. Without this, a
+// program run as a normal user fails on first use of a pin with an error like "permission denied" on a sysfs file,
+// which doesn't say what to do about it. Permissions checks everything up front, and says how to fix each problem.
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A file or device node the program can't access.
+type PermissionProblem struct {
+	// the module that needs the file
+	Module string
+
+	Path  string
+	Write bool
+
+	// the underlying error, e.g. from os.Stat
+	Err error
+
+	// what to do about it
+	Advice string
+}
+
+func (p *PermissionProblem) Error() string {
+	access := "read"
+	if p.Write {
+		access = "read and write"
+	}
+	return fmt.Sprintf("module '%s' cannot %s %s: %s", p.Module, access, p.Path, p.Advice)
+}
+
+// The error returned by Permissions, listing each problem found.
+type PermissionsError struct {
+	Problems []*PermissionProblem
+}
+
+func (e *PermissionsError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check the program can access the files and device nodes used by the driver's modules and any registered
+// modules. Returns nil if all is well, or a *PermissionsError describing each problem and how to fix it. This is
+// best called at startup, before any pins are used.
+func Permissions() error {
+	if driver == nil {
+		return fmt.Errorf("no driver is selected")
+	}
+
+	modules := GetModules()
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := make([]*PermissionProblem, 0)
+	for _, name := range names {
+		am, ok := modules[name].(AccessCheckModule)
+		if !ok {
+			continue
+		}
+		for _, ra := range am.RequiredAccess() {
+			if p := checkAccess(ra); p != nil {
+				p.Module = name
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PermissionsError{problems}
+	}
+	return nil
+}
+
+// Check a file can be accessed as required, returning a problem with advice if not.
+func checkAccess(ra RequiredAccess) *PermissionProblem {
+	info, e := os.Stat(ra.Path)
+	if e != nil {
+		advice := "it does not exist; check the kernel driver for it is loaded, or enabled in device tree"
+		if !os.IsNotExist(e) {
+			advice = e.Error()
+		}
+		return &PermissionProblem{Path: ra.Path, Write: ra.Write, Err: e, Advice: advice}
+	}
+
+	mode := uint32(4) // R_OK
+	if ra.Write {
+		mode |= 2 // W_OK
+	}
+	e = syscall.Access(ra.Path, mode)
+	if e == nil {
+		return nil
+	}
+	return &PermissionProblem{Path: ra.Path, Write: ra.Write, Err: e, Advice: permissionAdvice(ra.Path, info)}
+}
+
+// Suggest how to get access to a file, based on the group that owns it.
+func permissionAdvice(path string, info os.FileInfo) string {
+	group := ""
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if g, e := user.LookupGroupId(strconv.Itoa(int(st.Gid))); e == nil {
+			group = g.Name
+		}
+	}
+
+	if group == "" || group == "root" {
+		return fmt.Sprintf("it is only accessible to root; add a udev rule giving a group such as 'gpio' access to %s, or run as root", path)
+	}
+	if info.Mode().Perm()&0060 == 0 {
+		return fmt.Sprintf("its group '%s' does not have access; add a udev rule to allow group access, or run as root", group)
+	}
+	return fmt.Sprintf("add the user to the '%s' group (sudo usermod -aG %s $USER) and log in again", group, group)
+}
+
+// After a GPIO or PWM channel is exported through sysfs, udev changes the ownership and permissions of its files
+// asynchronously, so a program that isn't root can find it doesn't have access to them yet. ExportRetry controls
+// how writes to a newly exported channel's files are retried when access is denied.
+type ExportRetry struct {
+	// the number of times to retry. 0 disables retrying.
+	Attempts int
+
+	// the delay before the first retry. The delay doubles after each retry, up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// The default retries for about 200ms in all, which is enough for udev on a busy single board computer.
+var exportRetry = ExportRetry{Attempts: 10, InitialDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+// Set how writes to the files of a newly exported GPIO or PWM channel are retried when access is denied.
+func SetExportRetryPolicy(policy ExportRetry) {
+	exportRetry = policy
+}
+
+// Set how many times to retry access to the files of a newly exported GPIO or PWM channel, and a fixed delay between
+// attempts. Setting attempts to 0 disables retrying.
+func SetExportRetry(attempts int, delay time.Duration) {
+	exportRetry = ExportRetry{Attempts: attempts, InitialDelay: delay, MaxDelay: delay}
+}
+
+// Call f, retrying with backoff while it fails because access is denied. Returns the last error.
+func retryExport(f func() error) error {
+	delay := exportRetry.InitialDelay
+	e := f()
+	for i := 0; i < exportRetry.Attempts && e != nil && os.IsPermission(e); i++ {
+		time.Sleep(delay)
+		delay *= 2
+		if delay > exportRetry.MaxDelay {
+			delay = exportRetry.MaxDelay
+		}
+		e = f()
+	}
+	return e
+}
+
+// Write a value to a file of a newly exported channel, retrying while access is denied.
+func writeExportedFile(filename string, value string) error {
+	return retryExport(func() error {
+		return WriteStringToFile(filename, value)
+	})
+}
+
+// Wait until a file of a newly exported channel is writable, or the retry attempts are used up.
+func waitForExport(path string) {
+	retryExport(func() error {
+		return syscall.Access(path, 2)
+	})
+}
@@ -0,0 +1,88 @@
+// Drive strength control for the Raspberry Pi (BCM2835/6/7 and BCM2711). The kernel has no interface for this,
+// so it is set through the pads control registers via /dev/mem, which requires root.
+//
+// GPIO pins are grouped in banks (0-27, 28-45, 46-53) that share one pads register, so setting the drive
+// strength of a pin sets it for every pin in the same bank.
+
+package hwio
+
+// References:
+// - BCM2835 ARM peripherals, and the "GPIO pads control" whitepaper from the Raspberry Pi foundation.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// offset of the pads registers from the peripheral base address
+	piPadsOffset = 0x100000
+
+	// offset of the register for bank 0 within the pads block. Banks 1 and 2 follow.
+	piPadsBank0 = 0x2c
+
+	// writes to a pads register are ignored unless they include this value
+	piPadsPassword = 0x5a000000
+
+	// bits of the register that select drive strength. 0 is 2mA, 7 is 16mA.
+	piPadsDriveMask = 0x7
+)
+
+// Determine the peripheral base address from device tree. The address cells differ between SoC's: on the
+// BCM2711 the parent address is 64 bits, so the low word is at offset 8 instead of 4.
+func piPeripheralBase() (int64, error) {
+	ranges, e := ioutil.ReadFile("/proc/device-tree/soc/ranges")
+	if e != nil {
+		return 0, e
+	}
+	if len(ranges) < 12 {
+		return 0, fmt.Errorf("unexpected format of soc/ranges")
+	}
+
+	base := binary.BigEndian.Uint32(ranges[4:8])
+	if base == 0 {
+		base = binary.BigEndian.Uint32(ranges[8:12])
+	}
+	return int64(base), nil
+}
+
+// Set the drive strength of the bank containing the GPIO. Drive strength must be an even number of milliamps
+// between 2 and 16.
+func piSetDriveStrength(gpioLogical int, milliamps int) error {
+	if milliamps < 2 || milliamps > 16 || milliamps%2 != 0 {
+		return fmt.Errorf("drive strength must be 2-16mA in 2mA steps, got %dmA", milliamps)
+	}
+
+	bank := 0
+	if gpioLogical >= 46 {
+		bank = 2
+	} else if gpioLogical >= 28 {
+		bank = 1
+	}
+
+	base, e := piPeripheralBase()
+	if e != nil {
+		return e
+	}
+
+	f, e := os.OpenFile("/dev/mem", os.O_RDWR|os.O_SYNC, 0)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	mem, e := syscall.Mmap(int(f.Fd()), base+piPadsOffset, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		return e
+	}
+	defer syscall.Munmap(mem)
+
+	reg := (*uint32)(unsafe.Pointer(&mem[piPadsBank0+4*bank]))
+	*reg = piPadsPassword | (*reg &^ (piPadsDriveMask | 0xff000000)) | uint32(milliamps/2-1)
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package hwio
+
+// A PID controller, for holding a process variable such as a temperature or motor speed at a setpoint by adjusting
+// an output such as a heater's or motor's PWM duty. PID does the calculation, and RunPID runs it at a fixed rate,
+// reading the process variable from a function and writing the output to another. AnalogInput, PWMOutput and
+// GPIOOutput make those functions for pins.
+//
+// The derivative is of the process variable rather than the error, so a change of setpoint doesn't kick the output.
+// The integral is limited to the output range, so it doesn't wind up while the output is saturated, e.g. while a
+// heater is on full and the temperature is still far from the setpoint.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A PID controller's gains, setpoint and state.
+type PID struct {
+	// Gains of the proportional, integral (per second) and derivative (in seconds) terms.
+	Kp float64
+	Ki float64
+	Kd float64
+
+	// The value the process variable is held at.
+	Setpoint float64
+
+	// The range of the output. If both are 0, the output is 0 to 1, as PWMOutput and GPIOOutput expect.
+	OutMin float64
+	OutMax float64
+
+	integral float64
+	lastPV   float64
+	started  bool
+}
+
+// Create a PID controller with gains, and an output from 0 to 1.
+func NewPID(kp float64, ki float64, kd float64) *PID {
+	return &PID{Kp: kp, Ki: ki, Kd: kd, OutMax: 1}
+}
+
+// Return the output range.
+func (p *PID) outputRange() (float64, float64) {
+	if p.OutMin == 0 && p.OutMax == 0 {
+		return 0, 1
+	}
+	return p.OutMin, p.OutMax
+}
+
+// Calculate the output for a reading of the process variable, dt after the last. The first update after NewPID or
+// Reset has no derivative term.
+func (p *PID) Update(pv float64, dt time.Duration) float64 {
+	lo, hi := p.outputRange()
+	seconds := dt.Seconds()
+	e := p.Setpoint - pv
+
+	if seconds > 0 {
+		p.integral = clampFloat(p.integral+p.Ki*e*seconds, lo, hi)
+	}
+	derivative := 0.0
+	if p.started && seconds > 0 {
+		derivative = -p.Kd * (pv - p.lastPV) / seconds
+	}
+	p.lastPV = pv
+	p.started = true
+
+	return clampFloat(p.Kp*e+p.integral+derivative, lo, hi)
+}
+
+// Clear the integral and derivative state, e.g. after the loop has been stopped for a while.
+func (p *PID) Reset() {
+	p.integral = 0
+	p.started = false
+}
+
+func clampFloat(v float64, lo float64, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// A PID controller being run in the background.
+type PIDLoop struct {
+	pid    *PID
+	input  func() (float64, error)
+	output func(float64) error
+
+	stop chan bool
+	done chan bool
+
+	// guards pid, the last values and err
+	sync.Mutex
+	pv  float64
+	out float64
+	err error
+}
+
+// Run a PID controller every interval, reading the process variable from input and writing the output to output,
+// until stopped or either returns an error.
+func RunPID(pid *PID, interval time.Duration, input func() (float64, error), output func(float64) error) (*PIDLoop, error) {
+	if pid == nil || input == nil || output == nil {
+		return nil, errors.New("RunPID needs a controller, an input and an output")
+	}
+	if interval <= 0 {
+		return nil, errors.New("RunPID needs a positive interval")
+	}
+
+	l := &PIDLoop{pid: pid, input: input, output: output, stop: make(chan bool), done: make(chan bool)}
+	go l.run(interval)
+	return l, nil
+}
+
+func (l *PIDLoop) run(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := MonotonicNow()
+	for {
+		pv, e := l.input()
+		if e == nil {
+			now := MonotonicNow()
+			l.Lock()
+			out := l.pid.Update(pv, now-last)
+			l.pv, l.out = pv, out
+			l.Unlock()
+			last = now
+			e = l.output(out)
+		}
+		if e != nil {
+			l.Lock()
+			l.err = e
+			l.Unlock()
+			return
+		}
+
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Change the setpoint of the running controller.
+func (l *PIDLoop) SetSetpoint(setpoint float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.pid.Setpoint = setpoint
+}
+
+// Return the last reading of the process variable, and the output calculated from it.
+func (l *PIDLoop) Last() (pv float64, out float64) {
+	l.Lock()
+	defer l.Unlock()
+	return l.pv, l.out
+}
+
+// Stop the loop, and wait for it to finish. The output is left at its last value. Returns the error that stopped it
+// earlier, if there was one.
+func (l *PIDLoop) Stop() error {
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+	return l.Wait()
+}
+
+// Wait for the loop to finish, which it only does when stopped or on an error, and return the error.
+func (l *PIDLoop) Wait() error {
+	<-l.done
+	return l.Err()
+}
+
+// Return the error from reading the input or writing the output, if there was one.
+func (l *PIDLoop) Err() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.err
+}
+
+// Return an input that reads an analog pin, with the reading scaled by scale, e.g. to convert it to a temperature.
+// If scale is nil the raw reading is used.
+func AnalogInput(pin Pin, scale func(reading int) float64) func() (float64, error) {
+	return func() (float64, error) {
+		v, e := AnalogRead(pin)
+		if e != nil {
+			return 0, e
+		}
+		if scale == nil {
+			return float64(v), nil
+		}
+		return scale(v), nil
+	}
+}
+
+// Return an output that sets the duty of a PWM pin from an output of 0 to 1, with a period. The pin's PWM output is
+// enabled, starting at 0.
+func PWMOutput(pin Pin, period time.Duration) (func(float64) error, error) {
+	pwm := findPWMModule(pin)
+	if pwm == nil {
+		return nil, fmt.Errorf("pin %d is not a PWM pin", pin)
+	}
+	if period <= 0 {
+		return nil, errors.New("PWMOutput needs a positive period")
+	}
+	e := startPWM(pwm, pin, period, 0)
+	if e != nil {
+		return nil, e
+	}
+	return func(out float64) error {
+		return pwm.SetDuty(pin, int64(clampFloat(out, 0, 1)*float64(period)))
+	}, nil
+}
+
+// Return an output that switches a GPIO pin on for a fraction of each window given by an output of 0 to 1, e.g. a
+// relay or solid state relay switching a heater. The pin must already be an output. The pin is only switched when
+// the output is written, so the fraction is only as fine as the loop's interval is to the window; a window of a few
+// seconds suits a loop run ten times a second.
+func GPIOOutput(pin Pin, window time.Duration) func(float64) error {
+	start := MonotonicNow()
+	return func(out float64) error {
+		elapsed := (MonotonicNow() - start) % window
+		if float64(elapsed) < clampFloat(out, 0, 1)*float64(window) {
+			return DigitalWrite(pin, High)
+		}
+		return DigitalWrite(pin, Low)
+	}
+}
@@ -1,7 +1,11 @@
 package hwio
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Definitions relating to pins.
@@ -30,6 +34,36 @@ func (mode PinIOMode) String() string {
 	return ""
 }
 
+// What happens to a pin when it is closed, or its module disabled, for modules that implement
+// GPIOClosePolicyModule.
+type ClosePolicy int
+
+const (
+	// Unexport the pin. This is the default.
+	UnexportOnClose ClosePolicy = iota
+
+	// Leave the pin exported, with its direction and level as they are, so an output holds its level after the
+	// program exits.
+	LeaveExported
+
+	// Put the pin back as it was before it was opened: unexported if it wasn't exported, otherwise with its
+	// previous direction, level and active-low setting.
+	RestorePrevious
+)
+
+// String representation of close policy
+func (policy ClosePolicy) String() string {
+	switch policy {
+	case UnexportOnClose:
+		return "UnexportOnClose"
+	case LeaveExported:
+		return "LeaveExported"
+	case RestorePrevious:
+		return "RestorePrevious"
+	}
+	return ""
+}
+
 // Convenience constants for digital pin values.
 const (
 	High = 1
@@ -42,15 +76,71 @@ type PinDef struct {
 	pin     Pin      // the pin, also in the map key of HardwarePinMap
 	names   []string // a list of names for the pin as defined by driver. There should be at least one. The first is the canonical name.
 	modules []string // a list of module names that can use this pin
+
+	header   string // name of the header or connector the pin is on, e.g. "P8", or "" if it's not on one
+	position int    // position of the pin on its header, numbered from 1 as printed on the board
+
+	lineName string // the kernel's name for the pin's GPIO line, from gpio-line-names in device tree, or ""
 }
 
 type PinList []Pin
 
 type HardwarePinMap map[Pin]*PinDef
 
-// Add a pin to the map
-func (m HardwarePinMap) add(pin Pin, names []string, modules []string) {
-	m[pin] = &PinDef{pin, names, modules}
+// Add a pin to the map. names are the names the pin is known by, the first being the canonical name, and modules
+// are the names of the modules that can use the pin. Drivers call this from PinMap.
+func (m HardwarePinMap) Add(pin Pin, names []string, modules []string) {
+	m[pin] = &PinDef{pin: pin, names: names, modules: modules}
+}
+
+// Record that a pin is at a position on a named header. The pin must already be in the map.
+func (m HardwarePinMap) SetHeader(pin Pin, header string, position int) {
+	if pd := m[pin]; pd != nil {
+		pd.header = header
+		pd.position = position
+	}
+}
+
+// Return the names of the headers the pins are on, sorted.
+func (m HardwarePinMap) Headers() []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, pd := range m {
+		if pd.header != "" && !seen[pd.header] {
+			seen[pd.header] = true
+			result = append(result, pd.header)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Return the pins on a header, in order of position.
+func (m HardwarePinMap) HeaderPins(header string) PinList {
+	defs := make([]*PinDef, 0)
+	for _, pd := range m {
+		if pd.header != "" && strings.EqualFold(pd.header, header) {
+			defs = append(defs, pd)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].position < defs[j].position })
+
+	result := make(PinList, len(defs))
+	for i, pd := range defs {
+		result[i] = pd.pin
+	}
+	return result
+}
+
+// Return the PinDef of the pin at a position on a header, or nil if there is none. Header names are not case
+// sensitive.
+func (m HardwarePinMap) GetHeaderPin(header string, position int) *PinDef {
+	for _, pd := range m {
+		if pd.position == position && pd.header != "" && strings.EqualFold(pd.header, header) {
+			return pd
+		}
+	}
+	return nil
 }
 
 // Given a pin number, return it's PinDef, or nil if that pin is not defined in the map
@@ -62,9 +152,63 @@ func (m HardwarePinMap) GetPin(pin Pin) *PinDef {
 // supports.
 func (pd *PinDef) String() string {
 	s := pd.Names() + "  modules:" + strings.Join(pd.modules, ",")
+	if pd.header != "" {
+		s += fmt.Sprintf("  header:%s.%d", pd.header, pd.position)
+	}
+	if pd.lineName != "" {
+		s += "  line:" + pd.lineName
+	}
 	return s
 }
 
+// Return the pin number.
+func (pd *PinDef) Pin() Pin {
+	return pd.pin
+}
+
+// Return the names of the pin. The first is the canonical name.
+func (pd *PinDef) NameList() []string {
+	return pd.names
+}
+
+// Return the names of the modules that can use the pin.
+func (pd *PinDef) Modules() []string {
+	return pd.modules
+}
+
+// Determine if the pin can be used by a module.
+func (pd *PinDef) usedBy(module string) bool {
+	for _, m := range pd.modules {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the kernel's name for the pin's GPIO line, or "" if it has none or the GPIO module can't find it.
+func (pd *PinDef) LineName() string {
+	return pd.lineName
+}
+
+// Return the header the pin is on and its position, or "" and 0 if it's not on a header.
+func (pd *PinDef) Header() (string, int) {
+	return pd.header, pd.position
+}
+
+// Split a pin name of the form "<header>.<position>", e.g. "P2.07", into the header and position.
+func parseHeaderPinName(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".")
+	if i <= 0 {
+		return "", 0, false
+	}
+	position, e := strconv.Atoi(name[i+1:])
+	if e != nil || position <= 0 {
+		return "", 0, false
+	}
+	return name[:i], position, true
+}
+
 // From the hwPinRefs, construct a string by appending them together. Not brilliantly efficient,
 // but its most for diagnostics anyway.
 func (pd *PinDef) Names() string {
@@ -81,3 +225,126 @@ func (pd *PinDef) Names() string {
 // 	}
 // 	return false
 // }
+
+// Output drive modes, used in PinConfig.
+type PinDrive int
+
+const (
+	DrivePushPull PinDrive = iota
+	DriveOpenDrain
+	DriveOpenSource
+)
+
+// String representation of pin drive
+func (drive PinDrive) String() string {
+	switch drive {
+	case DrivePushPull:
+		return "PushPull"
+	case DriveOpenDrain:
+		return "OpenDrain"
+	case DriveOpenSource:
+		return "OpenSource"
+	}
+	return ""
+}
+
+// Bias settings, used in PinConfig. BiasDefault leaves the bias as the kernel or device tree
+// configured it, unless the mode is InputPullUp or InputPullDown.
+type PinBias int
+
+const (
+	BiasDefault PinBias = iota
+	BiasDisabled
+	BiasPullUp
+	BiasPullDown
+)
+
+// String representation of pin bias
+func (bias PinBias) String() string {
+	switch bias {
+	case BiasDefault:
+		return "Default"
+	case BiasDisabled:
+		return "Disabled"
+	case BiasPullUp:
+		return "PullUp"
+	case BiasPullDown:
+		return "PullDown"
+	}
+	return ""
+}
+
+// Edges of a digital signal, used when watching input pins for changes. Edges are logical, so on an active-low pin
+// a rising edge is the line going electrically low.
+type Edge int
+
+const (
+	EdgeNone Edge = iota
+	EdgeRising
+	EdgeFalling
+	EdgeBoth
+)
+
+// String representation of an edge
+func (edge Edge) String() string {
+	switch edge {
+	case EdgeNone:
+		return "None"
+	case EdgeRising:
+		return "Rising"
+	case EdgeFalling:
+		return "Falling"
+	case EdgeBoth:
+		return "Both"
+	}
+	return ""
+}
+
+// PinConfig describes the full electrical configuration of a GPIO line, and is passed to PinModeConfig.
+// Apart from Mode, the zero value of each field leaves that attribute unchanged, so PinConfig{Mode: Output}
+// is equivalent to PinMode(pin, Output).
+type PinConfig struct {
+	Mode PinIOMode
+
+	// How an output drives the line. Open-drain is what I2C-like buses and sinking LEDs need.
+	Drive PinDrive
+
+	// Pull-up or pull-down applied to the line.
+	Bias PinBias
+
+	// Drive strength in milliamps. 0 leaves the SoC default. Only some SoC's support this, and on some
+	// (e.g. Raspberry Pi) it is set for a whole bank of pins at once.
+	DriveStrength int
+
+	// If true, the line is electrically inverted, so High is written out as a low voltage.
+	ActiveLow bool
+
+	// Debounce period of an input, applied by the kernel, so a change is only seen once the line has been stable
+	// for this long. 0 means no debouncing.
+	Debounce time.Duration
+
+	// Level an output starts at, when UseInitialValue is true. The level is set together with the direction,
+	// so the output does not briefly drive whatever level the kernel defaults to.
+	InitialValue    int
+	UseInitialValue bool
+
+	// Limits on how fast the output can change, enforced by DigitalWrite and AnalogWrite rather than the GPIO
+	// module: the most changes of level a second, and the most AnalogWrite duty change a second as a fraction of
+	// full scale. 0 means no limit. See output_limits.go.
+	MaxToggleRate float64
+	MaxDutySlew   float64
+}
+
+// Determine the bias implied by the config, taking InputPullUp and InputPullDown modes into account.
+func (c PinConfig) effectiveBias() PinBias {
+	if c.Bias != BiasDefault {
+		return c.Bias
+	}
+	switch c.Mode {
+	case InputPullUp:
+		return BiasPullUp
+	case InputPullDown:
+		return BiasPullDown
+	}
+	return BiasDefault
+}
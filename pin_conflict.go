@@ -0,0 +1,138 @@
+package hwio
+
+// Detecting GPIO lines that are already claimed by a kernel driver, such as an LED, a button, or a device tree
+// overlay that uses the pin for something else, or by another program. Without this, exporting or requesting the
+// line fails with "device or resource busy", or, on older kernels, appears to work while the driver keeps control
+// of the pin. The GPIO modules check the line first, and return a PinInUseError naming the consumer.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Location of the GPIO debugfs file, which lists the lines in use and their consumers. This is a variable so tests
+// can point it elsewhere.
+var gpioDebugfsPath = "/sys/kernel/debug/gpio"
+
+// The error returned when a pin's GPIO line is already in use.
+type PinInUseError struct {
+	Pin Pin
+
+	// The line, e.g. "/dev/gpiochip0 line 18" or "gpio 18"
+	Line string
+
+	// What is using it, as the kernel reports it: the name of a driver or device tree node, e.g. "led0", or of the
+	// program that requested it.
+	Consumer string
+}
+
+func (e *PinInUseError) Error() string {
+	return fmt.Sprintf("pin %d (%s) is in use by '%s'; free it by disabling the driver, device tree overlay or program that claims it", e.Pin, e.Line, e.Consumer)
+}
+
+// Read the info of a line of a GPIO character device, including whether it's in use, and by what.
+func readGPIOLineInfo(chip string, line int) (*gpioV2LineInfo, error) {
+	f, e := os.OpenFile(chip, os.O_RDWR, 0)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	info := &gpioV2LineInfo{offset: uint32(line)}
+	e = gpioIoctl(f.Fd(), gpioV2GetLineInfoIoctl, unsafe.Pointer(info))
+	if e != nil {
+		return nil, e
+	}
+	return info, nil
+}
+
+// Check a line of a GPIO character device is free to request for a pin. If the line info can't be read, the line
+// is assumed to be free, and requesting it will give the error.
+func checkGPIOLineFree(pin Pin, chip string, line int) error {
+	info, e := readGPIOLineInfo(chip, line)
+	if e != nil || info.flags&gpioV2LineFlagUsed == 0 {
+		return nil
+	}
+	consumer := gpioCString(info.consumer[:])
+	if consumer == "" {
+		consumer = "unknown"
+	}
+	return &PinInUseError{Pin: pin, Line: fmt.Sprintf("%s line %d", chip, line), Consumer: consumer}
+}
+
+// Return the consumer of a GPIO by its global number, from the GPIO debugfs file, or "" if it is free or debugfs
+// can't be read, which needs root and debugfs mounted.
+func gpioDebugfsConsumer(fsys SysfsFS, gpio int) string {
+	f, e := fsys.Open(sysfsName(gpioDebugfsPath))
+	if e != nil {
+		return ""
+	}
+	defer f.Close()
+	return parseGPIODebugfs(bufio.NewScanner(f))[gpio]
+}
+
+// A line of the GPIO debugfs file: " gpio-18  (GPIO18              |led0                ) out lo". Older kernels
+// only list lines in use, with just the consumer in the brackets, and newer ones list unused lines with just
+// their name and nothing after the brackets.
+var gpioDebugfsLine = regexp.MustCompile(`^\s*gpio-(\d+)\s*\(([^|)]*)(\|([^)]*))?\)(.*)$`)
+
+// Parse the GPIO debugfs file, returning the consumer of each GPIO in use.
+func parseGPIODebugfs(scanner *bufio.Scanner) map[int]string {
+	result := make(map[int]string)
+	for gpio, line := range parseGPIODebugfsLines(scanner) {
+		result[gpio] = line.consumer
+	}
+	return result
+}
+
+// A GPIO in use, as the debugfs file lists it.
+type gpioDebugfsLineInfo struct {
+	name      string
+	consumer  string
+	direction string // "in" or "out", or "" if not listed
+	value     int    // 0 or 1, or -1 if not listed
+	activeLow bool
+}
+
+// Parse the GPIO debugfs file, returning each GPIO in use by its global number.
+func parseGPIODebugfsLines(scanner *bufio.Scanner) map[int]*gpioDebugfsLineInfo {
+	result := make(map[int]*gpioDebugfsLineInfo)
+	for scanner.Scan() {
+		m := gpioDebugfsLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		gpio, _ := strconv.Atoi(m[1])
+
+		line := &gpioDebugfsLineInfo{value: -1}
+		if m[3] != "" {
+			line.name = strings.TrimSpace(m[2])
+			line.consumer = strings.TrimSpace(m[4])
+		} else if strings.TrimSpace(m[5]) != "" {
+			line.consumer = strings.TrimSpace(m[2])
+		}
+		if line.consumer == "" {
+			continue
+		}
+
+		// the rest is e.g. "out lo ACTIVE LOW" or "in  hi IRQ"
+		rest := strings.Fields(m[5])
+		if len(rest) > 0 && (rest[0] == "in" || rest[0] == "out") {
+			line.direction = rest[0]
+		}
+		if len(rest) > 1 && (rest[1] == "lo" || rest[1] == "hi") {
+			line.value = 0
+			if rest[1] == "hi" {
+				line.value = 1
+			}
+		}
+		line.activeLow = strings.Contains(m[5], "ACTIVE LOW")
+		result[gpio] = line
+	}
+	return result
+}
@@ -0,0 +1,147 @@
+package hwio
+
+// Rendering of the pin map for people and programs: JSON, a table of all pins, and diagrams of the headers. Where
+// the map is the driver's map (GetDefinedPins), these include which module each pin is currently assigned to, and
+// the mode it was set to with PinMode.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// The JSON form of a pin.
+type pinDefJSON struct {
+	Pin        Pin      `json:"pin"`
+	Names      []string `json:"names"`
+	Modules    []string `json:"modules"`
+	Header     string   `json:"header,omitempty"`
+	Position   int      `json:"position,omitempty"`
+	Line       string   `json:"line,omitempty"`
+	AssignedTo string   `json:"assignedTo,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+}
+
+// Serialise the pin map to JSON, as an array of pins in pin number order.
+func (m HardwarePinMap) MarshalJSON() ([]byte, error) {
+	result := make([]*pinDefJSON, 0, len(m))
+	for _, pin := range m.sortedPins() {
+		pd := m[pin]
+		j := &pinDefJSON{Pin: pin, Names: pd.names, Modules: pd.modules, Header: pd.header, Position: pd.position, Line: pd.lineName}
+		j.AssignedTo, j.Mode = pinAssignment(pin)
+		result = append(result, j)
+	}
+	return json.Marshal(result)
+}
+
+// Return the pins of the map in pin number order.
+func (m HardwarePinMap) sortedPins() PinList {
+	result := make(PinList, 0, len(m))
+	for pin := range m {
+		result = append(result, pin)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Return the name of the module a pin is assigned to and the mode it was set to, or "" for either if not known.
+func pinAssignment(pin Pin) (module string, mode string) {
+	a := assignedPins[pin]
+	if a == nil {
+		return "", ""
+	}
+	if a.modeSet {
+		mode = a.pinIOMode.String()
+	}
+	return a.module.GetName(), mode
+}
+
+// Render the pin map as a table, one pin per line in pin number order, with columns for the pin number, names,
+// modules, header position, kernel line name, and current assignment.
+func (m HardwarePinMap) Table() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PIN\tNAMES\tMODULES\tHEADER\tLINE\tASSIGNED\tMODE")
+	for _, pin := range m.sortedPins() {
+		pd := m[pin]
+		header := ""
+		if pd.header != "" {
+			header = fmt.Sprintf("%s.%d", pd.header, pd.position)
+		}
+		module, mode := pinAssignment(pin)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", pin, pd.Names(), strings.Join(pd.modules, ","), header, pd.lineName, module, mode)
+	}
+	w.Flush()
+
+	// tabwriter pads the last column too
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Render a diagram of a header, as it is laid out on the board: odd positions on the left, even positions on the
+// right. Each pin is labelled with its canonical name and, if it is assigned, the module and mode, e.g.
+// "gpio17 [gpio Output]". Returns "" if the header is not in the map.
+func (m HardwarePinMap) HeaderDiagram(header string) string {
+	pins := m.HeaderPins(header)
+	if len(pins) == 0 {
+		return ""
+	}
+
+	byPosition := make(map[int]*PinDef)
+	last := 0
+	for _, pin := range pins {
+		pd := m[pin]
+		byPosition[pd.position] = pd
+		if pd.position > last {
+			last = pd.position
+		}
+	}
+
+	// labels of the left and right columns of each row
+	rows := (last + 1) / 2
+	left := make([]string, rows)
+	right := make([]string, rows)
+	width := 0
+	for r := 0; r < rows; r++ {
+		left[r] = headerPinLabel(byPosition[r*2+1])
+		right[r] = headerPinLabel(byPosition[r*2+2])
+		if len(left[r]) > width {
+			width = len(left[r])
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", m[pins[0]].header)
+	for r := 0; r < rows; r++ {
+		line := fmt.Sprintf("%*s  %2d | %-2d  %s", width, left[r], r*2+1, r*2+2, right[r])
+		fmt.Fprintln(&buf, strings.TrimRight(line, " "))
+	}
+	return buf.String()
+}
+
+// Render diagrams of all the headers in the map, separated by blank lines.
+func (m HardwarePinMap) HeaderDiagrams() string {
+	diagrams := make([]string, 0)
+	for _, h := range m.Headers() {
+		diagrams = append(diagrams, m.HeaderDiagram(h))
+	}
+	return strings.Join(diagrams, "\n")
+}
+
+func headerPinLabel(pd *PinDef) string {
+	if pd == nil {
+		return ""
+	}
+	label := pd.names[0]
+	module, mode := pinAssignment(pd.pin)
+	if module != "" {
+		label += " [" + strings.TrimSpace(module+" "+mode) + "]"
+	}
+	return label
+}
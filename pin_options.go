@@ -0,0 +1,68 @@
+package hwio
+
+// Options for PinMode, which set the line attributes of PinConfig without a PinConfig literal, so attributes can be
+// added without changing PinMode:
+//
+//	hwio.PinMode(button, hwio.Input, hwio.WithPull(hwio.BiasPullUp), hwio.WithDebounce(5*time.Millisecond))
+//	hwio.PinMode(relay, hwio.Output, hwio.WithActiveLow(), hwio.WithInitialValue(hwio.Low))
+//
+// Whether an attribute can be applied depends on the GPIO module, which returns an error if it can't.
+
+import (
+	"time"
+)
+
+// An option for PinMode, setting an attribute of the pin's config.
+type PinOption func(config *PinConfig)
+
+// Return the config for a mode with options applied.
+func NewPinConfig(mode PinIOMode, options ...PinOption) PinConfig {
+	config := PinConfig{Mode: mode}
+	for _, option := range options {
+		option(&config)
+	}
+	return config
+}
+
+// Apply a pull-up or pull-down.
+func WithPull(bias PinBias) PinOption {
+	return func(config *PinConfig) {
+		config.Bias = bias
+	}
+}
+
+// Have the kernel debounce an input, so a change is only seen once the line has been stable for the period.
+func WithDebounce(period time.Duration) PinOption {
+	return func(config *PinConfig) {
+		config.Debounce = period
+	}
+}
+
+// Make the pin active-low, so High is a low voltage.
+func WithActiveLow() PinOption {
+	return func(config *PinConfig) {
+		config.ActiveLow = true
+	}
+}
+
+// Set how an output drives the line, e.g. DriveOpenDrain.
+func WithDrive(drive PinDrive) PinOption {
+	return func(config *PinConfig) {
+		config.Drive = drive
+	}
+}
+
+// Set the drive strength of the pin in milliamps.
+func WithDriveStrength(mA int) PinOption {
+	return func(config *PinConfig) {
+		config.DriveStrength = mA
+	}
+}
+
+// Start an output at a level, set together with the direction so the output doesn't glitch.
+func WithInitialValue(value int) PinOption {
+	return func(config *PinConfig) {
+		config.InitialValue = value
+		config.UseInitialValue = true
+	}
+}
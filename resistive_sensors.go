@@ -0,0 +1,179 @@
+package hwio
+
+// Helpers for sensors whose resistance changes, such as thermistors and light dependent resistors (LDRs), read through
+// a voltage divider: the sensor and a fixed resistor in series across a supply, with an analog pin reading the point
+// between them. A Divider converts a reading to the sensor's resistance, a Thermistor converts that to a temperature
+// with the Steinhart-Hart equation, and an LDR to an approximate light level. A Potentiometer reads the position of a
+// pot wired across the supply:
+//
+//	// a 10k NTC thermistor with a B of 3950, to ground, under a 10k resistor, on a 12 bit ADC with a 3.3V reference
+//	divider := hwio.Divider{Pin: ain0, VoltsPerCount: 3.3 / 4096, SupplyVolts: 3.3, FixedOhms: 10000}
+//	thermistor := hwio.Thermistor{Divider: divider, Coefficients: hwio.BetaCoefficients(10000, hwio.Celsius(25), 3950)}
+//	t, e := thermistor.ReadTemperature()
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A voltage divider of a fixed resistor and a sensor, read on an analog pin.
+type Divider struct {
+	// The analog pin reading the point between the resistor and the sensor
+	Pin Pin
+
+	// The voltage of one count of the analog pin, e.g. 3.3/4096 for a 12 bit ADC with a 3.3V reference, and the
+	// voltage across the divider. When the divider is supplied from the ADC's reference, as it should be for the best
+	// accuracy, SupplyVolts is the reference.
+	VoltsPerCount float64
+	SupplyVolts   float64
+
+	// The resistance of the fixed resistor, in ohms
+	FixedOhms float64
+
+	// Whether the sensor is between the supply and the pin, with the fixed resistor to ground. Otherwise the sensor is
+	// between the pin and ground.
+	SensorHigh bool
+}
+
+// Convert a reading of the analog pin to the sensor's resistance in ohms. A reading at either end of the range,
+// which is what an open or shorted sensor gives, is an error.
+func (d Divider) Resistance(raw int) (float64, error) {
+	if d.VoltsPerCount <= 0 || d.SupplyVolts <= 0 || d.FixedOhms <= 0 {
+		return 0, errors.New("divider needs the volts per count, supply voltage and fixed resistance")
+	}
+	ratio := float64(raw) * d.VoltsPerCount / d.SupplyVolts
+	if ratio <= 0 || ratio >= 1 {
+		return 0, fmt.Errorf("divider reading %d is at the end of its range, so the sensor is open or shorted", raw)
+	}
+	if d.SensorHigh {
+		return d.FixedOhms * (1 - ratio) / ratio, nil
+	}
+	return d.FixedOhms * ratio / (1 - ratio), nil
+}
+
+// Read the analog pin, and return the sensor's resistance in ohms.
+func (d Divider) ReadResistance() (float64, error) {
+	raw, e := AnalogRead(d.Pin)
+	if e != nil {
+		return 0, e
+	}
+	return d.Resistance(raw)
+}
+
+// The coefficients of the Steinhart-Hart equation, 1/T = A + B ln(R) + C ln(R)³, relating the resistance of a
+// thermistor in ohms to its temperature in kelvin. Datasheets give them for some thermistors; otherwise
+// BetaCoefficients or SteinhartHartFromPoints work them out.
+type SteinhartHart struct {
+	A, B, C float64
+}
+
+// Return the coefficients for a thermistor specified by its resistance at a temperature, usually 25°C, and its B (or
+// β) value, which is what most datasheets give. This is the Steinhart-Hart equation with C as 0, which is accurate to
+// within a degree or so near the reference temperature.
+func BetaCoefficients(r0 float64, t0 Temperature, beta float64) SteinhartHart {
+	return SteinhartHart{A: 1/t0.Kelvin() - math.Log(r0)/beta, B: 1 / beta}
+}
+
+// Work out the coefficients from the thermistor's resistance at three temperatures, such as from a datasheet's table
+// or from calibrating it. The temperatures should span the range it is used over.
+func SteinhartHartFromPoints(r1 float64, t1 Temperature, r2 float64, t2 Temperature, r3 float64, t3 Temperature) (SteinhartHart, error) {
+	if r1 <= 0 || r2 <= 0 || r3 <= 0 {
+		return SteinhartHart{}, errors.New("thermistor resistances must be positive")
+	}
+	l1, l2, l3 := math.Log(r1), math.Log(r2), math.Log(r3)
+	y1, y2, y3 := 1/t1.Kelvin(), 1/t2.Kelvin(), 1/t3.Kelvin()
+	if l1 == l2 || l2 == l3 || l1 == l3 {
+		return SteinhartHart{}, errors.New("thermistor resistances must be different")
+	}
+	g2 := (y2 - y1) / (l2 - l1)
+	g3 := (y3 - y1) / (l3 - l1)
+	c := (g3 - g2) / (l3 - l2) / (l1 + l2 + l3)
+	b := g2 - c*(l1*l1+l1*l2+l2*l2)
+	a := y1 - (b+l1*l1*c)*l1
+	return SteinhartHart{A: a, B: b, C: c}, nil
+}
+
+// Return the temperature of a thermistor with a resistance in ohms.
+func (sh SteinhartHart) Temperature(ohms float64) Temperature {
+	l := math.Log(ohms)
+	return Kelvin(1 / (sh.A + sh.B*l + sh.C*l*l*l))
+}
+
+// An NTC thermistor read through a divider. It is a Thermometer.
+type Thermistor struct {
+	Divider      Divider
+	Coefficients SteinhartHart
+}
+
+// Read the thermistor's temperature.
+func (t Thermistor) ReadTemperature() (Temperature, error) {
+	ohms, e := t.Divider.ReadResistance()
+	if e != nil {
+		return 0, e
+	}
+	return t.Coefficients.Temperature(ohms), nil
+}
+
+// A light dependent resistor read through a divider. Its resistance falls as the light increases, following a power
+// law whose exponent, gamma, datasheets give along with its resistance at 10 lux, e.g. a gamma of 0.7 and 10kΩ to
+// 20kΩ for a GL5528. LDRs vary a lot from one to the next, so the light level is only a rough guide.
+type LDR struct {
+	Divider Divider
+
+	// The resistance at 10 lux in ohms, and gamma
+	Ohms10Lux float64
+	Gamma     float64
+}
+
+// Read the approximate light level, in lux.
+func (l LDR) ReadLux() (float64, error) {
+	if l.Ohms10Lux <= 0 || l.Gamma <= 0 {
+		return 0, errors.New("LDR needs its resistance at 10 lux and gamma")
+	}
+	ohms, e := l.Divider.ReadResistance()
+	if e != nil {
+		return 0, e
+	}
+	return 10 * math.Pow(l.Ohms10Lux/ohms, 1/l.Gamma), nil
+}
+
+// A potentiometer with its ends across the supply and its wiper on an analog pin.
+type Potentiometer struct {
+	Pin Pin
+
+	// The voltage of one count of the analog pin, and the voltage across the potentiometer, as for a Divider.
+	VoltsPerCount float64
+	SupplyVolts   float64
+
+	// The values at either end of its travel, e.g. 0 and 270 for the angle of a 270° pot. If both are zero, Value is
+	// the position, 0 to 1.
+	Min float64
+	Max float64
+}
+
+// Convert a reading of the analog pin to the position of the wiper, from 0 to 1, and the value it represents. Readings
+// beyond the ends, from noise or a supply that differs from the ADC's reference, are taken as the ends.
+func (p Potentiometer) Convert(raw int) (position float64, value float64) {
+	if p.SupplyVolts > 0 {
+		position = math.Max(0, math.Min(1, float64(raw)*p.VoltsPerCount/p.SupplyVolts))
+	}
+	min, max := p.Min, p.Max
+	if min == 0 && max == 0 {
+		max = 1
+	}
+	return position, min + position*(max-min)
+}
+
+// Read the analog pin, and return the value the wiper's position represents.
+func (p Potentiometer) Read() (float64, error) {
+	if p.VoltsPerCount <= 0 || p.SupplyVolts <= 0 {
+		return 0, errors.New("potentiometer needs the volts per count and supply voltage")
+	}
+	raw, e := AnalogRead(p.Pin)
+	if e != nil {
+		return 0, e
+	}
+	_, value := p.Convert(raw)
+	return value, nil
+}
@@ -0,0 +1,1315 @@
+// The hwio service, for using the pins and buses of a board from another process or machine, in any language with a
+// gRPC implementation. Pins are given by name, as for hwio.GetPin, e.g. "P8.13" or "gpio17"; modules by name, e.g.
+// "i2c1".
+//
+// After changing this file, regenerate the Go and Python code as described in README.md.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: hwio.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PinIOMode int32
+
+const (
+	PinIOMode_MODE_INPUT           PinIOMode = 0
+	PinIOMode_MODE_OUTPUT          PinIOMode = 1
+	PinIOMode_MODE_INPUT_PULL_UP   PinIOMode = 2
+	PinIOMode_MODE_INPUT_PULL_DOWN PinIOMode = 3
+)
+
+// Enum value maps for PinIOMode.
+var (
+	PinIOMode_name = map[int32]string{
+		0: "MODE_INPUT",
+		1: "MODE_OUTPUT",
+		2: "MODE_INPUT_PULL_UP",
+		3: "MODE_INPUT_PULL_DOWN",
+	}
+	PinIOMode_value = map[string]int32{
+		"MODE_INPUT":           0,
+		"MODE_OUTPUT":          1,
+		"MODE_INPUT_PULL_UP":   2,
+		"MODE_INPUT_PULL_DOWN": 3,
+	}
+)
+
+func (x PinIOMode) Enum() *PinIOMode {
+	p := new(PinIOMode)
+	*p = x
+	return p
+}
+
+func (x PinIOMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PinIOMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_hwio_proto_enumTypes[0].Descriptor()
+}
+
+func (PinIOMode) Type() protoreflect.EnumType {
+	return &file_hwio_proto_enumTypes[0]
+}
+
+func (x PinIOMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PinIOMode.Descriptor instead.
+func (PinIOMode) EnumDescriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{0}
+}
+
+type Edge int32
+
+const (
+	Edge_EDGE_NONE    Edge = 0
+	Edge_EDGE_RISING  Edge = 1
+	Edge_EDGE_FALLING Edge = 2
+	Edge_EDGE_BOTH    Edge = 3
+)
+
+// Enum value maps for Edge.
+var (
+	Edge_name = map[int32]string{
+		0: "EDGE_NONE",
+		1: "EDGE_RISING",
+		2: "EDGE_FALLING",
+		3: "EDGE_BOTH",
+	}
+	Edge_value = map[string]int32{
+		"EDGE_NONE":    0,
+		"EDGE_RISING":  1,
+		"EDGE_FALLING": 2,
+		"EDGE_BOTH":    3,
+	}
+)
+
+func (x Edge) Enum() *Edge {
+	p := new(Edge)
+	*p = x
+	return p
+}
+
+func (x Edge) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Edge) Descriptor() protoreflect.EnumDescriptor {
+	return file_hwio_proto_enumTypes[1].Descriptor()
+}
+
+func (Edge) Type() protoreflect.EnumType {
+	return &file_hwio_proto_enumTypes[1]
+}
+
+func (x Edge) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Edge.Descriptor instead.
+func (Edge) EnumDescriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{1}
+}
+
+type PinModeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Mode          PinIOMode              `protobuf:"varint,2,opt,name=mode,proto3,enum=hwio.PinIOMode" json:"mode,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinModeRequest) Reset() {
+	*x = PinModeRequest{}
+	mi := &file_hwio_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinModeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinModeRequest) ProtoMessage() {}
+
+func (x *PinModeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinModeRequest.ProtoReflect.Descriptor instead.
+func (*PinModeRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PinModeRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *PinModeRequest) GetMode() PinIOMode {
+	if x != nil {
+		return x.Mode
+	}
+	return PinIOMode_MODE_INPUT
+}
+
+type PinModeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinModeResponse) Reset() {
+	*x = PinModeResponse{}
+	mi := &file_hwio_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinModeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinModeResponse) ProtoMessage() {}
+
+func (x *PinModeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinModeResponse.ProtoReflect.Descriptor instead.
+func (*PinModeResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{1}
+}
+
+type DigitalWriteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	High          bool                   `protobuf:"varint,2,opt,name=high,proto3" json:"high,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DigitalWriteRequest) Reset() {
+	*x = DigitalWriteRequest{}
+	mi := &file_hwio_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DigitalWriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DigitalWriteRequest) ProtoMessage() {}
+
+func (x *DigitalWriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DigitalWriteRequest.ProtoReflect.Descriptor instead.
+func (*DigitalWriteRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DigitalWriteRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *DigitalWriteRequest) GetHigh() bool {
+	if x != nil {
+		return x.High
+	}
+	return false
+}
+
+type DigitalWriteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DigitalWriteResponse) Reset() {
+	*x = DigitalWriteResponse{}
+	mi := &file_hwio_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DigitalWriteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DigitalWriteResponse) ProtoMessage() {}
+
+func (x *DigitalWriteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DigitalWriteResponse.ProtoReflect.Descriptor instead.
+func (*DigitalWriteResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{3}
+}
+
+type DigitalReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DigitalReadRequest) Reset() {
+	*x = DigitalReadRequest{}
+	mi := &file_hwio_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DigitalReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DigitalReadRequest) ProtoMessage() {}
+
+func (x *DigitalReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DigitalReadRequest.ProtoReflect.Descriptor instead.
+func (*DigitalReadRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DigitalReadRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+type DigitalReadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	High          bool                   `protobuf:"varint,1,opt,name=high,proto3" json:"high,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DigitalReadResponse) Reset() {
+	*x = DigitalReadResponse{}
+	mi := &file_hwio_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DigitalReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DigitalReadResponse) ProtoMessage() {}
+
+func (x *DigitalReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DigitalReadResponse.ProtoReflect.Descriptor instead.
+func (*DigitalReadResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DigitalReadResponse) GetHigh() bool {
+	if x != nil {
+		return x.High
+	}
+	return false
+}
+
+type AnalogReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalogReadRequest) Reset() {
+	*x = AnalogReadRequest{}
+	mi := &file_hwio_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalogReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalogReadRequest) ProtoMessage() {}
+
+func (x *AnalogReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalogReadRequest.ProtoReflect.Descriptor instead.
+func (*AnalogReadRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AnalogReadRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+type AnalogReadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         int32                  `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalogReadResponse) Reset() {
+	*x = AnalogReadResponse{}
+	mi := &file_hwio_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalogReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalogReadResponse) ProtoMessage() {}
+
+func (x *AnalogReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalogReadResponse.ProtoReflect.Descriptor instead.
+func (*AnalogReadResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *AnalogReadResponse) GetValue() int32 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type SetPWMRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The PWM module, e.g. "pwm" or "pwm2".
+	Module        string `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	Pin           string `protobuf:"bytes,2,opt,name=pin,proto3" json:"pin,omitempty"`
+	PeriodNs      int64  `protobuf:"varint,3,opt,name=period_ns,json=periodNs,proto3" json:"period_ns,omitempty"`
+	DutyNs        int64  `protobuf:"varint,4,opt,name=duty_ns,json=dutyNs,proto3" json:"duty_ns,omitempty"`
+	Enabled       bool   `protobuf:"varint,5,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPWMRequest) Reset() {
+	*x = SetPWMRequest{}
+	mi := &file_hwio_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPWMRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPWMRequest) ProtoMessage() {}
+
+func (x *SetPWMRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPWMRequest.ProtoReflect.Descriptor instead.
+func (*SetPWMRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SetPWMRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *SetPWMRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *SetPWMRequest) GetPeriodNs() int64 {
+	if x != nil {
+		return x.PeriodNs
+	}
+	return 0
+}
+
+func (x *SetPWMRequest) GetDutyNs() int64 {
+	if x != nil {
+		return x.DutyNs
+	}
+	return 0
+}
+
+func (x *SetPWMRequest) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+type SetPWMResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPWMResponse) Reset() {
+	*x = SetPWMResponse{}
+	mi := &file_hwio_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPWMResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPWMResponse) ProtoMessage() {}
+
+func (x *SetPWMResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPWMResponse.ProtoReflect.Descriptor instead.
+func (*SetPWMResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{9}
+}
+
+type I2CReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	Address       uint32                 `protobuf:"varint,2,opt,name=address,proto3" json:"address,omitempty"`
+	Register      uint32                 `protobuf:"varint,3,opt,name=register,proto3" json:"register,omitempty"`
+	Length        uint32                 `protobuf:"varint,4,opt,name=length,proto3" json:"length,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *I2CReadRequest) Reset() {
+	*x = I2CReadRequest{}
+	mi := &file_hwio_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *I2CReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*I2CReadRequest) ProtoMessage() {}
+
+func (x *I2CReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use I2CReadRequest.ProtoReflect.Descriptor instead.
+func (*I2CReadRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *I2CReadRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *I2CReadRequest) GetAddress() uint32 {
+	if x != nil {
+		return x.Address
+	}
+	return 0
+}
+
+func (x *I2CReadRequest) GetRegister() uint32 {
+	if x != nil {
+		return x.Register
+	}
+	return 0
+}
+
+func (x *I2CReadRequest) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+type I2CReadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *I2CReadResponse) Reset() {
+	*x = I2CReadResponse{}
+	mi := &file_hwio_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *I2CReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*I2CReadResponse) ProtoMessage() {}
+
+func (x *I2CReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use I2CReadResponse.ProtoReflect.Descriptor instead.
+func (*I2CReadResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *I2CReadResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type I2CWriteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	Address       uint32                 `protobuf:"varint,2,opt,name=address,proto3" json:"address,omitempty"`
+	Register      uint32                 `protobuf:"varint,3,opt,name=register,proto3" json:"register,omitempty"`
+	Data          []byte                 `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *I2CWriteRequest) Reset() {
+	*x = I2CWriteRequest{}
+	mi := &file_hwio_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *I2CWriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*I2CWriteRequest) ProtoMessage() {}
+
+func (x *I2CWriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use I2CWriteRequest.ProtoReflect.Descriptor instead.
+func (*I2CWriteRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *I2CWriteRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *I2CWriteRequest) GetAddress() uint32 {
+	if x != nil {
+		return x.Address
+	}
+	return 0
+}
+
+func (x *I2CWriteRequest) GetRegister() uint32 {
+	if x != nil {
+		return x.Register
+	}
+	return 0
+}
+
+func (x *I2CWriteRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type I2CWriteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *I2CWriteResponse) Reset() {
+	*x = I2CWriteResponse{}
+	mi := &file_hwio_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *I2CWriteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*I2CWriteResponse) ProtoMessage() {}
+
+func (x *I2CWriteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use I2CWriteResponse.ProtoReflect.Descriptor instead.
+func (*I2CWriteResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{13}
+}
+
+type SPIWriteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	SlaveSelect   int32                  `protobuf:"varint,2,opt,name=slave_select,json=slaveSelect,proto3" json:"slave_select,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SPIWriteRequest) Reset() {
+	*x = SPIWriteRequest{}
+	mi := &file_hwio_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SPIWriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SPIWriteRequest) ProtoMessage() {}
+
+func (x *SPIWriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SPIWriteRequest.ProtoReflect.Descriptor instead.
+func (*SPIWriteRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SPIWriteRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *SPIWriteRequest) GetSlaveSelect() int32 {
+	if x != nil {
+		return x.SlaveSelect
+	}
+	return 0
+}
+
+func (x *SPIWriteRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type SPIWriteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SPIWriteResponse) Reset() {
+	*x = SPIWriteResponse{}
+	mi := &file_hwio_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SPIWriteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SPIWriteResponse) ProtoMessage() {}
+
+func (x *SPIWriteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SPIWriteResponse.ProtoReflect.Descriptor instead.
+func (*SPIWriteResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{15}
+}
+
+type SPIReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Module        string                 `protobuf:"bytes,1,opt,name=module,proto3" json:"module,omitempty"`
+	SlaveSelect   int32                  `protobuf:"varint,2,opt,name=slave_select,json=slaveSelect,proto3" json:"slave_select,omitempty"`
+	Length        uint32                 `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SPIReadRequest) Reset() {
+	*x = SPIReadRequest{}
+	mi := &file_hwio_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SPIReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SPIReadRequest) ProtoMessage() {}
+
+func (x *SPIReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SPIReadRequest.ProtoReflect.Descriptor instead.
+func (*SPIReadRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *SPIReadRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *SPIReadRequest) GetSlaveSelect() int32 {
+	if x != nil {
+		return x.SlaveSelect
+	}
+	return 0
+}
+
+func (x *SPIReadRequest) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+type SPIReadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SPIReadResponse) Reset() {
+	*x = SPIReadResponse{}
+	mi := &file_hwio_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SPIReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SPIReadResponse) ProtoMessage() {}
+
+func (x *SPIReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SPIReadResponse.ProtoReflect.Descriptor instead.
+func (*SPIReadResponse) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *SPIReadResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type WatchEdgesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Edge          Edge                   `protobuf:"varint,2,opt,name=edge,proto3,enum=hwio.Edge" json:"edge,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEdgesRequest) Reset() {
+	*x = WatchEdgesRequest{}
+	mi := &file_hwio_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEdgesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEdgesRequest) ProtoMessage() {}
+
+func (x *WatchEdgesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEdgesRequest.ProtoReflect.Descriptor instead.
+func (*WatchEdgesRequest) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *WatchEdgesRequest) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *WatchEdgesRequest) GetEdge() Edge {
+	if x != nil {
+		return x.Edge
+	}
+	return Edge_EDGE_NONE
+}
+
+type EdgeEvent struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Pin    string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Rising bool                   `protobuf:"varint,2,opt,name=rising,proto3" json:"rising,omitempty"`
+	// When the edge happened, in nanoseconds on the board's monotonic clock.
+	TimestampNs int64 `protobuf:"varint,3,opt,name=timestamp_ns,json=timestampNs,proto3" json:"timestamp_ns,omitempty"`
+	// The sequence number of the edge on the pin, starting at 1. A gap means events were dropped.
+	Seq           uint32 `protobuf:"varint,4,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EdgeEvent) Reset() {
+	*x = EdgeEvent{}
+	mi := &file_hwio_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EdgeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EdgeEvent) ProtoMessage() {}
+
+func (x *EdgeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_hwio_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EdgeEvent.ProtoReflect.Descriptor instead.
+func (*EdgeEvent) Descriptor() ([]byte, []int) {
+	return file_hwio_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *EdgeEvent) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *EdgeEvent) GetRising() bool {
+	if x != nil {
+		return x.Rising
+	}
+	return false
+}
+
+func (x *EdgeEvent) GetTimestampNs() int64 {
+	if x != nil {
+		return x.TimestampNs
+	}
+	return 0
+}
+
+func (x *EdgeEvent) GetSeq() uint32 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+var File_hwio_proto protoreflect.FileDescriptor
+
+const file_hwio_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"hwio.proto\x12\x04hwio\"G\n" +
+	"\x0ePinModeRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12#\n" +
+	"\x04mode\x18\x02 \x01(\x0e2\x0f.hwio.PinIOModeR\x04mode\"\x11\n" +
+	"\x0fPinModeResponse\";\n" +
+	"\x13DigitalWriteRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x12\n" +
+	"\x04high\x18\x02 \x01(\bR\x04high\"\x16\n" +
+	"\x14DigitalWriteResponse\"&\n" +
+	"\x12DigitalReadRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\")\n" +
+	"\x13DigitalReadResponse\x12\x12\n" +
+	"\x04high\x18\x01 \x01(\bR\x04high\"%\n" +
+	"\x11AnalogReadRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\"*\n" +
+	"\x12AnalogReadResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\x05R\x05value\"\x89\x01\n" +
+	"\rSetPWMRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12\x10\n" +
+	"\x03pin\x18\x02 \x01(\tR\x03pin\x12\x1b\n" +
+	"\tperiod_ns\x18\x03 \x01(\x03R\bperiodNs\x12\x17\n" +
+	"\aduty_ns\x18\x04 \x01(\x03R\x06dutyNs\x12\x18\n" +
+	"\aenabled\x18\x05 \x01(\bR\aenabled\"\x10\n" +
+	"\x0eSetPWMResponse\"v\n" +
+	"\x0eI2CReadRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\rR\aaddress\x12\x1a\n" +
+	"\bregister\x18\x03 \x01(\rR\bregister\x12\x16\n" +
+	"\x06length\x18\x04 \x01(\rR\x06length\"%\n" +
+	"\x0fI2CReadResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"s\n" +
+	"\x0fI2CWriteRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\rR\aaddress\x12\x1a\n" +
+	"\bregister\x18\x03 \x01(\rR\bregister\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\fR\x04data\"\x12\n" +
+	"\x10I2CWriteResponse\"`\n" +
+	"\x0fSPIWriteRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12!\n" +
+	"\fslave_select\x18\x02 \x01(\x05R\vslaveSelect\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\"\x12\n" +
+	"\x10SPIWriteResponse\"c\n" +
+	"\x0eSPIReadRequest\x12\x16\n" +
+	"\x06module\x18\x01 \x01(\tR\x06module\x12!\n" +
+	"\fslave_select\x18\x02 \x01(\x05R\vslaveSelect\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\rR\x06length\"%\n" +
+	"\x0fSPIReadResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"E\n" +
+	"\x11WatchEdgesRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x1e\n" +
+	"\x04edge\x18\x02 \x01(\x0e2\n" +
+	".hwio.EdgeR\x04edge\"j\n" +
+	"\tEdgeEvent\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x16\n" +
+	"\x06rising\x18\x02 \x01(\bR\x06rising\x12!\n" +
+	"\ftimestamp_ns\x18\x03 \x01(\x03R\vtimestampNs\x12\x10\n" +
+	"\x03seq\x18\x04 \x01(\rR\x03seq*^\n" +
+	"\tPinIOMode\x12\x0e\n" +
+	"\n" +
+	"MODE_INPUT\x10\x00\x12\x0f\n" +
+	"\vMODE_OUTPUT\x10\x01\x12\x16\n" +
+	"\x12MODE_INPUT_PULL_UP\x10\x02\x12\x18\n" +
+	"\x14MODE_INPUT_PULL_DOWN\x10\x03*G\n" +
+	"\x04Edge\x12\r\n" +
+	"\tEDGE_NONE\x10\x00\x12\x0f\n" +
+	"\vEDGE_RISING\x10\x01\x12\x10\n" +
+	"\fEDGE_FALLING\x10\x02\x12\r\n" +
+	"\tEDGE_BOTH\x10\x032\xdf\x04\n" +
+	"\x04Hwio\x126\n" +
+	"\aPinMode\x12\x14.hwio.PinModeRequest\x1a\x15.hwio.PinModeResponse\x12E\n" +
+	"\fDigitalWrite\x12\x19.hwio.DigitalWriteRequest\x1a\x1a.hwio.DigitalWriteResponse\x12B\n" +
+	"\vDigitalRead\x12\x18.hwio.DigitalReadRequest\x1a\x19.hwio.DigitalReadResponse\x12?\n" +
+	"\n" +
+	"AnalogRead\x12\x17.hwio.AnalogReadRequest\x1a\x18.hwio.AnalogReadResponse\x123\n" +
+	"\x06SetPWM\x12\x13.hwio.SetPWMRequest\x1a\x14.hwio.SetPWMResponse\x126\n" +
+	"\aI2CRead\x12\x14.hwio.I2CReadRequest\x1a\x15.hwio.I2CReadResponse\x129\n" +
+	"\bI2CWrite\x12\x15.hwio.I2CWriteRequest\x1a\x16.hwio.I2CWriteResponse\x129\n" +
+	"\bSPIWrite\x12\x15.hwio.SPIWriteRequest\x1a\x16.hwio.SPIWriteResponse\x126\n" +
+	"\aSPIRead\x12\x14.hwio.SPIReadRequest\x1a\x15.hwio.SPIReadResponse\x128\n" +
+	"\n" +
+	"WatchEdges\x12\x17.hwio.WatchEdgesRequest\x1a\x0f.hwio.EdgeEvent0\x01B Z\x1egithub.com/cinellodev/hwio/rpcb\x06proto3"
+
+var (
+	file_hwio_proto_rawDescOnce sync.Once
+	file_hwio_proto_rawDescData []byte
+)
+
+func file_hwio_proto_rawDescGZIP() []byte {
+	file_hwio_proto_rawDescOnce.Do(func() {
+		file_hwio_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_hwio_proto_rawDesc), len(file_hwio_proto_rawDesc)))
+	})
+	return file_hwio_proto_rawDescData
+}
+
+var file_hwio_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_hwio_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_hwio_proto_goTypes = []any{
+	(PinIOMode)(0),               // 0: hwio.PinIOMode
+	(Edge)(0),                    // 1: hwio.Edge
+	(*PinModeRequest)(nil),       // 2: hwio.PinModeRequest
+	(*PinModeResponse)(nil),      // 3: hwio.PinModeResponse
+	(*DigitalWriteRequest)(nil),  // 4: hwio.DigitalWriteRequest
+	(*DigitalWriteResponse)(nil), // 5: hwio.DigitalWriteResponse
+	(*DigitalReadRequest)(nil),   // 6: hwio.DigitalReadRequest
+	(*DigitalReadResponse)(nil),  // 7: hwio.DigitalReadResponse
+	(*AnalogReadRequest)(nil),    // 8: hwio.AnalogReadRequest
+	(*AnalogReadResponse)(nil),   // 9: hwio.AnalogReadResponse
+	(*SetPWMRequest)(nil),        // 10: hwio.SetPWMRequest
+	(*SetPWMResponse)(nil),       // 11: hwio.SetPWMResponse
+	(*I2CReadRequest)(nil),       // 12: hwio.I2CReadRequest
+	(*I2CReadResponse)(nil),      // 13: hwio.I2CReadResponse
+	(*I2CWriteRequest)(nil),      // 14: hwio.I2CWriteRequest
+	(*I2CWriteResponse)(nil),     // 15: hwio.I2CWriteResponse
+	(*SPIWriteRequest)(nil),      // 16: hwio.SPIWriteRequest
+	(*SPIWriteResponse)(nil),     // 17: hwio.SPIWriteResponse
+	(*SPIReadRequest)(nil),       // 18: hwio.SPIReadRequest
+	(*SPIReadResponse)(nil),      // 19: hwio.SPIReadResponse
+	(*WatchEdgesRequest)(nil),    // 20: hwio.WatchEdgesRequest
+	(*EdgeEvent)(nil),            // 21: hwio.EdgeEvent
+}
+var file_hwio_proto_depIdxs = []int32{
+	0,  // 0: hwio.PinModeRequest.mode:type_name -> hwio.PinIOMode
+	1,  // 1: hwio.WatchEdgesRequest.edge:type_name -> hwio.Edge
+	2,  // 2: hwio.Hwio.PinMode:input_type -> hwio.PinModeRequest
+	4,  // 3: hwio.Hwio.DigitalWrite:input_type -> hwio.DigitalWriteRequest
+	6,  // 4: hwio.Hwio.DigitalRead:input_type -> hwio.DigitalReadRequest
+	8,  // 5: hwio.Hwio.AnalogRead:input_type -> hwio.AnalogReadRequest
+	10, // 6: hwio.Hwio.SetPWM:input_type -> hwio.SetPWMRequest
+	12, // 7: hwio.Hwio.I2CRead:input_type -> hwio.I2CReadRequest
+	14, // 8: hwio.Hwio.I2CWrite:input_type -> hwio.I2CWriteRequest
+	16, // 9: hwio.Hwio.SPIWrite:input_type -> hwio.SPIWriteRequest
+	18, // 10: hwio.Hwio.SPIRead:input_type -> hwio.SPIReadRequest
+	20, // 11: hwio.Hwio.WatchEdges:input_type -> hwio.WatchEdgesRequest
+	3,  // 12: hwio.Hwio.PinMode:output_type -> hwio.PinModeResponse
+	5,  // 13: hwio.Hwio.DigitalWrite:output_type -> hwio.DigitalWriteResponse
+	7,  // 14: hwio.Hwio.DigitalRead:output_type -> hwio.DigitalReadResponse
+	9,  // 15: hwio.Hwio.AnalogRead:output_type -> hwio.AnalogReadResponse
+	11, // 16: hwio.Hwio.SetPWM:output_type -> hwio.SetPWMResponse
+	13, // 17: hwio.Hwio.I2CRead:output_type -> hwio.I2CReadResponse
+	15, // 18: hwio.Hwio.I2CWrite:output_type -> hwio.I2CWriteResponse
+	17, // 19: hwio.Hwio.SPIWrite:output_type -> hwio.SPIWriteResponse
+	19, // 20: hwio.Hwio.SPIRead:output_type -> hwio.SPIReadResponse
+	21, // 21: hwio.Hwio.WatchEdges:output_type -> hwio.EdgeEvent
+	12, // [12:22] is the sub-list for method output_type
+	2,  // [2:12] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_hwio_proto_init() }
+func file_hwio_proto_init() {
+	if File_hwio_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_hwio_proto_rawDesc), len(file_hwio_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   20,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_hwio_proto_goTypes,
+		DependencyIndexes: file_hwio_proto_depIdxs,
+		EnumInfos:         file_hwio_proto_enumTypes,
+		MessageInfos:      file_hwio_proto_msgTypes,
+	}.Build()
+	File_hwio_proto = out.File
+	file_hwio_proto_goTypes = nil
+	file_hwio_proto_depIdxs = nil
+}
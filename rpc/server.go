@@ -0,0 +1,203 @@
+// A gRPC server for the Hwio service of hwio.proto, so programs in other processes, on other machines or in other
+// languages can use the pins and buses of a board:
+//
+//	listener, err := net.Listen("tcp", ":50051")
+//	s := grpc.NewServer()
+//	rpc.RegisterHwioServer(s, rpc.NewServer())
+//	s.Serve(listener)
+//
+// The I2C, SPI and PWM modules clients use must be enabled by the program first, as for any other use of them.
+// Clients are generated from hwio.proto. The Go client is in this package (NewHwioClient), and the Python client is
+// in the python directory.
+//
+// Current status:
+// - there is no authentication; use the transport credentials of grpc.NewServer, or only listen on a trusted network
+// - the server uses the default board, and doesn't release pins when a client goes away
+
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative hwio.proto
+//go:generate python3 -m grpc_tools.protoc -I. --python_out=python --grpc_python_out=python hwio.proto
+
+import (
+	"context"
+
+	"github.com/cinellodev/hwio"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Implements HwioServer with the package functions of hwio.
+type Server struct {
+	UnimplementedHwioServer
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+func (s *Server) PinMode(ctx context.Context, req *PinModeRequest) (*PinModeResponse, error) {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return nil, e
+	}
+	modes := map[PinIOMode]hwio.PinIOMode{
+		PinIOMode_MODE_INPUT:           hwio.Input,
+		PinIOMode_MODE_OUTPUT:          hwio.Output,
+		PinIOMode_MODE_INPUT_PULL_UP:   hwio.InputPullUp,
+		PinIOMode_MODE_INPUT_PULL_DOWN: hwio.InputPullDown,
+	}
+	mode, ok := modes[req.Mode]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown mode %d", req.Mode)
+	}
+	return &PinModeResponse{}, hwio.PinMode(pin, mode)
+}
+
+func (s *Server) DigitalWrite(ctx context.Context, req *DigitalWriteRequest) (*DigitalWriteResponse, error) {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return nil, e
+	}
+	value := hwio.Low
+	if req.High {
+		value = hwio.High
+	}
+	return &DigitalWriteResponse{}, hwio.DigitalWrite(pin, value)
+}
+
+func (s *Server) DigitalRead(ctx context.Context, req *DigitalReadRequest) (*DigitalReadResponse, error) {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return nil, e
+	}
+	value, e := hwio.DigitalRead(pin)
+	if e != nil {
+		return nil, e
+	}
+	return &DigitalReadResponse{High: value == hwio.High}, nil
+}
+
+func (s *Server) AnalogRead(ctx context.Context, req *AnalogReadRequest) (*AnalogReadResponse, error) {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return nil, e
+	}
+	value, e := hwio.AnalogRead(pin)
+	if e != nil {
+		return nil, e
+	}
+	return &AnalogReadResponse{Value: int32(value)}, nil
+}
+
+func (s *Server) SetPWM(ctx context.Context, req *SetPWMRequest) (*SetPWMResponse, error) {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return nil, e
+	}
+	m, e := hwio.GetPWMModule(req.Module)
+	if e != nil {
+		return nil, status.Error(codes.NotFound, e.Error())
+	}
+	e = m.SetPeriod(pin, req.PeriodNs)
+	if e == nil {
+		e = m.SetDuty(pin, req.DutyNs)
+	}
+	if e == nil {
+		e = m.EnablePin(pin, req.Enabled)
+	}
+	return &SetPWMResponse{}, e
+}
+
+func (s *Server) I2CRead(ctx context.Context, req *I2CReadRequest) (*I2CReadResponse, error) {
+	device, e := getI2CDevice(req.Module, req.Address)
+	if e != nil {
+		return nil, e
+	}
+	data, e := device.Read(byte(req.Register), int(req.Length))
+	if e != nil {
+		return nil, e
+	}
+	return &I2CReadResponse{Data: data}, nil
+}
+
+func (s *Server) I2CWrite(ctx context.Context, req *I2CWriteRequest) (*I2CWriteResponse, error) {
+	device, e := getI2CDevice(req.Module, req.Address)
+	if e != nil {
+		return nil, e
+	}
+	return &I2CWriteResponse{}, device.Write(byte(req.Register), req.Data)
+}
+
+func (s *Server) SPIWrite(ctx context.Context, req *SPIWriteRequest) (*SPIWriteResponse, error) {
+	m, e := hwio.GetSPIModule(req.Module)
+	if e != nil {
+		return nil, status.Error(codes.NotFound, e.Error())
+	}
+	return &SPIWriteResponse{}, m.Write(int(req.SlaveSelect), req.Data)
+}
+
+func (s *Server) SPIRead(ctx context.Context, req *SPIReadRequest) (*SPIReadResponse, error) {
+	m, e := hwio.GetSPIModule(req.Module)
+	if e != nil {
+		return nil, status.Error(codes.NotFound, e.Error())
+	}
+	data := make([]byte, req.Length)
+	n, e := m.Read(int(req.SlaveSelect), data)
+	if e != nil {
+		return nil, e
+	}
+	return &SPIReadResponse{Data: data[:n]}, nil
+}
+
+// Send the edges of a pin until the client cancels the call, then stop watching the pin.
+func (s *Server) WatchEdges(req *WatchEdgesRequest, stream grpc.ServerStreamingServer[EdgeEvent]) error {
+	pin, e := getPin(req.Pin)
+	if e != nil {
+		return e
+	}
+	edges := map[Edge]hwio.Edge{Edge_EDGE_RISING: hwio.EdgeRising, Edge_EDGE_FALLING: hwio.EdgeFalling, Edge_EDGE_BOTH: hwio.EdgeBoth}
+	edge, ok := edges[req.Edge]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown edge %d", req.Edge)
+	}
+
+	events, e := hwio.WatchEdges(pin, edge)
+	if e != nil {
+		return status.Error(codes.FailedPrecondition, e.Error())
+	}
+	defer hwio.StopWatchingEdges(pin)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			e = stream.Send(&EdgeEvent{Pin: req.Pin, Rising: event.Rising, TimestampNs: int64(event.Timestamp), Seq: event.Seq})
+			if e != nil {
+				return e
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Look up a pin by name, returning a NotFound error if there isn't one.
+func getPin(name string) (hwio.Pin, error) {
+	pin, e := hwio.GetPin(name)
+	if e != nil {
+		return 0, status.Error(codes.NotFound, e.Error())
+	}
+	return pin, nil
+}
+
+func getI2CDevice(module string, address uint32) (hwio.I2CDevice, error) {
+	m, e := hwio.GetI2CModule(module)
+	if e != nil {
+		return nil, status.Error(codes.NotFound, e.Error())
+	}
+	return m.GetDevice(int(address)), nil
+}
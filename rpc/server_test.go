@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cinellodev/hwio"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Start a server on an in-memory listener, returning a client connected to it.
+func startServer(t *testing.T) HwioClient {
+	listener := bufconn.Listen(1 << 16)
+	s := grpc.NewServer()
+	RegisterHwioServer(s, NewServer())
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	dial := func(context.Context, string) (net.Conn, error) { return listener.Dial() }
+	conn, e := grpc.NewClient("passthrough:///bufconn", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if e != nil {
+		t.Fatalf("could not connect: %s", e)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewHwioClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	client := startServer(t)
+	ctx := context.Background()
+
+	_, e := client.PinMode(ctx, &PinModeRequest{Pin: "gpio1", Mode: PinIOMode_MODE_OUTPUT})
+	if e != nil {
+		t.Fatalf("PinMode failed: %s", e)
+	}
+	_, e = client.DigitalWrite(ctx, &DigitalWriteRequest{Pin: "gpio1", High: true})
+	if e != nil {
+		t.Fatalf("DigitalWrite failed: %s", e)
+	}
+	r, e := client.DigitalRead(ctx, &DigitalReadRequest{Pin: "gpio1"})
+	if e != nil || !r.High {
+		t.Errorf("DigitalRead returned %v, %v, expected high", r, e)
+	}
+	pin, _ := hwio.GetPin("gpio1")
+	if v, _ := hwio.DigitalRead(pin); v != hwio.High {
+		t.Errorf("pin is %d, expected High", v)
+	}
+
+	_, e = client.DigitalWrite(ctx, &DigitalWriteRequest{Pin: "nope"})
+	if status.Code(e) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown pin, got %v", e)
+	}
+	_, e = client.I2CRead(ctx, &I2CReadRequest{Module: "i2c9", Address: 0x48, Length: 1})
+	if status.Code(e) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown module, got %v", e)
+	}
+
+	// edges can't be watched on an output, which is reported when the stream is read
+	stream, e := client.WatchEdges(ctx, &WatchEdgesRequest{Pin: "gpio1", Edge: Edge_EDGE_BOTH})
+	if e == nil {
+		_, e = stream.Recv()
+	}
+	if status.Code(e) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition watching an output, got %v", e)
+	}
+}
@@ -0,0 +1,364 @@
+package hwio
+
+// Real-time clocks, and pin actions scheduled for a time of day. An RTC reads a Linux RTC device through
+// /sys/class/rtc, and can set its wake alarm, so that a battery powered data logger can suspend between readings and
+// be woken by the RTC. A Scheduler runs sequences (see Sequencer) at set times, or at intervals, and keeps the RTC's
+// wake alarm set to its next action, so the actions still happen if the system is suspended in between.
+//
+// Go's timers run on the monotonic clock, which stops while the system is suspended, so a Scheduler checks the wall
+// clock at least once a second rather than waiting for a timer. An action whose time passed while the system was
+// suspended runs as soon as it wakes; an action repeating at an interval runs once, however many intervals were
+// missed.
+//
+// Known issues:
+// - the RTC's time is taken as UTC, which is usual on Linux, but not on systems that dual boot Windows
+// - only one wake alarm can be set on an RTC, so only one Scheduler should use it
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rtcSysfsPath = "/sys/class/rtc"
+var powerStatePath = "/sys/power/state"
+
+// How often a Scheduler checks the wall clock.
+var scheduleCheckInterval = time.Second
+
+// A real-time clock device.
+type RTC struct {
+	name string
+	path string
+}
+
+// Open an RTC device by name, e.g. "rtc0", or the first RTC if name is "".
+func OpenRTC(name string) (*RTC, error) {
+	if name == "" {
+		name = "rtc0"
+	}
+	path := rtcSysfsPath + "/" + name
+	if !fileExists(path + "/since_epoch") {
+		return nil, fmt.Errorf("no RTC named '%s'", name)
+	}
+	return &RTC{name: name, path: path}, nil
+}
+
+// Return the name of the RTC.
+func (r *RTC) Name() string {
+	return r.name
+}
+
+// Return the time the RTC holds, to the second.
+func (r *RTC) Now() (time.Time, error) {
+	s, e := r.readSeconds("since_epoch")
+	if e != nil {
+		return time.Time{}, e
+	}
+	return time.Unix(s, 0), nil
+}
+
+// Determine if the RTC can wake the system.
+func (r *RTC) CanWake() bool {
+	return fileExists(r.path + "/wakealarm")
+}
+
+// Set the RTC to wake the system at a time, replacing any alarm already set. The alarm is to the second, rounded up.
+func (r *RTC) SetWakeAlarm(t time.Time) error {
+	if !r.CanWake() {
+		return fmt.Errorf("RTC '%s' can't wake the system", r.name)
+	}
+	if !t.After(clock.Now()) {
+		return errors.New("wake alarm must be in the future")
+	}
+	// an alarm that is already set must be cleared before another can be set
+	e := WriteStringToFile(r.path+"/wakealarm", "0")
+	if e != nil {
+		return e
+	}
+	return WriteStringToFile(r.path+"/wakealarm", strconv.FormatInt(t.Add(time.Second-1).Unix(), 10))
+}
+
+// Return the time the RTC's wake alarm is set for, and false if it isn't set.
+func (r *RTC) WakeAlarm() (time.Time, bool, error) {
+	if !r.CanWake() {
+		return time.Time{}, false, nil
+	}
+	b, e := ioutil.ReadFile(r.path + "/wakealarm")
+	if e != nil {
+		return time.Time{}, false, e
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	s, e := strconv.ParseInt(value, 10, 64)
+	if e != nil {
+		return time.Time{}, false, e
+	}
+	return time.Unix(s, 0), true, nil
+}
+
+// Clear the RTC's wake alarm.
+func (r *RTC) ClearWakeAlarm() error {
+	if !r.CanWake() {
+		return nil
+	}
+	return WriteStringToFile(r.path+"/wakealarm", "0")
+}
+
+// Set the wake alarm, and suspend the system to memory until then. This returns once the system has woken, by the
+// alarm or by anything else that can wake it. Suspending needs root.
+func (r *RTC) SuspendUntil(t time.Time) error {
+	e := r.SetWakeAlarm(t)
+	if e != nil {
+		return e
+	}
+	return WriteStringToFile(powerStatePath, "mem")
+}
+
+func (r *RTC) readSeconds(file string) (int64, error) {
+	b, e := ioutil.ReadFile(r.path + "/" + file)
+	if e != nil {
+		return 0, e
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// An action of a Scheduler.
+type scheduledAction struct {
+	id    int
+	at    time.Time
+	every time.Duration
+	seq   *Sequencer
+}
+
+// Runs sequences at times of day. See the top of this file.
+type Scheduler struct {
+	rtc     *RTC
+	changed chan bool
+	stop    chan bool
+	done    chan bool
+
+	// guards actions, running, nextID and err
+	sync.Mutex
+	actions []*scheduledAction
+	running []*Sequencer
+	nextID  int
+	err     error
+}
+
+// Create a scheduler. If rtc is not nil and can wake the system, its wake alarm is kept set to the next action.
+func NewScheduler(rtc *RTC) *Scheduler {
+	if rtc != nil && !rtc.CanWake() {
+		rtc = nil
+	}
+	s := &Scheduler{rtc: rtc, changed: make(chan bool, 1), stop: make(chan bool), done: make(chan bool)}
+	go s.run()
+	return s
+}
+
+// Run steps at a time, returning an id for Cancel. The pins the steps use must already be outputs.
+func (s *Scheduler) At(t time.Time, steps ...SequenceStep) (int, error) {
+	return s.add(t, 0, steps)
+}
+
+// Run steps at a time, then every interval after it, returning an id for Cancel. If the steps are still running
+// from the last time, that time is skipped.
+func (s *Scheduler) Every(first time.Time, interval time.Duration, steps ...SequenceStep) (int, error) {
+	if interval <= 0 {
+		return 0, errors.New("Every needs a positive interval")
+	}
+	return s.add(first, interval, steps)
+}
+
+func (s *Scheduler) add(t time.Time, every time.Duration, steps []SequenceStep) (int, error) {
+	seq, e := NewSequencer(steps...)
+	if e != nil {
+		return 0, e
+	}
+
+	s.Lock()
+	s.nextID++
+	a := &scheduledAction{id: s.nextID, at: t.Round(0), every: every, seq: seq}
+	s.actions = append(s.actions, a)
+	s.sortActions()
+	s.Unlock()
+
+	s.notify()
+	return a.id, nil
+}
+
+// Cancel an action, returning false if there is no action with that id, e.g. because it has already run. A sequence
+// that is running is left to finish.
+func (s *Scheduler) Cancel(id int) bool {
+	s.Lock()
+	found := false
+	for i, a := range s.actions {
+		if a.id == id {
+			s.actions = append(s.actions[:i], s.actions[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.Unlock()
+
+	if found {
+		s.notify()
+	}
+	return found
+}
+
+// Return the time of the next action, and false if there are none.
+func (s *Scheduler) Next() (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.actions) == 0 {
+		return time.Time{}, false
+	}
+	return s.actions[0].at, true
+}
+
+// Return the last error from running an action or setting the wake alarm, if there was one.
+func (s *Scheduler) Err() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+// Stop the scheduler, stopping any sequences it is running, and clear the wake alarm. Returns the last error, as
+// Err does.
+func (s *Scheduler) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+
+	s.Lock()
+	running := s.running
+	s.running = nil
+	s.Unlock()
+	for _, seq := range running {
+		seq.Stop()
+	}
+	if s.rtc != nil {
+		s.setErr(s.rtc.ClearWakeAlarm())
+	}
+	return s.Err()
+}
+
+// Wake the scheduler's goroutine after the actions have changed.
+func (s *Scheduler) notify() {
+	select {
+	case s.changed <- true:
+	default:
+	}
+}
+
+func (s *Scheduler) setErr(e error) {
+	if e != nil {
+		s.Lock()
+		s.err = e
+		s.Unlock()
+	}
+}
+
+// Sort the actions by time. The caller must hold the lock.
+func (s *Scheduler) sortActions() {
+	sort.SliceStable(s.actions, func(i, j int) bool {
+		return s.actions[i].at.Before(s.actions[j].at)
+	})
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	var alarm time.Time
+	for {
+		// the wall clock, as the monotonic clock stops during suspend
+		now := clock.Now().Round(0)
+
+		s.Lock()
+		var due []*scheduledAction
+		for len(s.actions) > 0 && !s.actions[0].at.After(now) {
+			a := s.actions[0]
+			s.actions = s.actions[1:]
+			due = append(due, a)
+			if a.every > 0 {
+				for !a.at.After(now) {
+					a.at = a.at.Add(a.every)
+				}
+				s.actions = append(s.actions, a)
+			}
+		}
+		s.sortActions()
+		var next time.Time
+		if len(s.actions) > 0 {
+			next = s.actions[0].at
+		}
+		s.Unlock()
+
+		for _, a := range due {
+			s.start(a.seq)
+		}
+
+		if s.rtc != nil && !next.Equal(alarm) {
+			var e error
+			if next.IsZero() {
+				e = s.rtc.ClearWakeAlarm()
+			} else {
+				e = s.rtc.SetWakeAlarm(next)
+			}
+			s.setErr(e)
+			alarm = next
+		}
+
+		wait := scheduleCheckInterval
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		timer := clock.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C():
+		}
+	}
+}
+
+// Start an action's sequence, and collect its error when it finishes.
+func (s *Scheduler) start(seq *Sequencer) {
+	if seq.Running() {
+		return
+	}
+	e := seq.Start()
+	if e != nil {
+		s.setErr(e)
+		return
+	}
+
+	s.Lock()
+	s.running = append(s.running, seq)
+	s.Unlock()
+	go func() {
+		s.setErr(seq.Wait())
+		s.Lock()
+		for i, r := range s.running {
+			if r == seq {
+				s.running = append(s.running[:i], s.running[i+1:]...)
+				break
+			}
+		}
+		s.Unlock()
+	}()
+}
@@ -0,0 +1,398 @@
+// A small rules engine connecting inputs to outputs, for automation that would otherwise need a loop of its own:
+// "when the door switch opens, turn the light on for 5 minutes", or "when the battery voltage drops below 11.5V,
+// publish an event". Rules are made in code, or loaded from a JSON file with LoadRules.
+//
+// A rule is triggered by an edge on a digital input, which needs a GPIO module that supports edge detection, or by
+// an analog input crossing a threshold, which is sampled at an interval. When it triggers, it can set an output,
+// optionally for a time, and publish a named event on the engine's Events channel.
+
+package hwio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often an analog rule samples its input, if it doesn't say.
+const defaultRuleInterval = 100 * time.Millisecond
+
+type Rule struct {
+	// The input that triggers the rule, and the edge that triggers it. For an analog input, a rising edge is the
+	// value going above Threshold, and a falling edge it going below Threshold-Hysteresis.
+	When       Pin
+	Edge       Edge
+	Analog     bool
+	Threshold  int
+	Hysteresis int
+
+	// How often an analog input is sampled. Defaults to 100ms.
+	Interval time.Duration
+
+	// The output to set, if UseSet is true, and the level to set it to. If For is not zero, the output is set
+	// back to the opposite level after that long; if the rule triggers again in the meantime, the time restarts.
+	Set    Pin
+	UseSet bool
+	Value  int
+	For    time.Duration
+
+	// The name of an event to publish on the engine's Events channel, if not "".
+	Publish string
+}
+
+// An event published by a rule.
+type RuleEvent struct {
+	Name string
+
+	// The input that triggered the rule, whether it was a rising or falling edge, and the level (digital) or
+	// value (analog) of the input after it.
+	Pin    Pin
+	Rising bool
+	Value  int
+
+	Time time.Time
+}
+
+type RuleEngine struct {
+	// Events published by rules. This is closed when the engine is closed. Events are dropped if it's full, so it
+	// should be read promptly.
+	Events <-chan RuleEvent
+
+	rules  []Rule
+	events chan RuleEvent
+
+	// guards the outputs, timers and err, so rules triggered at the same time don't interleave their writes
+	sync.Mutex
+	timers map[int]*time.Timer
+	err    error
+
+	// the digital inputs being watched, and the goroutines handling inputs
+	watched []Pin
+	stop    chan bool
+	running sync.WaitGroup
+}
+
+// Start a rules engine. Digital inputs are set to Input and watched for edges. Outputs are set to Output, starting
+// Low, unless they are outputs already.
+func NewRuleEngine(rules ...Rule) (*RuleEngine, error) {
+	rules = append([]Rule(nil), rules...)
+	for i := range rules {
+		e := checkRule(&rules[i])
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	r := &RuleEngine{
+		rules:  rules,
+		events: make(chan RuleEvent, 64),
+		timers: make(map[int]*time.Timer),
+		stop:   make(chan bool),
+	}
+	r.Events = r.events
+
+	e := r.start()
+	if e != nil {
+		r.Close()
+		return nil, e
+	}
+	return r, nil
+}
+
+// Stop the engine, and close the Events channel. Outputs that a rule set for a time are set back now.
+func (r *RuleEngine) Close() error {
+	select {
+	case <-r.stop:
+		return nil
+	default:
+	}
+
+	close(r.stop)
+	for _, pin := range r.watched {
+		StopWatchingEdges(pin)
+	}
+	r.running.Wait()
+
+	r.Lock()
+	for i, timer := range r.timers {
+		if timer.Stop() {
+			r.setBack(i)
+		}
+	}
+	r.Unlock()
+
+	close(r.events)
+	return nil
+}
+
+// Return the last error from writing an output, or nil if there hasn't been one.
+func (r *RuleEngine) Err() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.err
+}
+
+// Set up the pins and start watching the inputs.
+func (r *RuleEngine) start() error {
+	edges := make(map[Pin]Edge)
+	for i, rule := range r.rules {
+		if rule.UseSet && !pinIsOutput(rule.Set) {
+			e := PinModeOutputInit(rule.Set, Low)
+			if e != nil {
+				return e
+			}
+		}
+
+		if rule.Analog {
+			r.running.Add(1)
+			go r.sampleAnalog(i)
+			continue
+		}
+		if edge, ok := edges[rule.When]; ok && edge != rule.Edge {
+			edges[rule.When] = EdgeBoth
+		} else {
+			edges[rule.When] = rule.Edge
+		}
+	}
+
+	for pin, edge := range edges {
+		e := PinMode(pin, Input)
+		if e != nil {
+			return e
+		}
+		events, e := WatchEdges(pin, edge)
+		if e != nil {
+			return e
+		}
+		r.watched = append(r.watched, pin)
+		r.running.Add(1)
+		go r.watchDigital(pin, events)
+	}
+	return nil
+}
+
+// Trigger the rules on a digital input from its edges, until the edges channel is closed.
+func (r *RuleEngine) watchDigital(pin Pin, events <-chan EdgeEvent) {
+	defer r.running.Done()
+
+	for event := range events {
+		for i, rule := range r.rules {
+			if !rule.Analog && rule.When == pin && edgeMatches(rule.Edge, event.Rising) {
+				value := Low
+				if event.Rising {
+					value = High
+				}
+				r.trigger(i, event.Rising, value)
+			}
+		}
+	}
+}
+
+// Sample the analog input of a rule, triggering it when the value crosses the threshold.
+func (r *RuleEngine) sampleAnalog(i int) {
+	defer r.running.Done()
+
+	rule := r.rules[i]
+	ticker := clock.NewTicker(rule.Interval)
+	defer ticker.Stop()
+
+	crossing := &analogCrossing{threshold: rule.Threshold, hysteresis: rule.Hysteresis}
+	for {
+		value, e := AnalogRead(rule.When)
+		if e == nil {
+			crossed, rising, _ := crossing.update(value)
+			if crossed && edgeMatches(rule.Edge, rising) {
+				r.trigger(i, rising, value)
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Carry out the actions of a rule.
+func (r *RuleEngine) trigger(i int, rising bool, value int) {
+	rule := r.rules[i]
+
+	if rule.UseSet {
+		r.Lock()
+		r.write(rule.Set, rule.Value)
+		if rule.For > 0 {
+			if timer := r.timers[i]; timer != nil {
+				timer.Stop()
+			}
+			var timer *time.Timer
+			timer = time.AfterFunc(rule.For, func() {
+				r.Lock()
+				defer r.Unlock()
+				// the rule may have been triggered again while this was waiting for the lock
+				if r.timers[i] == timer {
+					r.setBack(i)
+				}
+			})
+			r.timers[i] = timer
+		}
+		r.Unlock()
+	}
+
+	if rule.Publish != "" {
+		select {
+		case r.events <- RuleEvent{Name: rule.Publish, Pin: rule.When, Rising: rising, Value: value, Time: clock.Now()}:
+		default:
+		}
+	}
+}
+
+// Set the output of a rule back after its time. The engine must be locked.
+func (r *RuleEngine) setBack(i int) {
+	delete(r.timers, i)
+	r.write(r.rules[i].Set, Negate(r.rules[i].Value))
+}
+
+// Write an output, remembering the error if it fails. The engine must be locked.
+func (r *RuleEngine) write(pin Pin, value int) {
+	e := DigitalWrite(pin, value)
+	if e != nil {
+		r.err = e
+	}
+}
+
+// Determine if an edge of the given direction triggers a rule.
+func edgeMatches(edge Edge, rising bool) bool {
+	return edge == EdgeBoth || (edge == EdgeRising) == rising
+}
+
+// Determine if a pin has been set as an output.
+func pinIsOutput(pin Pin) bool {
+	a := assignedPins[pin]
+	return a != nil && a.modeSet && a.pinIOMode == Output
+}
+
+// Check a rule is complete, filling in defaults.
+func checkRule(rule *Rule) error {
+	if rule.Edge == EdgeNone {
+		return errors.New("rule needs an edge to trigger on")
+	}
+	if !rule.UseSet && rule.Publish == "" {
+		return errors.New("rule needs an output to set or an event to publish")
+	}
+	if rule.For < 0 || rule.Interval < 0 || rule.Hysteresis < 0 {
+		return errors.New("rule cannot have a negative time or hysteresis")
+	}
+	if rule.Analog && rule.Interval == 0 {
+		rule.Interval = defaultRuleInterval
+	}
+	return nil
+}
+
+// The JSON form of a rule in a rules file. Pins are given by name, levels as "high" or "low", and times as Go
+// durations, e.g. "5s".
+type ruleFileRule struct {
+	When       string `json:"when"`
+	Edge       string `json:"edge"`
+	Analog     bool   `json:"analog,omitempty"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Hysteresis int    `json:"hysteresis,omitempty"`
+	Interval   string `json:"interval,omitempty"`
+	Set        string `json:"set,omitempty"`
+	Value      string `json:"value,omitempty"`
+	For        string `json:"for,omitempty"`
+	Publish    string `json:"publish,omitempty"`
+}
+
+type ruleFile struct {
+	Rules []*ruleFileRule `json:"rules"`
+}
+
+// Load rules from a JSON file, for NewRuleEngine. The file looks like this:
+//
+//	{
+//	  "rules": [
+//	    {"when": "GPIO17", "edge": "rising", "set": "GPIO27", "value": "high", "for": "5s"},
+//	    {"when": "AIN0", "analog": true, "edge": "falling", "threshold": 1150, "hysteresis": 20,
+//	     "interval": "10s", "publish": "battery-low"}
+//	  ]
+//	}
+func LoadRules(path string) ([]Rule, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+
+	var file ruleFile
+	e = json.Unmarshal(b, &file)
+	if e != nil {
+		return nil, fmt.Errorf("rules file %s is not valid: %s", path, e)
+	}
+
+	rules := make([]Rule, 0, len(file.Rules))
+	for n, fr := range file.Rules {
+		rule, e := fr.rule()
+		if e != nil {
+			return nil, fmt.Errorf("rules file %s rule %d: %s", path, n+1, e)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Convert a rule from a rules file.
+func (fr *ruleFileRule) rule() (Rule, error) {
+	rule := Rule{Analog: fr.Analog, Threshold: fr.Threshold, Hysteresis: fr.Hysteresis, Publish: fr.Publish}
+
+	var e error
+	rule.When, e = GetPin(fr.When)
+	if e != nil {
+		return rule, e
+	}
+
+	switch strings.ToLower(fr.Edge) {
+	case "rising":
+		rule.Edge = EdgeRising
+	case "falling":
+		rule.Edge = EdgeFalling
+	case "both":
+		rule.Edge = EdgeBoth
+	default:
+		return rule, fmt.Errorf("edge '%s' is not 'rising', 'falling' or 'both'", fr.Edge)
+	}
+
+	if fr.Set != "" {
+		rule.Set, e = GetPin(fr.Set)
+		if e != nil {
+			return rule, e
+		}
+		rule.UseSet = true
+
+		switch strings.ToLower(fr.Value) {
+		case "high", "1":
+			rule.Value = High
+		case "low", "0":
+			rule.Value = Low
+		default:
+			return rule, fmt.Errorf("value '%s' is not 'high' or 'low'", fr.Value)
+		}
+	}
+
+	if fr.For != "" {
+		rule.For, e = time.ParseDuration(fr.For)
+		if e != nil {
+			return rule, e
+		}
+	}
+	if fr.Interval != "" {
+		rule.Interval, e = time.ParseDuration(fr.Interval)
+		if e != nil {
+			return rule, e
+		}
+	}
+	return rule, checkRule(&rule)
+}
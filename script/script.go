@@ -0,0 +1,417 @@
+// Pin logic from Starlark scripts (a dialect of Python, https://github.com/google/starlark-go), so the behaviour of
+// a deployed device can be changed by editing a file rather than rebuilding the program. A script sets up pins at its
+// top level, and registers handlers for edges and timers, which are called from goroutines until the script is
+// closed:
+//
+//	led = get_pin("P8.13")
+//	button = get_pin("P8.14")
+//	pin_mode(led, "output")
+//	pin_mode(button, "input_pullup")
+//
+//	def pressed(pin, value):
+//	    set_state("presses", get_state("presses", 0) + 1)
+//	    digital_write(led, HIGH)
+//	    after("5s", lambda: digital_write(led, LOW))
+//	    publish("pressed", get_state("presses"))
+//
+//	on_edge(button, "falling", pressed)
+//	every("10s", lambda: publish("temperature", analog_read("AIN0")))
+//
+// Pins are given by number, or by name as for hwio.GetPin, times as Go durations, e.g. "100ms", and edges as
+// "rising", "falling" or "both". Handlers are called one at a time, so they don't need to lock anything, but a
+// handler that takes a long time delays the others. Global variables can't be changed once the top level has run, so
+// handlers keep state with get_state and set_state. print writes to the log.
+//
+// Current status:
+// - a script can't be reloaded in place; Close it and Load it again
+// - handlers can only use the package functions, so a script can't talk to I2C, SPI or serial devices
+
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+	"go.starlark.net/starlark"
+)
+
+// An event published by a script.
+type Event struct {
+	Name  string
+	Value starlark.Value
+	Time  time.Time
+}
+
+type Script struct {
+	// Events published by the script. This is closed when the script is closed. Events are dropped if it's full, so
+	// it should be read promptly.
+	Events <-chan Event
+
+	name   string
+	events chan Event
+
+	// guards state and err, and is held while the script runs, so handlers run one at a time
+	sync.Mutex
+	state map[string]starlark.Value
+	err   error
+
+	// the inputs being watched, and the goroutines calling handlers
+	watched []hwio.Pin
+	stop    chan bool
+	running sync.WaitGroup
+}
+
+// Load a script from a file and run its top level.
+func Load(path string) (*Script, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	return Run(path, b)
+}
+
+// Run the top level of a script, for which name is the file name used in error messages. If it fails, whatever it
+// had started is stopped.
+func Run(name string, source []byte) (*Script, error) {
+	s := &Script{
+		name:   name,
+		events: make(chan Event, 64),
+		state:  make(map[string]starlark.Value),
+		stop:   make(chan bool),
+	}
+	s.Events = s.events
+
+	s.Lock()
+	_, e := starlark.ExecFile(s.thread(), name, source, s.builtins())
+	s.Unlock()
+	if e != nil {
+		s.Close()
+		return nil, e
+	}
+	return s, nil
+}
+
+// Stop calling the script's handlers, waiting for one that is running to return, and close the Events channel. Pins
+// are left as they are.
+func (s *Script) Close() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+
+	close(s.stop)
+	s.Lock()
+	watched := s.watched
+	s.Unlock()
+	for _, pin := range watched {
+		hwio.StopWatchingEdges(pin)
+	}
+	s.running.Wait()
+
+	close(s.events)
+	return nil
+}
+
+// Return the last error from a handler, or nil if there hasn't been one. Errors from the script include a
+// backtrace.
+func (s *Script) Err() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+// Return a thread to run the script on. Threads aren't shared between goroutines.
+func (s *Script) thread() *starlark.Thread {
+	return &starlark.Thread{
+		Name: s.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("%s: %s", s.name, msg)
+		},
+	}
+}
+
+// Call a handler, unless the script has been closed, remembering the error if it fails.
+func (s *Script) call(fn starlark.Callable, args ...starlark.Value) {
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+
+	_, e := starlark.Call(s.thread(), fn, args, nil)
+	if e != nil {
+		if evalError, ok := e.(*starlark.EvalError); ok {
+			e = errors.New(evalError.Backtrace())
+		}
+		s.err = e
+	}
+}
+
+// Return the functions and constants available to the script.
+func (s *Script) builtins() starlark.StringDict {
+	builtins := starlark.StringDict{
+		"HIGH": starlark.MakeInt(hwio.High),
+		"LOW":  starlark.MakeInt(hwio.Low),
+	}
+	for name, fn := range map[string]func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error){
+		"get_pin":       s.getPin,
+		"pin_mode":      s.pinMode,
+		"digital_write": s.digitalWrite,
+		"digital_read":  s.digitalRead,
+		"analog_write":  s.analogWrite,
+		"analog_read":   s.analogRead,
+		"on_edge":       s.onEdge,
+		"every":         s.every,
+		"after":         s.after,
+		"publish":       s.publish,
+		"get_state":     s.getState,
+		"set_state":     s.setState,
+	} {
+		builtins[name] = starlark.NewBuiltin(name, fn)
+	}
+	return builtins
+}
+
+// A pin argument, given by number or name.
+type pinArg hwio.Pin
+
+func (p *pinArg) Unpack(v starlark.Value) error {
+	switch v := v.(type) {
+	case starlark.Int:
+		n, e := starlark.AsInt32(v)
+		*p = pinArg(n)
+		return e
+	case starlark.String:
+		pin, e := hwio.GetPin(string(v))
+		*p = pinArg(pin)
+		return e
+	}
+	return fmt.Errorf("got %s, want a pin number or name", v.Type())
+}
+
+// A duration argument, given as a Go duration, e.g. "5s".
+type durationArg time.Duration
+
+func (d *durationArg) Unpack(v starlark.Value) error {
+	str, ok := starlark.AsString(v)
+	if !ok {
+		return fmt.Errorf("got %s, want a duration such as \"5s\"", v.Type())
+	}
+	duration, e := time.ParseDuration(str)
+	if e != nil {
+		return e
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration %s is not positive", str)
+	}
+	*d = durationArg(duration)
+	return nil
+}
+
+// get_pin(name) returns the number of a pin.
+func (s *Script) getPin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); e != nil {
+		return nil, e
+	}
+	pin, e := hwio.GetPin(name)
+	if e != nil {
+		return nil, e
+	}
+	return starlark.MakeInt(int(pin)), nil
+}
+
+// pin_mode(pin, mode) sets the mode of a pin: "input", "output", "input_pullup" or "input_pulldown".
+func (s *Script) pinMode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	var mode string
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &pin, &mode); e != nil {
+		return nil, e
+	}
+	modes := map[string]hwio.PinIOMode{
+		"input":          hwio.Input,
+		"output":         hwio.Output,
+		"input_pullup":   hwio.InputPullUp,
+		"input_pulldown": hwio.InputPullDown,
+	}
+	m, ok := modes[mode]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown mode '%s'", b.Name(), mode)
+	}
+	return starlark.None, hwio.PinMode(hwio.Pin(pin), m)
+}
+
+// digital_write(pin, value) sets an output HIGH or LOW.
+func (s *Script) digitalWrite(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	var value int
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &pin, &value); e != nil {
+		return nil, e
+	}
+	return starlark.None, hwio.DigitalWrite(hwio.Pin(pin), value)
+}
+
+// digital_read(pin) returns HIGH or LOW.
+func (s *Script) digitalRead(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pin); e != nil {
+		return nil, e
+	}
+	value, e := hwio.DigitalRead(hwio.Pin(pin))
+	if e != nil {
+		return nil, e
+	}
+	return starlark.MakeInt(value), nil
+}
+
+// analog_write(pin, value) sets the duty of a pin, from 0 to 255.
+func (s *Script) analogWrite(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	var value int
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &pin, &value); e != nil {
+		return nil, e
+	}
+	return starlark.None, hwio.AnalogWrite(hwio.Pin(pin), value)
+}
+
+// analog_read(pin) returns the value of an analog input.
+func (s *Script) analogRead(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pin); e != nil {
+		return nil, e
+	}
+	value, e := hwio.AnalogRead(hwio.Pin(pin))
+	if e != nil {
+		return nil, e
+	}
+	return starlark.MakeInt(value), nil
+}
+
+// on_edge(pin, edge, handler) calls handler(pin, value) on each edge of an input.
+func (s *Script) onEdge(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pin pinArg
+	var edgeName string
+	var handler starlark.Callable
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 3, &pin, &edgeName, &handler); e != nil {
+		return nil, e
+	}
+	edges := map[string]hwio.Edge{"rising": hwio.EdgeRising, "falling": hwio.EdgeFalling, "both": hwio.EdgeBoth}
+	edge, ok := edges[edgeName]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown edge '%s'", b.Name(), edgeName)
+	}
+
+	events, e := hwio.WatchEdges(hwio.Pin(pin), edge)
+	if e != nil {
+		return nil, e
+	}
+	s.watched = append(s.watched, hwio.Pin(pin))
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for event := range events {
+			value := hwio.Low
+			if event.Rising {
+				value = hwio.High
+			}
+			s.call(handler, starlark.MakeInt(int(pin)), starlark.MakeInt(value))
+		}
+	}()
+	return starlark.None, nil
+}
+
+// every(interval, handler) calls handler() at an interval.
+func (s *Script) every(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var interval durationArg
+	var handler starlark.Callable
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &interval, &handler); e != nil {
+		return nil, e
+	}
+
+	ticker := hwio.GetClock().NewTicker(time.Duration(interval))
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				s.call(handler)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return starlark.None, nil
+}
+
+// after(delay, handler) calls handler() once, after a delay.
+func (s *Script) after(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var delay durationArg
+	var handler starlark.Callable
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &delay, &handler); e != nil {
+		return nil, e
+	}
+
+	timer := hwio.GetClock().NewTimer(time.Duration(delay))
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			s.call(handler)
+		case <-s.stop:
+		}
+	}()
+	return starlark.None, nil
+}
+
+// publish(name, value=None) publishes an event on the script's Events channel.
+func (s *Script) publish(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var value starlark.Value = starlark.None
+	if e := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value?", &value); e != nil {
+		return nil, e
+	}
+	value.Freeze()
+	select {
+	case s.events <- Event{Name: name, Value: value, Time: hwio.GetClock().Now()}:
+	default:
+	}
+	return starlark.None, nil
+}
+
+// get_state(key, default=None) returns a value saved by set_state.
+func (s *Script) getState(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var def starlark.Value = starlark.None
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &def); e != nil {
+		return nil, e
+	}
+	if value, ok := s.state[key]; ok {
+		return value, nil
+	}
+	return def, nil
+}
+
+// set_state(key, value) saves a value for handlers that run later. The value can't be changed after it is saved.
+func (s *Script) setState(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var value starlark.Value
+	if e := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &key, &value); e != nil {
+		return nil, e
+	}
+	value.Freeze()
+	s.state[key] = value
+	return starlark.None, nil
+}
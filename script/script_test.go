@@ -0,0 +1,98 @@
+package script
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cinellodev/hwio"
+	"go.starlark.net/starlark"
+)
+
+const testScript = `
+led = get_pin("gpio1")
+pin_mode(led, "output")
+digital_write(led, HIGH)
+
+def tick():
+    n = get_state("ticks", 0) + 1
+    set_state("ticks", n)
+    digital_write(led, n % 2)
+    publish("tick", n)
+
+every("1s", tick)
+after("1500ms", lambda: publish("once"))
+`
+
+func TestScript(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	clock := hwio.NewFakeClock(time.Unix(0, 0))
+	hwio.SetClock(clock)
+	defer hwio.SetClock(nil)
+
+	s, e := Run("test.star", []byte(testScript))
+	if e != nil {
+		t.Fatalf("Run failed: %s", e)
+	}
+	led, _ := hwio.GetPin("gpio1")
+	if v, _ := hwio.DigitalRead(led); v != hwio.High {
+		t.Errorf("led is %d after the top level, expected High", v)
+	}
+
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	event := <-s.Events
+	if event.Name != "tick" || event.Value != starlark.MakeInt(1) {
+		t.Errorf("expected tick 1, got %s %s", event.Name, event.Value)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if event = <-s.Events; event.Name != "once" {
+		t.Errorf("expected once, got %s", event.Name)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if event = <-s.Events; event.Name != "tick" || event.Value != starlark.MakeInt(2) {
+		t.Errorf("expected tick 2, got %s %s", event.Name, event.Value)
+	}
+	if v, _ := hwio.DigitalRead(led); v != hwio.Low {
+		t.Errorf("led is %d after 2 ticks, expected Low", v)
+	}
+
+	s.Close()
+	if _, ok := <-s.Events; ok {
+		t.Error("Events is still open after Close")
+	}
+	if e := s.Err(); e != nil {
+		t.Errorf("unexpected error %s", e)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	clock := hwio.NewFakeClock(time.Unix(0, 0))
+	hwio.SetClock(clock)
+	defer hwio.SetClock(nil)
+
+	if _, e := Run("bad.star", []byte(`pin_mode("gpio1", "sideways")`)); e == nil {
+		t.Error("expected an error from an unknown mode")
+	}
+	if _, e := Run("bad.star", []byte(`every("soon", print)`)); e == nil {
+		t.Error("expected an error from a bad duration")
+	}
+
+	// an error in a handler is kept for Err, and the handler is called again
+	s, e := Run("handler.star", []byte(`every("1s", lambda: digital_write("nope", HIGH))`))
+	if e != nil {
+		t.Fatalf("Run failed: %s", e)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	for s.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+	if !strings.Contains(s.Err().Error(), "handler.star") {
+		t.Errorf("expected a backtrace, got %s", s.Err())
+	}
+}
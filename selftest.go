@@ -0,0 +1,322 @@
+// A loopback self-test, for production testing of carrier boards: pins are jumpered in pairs, an output to an input,
+// and SelfTest drives each output and checks the input follows, giving a pass or fail for each pair. GPIO pairs are
+// also checked for shorts to each other, by driving one pair at a time and checking the other inputs stay low. PWM
+// pairs check the frequency arriving at the input, and analog pairs, where an output drives an analog input through
+// a divider or the like, check the reading with the output low and high.
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What a self-test pair checks.
+type SelfTestKind int
+
+const (
+	SelfTestGPIO SelfTestKind = iota
+	SelfTestPWM
+	SelfTestAnalog
+)
+
+// String representation of a self-test kind
+func (kind SelfTestKind) String() string {
+	switch kind {
+	case SelfTestGPIO:
+		return "GPIO"
+	case SelfTestPWM:
+		return "PWM"
+	case SelfTestAnalog:
+		return "analog"
+	}
+	return ""
+}
+
+// A pair of pins jumpered together, Out driving In.
+type SelfTestLink struct {
+	// The name used in the report. Defaults to the names of the pins.
+	Name string
+
+	Kind SelfTestKind
+	Out  Pin
+	In   Pin
+
+	// For PWM, the frequency to generate on Out, which must be a PWM pin, and how far the frequency measured on In
+	// can be from it, as a fraction. In must be on a GPIO module with edge detection. Default to 1kHz and 0.05.
+	Hz        float64
+	Tolerance float64
+
+	// For analog, the highest reading allowed with Out low, and the lowest with Out high.
+	LowMax  int
+	HighMin int
+
+	// How long to wait after changing Out before checking In. Defaults to 1ms.
+	Settle time.Duration
+}
+
+// The outcome of testing one pair.
+type SelfTestResult struct {
+	Link   SelfTestLink
+	Passed bool
+
+	// What was found, e.g. "stuck high", or the error that stopped the test.
+	Detail string
+}
+
+type SelfTestReport struct {
+	Results []SelfTestResult
+}
+
+// Determine if every pair passed.
+func (r *SelfTestReport) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the report as text, a line per pair, then an overall PASS or FAIL.
+func (r *SelfTestReport) String() string {
+	var b strings.Builder
+	failed := 0
+	for _, result := range r.Results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(&b, "%s %s %s: %s\n", status, result.Link.Kind, result.Link.Name, result.Detail)
+	}
+	if failed == 0 {
+		fmt.Fprintf(&b, "PASS all %d pairs\n", len(r.Results))
+	} else {
+		fmt.Fprintf(&b, "FAIL %d of %d pairs\n", failed, len(r.Results))
+	}
+	return b.String()
+}
+
+// How long a PWM pair is measured for, at most.
+const selfTestPWMWindow = 100 * time.Millisecond
+
+// Test jumpered pairs of pins. Outputs are left Low, and inputs as inputs. An error is only returned if the pairs
+// themselves are not valid; a pair that can't be tested, e.g. because a pin can't be set up, fails in the report.
+func SelfTest(links ...SelfTestLink) (*SelfTestReport, error) {
+	links = append([]SelfTestLink(nil), links...)
+	for i := range links {
+		e := checkSelfTestLink(&links[i])
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	report := &SelfTestReport{Results: make([]SelfTestResult, len(links))}
+	ready := make([]bool, len(links))
+	for i, link := range links {
+		report.Results[i].Link = link
+		e := setupSelfTestLink(link)
+		if e != nil {
+			report.Results[i].Detail = e.Error()
+			continue
+		}
+		ready[i] = true
+	}
+
+	for i, link := range links {
+		if !ready[i] {
+			continue
+		}
+		var passed bool
+		var detail string
+		switch link.Kind {
+		case SelfTestGPIO:
+			passed, detail = selfTestGPIO(links, ready, i)
+		case SelfTestPWM:
+			passed, detail = selfTestPWM(link)
+		case SelfTestAnalog:
+			passed, detail = selfTestAnalog(link)
+		}
+		report.Results[i].Passed, report.Results[i].Detail = passed, detail
+	}
+	return report, nil
+}
+
+// Check a pair is complete, filling in defaults.
+func checkSelfTestLink(link *SelfTestLink) error {
+	if link.Out == link.In {
+		return fmt.Errorf("self-test pair cannot use pin %d as both output and input", link.Out)
+	}
+	if link.Name == "" {
+		link.Name = selfTestPinName(link.Out) + "->" + selfTestPinName(link.In)
+	}
+	if link.Settle <= 0 {
+		link.Settle = time.Millisecond
+	}
+
+	switch link.Kind {
+	case SelfTestGPIO:
+	case SelfTestPWM:
+		if link.Hz <= 0 {
+			link.Hz = 1000
+		}
+		if link.Tolerance <= 0 {
+			link.Tolerance = 0.05
+		}
+	case SelfTestAnalog:
+		if link.LowMax >= link.HighMin {
+			return fmt.Errorf("self-test pair %s needs LowMax below HighMin", link.Name)
+		}
+	default:
+		return errors.New("self-test pair has an unknown kind")
+	}
+	return nil
+}
+
+func selfTestPinName(pin Pin) string {
+	if name := PinName(pin); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%d", pin)
+}
+
+// Set up the pins of a pair. The output of a PWM pair is set up when it's tested.
+func setupSelfTestLink(link SelfTestLink) error {
+	if link.Kind != SelfTestPWM {
+		e := PinModeOutputInit(link.Out, Low)
+		if e != nil {
+			return e
+		}
+	}
+	if link.Kind != SelfTestAnalog {
+		return PinMode(link.In, Input)
+	}
+	_, e := AnalogRead(link.In)
+	return e
+}
+
+// Drive a GPIO pair high then low, checking its input follows and the inputs of the other GPIO pairs stay low.
+func selfTestGPIO(links []SelfTestLink, ready []bool, i int) (bool, string) {
+	link := links[i]
+
+	e := DigitalWrite(link.Out, High)
+	if e != nil {
+		return false, e.Error()
+	}
+	time.Sleep(link.Settle)
+	high, e := DigitalRead(link.In)
+	if e != nil {
+		DigitalWrite(link.Out, Low)
+		return false, e.Error()
+	}
+
+	shorted := make([]string, 0)
+	for j, other := range links {
+		if j == i || !ready[j] || other.Kind != SelfTestGPIO {
+			continue
+		}
+		if v, e := DigitalRead(other.In); e == nil && v == High {
+			shorted = append(shorted, other.Name)
+		}
+	}
+
+	e = DigitalWrite(link.Out, Low)
+	if e != nil {
+		return false, e.Error()
+	}
+	time.Sleep(link.Settle)
+	low, e := DigitalRead(link.In)
+	if e != nil {
+		return false, e.Error()
+	}
+
+	switch {
+	case high == Low && low == Low:
+		return false, "stuck low or not connected"
+	case high == High && low == High:
+		return false, "stuck high"
+	case high == Low:
+		return false, "inverted"
+	case len(shorted) > 0:
+		return false, "shorted to " + strings.Join(shorted, ", ")
+	}
+	return true, "follows high and low"
+}
+
+// Generate PWM on a pair's output, and count the rising edges at its input.
+func selfTestPWM(link SelfTestLink) (bool, string) {
+	pwm := findPWMModule(link.Out)
+	if pwm == nil {
+		return false, fmt.Sprintf("pin %d is not a PWM pin", link.Out)
+	}
+	period := time.Duration(float64(time.Second) / link.Hz)
+	e := startPWM(pwm, link.Out, period, period/2)
+	if e != nil {
+		return false, e.Error()
+	}
+	defer pwm.EnablePin(link.Out, false)
+	time.Sleep(link.Settle)
+
+	events, e := WatchEdges(link.In, EdgeRising)
+	if e != nil {
+		return false, e.Error()
+	}
+	// measure for at least 10 periods, so one edge either way doesn't matter much
+	window := selfTestPWMWindow
+	if 10*period > window {
+		window = 10 * period
+	}
+	time.Sleep(window)
+	StopWatchingEdges(link.In)
+
+	var first, last time.Duration
+	count := 0
+	for event := range events {
+		if count == 0 {
+			first = event.Timestamp
+		}
+		last = event.Timestamp
+		count++
+	}
+	if count < 2 {
+		return false, "no signal"
+	}
+
+	measured := float64(count-1) / (last - first).Seconds()
+	detail := fmt.Sprintf("measured %.1fHz for %.1fHz", measured, link.Hz)
+	if measured < link.Hz*(1-link.Tolerance) || measured > link.Hz*(1+link.Tolerance) {
+		return false, detail
+	}
+	return true, detail
+}
+
+// Drive a pair's output low then high, checking the analog input's reading.
+func selfTestAnalog(link SelfTestLink) (bool, string) {
+	readAt := func(value int) (int, error) {
+		e := DigitalWrite(link.Out, value)
+		if e != nil {
+			return 0, e
+		}
+		time.Sleep(link.Settle)
+		return AnalogRead(link.In)
+	}
+
+	low, e := readAt(Low)
+	if e != nil {
+		return false, e.Error()
+	}
+	high, e := readAt(High)
+	DigitalWrite(link.Out, Low)
+	if e != nil {
+		return false, e.Error()
+	}
+
+	detail := fmt.Sprintf("read %d low and %d high", low, high)
+	if low > link.LowMax || high < link.HighMin {
+		return false, detail
+	}
+	return true, detail
+}
@@ -0,0 +1,38 @@
+package hwio
+
+// Interfaces for sensors of common quantities, which the drivers under devices implement, so code using a sensor
+// doesn't depend on the chip measuring it. A program reading the temperature from a TMP102 works unchanged with a
+// GY-520, or any other driver with a ReadTemperature method:
+//
+//	var thermometer hwio.Thermometer = tmp102.NewTMP102(i2c)
+//	...
+//	t, e := thermometer.ReadTemperature()
+//
+// Each method takes a fresh reading, and returns it as a measurement type, so the units are the same whatever the
+// chip reports.
+
+// A sensor measuring temperature.
+type Thermometer interface {
+	ReadTemperature() (Temperature, error)
+}
+
+// A sensor measuring relative humidity.
+type Hygrometer interface {
+	ReadHumidity() (Humidity, error)
+}
+
+// A sensor measuring air pressure.
+type Barometer interface {
+	ReadPressure() (Pressure, error)
+}
+
+// A sensor measuring acceleration along 3 axes, including that due to gravity, so one lying flat reads about 1g on
+// its z axis.
+type Accelerometer interface {
+	ReadAcceleration() (x Acceleration, y Acceleration, z Acceleration, e error)
+}
+
+// A sensor measuring the distance to an object, such as an ultrasonic or time of flight sensor.
+type DistanceSensor interface {
+	ReadDistance() (Distance, error)
+}
@@ -0,0 +1,281 @@
+// A Sequencer runs a list of timed pin actions on its own goroutine: setting pins, waiting, pulsing and repeating,
+// as for traffic lights, solenoid valves that must open in turn, or a test fixture driving a device under test.
+//
+// Each step is scheduled from the start of the sequence rather than from the end of the step before, so a step that
+// runs late, because the goroutine wasn't scheduled promptly, doesn't delay the ones after it, and a sequence that
+// repeats for hours doesn't drift. Timing is by the Go scheduler, so expect steps to be up to a millisecond or so late
+// on an idle system; for tighter timing use Pulse or a CameraTrigger.
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The actions of a sequence step.
+type SequenceAction int
+
+const (
+	// Set Pin to Value.
+	SequenceSet SequenceAction = iota
+
+	// Wait for Duration.
+	SequenceWait
+
+	// Set Pin to Value for Duration, then to the opposite level.
+	SequencePulse
+
+	// Run Steps Count times, or forever if Count is 0.
+	SequenceRepeat
+)
+
+// String representation of a sequence action
+func (action SequenceAction) String() string {
+	switch action {
+	case SequenceSet:
+		return "Set"
+	case SequenceWait:
+		return "Wait"
+	case SequencePulse:
+		return "Pulse"
+	case SequenceRepeat:
+		return "Repeat"
+	}
+	return ""
+}
+
+// A step of a sequence. The fields used depend on the action. StepSet, StepWait, StepPulse and StepRepeat make
+// steps.
+type SequenceStep struct {
+	Action   SequenceAction
+	Pin      Pin
+	Value    int
+	Duration time.Duration
+	Count    int
+	Steps    []SequenceStep
+}
+
+// A step that sets a pin to High or Low.
+func StepSet(pin Pin, value int) SequenceStep {
+	return SequenceStep{Action: SequenceSet, Pin: pin, Value: value}
+}
+
+// A step that waits.
+func StepWait(duration time.Duration) SequenceStep {
+	return SequenceStep{Action: SequenceWait, Duration: duration}
+}
+
+// A step that sets a pin to its active level, High or Low, for a time, then back.
+func StepPulse(pin Pin, active int, duration time.Duration) SequenceStep {
+	return SequenceStep{Action: SequencePulse, Pin: pin, Value: active, Duration: duration}
+}
+
+// A step that runs steps count times, or forever if count is 0.
+func StepRepeat(count int, steps ...SequenceStep) SequenceStep {
+	return SequenceStep{Action: SequenceRepeat, Count: count, Steps: steps}
+}
+
+// Returned by runSteps when the sequence is stopped.
+var errSequenceStopped = errors.New("sequence stopped")
+
+type Sequencer struct {
+	steps []SequenceStep
+
+	// guards stop, done and err
+	sync.Mutex
+	stop chan bool
+	done chan bool
+	err  error
+
+	// when the next step is due, on the sequence's own schedule, and the clock it runs on. These are only used by the
+	// sequence's goroutine.
+	next  time.Time
+	clock Clock
+}
+
+// Create a sequencer for a list of steps. The pins the steps use must already be outputs.
+func NewSequencer(steps ...SequenceStep) (*Sequencer, error) {
+	e := checkSequence(steps)
+	if e != nil {
+		return nil, e
+	}
+	return &Sequencer{steps: steps}, nil
+}
+
+// Start running the sequence on its own goroutine. It is an error to start a sequence that is running.
+func (s *Sequencer) Start() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.running() {
+		return errors.New("sequence is already running")
+	}
+	s.stop = make(chan bool)
+	s.done = make(chan bool)
+	s.err = nil
+	s.clock = clock
+	go s.run(s.stop, s.done)
+	return nil
+}
+
+// Stop the sequence, waiting for it to finish the step it is on, and return the error that stopped it earlier if
+// there was one. Pins are left as they are, including a pin in the middle of a pulse.
+func (s *Sequencer) Stop() error {
+	s.Lock()
+	if s.running() {
+		close(s.stop)
+	}
+	s.Unlock()
+	return s.Wait()
+}
+
+// Wait for the sequence to finish, returning the error from writing a pin if there was one. A sequence that repeats
+// forever only finishes when it is stopped.
+func (s *Sequencer) Wait() error {
+	s.Lock()
+	done := s.done
+	s.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+// Determine if the sequence is running.
+func (s *Sequencer) Running() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.running()
+}
+
+func (s *Sequencer) running() bool {
+	if s.done == nil {
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *Sequencer) run(stop chan bool, done chan bool) {
+	s.next = s.clock.Now()
+	e := s.runSteps(s.steps, stop)
+	if e == errSequenceStopped {
+		e = nil
+	}
+
+	s.Lock()
+	s.err = e
+	s.Unlock()
+	close(done)
+}
+
+func (s *Sequencer) runSteps(steps []SequenceStep, stop chan bool) error {
+	for _, step := range steps {
+		var e error
+		switch step.Action {
+		case SequenceSet:
+			e = DigitalWrite(step.Pin, step.Value)
+
+		case SequenceWait:
+			e = s.wait(step.Duration, stop)
+
+		case SequencePulse:
+			e = DigitalWrite(step.Pin, step.Value)
+			if e == nil {
+				e = s.wait(step.Duration, stop)
+			}
+			if e == nil {
+				e = DigitalWrite(step.Pin, Negate(step.Value))
+			}
+
+		case SequenceRepeat:
+			for i := 0; e == nil && (step.Count == 0 || i < step.Count); i++ {
+				e = s.runSteps(step.Steps, stop)
+			}
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Wait until duration after the time the last wait was due, or return early if the sequence is stopped.
+func (s *Sequencer) wait(duration time.Duration, stop chan bool) error {
+	s.next = s.next.Add(duration)
+
+	remaining := s.next.Sub(s.clock.Now())
+	if remaining <= 0 {
+		select {
+		case <-stop:
+			return errSequenceStopped
+		default:
+			return nil
+		}
+	}
+
+	timer := s.clock.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-stop:
+		return errSequenceStopped
+	}
+}
+
+// Check the steps of a sequence are valid. A sequence that repeats forever must take time, or it would never yield.
+func checkSequence(steps []SequenceStep) error {
+	for _, step := range steps {
+		switch step.Action {
+		case SequenceSet:
+		case SequenceWait, SequencePulse:
+			if step.Duration < 0 {
+				return fmt.Errorf("sequence %s step has a negative duration", step.Action)
+			}
+		case SequenceRepeat:
+			if step.Count < 0 {
+				return errors.New("sequence Repeat step has a negative count")
+			}
+			if step.Count == 0 && sequenceDuration(step.Steps) == 0 {
+				return errors.New("sequence Repeat step repeats forever without waiting")
+			}
+			e := checkSequence(step.Steps)
+			if e != nil {
+				return e
+			}
+		default:
+			return fmt.Errorf("sequence step has an unknown action %d", step.Action)
+		}
+	}
+	return nil
+}
+
+// Return how long steps take to run once, counting a step that repeats forever once.
+func sequenceDuration(steps []SequenceStep) time.Duration {
+	total := time.Duration(0)
+	for _, step := range steps {
+		switch step.Action {
+		case SequenceWait, SequencePulse:
+			total += step.Duration
+		case SequenceRepeat:
+			count := step.Count
+			if count == 0 {
+				count = 1
+			}
+			total += time.Duration(count) * sequenceDuration(step.Steps)
+		}
+	}
+	return total
+}
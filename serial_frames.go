@@ -0,0 +1,241 @@
+package hwio
+
+// Reading frames from a serial port. Rather than each application writing its own read loop to find where messages
+// start and end, ReadFrames reads the port on a goroutine and delivers complete frames on a channel. Frames end at a
+// delimiter (NMEA sentences and AT command responses end in "\r\n"), at a fixed length, or when the line has been
+// idle for a time (Modbus RTU frames are separated by 3.5 characters of silence).
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The longest frame ReadFrames builds if SerialFraming.MaxLength is not set.
+const defaultMaxFrameLength = 1024
+
+// How ReadFrames splits the bytes received into frames. At least one of Delimiter, Length and Idle must be set. If
+// more than one is set, a frame ends at whichever comes first.
+type SerialFraming struct {
+	// Bytes that end a frame, e.g. []byte("\r\n"). They are removed from the frame unless KeepDelimiter is true.
+	Delimiter     []byte
+	KeepDelimiter bool
+
+	// The length of each frame, for protocols with fixed length messages.
+	Length int
+
+	// How long the line must be idle after a byte for the frame to end. See ModbusRTUIdle.
+	Idle time.Duration
+
+	// The longest frame. When a frame reaches this length without ending, it is delivered as it is. Defaults to
+	// 1024 bytes.
+	MaxLength int
+}
+
+// A frame received by ReadFrames, with the time its last byte was read.
+type SerialFrame struct {
+	Data []byte
+	Time time.Time
+}
+
+// Serial modules whose reads can be given a deadline implement this interface. ReadFrames uses it to stop reading
+// the port promptly when it is stopped.
+type SerialDeadlineModule interface {
+	SerialModule
+
+	// Make reads waiting past t return an error. A zero t means reads wait indefinitely.
+	SetReadDeadline(t time.Time) error
+}
+
+// Delivers frames read from a serial port, returned by ReadFrames. Frames is closed when the reader is stopped, or
+// when reading the port fails, after which Err returns the reason.
+type SerialFrameReader struct {
+	Frames <-chan SerialFrame
+
+	serial  SerialModule
+	framing SerialFraming
+	frames  chan SerialFrame
+	chunks  chan serialChunk
+	stop    chan bool
+	done    chan bool
+	readers chan bool
+	err     error
+}
+
+// Bytes from one read of the port, or the error that ended reading.
+type serialChunk struct {
+	data   []byte
+	buffer *serialBuffer
+	time   time.Time
+	err    error
+}
+
+// A buffer to read the port into, pooled so each read doesn't allocate. run returns it once it has taken the bytes.
+type serialBuffer [256]byte
+
+var serialBuffers = sync.Pool{New: func() interface{} { return new(serialBuffer) }}
+
+// The time the line is idle between Modbus RTU frames at a baud rate: 3.5 characters, or a fixed 1.75ms above
+// 19200 baud, as the Modbus over serial line specification sets out.
+func ModbusRTUIdle(baud int) time.Duration {
+	if baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(35 * int64(time.Second) / int64(baud))
+}
+
+// Start reading frames from a serial port. Frames are delivered on the reader's Frames channel until Stop is called.
+// While the reader is running, the port should not be read by anything else, though it can still be written.
+func ReadFrames(serial SerialModule, framing SerialFraming) (*SerialFrameReader, error) {
+	if len(framing.Delimiter) == 0 && framing.Length <= 0 && framing.Idle <= 0 {
+		return nil, errors.New("ReadFrames needs a delimiter, length or idle time to find the end of frames")
+	}
+	if framing.MaxLength <= 0 {
+		framing.MaxLength = defaultMaxFrameLength
+	}
+
+	r := &SerialFrameReader{
+		serial:  serial,
+		framing: framing,
+		frames:  make(chan SerialFrame, 16),
+		chunks:  make(chan serialChunk, 16),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+		readers: make(chan bool),
+	}
+	r.Frames = r.frames
+	go r.read()
+	go r.run()
+	return r, nil
+}
+
+// Stop reading frames, and close the Frames channel. A frame that has only been partly received is discarded. If
+// the serial module supports read deadlines, the port is no longer read once Stop returns; otherwise the goroutine
+// reading it exits once its current read returns.
+func (r *SerialFrameReader) Stop() {
+	select {
+	case <-r.stop:
+		return
+	default:
+	}
+	close(r.stop)
+	if dm, ok := r.serial.(SerialDeadlineModule); ok && dm.SetReadDeadline(time.Now()) == nil {
+		<-r.readers
+		dm.SetReadDeadline(time.Time{})
+	}
+	<-r.done
+}
+
+// Return the error that stopped the reader, if reading the port failed. This is only set once Frames is closed.
+func (r *SerialFrameReader) Err() error {
+	return r.err
+}
+
+// Read the port, passing what is read to run.
+func (r *SerialFrameReader) read() {
+	defer close(r.readers)
+
+	for {
+		buffer := serialBuffers.Get().(*serialBuffer)
+		n, e := r.serial.Read(buffer[:])
+		chunk := serialChunk{data: buffer[:n], buffer: buffer, time: time.Now()}
+		if e != nil && !errors.Is(e, os.ErrDeadlineExceeded) {
+			chunk.err = e
+		}
+
+		select {
+		case r.chunks <- chunk:
+		case <-r.stop:
+		}
+
+		if chunk.err != nil {
+			return
+		}
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+	}
+}
+
+// Build frames from what is read, and deliver them.
+func (r *SerialFrameReader) run() {
+	defer close(r.done)
+	defer close(r.frames)
+
+	// the frame being built, which is reused, as each frame delivered is a copy
+	var frame []byte
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if r.framing.Idle > 0 {
+		timer = time.NewTimer(r.framing.Idle)
+		timer.Stop()
+	}
+
+	for {
+		select {
+		case <-r.stop:
+			return
+
+		case <-idle:
+			idle = nil
+			if len(frame) == 0 {
+				continue
+			}
+			if !r.deliver(frame, time.Now()) {
+				return
+			}
+			frame = frame[:0]
+
+		case chunk := <-r.chunks:
+			for _, b := range chunk.data {
+				frame = append(frame, b)
+				if data, ok := r.frameEnd(frame); ok {
+					if !r.deliver(data, chunk.time) {
+						return
+					}
+					frame = frame[:0]
+				}
+			}
+			serialBuffers.Put(chunk.buffer)
+			if chunk.err != nil {
+				r.err = fmt.Errorf("ReadFrames stopped reading module '%s': %s", r.serial.GetName(), chunk.err)
+				return
+			}
+			if timer != nil && len(frame) > 0 {
+				timer.Stop()
+				timer.Reset(r.framing.Idle)
+				idle = timer.C
+			}
+		}
+	}
+}
+
+// Determine if a frame is complete after a byte has been added, returning the frame to deliver if it is.
+func (r *SerialFrameReader) frameEnd(frame []byte) ([]byte, bool) {
+	f := r.framing
+	if len(f.Delimiter) > 0 && bytes.HasSuffix(frame, f.Delimiter) {
+		if f.KeepDelimiter {
+			return frame, true
+		}
+		return frame[:len(frame)-len(f.Delimiter)], true
+	}
+	if f.Length > 0 && len(frame) == f.Length {
+		return frame, true
+	}
+	return frame, len(frame) >= f.MaxLength
+}
+
+// Deliver a copy of a frame, returning false if the reader was stopped while waiting for the application to take it.
+func (r *SerialFrameReader) deliver(data []byte, t time.Time) bool {
+	select {
+	case r.frames <- SerialFrame{Data: append([]byte(nil), data...), Time: t}:
+		return true
+	case <-r.stop:
+		return false
+	}
+}
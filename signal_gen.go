@@ -0,0 +1,350 @@
+package hwio
+
+// Signal generators for exercising external circuits during bring-up: square waves, PWM frequency sweeps, stepped
+// patterns across a set of pins, and pseudo-random bitstreams for checking a link end to end.
+//
+// Square waves use a pin's PWM module when it has one. Everything else is bit-banged on GPIO pins from a goroutine
+// locked to its thread, which sleeps until just before each transition and spins for the rest, on a schedule from
+// the start of the signal so it doesn't drift. This is good to tens of microseconds on an idle system, but it keeps
+// a CPU busy at high rates, and a transition can be late when the system is loaded.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A signal being generated in the background.
+type SignalGenerator struct {
+	stop chan bool
+	done chan bool
+
+	// guards err
+	sync.Mutex
+	err error
+}
+
+// Run a generator, which returns when it's finished or stopped.
+func startSignal(generate func(g *SignalGenerator) error) *SignalGenerator {
+	g := &SignalGenerator{stop: make(chan bool), done: make(chan bool)}
+	go func() {
+		defer close(g.done)
+		e := generate(g)
+		g.Lock()
+		g.err = e
+		g.Unlock()
+	}()
+	return g
+}
+
+// Stop the signal, and wait for it to finish. Returns the error that stopped it earlier, if there was one.
+func (g *SignalGenerator) Stop() error {
+	select {
+	case <-g.stop:
+	default:
+		close(g.stop)
+	}
+	return g.Wait()
+}
+
+// Wait for the signal to finish, returning the error from writing a pin if there was one. A signal without a
+// duration or count only finishes when it is stopped.
+func (g *SignalGenerator) Wait() error {
+	<-g.done
+	g.Lock()
+	defer g.Unlock()
+	return g.err
+}
+
+// Wait until a time on the clock of MonotonicNow, returning false if the signal is stopped first.
+func (g *SignalGenerator) waitUntil(deadline time.Duration) bool {
+	if remaining := deadline - MonotonicNow(); remaining > spinThreshold {
+		timer := time.NewTimer(remaining - spinThreshold)
+		select {
+		case <-timer.C:
+		case <-g.stop:
+			timer.Stop()
+			return false
+		}
+	}
+	for MonotonicNow() < deadline {
+	}
+
+	select {
+	case <-g.stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// Generate a square wave on a pin at a frequency, High for duty (0 to 1) of each period, for a time, or until
+// stopped if duration is 0. A pin that a PWM module can drive uses hardware PWM, and is disabled at the end; any
+// other pin must be a GPIO output, and is bit-banged, ending Low.
+func GenerateSquare(pin Pin, hz float64, duty float64, duration time.Duration) (*SignalGenerator, error) {
+	if hz <= 0 {
+		return nil, errors.New("square wave needs a positive frequency")
+	}
+	if duty < 0 || duty > 1 {
+		return nil, fmt.Errorf("square wave duty must be 0 to 1, got %g", duty)
+	}
+	if duration < 0 {
+		return nil, errors.New("square wave cannot have a negative duration")
+	}
+	period := time.Duration(float64(time.Second) / hz)
+	high := time.Duration(float64(period) * duty)
+
+	if pwm := findPWMModule(pin); pwm != nil {
+		e := startPWM(pwm, pin, period, high)
+		if e != nil {
+			return nil, e
+		}
+		return startSignal(func(g *SignalGenerator) error {
+			g.waitUntil(MonotonicNow() + duration)
+			if duration == 0 {
+				<-g.stop
+			}
+			return pwm.EnablePin(pin, false)
+		}), nil
+	}
+
+	return startSignal(func(g *SignalGenerator) error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		start := MonotonicNow()
+		for t := time.Duration(0); duration == 0 || t < duration; t += period {
+			if high > 0 {
+				if e := DigitalWrite(pin, High); e != nil {
+					return e
+				}
+			}
+			if !g.waitUntil(start + t + high) {
+				break
+			}
+			if high < period {
+				if e := DigitalWrite(pin, Low); e != nil {
+					return e
+				}
+			}
+			if !g.waitUntil(start + t + period) {
+				break
+			}
+		}
+		return DigitalWrite(pin, Low)
+	}), nil
+}
+
+// A PWM frequency sweep, from one frequency to another in steps, dwelling on each.
+type PWMSweep struct {
+	FromHz float64
+	ToHz   float64
+
+	// The number of frequencies, including both ends. At least 2.
+	Steps int
+
+	// How long to stay on each frequency.
+	Dwell time.Duration
+
+	// The duty cycle, 0 to 1.
+	Duty float64
+
+	// Space the frequencies logarithmically, as for a frequency response, rather than evenly.
+	UseLog bool
+
+	// Sweep back down after reaching ToHz, and repeat, until stopped. Otherwise the pin is disabled after one sweep.
+	Repeat bool
+}
+
+// Return the frequency of each step of the sweep.
+func (s PWMSweep) Frequencies() []float64 {
+	result := make([]float64, s.Steps)
+	if s.Steps == 1 {
+		result[0] = s.FromHz
+		return result
+	}
+	for i := range result {
+		f := float64(i) / float64(s.Steps-1)
+		if s.UseLog {
+			result[i] = s.FromHz * math.Pow(s.ToHz/s.FromHz, f)
+		} else {
+			result[i] = s.FromHz + (s.ToHz-s.FromHz)*f
+		}
+	}
+	return result
+}
+
+// Sweep the frequency of a PWM pin, which must be a pin a PWM module can drive.
+func GeneratePWMSweep(pin Pin, sweep PWMSweep) (*SignalGenerator, error) {
+	if sweep.FromHz <= 0 || sweep.ToHz <= 0 {
+		return nil, errors.New("PWM sweep needs positive frequencies")
+	}
+	if sweep.Steps < 2 || sweep.Dwell <= 0 {
+		return nil, errors.New("PWM sweep needs at least 2 steps and a dwell time")
+	}
+	if sweep.Duty < 0 || sweep.Duty > 1 {
+		return nil, fmt.Errorf("PWM sweep duty must be 0 to 1, got %g", sweep.Duty)
+	}
+	pwm := findPWMModule(pin)
+	if pwm == nil {
+		return nil, fmt.Errorf("pin %d is not a PWM pin", pin)
+	}
+
+	frequencies := sweep.Frequencies()
+	if sweep.Repeat {
+		for i := len(frequencies) - 2; i > 0; i-- {
+			frequencies = append(frequencies, frequencies[i])
+		}
+	}
+
+	e := pwm.Enable()
+	if e != nil {
+		return nil, e
+	}
+	return startSignal(func(g *SignalGenerator) error {
+		start := MonotonicNow()
+		for n := 0; sweep.Repeat || n < len(frequencies); n++ {
+			period := time.Duration(float64(time.Second) / frequencies[n%len(frequencies)])
+			if e := startPWM(pwm, pin, period, time.Duration(float64(period)*sweep.Duty)); e != nil {
+				pwm.EnablePin(pin, false)
+				return e
+			}
+			if !g.waitUntil(start + time.Duration(n+1)*sweep.Dwell) {
+				break
+			}
+		}
+		return pwm.EnablePin(pin, false)
+	}), nil
+}
+
+// Write a sequence of values across a set of output pins, one every interval, as with DigitalWriteGroup, with the
+// most significant bit to the first pin. The sequence runs count times, or until stopped if count is 0, and the pins
+// are left at the last value.
+func GeneratePattern(pins PinList, values []uint32, interval time.Duration, count int) (*SignalGenerator, error) {
+	if len(values) == 0 || interval <= 0 {
+		return nil, errors.New("pattern needs values and an interval")
+	}
+	if count < 0 {
+		return nil, errors.New("pattern cannot have a negative count")
+	}
+	if len(pins) > 32 {
+		return nil, errors.New("pattern only supports up to 32 pins")
+	}
+
+	return startSignal(func(g *SignalGenerator) error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		start := MonotonicNow()
+		for n := 0; count == 0 || n < count*len(values); n++ {
+			if e := DigitalWriteGroup(pins, values[n%len(values)]); e != nil {
+				return e
+			}
+			if !g.waitUntil(start + time.Duration(n+1)*interval) {
+				break
+			}
+		}
+		return nil
+	}), nil
+}
+
+// The feedback taps of the standard pseudo-random binary sequences, from ITU-T O.150, by order.
+var prbsTaps = map[int]uint{7: 6, 9: 5, 15: 14, 23: 18, 31: 28}
+
+// A pseudo-random binary sequence generator, PRBS7, 9, 15, 23 or 31, as bit error rate testers use. A receiver
+// with its own PRBS of the same order can check a bitstream, once it has synchronised to it.
+type PRBS struct {
+	order int
+	tap   uint
+	state uint32
+}
+
+// Create a PRBS generator of an order: 7, 9, 15, 23 or 31. It starts from all ones.
+func NewPRBS(order int) (*PRBS, error) {
+	tap, ok := prbsTaps[order]
+	if !ok {
+		return nil, fmt.Errorf("PRBS order must be 7, 9, 15, 23 or 31, got %d", order)
+	}
+	return &PRBS{order: order, tap: tap, state: 1<<uint(order) - 1}, nil
+}
+
+// Return the next bit of the sequence, High or Low.
+func (p *PRBS) Next() int {
+	bit := (p.state>>uint(p.order-1) ^ p.state>>(p.tap-1)) & 1
+	p.state = (p.state<<1 | bit) & (1<<uint(p.order) - 1)
+	return int(bit)
+}
+
+// The length of the sequence before it repeats.
+func (p *PRBS) Length() int {
+	return 1<<uint(p.order) - 1
+}
+
+// Send a pseudo-random bitstream of an order out of a GPIO output, at a bit rate, for count bits, or until stopped
+// if count is 0. The pin is left Low.
+func GeneratePRBS(pin Pin, order int, bitsPerSecond float64, count int) (*SignalGenerator, error) {
+	prbs, e := NewPRBS(order)
+	if e != nil {
+		return nil, e
+	}
+	if bitsPerSecond <= 0 {
+		return nil, errors.New("PRBS needs a positive bit rate")
+	}
+	if count < 0 {
+		return nil, errors.New("PRBS cannot have a negative count")
+	}
+	bitTime := time.Duration(float64(time.Second) / bitsPerSecond)
+
+	return startSignal(func(g *SignalGenerator) error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		start := MonotonicNow()
+		for n := 0; count == 0 || n < count; n++ {
+			if e := DigitalWrite(pin, prbs.Next()); e != nil {
+				return e
+			}
+			if !g.waitUntil(start + time.Duration(n+1)*bitTime) {
+				break
+			}
+		}
+		return DigitalWrite(pin, Low)
+	}), nil
+}
+
+// Return the PWM module that can drive a pin, or nil if there isn't one.
+func findPWMModule(pin Pin) PWMModule {
+	pd := definedPins[pin]
+	if pd == nil {
+		return nil
+	}
+	modules := GetModules()
+	for _, name := range pd.modules {
+		if pwm, ok := modules[name].(PWMModule); ok {
+			return pwm
+		}
+	}
+	return nil
+}
+
+// Set a PWM pin's period and duty, and enable it. The duty is cleared first, as a module can refuse a period
+// shorter than the current duty.
+func startPWM(pwm PWMModule, pin Pin, period time.Duration, duty time.Duration) error {
+	e := pwm.Enable()
+	if e == nil {
+		e = pwm.SetDuty(pin, 0)
+	}
+	if e == nil {
+		e = pwm.SetPeriod(pin, int64(period))
+	}
+	if e == nil {
+		e = pwm.SetDuty(pin, int64(duty))
+	}
+	if e == nil {
+		e = pwm.EnablePin(pin, true)
+	}
+	return e
+}
@@ -0,0 +1,177 @@
+package hwio
+
+// Simulating individual pins on real hardware, e.g. while a sensor is disconnected or not yet fitted, so the rest of
+// the system can keep running. A simulated pin is handled in memory rather than by the GPIO or analog module: writes
+// are held, and reads return the held value, or one from a function, e.g. a ramp or a replayed recording:
+//
+//	hwio.SimulatePin(button, hwio.PinSimulation{Value: hwio.High})
+//	hwio.SimulatePin(level, hwio.PinSimulation{Read: func(elapsed time.Duration) int {
+//		return 512 + int(100*math.Sin(elapsed.Seconds()))
+//	}})
+//	...
+//	hwio.SetSimulatedValue(button, hwio.Low)  // press the button
+//
+// PinMode, PinModeConfig, DigitalWrite, DigitalRead, AnalogRead and ClosePin act on the simulation, for the pin's
+// board; nothing is done to the pin itself. Other pins are unaffected.
+//
+// Known issues:
+// - only the pin functions above are simulated; group reads and writes, edge watches, PWM and buses still use the
+//   hardware
+// - pins should be simulated before the goroutines that use them start, as the board's simulations are not guarded
+//
+// A pin set active-low by PinModeConfig holds the electrical level, so Value and SetSimulatedValue are inverted from
+// what DigitalWrite and DigitalRead see.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The behaviour of a simulated pin.
+type PinSimulation struct {
+	// The value read until another is written or set, for digital and analog reads alike
+	Value int
+
+	// If set, called for each read with the time since the simulation started, to give the value read. Values
+	// written and set are then only passed to Write.
+	Read func(elapsed time.Duration) int
+
+	// If set, called with each value written to the pin
+	Write func(value int)
+}
+
+// A simulated pin, and its state.
+type simulatedPin struct {
+	PinSimulation
+	pin   Pin
+	start time.Time
+
+	// guards Value, mode and activeLow
+	sync.Mutex
+	mode      PinIOMode
+	modeSet   bool
+	activeLow bool
+}
+
+// Simulate a pin of the default board, replacing any simulation it already has.
+func SimulatePin(pin Pin, simulation PinSimulation) error {
+	return defaultBoard.SimulatePin(pin, simulation)
+}
+
+// Stop simulating a pin of the default board, so it uses the hardware again. Its mode must be set again with
+// PinMode before it is used.
+func StopSimulatingPin(pin Pin) {
+	defaultBoard.StopSimulatingPin(pin)
+}
+
+// Set the value a simulated pin of the default board reads, as if its input had changed.
+func SetSimulatedValue(pin Pin, value int) error {
+	return defaultBoard.SetSimulatedValue(pin, value)
+}
+
+// Return the simulated pins of the default board, in order.
+func SimulatedPins() PinList {
+	return defaultBoard.SimulatedPins()
+}
+
+// Simulate a pin of the board, as SimulatePin does for the default board.
+func (b *Board) SimulatePin(pin Pin, simulation PinSimulation) error {
+	if b.Pins()[pin] == nil {
+		return fmt.Errorf("pin %d is not known on this board", pin)
+	}
+	if b.simulated == nil {
+		b.simulated = make(map[Pin]*simulatedPin)
+	}
+	b.simulated[pin] = &simulatedPin{PinSimulation: simulation, pin: pin, start: clock.Now()}
+	return nil
+}
+
+// Stop simulating a pin of the board, as StopSimulatingPin does for the default board.
+func (b *Board) StopSimulatingPin(pin Pin) {
+	delete(b.simulated, pin)
+}
+
+// Set the value a simulated pin of the board reads, as SetSimulatedValue does for the default board.
+func (b *Board) SetSimulatedValue(pin Pin, value int) error {
+	s := b.simulated[pin]
+	if s == nil {
+		return fmt.Errorf("pin %d is not simulated", pin)
+	}
+	s.Lock()
+	s.Value = value
+	s.Unlock()
+	return nil
+}
+
+// Return the simulated pins of the board, in order.
+func (b *Board) SimulatedPins() PinList {
+	pins := make(PinList, 0, len(b.simulated))
+	for pin := range b.simulated {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i] < pins[j] })
+	return pins
+}
+
+func (s *simulatedPin) setMode(mode PinIOMode, activeLow bool) {
+	s.Lock()
+	s.mode = mode
+	s.modeSet = true
+	s.activeLow = activeLow
+	s.Unlock()
+}
+
+func (s *simulatedPin) close() {
+	s.Lock()
+	s.modeSet = false
+	s.Unlock()
+}
+
+func (s *simulatedPin) write(value int) error {
+	s.Lock()
+	if s.modeSet && s.mode != Output {
+		s.Unlock()
+		return fmt.Errorf("simulated pin %d is not an output", s.pin)
+	}
+	s.Value = value
+	s.Unlock()
+
+	if s.Write != nil {
+		s.Write(value)
+	}
+	return nil
+}
+
+// Write a digital value, which is inverted if the pin was set active-low, so Value holds the electrical level.
+func (s *simulatedPin) digitalWrite(value int) error {
+	s.Lock()
+	activeLow := s.activeLow
+	s.Unlock()
+	if activeLow {
+		value = Negate(value)
+	}
+	return s.write(value)
+}
+
+// Read a digital value, inverted if the pin was set active-low.
+func (s *simulatedPin) digitalRead() int {
+	value := s.read()
+	s.Lock()
+	activeLow := s.activeLow
+	s.Unlock()
+	if activeLow {
+		value = Negate(value)
+	}
+	return value
+}
+
+func (s *simulatedPin) read() int {
+	if s.Read != nil {
+		return s.Read(clock.Now().Sub(s.start))
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.Value
+}
@@ -0,0 +1,425 @@
+package hwio
+
+// Snapshots of the hardware state visible to hwio: the GPIOs that are exported or in use, with their directions
+// and values, the PWM channels and their settings, and the I2C devices present. Two snapshots can be diffed, to
+// find what changed between a setup that worked and one that doesn't:
+//
+//	before, e := hwio.TakeSnapshot(hwio.SnapshotOptions{})
+//	e = before.Save("working.json")
+//	...
+//	before, e = hwio.LoadSnapshot("working.json")
+//	after, e := hwio.TakeSnapshot(hwio.SnapshotOptions{})
+//	for _, c := range hwio.DiffSnapshots(before, after) {
+//		fmt.Println(c)
+//	}
+//
+// The snapshot is read from sysfs and the GPIO debugfs file, not from hwio's own pin assignments, so it also shows
+// what other programs and the kernel have set up.
+//
+// Known issues:
+// - lines requested through the GPIO character device only appear if debugfs is mounted and readable, which usually
+//   needs root
+// - I2C devices without a kernel driver are only found when ProbeI2C is set, which reads a byte from each address
+//   as "i2cdetect -r" does; this can upset some devices, e.g. write-only ones
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Location of the I2C devices the kernel knows about, and of the kernel's release. These are variables so tests can
+// point them elsewhere.
+var i2cDevicesPath = "/sys/bus/i2c/devices"
+var kernelReleasePath = "/proc/sys/kernel/osrelease"
+
+// The hardware state at one time, as returned by TakeSnapshot.
+type HardwareSnapshot struct {
+	Time time.Time `json:"time"`
+
+	// The kernel release, e.g. "6.1.21-v8+", and the board's model from the device tree, if known
+	Kernel string `json:"kernel,omitempty"`
+	Model  string `json:"model,omitempty"`
+
+	// Sorted by GPIO number, PWM chip and channel, and I2C bus and address
+	GPIOs      []GPIOState      `json:"gpios"`
+	PWMs       []PWMState       `json:"pwms"`
+	I2CDevices []I2CDeviceState `json:"i2c_devices"`
+}
+
+// The state of a GPIO that is exported through sysfs or in use by a driver or program.
+type GPIOState struct {
+	// The kernel's global GPIO number
+	GPIO int `json:"gpio"`
+
+	// The line's name, and what is using it, as the debugfs file lists them, e.g. "sysfs" for exported GPIOs
+	Name     string `json:"name,omitempty"`
+	Consumer string `json:"consumer,omitempty"`
+
+	Exported  bool   `json:"exported"`
+	Direction string `json:"direction,omitempty"`
+
+	// The value, or nil if it could not be read
+	Value *int `json:"value,omitempty"`
+
+	// The edge interrupts are generated on, for exported GPIOs
+	Edge      string `json:"edge,omitempty"`
+	ActiveLow bool   `json:"active_low,omitempty"`
+}
+
+// The settings of an exported PWM channel. Times are in nanoseconds.
+type PWMState struct {
+	Chip     string `json:"chip"`
+	Channel  int    `json:"channel"`
+	Period   int    `json:"period"`
+	Duty     int    `json:"duty"`
+	Enabled  bool   `json:"enabled"`
+	Polarity string `json:"polarity,omitempty"`
+}
+
+// An I2C device present on a bus.
+type I2CDeviceState struct {
+	Bus     int `json:"bus"`
+	Address int `json:"address"`
+
+	// The name of the kernel device, e.g. "ds1307", or "" if the device was found by probing and has no driver
+	Name string `json:"name,omitempty"`
+}
+
+type SnapshotOptions struct {
+	// If true, probe each I2C bus for devices without a kernel driver. This needs access to /dev/i2c-*.
+	ProbeI2C bool
+
+	// The filesystem to read, or nil for the real one
+	Filesystem SysfsFS
+}
+
+// Take a snapshot of the hardware state.
+func TakeSnapshot(options SnapshotOptions) (*HardwareSnapshot, error) {
+	fsys := options.Filesystem
+	if fsys == nil {
+		fsys = defaultSysfs
+	}
+
+	s := &HardwareSnapshot{
+		Time:       time.Now(),
+		GPIOs:      snapshotGPIOs(fsys),
+		PWMs:       snapshotPWMs(fsys),
+		I2CDevices: snapshotI2CDevices(fsys),
+	}
+	if b, e := sysfsReadFile(fsys, kernelReleasePath); e == nil {
+		s.Kernel = strings.TrimSpace(string(b))
+	}
+	if b, e := sysfsReadFile(fsys, deviceTreePath+"/model"); e == nil {
+		s.Model = strings.TrimRight(string(b), "\x00\n")
+	}
+
+	if options.ProbeI2C {
+		found, e := probeI2CBuses(fsys)
+		if e != nil {
+			return nil, e
+		}
+		s.I2CDevices = mergeI2CDevices(s.I2CDevices, found)
+	}
+	return s, nil
+}
+
+// Read the exported GPIOs from sysfs, and those in use from debugfs.
+func snapshotGPIOs(fsys SysfsFS) []GPIOState {
+	gpios := make(map[int]*GPIOState)
+
+	if b, e := sysfsReadFile(fsys, gpioDebugfsPath); e == nil {
+		for gpio, line := range parseGPIODebugfsLines(bufio.NewScanner(bytes.NewReader(b))) {
+			g := &GPIOState{GPIO: gpio, Name: line.name, Consumer: line.consumer, Direction: line.direction,
+				ActiveLow: line.activeLow}
+			if line.value >= 0 {
+				v := line.value
+				g.Value = &v
+			}
+			gpios[gpio] = g
+		}
+	}
+
+	// sysfs is more precise than debugfs, so its values win
+	for _, dir := range sysfsMatches(fsys, gpioSysfsPath+"/gpio*") {
+		gpio, e := strconv.Atoi(strings.TrimPrefix(path.Base(dir), "gpio"))
+		if e != nil {
+			// gpiochipN
+			continue
+		}
+		g := gpios[gpio]
+		if g == nil {
+			g = &GPIOState{GPIO: gpio}
+			gpios[gpio] = g
+		}
+		g.Exported = true
+		g.Direction = snapshotAttribute(fsys, dir+"/direction", g.Direction)
+		g.Edge = snapshotAttribute(fsys, dir+"/edge", g.Edge)
+		if s := snapshotAttribute(fsys, dir+"/active_low", ""); s != "" {
+			g.ActiveLow = s == "1"
+		}
+		if v, e := strconv.Atoi(snapshotAttribute(fsys, dir+"/value", "")); e == nil {
+			g.Value = &v
+		}
+	}
+
+	result := make([]GPIOState, 0, len(gpios))
+	for _, g := range gpios {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GPIO < result[j].GPIO })
+	return result
+}
+
+// Read the exported channels of each PWM chip.
+func snapshotPWMs(fsys SysfsFS) []PWMState {
+	result := make([]PWMState, 0)
+	for _, dir := range sysfsMatches(fsys, pwmSysfsPath+"/pwmchip*/pwm*") {
+		channel, e := strconv.Atoi(strings.TrimPrefix(path.Base(dir), "pwm"))
+		if e != nil {
+			continue
+		}
+		p := PWMState{
+			Chip:     path.Base(path.Dir(dir)),
+			Channel:  channel,
+			Enabled:  snapshotAttribute(fsys, dir+"/enable", "") == "1",
+			Polarity: snapshotAttribute(fsys, dir+"/polarity", ""),
+		}
+		p.Period, _ = strconv.Atoi(snapshotAttribute(fsys, dir+"/period", ""))
+		p.Duty, _ = strconv.Atoi(snapshotAttribute(fsys, dir+"/duty_cycle", ""))
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Chip != result[j].Chip {
+			return result[i].Chip < result[j].Chip
+		}
+		return result[i].Channel < result[j].Channel
+	})
+	return result
+}
+
+// Read the I2C devices the kernel knows about, named e.g. "1-0048" for address 0x48 on bus 1.
+func snapshotI2CDevices(fsys SysfsFS) []I2CDeviceState {
+	result := make([]I2CDeviceState, 0)
+	for _, dir := range sysfsMatches(fsys, i2cDevicesPath+"/*-*") {
+		var d I2CDeviceState
+		if _, e := fmt.Sscanf(path.Base(dir), "%d-%x", &d.Bus, &d.Address); e != nil {
+			continue
+		}
+		d.Name = snapshotAttribute(fsys, dir+"/name", "")
+		result = append(result, d)
+	}
+	sortI2CDevices(result)
+	return result
+}
+
+// Return the trimmed contents of a sysfs attribute, or def if it can't be read.
+func snapshotAttribute(fsys SysfsFS, path string, def string) string {
+	b, e := sysfsReadFile(fsys, path)
+	if e != nil {
+		return def
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Probe the usual range of addresses on each I2C bus, as "i2cdetect -r" does.
+func probeI2CBuses(fsys SysfsFS) ([]I2CDeviceState, error) {
+	result := make([]I2CDeviceState, 0)
+	for _, adapter := range sysfsMatches(fsys, i2cDevicesPath+"/i2c-*") {
+		bus, e := strconv.Atoi(strings.TrimPrefix(path.Base(adapter), "i2c-"))
+		if e != nil {
+			continue
+		}
+		f, e := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", bus), os.O_RDWR, 0)
+		if e != nil {
+			return nil, fmt.Errorf("could not probe I2C bus %d: %s", bus, e)
+		}
+		for address := 0x08; address <= 0x77; address++ {
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), I2CSlave, uintptr(address))
+			if errno == syscall.EBUSY {
+				// claimed by a driver, so it is in sysfs too
+				result = append(result, I2CDeviceState{Bus: bus, Address: address})
+				continue
+			}
+			if errno != 0 {
+				continue
+			}
+			if _, e := f.Read(make([]byte, 1)); e == nil {
+				result = append(result, I2CDeviceState{Bus: bus, Address: address})
+			}
+		}
+		f.Close()
+	}
+	return result, nil
+}
+
+// Add the probed devices that the kernel doesn't know about.
+func mergeI2CDevices(known []I2CDeviceState, probed []I2CDeviceState) []I2CDeviceState {
+	seen := make(map[[2]int]bool)
+	for _, d := range known {
+		seen[[2]int{d.Bus, d.Address}] = true
+	}
+	for _, d := range probed {
+		if !seen[[2]int{d.Bus, d.Address}] {
+			known = append(known, d)
+		}
+	}
+	sortI2CDevices(known)
+	return known
+}
+
+func sortI2CDevices(devices []I2CDeviceState) {
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Bus != devices[j].Bus {
+			return devices[i].Bus < devices[j].Bus
+		}
+		return devices[i].Address < devices[j].Address
+	})
+}
+
+// Save the snapshot to a file, as JSON.
+func (s *HardwareSnapshot) Save(path string) error {
+	b, e := json.MarshalIndent(s, "", "  ")
+	if e != nil {
+		return e
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Load a snapshot saved with Save.
+func LoadSnapshot(path string) (*HardwareSnapshot, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	s := new(HardwareSnapshot)
+	if e = json.Unmarshal(b, s); e != nil {
+		return nil, fmt.Errorf("'%s' is not a hardware snapshot: %s", path, e)
+	}
+	return s, nil
+}
+
+// A difference between two snapshots.
+type SnapshotChange struct {
+	// What changed, e.g. "gpio17", "pwmchip0/pwm1", "i2c-1 0x48" or "system", and which of its fields, or "" if it
+	// appeared or disappeared
+	What  string
+	Field string
+
+	// The values in each snapshot, or "" if it was absent
+	Before string
+	After  string
+}
+
+func (c SnapshotChange) String() string {
+	switch {
+	case c.Field != "":
+		return fmt.Sprintf("%s %s: %s -> %s", c.What, c.Field, c.Before, c.After)
+	case c.Before == "":
+		return fmt.Sprintf("%s: added (%s)", c.What, c.After)
+	default:
+		return fmt.Sprintf("%s: removed (was %s)", c.What, c.Before)
+	}
+}
+
+// Return the differences between two snapshots, sorted by what changed. The snapshots' times are not compared.
+func DiffSnapshots(before *HardwareSnapshot, after *HardwareSnapshot) []SnapshotChange {
+	b, a := snapshotFields(before), snapshotFields(after)
+	changes := make([]SnapshotChange, 0)
+	for what, fields := range b {
+		if _, ok := a[what]; !ok {
+			changes = append(changes, SnapshotChange{What: what, Before: summariseFields(fields)})
+		}
+	}
+	for what, fields := range a {
+		old, ok := b[what]
+		if !ok {
+			changes = append(changes, SnapshotChange{What: what, After: summariseFields(fields)})
+			continue
+		}
+		for _, field := range unionKeys(old, fields) {
+			if old[field] != fields[field] {
+				changes = append(changes, SnapshotChange{What: what, Field: field, Before: old[field], After: fields[field]})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].What != changes[j].What {
+			return changes[i].What < changes[j].What
+		}
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// Flatten a snapshot into the fields of each thing in it, as strings, for diffing.
+func snapshotFields(s *HardwareSnapshot) map[string]map[string]string {
+	result := map[string]map[string]string{
+		"system": {"kernel": s.Kernel, "model": s.Model},
+	}
+	for _, g := range s.GPIOs {
+		value := ""
+		if g.Value != nil {
+			value = strconv.Itoa(*g.Value)
+		}
+		result[fmt.Sprintf("gpio%d", g.GPIO)] = map[string]string{
+			"name":       g.Name,
+			"consumer":   g.Consumer,
+			"exported":   strconv.FormatBool(g.Exported),
+			"direction":  g.Direction,
+			"value":      value,
+			"edge":       g.Edge,
+			"active_low": strconv.FormatBool(g.ActiveLow),
+		}
+	}
+	for _, p := range s.PWMs {
+		result[fmt.Sprintf("%s/pwm%d", p.Chip, p.Channel)] = map[string]string{
+			"period":   strconv.Itoa(p.Period),
+			"duty":     strconv.Itoa(p.Duty),
+			"enabled":  strconv.FormatBool(p.Enabled),
+			"polarity": p.Polarity,
+		}
+	}
+	for _, d := range s.I2CDevices {
+		result[fmt.Sprintf("i2c-%d 0x%02x", d.Bus, d.Address)] = map[string]string{"name": d.Name}
+	}
+	return result
+}
+
+// Describe a thing that appeared or disappeared by its non-empty fields, e.g. "direction=out value=1".
+func summariseFields(fields map[string]string) string {
+	var parts []string
+	for _, field := range unionKeys(fields, nil) {
+		if fields[field] != "" && fields[field] != "false" {
+			parts = append(parts, field+"="+fields[field])
+		}
+	}
+	if len(parts) == 0 {
+		return "present"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Return the keys of two maps, sorted.
+func unionKeys(a map[string]string, b map[string]string) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
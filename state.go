@@ -0,0 +1,135 @@
+package hwio
+
+// Saving and restoring the state of GPIO pins, so a long running program can be restarted (e.g. after an update)
+// and put its outputs back as they were. Outputs are restored with their level set together with the direction,
+// so they don't glitch to the kernel's default level on the way.
+//
+// Only pins that were set up with PinMode, PinModeConfig or PinModeGroup are saved. Pins used by other modules
+// (PWM, I2C etc.) are left to those modules.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The version of the state file format written by SaveState.
+const pinStateVersion = 1
+
+// The saved state of a pin. The pin's canonical name is saved as well as its number, so RestoreState can check the
+// state file was written for the same pin map.
+type savedPinState struct {
+	Pin   Pin    `json:"pin"`
+	Name  string `json:"name"`
+	Mode  string `json:"mode"`
+	Value int    `json:"value,omitempty"`
+}
+
+type savedState struct {
+	Version int              `json:"version"`
+	Pins    []*savedPinState `json:"pins"`
+}
+
+// Save the modes of the GPIO pins, and the levels of those that are outputs, to a file. The file is replaced
+// atomically, so a crash while saving leaves the previous state intact.
+func SaveState(path string) error {
+	gpio, e := GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	state := &savedState{Version: pinStateVersion, Pins: make([]*savedPinState, 0)}
+	for pin, a := range assignedPins {
+		if !a.modeSet || a.module.GetName() != gpio.GetName() {
+			continue
+		}
+
+		ps := &savedPinState{Pin: pin, Name: PinName(pin), Mode: a.pinIOMode.String()}
+		if a.pinIOMode == Output {
+			ps.Value, e = gpio.DigitalRead(pin)
+			if e != nil {
+				return fmt.Errorf("could not read the level of pin %d to save it: %s", pin, e)
+			}
+		}
+		state.Pins = append(state.Pins, ps)
+	}
+	sort.Slice(state.Pins, func(i, j int) bool { return state.Pins[i].Pin < state.Pins[j].Pin })
+
+	b, e := json.MarshalIndent(state, "", "  ")
+	if e != nil {
+		return e
+	}
+
+	f, e := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if e != nil {
+		return e
+	}
+	_, e = f.Write(b)
+	if e == nil {
+		e = f.Sync()
+	}
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	if e != nil {
+		os.Remove(f.Name())
+		return e
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Restore the pin state saved by SaveState. Each pin is set back to its mode, and outputs to their saved level. If
+// a pin in the file isn't in the current pin map under the same name, nothing is restored and an error is returned.
+func RestoreState(path string) error {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return e
+	}
+
+	state := &savedState{}
+	e = json.Unmarshal(b, state)
+	if e != nil {
+		return fmt.Errorf("could not parse pin state file %s: %s", path, e)
+	}
+	if state.Version != pinStateVersion {
+		return fmt.Errorf("pin state file %s has version %d, expected %d", path, state.Version, pinStateVersion)
+	}
+
+	// check everything before changing any pins
+	modes := make([]PinIOMode, len(state.Pins))
+	for i, ps := range state.Pins {
+		if name := PinName(ps.Pin); name != ps.Name {
+			return fmt.Errorf("pin %d in state file %s is '%s', but is '%s' on this board", ps.Pin, path, ps.Name, name)
+		}
+		mode, ok := parsePinIOMode(ps.Mode)
+		if !ok {
+			return fmt.Errorf("pin %d in state file %s has unknown mode '%s'", ps.Pin, path, ps.Mode)
+		}
+		modes[i] = mode
+	}
+
+	for i, ps := range state.Pins {
+		if modes[i] == Output {
+			e = PinModeOutputInit(ps.Pin, ps.Value)
+		} else {
+			e = PinMode(ps.Pin, modes[i])
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Return the PinIOMode with the given String() representation.
+func parsePinIOMode(s string) (PinIOMode, bool) {
+	for _, mode := range []PinIOMode{Input, Output, InputPullUp, InputPullDown} {
+		if mode.String() == s {
+			return mode, true
+		}
+	}
+	return Input, false
+}
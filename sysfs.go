@@ -0,0 +1,463 @@
+package hwio
+
+// The filesystem that sysfs-backed modules read and write. Modules that take one (DTGPIOModule, SysfsPWMModule and the
+// IIO, BeagleBone and Odroid analog modules) use the real filesystem unless SetFilesystem gives them another, such as a
+// FakeSysfs, so their export, direction and value handling can be tested off the board:
+//
+//	fake := hwio.NewFakeSysfs()
+//	fake.AddGPIOClass("/sys/class/gpio")
+//	gpio := hwio.NewDTGPIOModule("gpio")
+//	gpio.SetFilesystem(fake)
+//
+// Names are those of io/fs, without the leading "/", so "/sys/class/gpio/export" is "sys/class/gpio/export".
+//
+// Known issues:
+// - the LED module still uses the real filesystem
+// - a FakeSysfs only behaves like the GPIO and PWM classes for export, unexport and direction; other files just hold
+//   what was last written to them
+// - links in a FakeSysfs are only followed by EvalSymlinks, so files under a linked directory are set at the link's
+//   own path
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing/fstest"
+)
+
+// A filesystem for sysfs: an io/fs filesystem that can also write to existing files, as sysfs attributes are written.
+type SysfsFS interface {
+	fs.FS
+
+	// Replace the contents of an existing file with data, as writing an attribute does.
+	WriteFile(name string, data []byte) error
+
+	// Open an existing file to be read, or written if write is true, repeatedly, e.g. a GPIO value file, which is
+	// kept open for speed.
+	OpenFile(name string, write bool) (SysfsFile, error)
+
+	// Check an existing file can be written, or read if write is false, as access(2) does.
+	Access(name string, write bool) error
+
+	// Return the name of a file with any symbolic links resolved, as filepath.EvalSymlinks does, e.g. to find the
+	// device a pwmchip belongs to.
+	EvalSymlinks(name string) (string, error)
+}
+
+// A file opened by SysfsFS.OpenFile. Writes after a Seek to 0 replace the value, as for a sysfs attribute.
+type SysfsFile interface {
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// The filesystem modules use unless they are given another.
+var defaultSysfs SysfsFS = osSysfs{}
+
+// The real filesystem.
+type osSysfs struct{}
+
+func (osSysfs) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Open("/" + name)
+}
+
+func (osSysfs) WriteFile(name string, data []byte) error {
+	f, e := os.OpenFile("/"+name, os.O_WRONLY|os.O_TRUNC, 0666)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	_, e = f.Write(data)
+	return e
+}
+
+func (osSysfs) OpenFile(name string, write bool) (SysfsFile, error) {
+	mode := os.O_RDONLY
+	if write {
+		mode = os.O_WRONLY | os.O_TRUNC
+	}
+	return os.OpenFile("/"+name, mode, 0666)
+}
+
+func (osSysfs) Access(name string, write bool) error {
+	mode := uint32(4)
+	if write {
+		mode = 2
+	}
+	return syscall.Access("/"+name, mode)
+}
+
+func (osSysfs) EvalSymlinks(name string) (string, error) {
+	path, e := filepath.EvalSymlinks("/" + name)
+	if e != nil {
+		return "", e
+	}
+	return sysfsName(path), nil
+}
+
+// A buffer for reading an attribute that holds a short value, e.g. a GPIO value or an ADC reading.
+type sysfsBuffer [32]byte
+
+// Buffers for reading attributes, pooled so reads on hot paths don't allocate.
+var sysfsBuffers = sync.Pool{New: func() interface{} { return new(sysfsBuffer) }}
+
+// Return the io/fs name of an absolute path.
+func sysfsName(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func sysfsReadFile(fsys SysfsFS, path string) ([]byte, error) {
+	return fs.ReadFile(fsys, sysfsName(path))
+}
+
+func sysfsWriteString(fsys SysfsFS, path string, value string) error {
+	return fsys.WriteFile(sysfsName(path), []byte(value))
+}
+
+func sysfsExists(fsys SysfsFS, path string) bool {
+	_, e := fs.Stat(fsys, sysfsName(path))
+	return e == nil
+}
+
+// Return the absolute path of a file with any symbolic links resolved, or "" if it can't be resolved.
+func sysfsEvalSymlinks(fsys SysfsFS, path string) string {
+	name, e := fsys.EvalSymlinks(sysfsName(path))
+	if e != nil {
+		return ""
+	}
+	return "/" + name
+}
+
+// Return the absolute paths matching a pattern.
+func sysfsMatches(fsys SysfsFS, pattern string) []string {
+	matches, _ := fs.Glob(fsys, sysfsName(pattern))
+	for i, m := range matches {
+		matches[i] = "/" + m
+	}
+	return matches
+}
+
+// Write a value to a file of a newly exported channel, retrying while access is denied, as writeExportedFile does.
+func sysfsWriteExported(fsys SysfsFS, path string, value string) error {
+	return retryExport(func() error {
+		return sysfsWriteString(fsys, path, value)
+	})
+}
+
+// Wait until a file of a newly exported channel is writable, as waitForExport does.
+func sysfsWaitForExport(fsys SysfsFS, path string) {
+	retryExport(func() error {
+		return fsys.Access(sysfsName(path), true)
+	})
+}
+
+// An in-memory sysfs tree for tests. Files are created with SetFile, and hold what was last written to them. GPIO
+// class directories added with AddGPIOClass also export and unexport lines, and set an output's value when its
+// direction is written as "high" or "low", as the kernel does. pwmchips added with AddPWMChip export and unexport
+// channels.
+//
+// Each write and OpenFile is recorded in a journal, in order, so tests can check exactly what a module did, e.g.
+// against a golden file.
+type FakeSysfs struct {
+	// guards files, links, gpioClasses, pwmChips and journal
+	sync.Mutex
+	files       map[string]string
+	links       map[string]string
+	gpioClasses []string
+	pwmChips    []string
+	journal     []string
+}
+
+func NewFakeSysfs() *FakeSysfs {
+	return &FakeSysfs{files: make(map[string]string), links: make(map[string]string)}
+}
+
+// Create or replace a file, e.g. SetFile("/sys/bus/iio/devices/iio:device0/name", "saradc").
+func (f *FakeSysfs) SetFile(path string, content string) {
+	f.Lock()
+	defer f.Unlock()
+	f.files[sysfsName(path)] = content
+}
+
+// Return the contents of a file, and false if it doesn't exist.
+func (f *FakeSysfs) File(path string) (string, bool) {
+	f.Lock()
+	defer f.Unlock()
+	content, ok := f.files[sysfsName(path)]
+	return content, ok
+}
+
+// Remove a file, or a directory and everything in it.
+func (f *FakeSysfs) Remove(path string) {
+	f.Lock()
+	defer f.Unlock()
+	f.remove(sysfsName(path))
+}
+
+// Make a directory behave as the GPIO class, e.g. "/sys/class/gpio", creating its export and unexport files.
+func (f *FakeSysfs) AddGPIOClass(path string) {
+	f.Lock()
+	defer f.Unlock()
+	name := sysfsName(path)
+	f.gpioClasses = append(f.gpioClasses, name)
+	f.files[name+"/export"] = ""
+	f.files[name+"/unexport"] = ""
+}
+
+// Make a directory behave as a pwmchip of the PWM class, e.g. "/sys/class/pwm/pwmchip0", creating its export and
+// unexport files. Exporting a channel creates its period, duty_cycle, enable and polarity files.
+func (f *FakeSysfs) AddPWMChip(path string) {
+	f.Lock()
+	defer f.Unlock()
+	name := sysfsName(path)
+	f.pwmChips = append(f.pwmChips, name)
+	f.files[name+"/export"] = ""
+	f.files[name+"/unexport"] = ""
+}
+
+// Make a path a symbolic link to target, for EvalSymlinks, e.g. a pwmchip of the PWM class to its directory under
+// the controller's device.
+func (f *FakeSysfs) SetLink(path string, target string) {
+	f.Lock()
+	defer f.Unlock()
+	f.links[sysfsName(path)] = sysfsName(target)
+}
+
+// Return what has been done to the files since the journal was last cleared, one line per write or OpenFile, e.g.
+// `write /sys/class/gpio/export "17"` or "open /sys/class/gpio/gpio17/value w". Files changed with SetFile and
+// Remove are not recorded.
+func (f *FakeSysfs) Journal() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.journal...)
+}
+
+// Clear the journal, e.g. after setting up the state a test starts from.
+func (f *FakeSysfs) ClearJournal() {
+	f.Lock()
+	defer f.Unlock()
+	f.journal = nil
+}
+
+// Return the names of the files, in order.
+func (f *FakeSysfs) Files() []string {
+	f.Lock()
+	defer f.Unlock()
+	result := make([]string, 0, len(f.files))
+	for name := range f.files {
+		result = append(result, "/"+name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *FakeSysfs) Open(name string) (fs.File, error) {
+	f.Lock()
+	tree := make(fstest.MapFS, len(f.files))
+	for n, content := range f.files {
+		tree[n] = &fstest.MapFile{Data: []byte(content), Mode: 0644}
+	}
+	f.Unlock()
+	return tree.Open(name)
+}
+
+func (f *FakeSysfs) WriteFile(name string, data []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	value := string(data)
+	f.journal = append(f.journal, fmt.Sprintf("write /%s %s", name, strconv.Quote(value)))
+	f.files[name] = value
+	if e := f.gpioClassWrite(name, strings.TrimSpace(value)); e != nil {
+		return e
+	}
+	return f.pwmChipWrite(name, strings.TrimSpace(value))
+}
+
+func (f *FakeSysfs) OpenFile(name string, write bool) (SysfsFile, error) {
+	if e := f.Access(name, write); e != nil {
+		return nil, e
+	}
+	mode := "r"
+	if write {
+		mode = "w"
+	}
+	f.Lock()
+	f.journal = append(f.journal, fmt.Sprintf("open /%s %s", name, mode))
+	f.Unlock()
+	return &fakeSysfsFile{fs: f, name: name, write: write}, nil
+}
+
+func (f *FakeSysfs) Access(name string, write bool) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "access", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (f *FakeSysfs) EvalSymlinks(name string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	result, linked := "", false
+	for _, part := range strings.Split(name, "/") {
+		result = path.Join(result, part)
+		if target, ok := f.links[result]; ok {
+			result, linked = target, true
+		}
+	}
+	if linked {
+		return result, nil
+	}
+	for n := range f.files {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			return name, nil
+		}
+	}
+	return "", &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Remove a file or directory. The caller must hold the lock.
+func (f *FakeSysfs) remove(name string) {
+	for n := range f.files {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			delete(f.files, n)
+		}
+	}
+}
+
+// Act on a write to a file of a GPIO class directory, as the kernel would. The caller must hold the lock.
+func (f *FakeSysfs) gpioClassWrite(name string, value string) error {
+	dir, file := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	for _, class := range f.gpioClasses {
+		switch {
+		case dir == class && (file == "export" || file == "unexport"):
+			n, e := strconv.Atoi(value)
+			if e != nil {
+				return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+			}
+			line := fmt.Sprintf("%s/gpio%d", class, n)
+			_, exported := f.files[line+"/value"]
+			if file == "unexport" {
+				if !exported {
+					return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+				}
+				f.remove(line)
+				return nil
+			}
+			if exported {
+				return &fs.PathError{Op: "write", Path: name, Err: syscall.EBUSY}
+			}
+			f.files[line+"/direction"] = "in"
+			f.files[line+"/value"] = "0"
+			f.files[line+"/active_low"] = "0"
+			return nil
+
+		case path.Dir(dir) == class && file == "direction":
+			switch value {
+			case "high", "low":
+				f.files[name] = "out"
+				f.files[dir+"/value"] = map[string]string{"high": "1", "low": "0"}[value]
+			case "out":
+				f.files[dir+"/value"] = "0"
+			case "in":
+			default:
+				return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Act on a write to the export or unexport file of a pwmchip, as the kernel would. The caller must hold the lock.
+func (f *FakeSysfs) pwmChipWrite(name string, value string) error {
+	dir, file := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	for _, chip := range f.pwmChips {
+		if dir != chip || (file != "export" && file != "unexport") {
+			continue
+		}
+		n, e := strconv.Atoi(value)
+		if e != nil {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+		}
+		channel := fmt.Sprintf("%s/pwm%d", chip, n)
+		_, exported := f.files[channel+"/enable"]
+		if file == "unexport" {
+			if !exported {
+				return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+			}
+			f.remove(channel)
+			return nil
+		}
+		if exported {
+			return &fs.PathError{Op: "write", Path: name, Err: syscall.EBUSY}
+		}
+		f.files[channel+"/period"] = "0"
+		f.files[channel+"/duty_cycle"] = "0"
+		f.files[channel+"/enable"] = "0"
+		f.files[channel+"/polarity"] = "normal"
+		return nil
+	}
+	return nil
+}
+
+// A file of a FakeSysfs opened with OpenFile.
+type fakeSysfsFile struct {
+	fs    *FakeSysfs
+	name  string
+	write bool
+}
+
+func (h *fakeSysfsFile) ReadAt(b []byte, off int64) (int, error) {
+	content, ok := h.fs.File(h.name)
+	if !ok {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrNotExist}
+	}
+	if off >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(b, content[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *fakeSysfsFile) Write(b []byte) (int, error) {
+	if !h.write {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrPermission}
+	}
+	e := h.fs.WriteFile(h.name, b)
+	if e != nil {
+		return 0, e
+	}
+	return len(b), nil
+}
+
+// Seek does nothing, as each write replaces the value and reads are at an offset.
+func (h *fakeSysfsFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (h *fakeSysfsFile) Close() error {
+	return nil
+}
@@ -0,0 +1,350 @@
+// Telemetry sinks for the data logger, publishing records over MQTT to a cloud IoT service such as AWS IoT Core or
+// Azure IoT Hub, and receiving commands that set output pins. Records are held while the broker can't be reached,
+// and sent when the connection is back:
+//
+//	config, err := telemetry.AWSIoT("abc123-ats.iot.eu-west-1.amazonaws.com", "pump-1", "pump-1.crt", "pump-1.key", "AmazonRootCA1.pem")
+//	config.Outputs = map[string]hwio.Pin{"valve": valve}
+//	sink, err := telemetry.NewSink(config)
+//	logger, err := hwio.NewDataLogger(hwio.DataLoggerConfig{
+//		Channels: []hwio.LogChannel{hwio.LogAnalog("level", level)},
+//		Interval: 10 * time.Second,
+//		Sinks:    []hwio.LogSink{sink},
+//	})
+//
+// Each record is published as a JSON object with the record's time and a field for each channel, e.g.
+// {"level":512,"time":"2024-05-01T12:00:00Z"}; channels that could not be read are null. Commands are JSON objects
+// naming an output and the value to set it to, e.g. {"pin":"valve","value":1}. Only the pins in Outputs can be set.
+//
+// Current status:
+// - records are published with QoS 1 one at a time, in order; a record the broker doesn't acknowledge is sent again
+// - held records are kept in memory only, so they are lost if the program exits before the broker is back
+// - Azure IoT Hub devices authenticate with a symmetric key; X.509 devices can use a Config with TLS set instead
+
+package telemetry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cinellodev/hwio"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// How long to wait for the broker to acknowledge a record.
+	publishTimeout = 10 * time.Second
+
+	defaultBufferSize    = 10000
+	defaultRetryInterval = 10 * time.Second
+)
+
+type Config struct {
+	// The broker's URL, e.g. "ssl://abc123-ats.iot.eu-west-1.amazonaws.com:8883", or "tcp://localhost:1883" for a
+	// broker without TLS.
+	Broker   string
+	ClientID string
+
+	// The user name and password, if the broker needs them. If Credentials is set, it is called on each connection
+	// instead, for brokers whose passwords expire.
+	Username    string
+	Password    string
+	Credentials func() (username string, password string)
+
+	// The client certificate and the CAs to trust, for ssl:// brokers. If nil, the system's CAs are trusted.
+	TLS *tls.Config
+
+	// The topic records are published to.
+	Topic string
+
+	// The topic commands are received on, or "" to receive none, and the pins commands can set, by the names the
+	// commands use.
+	CommandTopic string
+	Outputs      map[string]hwio.Pin
+
+	// The most records held while the broker can't be reached. When full, the oldest are dropped. Defaults to 10000.
+	BufferSize int
+
+	// How long to wait between attempts to connect. Defaults to 10 seconds.
+	RetryInterval time.Duration
+}
+
+// A record waiting to be published, numbered so it is only removed once sent even if older records are dropped
+// meanwhile.
+type message struct {
+	seq     uint64
+	payload []byte
+}
+
+// A data logger sink publishing records over MQTT.
+type Sink struct {
+	config Config
+	client mqtt.Client
+
+	// guards queue, lastSeq, dropped and err, and is held while commands set outputs, so the program can serialise
+	// its own use of the pins with them
+	sync.Mutex
+	queue   []message
+	lastSeq uint64
+	dropped int
+	err     error
+
+	// serialises sending the queue
+	sending sync.Mutex
+
+	// the goroutines sending the queue on connection
+	running sync.WaitGroup
+}
+
+// Create a sink, and start connecting to the broker. The sink can be written to before the connection is made.
+func NewSink(config Config) (*Sink, error) {
+	if config.Broker == "" || config.Topic == "" {
+		return nil, errors.New("telemetry sink needs a broker and a topic")
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBufferSize
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRetryInterval
+	}
+
+	s := &Sink{config: config}
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetProtocolVersion(4).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(config.RetryInterval).
+		SetMaxReconnectInterval(config.RetryInterval).
+		SetOnConnectHandler(s.onConnect)
+	if config.Credentials != nil {
+		options.SetCredentialsProvider(config.Credentials)
+	}
+	if config.TLS != nil {
+		options.SetTLSConfig(config.TLS)
+	}
+	s.client = mqtt.NewClient(options)
+
+	// with connect retry, this completes once connected; the sink is usable before then
+	s.client.Connect()
+	return s, nil
+}
+
+// Subscribe to commands and send the held records, each time the client connects.
+func (s *Sink) onConnect(client mqtt.Client) {
+	if s.config.CommandTopic != "" {
+		t := client.Subscribe(s.config.CommandTopic, 1, s.onCommand)
+		if t.WaitTimeout(publishTimeout) && t.Error() != nil {
+			s.setErr(t.Error())
+		}
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.send()
+	}()
+}
+
+// A command to set an output pin.
+type command struct {
+	Pin   string `json:"pin"`
+	Value int    `json:"value"`
+}
+
+func (s *Sink) onCommand(client mqtt.Client, m mqtt.Message) {
+	var c command
+	e := json.Unmarshal(m.Payload(), &c)
+	if e != nil {
+		s.setErr(fmt.Errorf("invalid command '%s': %s", m.Payload(), e))
+		return
+	}
+	pin, ok := s.config.Outputs[c.Pin]
+	if !ok {
+		s.setErr(fmt.Errorf("command for unknown output '%s'", c.Pin))
+		return
+	}
+	if c.Value != hwio.Low && c.Value != hwio.High {
+		s.setErr(fmt.Errorf("command for output '%s' has invalid value %d", c.Pin, c.Value))
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	e = hwio.DigitalWrite(pin, c.Value)
+	if e != nil {
+		s.err = e
+	}
+}
+
+// Queue the records, and send them if the broker is connected.
+func (s *Sink) WriteRecords(names []string, records []hwio.LogRecord) error {
+	s.Lock()
+	for _, r := range records {
+		fields := map[string]interface{}{"time": r.Time.UTC().Format(time.RFC3339Nano)}
+		for i, name := range names {
+			if math.IsNaN(r.Values[i]) {
+				fields[name] = nil
+			} else {
+				fields[name] = r.Values[i]
+			}
+		}
+		payload, e := json.Marshal(fields)
+		if e != nil {
+			s.Unlock()
+			return e
+		}
+		s.lastSeq++
+		s.queue = append(s.queue, message{seq: s.lastSeq, payload: payload})
+	}
+	if n := len(s.queue) - s.config.BufferSize; n > 0 {
+		s.queue = append(s.queue[:0], s.queue[n:]...)
+		s.dropped += n
+	}
+	s.Unlock()
+
+	s.send()
+	return nil
+}
+
+// Publish the queued records in order, until the queue is empty or the broker doesn't acknowledge one.
+func (s *Sink) send() {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	for s.client.IsConnectionOpen() {
+		s.Lock()
+		if len(s.queue) == 0 {
+			s.Unlock()
+			return
+		}
+		m := s.queue[0]
+		s.Unlock()
+
+		t := s.client.Publish(s.config.Topic, 1, false, m.payload)
+		if !t.WaitTimeout(publishTimeout) {
+			return
+		}
+		if t.Error() != nil {
+			s.setErr(t.Error())
+			return
+		}
+
+		s.Lock()
+		if len(s.queue) > 0 && s.queue[0].seq == m.seq {
+			s.queue = s.queue[1:]
+		}
+		s.Unlock()
+	}
+}
+
+// Send what records can be sent, and disconnect. Returns an error if records were left unsent.
+func (s *Sink) Close() error {
+	s.send()
+	s.client.Disconnect(250)
+	s.running.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+	if len(s.queue) > 0 {
+		return fmt.Errorf("%d records could not be sent to %s", len(s.queue), s.config.Broker)
+	}
+	return nil
+}
+
+// Return the number of records waiting to be sent.
+func (s *Sink) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.queue)
+}
+
+// Return the number of records dropped because the buffer was full.
+func (s *Sink) Dropped() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.dropped
+}
+
+// Return the last error publishing a record or handling a command, or nil if there hasn't been one.
+func (s *Sink) Err() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+func (s *Sink) setErr(e error) {
+	s.Lock()
+	s.err = e
+	s.Unlock()
+}
+
+// Return a configuration for AWS IoT Core: a thing's endpoint (from "aws iot describe-endpoint"), its name, which is
+// used as the client id, and its certificate, private key and the Amazon root CA, as PEM files. Records are
+// published to hwio/<thing>/telemetry, and commands received on hwio/<thing>/commands; the thing's policy must allow
+// both.
+func AWSIoT(endpoint string, thing string, certFile string, keyFile string, caFile string) (Config, error) {
+	cert, e := tls.LoadX509KeyPair(certFile, keyFile)
+	if e != nil {
+		return Config{}, e
+	}
+	ca, e := ioutil.ReadFile(caFile)
+	if e != nil {
+		return Config{}, e
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return Config{}, fmt.Errorf("no certificates found in '%s'", caFile)
+	}
+
+	return Config{
+		Broker:       "ssl://" + endpoint + ":8883",
+		ClientID:     thing,
+		TLS:          &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots},
+		Topic:        "hwio/" + thing + "/telemetry",
+		CommandTopic: "hwio/" + thing + "/commands",
+	}, nil
+}
+
+// Return a configuration for Azure IoT Hub: the hub's host name, e.g. "myhub.azure-devices.net", a device id, and
+// the device's primary or secondary key, as shown in the portal. Each connection signs a token valid for validity.
+// Records are sent as device-to-cloud messages, and commands received as cloud-to-device messages.
+func AzureIoTHub(hub string, device string, key string, validity time.Duration) (Config, error) {
+	k, e := base64.StdEncoding.DecodeString(key)
+	if e != nil {
+		return Config{}, fmt.Errorf("device key is not base64: %s", e)
+	}
+	username := hub + "/" + device + "/?api-version=2021-04-12"
+
+	return Config{
+		Broker:   "ssl://" + hub + ":8883",
+		ClientID: device,
+		Credentials: func() (string, string) {
+			return username, azureToken(hub+"/devices/"+device, k, time.Now().Add(validity))
+		},
+		Topic:        "devices/" + device + "/messages/events/",
+		CommandTopic: "devices/" + device + "/messages/devicebound/#",
+	}, nil
+}
+
+// Return a shared access signature for a resource, signed with a key and expiring at expiry.
+func azureToken(resource string, key []byte, expiry time.Time) string {
+	sr := url.QueryEscape(resource)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sr + "\n" + se))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return "SharedAccessSignature sr=" + sr + "&sig=" + url.QueryEscape(sig) + "&se=" + se
+}
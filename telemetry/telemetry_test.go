@@ -0,0 +1,250 @@
+package telemetry
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cinellodev/hwio"
+)
+
+// A minimal MQTT 3.1.1 broker for one client, recording what it publishes and subscribes to.
+type testBroker struct {
+	listener net.Listener
+
+	sync.Mutex
+	conn       net.Conn
+	published  map[string][][]byte
+	subscribed []string
+}
+
+func newTestBroker(t *testing.T, address string) *testBroker {
+	l, e := net.Listen("tcp", address)
+	if e != nil {
+		t.Fatalf("could not listen: %s", e)
+	}
+	b := &testBroker{listener: l, published: make(map[string][][]byte)}
+	go func() {
+		for {
+			conn, e := l.Accept()
+			if e != nil {
+				return
+			}
+			b.Lock()
+			b.conn = conn
+			b.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) close() {
+	b.listener.Close()
+	b.Lock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.Unlock()
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, e := r.ReadByte()
+		if e != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			c, e := r.ReadByte()
+			if e != nil {
+				return
+			}
+			length += int(c&0x7f) * multiplier
+			multiplier *= 128
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, e = io.ReadFull(r, body); e != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			n := int(body[0])<<8 | int(body[1])
+			topic := string(body[2 : 2+n])
+			rest := body[2+n:]
+			if header&0x06 != 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.Lock()
+			b.published[topic] = append(b.published[topic], rest)
+			b.Unlock()
+		case 8: // SUBSCRIBE
+			var granted []byte
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(rest[0])<<8 | int(rest[1])
+				b.Lock()
+				b.subscribed = append(b.subscribed, string(rest[2:2+n]))
+				b.Unlock()
+				granted = append(granted, rest[2+n])
+				rest = rest[3+n:]
+			}
+			conn.Write(append([]byte{0x90, byte(2 + len(granted)), body[0], body[1]}, granted...))
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// Publish a message to the client, with QoS 0.
+func (b *testBroker) publish(topic string, payload string) {
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	b.Lock()
+	b.conn.Write(append([]byte{0x30, byte(len(body))}, body...))
+	b.Unlock()
+}
+
+func (b *testBroker) messages(topic string) [][]byte {
+	b.Lock()
+	defer b.Unlock()
+	return b.published[topic]
+}
+
+// Wait for a condition, failing the test if it isn't met in a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSink(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	defer hwio.CloseAll()
+	led, _ := hwio.GetPin("gpio1")
+	hwio.PinMode(led, hwio.Output)
+
+	// find a free port for the broker, which isn't running at first
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("could not listen: %s", e)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	sink, e := NewSink(Config{
+		Broker:        "tcp://" + address,
+		ClientID:      "test",
+		Topic:         "hwio/test/telemetry",
+		CommandTopic:  "hwio/test/commands",
+		Outputs:       map[string]hwio.Pin{"led": led},
+		BufferSize:    3,
+		RetryInterval: 50 * time.Millisecond,
+	})
+	if e != nil {
+		t.Fatalf("NewSink returned error '%s'", e)
+	}
+
+	// records are held while the broker can't be reached, dropping the oldest when the buffer is full
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var records []hwio.LogRecord
+	for i := 0; i < 4; i++ {
+		records = append(records, hwio.LogRecord{Time: start.Add(time.Duration(i) * time.Second), Values: []float64{float64(i)}})
+	}
+	sink.WriteRecords([]string{"level"}, records)
+	if sink.Pending() != 3 || sink.Dropped() != 1 {
+		t.Errorf("expected 3 records pending and 1 dropped, got %d and %d", sink.Pending(), sink.Dropped())
+	}
+
+	broker := newTestBroker(t, address)
+	defer broker.close()
+	waitFor(t, "the held records", func() bool { return len(broker.messages("hwio/test/telemetry")) == 3 })
+	for i, m := range broker.messages("hwio/test/telemetry") {
+		var fields map[string]interface{}
+		json.Unmarshal(m, &fields)
+		if fields["level"] != float64(i+1) || fields["time"] != records[i+1].Time.Format(time.RFC3339Nano) {
+			t.Errorf("unexpected record %s", m)
+		}
+	}
+	if sink.Pending() != 0 {
+		t.Errorf("expected no records pending, got %d", sink.Pending())
+	}
+
+	// records written while connected are sent straight away
+	e = sink.WriteRecords([]string{"level"}, records[:1])
+	if e != nil || len(broker.messages("hwio/test/telemetry")) != 4 {
+		t.Errorf("record was not sent while connected: %v", e)
+	}
+
+	// commands set outputs, and only outputs
+	broker.Lock()
+	subscribed := strings.Join(broker.subscribed, ",")
+	broker.Unlock()
+	if subscribed != "hwio/test/commands" {
+		t.Fatalf("unexpected subscriptions %s", subscribed)
+	}
+	broker.publish("hwio/test/commands", `{"pin":"led","value":1}`)
+	waitFor(t, "the led to be set", func() bool {
+		sink.Lock()
+		defer sink.Unlock()
+		v, _ := hwio.DigitalRead(led)
+		return v == hwio.High
+	})
+	broker.publish("hwio/test/commands", `{"pin":"pump","value":1}`)
+	waitFor(t, "an error for an unknown output", func() bool { return sink.Err() != nil })
+
+	if e = sink.Close(); e != nil {
+		t.Errorf("Close returned error '%s'", e)
+	}
+}
+
+func TestAzureIoTHub(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	config, e := AzureIoTHub("myhub.azure-devices.net", "pump-1", key, time.Hour)
+	if e != nil {
+		t.Fatalf("AzureIoTHub returned error '%s'", e)
+	}
+	if config.Broker != "ssl://myhub.azure-devices.net:8883" || config.Topic != "devices/pump-1/messages/events/" {
+		t.Errorf("unexpected configuration %+v", config)
+	}
+
+	username, password := config.Credentials()
+	if username != "myhub.azure-devices.net/pump-1/?api-version=2021-04-12" {
+		t.Errorf("unexpected user name %s", username)
+	}
+	values, e := url.ParseQuery(strings.TrimPrefix(password, "SharedAccessSignature "))
+	if e != nil || values.Get("sr") != "myhub.azure-devices.net/devices/pump-1" {
+		t.Fatalf("unexpected token %s", password)
+	}
+	mac := hmac.New(sha256.New, []byte("0123456789abcdef"))
+	mac.Write([]byte(url.QueryEscape(values.Get("sr")) + "\n" + values.Get("se")))
+	if values.Get("sig") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("token %s is not signed with the key", password)
+	}
+
+	if _, e = AzureIoTHub("myhub.azure-devices.net", "pump-1", "not base64!", time.Hour); e == nil {
+		t.Error("expected an error for a key that isn't base64")
+	}
+}
@@ -0,0 +1,217 @@
+package hwio
+
+// A software watchdog for control loops driving unattended actuators. The loop kicks the watchdog each time round;
+// if it misses for longer than the timeout, because it has hung, deadlocked or is stuck waiting on a sensor, the
+// watchdog trips: it drives the pins registered with SafeState to their safe levels, e.g. heaters and motors off,
+// and calls OnMiss.
+//
+// It can also keep the system's hardware watchdog (/dev/watchdog) fed while the loop is healthy, so that a hung
+// process, or one that has tripped with HardwareReboot set, gets the board reset.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type WatchdogConfig struct {
+	// How long the loop can go without kicking the watchdog.
+	Timeout time.Duration
+
+	// Called from the watchdog's goroutine when it trips, after the pins are set to their safe levels.
+	OnMiss func()
+
+	// The hardware watchdog device to feed, e.g. "/dev/watchdog", or "" for none. It is fed every
+	// HardwareInterval, 1 second if 0, which must be well inside the hardware watchdog's own timeout.
+	Hardware         string
+	HardwareInterval time.Duration
+
+	// If true, the hardware watchdog is no longer fed once the software watchdog trips, so the board is reset.
+	HardwareReboot bool
+}
+
+type Watchdog struct {
+	config   WatchdogConfig
+	hardware *os.File
+	kicks    chan bool
+	stop     chan bool
+	done     chan bool
+
+	// guards safe, last, tripped and err
+	sync.Mutex
+	safe    map[Pin]int
+	last    time.Duration
+	tripped bool
+	err     error
+}
+
+// Start a watchdog. It is armed straight away, so the loop must kick it within the timeout.
+func NewWatchdog(config WatchdogConfig) (*Watchdog, error) {
+	if config.Timeout <= 0 {
+		return nil, errors.New("watchdog needs a positive timeout")
+	}
+	if config.HardwareInterval <= 0 {
+		config.HardwareInterval = time.Second
+	}
+
+	w := &Watchdog{
+		config: config,
+		kicks:  make(chan bool, 1),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+		safe:   make(map[Pin]int),
+		last:   MonotonicNow(),
+	}
+	if config.Hardware != "" {
+		f, e := os.OpenFile(config.Hardware, os.O_WRONLY, 0)
+		if e != nil {
+			return nil, fmt.Errorf("could not open hardware watchdog: %s", e)
+		}
+		w.hardware = f
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Register an output pin to be set to a level when the watchdog trips.
+func (w *Watchdog) SafeState(pin Pin, value int) {
+	w.Lock()
+	defer w.Unlock()
+	w.safe[pin] = value
+}
+
+// Tell the watchdog the loop is alive. Kicks after the watchdog has tripped are ignored until Rearm is called.
+func (w *Watchdog) Kick() {
+	w.Lock()
+	w.last = MonotonicNow()
+	w.Unlock()
+}
+
+// Determine if the watchdog has tripped.
+func (w *Watchdog) Tripped() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.tripped
+}
+
+// Arm the watchdog again after it has tripped, once the loop has recovered. The pins are left at their safe levels
+// for the loop to set again.
+func (w *Watchdog) Rearm() {
+	w.Lock()
+	w.tripped = false
+	w.last = MonotonicNow()
+	w.Unlock()
+
+	select {
+	case w.kicks <- true:
+	default:
+	}
+}
+
+// Return the first error from setting a pin to its safe level or feeding the hardware watchdog, if there was one.
+func (w *Watchdog) Err() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.err
+}
+
+// Stop the watchdog, without tripping it. The hardware watchdog is disarmed, if its driver allows that.
+func (w *Watchdog) Close() error {
+	select {
+	case <-w.stop:
+		return w.Err()
+	default:
+		close(w.stop)
+	}
+	<-w.done
+
+	if w.hardware != nil {
+		// the magic close character disarms the hardware watchdog, rather than letting it reset the board
+		w.hardware.Write([]byte("V"))
+		if e := w.hardware.Close(); e != nil {
+			w.setErr(e)
+		}
+	}
+	return w.Err()
+}
+
+func (w *Watchdog) setErr(e error) {
+	w.Lock()
+	if w.err == nil {
+		w.err = e
+	}
+	w.Unlock()
+}
+
+func (w *Watchdog) run() {
+	defer close(w.done)
+
+	var feed <-chan time.Time
+	if w.hardware != nil {
+		ticker := clock.NewTicker(w.config.HardwareInterval)
+		defer ticker.Stop()
+		feed = ticker.C()
+		w.feedHardware()
+	}
+
+	timer := clock.NewTimer(w.config.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-feed:
+			if !w.config.HardwareReboot || !w.Tripped() {
+				w.feedHardware()
+			}
+			continue
+		case <-w.kicks:
+		case <-timer.C():
+		}
+
+		// wait until the timeout after the last kick, which may have moved on since the timer was set
+		w.Lock()
+		remaining := w.config.Timeout - (MonotonicNow() - w.last)
+		trip := remaining <= 0 && !w.tripped
+		if trip {
+			w.tripped = true
+		}
+		safe := make(map[Pin]int, len(w.safe))
+		for pin, value := range w.safe {
+			safe[pin] = value
+		}
+		w.Unlock()
+
+		if trip {
+			for pin, value := range safe {
+				if e := DigitalWrite(pin, value); e != nil {
+					w.setErr(e)
+				}
+			}
+			if w.config.OnMiss != nil {
+				w.config.OnMiss()
+			}
+		}
+		if remaining <= 0 {
+			// tripped; wait for Rearm
+			continue
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+		timer.Reset(remaining)
+	}
+}
+
+// Feed the hardware watchdog.
+func (w *Watchdog) feedHardware() {
+	if _, e := w.hardware.Write([]byte{0}); e != nil {
+		w.setErr(e)
+	}
+}